@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/adapter"
+	"github.com/terabiome/homonculus/internal/api/contracts"
+	"github.com/terabiome/homonculus/internal/config"
+	"github.com/terabiome/homonculus/internal/service"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/labels"
+	"github.com/terabiome/homonculus/pkg/secrets"
+	"github.com/terabiome/homonculus/pkg/workspace"
+)
+
+// benchLabel marks the VMs of a benchmark run; its value is the run ID.
+const benchLabel = "bench"
+
+// benchPollInterval is how often a benchmark checks whether its VMs acquired an address.
+const benchPollInterval = 2 * time.Second
+
+// benchPhases are the provisioning phases reported by a benchmark, in order.
+var benchPhases = []string{
+	service.PhaseDiskCreated,
+	service.PhaseISOCreated,
+	service.PhaseDefined,
+	service.PhaseStarted,
+	service.PhaseIPAcquired,
+	service.PhaseAgentReady,
+}
+
+// benchOptions configures a benchmark run.
+type benchOptions struct {
+	SpecPath    string
+	Count       int
+	Prefix      string
+	Parallelism int
+	WaitIP      time.Duration
+	JSON        bool
+}
+
+// benchReport is the result of a benchmark run.
+type benchReport struct {
+	RunID          string       `json:"run_id"`
+	Requested      int          `json:"requested"`
+	Created        int          `json:"created"`
+	Parallelism    int          `json:"parallelism"` // 0 is unbounded
+	CreateSeconds  float64      `json:"create_seconds"`
+	VMsPerMinute   float64      `json:"vms_per_minute"`
+	DeleteSeconds  float64      `json:"delete_seconds"`
+	Phases         []benchPhase `json:"phases"`
+	Boot           []benchPhase `json:"boot,omitempty"` // Time from the start until a DHCP lease and the guest agent
+	CreateError    string       `json:"create_error,omitempty"`
+	DeleteError    string       `json:"delete_error,omitempty"`
+	WithoutAddress []string     `json:"without_address,omitempty"` // VMs that did not acquire an address in time
+}
+
+// benchPhase summarizes how long the VMs took to reach a phase from the phase before it, or
+// a boot milestone from their start.
+type benchPhase struct {
+	Phase      string  `json:"phase"`
+	Count      int     `json:"count"`
+	MinSeconds float64 `json:"min_seconds"`
+	AvgSeconds float64 `json:"avg_seconds"`
+	P50Seconds float64 `json:"p50_seconds"`
+	P95Seconds float64 `json:"p95_seconds"`
+	MaxSeconds float64 `json:"max_seconds"`
+}
+
+// runBench provisions throwaway VMs from a spec, measures how long every provisioning phase
+// took, deletes the VMs again and prints the results. It works on a copy of the state file,
+// so it does not interfere with a running server, apart from the load it puts on the hosts.
+func runBench(ctx context.Context, cfg *config.Config, log *slog.Logger, secretResolver *secrets.Resolver, options benchOptions) error {
+	data, err := os.ReadFile(options.SpecPath)
+	if err != nil {
+		return fmt.Errorf("failed to read VM spec: %w", err)
+	}
+	var spec contracts.CreateVMRequest
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return fmt.Errorf("failed to parse VM spec %s: %w", options.SpecPath, err)
+	}
+
+	runID, err := newBenchRunID()
+	if err != nil {
+		return err
+	}
+	prefix := options.Prefix
+	if prefix == "" {
+		prefix = "bench-" + runID
+	}
+	spec.Name = ""
+	spec.NamePrefix = prefix
+	spec.Count = options.Count
+	spec.Labels = maps.Clone(spec.Labels)
+	if spec.Labels == nil {
+		spec.Labels = make(map[string]string)
+	}
+	spec.Labels[benchLabel] = runID
+	selector, err := labels.Parse(benchLabel + "=" + runID)
+	if err != nil {
+		return err
+	}
+
+	workspaces, err := workspace.NewRoot(cfg.WorkspaceDir)
+	if err != nil {
+		return err
+	}
+	staging, err := workspaces.New("bench-" + runID)
+	if err != nil {
+		return err
+	}
+	defer staging.Close()
+	statePath := staging.Path("state.json")
+	if err := copyStateFile(cfg.StatePath, statePath); err != nil {
+		return err
+	}
+
+	benchCfg := *cfg
+	benchCfg.StatePath = statePath
+	benchCfg.EventLogPath = staging.Path("events.jsonl")
+	benchCfg.JobLogPath = staging.Path("jobs.jsonl")
+	if options.Parallelism >= 0 {
+		benchCfg.Limits.CreateParallelism = options.Parallelism
+	}
+	vmService, err := initVMService(&benchCfg, log, secretResolver)
+	if err != nil {
+		return fmt.Errorf("failed to initialize VM service: %w", err)
+	}
+
+	// Boots are measured in the background, as by the server.
+	bootCtx, stopBootWatcher := context.WithCancel(ctx)
+	defer stopBootWatcher()
+	go service.NewBootWatcher(vmService, log).Run(bootCtx)
+
+	report := benchReport{RunID: runID, Requested: options.Count, Parallelism: benchCfg.Limits.CreateParallelism}
+	log.Info("starting benchmark",
+		slog.String("run_id", runID),
+		slog.String("prefix", prefix),
+		slog.Int("count", options.Count),
+		slog.Int("parallelism", report.Parallelism),
+	)
+
+	cluster := parameters.CreateCluster{
+		VirtualMachines: []parameters.CreateVM{adapter.NewServiceParameterAdapter().AdaptCreateVM(spec)},
+	}
+	start := time.Now()
+	if err := vmService.CreateCluster(ctx, cluster); err != nil {
+		report.CreateError = err.Error()
+	}
+	report.CreateSeconds = time.Since(start).Seconds()
+
+	// Cleanup continues after an interrupt, so no throwaway VM is left behind.
+	cleanupCtx := context.WithoutCancel(ctx)
+	vmInfos, err := waitForBenchAddresses(ctx, vmService, selector, options.WaitIP)
+	if err != nil {
+		vmInfos, err = vmService.SelectVirtualMachines(cleanupCtx, selector)
+		if err != nil {
+			return fmt.Errorf("failed to list benchmark VMs %s-*, delete them manually: %w", prefix, err)
+		}
+	}
+	report.Created = len(vmInfos)
+	if report.CreateSeconds > 0 {
+		report.VMsPerMinute = float64(report.Created) / report.CreateSeconds * 60
+	}
+	report.Phases = summarizePhases(vmInfos)
+	report.Boot = summarizeBoots(vmInfos)
+	if options.WaitIP > 0 {
+		for _, vmInfo := range vmInfos {
+			if vmInfo.IPAddress == "" {
+				report.WithoutAddress = append(report.WithoutAddress, vmInfo.Name)
+			}
+		}
+	}
+
+	deleteVMs := make([]parameters.DeleteVM, len(vmInfos))
+	for i, vmInfo := range vmInfos {
+		deleteVMs[i] = parameters.DeleteVM{Name: vmInfo.Name}
+	}
+	start = time.Now()
+	if err := vmService.DeleteCluster(cleanupCtx, deleteVMs); err != nil {
+		report.DeleteError = err.Error()
+	}
+	report.DeleteSeconds = time.Since(start).Seconds()
+
+	if options.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	printBenchReport(os.Stdout, report)
+	return nil
+}
+
+// newBenchRunID returns a short random ID for a benchmark run.
+func newBenchRunID() (string, error) {
+	id := make([]byte, 3)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate benchmark run ID: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// copyStateFile copies the state file at path to dst. A missing state file leaves dst empty.
+func copyStateFile(path, dst string) error {
+	var data []byte
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read state file %s: %w", path, err)
+		}
+	}
+	if err := os.WriteFile(dst, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write benchmark state file: %w", err)
+	}
+	return nil
+}
+
+// waitForBenchAddresses lists the VMs of a benchmark run, polling until every started VM
+// reports an IP address and its boot was measured, or waitIP elapsed. Listing records the
+// ip_acquired phase of the VMs.
+func waitForBenchAddresses(ctx context.Context, vmService *service.VMService, selector labels.Selector, waitIP time.Duration) ([]parameters.VMInfo, error) {
+	deadline := time.Now().Add(waitIP)
+	for {
+		vmInfos, err := vmService.SelectVirtualMachines(ctx, selector)
+		if err != nil {
+			return nil, err
+		}
+		waiting := slices.ContainsFunc(vmInfos, func(vmInfo parameters.VMInfo) bool {
+			return vmInfo.State == "running" && (vmInfo.IPAddress == "" || vmInfo.Boot != nil && vmInfo.Boot.Pending)
+		})
+		if !waiting || time.Now().After(deadline) {
+			return vmInfos, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(benchPollInterval):
+		}
+	}
+}
+
+// summarizePhases computes the distribution of the time every VM took to reach a phase
+// from the phase it reached before.
+func summarizePhases(vmInfos []parameters.VMInfo) []benchPhase {
+	durations := make(map[string][]float64)
+	for _, vmInfo := range vmInfos {
+		for i := 1; i < len(vmInfo.Timeline); i++ {
+			phase := vmInfo.Timeline[i]
+			durations[phase.Phase] = append(durations[phase.Phase], phase.Time.Sub(vmInfo.Timeline[i-1].Time).Seconds())
+		}
+	}
+
+	var phases []benchPhase
+	for _, name := range benchPhases {
+		if samples := durations[name]; len(samples) > 0 {
+			phases = append(phases, summarizeSamples(name, samples))
+		}
+	}
+	return phases
+}
+
+// summarizeBoots computes the distribution of the time every VM took from its last start
+// until it got a DHCP lease and until its guest agent answered.
+func summarizeBoots(vmInfos []parameters.VMInfo) []benchPhase {
+	var ipAcquired, agentReady []float64
+	for _, vmInfo := range vmInfos {
+		if vmInfo.Boot == nil {
+			continue
+		}
+		if vmInfo.Boot.IPAcquired > 0 {
+			ipAcquired = append(ipAcquired, vmInfo.Boot.IPAcquired.Seconds())
+		}
+		if vmInfo.Boot.GuestAgentReady > 0 {
+			agentReady = append(agentReady, vmInfo.Boot.GuestAgentReady.Seconds())
+		}
+	}
+
+	var milestones []benchPhase
+	if len(ipAcquired) > 0 {
+		milestones = append(milestones, summarizeSamples(service.PhaseIPAcquired, ipAcquired))
+	}
+	if len(agentReady) > 0 {
+		milestones = append(milestones, summarizeSamples(service.PhaseAgentReady, agentReady))
+	}
+	return milestones
+}
+
+// summarizeSamples computes the distribution of the durations, in seconds, of a phase.
+func summarizeSamples(name string, samples []float64) benchPhase {
+	slices.Sort(samples)
+	var sum float64
+	for _, sample := range samples {
+		sum += sample
+	}
+	return benchPhase{
+		Phase:      name,
+		Count:      len(samples),
+		MinSeconds: samples[0],
+		AvgSeconds: sum / float64(len(samples)),
+		P50Seconds: percentile(samples, 0.50),
+		P95Seconds: percentile(samples, 0.95),
+		MaxSeconds: samples[len(samples)-1],
+	}
+}
+
+// percentile returns the nearest-rank percentile of sorted samples.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(p*float64(len(sorted)) + 0.5)
+	return sorted[min(max(rank-1, 0), len(sorted)-1)]
+}
+
+// printBenchReport writes a benchmark report as a table.
+func printBenchReport(w io.Writer, report benchReport) {
+	fmt.Fprintf(w, "run %s: created %d/%d VMs in %.1fs (%.1f VMs/min, parallelism %s), deleted in %.1fs\n",
+		report.RunID, report.Created, report.Requested, report.CreateSeconds, report.VMsPerMinute,
+		parallelismString(report.Parallelism), report.DeleteSeconds)
+	if report.CreateError != "" {
+		fmt.Fprintf(w, "create error: %s\n", report.CreateError)
+	}
+	if report.DeleteError != "" {
+		fmt.Fprintf(w, "delete error: %s\n", report.DeleteError)
+	}
+	if len(report.WithoutAddress) > 0 {
+		fmt.Fprintf(w, "no address acquired: %s\n", strings.Join(report.WithoutAddress, ", "))
+	}
+	printBenchPhases(w, "phase", report.Phases)
+	printBenchPhases(w, "boot", report.Boot)
+}
+
+// printBenchPhases writes the distributions of phases as a table headed by title, if there are any.
+func printBenchPhases(w io.Writer, title string, phases []benchPhase) {
+	if len(phases) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "\n%-14s %5s %8s %8s %8s %8s %8s\n", title, "count", "min", "avg", "p50", "p95", "max")
+	for _, phase := range phases {
+		fmt.Fprintf(w, "%-14s %5d %7.2fs %7.2fs %7.2fs %7.2fs %7.2fs\n",
+			phase.Phase, phase.Count, phase.MinSeconds, phase.AvgSeconds, phase.P50Seconds, phase.P95Seconds, phase.MaxSeconds)
+	}
+}
+
+// parallelismString formats a create parallelism, where 0 is unbounded.
+func parallelismString(parallelism int) string {
+	if parallelism == 0 {
+		return "unbounded"
+	}
+	return fmt.Sprint(parallelism)
+}
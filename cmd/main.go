@@ -2,30 +2,52 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/terabiome/homonculus/internal/adapter"
 	"github.com/terabiome/homonculus/internal/api/handler"
+	"github.com/terabiome/homonculus/internal/api/middleware"
 	"github.com/terabiome/homonculus/internal/api/routes"
 	"github.com/terabiome/homonculus/internal/config"
 	"github.com/terabiome/homonculus/internal/service"
 	"github.com/terabiome/homonculus/internal/service/infrastructure/cloudinit"
 	"github.com/terabiome/homonculus/internal/service/infrastructure/disk"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/fake"
 	"github.com/terabiome/homonculus/internal/service/infrastructure/libvirt"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/netboot"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/qemu"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/internal/store"
 	"github.com/terabiome/homonculus/pkg/constants"
+	"github.com/terabiome/homonculus/pkg/executor"
+	"github.com/terabiome/homonculus/pkg/labels"
 	pkglibvirt "github.com/terabiome/homonculus/pkg/libvirt"
 	"github.com/terabiome/homonculus/pkg/logger"
+	"github.com/terabiome/homonculus/pkg/pathpolicy"
+	"github.com/terabiome/homonculus/pkg/secrets"
 	"github.com/terabiome/homonculus/pkg/telemetry"
 	"github.com/terabiome/homonculus/pkg/templator"
+	"github.com/terabiome/homonculus/pkg/workspace"
 	"github.com/urfave/cli/v2"
 )
 
+// staleWorkspaceAge is how old a workspace must be to be removed at startup as a leftover
+// of a crashed process, rather than belonging to a job still running in another process.
+const staleWorkspaceAge = 24 * time.Hour
+
+// maxEvents bounds the event log; the oldest events are dropped first.
+const maxEvents = 5000
+
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -39,7 +61,14 @@ func main() {
 		os.Exit(1)
 	}
 
-	log := logger.New(cfg.LogLevel, cfg.LogFormat)
+	secretResolver, err := newSecretResolver(cfg.Secrets)
+	if err != nil {
+		slog.Error("secrets configuration error", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	// Resolved secret values are masked in every log record.
+	log := slog.New(secrets.NewRedactingHandler(logger.New(cfg.LogLevel, cfg.LogFormat).Handler(), secretResolver))
 	log.Info("homonculus starting",
 		slog.String("log_level", cfg.LogLevel),
 		slog.String("log_format", cfg.LogFormat),
@@ -90,7 +119,81 @@ func main() {
 					},
 				},
 				Action: func(cliCtx *cli.Context) error {
-					return runServer(ctx, cfg, log, cliCtx.String("address"))
+					return runServer(ctx, cfg, log, secretResolver, tel.MetricsHandler(), cliCtx.String("address"))
+				},
+			},
+			{
+				Name:      "bench",
+				Usage:     "Provision throwaway VMs from a spec, report per-phase durations and throughput, and delete them",
+				ArgsUsage: "<vm-spec.json>",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:    "count",
+						Aliases: []string{"n"},
+						Usage:   "Number of VMs to provision",
+						Value:   5,
+					},
+					&cli.StringFlag{
+						Name:  "prefix",
+						Usage: "Name prefix of the VMs (default: bench-<run id>)",
+					},
+					&cli.IntFlag{
+						Name:  "parallelism",
+						Usage: "VMs provisioned at once, 0 for unbounded (default: limits.create_parallelism)",
+						Value: -1,
+					},
+					&cli.DurationFlag{
+						Name:  "wait-ip",
+						Usage: "How long to wait for started VMs to acquire an IP address and finish booting, 0 to not wait",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print the report as JSON",
+					},
+				},
+				Action: func(cliCtx *cli.Context) error {
+					if cliCtx.NArg() != 1 {
+						return fmt.Errorf("expected exactly one VM spec file argument")
+					}
+					return runBench(ctx, cfg, log, secretResolver, benchOptions{
+						SpecPath:    cliCtx.Args().First(),
+						Count:       cliCtx.Int("count"),
+						Prefix:      cliCtx.String("prefix"),
+						Parallelism: cliCtx.Int("parallelism"),
+						WaitIP:      cliCtx.Duration("wait-ip"),
+						JSON:        cliCtx.Bool("json"),
+					})
+				},
+			},
+			{
+				Name:  "token",
+				Usage: "Manage API bearer tokens",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "generate",
+						Usage: "Generate a random token and print it with its sha256 hash",
+						Action: func(cliCtx *cli.Context) error {
+							secret := make([]byte, 32)
+							if _, err := rand.Read(secret); err != nil {
+								return fmt.Errorf("failed to generate token: %w", err)
+							}
+							token := hex.EncodeToString(secret)
+							fmt.Printf("token:  %s\nsha256: %s\n", token, middleware.HashToken(token))
+							return nil
+						},
+					},
+					{
+						Name:      "hash",
+						Usage:     "Print the sha256 hash of a token",
+						ArgsUsage: "<token>",
+						Action: func(cliCtx *cli.Context) error {
+							if cliCtx.NArg() != 1 {
+								return fmt.Errorf("expected exactly one token argument")
+							}
+							fmt.Println(middleware.HashToken(cliCtx.Args().First()))
+							return nil
+						},
+					},
 				},
 			},
 		},
@@ -102,7 +205,7 @@ func main() {
 	}
 }
 
-func initVMService(cfg *config.Config, log *slog.Logger) (*service.VMService, error) {
+func initVMService(cfg *config.Config, log *slog.Logger, secretResolver *secrets.Resolver) (*service.VMService, error) {
 	engine := templator.NewEngine()
 
 	log.Debug("loading templates")
@@ -111,6 +214,12 @@ func initVMService(cfg *config.Config, log *slog.Logger) (*service.VMService, er
 		return nil, err
 	}
 
+	if cfg.LibvirtContainerTemplatePath != "" {
+		if err := engine.LoadTemplate(constants.TemplateLibvirtContainer, cfg.LibvirtContainerTemplatePath); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := engine.LoadTemplate(constants.TemplateCloudInitUserData, cfg.CloudInitUserDataTemplate); err != nil {
 		return nil, err
 	}
@@ -129,40 +238,254 @@ func initVMService(cfg *config.Config, log *slog.Logger) (*service.VMService, er
 
 	log.Debug("templates loaded successfully")
 
-	connManager, err := pkglibvirt.NewConnectionManager(cfg.LibvirtURI, log)
+	hosts := pkglibvirt.NewHostPool()
+	for _, hypervisor := range cfg.Hypervisors {
+		hostLog := log.With(slog.String("host", hypervisor.Name))
+
+		if cfg.Backend == config.BackendFake {
+			if err := hosts.Add(hypervisor.Name, pkglibvirt.NewOfflineConnectionManager(hypervisor.URI, fake.NewExecutor(hostLog), hostLog)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var exec executor.Executor = executor.NewLocal(hostLog)
+		if hypervisor.SSH != nil {
+			sshExec, err := executor.NewSSH(executor.SSHConfig{
+				Host:    hypervisor.SSH.Host,
+				Port:    hypervisor.SSH.Port,
+				User:    hypervisor.SSH.User,
+				KeyPath: hypervisor.SSH.KeyPath,
+			}, hostLog)
+			if err != nil {
+				return nil, fmt.Errorf("failed to initialize executor for host %s: %w", hypervisor.Name, err)
+			}
+			exec = sshExec
+		}
+		exec = executor.NewInstrumented(exec, hypervisor.Name, hostLog)
+
+		var connManager *pkglibvirt.ConnectionManager
+		if cfg.Backend == config.BackendQEMU {
+			connManager = pkglibvirt.NewOfflineConnectionManager(hypervisor.URI, exec, hostLog)
+		} else {
+			maxConnections := hypervisor.MaxConnections
+			if maxConnections == 0 {
+				maxConnections = pkglibvirt.DefaultMaxConnections
+			}
+			var err error
+			keepalive := pkglibvirt.Keepalive{Interval: cfg.LibvirtKeepaliveInterval, Count: cfg.LibvirtKeepaliveCount}
+			connManager, err = pkglibvirt.NewConnectionPool(hypervisor.URI, exec, maxConnections, keepalive, hostLog)
+			if err != nil {
+				if !cfg.AllowDegradedStart {
+					return nil, fmt.Errorf("failed to initialize connection manager for host %s: %w", hypervisor.Name, err)
+				}
+				hostLog.Warn("libvirt unreachable, starting degraded until it comes up", slog.String("error", err.Error()))
+				connManager = pkglibvirt.NewDisconnectedConnectionPool(hypervisor.URI, exec, maxConnections, keepalive, hostLog)
+			}
+		}
+		if err := hosts.Add(hypervisor.Name, connManager); err != nil {
+			return nil, err
+		}
+		log.Info("connection manager initialized",
+			slog.String("host", hypervisor.Name),
+			slog.String("uri", hypervisor.URI),
+			slog.String("executor", exec.Name()),
+		)
+	}
+
+	allowedPaths, err := pathpolicy.NewAllowList(cfg.AllowedPaths)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowed paths: %w", err)
+	}
+	if !allowedPaths.Enabled() {
+		log.Warn("no allowed_paths configured; disk, image and ISO paths are not restricted")
+	}
+
+	quotas, err := newQuotas(cfg.Quotas)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quotas: %w", err)
+	}
+
+	layout, err := service.NewPathLayout(cfg.DiskDir, cfg.DiskPathTemplate, cfg.ISODir, cfg.ISOPathTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path layout: %w", err)
+	}
+
+	keyring, err := newStateKeyring(secretResolver, cfg.StateEncryption)
+	if err != nil {
+		return nil, err
+	}
+	stateStore, err := store.Open(cfg.StatePath, keyring, log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state store: %w", err)
+	}
+	eventLog, err := store.OpenLog(cfg.EventLogPath, "events", maxEvents, keyring, log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event log: %w", err)
+	}
+	jobLog, err := store.OpenLog(cfg.JobLogPath, "jobs", 2*service.MaxJobs, keyring, log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job log: %w", err)
+	}
+
+	workspaces, err := workspace.NewRoot(cfg.WorkspaceDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize connection manager: %w", err)
+		return nil, err
+	}
+	if removed, err := workspaces.RemoveStale(staleWorkspaceAge); err != nil {
+		log.Warn("failed to remove stale workspaces", slog.String("error", err.Error()))
+	} else if removed > 0 {
+		log.Info("removed stale workspaces", slog.String("dir", workspaces.Dir()), slog.Int("count", removed))
+	}
+
+	var (
+		diskManager      service.DiskManager      = disk.NewManager(log)
+		cloudinitManager service.CloudInitManager = cloudinit.NewManager(engine, workspaces, cfg.CloudInitISOCache, log)
+		netbootManager   service.NetbootManager   = netboot.NewManager(log)
+		libvirtManager   service.LibvirtManager   = libvirt.NewManager(engine, allowedPaths, cfg.PinConflictPolicy == config.PinConflictFail, cfg.MemoryOvercommitRatio, log)
+	)
+	switch cfg.Backend {
+	case config.BackendQEMU:
+		log.Info("using the qemu backend; VMs run as plain QEMU processes without libvirtd")
+		libvirtManager = qemu.NewManager(allowedPaths, log)
+	case config.BackendFake:
+		log.Warn("using the fake backend; VMs are kept in memory and lost on restart")
+		hypervisor := fake.NewHypervisor(log)
+		diskManager, cloudinitManager, netbootManager, libvirtManager = hypervisor, hypervisor, hypervisor, hypervisor
 	}
-	log.Info("connection manager initialized", slog.String("uri", cfg.LibvirtURI))
 
 	return service.NewVMService(
-		disk.NewManager(log),
-		cloudinit.NewManager(engine, log),
-		libvirt.NewManager(engine, log),
-		connManager,
+		diskManager,
+		cloudinitManager,
+		netbootManager,
+		libvirtManager,
+		hosts,
+		stateStore,
+		eventLog,
+		jobLog,
+		secretResolver,
+		allowedPaths,
+		engine,
+		cfg.Limits.CreateParallelism,
+		cfg.QueryCacheTTL,
+		quotas,
+		service.AdmissionPolicy{
+			MaxLoadPerCPU:   cfg.Admission.MaxLoadPerCPU,
+			MinFreeMemoryMB: cfg.Admission.MinFreeMemoryMB,
+			QueueTimeout:    cfg.Admission.QueueTimeout,
+		},
+		newIPPools(cfg.IPPools),
+		layout,
 		log,
 	), nil
 }
 
+// newQuotas converts the configured resource quotas.
+func newQuotas(configs []config.QuotaConfig) ([]service.Quota, error) {
+	var quotas []service.Quota
+	for _, quotaConfig := range configs {
+		selector, err := labels.Parse(quotaConfig.Selector)
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, service.Quota{
+			Identity:    quotaConfig.Identity,
+			Selector:    selector,
+			MaxVMs:      quotaConfig.MaxVMs,
+			MaxVCPUs:    quotaConfig.MaxVCPUs,
+			MaxMemoryMB: quotaConfig.MaxMemoryMB,
+			MaxDiskGB:   quotaConfig.MaxDiskGB,
+		})
+	}
+	return quotas, nil
+}
+
+// newIPPools converts the configured IP pools.
+func newIPPools(configs []config.IPPoolConfig) []service.IPPool {
+	pools := make([]service.IPPool, len(configs))
+	for i, poolConfig := range configs {
+		pools[i] = service.IPPool{
+			Name: poolConfig.Name,
+			Pool: parameters.AddressPool{
+				Network:     poolConfig.Network,
+				Start:       poolConfig.Start,
+				End:         poolConfig.End,
+				Gateway:     poolConfig.Gateway,
+				Nameservers: poolConfig.Nameservers,
+			},
+		}
+	}
+	return pools
+}
+
 // runServer starts the HTTP API server
-func runServer(ctx context.Context, cfg *config.Config, log *slog.Logger, address string) error {
+func runServer(ctx context.Context, cfg *config.Config, log *slog.Logger, secretResolver *secrets.Resolver, metrics http.Handler, address string) error {
 	log.Info("initializing HTTP server", slog.String("address", address))
 
 	// Initialize VM service
-	vmService, err := initVMService(cfg, log)
+	vmService, err := initVMService(cfg, log, secretResolver)
 	if err != nil {
 		return fmt.Errorf("failed to initialize VM service: %w", err)
 	}
 
+	// Hosts unreachable at startup are reported by /readyz right away, not only after the first health check.
+	vmService.CheckConnections(ctx)
+
+	report, err := vmService.RecoverOperations(ctx)
+	if len(report.Resumed) > 0 || len(report.RolledBack) > 0 || len(report.Failed) > 0 {
+		log.Info("recovered interrupted VM creations",
+			slog.Any("resumed", report.Resumed),
+			slog.Any("rolled_back", report.RolledBack),
+			slog.Any("failed", report.Failed),
+		)
+	}
+	if err != nil {
+		log.Error("startup recovery incomplete", slog.String("error", err.Error()))
+	}
+
+	if cfg.ReconcileEnabled {
+		go service.NewReconciler(vmService, cfg.ReconcileInterval, log).Run(ctx)
+	}
+	if cfg.GCEnabled {
+		go service.NewGarbageCollector(vmService, cfg.GCInterval, cfg.GCGracePeriod, log).Run(ctx)
+	}
+	go service.NewScheduleRunner(vmService, log).Run(ctx)
+	go service.NewReaper(vmService, cfg.ExpiryCheckInterval, log).Run(ctx)
+	go service.NewEventWatcher(vmService, log).Run(ctx)
+	go service.NewHealthMonitor(vmService, cfg.HealthCheckInterval, log).Run(ctx)
+	go service.NewBootWatcher(vmService, log).Run(ctx)
+
 	spAdapter := adapter.NewServiceParameterAdapter()
 
 	// Initialize handlers
 	vmHandler := handler.NewVirtualMachine(vmService, log, spAdapter)
-	k3sHandler := handler.NewK3s(log)
-	systemHandler := handler.NewSystem(log)
+	k3sHandler := handler.NewK3s(vmService, log, secretResolver)
+	systemHandler := handler.NewSystem(vmService, log, spAdapter)
+	integrationHandler := handler.NewIntegration(vmService, log, spAdapter)
+
+	authenticator, err := newAuthenticator(cfg.Auth, log)
+	if err != nil {
+		return fmt.Errorf("failed to initialize authentication: %w", err)
+	}
+	if authenticator == nil {
+		log.Warn("API authentication disabled")
+	}
+
+	inFlight := middleware.NewInFlight()
+	guards := routes.Guards{Authenticator: authenticator, InFlight: inFlight}
+	if cfg.Limits.RequestsPerSecond > 0 {
+		guards.RateLimiter = middleware.NewRateLimiter(cfg.Limits.RequestsPerSecond, cfg.Limits.Burst, log)
+	}
+	if cfg.Limits.MaxConcurrentOperations > 0 {
+		guards.Operations = middleware.NewOperationLimiter(cfg.Limits.MaxConcurrentOperations, log)
+	}
 
 	// Setup router
-	router := routes.SetupMux(vmHandler, k3sHandler, systemHandler)
+	router := routes.SetupMux(vmHandler, k3sHandler, systemHandler, integrationHandler, guards, metrics)
+
+	// Requests outlive the shutdown signal, so running operations can finish while the server drains.
+	requestCtx, cancelRequests := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelRequests()
 
 	// Create HTTP server
 	server := &http.Server{
@@ -171,13 +494,33 @@ func runServer(ctx context.Context, cfg *config.Config, log *slog.Logger, addres
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		BaseContext:  func(net.Listener) context.Context { return requestCtx },
+	}
+
+	tlsConfig, err := buildTLSConfig(cfg, log)
+	if err != nil {
+		return fmt.Errorf("failed to initialize TLS: %w", err)
+	}
+	server.TLSConfig = tlsConfig
+	if tlsConfig == nil && authenticator != nil {
+		log.Warn("API authentication enabled without TLS; bearer tokens travel in cleartext")
 	}
 
 	// Start server in a goroutine
 	serverErrChan := make(chan error, 1)
 	go func() {
-		log.Info("HTTP server starting", slog.String("address", address))
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Info("HTTP server starting",
+			slog.String("address", address),
+			slog.Bool("tls", tlsConfig != nil),
+		)
+		var err error
+		if tlsConfig != nil {
+			// Certificates come from server.TLSConfig.
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			serverErrChan <- fmt.Errorf("server error: %w", err)
 		}
 	}()
@@ -187,13 +530,115 @@ func runServer(ctx context.Context, cfg *config.Config, log *slog.Logger, addres
 	case err := <-serverErrChan:
 		return err
 	case <-ctx.Done():
-		log.Info("shutting down HTTP server")
-		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer shutdownCancel()
-		if err := server.Shutdown(shutdownCtx); err != nil {
-			return fmt.Errorf("server shutdown error: %w", err)
-		}
-		log.Info("HTTP server stopped")
-		return nil
+		log.Info("shutting down HTTP server",
+			slog.Int64("in_flight_operations", inFlight.Count()),
+			slog.Int("running_jobs", vmService.RunningJobs()),
+			slog.Duration("drain_timeout", cfg.ShutdownTimeout),
+		)
+		drainCtx, drainCancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer drainCancel()
+		// Jobs outlive the requests that started them, so they are drained separately.
+		if err := server.Shutdown(drainCtx); err == nil {
+			if err := vmService.WaitForJobs(drainCtx); err == nil {
+				log.Info("HTTP server stopped")
+				return nil
+			}
+		}
+
+		// Cancelled operations roll back their partial work, so give them the time that takes.
+		log.Warn("drain timeout reached, cancelling in-flight operations",
+			slog.Int64("in_flight_operations", inFlight.Count()),
+			slog.Int("running_jobs", vmService.RunningJobs()),
+		)
+		cancelRequests()
+		vmService.CancelJobs()
+		cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), service.RollbackTimeout)
+		defer cleanupCancel()
+		if err := inFlight.Wait(cleanupCtx); err != nil {
+			log.Error("in-flight operations did not finish cleaning up",
+				slog.Int64("in_flight_operations", inFlight.Count()),
+			)
+		}
+		if err := vmService.WaitForJobs(cleanupCtx); err != nil {
+			log.Error("jobs did not finish cleaning up",
+				slog.Int("running_jobs", vmService.RunningJobs()),
+			)
+		}
+		server.Close()
+		return fmt.Errorf("server shutdown: drain timeout of %s exceeded", cfg.ShutdownTimeout)
+	}
+}
+
+// newAuthenticator builds the API authenticator from config, or returns nil when authentication is disabled.
+func newAuthenticator(cfg config.AuthConfig, log *slog.Logger) (*middleware.Authenticator, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	var tokens []middleware.Token
+	for _, token := range cfg.Tokens {
+		tokens = append(tokens, middleware.Token{Name: token.Name, SHA256: token.SHA256, Role: middleware.Role(token.Role), Namespace: token.Namespace})
+	}
+	if cfg.TokenFile != "" {
+		fileTokens, err := middleware.LoadTokenFile(cfg.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, fileTokens...)
+	}
+
+	certificateRoles := make(map[string]middleware.Role, len(cfg.CertificateRoles))
+	for commonName, role := range cfg.CertificateRoles {
+		certificateRoles[commonName] = middleware.Role(role)
+	}
+
+	log.Info("API authentication enabled",
+		slog.Int("tokens", len(tokens)),
+		slog.Bool("client_certificates", cfg.ClientCAPath != ""),
+		slog.Bool("anonymous_read", cfg.AnonymousRead),
+		slog.String("default_role", cfg.DefaultRole),
+	)
+	return middleware.NewAuthenticator(middleware.Options{
+		Tokens:           tokens,
+		CertificateRoles: certificateRoles,
+		DefaultRole:      middleware.Role(cfg.DefaultRole),
+		AnonymousRead:    cfg.AnonymousRead,
+	}, log)
+}
+
+// newStateKeyring resolves the state encryption keys. Without keys the state store is not encrypted.
+func newStateKeyring(secretResolver *secrets.Resolver, cfg config.StateEncryptionConfig) (*store.Keyring, error) {
+	keys := make([]store.Key, len(cfg.Keys))
+	for i, key := range cfg.Keys {
+		passphrase, err := secretResolver.Resolve(context.Background(), key.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve state encryption key %s: %w", key.ID, err)
+		}
+		keys[i] = store.Key{ID: key.ID, Passphrase: passphrase}
+	}
+	keyring, err := store.NewKeyring(keys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state encryption keys: %w", err)
+	}
+	return keyring, nil
+}
+
+// newSecretResolver creates the resolver for secret:// references from the configured backend.
+func newSecretResolver(cfg config.SecretsConfig) (*secrets.Resolver, error) {
+	switch cfg.Backend {
+	case "file":
+		return secrets.NewResolver(secrets.FileProvider{Dir: cfg.Dir}), nil
+	case "vault":
+		token := cfg.Vault.Token
+		if cfg.Vault.TokenFile != "" {
+			data, err := os.ReadFile(cfg.Vault.TokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read vault token file: %w", err)
+			}
+			token = strings.TrimSpace(string(data))
+		}
+		return secrets.NewResolver(secrets.NewVaultProvider(cfg.Vault.Address, token, cfg.Vault.Mount)), nil
+	default:
+		return secrets.NewResolver(secrets.EnvProvider{Prefix: cfg.EnvPrefix}), nil
 	}
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/config"
+	"github.com/terabiome/homonculus/pkg/certs"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// selfSignedValidity is how long a generated self-signed certificate stays valid.
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// buildTLSConfig returns the server TLS config, or nil when the API is served over plain HTTP.
+func buildTLSConfig(cfg *config.Config, log *slog.Logger) (*tls.Config, error) {
+	if !cfg.TLS.Enabled() {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.TLS.MinVersion == "1.3" {
+		tlsConfig.MinVersion = tls.VersionTLS13
+	}
+
+	switch {
+	case cfg.TLS.CertPath != "":
+		certificate, err := tls.LoadX509KeyPair(cfg.TLS.CertPath, cfg.TLS.KeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+		log.Info("serving TLS certificate from file", slog.String("cert_path", cfg.TLS.CertPath))
+
+	case cfg.TLS.SelfSigned:
+		hosts := cfg.TLS.SelfSignedHosts
+		if len(hosts) == 0 {
+			hosts = []string{"localhost", "127.0.0.1", "::1"}
+			if hostname, err := os.Hostname(); err == nil {
+				hosts = append([]string{hostname}, hosts...)
+			}
+		}
+		certificate, err := certs.GenerateSelfSigned(hosts, selfSignedValidity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate self-signed certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+		fingerprint := sha256.Sum256(certificate.Leaf.Raw)
+		log.Warn("serving self-signed TLS certificate",
+			slog.Any("hosts", hosts),
+			slog.String("sha256_fingerprint", hex.EncodeToString(fingerprint[:])),
+		)
+
+	default:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.TLS.ACME.Domains...),
+			Cache:      autocert.DirCache(cfg.TLS.ACME.CacheDir),
+			Email:      cfg.TLS.ACME.Email,
+		}
+		if cfg.TLS.ACME.DirectoryURL != "" {
+			manager.Client = &acme.Client{DirectoryURL: cfg.TLS.ACME.DirectoryURL}
+		}
+		// Challenges are answered over TLS-ALPN-01 on the API listener itself.
+		tlsConfig.GetCertificate = manager.GetCertificate
+		tlsConfig.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+		log.Info("serving ACME TLS certificates",
+			slog.Any("domains", cfg.TLS.ACME.Domains),
+			slog.String("cache_dir", cfg.TLS.ACME.CacheDir),
+		)
+	}
+
+	if cfg.Auth.ClientCAPath != "" {
+		pem, err := os.ReadFile(cfg.Auth.ClientCAPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA %s", cfg.Auth.ClientCAPath)
+		}
+		// Client certificates are optional so token-authenticated callers can share the listener.
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
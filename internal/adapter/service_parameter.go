@@ -1,8 +1,19 @@
 package adapter
 
 import (
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/terabiome/homonculus/internal/api/contracts"
+	"github.com/terabiome/homonculus/internal/service"
 	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/constants"
+	"github.com/terabiome/homonculus/pkg/executor/qemuimg"
+	"github.com/terabiome/homonculus/pkg/units"
 )
 
 type ServiceParameterAdapter struct{}
@@ -12,12 +23,76 @@ func NewServiceParameterAdapter() *ServiceParameterAdapter {
 	return &spAdapter
 }
 
-func (spAdapter ServiceParameterAdapter) AdaptCreateCluster(req contracts.CreateClusterRequest) []parameters.CreateVM {
+func (spAdapter ServiceParameterAdapter) AdaptCreateCluster(req contracts.CreateClusterRequest) parameters.CreateCluster {
 	params := make([]parameters.CreateVM, len(req.VirtualMachines))
 	for i, vm := range req.VirtualMachines {
 		params[i] = spAdapter.AdaptCreateVM(vm)
 	}
-	return params
+	return parameters.CreateCluster{
+		Name:            req.Name,
+		SSHKeys:         req.SSHKeys,
+		VirtualMachines: params,
+	}
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptCreateFleet(req contracts.CreateFleetRequest) parameters.CreateFleet {
+	var pool *parameters.AddressPool
+	if req.AddressPool != nil {
+		pool = &parameters.AddressPool{
+			Network:     req.AddressPool.Network,
+			Start:       req.AddressPool.Start,
+			End:         req.AddressPool.End,
+			Gateway:     req.AddressPool.Gateway,
+			Nameservers: req.AddressPool.Nameservers,
+		}
+	}
+	return parameters.CreateFleet{
+		Name:        req.Name,
+		NamePrefix:  req.NamePrefix,
+		Count:       req.Count,
+		SSHKeys:     req.SSHKeys,
+		AddressPool: pool,
+		Template:    spAdapter.AdaptCreateVM(req.Template),
+	}
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptFleetMembersToAPI(vms []parameters.CreateVM) []contracts.FleetMember {
+	result := make([]contracts.FleetMember, len(vms))
+	for i, vm := range vms {
+		result[i] = contracts.FleetMember{
+			Name:       vm.Name,
+			MACAddress: vm.MACAddress,
+		}
+		if vm.Network != nil {
+			result[i].IPv4Address = vm.Network.IPv4Address
+		}
+	}
+	return result
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptIPPoolsToAPI(pools []parameters.IPPoolUsage) []contracts.IPPool {
+	result := make([]contracts.IPPool, len(pools))
+	for i, pool := range pools {
+		allocations := make([]contracts.AddressAllocation, len(pool.Allocations))
+		for j, allocation := range pool.Allocations {
+			allocations[j] = contracts.AddressAllocation{
+				VM:      allocation.VM,
+				Address: allocation.Address,
+			}
+		}
+		result[i] = contracts.IPPool{
+			Name: pool.Name,
+			Pool: contracts.AddressPool{
+				Network:     pool.Pool.Network,
+				Start:       pool.Pool.Start,
+				End:         pool.Pool.End,
+				Gateway:     pool.Pool.Gateway,
+				Nameservers: pool.Pool.Nameservers,
+			},
+			Allocations: allocations,
+		}
+	}
+	return result
 }
 
 func (spAdapter ServiceParameterAdapter) AdaptCreateVM(vm contracts.CreateVMRequest) parameters.CreateVM {
@@ -26,8 +101,11 @@ func (spAdapter ServiceParameterAdapter) AdaptCreateVM(vm contracts.CreateVMRequ
 	// Convert tuning configuration if present
 	if vm.Tuning != nil {
 		tuning = &parameters.VMTuning{
+			AutoPin:        vm.Tuning.AutoPin,
 			VCPUPins:       vm.Tuning.VCPUPins,
 			EmulatorCPUSet: vm.Tuning.EmulatorCPUSet,
+			IOThreads:      vm.Tuning.IOThreads,
+			IOThreadPins:   vm.Tuning.IOThreadPins,
 		}
 
 		// Convert NUMA memory if present
@@ -37,15 +115,44 @@ func (spAdapter ServiceParameterAdapter) AdaptCreateVM(vm contracts.CreateVMRequ
 				Mode:    vm.Tuning.NUMAMemory.Mode,
 			}
 		}
+
+		// Convert hugepages if present
+		if vm.Tuning.Hugepages != nil {
+			tuning.Hugepages = &parameters.Hugepages{
+				PageSize: vm.Tuning.Hugepages.PageSize,
+				Nodeset:  vm.Tuning.Hugepages.Nodeset,
+			}
+		}
+	}
+
+	var watchdog *parameters.Watchdog
+	if vm.Watchdog != nil {
+		watchdog = &parameters.Watchdog{
+			Model:  vm.Watchdog.Model,
+			Action: vm.Watchdog.Action,
+		}
+	}
+
+	var graphics *parameters.Graphics
+	if vm.Graphics != nil {
+		graphics = &parameters.Graphics{
+			Type:     vm.Graphics.Type,
+			Listen:   vm.Graphics.Listen,
+			Port:     vm.Graphics.Port,
+			Password: vm.Graphics.Password,
+		}
 	}
 
 	return parameters.CreateVM{
 		Name:                   vm.Name,
+		NamePrefix:             vm.NamePrefix,
+		Count:                  vm.Count,
 		VCPUCount:              vm.VCPUCount,
-		MemoryMB:               vm.MemoryMB,
+		MemoryMB:               spAdapter.AdaptSize(vm.Memory, units.MiB, vm.MemoryMB),
 		DiskPath:               vm.DiskPath,
-		DiskSizeGB:             vm.DiskSizeGB,
+		DiskSizeGB:             spAdapter.AdaptSize(vm.DiskSize, units.GiB, vm.DiskSizeGB),
 		BaseImagePath:          vm.BaseImagePath,
+		DiskOptions:            spAdapter.AdaptDiskOptions(vm.DiskOptions),
 		BridgeNetworkInterface: vm.BridgeNetworkInterface,
 		CloudInitISOPath:       vm.CloudInitISOPath,
 		HostBindMounts:         spAdapter.AdaptHostBindMounts(vm.HostBindMounts),
@@ -55,6 +162,227 @@ func (spAdapter ServiceParameterAdapter) AdaptCreateVM(vm contracts.CreateVMRequ
 		UserConfigs:            spAdapter.AdaptUserConfigs(vm.UserConfigs),
 		Runcmds:                vm.Runcmds,
 		Tuning:                 tuning,
+		Labels:                 vm.Labels,
+		KeepRunning:            vm.KeepRunning,
+		TTL:                    spAdapter.AdaptDuration(vm.TTL),
+		OnExpiry:               vm.OnExpiry,
+		Host:                   vm.Host,
+		SpreadGroup:            vm.SpreadGroup,
+		ColocateGroup:          vm.ColocateGroup,
+		Firmware:               string(vm.Firmware),
+		SecureBoot:             vm.SecureBoot,
+		NVRAMPath:              vm.NVRAMPath,
+		MachineType:            string(vm.MachineType),
+		TPM:                    vm.TPM,
+		HostDevices:            spAdapter.AdaptHostDevices(vm.HostDevices),
+		USBDevices:             spAdapter.AdaptUSBDevices(vm.USBDevices),
+		SerialDevices:          spAdapter.AdaptSerialDevices(vm.SerialDevices),
+		CDROMs:                 spAdapter.AdaptCDROMs(vm.CDROMs),
+		Watchdog:               watchdog,
+		OnPoweroff:             vm.OnPoweroff,
+		OnReboot:               vm.OnReboot,
+		OnCrash:                vm.OnCrash,
+		Graphics:               graphics,
+		IPFromPool:             vm.IPFromPool,
+		Proxy:                  spAdapter.AdaptProxyConfig(vm.Proxy),
+		Netboot:                spAdapter.AdaptNetboot(vm.Netboot),
+		ReadinessProbes:        spAdapter.AdaptReadinessProbes(vm.ReadinessProbes),
+		Start:                  vm.AutoStart,
+		KeepArtifactsOnFailure: vm.CleanupOnFailure != nil && !*vm.CleanupOnFailure,
+	}
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptCreateClusterToAPI(cluster parameters.CreateCluster) contracts.CreateClusterRequest {
+	vms := make([]contracts.CreateVMRequest, len(cluster.VirtualMachines))
+	for i, vm := range cluster.VirtualMachines {
+		vms[i] = spAdapter.AdaptCreateVMToAPI(vm)
+	}
+	return contracts.CreateClusterRequest{
+		Name:            cluster.Name,
+		SSHKeys:         cluster.SSHKeys,
+		VirtualMachines: vms,
+	}
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptCreateVMToAPI(vm parameters.CreateVM) contracts.CreateVMRequest {
+	var tuning *contracts.VMTuning
+	if vm.Tuning != nil {
+		tuning = &contracts.VMTuning{
+			AutoPin:        vm.Tuning.AutoPin,
+			VCPUPins:       vm.Tuning.VCPUPins,
+			EmulatorCPUSet: vm.Tuning.EmulatorCPUSet,
+			IOThreads:      vm.Tuning.IOThreads,
+			IOThreadPins:   vm.Tuning.IOThreadPins,
+		}
+		if vm.Tuning.NUMAMemory != nil {
+			tuning.NUMAMemory = &contracts.NUMAMemory{
+				Nodeset: vm.Tuning.NUMAMemory.Nodeset,
+				Mode:    vm.Tuning.NUMAMemory.Mode,
+			}
+		}
+		if vm.Tuning.Hugepages != nil {
+			tuning.Hugepages = &contracts.Hugepages{
+				PageSize: vm.Tuning.Hugepages.PageSize,
+				Nodeset:  vm.Tuning.Hugepages.Nodeset,
+			}
+		}
+	}
+
+	var diskOptions *contracts.DiskOptions
+	if vm.DiskOptions != nil {
+		diskOptions = &contracts.DiskOptions{
+			Preallocation:   vm.DiskOptions.Preallocation,
+			CompressionType: vm.DiskOptions.CompressionType,
+		}
+		if vm.DiskOptions.ClusterSize != 0 {
+			diskOptions.ClusterSize = strconv.FormatInt(vm.DiskOptions.ClusterSize, 10)
+		}
+	}
+
+	var watchdog *contracts.Watchdog
+	if vm.Watchdog != nil {
+		watchdog = &contracts.Watchdog{
+			Model:  vm.Watchdog.Model,
+			Action: vm.Watchdog.Action,
+		}
+	}
+
+	var graphics *contracts.Graphics
+	if vm.Graphics != nil {
+		graphics = &contracts.Graphics{
+			Type:     vm.Graphics.Type,
+			Listen:   vm.Graphics.Listen,
+			Port:     vm.Graphics.Port,
+			Password: vm.Graphics.Password,
+		}
+	}
+
+	var proxy *contracts.ProxyConfig
+	if vm.Proxy != nil {
+		proxy = &contracts.ProxyConfig{
+			HTTPProxy:  vm.Proxy.HTTPProxy,
+			HTTPSProxy: vm.Proxy.HTTPSProxy,
+			NoProxy:    vm.Proxy.NoProxy,
+		}
+	}
+
+	var netboot *contracts.Netboot
+	if vm.Netboot != nil {
+		netboot = &contracts.Netboot{}
+		if vm.Netboot.Server != nil {
+			netboot.Server = &contracts.NetbootServer{
+				DHCPRange: vm.Netboot.Server.DHCPRange,
+				TFTPRoot:  vm.Netboot.Server.TFTPRoot,
+				BootFile:  vm.Netboot.Server.BootFile,
+			}
+		}
+	}
+
+	hostBindMounts := make([]contracts.HostBindMount, len(vm.HostBindMounts))
+	for i, m := range vm.HostBindMounts {
+		hostBindMounts[i] = contracts.HostBindMount{
+			SourceDir: m.SourceDir,
+			TargetDir: m.TargetDir,
+			Driver:    m.Driver,
+			ReadOnly:  m.ReadOnly,
+		}
+	}
+
+	userConfigs := make([]contracts.UserConfig, len(vm.UserConfigs))
+	for i, c := range vm.UserConfigs {
+		userConfigs[i] = contracts.UserConfig{
+			Username:          c.Username,
+			SSHAuthorizedKeys: c.SSHAuthorizedKeys,
+			Password:          c.Password,
+		}
+	}
+
+	var hostDevices []contracts.HostDevice
+	for _, d := range vm.HostDevices {
+		hostDevices = append(hostDevices, contracts.HostDevice{Address: d.Address, VendorDevice: d.VendorDevice})
+	}
+	var usbDevices []contracts.USBDevice
+	for _, d := range vm.USBDevices {
+		usbDevices = append(usbDevices, contracts.USBDevice{VendorProduct: d.VendorProduct})
+	}
+	var serialDevices []contracts.SerialDevice
+	for _, d := range vm.SerialDevices {
+		serialDevices = append(serialDevices, contracts.SerialDevice{Type: d.Type, Path: d.Path, Host: d.Host, Port: d.Port})
+	}
+	var cdroms []contracts.CDROM
+	for _, c := range vm.CDROMs {
+		cdroms = append(cdroms, contracts.CDROM{Path: c.Path})
+	}
+
+	var probes []contracts.ReadinessProbe
+	for _, probe := range vm.ReadinessProbes {
+		var timeout string
+		if probe.Timeout != 0 {
+			timeout = probe.Timeout.String()
+		}
+		probes = append(probes, contracts.ReadinessProbe{
+			Type:    probe.Type,
+			Port:    probe.Port,
+			URL:     probe.URL,
+			Timeout: timeout,
+		})
+	}
+
+	var ttl string
+	if vm.TTL != 0 {
+		ttl = vm.TTL.String()
+	}
+	var cleanupOnFailure *bool
+	if vm.KeepArtifactsOnFailure {
+		cleanupOnFailure = new(bool)
+	}
+
+	return contracts.CreateVMRequest{
+		Name:                   vm.Name,
+		NamePrefix:             vm.NamePrefix,
+		Count:                  vm.Count,
+		VCPUCount:              vm.VCPUCount,
+		MemoryMB:               vm.MemoryMB,
+		DiskPath:               vm.DiskPath,
+		DiskSizeGB:             vm.DiskSizeGB,
+		BaseImagePath:          vm.BaseImagePath,
+		DiskOptions:            diskOptions,
+		BridgeNetworkInterface: vm.BridgeNetworkInterface,
+		CloudInitISOPath:       vm.CloudInitISOPath,
+		HostBindMounts:         hostBindMounts,
+		Role:                   constants.KubernetesRole(vm.Role),
+		DoPackageUpdate:        vm.DoPackageUpdate,
+		DoPackageUpgrade:       vm.DoPackageUpgrade,
+		UserConfigs:            userConfigs,
+		Runcmds:                vm.Runcmds,
+		Tuning:                 tuning,
+		Labels:                 vm.Labels,
+		KeepRunning:            vm.KeepRunning,
+		TTL:                    ttl,
+		OnExpiry:               vm.OnExpiry,
+		Host:                   vm.Host,
+		SpreadGroup:            vm.SpreadGroup,
+		ColocateGroup:          vm.ColocateGroup,
+		Firmware:               constants.Firmware(vm.Firmware),
+		SecureBoot:             vm.SecureBoot,
+		NVRAMPath:              vm.NVRAMPath,
+		MachineType:            constants.MachineType(vm.MachineType),
+		TPM:                    vm.TPM,
+		HostDevices:            hostDevices,
+		USBDevices:             usbDevices,
+		SerialDevices:          serialDevices,
+		CDROMs:                 cdroms,
+		Watchdog:               watchdog,
+		OnPoweroff:             vm.OnPoweroff,
+		OnReboot:               vm.OnReboot,
+		OnCrash:                vm.OnCrash,
+		Graphics:               graphics,
+		IPFromPool:             vm.IPFromPool,
+		Proxy:                  proxy,
+		Netboot:                netboot,
+		ReadinessProbes:        probes,
+		AutoStart:              vm.Start,
+		CleanupOnFailure:       cleanupOnFailure,
 	}
 }
 
@@ -72,7 +400,19 @@ func (spAdapter ServiceParameterAdapter) AdaptStartCluster(req contracts.StartCl
 	params := make([]parameters.StartVM, len(req.VirtualMachines))
 	for i, vm := range req.VirtualMachines {
 		params[i] = parameters.StartVM{
-			Name: vm.Name,
+			Name:      vm.Name,
+			DependsOn: vm.DependsOn,
+		}
+	}
+	return params
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptStopCluster(req contracts.StopClusterRequest) []parameters.StopVM {
+	params := make([]parameters.StopVM, len(req.VirtualMachines))
+	for i, vm := range req.VirtualMachines {
+		params[i] = parameters.StopVM{
+			Name:      vm.Name,
+			DependsOn: vm.DependsOn,
 		}
 	}
 	return params
@@ -88,6 +428,31 @@ func (spAdapter ServiceParameterAdapter) AdaptQueryCluster(req contracts.QueryCl
 	return params
 }
 
+func (spAdapter ServiceParameterAdapter) AdaptVMStatsToAPI(stats parameters.VMStats) contracts.VMStats {
+	return contracts.VMStats{
+		Name:            stats.Name,
+		Time:            stats.Time,
+		IntervalSeconds: stats.IntervalSeconds,
+		State:           stats.State,
+		CPUPercent:      stats.CPUPercent,
+		MemoryMB:        units.KiBToMiB(stats.MemoryKiB),
+		MemoryRSSMB:     units.KiBToMiB(stats.MemoryRSSKiB),
+		DiskReadBytes:   stats.DiskReadBytes,
+		DiskWriteBytes:  stats.DiskWriteBytes,
+		NetRxBytes:      stats.NetRxBytes,
+		NetTxBytes:      stats.NetTxBytes,
+	}
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptCloudInitToAPI(documents parameters.CloudInitDocuments) contracts.CloudInitDocuments {
+	return contracts.CloudInitDocuments{
+		VM:         documents.VM,
+		InstanceID: documents.InstanceID,
+		RenderedAt: documents.RenderedAt,
+		Documents:  documents.Documents,
+	}
+}
+
 func (spAdapter ServiceParameterAdapter) AdaptVMInfoToAPI(vmInfos []parameters.VMInfo) []contracts.VMInfo {
 	result := make([]contracts.VMInfo, len(vmInfos))
 	for i, info := range vmInfos {
@@ -100,36 +465,221 @@ func (spAdapter ServiceParameterAdapter) AdaptVMInfoToAPI(vmInfos []parameters.V
 				SizeGB: d.SizeGB,
 			}
 		}
+		var graphics []contracts.GraphicsInfo
+		for _, g := range info.Graphics {
+			graphics = append(graphics, contracts.GraphicsInfo{
+				Type:   g.Type,
+				Listen: g.Listen,
+				Port:   g.Port,
+			})
+		}
 		result[i] = contracts.VMInfo{
-			Name:       info.Name,
-			UUID:       info.UUID,
-			State:      info.State,
-			VCPUCount:  info.VCPUCount,
-			MemoryMB:   info.MemoryMB,
-			Disks:      disks,
-			AutoStart:  info.AutoStart,
-			Persistent: info.Persistent,
-			Hostname:   info.Hostname,
-			IPAddress:  info.IPAddress,
+			Name:        info.Name,
+			Namespace:   info.Namespace,
+			UUID:        info.UUID,
+			State:       info.State,
+			VCPUCount:   info.VCPUCount,
+			MemoryMB:    info.MemoryMB,
+			MemoryBytes: info.MemoryBytes,
+			Disks:       disks,
+			AutoStart:   info.AutoStart,
+			Persistent:  info.Persistent,
+			Hostname:    info.Hostname,
+			IPAddress:   info.IPAddress,
+			Labels:      info.Labels,
+			Ownership:   spAdapter.AdaptOwnershipToAPI(info.Ownership),
+			Host:        info.Host,
+			Graphics:    graphics,
+			Readiness:   spAdapter.AdaptReadinessToAPI(info.Readiness),
+			Timeline:    spAdapter.AdaptTimelineToAPI(info.Timeline),
+			Boot:        spAdapter.AdaptBootTimeToAPI(info.Boot),
 		}
 	}
 	return result
 }
 
+func (spAdapter ServiceParameterAdapter) AdaptOwnershipToAPI(ownership *parameters.Ownership) *contracts.Ownership {
+	if ownership == nil {
+		return nil
+	}
+	result := &contracts.Ownership{
+		Cluster:   ownership.Cluster,
+		Namespace: ownership.Namespace,
+		Owner:     ownership.Owner,
+		SpecHash:  ownership.SpecHash,
+		Adopted:   ownership.Adopted,
+	}
+	if !ownership.CreatedAt.IsZero() {
+		createdAt := ownership.CreatedAt
+		result.CreatedAt = &createdAt
+	}
+	return result
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptReadinessToAPI(readiness *parameters.Readiness) *contracts.Readiness {
+	if readiness == nil {
+		return nil
+	}
+	result := &contracts.Readiness{Ready: readiness.Ready}
+	if !readiness.CheckedAt.IsZero() {
+		checkedAt := readiness.CheckedAt
+		result.CheckedAt = &checkedAt
+	}
+	for _, probe := range readiness.Probes {
+		result.Probes = append(result.Probes, contracts.ProbeResult{
+			Type:    probe.Type,
+			Target:  probe.Target,
+			Ready:   probe.Ready,
+			Message: probe.Message,
+		})
+	}
+	return result
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptTimelineToAPI(phases []parameters.ProvisioningPhase) []contracts.ProvisioningPhase {
+	var result []contracts.ProvisioningPhase
+	for i, phase := range phases {
+		apiPhase := contracts.ProvisioningPhase{Phase: phase.Phase, Time: phase.Time}
+		if i > 0 {
+			apiPhase.Elapsed = phase.Time.Sub(phases[i-1].Time).Round(100 * time.Millisecond).String()
+		}
+		result = append(result, apiPhase)
+	}
+	return result
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptBootTimeToAPI(boot *parameters.BootTime) *contracts.BootTime {
+	if boot == nil {
+		return nil
+	}
+	return &contracts.BootTime{
+		StartedAt:              boot.StartedAt,
+		IPAcquiredSeconds:      boot.IPAcquired.Round(100 * time.Millisecond).Seconds(),
+		GuestAgentReadySeconds: boot.GuestAgentReady.Round(100 * time.Millisecond).Seconds(),
+		Pending:                boot.Pending,
+	}
+}
+
+// ansibleGroupLabels are the label keys whose values become Ansible groups, e.g. role=master -> role_master.
+var ansibleGroupLabels = []string{"cluster", "role"}
+
+var ansibleGroupPattern = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+func (spAdapter ServiceParameterAdapter) AdaptVMInfoToAnsibleInventory(vmInfos []parameters.VMInfo) contracts.AnsibleInventory {
+	inventory := contracts.AnsibleInventory{
+		Groups:   make(map[string]contracts.AnsibleGroup),
+		HostVars: make(map[string]map[string]any, len(vmInfos)),
+	}
+
+	var ungrouped []string
+	for _, info := range vmInfos {
+		hostVars := map[string]any{
+			"homonculus_state": info.State,
+			"homonculus_host":  info.Host,
+		}
+		if info.IPAddress != "" {
+			hostVars["ansible_host"] = info.IPAddress
+		}
+		if len(info.Labels) > 0 {
+			hostVars["homonculus_labels"] = info.Labels
+		}
+		inventory.HostVars[info.Name] = hostVars
+
+		grouped := false
+		for _, key := range ansibleGroupLabels {
+			value, ok := info.Labels[key]
+			if !ok || value == "" {
+				continue
+			}
+			name := key + "_" + ansibleGroupPattern.ReplaceAllString(strings.ToLower(value), "_")
+			group := inventory.Groups[name]
+			group.Hosts = append(group.Hosts, info.Name)
+			inventory.Groups[name] = group
+			grouped = true
+		}
+		if !grouped {
+			ungrouped = append(ungrouped, info.Name)
+		}
+	}
+
+	children := slices.Sorted(maps.Keys(inventory.Groups))
+	if len(ungrouped) > 0 {
+		inventory.Groups["ungrouped"] = contracts.AnsibleGroup{Hosts: ungrouped}
+		children = append(children, "ungrouped")
+	}
+	inventory.Groups["all"] = contracts.AnsibleGroup{Children: children}
+	return inventory
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptVMInfoToTerraform(info parameters.VMInfo) contracts.TerraformVirtualMachine {
+	disks := make([]contracts.DiskInfo, len(info.Disks))
+	for i, d := range info.Disks {
+		disks[i] = contracts.DiskInfo{
+			Path:   d.Path,
+			Type:   d.Type,
+			Device: d.Device,
+			SizeGB: d.SizeGB,
+		}
+	}
+	return contracts.TerraformVirtualMachine{
+		Name:      info.Name,
+		UUID:      info.UUID,
+		Host:      info.Host,
+		Cluster:   info.Labels[service.LabelCluster],
+		State:     info.State,
+		VCPUCount: info.VCPUCount,
+		MemoryMB:  info.MemoryMB,
+		AutoStart: info.AutoStart,
+		IPAddress: info.IPAddress,
+		Labels:    info.Labels,
+		Disks:     disks,
+	}
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptClusterToTerraform(name string, spec *parameters.CreateCluster, vmInfos []parameters.VMInfo) contracts.TerraformCluster {
+	uuids := make(map[string]string)
+	for _, info := range vmInfos {
+		uuids[info.Name] = info.UUID
+	}
+	if spec != nil {
+		for _, vm := range spec.VirtualMachines {
+			if _, ok := uuids[vm.Name]; !ok {
+				uuids[vm.Name] = ""
+			}
+		}
+	}
+
+	members := make([]contracts.TerraformClusterMember, 0, len(uuids))
+	for _, vmName := range slices.Sorted(maps.Keys(uuids)) {
+		members = append(members, contracts.TerraformClusterMember{Name: vmName, UUID: uuids[vmName]})
+	}
+	return contracts.TerraformCluster{
+		Name:    name,
+		Managed: spec != nil,
+		Members: members,
+	}
+}
+
 func (spAdapter ServiceParameterAdapter) AdaptCloneCluster(req contracts.CloneClusterRequest) parameters.CloneVM {
 	targetSpecs := make([]parameters.TargetVMSpec, len(req.TargetVMs))
 	for i, target := range req.TargetVMs {
 		targetSpecs[i] = parameters.TargetVMSpec{
 			Name:          target.Name,
 			VCPUCount:     target.VCPUCount,
-			MemoryMB:      target.MemoryMB,
+			MemoryMB:      spAdapter.AdaptSize(target.Memory, units.MiB, target.MemoryMB),
 			DiskPath:      target.DiskPath,
-			DiskSizeGB:    target.DiskSizeGB,
+			DiskSizeGB:    spAdapter.AdaptSize(target.DiskSize, units.GiB, target.DiskSizeGB),
 			BaseImagePath: target.BaseImagePath,
+			Start:         target.AutoStart,
+			Hostname:      target.Hostname,
+			UserConfigs:   spAdapter.AdaptUserConfigs(target.UserConfigs),
+			Runcmds:       target.Runcmds,
+			Network:       spAdapter.AdaptNetworkConfig(target.Network),
 		}
 	}
 
 	return parameters.CloneVM{
+		Cluster:     req.Cluster,
 		BaseVMName:  req.BaseVM.Name,
 		TargetSpecs: targetSpecs,
 	}
@@ -147,13 +697,420 @@ func (spAdapter ServiceParameterAdapter) AdaptUserConfigs(configs []contracts.Us
 	return result
 }
 
+func (spAdapter ServiceParameterAdapter) AdaptNetboot(netboot *contracts.Netboot) *parameters.Netboot {
+	if netboot == nil {
+		return nil
+	}
+	params := &parameters.Netboot{}
+	if netboot.Server != nil {
+		params.Server = &parameters.NetbootServer{
+			DHCPRange: netboot.Server.DHCPRange,
+			TFTPRoot:  netboot.Server.TFTPRoot,
+			BootFile:  netboot.Server.BootFile,
+		}
+	}
+	return params
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptReadinessProbes(probes []contracts.ReadinessProbe) []parameters.ReadinessProbe {
+	if len(probes) == 0 {
+		return nil
+	}
+	result := make([]parameters.ReadinessProbe, len(probes))
+	for i, probe := range probes {
+		result[i] = parameters.ReadinessProbe{
+			Type:    probe.Type,
+			Port:    probe.Port,
+			URL:     probe.URL,
+			Timeout: spAdapter.AdaptDuration(probe.Timeout),
+		}
+	}
+	return result
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptNetworkConfig(network *contracts.NetworkConfig) *parameters.NetworkConfig {
+	if network == nil {
+		return nil
+	}
+	return &parameters.NetworkConfig{
+		IPv4Address: network.IPv4Address,
+		IPv4Gateway: network.IPv4Gateway,
+		Nameservers: network.Nameservers,
+	}
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptProxyConfig(proxy *contracts.ProxyConfig) *parameters.ProxyConfig {
+	if proxy == nil {
+		return nil
+	}
+	return &parameters.ProxyConfig{
+		HTTPProxy:  proxy.HTTPProxy,
+		HTTPSProxy: proxy.HTTPSProxy,
+		NoProxy:    proxy.NoProxy,
+	}
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptHostDevices(devices []contracts.HostDevice) []parameters.HostDevice {
+	result := make([]parameters.HostDevice, len(devices))
+	for i, d := range devices {
+		result[i] = parameters.HostDevice{
+			Address:      d.Address,
+			VendorDevice: d.VendorDevice,
+		}
+	}
+	return result
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptUSBDevices(devices []contracts.USBDevice) []parameters.USBDevice {
+	result := make([]parameters.USBDevice, len(devices))
+	for i, d := range devices {
+		result[i] = parameters.USBDevice{VendorProduct: d.VendorProduct}
+	}
+	return result
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptSerialDevices(devices []contracts.SerialDevice) []parameters.SerialDevice {
+	result := make([]parameters.SerialDevice, len(devices))
+	for i, d := range devices {
+		result[i] = parameters.SerialDevice{
+			Type: d.Type,
+			Path: d.Path,
+			Host: d.Host,
+			Port: d.Port,
+		}
+	}
+	return result
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptAttachDevices(req contracts.AttachDevicesRequest) parameters.AttachDevices {
+	return parameters.AttachDevices{
+		Name:          req.Name,
+		USBDevices:    spAdapter.AdaptUSBDevices(req.USBDevices),
+		SerialDevices: spAdapter.AdaptSerialDevices(req.SerialDevices),
+	}
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptDetachDevices(req contracts.DetachDevicesRequest) parameters.DetachDevices {
+	return parameters.DetachDevices{
+		Name:        req.Name,
+		USBDevices:  spAdapter.AdaptUSBDevices(req.USBDevices),
+		SerialPorts: req.SerialPorts,
+	}
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptInsertMedia(req contracts.InsertMediaRequest) parameters.ChangeMedia {
+	return parameters.ChangeMedia{
+		Name:   req.Name,
+		Device: req.Device,
+		Path:   req.Path,
+	}
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptAdoptVM(req contracts.AdoptVMRequest) parameters.AdoptVM {
+	return parameters.AdoptVM{
+		Name:    req.Name,
+		Host:    req.Host,
+		Cluster: req.Cluster,
+		Labels:  req.Labels,
+	}
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptEjectMedia(req contracts.EjectMediaRequest) parameters.ChangeMedia {
+	return parameters.ChangeMedia{
+		Name:   req.Name,
+		Device: req.Device,
+	}
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptCDROMs(cdroms []contracts.CDROM) []parameters.CDROM {
+	result := make([]parameters.CDROM, len(cdroms))
+	for i, c := range cdroms {
+		result[i] = parameters.CDROM{Path: c.Path}
+	}
+	return result
+}
+
 func (spAdapter ServiceParameterAdapter) AdaptHostBindMounts(configs []contracts.HostBindMount) []parameters.HostBindMount {
 	result := make([]parameters.HostBindMount, len(configs))
 	for i, c := range configs {
 		result[i] = parameters.HostBindMount{
 			SourceDir: c.SourceDir,
 			TargetDir: c.TargetDir,
+			Driver:    c.Driver,
+			ReadOnly:  c.ReadOnly,
+			Tag:       "hostmount" + strconv.Itoa(i),
+		}
+	}
+	return result
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptHostCapacitiesToAPI(capacities []parameters.HostCapacity) []contracts.HostCapacity {
+	result := make([]contracts.HostCapacity, len(capacities))
+	for i, c := range capacities {
+		numaNodes := make([]contracts.NUMANode, len(c.NUMANodes))
+		for j, node := range c.NUMANodes {
+			cpus := make([]contracts.HostCPU, len(node.CPUs))
+			for k, cpu := range node.CPUs {
+				cpus[k] = contracts.HostCPU{
+					ID:       cpu.ID,
+					SocketID: cpu.SocketID,
+					CoreID:   cpu.CoreID,
+					Siblings: cpu.Siblings,
+				}
+			}
+			numaNodes[j] = contracts.NUMANode{
+				ID:            node.ID,
+				MemoryKiB:     node.MemoryKiB,
+				FreeMemoryKiB: node.FreeMemoryKiB,
+				CPUs:          cpus,
+			}
 		}
+
+		storagePools := make([]contracts.StoragePoolCapacity, len(c.StoragePools))
+		for j, pool := range c.StoragePools {
+			storagePools[j] = contracts.StoragePoolCapacity{
+				Name:            pool.Name,
+				Active:          pool.Active,
+				CapacityBytes:   pool.CapacityBytes,
+				AllocationBytes: pool.AllocationBytes,
+				AvailableBytes:  pool.AvailableBytes,
+			}
+		}
+
+		result[i] = contracts.HostCapacity{
+			Host:               c.Host,
+			TotalMemoryKiB:     c.TotalMemoryKiB,
+			FreeMemoryKiB:      c.FreeMemoryKiB,
+			CPUs:               c.CPUs,
+			AllocatedMemoryKiB: c.AllocatedMemoryKiB,
+			AllocatedVCPUs:     c.AllocatedVCPUs,
+			DefinedVMs:         c.DefinedVMs,
+			RunningVMs:         c.RunningVMs,
+			LoadAverage:        c.LoadAverage,
+			NUMANodes:          numaNodes,
+			StoragePools:       storagePools,
+		}
+	}
+	return result
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptDiskOptions(options *contracts.DiskOptions) *parameters.DiskOptions {
+	if options == nil {
+		return nil
+	}
+	result := &parameters.DiskOptions{
+		Preallocation:   options.Preallocation,
+		CompressionType: options.CompressionType,
+	}
+	if options.ClusterSize != "" {
+		// Cluster sizes are validated by the handler.
+		result.ClusterSize, _ = qemuimg.ParseClusterSize(options.ClusterSize)
+	}
+	return result
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptSize(value string, unit int64, fallback int64) int64 {
+	if value == "" {
+		return fallback
+	}
+	// Sizes are validated by the handler.
+	size, _ := units.ParseSizeIn(value, unit)
+	return size
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptDuration(value string) time.Duration {
+	// Durations are validated by the handler; an empty value yields 0.
+	duration, _ := time.ParseDuration(value)
+	return duration
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptCreateSchedule(req contracts.CreateScheduleRequest) parameters.CreateSchedule {
+	return parameters.CreateSchedule{
+		Cron:     req.Cron,
+		Timezone: req.Timezone,
+		Action:   req.Action,
+		Selector: req.Selector,
+		Retain:   req.Retain,
+	}
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptScheduleToAPI(schedule parameters.Schedule) contracts.Schedule {
+	optionalTime := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		return &t
+	}
+	return contracts.Schedule{
+		ID:        schedule.ID,
+		Cron:      schedule.Cron,
+		Timezone:  schedule.Timezone,
+		Action:    schedule.Action,
+		Selector:  schedule.Selector,
+		Retain:    schedule.Retain,
+		CreatedAt: schedule.CreatedAt,
+		LastRun:   optionalTime(schedule.LastRun),
+		LastError: schedule.LastError,
+		NextRun:   optionalTime(schedule.NextRun),
+	}
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptSchedulesToAPI(schedules []parameters.Schedule) []contracts.Schedule {
+	result := make([]contracts.Schedule, len(schedules))
+	for i, schedule := range schedules {
+		result[i] = spAdapter.AdaptScheduleToAPI(schedule)
 	}
 	return result
 }
+
+func (spAdapter ServiceParameterAdapter) AdaptEventsToAPI(events []parameters.Event) []contracts.Event {
+	result := make([]contracts.Event, len(events))
+	for i, event := range events {
+		result[i] = contracts.Event{
+			ID:      event.ID,
+			Time:    event.Time,
+			Type:    event.Type,
+			VM:      event.VM,
+			Host:    event.Host,
+			Actor:   event.Actor,
+			Message: event.Message,
+			Error:   event.Error,
+			TraceID: event.TraceID,
+			JobID:   event.JobID,
+		}
+	}
+	return result
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptJobToAPI(job parameters.Job) contracts.Job {
+	result := contracts.Job{
+		ID:              job.ID,
+		Operation:       job.Operation,
+		State:           job.State,
+		Error:           job.Error,
+		Actor:           job.Actor,
+		CreatedAt:       job.CreatedAt,
+		Completed:       job.Completed,
+		Total:           len(job.VMs),
+		VirtualMachines: make([]contracts.JobVM, len(job.VMs)),
+	}
+	if !job.FinishedAt.IsZero() {
+		finishedAt := job.FinishedAt
+		result.FinishedAt = &finishedAt
+	}
+	for i, vm := range job.VMs {
+		result.VirtualMachines[i] = contracts.JobVM{Name: vm.Name, State: vm.State, Error: vm.Error}
+	}
+	return result
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptTemplateRolloutToAPI(rollout parameters.TemplateRollout) contracts.TemplateRolloutResponse {
+	response := contracts.TemplateRolloutResponse{
+		Versions: make([]contracts.TemplateVersion, len(rollout.Versions)),
+		Diffs:    make([]contracts.TemplateDiff, len(rollout.Diffs)),
+	}
+	for i, version := range rollout.Versions {
+		response.Versions[i] = contracts.TemplateVersion{
+			Name:     version.Name,
+			Active:   version.Active,
+			LoadedAt: version.LoadedAt,
+			Digests:  version.Digests,
+		}
+	}
+	for i, diff := range rollout.Diffs {
+		response.Diffs[i] = contracts.TemplateDiff{
+			VM:             diff.VM,
+			Template:       diff.Template,
+			Time:           diff.Time,
+			Identical:      diff.Identical,
+			Diff:           diff.Diff,
+			CandidateError: diff.CandidateError,
+		}
+	}
+	return response
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptGenerateSSHKey(req contracts.GenerateSSHKeyRequest) parameters.GenerateSSHKey {
+	return parameters.GenerateSSHKey{
+		Name:  req.Name,
+		Store: req.Store,
+	}
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptSSHKeyToAPI(key parameters.SSHKey) contracts.SSHKey {
+	return contracts.SSHKey{
+		Name:        key.Name,
+		PublicKey:   key.PublicKey,
+		Fingerprint: key.Fingerprint,
+		Stored:      key.Stored,
+		CreatedAt:   key.CreatedAt,
+		PrivateKey:  key.PrivateKey,
+	}
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptSSHKeysToAPI(keys []parameters.SSHKey) []contracts.SSHKey {
+	result := make([]contracts.SSHKey, len(keys))
+	for i, key := range keys {
+		result[i] = spAdapter.AdaptSSHKeyToAPI(key)
+	}
+	return result
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptDomainDiffsToAPI(diffs []parameters.DomainDiff) []contracts.DomainDiffResponse {
+	result := make([]contracts.DomainDiffResponse, len(diffs))
+	for i, diff := range diffs {
+		result[i] = contracts.DomainDiffResponse{
+			VM:          diff.VM,
+			Host:        diff.Host,
+			Defined:     diff.Defined,
+			Drift:       make([]contracts.DomainDrift, len(diff.Drift)),
+			RenderedXML: diff.RenderedXML,
+		}
+		for j, drift := range diff.Drift {
+			result[i].Drift[j] = contracts.DomainDrift{
+				Field: drift.Field,
+				Spec:  drift.Spec,
+				Live:  drift.Live,
+			}
+		}
+	}
+	return result
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptHostMaintenanceToAPI(maintenance []parameters.HostMaintenance) []contracts.HostMaintenance {
+	result := make([]contracts.HostMaintenance, len(maintenance))
+	for i, m := range maintenance {
+		result[i] = contracts.HostMaintenance{
+			Host:      m.Host,
+			Mode:      m.Mode,
+			StartedAt: m.StartedAt,
+			Migrated:  m.Migrated,
+			Stopped:   m.Stopped,
+		}
+	}
+	return result
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptHostConnectionsToAPI(connections []parameters.HostConnection) contracts.ReadinessResponse {
+	response := contracts.ReadinessResponse{Ready: true, Hosts: make([]contracts.HostConnection, len(connections))}
+	for i, connection := range connections {
+		response.Hosts[i] = contracts.HostConnection{
+			Host:      connection.Host,
+			Reachable: connection.Reachable,
+			Error:     connection.Error,
+		}
+		response.Ready = response.Ready && connection.Reachable
+	}
+	return response
+}
+
+func (spAdapter ServiceParameterAdapter) AdaptConsoleTicketToAPI(ticket parameters.ConsoleTicket) contracts.ConsoleTicketResponse {
+	return contracts.ConsoleTicketResponse{
+		VM:        ticket.VM,
+		Type:      ticket.Type,
+		Host:      ticket.Host,
+		Port:      ticket.Port,
+		Password:  ticket.Password,
+		ExpiresAt: ticket.ExpiresAt,
+	}
+}
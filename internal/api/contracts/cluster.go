@@ -1,7 +1,13 @@
 package contracts
 
 // CreateClusterRequest contains the configuration for creating a cluster of virtual machines.
+// A named cluster is persisted as desired state and its VMs are labelled with cluster=<name>.
+// Variables are substituted for ${name} references in the VM entries; "$${" yields a literal "${".
+// The public keys of the named SSH keys are authorized for every user of every VM.
 type CreateClusterRequest struct {
+	Name            string            `json:"name,omitempty"`
+	Variables       map[string]string `json:"variables,omitempty"`
+	SSHKeys         []string          `json:"ssh_keys,omitempty"`
 	VirtualMachines []CreateVMRequest `json:"virtual_machines"`
 }
 
@@ -13,6 +19,68 @@ type DeleteClusterRequest struct {
 // StartClusterRequest contains the configuration for starting a cluster of virtual machines.
 type StartClusterRequest struct {
 	VirtualMachines []StartVMRequest `json:"virtual_machines"`
+	ReadyTimeout    string           `json:"ready_timeout,omitempty"` // How long each tier of depends_on waits for the previous one to become ready, e.g. "5m" (default: 10m)
+}
+
+// StopClusterRequest contains the configuration for stopping a cluster of virtual machines.
+type StopClusterRequest struct {
+	VirtualMachines []StopVMRequest `json:"virtual_machines"`
+	ShutdownTimeout string          `json:"shutdown_timeout,omitempty"` // How long each tier of depends_on waits for the previous one to shut off, e.g. "2m" (default: 5m)
+}
+
+// ResetClusterRequest names a named cluster and the snapshot every one of its VMs is reverted to.
+type ResetClusterRequest struct {
+	Cluster  string `json:"cluster"`
+	Snapshot string `json:"snapshot"`
+}
+
+// CreateFleetRequest contains the configuration for creating a fleet: count linked clones of the
+// golden image of the template, named <name_prefix>-1..<name_prefix>-<count> and stored as a named cluster.
+// Every VM gets a generated MAC address and, with an address pool, the next free address of the pool.
+// Variables are substituted like in CreateClusterRequest.
+type CreateFleetRequest struct {
+	Name        string            `json:"name"`
+	NamePrefix  string            `json:"name_prefix"`
+	Count       int               `json:"count"`
+	Variables   map[string]string `json:"variables,omitempty"`
+	SSHKeys     []string          `json:"ssh_keys,omitempty"`
+	AddressPool *AddressPool      `json:"address_pool,omitempty"`
+	Template    CreateVMRequest   `json:"template"` // base_image_path is the golden image; name, name_prefix and count must be empty
+}
+
+// AddressPool is a range of static IPv4 addresses handed out to the VMs of a fleet.
+// Start and end default to the first and last host address of the network.
+type AddressPool struct {
+	Network     string   `json:"network"` // CIDR notation, e.g. 10.0.0.0/24
+	Start       string   `json:"start,omitempty"`
+	End         string   `json:"end,omitempty"`
+	Gateway     string   `json:"gateway,omitempty"`
+	Nameservers []string `json:"nameservers,omitempty"`
+}
+
+// IPPool describes a configured IP pool and the addresses allocated from it.
+type IPPool struct {
+	Name        string              `json:"name"`
+	Pool        AddressPool         `json:"pool"`
+	Allocations []AddressAllocation `json:"allocations"`
+}
+
+// AddressAllocation is an address a VM holds from an IP pool.
+type AddressAllocation struct {
+	VM      string `json:"vm"`
+	Address string `json:"address"`
+}
+
+// ListIPPoolsResponse contains the configured IP pools.
+type ListIPPoolsResponse struct {
+	IPPools []IPPool `json:"ip_pools"`
+}
+
+// FleetMember describes the network identity assigned to a VM of a fleet.
+type FleetMember struct {
+	Name        string `json:"name"`
+	MACAddress  string `json:"mac_address"`
+	IPv4Address string `json:"ipv4_address,omitempty"`
 }
 
 // QueryClusterRequest contains the configuration for querying a cluster of virtual machines.
@@ -26,7 +94,11 @@ type QueryClusterResponse struct {
 }
 
 // CloneClusterRequest contains the configuration for cloning a base VM into multiple target VMs.
+// Variables are substituted like in CreateClusterRequest. The clones are labelled with Cluster
+// rather than the cluster of the base VM, and belong to no cluster without it.
 type CloneClusterRequest struct {
-	BaseVM    BaseVMSpec     `json:"base_virtual_machine"`
-	TargetVMs []TargetVMSpec `json:"target_virtual_machines"`
+	Variables map[string]string `json:"variables,omitempty"`
+	Cluster   string            `json:"cluster,omitempty"`
+	BaseVM    BaseVMSpec        `json:"base_virtual_machine"`
+	TargetVMs []TargetVMSpec    `json:"target_virtual_machines"`
 }
@@ -0,0 +1,63 @@
+package contracts
+
+import "encoding/json"
+
+// AnsibleInventory is an Ansible dynamic inventory document.
+// Groups are serialized as top-level keys next to "_meta", as ansible-inventory expects.
+type AnsibleInventory struct {
+	Groups   map[string]AnsibleGroup
+	HostVars map[string]map[string]any
+}
+
+// AnsibleGroup lists the hosts and child groups of an inventory group.
+type AnsibleGroup struct {
+	Hosts    []string `json:"hosts,omitempty"`
+	Children []string `json:"children,omitempty"`
+}
+
+// MarshalJSON renders the inventory in the dynamic inventory script format.
+func (inventory AnsibleInventory) MarshalJSON() ([]byte, error) {
+	document := make(map[string]any, len(inventory.Groups)+1)
+	for name, group := range inventory.Groups {
+		document[name] = group
+	}
+
+	hostVars := inventory.HostVars
+	if hostVars == nil {
+		hostVars = map[string]map[string]any{}
+	}
+	document["_meta"] = map[string]any{"hostvars": hostVars}
+
+	return json.Marshal(document)
+}
+
+// TerraformVirtualMachine is the canonical representation of a VM for infrastructure-as-code tooling.
+// The name is the resource identifier; the UUID is immutable for the lifetime of the VM.
+// The state and IP address are observed, not specified, and do not change its ETag.
+type TerraformVirtualMachine struct {
+	Name      string            `json:"name"`
+	UUID      string            `json:"uuid"`
+	Host      string            `json:"host"`
+	Cluster   string            `json:"cluster,omitempty"`
+	State     string            `json:"state"`
+	VCPUCount uint              `json:"vcpu_count"`
+	MemoryMB  uint              `json:"memory_mb"`
+	AutoStart bool              `json:"autostart"`
+	IPAddress string            `json:"ip_address,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Disks     []DiskInfo        `json:"disks"`
+}
+
+// TerraformCluster is the canonical representation of a cluster and its members.
+// Members are the VMs labelled with the cluster and those in its stored spec, ordered by name.
+type TerraformCluster struct {
+	Name    string                   `json:"name"`
+	Managed bool                     `json:"managed"` // the cluster has a stored desired-state spec
+	Members []TerraformClusterMember `json:"members"`
+}
+
+// TerraformClusterMember identifies a VM of a cluster. The UUID is empty if the VM does not exist.
+type TerraformClusterMember struct {
+	Name string `json:"name"`
+	UUID string `json:"uuid,omitempty"`
+}
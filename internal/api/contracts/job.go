@@ -0,0 +1,25 @@
+package contracts
+
+import "time"
+
+// Job reports a create or clone cluster request sent with ?async=true, which returns as soon
+// as the job started. Poll GET /jobs/{id} until its state is succeeded or failed.
+type Job struct {
+	ID              string     `json:"id"`
+	Operation       string     `json:"operation"` // create_cluster, clone_cluster, start_cluster, stop_cluster or maintenance
+	State           string     `json:"state"`     // running, succeeded or failed
+	Error           string     `json:"error,omitempty"`
+	Actor           string     `json:"actor,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	FinishedAt      *time.Time `json:"finished_at,omitempty"`
+	Completed       int        `json:"completed"` // VMs no longer pending
+	Total           int        `json:"total"`
+	VirtualMachines []JobVM    `json:"virtual_machines"`
+}
+
+// JobVM is the progress of a job on one of its virtual machines.
+type JobVM struct {
+	Name  string `json:"name"`
+	State string `json:"state"` // pending, succeeded or failed
+	Error string `json:"error,omitempty"`
+}
@@ -1,22 +1,156 @@
 package contracts
 
+import "time"
+
 // K3sMasterBootstrapConfig contains configuration for bootstrapping K3s master node(s).
 type K3sMasterBootstrapConfig struct {
-	Nodes []K3sNodeConfig `json:"nodes"`
-	Token string          `json:"token"`
+	Nodes        []K3sNodeConfig `json:"nodes"`
+	Token        string          `json:"token"`                   // Cluster token or secret:// reference
+	WaitForVMs   []string        `json:"wait_for_vms,omitempty"`  // VMs that must be running and pass their readiness probes before bootstrapping
+	ReadyTimeout string          `json:"ready_timeout,omitempty"` // How long to wait for wait_for_vms, e.g. "5m" (default: 10m)
+	Registries   *K3sRegistries  `json:"registries,omitempty"`    // Rendered to /etc/rancher/k3s/registries.yaml on every node before install
+	Proxy        *ProxyConfig    `json:"proxy,omitempty"`         // Proxy for the install download, K3s and its containerd
+	// Verify waits, using the kubeconfig of the first master, until every node is Ready and the kube-system pods run.
+	Verify        bool   `json:"verify,omitempty"`
+	VerifyTimeout string `json:"verify_timeout,omitempty"` // How long to wait for a healthy cluster, e.g. "10m" (default: 5m)
 }
 
 // K3sWorkerBootstrapConfig contains configuration for bootstrapping K3s worker node(s).
 type K3sWorkerBootstrapConfig struct {
-	Nodes     []K3sNodeConfig `json:"nodes"`
-	Token     string          `json:"token"`
-	MasterURL string          `json:"master_url"` // e.g., "https://k3s-master.local:6443" or "https://192.168.122.100:6443"
+	Nodes        []K3sNodeConfig `json:"nodes"`
+	Token        string          `json:"token"`                   // Cluster token or secret:// reference
+	MasterURL    string          `json:"master_url"`              // e.g., "https://k3s-master.local:6443" or "https://192.168.122.100:6443"
+	WaitForVMs   []string        `json:"wait_for_vms,omitempty"`  // VMs that must be running and pass their readiness probes before bootstrapping
+	ReadyTimeout string          `json:"ready_timeout,omitempty"` // How long to wait for wait_for_vms, e.g. "5m" (default: 10m)
+	Registries   *K3sRegistries  `json:"registries,omitempty"`    // Rendered to /etc/rancher/k3s/registries.yaml on every node before install
+	Proxy        *ProxyConfig    `json:"proxy,omitempty"`         // Proxy for the install download, K3s and its containerd
+	// VerifyMaster is a master whose kubeconfig is used to wait until the workers joined,
+	// every node is Ready and the kube-system pods run.
+	VerifyMaster  *K3sNodeConfig `json:"verify_master,omitempty"`
+	VerifyTimeout string         `json:"verify_timeout,omitempty"` // How long to wait for a healthy cluster, e.g. "10m" (default: 5m)
 }
 
 // K3sNodeConfig contains SSH connection details for a node.
 type K3sNodeConfig struct {
-	Host    string `json:"host"`               // IP address, hostname, or domain
-	SSHUser string `json:"ssh_user"`           // SSH username
-	SSHKey  string `json:"ssh_key"`            // Path to SSH private key
-	SSHPort int    `json:"ssh_port,omitempty"` // SSH port (default: 22)
+	Host          string `json:"host"`                      // IP address, hostname, or domain
+	SSHUser       string `json:"ssh_user"`                  // SSH username
+	SSHKey        string `json:"ssh_key"`                   // Path to SSH private key
+	SSHPrivateKey string `json:"ssh_private_key,omitempty"` // secret:// reference to PEM key material, used instead of ssh_key
+	SSHPort       int    `json:"ssh_port,omitempty"`        // SSH port (default: 22)
+	// GuestAgentVM runs the commands for this node through the QEMU guest agent of the named VM,
+	// over the hypervisor connection, instead of SSH. For VMs whose network homonculus cannot reach.
+	GuestAgentVM string `json:"guest_agent_vm,omitempty"`
+}
+
+// K3sRegistries configures the container registries of K3s nodes, such as Harbor or pull-through caches.
+// See https://docs.k3s.io/installation/private-registry.
+type K3sRegistries struct {
+	Mirrors map[string]K3sRegistryMirror `json:"mirrors,omitempty"` // By registry name, e.g. "docker.io", or "*" for every registry
+	Configs map[string]K3sRegistryConfig `json:"configs,omitempty"` // By registry host, e.g. "harbor.lab:443"
+}
+
+// K3sRegistryMirror lists the endpoints images of a registry are pulled from, in order.
+type K3sRegistryMirror struct {
+	Endpoints []string          `json:"endpoints"`
+	Rewrite   map[string]string `json:"rewrite,omitempty"` // Regular expressions rewriting image names for the mirror
+}
+
+// K3sRegistryConfig holds the credentials and TLS settings of a registry host.
+type K3sRegistryConfig struct {
+	Auth *K3sRegistryAuth `json:"auth,omitempty"`
+	TLS  *K3sRegistryTLS  `json:"tls,omitempty"`
+}
+
+// K3sRegistryAuth authenticates to a registry with a username and password, or a token.
+type K3sRegistryAuth struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"` // Password or secret:// reference
+	Token    string `json:"token,omitempty"`    // Identity token or secret:// reference
+}
+
+// K3sRegistryTLS configures TLS to a registry. The files must exist on the nodes.
+type K3sRegistryTLS struct {
+	CAFile             string `json:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+// K3sMasterBootstrapResponse echoes a master bootstrap config, with the cluster health if it was verified.
+type K3sMasterBootstrapResponse struct {
+	K3sMasterBootstrapConfig
+	Health *K3sClusterHealth `json:"health,omitempty"`
+}
+
+// K3sWorkerBootstrapResponse echoes a worker bootstrap config, with the cluster health if it was verified.
+type K3sWorkerBootstrapResponse struct {
+	K3sWorkerBootstrapConfig
+	Health *K3sClusterHealth `json:"health,omitempty"`
+}
+
+// K3sClusterHealth is the state of the nodes and kube-system pods of a cluster, as reported by its API server.
+type K3sClusterHealth struct {
+	Healthy bool           `json:"healthy"`
+	Nodes   []K3sNodeState `json:"nodes"`
+	Pods    []K3sPodState  `json:"pods"`
+	Missing []string       `json:"missing,omitempty"` // Bootstrapped hosts not registered as nodes
+}
+
+// K3sNodeState is the readiness of a cluster node.
+type K3sNodeState struct {
+	Name      string   `json:"name"`
+	Addresses []string `json:"addresses,omitempty"`
+	Ready     bool     `json:"ready"`
+}
+
+// K3sPodState is the state of a kube-system pod.
+type K3sPodState struct {
+	Name  string `json:"name"`
+	Phase string `json:"phase"`
+	Ready bool   `json:"ready"` // Running with all containers ready, or completed
+}
+
+// K3sEtcdSnapshotRequest names a K3s server node and an etcd snapshot on it.
+type K3sEtcdSnapshotRequest struct {
+	Node K3sNodeConfig `json:"node"`
+	// Name of the snapshot; when saving, the prefix K3s appends the node name and a timestamp to (default: backup-<time>)
+	Name string `json:"name,omitempty"`
+	// Cluster is a named VM cluster to snapshot along with etcd when saving, under the name of the request
+	Cluster string `json:"cluster,omitempty"`
+}
+
+// K3sEtcdRestoreRequest restores a cluster from a local etcd snapshot of its first server node.
+type K3sEtcdRestoreRequest struct {
+	Nodes []K3sNodeConfig `json:"nodes"` // Every server node; the first is reset from the snapshot, the others rejoin
+	Name  string          `json:"name"`  // Snapshot file name, as listed
+}
+
+// K3sRemoveNodeRequest removes a node from a cluster: it is cordoned and drained, its node object
+// is deleted, K3s is uninstalled from it and, with delete_vm, its virtual machine is deleted.
+type K3sRemoveNodeRequest struct {
+	Master       K3sNodeConfig `json:"master"`                  // Server node kubectl runs on
+	Node         K3sNodeConfig `json:"node"`                    // Node to remove
+	NodeName     string        `json:"node_name,omitempty"`     // Kubernetes node name (default: hostname of the node)
+	DrainTimeout string        `json:"drain_timeout,omitempty"` // How long to wait for pods to be evicted, e.g. "10m" (default: 5m)
+	DeleteVM     string        `json:"delete_vm,omitempty"`     // Virtual machine of the node to delete once it is removed
+}
+
+// K3sRemoveNodeResponse reports a removed node.
+type K3sRemoveNodeResponse struct {
+	NodeName  string `json:"node_name"`
+	VMDeleted string `json:"vm_deleted,omitempty"`
+}
+
+// K3sEtcdSnapshot describes an etcd snapshot of a K3s server.
+type K3sEtcdSnapshot struct {
+	Name      string    `json:"name"`
+	Location  string    `json:"location"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+// K3sEtcdSnapshotsResponse lists etcd snapshots, and the VM snapshot taken along with them, if any.
+type K3sEtcdSnapshotsResponse struct {
+	Snapshots  []K3sEtcdSnapshot `json:"snapshots"`
+	VMSnapshot string            `json:"vm_snapshot,omitempty"`
 }
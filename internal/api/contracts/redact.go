@@ -0,0 +1,204 @@
+package contracts
+
+import (
+	"log/slog"
+	"slices"
+
+	"github.com/terabiome/homonculus/pkg/secrets"
+)
+
+// redactSecret masks a sensitive value. secret:// references are kept, since they reveal nothing.
+func redactSecret(value string) string {
+	if value == "" || secrets.IsRef(value) {
+		return value
+	}
+	return secrets.Mask
+}
+
+// Redacted returns a copy of the user config with the password masked.
+func (c UserConfig) Redacted() UserConfig {
+	c.Password = redactSecret(c.Password)
+	return c
+}
+
+// Redacted returns a copy of the request with user and console passwords masked.
+func (r CreateVMRequest) Redacted() CreateVMRequest {
+	r.UserConfigs = slices.Clone(r.UserConfigs)
+	for i := range r.UserConfigs {
+		r.UserConfigs[i] = r.UserConfigs[i].Redacted()
+	}
+	if r.Graphics != nil {
+		graphics := *r.Graphics
+		graphics.Password = redactSecret(graphics.Password)
+		r.Graphics = &graphics
+	}
+	return r
+}
+
+// Redacted returns a copy of the request with the secrets of every VM masked.
+func (r CreateClusterRequest) Redacted() CreateClusterRequest {
+	r.VirtualMachines = slices.Clone(r.VirtualMachines)
+	for i := range r.VirtualMachines {
+		r.VirtualMachines[i] = r.VirtualMachines[i].Redacted()
+	}
+	return r
+}
+
+// Redacted returns a copy of the request with the secrets of the template masked.
+func (r CreateFleetRequest) Redacted() CreateFleetRequest {
+	r.Template = r.Template.Redacted()
+	return r
+}
+
+// Redacted returns a copy of the target with user passwords masked.
+func (t TargetVMSpec) Redacted() TargetVMSpec {
+	t.UserConfigs = slices.Clone(t.UserConfigs)
+	for i := range t.UserConfigs {
+		t.UserConfigs[i] = t.UserConfigs[i].Redacted()
+	}
+	return t
+}
+
+// Redacted returns a copy of the request with the secrets of every target masked.
+func (r CloneClusterRequest) Redacted() CloneClusterRequest {
+	r.TargetVMs = slices.Clone(r.TargetVMs)
+	for i := range r.TargetVMs {
+		r.TargetVMs[i] = r.TargetVMs[i].Redacted()
+	}
+	return r
+}
+
+// Redacted returns a copy of the node config with inline key material masked.
+func (c K3sNodeConfig) Redacted() K3sNodeConfig {
+	c.SSHPrivateKey = redactSecret(c.SSHPrivateKey)
+	return c
+}
+
+// Redacted returns a copy of the config with the token, node keys and registry credentials masked.
+func (c K3sMasterBootstrapConfig) Redacted() K3sMasterBootstrapConfig {
+	c.Token = redactSecret(c.Token)
+	c.Nodes = redactNodes(c.Nodes)
+	c.Registries = c.Registries.Redacted()
+	return c
+}
+
+// Redacted returns a copy of the config with the token, node keys and registry credentials masked.
+func (c K3sWorkerBootstrapConfig) Redacted() K3sWorkerBootstrapConfig {
+	c.Token = redactSecret(c.Token)
+	c.Nodes = redactNodes(c.Nodes)
+	c.Registries = c.Registries.Redacted()
+	if c.VerifyMaster != nil {
+		master := c.VerifyMaster.Redacted()
+		c.VerifyMaster = &master
+	}
+	return c
+}
+
+// Redacted returns a copy of the request with the node key masked.
+func (r K3sEtcdSnapshotRequest) Redacted() K3sEtcdSnapshotRequest {
+	r.Node = r.Node.Redacted()
+	return r
+}
+
+// Redacted returns a copy of the request with the node keys masked.
+func (r K3sEtcdRestoreRequest) Redacted() K3sEtcdRestoreRequest {
+	r.Nodes = redactNodes(r.Nodes)
+	return r
+}
+
+// Redacted returns a copy of the request with the node keys masked.
+func (r K3sRemoveNodeRequest) Redacted() K3sRemoveNodeRequest {
+	r.Master = r.Master.Redacted()
+	r.Node = r.Node.Redacted()
+	return r
+}
+
+// Redacted returns a copy of the registries with passwords and tokens masked.
+func (r *K3sRegistries) Redacted() *K3sRegistries {
+	if r == nil {
+		return nil
+	}
+	redacted := *r
+	redacted.Configs = make(map[string]K3sRegistryConfig, len(r.Configs))
+	for host, config := range r.Configs {
+		if config.Auth != nil {
+			auth := *config.Auth
+			auth.Password = redactSecret(auth.Password)
+			auth.Token = redactSecret(auth.Token)
+			config.Auth = &auth
+		}
+		redacted.Configs[host] = config
+	}
+	return &redacted
+}
+
+func redactNodes(nodes []K3sNodeConfig) []K3sNodeConfig {
+	result := make([]K3sNodeConfig, len(nodes))
+	for i, node := range nodes {
+		result[i] = node.Redacted()
+	}
+	return result
+}
+
+// The LogValue methods make slog log the redacted form. The local types drop the
+// LogValue method so the redacted copy is not resolved again.
+
+func (c UserConfig) LogValue() slog.Value {
+	type plain UserConfig
+	return slog.AnyValue(plain(c.Redacted()))
+}
+
+func (r CreateVMRequest) LogValue() slog.Value {
+	type plain CreateVMRequest
+	return slog.AnyValue(plain(r.Redacted()))
+}
+
+func (r CreateClusterRequest) LogValue() slog.Value {
+	type plain CreateClusterRequest
+	return slog.AnyValue(plain(r.Redacted()))
+}
+
+func (r CreateFleetRequest) LogValue() slog.Value {
+	type plain CreateFleetRequest
+	return slog.AnyValue(plain(r.Redacted()))
+}
+
+func (t TargetVMSpec) LogValue() slog.Value {
+	type plain TargetVMSpec
+	return slog.AnyValue(plain(t.Redacted()))
+}
+
+func (r CloneClusterRequest) LogValue() slog.Value {
+	type plain CloneClusterRequest
+	return slog.AnyValue(plain(r.Redacted()))
+}
+
+func (c K3sNodeConfig) LogValue() slog.Value {
+	type plain K3sNodeConfig
+	return slog.AnyValue(plain(c.Redacted()))
+}
+
+func (c K3sMasterBootstrapConfig) LogValue() slog.Value {
+	type plain K3sMasterBootstrapConfig
+	return slog.AnyValue(plain(c.Redacted()))
+}
+
+func (c K3sWorkerBootstrapConfig) LogValue() slog.Value {
+	type plain K3sWorkerBootstrapConfig
+	return slog.AnyValue(plain(c.Redacted()))
+}
+
+func (r K3sEtcdSnapshotRequest) LogValue() slog.Value {
+	type plain K3sEtcdSnapshotRequest
+	return slog.AnyValue(plain(r.Redacted()))
+}
+
+func (r K3sEtcdRestoreRequest) LogValue() slog.Value {
+	type plain K3sEtcdRestoreRequest
+	return slog.AnyValue(plain(r.Redacted()))
+}
+
+func (r K3sRemoveNodeRequest) LogValue() slog.Value {
+	type plain K3sRemoveNodeRequest
+	return slog.AnyValue(plain(r.Redacted()))
+}
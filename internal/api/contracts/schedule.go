@@ -0,0 +1,31 @@
+package contracts
+
+import "time"
+
+// CreateScheduleRequest registers a recurring action on the virtual machines matching a label selector.
+type CreateScheduleRequest struct {
+	Cron     string `json:"cron"`               // Five-field cron expression or @daily, @hourly, ... (e.g., "0 1 * * *")
+	Timezone string `json:"timezone,omitempty"` // IANA time zone of the cron expression (default: server local time)
+	Action   string `json:"action"`             // start, stop or snapshot
+	Selector string `json:"selector"`           // Label selector of the target VMs (e.g., "cluster=lab")
+	Retain   int    `json:"retain,omitempty"`   // Snapshots kept per VM by a snapshot schedule (default: all)
+}
+
+// Schedule describes a registered recurring action.
+type Schedule struct {
+	ID        string     `json:"id"`
+	Cron      string     `json:"cron"`
+	Timezone  string     `json:"timezone,omitempty"`
+	Action    string     `json:"action"`
+	Selector  string     `json:"selector"`
+	Retain    int        `json:"retain,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	NextRun   *time.Time `json:"next_run,omitempty"`
+}
+
+// ListSchedulesResponse contains the registered schedules.
+type ListSchedulesResponse struct {
+	Schedules []Schedule `json:"schedules"`
+}
@@ -1,8 +1,22 @@
 package contracts
 
+// Correlation headers identify the API request a response answers. Operators find its trace
+// by the trace ID and its events by both IDs; GenericResponse repeats them in the body.
+const (
+	TraceIDHeader = "X-Homonculus-Trace-ID"
+	JobIDHeader   = "X-Homonculus-Job-ID"
+)
+
 // UserConfig represents a user account configuration for cloud-init.
 type UserConfig struct {
 	Username          string   `json:"username"`
 	SSHAuthorizedKeys []string `json:"ssh_authorized_keys"`
-	Password          string   `json:"passwd"`
+	Password          string   `json:"passwd"` // Password hash or secret:// reference
+}
+
+// ProxyConfig routes outbound HTTP(S) traffic through a proxy, for networks behind corporate proxies.
+type ProxyConfig struct {
+	HTTPProxy  string `json:"http_proxy,omitempty"`  // e.g. "http://proxy.corp:3128"
+	HTTPSProxy string `json:"https_proxy,omitempty"` // e.g. "http://proxy.corp:3128"
+	NoProxy    string `json:"no_proxy,omitempty"`    // Comma-separated hosts, domains and CIDRs reached directly
 }
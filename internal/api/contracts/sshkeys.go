@@ -0,0 +1,25 @@
+package contracts
+
+import "time"
+
+// GenerateSSHKeyRequest generates an ed25519 keypair on the server.
+// Stored keys keep the private key encrypted on the server; otherwise it is returned once and discarded.
+type GenerateSSHKeyRequest struct {
+	Name  string `json:"name"`
+	Store bool   `json:"store,omitempty"` // requires state_encryption in the server configuration
+}
+
+// SSHKey describes a generated keypair. PrivateKey is only set when the key is handed out.
+type SSHKey struct {
+	Name        string    `json:"name"`
+	PublicKey   string    `json:"public_key"`
+	Fingerprint string    `json:"fingerprint"`
+	Stored      bool      `json:"stored"`
+	CreatedAt   time.Time `json:"created_at"`
+	PrivateKey  string    `json:"private_key,omitempty"`
+}
+
+// ListSSHKeysResponse contains the known keypairs, without private keys.
+type ListSSHKeysResponse struct {
+	SSHKeys []SSHKey `json:"ssh_keys"`
+}
@@ -0,0 +1,123 @@
+package contracts
+
+import "time"
+
+// HostCapacity represents the resource inventory of a hypervisor host.
+type HostCapacity struct {
+	Host               string                `json:"host"`
+	TotalMemoryKiB     uint64                `json:"total_memory_kib"`
+	FreeMemoryKiB      uint64                `json:"free_memory_kib"`
+	CPUs               uint                  `json:"cpus"`
+	AllocatedMemoryKiB uint64                `json:"allocated_memory_kib"`
+	AllocatedVCPUs     uint                  `json:"allocated_vcpus"`
+	DefinedVMs         int                   `json:"defined_vms"`
+	RunningVMs         int                   `json:"running_vms"`
+	LoadAverage        float64               `json:"load_average"`
+	NUMANodes          []NUMANode            `json:"numa_nodes,omitempty"`
+	StoragePools       []StoragePoolCapacity `json:"storage_pools,omitempty"`
+}
+
+// NUMANode represents one NUMA cell of a hypervisor host.
+type NUMANode struct {
+	ID            int       `json:"id"`
+	MemoryKiB     uint64    `json:"memory_kib"`
+	FreeMemoryKiB uint64    `json:"free_memory_kib"`
+	CPUs          []HostCPU `json:"cpus"`
+}
+
+// HostCPU represents one logical CPU of a hypervisor host.
+type HostCPU struct {
+	ID       int    `json:"id"`
+	SocketID int    `json:"socket_id"`
+	CoreID   int    `json:"core_id"`
+	Siblings string `json:"siblings,omitempty"`
+}
+
+// StoragePoolCapacity represents the capacity of a libvirt storage pool.
+type StoragePoolCapacity struct {
+	Name            string `json:"name"`
+	Active          bool   `json:"active"`
+	CapacityBytes   uint64 `json:"capacity_bytes"`
+	AllocationBytes uint64 `json:"allocation_bytes"`
+	AvailableBytes  uint64 `json:"available_bytes"`
+}
+
+// Event represents a recorded lifecycle event of a VM or of a background job.
+type Event struct {
+	ID      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	VM      string    `json:"vm,omitempty"`
+	Host    string    `json:"host,omitempty"`
+	Actor   string    `json:"actor,omitempty"`
+	Message string    `json:"message"`
+	Error   string    `json:"error,omitempty"`
+	TraceID string    `json:"trace_id,omitempty"`
+	JobID   string    `json:"job_id,omitempty"`
+}
+
+// GarbageCollectResponse lists the failed VMs a garbage collection pass removed, the ones
+// started since and no longer tracked, and the ones it failed to remove.
+type GarbageCollectResponse struct {
+	Collected []string `json:"collected"`
+	Adopted   []string `json:"adopted,omitempty"`
+	Failed    []string `json:"failed,omitempty"`
+}
+
+// TemplateVersion describes a loaded version of the libvirt and cloud-init templates.
+type TemplateVersion struct {
+	Name     string            `json:"name"` // blue or green
+	Active   bool              `json:"active"`
+	LoadedAt time.Time         `json:"loaded_at"`
+	Digests  map[string]string `json:"digests"` // sha256 of every template source, by template name
+}
+
+// TemplateDiff is the outcome of canary-rendering a template of a new VM with the candidate version.
+type TemplateDiff struct {
+	VM             string    `json:"vm"`
+	Template       string    `json:"template"`
+	Time           time.Time `json:"time"`
+	Identical      bool      `json:"identical"`
+	Diff           string    `json:"diff,omitempty"` // unified diff from the active to the candidate rendering
+	CandidateError string    `json:"candidate_error,omitempty"`
+}
+
+// TemplateRolloutResponse lists the loaded template versions, the active one first, and the
+// canary renderings of new VMs since the candidate version was staged.
+type TemplateRolloutResponse struct {
+	Versions []TemplateVersion `json:"versions"`
+	Diffs    []TemplateDiff    `json:"diffs"`
+}
+
+// MaintenanceRequest puts a hypervisor host into maintenance or takes it out. Entering with
+// mode "migrate" live-migrates the VMs placed on the host to other hosts, "shutdown" shuts
+// them down gracefully and "none" (the default) leaves them running; exiting reverses it.
+type MaintenanceRequest struct {
+	Host            string `json:"host"`
+	Enabled         bool   `json:"enabled"`
+	Mode            string `json:"mode,omitempty"`
+	ShutdownTimeout string `json:"shutdown_timeout,omitempty"` // How long to wait for VMs to shut off, e.g. "2m" (default: 5m)
+}
+
+// ReadinessResponse reports whether homonculus can reach the libvirt daemons of its hosts.
+// While any is unreachable, the server runs degraded and requests for that host fail with 503.
+type ReadinessResponse struct {
+	Ready bool             `json:"ready"`
+	Hosts []HostConnection `json:"hosts"`
+}
+
+// HostConnection reports whether the libvirt connection to a host worked at the last check.
+type HostConnection struct {
+	Host      string `json:"host"`
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+}
+
+// HostMaintenance describes a hypervisor host in maintenance.
+type HostMaintenance struct {
+	Host      string            `json:"host"`
+	Mode      string            `json:"mode"`
+	StartedAt time.Time         `json:"started_at"`
+	Migrated  map[string]string `json:"migrated,omitempty"` // destination host by VM name
+	Stopped   []string          `json:"stopped,omitempty"`
+}
@@ -1,6 +1,10 @@
 package contracts
 
-import "github.com/terabiome/homonculus/pkg/constants"
+import (
+	"time"
+
+	"github.com/terabiome/homonculus/pkg/constants"
+)
 
 // NUMAMemory contains NUMA memory tuning configuration.
 type NUMAMemory struct {
@@ -8,27 +12,133 @@ type NUMAMemory struct {
 	Mode    string `json:"mode,omitempty"` // strict, preferred, or interleave (default: strict)
 }
 
+// Hugepages contains hugepage memory backing configuration.
+type Hugepages struct {
+	PageSize string `json:"page_size"`         // Hugepage size (e.g., "2M", "1G")
+	Nodeset  string `json:"nodeset,omitempty"` // NUMA node set to allocate pages from (default: all)
+}
+
 // VMTuning contains virtual machine performance tuning configuration.
 type VMTuning struct {
+	AutoPin        bool        `json:"auto_pin,omitempty"`        // Compute NUMA-aware pinning automatically
 	VCPUPins       []string    `json:"vcpu_pins,omitempty"`       // CPU pinning: list of CPU sets
 	EmulatorCPUSet string      `json:"emulator_cpuset,omitempty"` // CPU set for QEMU/KVM emulator threads
+	IOThreads      int         `json:"iothreads,omitempty"`       // Number of I/O threads; the OS disk is served by the first
+	IOThreadPins   []string    `json:"iothread_pins,omitempty"`   // CPU pinning of I/O threads: list of CPU sets
 	NUMAMemory     *NUMAMemory `json:"numa_memory,omitempty"`     // NUMA memory placement
+	Hugepages      *Hugepages  `json:"hugepages,omitempty"`       // Back guest memory with hugepages
+}
+
+// HostDevice selects a host PCI device to pass through.
+type HostDevice struct {
+	Address      string `json:"address,omitempty"`       // PCI address (e.g., "0000:41:00.0")
+	VendorDevice string `json:"vendor_device,omitempty"` // vendor:device id (e.g., "10de:2204"), first unassigned match is used
+}
+
+// USBDevice selects a host USB device to pass through.
+type USBDevice struct {
+	VendorProduct string `json:"vendor_product"` // vendor:product id (e.g., "1050:0407")
+}
+
+// SerialDevice describes an extra guest serial port.
+type SerialDevice struct {
+	Type string `json:"type"`           // pty, tcp, unix, file, or dev (default: pty)
+	Path string `json:"path,omitempty"` // Socket, file or host device path for unix, file and dev
+	Host string `json:"host,omitempty"` // Listen address for tcp (default: 127.0.0.1)
+	Port int    `json:"port,omitempty"` // Listen port for tcp
+}
+
+// DiskOptions trade provisioning speed against runtime performance of the qcow2 disk of a virtual machine.
+type DiskOptions struct {
+	Preallocation   string `json:"preallocation,omitempty"`    // off, metadata, falloc or full (default: off)
+	ClusterSize     string `json:"cluster_size,omitempty"`     // Power of two from 512 to 2M, e.g. "64k" or "2M" (default: 64k)
+	CompressionType string `json:"compression_type,omitempty"` // zlib or zstd, used for compressed clusters (default: zlib)
+}
+
+// Watchdog describes an emulated hardware watchdog that acts when the guest stops feeding it.
+type Watchdog struct {
+	Model  string `json:"model,omitempty"`  // i6300esb, ib700 or itco (default: i6300esb; itco requires q35)
+	Action string `json:"action,omitempty"` // reset, shutdown, poweroff, pause, dump, inject-nmi or none (default: reset)
+}
+
+// CDROM describes an extra CD-ROM drive. Extra drives are attached as sdd, sde, ... in order.
+type CDROM struct {
+	Path string `json:"path,omitempty"` // ISO to insert, e.g. driver or installer media (default: empty drive)
+}
+
+// Graphics contains the graphical console configuration of a virtual machine.
+type Graphics struct {
+	Type     string `json:"type"`               // none, vnc, or spice (default: vnc)
+	Listen   string `json:"listen,omitempty"`   // Listen address (default: 0.0.0.0)
+	Port     int    `json:"port,omitempty"`     // Fixed port (default: allocated automatically)
+	Password string `json:"password,omitempty"` // Console password or secret:// reference (VNC allows at most 8 characters)
+}
+
+// Netboot configures a virtual machine that boots from the network instead of a base image.
+type Netboot struct {
+	Server *NetbootServer `json:"server,omitempty"` // Run a DHCP/TFTP helper on the VM's bridge (default: rely on an existing PXE server)
+}
+
+// NetbootServer configures the dnsmasq DHCP/TFTP helper started on the hypervisor for network-booted VMs.
+// One helper runs per host and bridge; it is stopped once the last VM using it is deleted.
+type NetbootServer struct {
+	DHCPRange string `json:"dhcp_range"` // dnsmasq range (e.g., "192.168.100.50,192.168.100.150,12h")
+	TFTPRoot  string `json:"tftp_root"`  // Host directory served over TFTP
+	BootFile  string `json:"boot_file"`  // Boot file offered to PXE clients, relative to tftp_root (e.g., "pxelinux.0")
+}
+
+// GraphicsInfo describes a graphical console of a virtual machine.
+type GraphicsInfo struct {
+	Type   string `json:"type"`
+	Listen string `json:"listen,omitempty"`
+	Port   int    `json:"port,omitempty"` // Allocated port, only known while the VM runs
+}
+
+// ReadinessProbe checks that the guest OS of a started virtual machine is ready to be used.
+// A VM is ready once all of its probes succeeded; tcp, ssh and relative http probes target the VM's IP address.
+type ReadinessProbe struct {
+	Type    string `json:"type"`              // tcp, ssh, http or cloudinit (guest agent reports cloud-init finished without errors)
+	Port    int    `json:"port,omitempty"`    // tcp (required), ssh (default: 22) or http (default: 80)
+	URL     string `json:"url,omitempty"`     // http: a path such as "/healthz" on the VM address; 2xx and 3xx succeed
+	Timeout string `json:"timeout,omitempty"` // Per attempt, e.g. "3s" (default: 5s)
+}
+
+// Readiness reports the readiness probes of a virtual machine since it was last started.
+type Readiness struct {
+	Ready     bool          `json:"ready"`
+	CheckedAt *time.Time    `json:"checked_at,omitempty"` // Unset until the probes were evaluated
+	Probes    []ProbeResult `json:"probes,omitempty"`
+}
+
+// ProbeResult is the outcome of the last attempt of a readiness probe.
+type ProbeResult struct {
+	Type    string `json:"type"`
+	Target  string `json:"target"`
+	Ready   bool   `json:"ready"`
+	Message string `json:"message,omitempty"`
 }
 
 // HostBindMount contains list of mount points from host on virtual machines
 type HostBindMount struct {
 	SourceDir string `json:"source_dir"`
 	TargetDir string `json:"target_dir"`
+	Driver    string `json:"driver,omitempty"`    // virtiofs or 9p (default: virtiofs)
+	ReadOnly  bool   `json:"read_only,omitempty"` // Mount read-only inside the guest
 }
 
 // CreateVMRequest contains the configuration for creating a single virtual machine.
 type CreateVMRequest struct {
 	Name                   string                   `json:"name"`
+	NamePrefix             string                   `json:"name_prefix,omitempty"` // With count, expands into VMs <prefix>-1..<prefix>-<count>; paths may use {name} and {index}
+	Count                  int                      `json:"count,omitempty"`       // Number of VMs to expand name_prefix into
 	VCPUCount              int                      `json:"vcpu_count"`
 	MemoryMB               int64                    `json:"memory_mb"`
+	Memory                 string                   `json:"memory,omitempty"` // Instead of memory_mb, e.g. "16Gi" or "8G"; rounded up to whole MiB
 	DiskPath               string                   `json:"disk_path"`
 	DiskSizeGB             int64                    `json:"disk_size_gb"`
+	DiskSize               string                   `json:"disk_size,omitempty"` // Instead of disk_size_gb, e.g. "100Gi" or "100G"; rounded up to whole GiB
 	BaseImagePath          string                   `json:"base_image_path"`
+	DiskOptions            *DiskOptions             `json:"disk_options,omitempty"` // qemu-img options of the disk
 	BridgeNetworkInterface string                   `json:"bridge_network_interface"`
 	CloudInitISOPath       string                   `json:"cloud_init_iso_path"`
 	HostBindMounts         []HostBindMount          `json:"host_bind_mounts"`
@@ -37,7 +147,43 @@ type CreateVMRequest struct {
 	DoPackageUpgrade       bool                     `json:"do_package_upgrade"`
 	UserConfigs            []UserConfig             `json:"user_configs"`
 	Runcmds                []string                 `json:"runcmds"`
-	Tuning                 *VMTuning                `json:"tuning,omitempty"` // VM performance tuning
+	Tuning                 *VMTuning                `json:"tuning,omitempty"`             // VM performance tuning
+	Labels                 map[string]string        `json:"labels,omitempty"`             // Arbitrary key/value labels for selector queries
+	KeepRunning            bool                     `json:"keep_running,omitempty"`       // Reconciler restarts the VM whenever it is found stopped
+	TTL                    string                   `json:"ttl,omitempty"`                // Lifetime after creation, e.g. "4h" (default: unlimited)
+	OnExpiry               string                   `json:"on_expiry,omitempty"`          // delete or stop once the ttl elapsed (default: delete)
+	Host                   string                   `json:"host,omitempty"`               // Hypervisor host to place the VM on (default: scheduled)
+	SpreadGroup            string                   `json:"spread_group,omitempty"`       // VMs of the cluster in the same spread group are placed on different hosts, or rejected
+	ColocateGroup          string                   `json:"colocate_group,omitempty"`     // VMs of the cluster in the same colocate group are placed on one host
+	Firmware               constants.Firmware       `json:"firmware,omitempty"`           // bios or uefi (default: bios)
+	SecureBoot             bool                     `json:"secure_boot,omitempty"`        // Enable UEFI secure boot with enrolled keys (requires uefi and q35)
+	NVRAMPath              string                   `json:"nvram_path,omitempty"`         // UEFI variable store path (default: chosen by libvirt)
+	MachineType            constants.MachineType    `json:"machine_type,omitempty"`       // q35, pc, or container for an LXC system container (default: hypervisor default)
+	TPM                    bool                     `json:"tpm,omitempty"`                // Attach an emulated TPM 2.0 device
+	HostDevices            []HostDevice             `json:"hostdevs,omitempty"`           // Host PCI devices to pass through (e.g., GPUs)
+	USBDevices             []USBDevice              `json:"usb_devices,omitempty"`        // Host USB devices to pass through
+	SerialDevices          []SerialDevice           `json:"serial_devices,omitempty"`     // Extra serial ports, numbered from 1
+	CDROMs                 []CDROM                  `json:"cdroms,omitempty"`             // Extra CD-ROM drives besides the cloud-init ISO
+	Watchdog               *Watchdog                `json:"watchdog,omitempty"`           // Hardware watchdog device
+	OnPoweroff             string                   `json:"on_poweroff,omitempty"`        // destroy, restart, preserve or rename-restart (default: destroy)
+	OnReboot               string                   `json:"on_reboot,omitempty"`          // destroy, restart, preserve or rename-restart (default: restart)
+	OnCrash                string                   `json:"on_crash,omitempty"`           // on_poweroff actions, coredump-destroy or coredump-restart (default: destroy)
+	Graphics               *Graphics                `json:"graphics,omitempty"`           // Graphical console (default: VNC with automatic port)
+	IPFromPool             string                   `json:"ip_from_pool,omitempty"`       // Allocate a static address from the named ip_pools entry (default: DHCP)
+	Proxy                  *ProxyConfig             `json:"proxy,omitempty"`              // Proxy exported to the guest environment and systemd services through cloud-init
+	Netboot                *Netboot                 `json:"netboot,omitempty"`            // Boot from the network; base_image_path is not needed and disk_path may name an empty disk or be omitted
+	ReadinessProbes        []ReadinessProbe         `json:"readiness_probes,omitempty"`   // Checks that the guest is ready, evaluated whenever the VM was started
+	AutoStart              bool                     `json:"auto_start,omitempty"`         // Start the VM once it is defined
+	CleanupOnFailure       *bool                    `json:"cleanup_on_failure,omitempty"` // Roll back disk, ISO and domain if creation fails (default: true)
+}
+
+// CloudInitDocuments are the cloud-init documents a VM was created with. Passwords that are not
+// secret:// references and proxy credentials are masked.
+type CloudInitDocuments struct {
+	VM         string            `json:"vm"`
+	InstanceID string            `json:"instance_id"`
+	RenderedAt time.Time         `json:"rendered_at"`
+	Documents  map[string]string `json:"documents"` // By file name: user-data, meta-data, network-config
 }
 
 // DeleteVMRequest contains the configuration for deleting a single virtual machine.
@@ -47,7 +193,55 @@ type DeleteVMRequest struct {
 
 // StartVMRequest contains the configuration for starting a single virtual machine.
 type StartVMRequest struct {
-	Name string `json:"name"`
+	Name      string   `json:"name"`
+	DependsOn []string `json:"depends_on,omitempty"` // VMs of the request that must be ready before this VM starts
+}
+
+// StopVMRequest contains the configuration for stopping a single virtual machine.
+type StopVMRequest struct {
+	Name      string   `json:"name"`
+	DependsOn []string `json:"depends_on,omitempty"` // VMs of the request that stop only after this VM has shut off
+}
+
+// AttachDevicesRequest contains the devices to hotplug into a virtual machine.
+type AttachDevicesRequest struct {
+	Name          string         `json:"name"`
+	USBDevices    []USBDevice    `json:"usb_devices,omitempty"`
+	SerialDevices []SerialDevice `json:"serial_devices,omitempty"`
+}
+
+// AttachDevicesResponse reports the guest serial ports assigned to attached serial devices.
+type AttachDevicesResponse struct {
+	Name        string `json:"name"`
+	SerialPorts []int  `json:"serial_ports,omitempty"`
+}
+
+// DetachDevicesRequest contains the devices to unplug from a virtual machine.
+type DetachDevicesRequest struct {
+	Name        string      `json:"name"`
+	USBDevices  []USBDevice `json:"usb_devices,omitempty"`
+	SerialPorts []int       `json:"serial_ports,omitempty"`
+}
+
+// InsertMediaRequest contains the ISO to insert into a CD-ROM drive of a virtual machine.
+type InsertMediaRequest struct {
+	Name   string `json:"name"`
+	Device string `json:"device,omitempty"` // Drive target, e.g. "sdd" (default: first extra CD-ROM drive)
+	Path   string `json:"path"`
+}
+
+// AdoptVMRequest selects a libvirt domain defined outside homonculus to take over.
+type AdoptVMRequest struct {
+	Name    string            `json:"name"`
+	Host    string            `json:"host,omitempty"`    // Located by name if omitted
+	Cluster string            `json:"cluster,omitempty"` // Cluster the VM joins, recorded as its cluster label
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// EjectMediaRequest selects the CD-ROM drive of a virtual machine to eject.
+type EjectMediaRequest struct {
+	Name   string `json:"name"`
+	Device string `json:"device,omitempty"` // Drive target, e.g. "sdd" (default: first extra CD-ROM drive)
 }
 
 // QueryVMRequest contains the configuration for querying a single virtual machine.
@@ -65,16 +259,67 @@ type DiskInfo struct {
 
 // VMInfo contains detailed information about a virtual machine.
 type VMInfo struct {
-	Name       string     `json:"name"`
-	UUID       string     `json:"uuid"`
-	State      string     `json:"state"` // running, shutoff, paused, etc. (human-readable for JSON)
-	VCPUCount  uint       `json:"vcpu_count"`
-	MemoryMB   uint       `json:"memory_mb"`
-	Disks      []DiskInfo `json:"disks"`
-	AutoStart  bool       `json:"autostart"`
-	Persistent bool       `json:"persistent"`
-	Hostname   string     `json:"hostname,omitempty"`   // DHCP hostname
-	IPAddress  string     `json:"ip_address,omitempty"` // DHCP IP address
+	Name        string              `json:"name"`
+	Namespace   string              `json:"namespace,omitempty"` // namespace the VM was created in
+	UUID        string              `json:"uuid"`
+	State       string              `json:"state"` // running, shutoff, paused, etc. (human-readable for JSON)
+	VCPUCount   uint                `json:"vcpu_count"`
+	MemoryMB    uint                `json:"memory_mb"`    // Memory currently assigned, in MiB rounded down
+	MemoryBytes uint64              `json:"memory_bytes"` // Memory currently assigned, exactly
+	Disks       []DiskInfo          `json:"disks"`
+	AutoStart   bool                `json:"autostart"`
+	Persistent  bool                `json:"persistent"`
+	Hostname    string              `json:"hostname,omitempty"`   // DHCP hostname
+	IPAddress   string              `json:"ip_address,omitempty"` // DHCP IP address
+	Labels      map[string]string   `json:"labels,omitempty"`
+	Ownership   *Ownership          `json:"ownership,omitempty"` // Only set for domains homonculus defined
+	Host        string              `json:"host,omitempty"`      // Hypervisor host the VM lives on
+	Graphics    []GraphicsInfo      `json:"graphics,omitempty"`
+	Readiness   *Readiness          `json:"readiness,omitempty"` // Only set for VMs with readiness probes
+	Timeline    []ProvisioningPhase `json:"timeline,omitempty"`  // Phases of the last creation, in order
+	Boot        *BootTime           `json:"boot,omitempty"`      // Measured on the last start
+}
+
+// BootTime is how long the last start of a virtual machine took to reach the network and its
+// guest agent. Milestones that were not reached are left out.
+type BootTime struct {
+	StartedAt              time.Time `json:"started_at"`
+	IPAcquiredSeconds      float64   `json:"ip_acquired_seconds,omitempty"`       // Until the first DHCP lease
+	GuestAgentReadySeconds float64   `json:"guest_agent_ready_seconds,omitempty"` // Until the guest agent answered
+	Pending                bool      `json:"pending,omitempty"`                   // Still being measured
+}
+
+// Ownership is the record homonculus keeps in the metadata of every domain it defines.
+type Ownership struct {
+	Cluster   string     `json:"cluster,omitempty"`
+	Namespace string     `json:"namespace,omitempty"`
+	Owner     string     `json:"owner,omitempty"`     // API caller that created the VM
+	SpecHash  string     `json:"spec_hash,omitempty"` // SHA-256 of the create spec
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	Adopted   bool       `json:"adopted,omitempty"` // Defined outside homonculus; its disks survive deletion
+}
+
+// ProvisioningPhase is a phase a virtual machine reached while it was provisioned:
+// validated, disk_created, iso_created, defined, started, ip_acquired, agent_ready or bootstrapped.
+type ProvisioningPhase struct {
+	Phase   string    `json:"phase"`
+	Time    time.Time `json:"time"`
+	Elapsed string    `json:"elapsed,omitempty"` // Since the previous phase, e.g. "42.1s"
+}
+
+// VMStats is one event of a stats stream: the resource usage of a VM since the previous event.
+type VMStats struct {
+	Name            string    `json:"name"`
+	Time            time.Time `json:"time"`
+	IntervalSeconds float64   `json:"interval_seconds"`
+	State           string    `json:"state"`
+	CPUPercent      float64   `json:"cpu_percent"`             // Share of the VM's vCPUs in use, 0-100
+	MemoryMB        uint64    `json:"memory_mb"`               // Memory assigned to the guest
+	MemoryRSSMB     uint64    `json:"memory_rss_mb,omitempty"` // Host memory resident for the VM
+	DiskReadBytes   uint64    `json:"disk_read_bytes"`         // Bytes read from all disks during the interval
+	DiskWriteBytes  uint64    `json:"disk_write_bytes"`        // Bytes written to all disks during the interval
+	NetRxBytes      uint64    `json:"net_rx_bytes"`            // Bytes received on all interfaces during the interval
+	NetTxBytes      uint64    `json:"net_tx_bytes"`            // Bytes sent on all interfaces during the interval
 }
 
 // BaseVMSpec identifies the base virtual machine to clone from.
@@ -82,13 +327,61 @@ type BaseVMSpec struct {
 	Name string `json:"name"`
 }
 
+// NetworkConfig configures the guest network through cloud-init. Without an address the guest uses DHCP.
+type NetworkConfig struct {
+	IPv4Address string   `json:"ipv4_address,omitempty"` // CIDR notation, e.g. 10.0.0.5/24
+	IPv4Gateway string   `json:"ipv4_gateway,omitempty"`
+	Nameservers []string `json:"nameservers,omitempty"`
+}
+
 // TargetVMSpec contains the configuration for a cloned virtual machine.
 // BaseImagePath is populated internally from the base VM's disk and is not part of the contracts.
+// Hostname, user configs, runcmds and network override the cloud-init settings of the base VM.
 type TargetVMSpec struct {
-	Name          string `json:"name"`
-	VCPUCount     int    `json:"vcpu_count"`
-	MemoryMB      int64  `json:"memory_mb"`
-	DiskPath      string `json:"disk_path"`
-	DiskSizeGB    int64  `json:"disk_size_gb"`
-	BaseImagePath string `json:"-"`
+	Name          string         `json:"name"`
+	VCPUCount     int            `json:"vcpu_count"`
+	MemoryMB      int64          `json:"memory_mb"`
+	Memory        string         `json:"memory,omitempty"` // Instead of memory_mb, e.g. "16Gi"
+	DiskPath      string         `json:"disk_path"`
+	DiskSizeGB    int64          `json:"disk_size_gb"`
+	DiskSize      string         `json:"disk_size,omitempty"` // Instead of disk_size_gb, e.g. "100Gi"
+	BaseImagePath string         `json:"-"`
+	AutoStart     bool           `json:"auto_start,omitempty"` // Start the clone once it is defined
+	Hostname      string         `json:"hostname,omitempty"`
+	UserConfigs   []UserConfig   `json:"user_configs,omitempty"`
+	Runcmds       []string       `json:"runcmds,omitempty"`
+	Network       *NetworkConfig `json:"network,omitempty"`
+}
+
+// DomainDrift is a field in which a defined domain differs from the domain its spec renders to.
+type DomainDrift struct {
+	Field string `json:"field"`
+	Spec  string `json:"spec"`
+	Live  string `json:"live"`
+}
+
+// DomainDiffResponse compares the domain a VM spec renders to with the domain currently
+// defined. Undefined VMs report no drift.
+type DomainDiffResponse struct {
+	VM          string        `json:"vm"`
+	Host        string        `json:"host"`
+	Defined     bool          `json:"defined"`
+	Drift       []DomainDrift `json:"drift"`
+	RenderedXML string        `json:"rendered_xml"`
+}
+
+// ConsoleTicketRequest asks for temporary access to the graphical console of a virtual machine.
+type ConsoleTicketRequest struct {
+	TTL string `json:"ttl,omitempty"` // How long the password stays valid, e.g. "5m" (default: 5m, at most 1h)
+}
+
+// ConsoleTicketResponse is what a VNC or SPICE client needs to connect to a graphical console
+// until the ticket expires.
+type ConsoleTicketResponse struct {
+	VM        string    `json:"vm"`
+	Type      string    `json:"type"`
+	Host      string    `json:"host"`
+	Port      int       `json:"port"`
+	Password  string    `json:"password"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"archive/zip"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Artifacts handles GET /{name}/artifacts requests, returning a zip of the domain XML, cloud-init
+// files and provisioning log of a VM for debugging broken nodes
+func (h *VirtualMachine) Artifacts(writer http.ResponseWriter, request *http.Request) {
+	name := request.PathValue("name")
+
+	artifacts, err := h.vmService.GetVirtualMachineArtifacts(request.Context(), name)
+	if err != nil {
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to collect virtual machine artifacts",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/zip")
+	writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"-artifacts.zip"))
+	writer.WriteHeader(http.StatusOK)
+
+	archive := zip.NewWriter(writer)
+	modified := time.Now()
+	for _, artifact := range artifacts {
+		file, err := archive.CreateHeader(&zip.FileHeader{Name: name + "/" + artifact.Name, Method: zip.Deflate, Modified: modified})
+		if err == nil {
+			_, err = file.Write(artifact.Data)
+		}
+		if err != nil {
+			// The status is already sent; the client sees a truncated archive.
+			h.logger.Warn("failed to write virtual machine artifacts", slog.String("vm", name), slog.String("error", err.Error()))
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		h.logger.Warn("failed to write virtual machine artifacts", slog.String("vm", name), slog.String("error", err.Error()))
+	}
+}
+
+// CloudInit handles GET /{name}/cloud-init requests, returning the redacted cloud-init documents
+// recorded when the VM was created
+func (h *VirtualMachine) CloudInit(writer http.ResponseWriter, request *http.Request) {
+	name := request.PathValue("name")
+
+	documents, found, err := h.vmService.GetCloudInit(name)
+	if err != nil {
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to load cloud-init documents",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if !found {
+		writeResult(writer, http.StatusNotFound, GenericResponse{
+			Body:    nil,
+			Message: "no cloud-init documents recorded for virtual machine " + name,
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    h.spAdapter.AdaptCloudInitToAPI(documents),
+		Message: "retrieved cloud-init documents successfully",
+	})
+}
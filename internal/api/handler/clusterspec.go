@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"net/http"
+)
+
+// ExportClusterSpec handles GET /clusters/{name}/spec requests to reconstruct the spec of a
+// cluster from its live VMs. The spec is returned as a YAML create cluster request, unwrapped,
+// so it can be edited and applied elsewhere.
+func (h *VirtualMachine) ExportClusterSpec(writer http.ResponseWriter, request *http.Request) {
+	name := request.PathValue("name")
+
+	cluster, found, err := h.vmService.ExportClusterSpec(request.Context(), name)
+	if err != nil {
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to export cluster spec",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if !found {
+		writeResult(writer, http.StatusNotFound, GenericResponse{
+			Body:    nil,
+			Message: "cluster not found",
+		})
+		return
+	}
+
+	data, err := marshalYAML(h.spAdapter.AdaptCreateClusterToAPI(cluster))
+	if err != nil {
+		writeResult(writer, http.StatusInternalServerError, GenericResponse{
+			Body:    nil,
+			Message: "failed to encode cluster spec",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/yaml")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(data)
+}
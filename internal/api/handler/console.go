@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/terabiome/homonculus/internal/api/contracts"
+)
+
+const (
+	defaultConsoleTicketTTL = 5 * time.Minute
+	maxConsoleTicketTTL     = time.Hour
+)
+
+// consoleUpgrader accepts noVNC clients, which negotiate the "binary" subprotocol.
+var consoleUpgrader = websocket.Upgrader{
+	ReadBufferSize:  32 * 1024,
+	WriteBufferSize: 32 * 1024,
+	Subprotocols:    []string{"binary"},
+}
+
+// Console handles GET /{name}/console requests by bridging a websocket to the VM's VNC or SPICE console.
+// Browsers cannot set the Authorization header of a websocket, so they pass their bearer token as a
+// subprotocol next to "binary", see middleware.WebsocketTokenPrefix.
+func (h *VirtualMachine) Console(writer http.ResponseWriter, request *http.Request) {
+	name := request.PathValue("name")
+
+	conn, err := h.vmService.OpenConsole(request.Context(), name)
+	if err != nil {
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to open console",
+			Error:   err.Error(),
+		})
+		return
+	}
+	defer conn.Close()
+
+	ws, err := consoleUpgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		h.logger.Warn("failed to upgrade console connection", slog.String("vm", name), slog.String("error", err.Error()))
+		return
+	}
+	defer ws.Close()
+
+	// The server's read and write timeouts would otherwise cut long-lived console sessions.
+	ws.NetConn().SetDeadline(time.Time{})
+
+	h.logger.Info("console session started", slog.String("vm", name), slog.String("remote", request.RemoteAddr))
+
+	done := make(chan struct{}, 2)
+	go func() {
+		pumpConsoleToWebsocket(conn, ws)
+		done <- struct{}{}
+	}()
+	go func() {
+		pumpWebsocketToConsole(ws, conn)
+		done <- struct{}{}
+	}()
+	<-done
+
+	h.logger.Info("console session ended", slog.String("vm", name))
+}
+
+// ConsoleTicket handles POST /{name}/console/ticket requests by setting a short-lived password on the
+// VM's VNC or SPICE console and returning where and how to connect
+func (h *VirtualMachine) ConsoleTicket(writer http.ResponseWriter, request *http.Request) {
+	name := request.PathValue("name")
+
+	var ticketRequest contracts.ConsoleTicketRequest
+	cb, err := parseBodyAndHandleError(writer, request, &ticketRequest, request.ContentLength > 0)
+	if err != nil {
+		cb()
+		return
+	}
+
+	ttl := defaultConsoleTicketTTL
+	if ticketRequest.TTL != "" {
+		if ttl = h.spAdapter.AdaptDuration(ticketRequest.TTL); ttl <= 0 || ttl > maxConsoleTicketTTL {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: fmt.Sprintf("ttl must be a positive duration of at most %s, such as 5m", maxConsoleTicketTTL),
+			})
+			return
+		}
+	}
+
+	ticket, err := h.vmService.GrantConsoleTicket(request.Context(), name, ttl)
+	if err != nil {
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to issue console ticket",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	h.logger.Info("issued console ticket", slog.String("vm", name), slog.Time("expires_at", ticket.ExpiresAt))
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    h.spAdapter.AdaptConsoleTicketToAPI(ticket),
+		Message: "issued console ticket successfully",
+	})
+}
+
+// SerialConsole handles GET /{name}/serial requests by bridging a websocket to the VM's serial
+// console in read-only mode: input from the client is discarded
+func (h *VirtualMachine) SerialConsole(writer http.ResponseWriter, request *http.Request) {
+	h.serveSerialConsole(writer, request, false)
+}
+
+// InteractiveSerialConsole handles GET /{name}/serial/rw requests by bridging a websocket to the VM's
+// serial console in read-write mode, taking the console over from any other session
+func (h *VirtualMachine) InteractiveSerialConsole(writer http.ResponseWriter, request *http.Request) {
+	h.serveSerialConsole(writer, request, true)
+}
+
+func (h *VirtualMachine) serveSerialConsole(writer http.ResponseWriter, request *http.Request, readWrite bool) {
+	name := request.PathValue("name")
+
+	console, err := h.vmService.OpenSerialConsole(request.Context(), name, readWrite)
+	if err != nil {
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to open serial console",
+			Error:   err.Error(),
+		})
+		return
+	}
+	defer console.Close()
+
+	ws, err := consoleUpgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		h.logger.Warn("failed to upgrade serial console connection", slog.String("vm", name), slog.String("error", err.Error()))
+		return
+	}
+	defer ws.Close()
+
+	// The server's read and write timeouts would otherwise cut long-lived console sessions.
+	ws.NetConn().SetDeadline(time.Time{})
+
+	h.logger.Info("serial console session started",
+		slog.String("vm", name),
+		slog.String("remote", request.RemoteAddr),
+		slog.Bool("read_write", readWrite),
+	)
+
+	input := io.Writer(console)
+	if !readWrite {
+		input = io.Discard
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		pumpConsoleToWebsocket(console, ws)
+		done <- struct{}{}
+	}()
+	go func() {
+		pumpWebsocketToConsole(ws, input)
+		done <- struct{}{}
+	}()
+	<-done
+
+	h.logger.Info("serial console session ended", slog.String("vm", name))
+}
+
+// pumpConsoleToWebsocket forwards console bytes to the browser as binary frames.
+func pumpConsoleToWebsocket(conn io.Reader, ws *websocket.Conn) {
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := conn.Read(buffer)
+		if n > 0 {
+			if err := ws.WriteMessage(websocket.BinaryMessage, buffer[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "console closed"), time.Time{})
+			}
+			return
+		}
+	}
+}
+
+// pumpWebsocketToConsole forwards browser frames to the console.
+func pumpWebsocketToConsole(ws *websocket.Conn, conn io.Writer) {
+	for {
+		_, reader, err := ws.NextReader()
+		if err != nil {
+			return
+		}
+		if _, err := io.Copy(conn, reader); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/terabiome/homonculus/internal/adapter"
+	"github.com/terabiome/homonculus/internal/api/contracts"
+	"github.com/terabiome/homonculus/internal/service"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/labels"
+)
+
+// Integration handles HTTP requests from external tooling such as configuration management
+type Integration struct {
+	vmService *service.VMService
+	logger    *slog.Logger
+	spAdapter *adapter.ServiceParameterAdapter
+}
+
+// NewIntegration creates a new Integration handler
+func NewIntegration(vmService *service.VMService, logger *slog.Logger, spAdapter *adapter.ServiceParameterAdapter) *Integration {
+	return &Integration{
+		vmService: vmService,
+		logger:    logger,
+		spAdapter: spAdapter,
+	}
+}
+
+// AnsibleInventory handles GET /ansible/inventory requests to produce an Ansible dynamic inventory.
+// VMs are grouped by their cluster and role labels; the document is returned unwrapped so it can be
+// consumed directly by an inventory script or plugin.
+func (h *Integration) AnsibleInventory(writer http.ResponseWriter, request *http.Request) {
+	selector, cb, err := parseSelector(writer, request)
+	if err != nil {
+		cb()
+		return
+	}
+
+	vmInfos, err := h.vmService.SelectVirtualMachines(request.Context(), selector)
+	if err != nil {
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to query virtual machines",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	data, err := json.Marshal(h.spAdapter.AdaptVMInfoToAnsibleInventory(vmInfos))
+	if err != nil {
+		writeResult(writer, http.StatusInternalServerError, GenericResponse{
+			Body:    nil,
+			Message: "failed to encode ansible inventory",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeBytes(writer, http.StatusOK, data)
+}
+
+// TerraformVirtualMachine handles GET /terraform/virtualmachines/{name} requests to read the canonical
+// representation of a VM. The ETag header identifies the returned version; If-None-Match is honoured.
+func (h *Integration) TerraformVirtualMachine(writer http.ResponseWriter, request *http.Request) {
+	resource, etag, cb, err := h.terraformVirtualMachine(writer, request)
+	if err != nil {
+		cb()
+		return
+	}
+
+	writeResource(writer, request, etag, GenericResponse{
+		Body:    resource,
+		Message: "retrieved virtual machine successfully",
+	})
+}
+
+// DeleteTerraformVirtualMachine handles DELETE /terraform/virtualmachines/{name} requests.
+// With an If-Match header the VM is only deleted if it is still at the given version; the
+// version is compared and the VM deleted without homonculus changing it in between.
+func (h *Integration) DeleteTerraformVirtualMachine(writer http.ResponseWriter, request *http.Request) {
+	resource, _, cb, err := h.terraformVirtualMachine(writer, request)
+	if err != nil {
+		cb()
+		return
+	}
+
+	ifMatch := request.Header.Get("If-Match")
+	if ifMatch == "" {
+		err = h.vmService.DeleteCluster(request.Context(), []parameters.DeleteVM{{Name: resource.Name}})
+	} else {
+		var etag string
+		err = h.vmService.DeleteVirtualMachineIf(request.Context(), resource.Name, func(vmInfo parameters.VMInfo) error {
+			resource = h.spAdapter.AdaptVMInfoToTerraform(vmInfo)
+			var err error
+			if etag, err = terraformETag(resource); err != nil {
+				return err
+			}
+			if !etagMatches(ifMatch, etag) {
+				return errVersionMismatch
+			}
+			return nil
+		})
+		if errors.Is(err, errVersionMismatch) {
+			writer.Header().Set("ETag", etag)
+			writeResult(writer, http.StatusPreconditionFailed, GenericResponse{
+				Body:    resource,
+				Message: "virtual machine was modified",
+			})
+			return
+		}
+	}
+	if err != nil {
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to delete virtual machine",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    nil,
+		Message: "deleted virtual machine successfully",
+	})
+}
+
+// TerraformCluster handles GET /terraform/clusters/{name} requests to read the membership of a cluster.
+// The ETag header identifies the returned version; If-None-Match is honoured.
+func (h *Integration) TerraformCluster(writer http.ResponseWriter, request *http.Request) {
+	ctx := request.Context()
+	name := request.PathValue("name")
+
+	spec, found, err := h.vmService.GetClusterSpec(name)
+	if err != nil {
+		writeResult(writer, http.StatusInternalServerError, GenericResponse{
+			Body:    nil,
+			Message: "failed to get cluster",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	selector := labels.Selector{{Key: service.LabelCluster, Operator: labels.OperatorEquals, Value: name}}
+	vmInfos, err := h.vmService.SelectVirtualMachines(ctx, selector)
+	if err != nil {
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to query virtual machines",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if !found && len(vmInfos) == 0 {
+		writeResult(writer, http.StatusNotFound, GenericResponse{
+			Body:    nil,
+			Message: "cluster not found",
+		})
+		return
+	}
+
+	var specRef *parameters.CreateCluster
+	if found {
+		specRef = &spec
+	}
+	resource := h.spAdapter.AdaptClusterToTerraform(name, specRef, vmInfos)
+	etag, err := resourceETag(resource)
+	if err != nil {
+		writeResult(writer, http.StatusInternalServerError, GenericResponse{
+			Body:    nil,
+			Message: "failed to encode cluster",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResource(writer, request, etag, GenericResponse{
+		Body:    resource,
+		Message: "retrieved cluster successfully",
+	})
+}
+
+// terraformVirtualMachine looks up the VM named in the path and computes its ETag
+func (h *Integration) terraformVirtualMachine(writer http.ResponseWriter, request *http.Request) (contracts.TerraformVirtualMachine, string, responseCallback, error) {
+	vmInfo, found, err := h.vmService.GetVirtualMachine(request.Context(), request.PathValue("name"))
+	if err != nil {
+		return contracts.TerraformVirtualMachine{}, "", func() {
+			writeResult(writer, serviceErrorStatus(err), GenericResponse{
+				Body:    nil,
+				Message: "failed to get virtual machine",
+				Error:   err.Error(),
+			})
+		}, err
+	}
+	if !found {
+		return contracts.TerraformVirtualMachine{}, "", func() {
+			writeResult(writer, http.StatusNotFound, GenericResponse{
+				Body:    nil,
+				Message: "virtual machine not found",
+			})
+		}, errors.New("virtual machine not found")
+	}
+
+	resource := h.spAdapter.AdaptVMInfoToTerraform(vmInfo)
+	etag, err := terraformETag(resource)
+	if err != nil {
+		return contracts.TerraformVirtualMachine{}, "", func() {
+			writeResult(writer, http.StatusInternalServerError, GenericResponse{
+				Body:    nil,
+				Message: "failed to encode virtual machine",
+				Error:   err.Error(),
+			})
+		}, err
+	}
+	return resource, etag, func() {}, nil
+}
+
+// errVersionMismatch reports a resource no longer at the version of an If-Match header.
+var errVersionMismatch = errors.New("resource version does not match")
+
+// terraformETag computes the ETag of a VM from its spec. The state and address change as the
+// VM runs, not when its spec does, so they would fail If-Match for no reason.
+func terraformETag(resource contracts.TerraformVirtualMachine) (string, error) {
+	resource.State = ""
+	resource.IPAddress = ""
+	return resourceETag(resource)
+}
+
+// writeResource writes a versioned resource, answering 304 if the client already holds that version
+func writeResource(writer http.ResponseWriter, request *http.Request, etag string, response GenericResponse) {
+	writer.Header().Set("ETag", etag)
+	if ifNoneMatch := request.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag) {
+		writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeResult(writer, http.StatusOK, response)
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/terabiome/homonculus/internal/adapter"
+	"github.com/terabiome/homonculus/internal/service"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+)
+
+// GetJob handles GET /jobs/{id} requests reporting the progress of a job started with ?async=true
+func (h *VirtualMachine) GetJob(writer http.ResponseWriter, request *http.Request) {
+	job, found, err := h.vmService.GetJob(request.Context(), request.PathValue("id"))
+	if err != nil {
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to retrieve job",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if !found {
+		writeResult(writer, http.StatusNotFound, GenericResponse{
+			Body:    nil,
+			Message: "job not found",
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    h.spAdapter.AdaptJobToAPI(job),
+		Message: "retrieved job successfully",
+	})
+}
+
+// parseAsync reports whether a request asked to run as a job with the async query parameter.
+func parseAsync(request *http.Request) (bool, error) {
+	value := request.URL.Query().Get("async")
+	if value == "" {
+		return false, nil
+	}
+	async, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("async must be true or false, got %q", value)
+	}
+	return async, nil
+}
+
+// startJob runs an operation on the VMs named vms as a job and responds with 202 Accepted and
+// the job, whose progress is polled at the Location header.
+func (h *VirtualMachine) startJob(writer http.ResponseWriter, request *http.Request, operation string, vms []string, run func(ctx context.Context) error) {
+	startJob(writer, request, h.vmService, h.spAdapter, operation, vms, run)
+}
+
+// startJob runs an operation as a job of vmService, see VirtualMachine.startJob.
+func startJob(writer http.ResponseWriter, request *http.Request, vmService *service.VMService, spAdapter *adapter.ServiceParameterAdapter, operation string, vms []string, run func(ctx context.Context) error) {
+	job, err := vmService.StartJob(request.Context(), operation, vms, run)
+	if err != nil {
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to start job",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writer.Header().Set("Location", "/api/v1/jobs/"+job.ID)
+	writeResult(writer, http.StatusAccepted, GenericResponse{
+		Body:    spAdapter.AdaptJobToAPI(job),
+		Message: "started " + job.Operation + " job",
+	})
+}
+
+// targetNames returns the names of the VMs a clone cluster request creates.
+func targetNames(cloneParams parameters.CloneVM) []string {
+	names := make([]string, len(cloneParams.TargetSpecs))
+	for i, target := range cloneParams.TargetSpecs {
+		names[i] = target.Name
+	}
+	return names
+}
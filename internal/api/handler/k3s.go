@@ -1,23 +1,206 @@
 package handler
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
+	"time"
 
 	"github.com/terabiome/homonculus/internal/api/contracts"
+	"github.com/terabiome/homonculus/internal/service"
+	"github.com/terabiome/homonculus/internal/service/parameters"
 	"github.com/terabiome/homonculus/pkg/k3s"
+	"github.com/terabiome/homonculus/pkg/secrets"
 )
 
+// defaultReadyTimeout bounds how long a bootstrap waits for its wait_for_vms.
+const defaultReadyTimeout = 10 * time.Minute
+
+// defaultVerifyTimeout bounds how long a verified bootstrap waits for a healthy cluster.
+const defaultVerifyTimeout = 5 * time.Minute
+
+// defaultDrainTimeout bounds how long removing a node waits for its pods to be evicted.
+const defaultDrainTimeout = 5 * time.Minute
+
 // K3s handles K3s-related HTTP requests
 type K3s struct {
-	logger *slog.Logger
+	vmService *service.VMService
+	logger    *slog.Logger
+	secrets   *secrets.Resolver
 }
 
 // NewK3s creates a new K3s handler
-func NewK3s(logger *slog.Logger) *K3s {
+func NewK3s(vmService *service.VMService, logger *slog.Logger, secretResolver *secrets.Resolver) *K3s {
 	return &K3s{
-		logger: logger,
+		vmService: vmService,
+		logger:    logger,
+		secrets:   secretResolver,
+	}
+}
+
+// bootstrapService returns a bootstrap service that reaches nodes with a guest_agent_vm
+// through the guest agent of their VM.
+func (h *K3s) bootstrapService() *k3s.BootstrapService {
+	return k3s.NewBootstrapService(h.logger).WithGuestExecutor(func(vm string) k3s.NodeExecutor {
+		return h.vmService.GuestExecutor(vm)
+	})
+}
+
+// waitForVirtualMachines blocks until the VMs a bootstrap depends on are ready.
+// It writes the error response and returns false if they are not.
+func (h *K3s) waitForVirtualMachines(ctx context.Context, writer http.ResponseWriter, names []string, readyTimeout string) bool {
+	if len(names) == 0 {
+		return true
+	}
+
+	timeout := defaultReadyTimeout
+	if readyTimeout != "" {
+		parsed, err := time.ParseDuration(readyTimeout)
+		if err != nil || parsed <= 0 {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: "ready_timeout must be a positive duration such as 5m",
+			})
+			return false
+		}
+		timeout = parsed
+	}
+
+	if err := h.vmService.WaitForReady(ctx, names, timeout); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrNotReady) {
+			status = http.StatusConflict
+		}
+		writeResult(writer, status, GenericResponse{
+			Body:    nil,
+			Message: "virtual machines did not become ready",
+			Error:   err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// parseVerifyTimeout parses the verify_timeout of a bootstrap config.
+// It writes the error response and returns false if it is invalid.
+func parseVerifyTimeout(writer http.ResponseWriter, verifyTimeout string) (time.Duration, bool) {
+	if verifyTimeout == "" {
+		return defaultVerifyTimeout, true
+	}
+	timeout, err := time.ParseDuration(verifyTimeout)
+	if err != nil || timeout <= 0 {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "verify_timeout must be a positive duration such as 5m",
+		})
+		return 0, false
+	}
+	return timeout, true
+}
+
+// verifyCluster waits until the bootstrapped hosts are healthy nodes of the cluster of master.
+// It writes the error response, with the last observed health, and returns false if they are not.
+func (h *K3s) verifyCluster(ctx context.Context, writer http.ResponseWriter, bootstrapService *k3s.BootstrapService,
+	master contracts.K3sNodeConfig, nodes []contracts.K3sNodeConfig, timeout time.Duration) (*contracts.K3sClusterHealth, bool) {
+	hosts := make([]string, len(nodes))
+	for i, node := range nodes {
+		hosts[i] = node.Host
+	}
+
+	health, err := bootstrapService.VerifyCluster(ctx, master, hosts, timeout)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, k3s.ErrClusterUnhealthy) {
+			status = http.StatusConflict
+		}
+		writeResult(writer, status, GenericResponse{
+			Body:    health,
+			Message: "K3s cluster did not become healthy",
+			Error:   err.Error(),
+		})
+		return nil, false
+	}
+	return &health, true
+}
+
+// resolveBootstrapSecrets resolves secret:// references in the token and node keys.
+// It returns resolved copies so responses keep echoing the references.
+func (h *K3s) resolveBootstrapSecrets(ctx context.Context, token string, nodes []contracts.K3sNodeConfig) (string, []contracts.K3sNodeConfig, error) {
+	resolvedToken, err := h.secrets.Resolve(ctx, token)
+	if err != nil {
+		return "", nil, fmt.Errorf("token: %w", err)
+	}
+	// Plain tokens are tracked too, so they are masked in logged install commands.
+	h.secrets.Track(resolvedToken)
+
+	resolvedNodes, err := h.resolveNodeSecrets(ctx, nodes)
+	if err != nil {
+		return "", nil, err
+	}
+	return resolvedToken, resolvedNodes, nil
+}
+
+// resolveNodeSecrets resolves the secret:// references of node keys into resolved copies.
+func (h *K3s) resolveNodeSecrets(ctx context.Context, nodes []contracts.K3sNodeConfig) ([]contracts.K3sNodeConfig, error) {
+	resolvedNodes := slices.Clone(nodes)
+	for i := range resolvedNodes {
+		if resolvedNodes[i].SSHPrivateKey == "" {
+			continue
+		}
+		if !secrets.IsRef(resolvedNodes[i].SSHPrivateKey) {
+			return nil, fmt.Errorf("node %s: ssh_private_key must be a secret:// reference", resolvedNodes[i].Host)
+		}
+		key, err := h.secrets.Resolve(ctx, resolvedNodes[i].SSHPrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("node %s ssh_private_key: %w", resolvedNodes[i].Host, err)
+		}
+		resolvedNodes[i].SSHPrivateKey = key
+	}
+	return resolvedNodes, nil
+}
+
+// resolveRegistrySecrets resolves the secret:// references of registry passwords and tokens
+// into a resolved copy and checks that the registries render into a valid registries.yaml.
+func (h *K3s) resolveRegistrySecrets(ctx context.Context, registries *contracts.K3sRegistries) (*contracts.K3sRegistries, error) {
+	if registries == nil {
+		return nil, nil
+	}
+	resolved := *registries
+	resolved.Configs = make(map[string]contracts.K3sRegistryConfig, len(registries.Configs))
+	for host, config := range registries.Configs {
+		if config.Auth != nil {
+			auth := *config.Auth
+			for field, value := range map[string]*string{"password": &auth.Password, "token": &auth.Token} {
+				if *value == "" {
+					continue
+				}
+				plain, err := h.secrets.Resolve(ctx, *value)
+				if err != nil {
+					return nil, fmt.Errorf("registry %s %s: %w", host, field, err)
+				}
+				h.secrets.Track(plain)
+				*value = plain
+			}
+			config.Auth = &auth
+		}
+		resolved.Configs[host] = config
+	}
+	if _, err := k3s.RenderRegistries(&resolved); err != nil {
+		return nil, err
 	}
+	return &resolved, nil
+}
+
+// writeSecretError writes the response for a failed secret resolution
+func writeSecretError(writer http.ResponseWriter, err error) {
+	writeResult(writer, http.StatusBadRequest, GenericResponse{
+		Body:    nil,
+		Message: "failed to resolve secrets in request",
+		Error:   err.Error(),
+	})
 }
 
 // GenerateToken handles POST /generate-token requests to generate a K3s token
@@ -40,6 +223,18 @@ func (h *K3s) GenerateToken(writer http.ResponseWriter, request *http.Request) {
 	})
 }
 
+// nodeNames returns the guest agent VM of every node, or its host for nodes reached over SSH.
+func nodeNames(nodes []contracts.K3sNodeConfig) []string {
+	names := make([]string, len(nodes))
+	for i, node := range nodes {
+		names[i] = node.Host
+		if node.GuestAgentVM != "" {
+			names[i] = node.GuestAgentVM
+		}
+	}
+	return names
+}
+
 // BootstrapMaster handles POST /bootstrap/master requests to bootstrap K3s master nodes
 func (h *K3s) BootstrapMaster(writer http.ResponseWriter, request *http.Request) {
 	var config contracts.K3sMasterBootstrapConfig
@@ -66,9 +261,39 @@ func (h *K3s) BootstrapMaster(writer http.ResponseWriter, request *http.Request)
 		return
 	}
 
+	verifyTimeout, ok := parseVerifyTimeout(writer, config.VerifyTimeout)
+	if !ok {
+		return
+	}
+
+	if err := validateProxy(config.Proxy); err != nil {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "invalid proxy in bootstrap config",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	h.logger.Debug("k3s bootstrap request", slog.String("path", request.URL.Path), slog.Any("config", config))
+
 	ctx := request.Context()
-	bootstrapService := k3s.NewBootstrapService(h.logger)
-	if err := bootstrapService.BootstrapMasters(ctx, config); err != nil {
+	resolved := config
+	resolved.Token, resolved.Nodes, err = h.resolveBootstrapSecrets(ctx, config.Token, config.Nodes)
+	if err == nil {
+		resolved.Registries, err = h.resolveRegistrySecrets(ctx, config.Registries)
+	}
+	if err != nil {
+		writeSecretError(writer, err)
+		return
+	}
+
+	if !h.waitForVirtualMachines(ctx, writer, config.WaitForVMs, config.ReadyTimeout) {
+		return
+	}
+
+	bootstrapService := h.bootstrapService()
+	if err := bootstrapService.BootstrapMasters(ctx, resolved); err != nil {
 		writeResult(writer, http.StatusInternalServerError, GenericResponse{
 			Body:    nil,
 			Message: "failed to bootstrap K3s master nodes",
@@ -76,9 +301,17 @@ func (h *K3s) BootstrapMaster(writer http.ResponseWriter, request *http.Request)
 		})
 		return
 	}
+	h.vmService.RecordBootstrapped(ctx, nodeNames(config.Nodes))
+
+	response := contracts.K3sMasterBootstrapResponse{K3sMasterBootstrapConfig: config.Redacted()}
+	if config.Verify {
+		if response.Health, ok = h.verifyCluster(ctx, writer, bootstrapService, resolved.Nodes[0], resolved.Nodes, verifyTimeout); !ok {
+			return
+		}
+	}
 
 	writeResult(writer, http.StatusOK, GenericResponse{
-		Body:    config,
+		Body:    response,
 		Message: "bootstrapped K3s master nodes successfully",
 	})
 }
@@ -117,9 +350,50 @@ func (h *K3s) BootstrapWorker(writer http.ResponseWriter, request *http.Request)
 		return
 	}
 
+	verifyTimeout, ok := parseVerifyTimeout(writer, config.VerifyTimeout)
+	if !ok {
+		return
+	}
+
+	if err := validateProxy(config.Proxy); err != nil {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "invalid proxy in bootstrap config",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	h.logger.Debug("k3s bootstrap request", slog.String("path", request.URL.Path), slog.Any("config", config))
+
+	// The verify master is resolved along with the workers and split off again.
+	nodes := config.Nodes
+	if config.VerifyMaster != nil {
+		nodes = append(slices.Clone(config.Nodes), *config.VerifyMaster)
+	}
+
 	ctx := request.Context()
-	bootstrapService := k3s.NewBootstrapService(h.logger)
-	if err := bootstrapService.BootstrapWorkers(ctx, config); err != nil {
+	resolved := config
+	resolved.Token, resolved.Nodes, err = h.resolveBootstrapSecrets(ctx, config.Token, nodes)
+	if err == nil {
+		resolved.Registries, err = h.resolveRegistrySecrets(ctx, config.Registries)
+	}
+	if err != nil {
+		writeSecretError(writer, err)
+		return
+	}
+	var verifyMaster contracts.K3sNodeConfig
+	if config.VerifyMaster != nil {
+		verifyMaster = resolved.Nodes[len(config.Nodes)]
+		resolved.Nodes = resolved.Nodes[:len(config.Nodes)]
+	}
+
+	if !h.waitForVirtualMachines(ctx, writer, config.WaitForVMs, config.ReadyTimeout) {
+		return
+	}
+
+	bootstrapService := h.bootstrapService()
+	if err := bootstrapService.BootstrapWorkers(ctx, resolved); err != nil {
 		writeResult(writer, http.StatusInternalServerError, GenericResponse{
 			Body:    nil,
 			Message: "failed to bootstrap K3s worker nodes",
@@ -127,9 +401,310 @@ func (h *K3s) BootstrapWorker(writer http.ResponseWriter, request *http.Request)
 		})
 		return
 	}
+	h.vmService.RecordBootstrapped(ctx, nodeNames(config.Nodes))
+
+	response := contracts.K3sWorkerBootstrapResponse{K3sWorkerBootstrapConfig: config.Redacted()}
+	if config.VerifyMaster != nil {
+		if response.Health, ok = h.verifyCluster(ctx, writer, bootstrapService, verifyMaster, resolved.Nodes, verifyTimeout); !ok {
+			return
+		}
+	}
 
 	writeResult(writer, http.StatusOK, GenericResponse{
-		Body:    config,
+		Body:    response,
 		Message: "bootstrapped K3s worker nodes successfully",
 	})
 }
+
+// parseEtcdSnapshotRequest parses an etcd snapshot request and resolves its node key.
+// It writes the error response and returns false if the request is invalid.
+func (h *K3s) parseEtcdSnapshotRequest(writer http.ResponseWriter, request *http.Request, requireName bool) (contracts.K3sEtcdSnapshotRequest, contracts.K3sNodeConfig, bool) {
+	var snapshotRequest contracts.K3sEtcdSnapshotRequest
+	cb, err := parseBodyAndHandleError(writer, request, &snapshotRequest, true)
+	if err != nil {
+		cb()
+		return snapshotRequest, contracts.K3sNodeConfig{}, false
+	}
+
+	if snapshotRequest.Node.Host == "" {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "node is required in etcd snapshot request",
+		})
+		return snapshotRequest, contracts.K3sNodeConfig{}, false
+	}
+	if snapshotRequest.Name != "" || requireName {
+		if err := k3s.ValidateSnapshotName(snapshotRequest.Name); err != nil {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: "invalid etcd snapshot name",
+				Error:   err.Error(),
+			})
+			return snapshotRequest, contracts.K3sNodeConfig{}, false
+		}
+	}
+
+	h.logger.Debug("k3s etcd snapshot request", slog.String("path", request.URL.Path), slog.Any("request", snapshotRequest))
+
+	nodes, err := h.resolveNodeSecrets(request.Context(), []contracts.K3sNodeConfig{snapshotRequest.Node})
+	if err != nil {
+		writeSecretError(writer, err)
+		return snapshotRequest, contracts.K3sNodeConfig{}, false
+	}
+	return snapshotRequest, nodes[0], true
+}
+
+// writeEtcdSnapshotError writes the response for a failed etcd snapshot operation
+func writeEtcdSnapshotError(writer http.ResponseWriter, err error, message string) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, k3s.ErrInvalidSnapshotName):
+		status = http.StatusBadRequest
+	case errors.Is(err, k3s.ErrSnapshotNotFound):
+		status = http.StatusNotFound
+	}
+	writeResult(writer, status, GenericResponse{
+		Body:    nil,
+		Message: message,
+		Error:   err.Error(),
+	})
+}
+
+// SaveEtcdSnapshot handles POST /etcd-snapshots/save requests to take an etcd snapshot on a
+// server node and, for a coordinated backup, a snapshot of every VM of a named cluster
+func (h *K3s) SaveEtcdSnapshot(writer http.ResponseWriter, request *http.Request) {
+	snapshotRequest, node, ok := h.parseEtcdSnapshotRequest(writer, request, false)
+	if !ok {
+		return
+	}
+	name := snapshotRequest.Name
+	if name == "" {
+		name = "backup-" + time.Now().UTC().Format("20060102-150405")
+	}
+
+	ctx := request.Context()
+	bootstrapService := h.bootstrapService()
+	snapshots, err := bootstrapService.SaveEtcdSnapshot(ctx, node, name)
+	if err != nil {
+		writeEtcdSnapshotError(writer, err, "failed to save etcd snapshot")
+		return
+	}
+
+	response := contracts.K3sEtcdSnapshotsResponse{Snapshots: snapshots}
+	if snapshotRequest.Cluster != "" {
+		// VM snapshots are taken after etcd, so resetting to them never loses state the etcd snapshot holds.
+		if err := h.vmService.SnapshotCluster(ctx, snapshotRequest.Cluster, name, "Taken with etcd snapshot "+name); err != nil {
+			status := serviceErrorStatus(err)
+			if errors.Is(err, service.ErrClusterNotFound) {
+				status = http.StatusNotFound
+			}
+			writeResult(writer, status, GenericResponse{
+				Body:    response,
+				Message: "saved etcd snapshot but failed to snapshot virtual machine cluster",
+				Error:   err.Error(),
+			})
+			return
+		}
+		response.VMSnapshot = name
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    response,
+		Message: "saved etcd snapshot successfully",
+	})
+}
+
+// ListEtcdSnapshots handles POST /etcd-snapshots/list requests to list the etcd snapshots of a server node
+func (h *K3s) ListEtcdSnapshots(writer http.ResponseWriter, request *http.Request) {
+	_, node, ok := h.parseEtcdSnapshotRequest(writer, request, false)
+	if !ok {
+		return
+	}
+
+	snapshots, err := h.bootstrapService().ListEtcdSnapshots(request.Context(), node)
+	if err != nil {
+		writeEtcdSnapshotError(writer, err, "failed to list etcd snapshots")
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    contracts.K3sEtcdSnapshotsResponse{Snapshots: snapshots},
+		Message: "listed etcd snapshots successfully",
+	})
+}
+
+// DownloadEtcdSnapshot handles POST /etcd-snapshots/download requests by streaming a local
+// etcd snapshot of a server node over SSH
+func (h *K3s) DownloadEtcdSnapshot(writer http.ResponseWriter, request *http.Request) {
+	snapshotRequest, node, ok := h.parseEtcdSnapshotRequest(writer, request, true)
+	if !ok {
+		return
+	}
+
+	controller := http.NewResponseController(writer)
+	streaming := false
+	// The response starts with the first bytes of the snapshot, so a missing snapshot still gets a JSON error.
+	body := writerFunc(func(p []byte) (int, error) {
+		if !streaming {
+			// Snapshots can take longer to transfer than the server's write timeout.
+			controller.SetWriteDeadline(time.Time{})
+			writer.Header().Set("Content-Type", "application/octet-stream")
+			writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", snapshotRequest.Name))
+			writer.WriteHeader(http.StatusOK)
+			streaming = true
+		}
+		return writer.Write(p)
+	})
+
+	err := h.bootstrapService().DownloadEtcdSnapshot(request.Context(), node, snapshotRequest.Name, body)
+	if err != nil && !streaming {
+		writeEtcdSnapshotError(writer, err, "failed to download etcd snapshot")
+		return
+	}
+	if err != nil {
+		// The status is sent already; the truncated body is all the client learns.
+		h.logger.Error("etcd snapshot download failed", slog.String("name", snapshotRequest.Name), slog.String("error", err.Error()))
+	}
+}
+
+// writerFunc adapts a function to io.Writer
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// RestoreEtcdSnapshot handles POST /etcd-snapshots/restore requests to restore a cluster from an etcd snapshot
+func (h *K3s) RestoreEtcdSnapshot(writer http.ResponseWriter, request *http.Request) {
+	var restoreRequest contracts.K3sEtcdRestoreRequest
+	cb, err := parseBodyAndHandleError(writer, request, &restoreRequest, true)
+	if err != nil {
+		cb()
+		return
+	}
+
+	if len(restoreRequest.Nodes) == 0 {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "no server nodes specified in restore request",
+		})
+		return
+	}
+	if err := k3s.ValidateSnapshotName(restoreRequest.Name); err != nil {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "invalid etcd snapshot name",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	h.logger.Debug("k3s etcd restore request", slog.String("path", request.URL.Path), slog.Any("request", restoreRequest))
+
+	ctx := request.Context()
+	nodes, err := h.resolveNodeSecrets(ctx, restoreRequest.Nodes)
+	if err != nil {
+		writeSecretError(writer, err)
+		return
+	}
+
+	if err := h.bootstrapService().RestoreEtcdSnapshot(ctx, nodes, restoreRequest.Name); err != nil {
+		writeEtcdSnapshotError(writer, err, "failed to restore etcd snapshot")
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    restoreRequest.Redacted(),
+		Message: "restored etcd snapshot successfully",
+	})
+}
+
+// RemoveNode handles POST /remove-node requests to drain a node, remove it from its cluster,
+// uninstall K3s from it and optionally delete its virtual machine
+func (h *K3s) RemoveNode(writer http.ResponseWriter, request *http.Request) {
+	var removeRequest contracts.K3sRemoveNodeRequest
+	cb, err := parseBodyAndHandleError(writer, request, &removeRequest, true)
+	if err != nil {
+		cb()
+		return
+	}
+
+	if removeRequest.Master.Host == "" || removeRequest.Node.Host == "" {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "master and node are required in remove request",
+		})
+		return
+	}
+	if removeRequest.Master.Host == removeRequest.Node.Host {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "master must be another server node than the node to remove",
+		})
+		return
+	}
+	if removeRequest.NodeName != "" {
+		if err := k3s.ValidateNodeName(removeRequest.NodeName); err != nil {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: "invalid node name",
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+
+	drainTimeout := defaultDrainTimeout
+	if removeRequest.DrainTimeout != "" {
+		parsed, err := time.ParseDuration(removeRequest.DrainTimeout)
+		if err != nil || parsed < time.Second {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: "drain_timeout must be a duration of at least 1s such as 10m",
+			})
+			return
+		}
+		drainTimeout = parsed
+	}
+
+	h.logger.Debug("k3s remove node request", slog.String("path", request.URL.Path), slog.Any("request", removeRequest))
+
+	ctx := request.Context()
+	nodes, err := h.resolveNodeSecrets(ctx, []contracts.K3sNodeConfig{removeRequest.Master, removeRequest.Node})
+	if err != nil {
+		writeSecretError(writer, err)
+		return
+	}
+
+	nodeName, err := h.bootstrapService().RemoveNode(ctx, nodes[0], nodes[1], removeRequest.NodeName, drainTimeout)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, k3s.ErrInvalidNodeName) {
+			status = http.StatusBadRequest
+		}
+		writeResult(writer, status, GenericResponse{
+			Body:    nil,
+			Message: "failed to remove K3s node",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	response := contracts.K3sRemoveNodeResponse{NodeName: nodeName}
+	if removeRequest.DeleteVM != "" {
+		if err := h.vmService.DeleteCluster(ctx, []parameters.DeleteVM{{Name: removeRequest.DeleteVM}}); err != nil {
+			writeResult(writer, serviceErrorStatus(err), GenericResponse{
+				Body:    response,
+				Message: "removed K3s node but failed to delete its virtual machine",
+				Error:   err.Error(),
+			})
+			return
+		}
+		response.VMDeleted = removeRequest.DeleteVM
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    response,
+		Message: "removed K3s node successfully",
+	})
+}
@@ -1,8 +1,17 @@
 package handler
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
+
+	"github.com/terabiome/homonculus/internal/api/contracts"
+	"github.com/terabiome/homonculus/internal/errdefs"
+	"github.com/terabiome/homonculus/pkg/labels"
+	"github.com/terabiome/homonculus/pkg/variables"
 )
 
 // GenericResponse is a standard API response structure
@@ -10,15 +19,37 @@ type GenericResponse struct {
 	Body    any    `json:"body,omitempty"`
 	Message string `json:"message"`
 	Error   string `json:"error,omitempty"`
+	TraceID string `json:"trace_id,omitempty"` // Copied from the correlation headers of the response
+	JobID   string `json:"job_id,omitempty"`
 }
 
 // responseCallback is a function type for error handling callbacks
 type responseCallback func()
 
+// serviceErrorStatus maps the typed errors of the service layer to HTTP statuses, falling back to 500
+func serviceErrorStatus(err error) int {
+	var diskErr *errdefs.ErrDiskCreateFailed
+	switch {
+	case errors.Is(err, errdefs.ErrVMNotFound), errors.Is(err, errdefs.ErrDeviceNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errdefs.ErrVMExists), errors.Is(err, errdefs.ErrPinConflict), errors.Is(err, errdefs.ErrConsoleBusy):
+		return http.StatusConflict
+	case errors.Is(err, errdefs.ErrVMNotManaged):
+		return http.StatusForbidden
+	case errors.Is(err, errdefs.ErrHypervisorUnavailable), errors.Is(err, errdefs.ErrHostOverloaded):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, errdefs.ErrNotSupported):
+		return http.StatusNotImplemented
+	case errors.As(err, &diskErr) && diskErr.Stage == errdefs.DiskStageValidate:
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
 // parseBodyAndHandleError parses the request body and handles errors
 func parseBodyAndHandleError(writer http.ResponseWriter, request *http.Request, target any, requireBody bool) (responseCallback, error) {
 	if requireBody {
-		if err := json.NewDecoder(request.Body).Decode(target); err != nil {
+		if err := decodeBody(request, target); err != nil {
 			return func() {
 				writeResult(writer, http.StatusBadRequest, GenericResponse{
 					Body:    nil,
@@ -31,8 +62,68 @@ func parseBodyAndHandleError(writer http.ResponseWriter, request *http.Request,
 	return func() {}, nil
 }
 
+// decodeBody decodes a JSON request body into target, or a YAML one if the Content-Type says so.
+func decodeBody(request *http.Request, target any) error {
+	if isYAML(request.Header.Get("Content-Type")) {
+		return decodeYAML(request.Body, target)
+	}
+	return json.NewDecoder(request.Body).Decode(target)
+}
+
+// expandRequestVariables substitutes the request variables into the rest of request and clears them,
+// so they are neither logged nor echoed back. Requests without variables are left untouched.
+func expandRequestVariables(request any, vars *map[string]string) error {
+	defined := *vars
+	*vars = nil
+	if len(defined) == 0 {
+		return nil
+	}
+	if err := variables.Validate(defined); err != nil {
+		return err
+	}
+	return variables.ExpandAll(request, defined)
+}
+
+// parseSelector parses the optional ?selector= label selector query parameter
+func parseSelector(writer http.ResponseWriter, request *http.Request) (labels.Selector, responseCallback, error) {
+	selector, err := labels.Parse(request.URL.Query().Get("selector"))
+	if err != nil {
+		return nil, func() {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: "invalid label selector",
+				Error:   err.Error(),
+			})
+		}, err
+	}
+	return selector, func() {}, nil
+}
+
+// resourceETag returns a strong entity tag over the JSON encoding of a resource.
+func resourceETag(resource any) (string, error) {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header value lists etag or is "*".
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
 // writeResult writes a JSON response with the given status code
 func writeResult(writer http.ResponseWriter, statusCode int, response GenericResponse) {
+	response.TraceID = writer.Header().Get(contracts.TraceIDHeader)
+	response.JobID = writer.Header().Get(contracts.JobIDHeader)
 	writer.Header().Set("Content-Type", "application/json")
 	writer.WriteHeader(statusCode)
 	json.NewEncoder(writer).Encode(response)
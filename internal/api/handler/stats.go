@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/service"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+)
+
+// defaultStatsInterval is the sampling interval of stats streams without an interval parameter.
+const defaultStatsInterval = 5 * time.Second
+
+// StatsStream handles GET /{name}/stats/stream requests by streaming the resource usage of a VM
+// as server-sent events, one stats event per interval, until the client disconnects
+func (h *VirtualMachine) StatsStream(writer http.ResponseWriter, request *http.Request) {
+	name := request.PathValue("name")
+
+	interval := defaultStatsInterval
+	if value := request.URL.Query().Get("interval"); value != "" {
+		var err error
+		if interval, err = parseInterval(value); err != nil || interval < service.MinStatsInterval {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: "invalid interval parameter",
+				Error:   fmt.Sprintf("interval must be a duration or a number of seconds of at least %s, got %q", service.MinStatsInterval, value),
+			})
+			return
+		}
+	}
+
+	controller := http.NewResponseController(writer)
+	streaming := false
+	emit := func(stats parameters.VMStats) error {
+		if !streaming {
+			// The server's write timeout would otherwise cut long-lived streams.
+			controller.SetWriteDeadline(time.Time{})
+			writer.Header().Set("Content-Type", "text/event-stream")
+			writer.Header().Set("Cache-Control", "no-cache")
+			writer.Header().Set("X-Accel-Buffering", "no")
+			writer.WriteHeader(http.StatusOK)
+			streaming = true
+		}
+		return writeEvent(writer, controller, "stats", h.spAdapter.AdaptVMStatsToAPI(stats))
+	}
+
+	h.logger.Info("stats stream started", slog.String("vm", name), slog.Duration("interval", interval), slog.String("remote", request.RemoteAddr))
+
+	err := h.vmService.StreamVirtualMachineStats(request.Context(), name, interval, emit)
+	if err != nil && !streaming {
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to stream VM stats",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if err != nil && request.Context().Err() == nil {
+		h.logger.Warn("stats stream failed", slog.String("vm", name), slog.String("error", err.Error()))
+		writeEvent(writer, controller, "error", GenericResponse{Message: "failed to stream VM stats", Error: err.Error()})
+	}
+
+	h.logger.Info("stats stream ended", slog.String("vm", name))
+}
+
+// parseInterval parses a duration such as 10s, or a plain number of seconds
+func parseInterval(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// writeEvent writes one server-sent event with a JSON payload and flushes it to the client
+func writeEvent(writer http.ResponseWriter, controller *http.ResponseController, event string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	return controller.Flush()
+}
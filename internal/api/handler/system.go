@@ -2,25 +2,318 @@ package handler
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/terabiome/homonculus/internal/adapter"
+	"github.com/terabiome/homonculus/internal/api/contracts"
+	"github.com/terabiome/homonculus/internal/service"
+	"github.com/terabiome/homonculus/internal/service/parameters"
 	"github.com/terabiome/homonculus/pkg/executor"
 )
 
 // System handles system-related HTTP requests
 type System struct {
-	logger *slog.Logger
+	vmService *service.VMService
+	logger    *slog.Logger
+	spAdapter *adapter.ServiceParameterAdapter
 }
 
 // NewSystem creates a new System handler
-func NewSystem(logger *slog.Logger) *System {
+func NewSystem(vmService *service.VMService, logger *slog.Logger, spAdapter *adapter.ServiceParameterAdapter) *System {
 	return &System{
-		logger: logger,
+		vmService: vmService,
+		logger:    logger,
+		spAdapter: spAdapter,
 	}
 }
 
+// Capacity handles GET /capacity requests to report the resource inventory of every hypervisor host
+func (h *System) Capacity(writer http.ResponseWriter, request *http.Request) {
+	capacities, err := h.vmService.ListHostCapacities(request.Context())
+	if err != nil {
+		writeResult(writer, http.StatusInternalServerError, GenericResponse{
+			Body:    nil,
+			Message: "failed to get host capacity",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    h.spAdapter.AdaptHostCapacitiesToAPI(capacities),
+		Message: "retrieved host capacity successfully",
+	})
+}
+
+// Events handles GET /events requests to list recorded lifecycle events, oldest first.
+// Events can be filtered by ?vm=, ?type= (a full type or a source such as "libvirt"),
+// ?since= (RFC 3339 time or a duration back from now, e.g. 12h) and capped with ?limit=.
+func (h *System) Events(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	filter := parameters.EventFilter{
+		VM:      query.Get("vm"),
+		Type:    query.Get("type"),
+		TraceID: query.Get("trace_id"),
+		JobID:   query.Get("job_id"),
+	}
+
+	if since := query.Get("since"); since != "" {
+		var err error
+		if filter.Since, err = parseSince(since, time.Now()); err != nil {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: "invalid since parameter",
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: "invalid limit parameter",
+				Error:   fmt.Sprintf("limit must be a non-negative integer, got %q", limit),
+			})
+			return
+		}
+	}
+
+	events, err := h.vmService.ListEvents(filter)
+	if err != nil {
+		writeResult(writer, http.StatusInternalServerError, GenericResponse{
+			Body:    nil,
+			Message: "failed to list events",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    h.spAdapter.AdaptEventsToAPI(events),
+		Message: "listed events successfully",
+	})
+}
+
+// CollectGarbage handles POST /admin/gc requests to remove the domains, disks and cloud-init
+// ISOs left behind by VM creations that failed after the domain was defined
+func (h *System) CollectGarbage(writer http.ResponseWriter, request *http.Request) {
+	report, err := h.vmService.CollectFailedVMs(request.Context(), time.Now())
+	body := contracts.GarbageCollectResponse{
+		Collected: report.Collected,
+		Adopted:   report.Adopted,
+		Failed:    report.Failed,
+	}
+	if err != nil {
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    body,
+			Message: "failed to collect failed VMs",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    body,
+		Message: fmt.Sprintf("collected %d failed VM(s)", len(report.Collected)),
+	})
+}
+
+// Maintenance handles POST /maintenance requests to put a hypervisor host into maintenance, optionally
+// migrating or shutting down its VMs, or to take it out of maintenance and restore them. The VMs
+// are moved by a job; the request returns as soon as it started.
+func (h *System) Maintenance(writer http.ResponseWriter, request *http.Request) {
+	var maintenanceRequest contracts.MaintenanceRequest
+	cb, err := parseBodyAndHandleError(writer, request, &maintenanceRequest, true)
+	if err != nil {
+		cb()
+		return
+	}
+
+	if maintenanceRequest.Host == "" {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "no host specified in request",
+		})
+		return
+	}
+
+	shutdownTimeout := defaultShutdownTimeout
+	if maintenanceRequest.ShutdownTimeout != "" {
+		if shutdownTimeout = h.spAdapter.AdaptDuration(maintenanceRequest.ShutdownTimeout); shutdownTimeout <= 0 {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: "shutdown_timeout must be a positive duration such as 5m",
+			})
+			return
+		}
+	}
+
+	host := maintenanceRequest.Host
+	mode := maintenanceRequest.Mode
+	if mode == "" && maintenanceRequest.Enabled {
+		mode = service.MaintenanceModeNone
+	}
+	if err := h.vmService.CheckMaintenance(host, mode, maintenanceRequest.Enabled); err != nil {
+		status := serviceErrorStatus(err)
+		switch {
+		case errors.Is(err, service.ErrInvalidMaintenance):
+			status = http.StatusBadRequest
+		case errors.Is(err, service.ErrHostInMaintenance), errors.Is(err, service.ErrHostNotInMaintenance):
+			status = http.StatusConflict
+		}
+		writeResult(writer, status, GenericResponse{
+			Body:    nil,
+			Message: "failed to change host maintenance",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	startJob(writer, request, h.vmService, h.spAdapter, service.JobMaintenance, nil, func(ctx context.Context) error {
+		var report parameters.MaintenanceReport
+		var err error
+		if maintenanceRequest.Enabled {
+			report, err = h.vmService.EnterMaintenance(ctx, host, mode, shutdownTimeout)
+		} else {
+			report, err = h.vmService.ExitMaintenance(ctx, host)
+		}
+		h.logger.Info("changed host maintenance",
+			slog.String("host", host),
+			slog.Bool("in_maintenance", report.InMaintenance),
+			slog.Any("migrated", report.Migrated),
+			slog.Any("stopped", report.Stopped),
+			slog.Any("started", report.Started),
+			slog.Any("failed", report.Failed),
+		)
+		return err
+	})
+}
+
+// ListMaintenance handles GET /maintenance requests to list the hypervisor hosts in maintenance
+func (h *System) ListMaintenance(writer http.ResponseWriter, request *http.Request) {
+	maintenance, err := h.vmService.ListMaintenance()
+	if err != nil {
+		writeResult(writer, http.StatusInternalServerError, GenericResponse{
+			Body:    nil,
+			Message: "failed to list host maintenance",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    h.spAdapter.AdaptHostMaintenanceToAPI(maintenance),
+		Message: "listed host maintenance successfully",
+	})
+}
+
+// Ready handles GET /readyz requests, answering 503 with the reason while the libvirt daemon
+// of any host is unreachable, e.g. because homonculus started before libvirtd on boot
+func (h *System) Ready(writer http.ResponseWriter, request *http.Request) {
+	readiness := h.spAdapter.AdaptHostConnectionsToAPI(h.vmService.HostConnections())
+	if readiness.Ready {
+		writeResult(writer, http.StatusOK, GenericResponse{
+			Body:    readiness,
+			Message: "ready",
+		})
+		return
+	}
+
+	var reasons []string
+	for _, host := range readiness.Hosts {
+		if !host.Reachable {
+			reasons = append(reasons, host.Host+": "+host.Error)
+		}
+	}
+	writeResult(writer, http.StatusServiceUnavailable, GenericResponse{
+		Body:    readiness,
+		Message: "degraded: libvirt unreachable",
+		Error:   strings.Join(reasons, "; "),
+	})
+}
+
+// TemplateRollout handles GET /admin/templates requests to show the loaded template versions
+// and how new VMs rendered with the candidate version differ
+func (h *System) TemplateRollout(writer http.ResponseWriter, request *http.Request) {
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    h.spAdapter.AdaptTemplateRolloutToAPI(h.vmService.TemplateRollout()),
+		Message: "retrieved template rollout successfully",
+	})
+}
+
+// StageTemplates handles POST /admin/templates/stage requests to load the template files
+// again as the candidate version, which new VMs are canary-rendered with
+func (h *System) StageTemplates(writer http.ResponseWriter, request *http.Request) {
+	rollout, err := h.vmService.StageTemplates(request.Context())
+	if err != nil {
+		writeResult(writer, http.StatusUnprocessableEntity, GenericResponse{
+			Body:    nil,
+			Message: "failed to stage templates",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    h.spAdapter.AdaptTemplateRolloutToAPI(rollout),
+		Message: "staged candidate templates successfully",
+	})
+}
+
+// SwitchTemplates handles POST /admin/templates/switch requests to make the candidate
+// template version active; switching again rolls back
+func (h *System) SwitchTemplates(writer http.ResponseWriter, request *http.Request) {
+	rollout, err := h.vmService.SwitchTemplates(request.Context())
+	if err != nil {
+		status := serviceErrorStatus(err)
+		if errors.Is(err, service.ErrNoTemplateCandidate) {
+			status = http.StatusConflict
+		}
+		writeResult(writer, status, GenericResponse{
+			Body:    nil,
+			Message: "failed to switch templates",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    h.spAdapter.AdaptTemplateRolloutToAPI(rollout),
+		Message: "switched active templates successfully",
+	})
+}
+
+// DiscardTemplates handles POST /admin/templates/discard requests to unload the candidate
+// template version
+func (h *System) DiscardTemplates(writer http.ResponseWriter, request *http.Request) {
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    h.spAdapter.AdaptTemplateRolloutToAPI(h.vmService.DiscardTemplates(request.Context())),
+		Message: "discarded candidate templates successfully",
+	})
+}
+
+// parseSince parses an absolute RFC 3339 time or a duration back from now
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if since, err := time.Parse(time.RFC3339, value); err == nil {
+		return since, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return time.Time{}, fmt.Errorf("since must be an RFC 3339 time or a positive duration, got %q", value)
+	}
+	return now.Add(-duration), nil
+}
+
 // CPUTopology handles GET /cpu-topology requests to display CPU and NUMA topology
 func (h *System) CPUTopology(writer http.ResponseWriter, request *http.Request) {
 	ctx := request.Context()
@@ -1,13 +1,29 @@
 package handler
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/terabiome/homonculus/internal/adapter"
 	"github.com/terabiome/homonculus/internal/api/contracts"
 	"github.com/terabiome/homonculus/internal/service"
 	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/constants"
+	"github.com/terabiome/homonculus/pkg/executor/qemuimg"
+	"github.com/terabiome/homonculus/pkg/labels"
+	"github.com/terabiome/homonculus/pkg/pathpolicy"
+	"github.com/terabiome/homonculus/pkg/units"
 )
 
 // VirtualMachine handles VM-related HTTP requests
@@ -26,8 +42,19 @@ func NewVirtualMachine(vmService *service.VMService, logger *slog.Logger, spAdap
 	}
 }
 
-// CreateCluster handles POST /create/cluster requests to create multiple VMs
+// CreateCluster handles POST /create/cluster requests to create multiple VMs. With ?async=true
+// the VMs are created by a job and the request returns as soon as it started.
 func (h *VirtualMachine) CreateCluster(writer http.ResponseWriter, request *http.Request) {
+	async, err := parseAsync(request)
+	if err != nil {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "invalid async parameter",
+			Error:   err.Error(),
+		})
+		return
+	}
+
 	var createRequest contracts.CreateClusterRequest
 	cb, err := parseBodyAndHandleError(writer, request, &createRequest, true)
 	if err != nil {
@@ -35,6 +62,15 @@ func (h *VirtualMachine) CreateCluster(writer http.ResponseWriter, request *http
 		return
 	}
 
+	if err := expandRequestVariables(&createRequest, &createRequest.Variables); err != nil {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "invalid request variables",
+			Error:   err.Error(),
+		})
+		return
+	}
+
 	if len(createRequest.VirtualMachines) == 0 {
 		writeResult(writer, http.StatusBadRequest, GenericResponse{
 			Body:    nil,
@@ -43,135 +79,1352 @@ func (h *VirtualMachine) CreateCluster(writer http.ResponseWriter, request *http
 		return
 	}
 
+	if createRequest.Name != "" {
+		if err := labels.Validate(map[string]string{service.LabelCluster: createRequest.Name}); err != nil {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: "invalid cluster name",
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+
+	for _, vm := range createRequest.VirtualMachines {
+		if message, err := validateCreateVM(vm); err != nil {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: message,
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+
+	// Logged in redacted form, see contracts.CreateClusterRequest.LogValue
+	h.logger.Debug("create cluster request", slog.Any("request", createRequest))
+
 	// Adapt API contract to service params
 	vmParams := h.spAdapter.AdaptCreateCluster(createRequest)
 
+	if async {
+		names, err := h.vmService.ClusterVMNames(vmParams)
+		if err != nil {
+			writeCreateError(writer, err, "failed to create virtual machine cluster")
+			return
+		}
+		h.startJob(writer, request, service.JobCreateCluster, names, func(ctx context.Context) error {
+			return h.vmService.CreateCluster(ctx, vmParams)
+		})
+		return
+	}
+
 	ctx := request.Context()
 	if err := h.vmService.CreateCluster(ctx, vmParams); err != nil {
-		writeResult(writer, http.StatusInternalServerError, GenericResponse{
+		writeCreateError(writer, err, "failed to create virtual machine cluster")
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    createRequest.Redacted(),
+		Message: "created virtual machine cluster successfully",
+	})
+}
+
+// Diff handles POST /diff requests comparing the domains a cluster spec renders to with the defined domains
+func (h *VirtualMachine) Diff(writer http.ResponseWriter, request *http.Request) {
+	var diffRequest contracts.CreateClusterRequest
+	cb, err := parseBodyAndHandleError(writer, request, &diffRequest, true)
+	if err != nil {
+		cb()
+		return
+	}
+
+	if err := expandRequestVariables(&diffRequest, &diffRequest.Variables); err != nil {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
 			Body:    nil,
-			Message: "failed to create virtual machine cluster",
+			Message: "invalid request variables",
 			Error:   err.Error(),
 		})
 		return
 	}
 
+	if len(diffRequest.VirtualMachines) == 0 {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "no virtual machines specified in request",
+		})
+		return
+	}
+
+	for _, vm := range diffRequest.VirtualMachines {
+		if message, err := validateCreateVM(vm); err != nil {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: message,
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+
+	diffs, err := h.vmService.DiffCluster(request.Context(), h.spAdapter.AdaptCreateCluster(diffRequest))
+	if err != nil {
+		writeCreateError(writer, err, "failed to diff virtual machines")
+		return
+	}
+
 	writeResult(writer, http.StatusOK, GenericResponse{
-		Body:    createRequest,
-		Message: "created virtual machine cluster successfully",
+		Body:    h.spAdapter.AdaptDomainDiffsToAPI(diffs),
+		Message: "diffed virtual machines successfully",
 	})
 }
 
-// DeleteCluster handles POST /delete/cluster requests to delete multiple VMs
-func (h *VirtualMachine) DeleteCluster(writer http.ResponseWriter, request *http.Request) {
-	var deleteRequest contracts.DeleteClusterRequest
-	cb, err := parseBodyAndHandleError(writer, request, &deleteRequest, true)
+// CreateFleet handles POST /create/fleet requests to create linked clones of a golden image
+func (h *VirtualMachine) CreateFleet(writer http.ResponseWriter, request *http.Request) {
+	var fleetRequest contracts.CreateFleetRequest
+	cb, err := parseBodyAndHandleError(writer, request, &fleetRequest, true)
 	if err != nil {
 		cb()
 		return
 	}
 
-	if len(deleteRequest.VirtualMachines) == 0 {
+	if err := expandRequestVariables(&fleetRequest, &fleetRequest.Variables); err != nil {
 		writeResult(writer, http.StatusBadRequest, GenericResponse{
 			Body:    nil,
-			Message: "no virtual machines specified in request",
+			Message: "invalid request variables",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if fleetRequest.Name == "" || fleetRequest.NamePrefix == "" || fleetRequest.Count < 1 {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "a fleet requires a name, a name_prefix and a positive count",
+		})
+		return
+	}
+	if fleetRequest.Template.BaseImagePath == "" {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "the fleet template requires a base_image_path to clone",
+		})
+		return
+	}
+	if fleetRequest.Template.Name != "" || fleetRequest.Template.NamePrefix != "" || fleetRequest.Template.Count != 0 {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "the fleet template must not set name, name_prefix or count",
+		})
+		return
+	}
+	if err := labels.Validate(map[string]string{service.LabelCluster: fleetRequest.Name}); err != nil {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "invalid fleet name",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if message, err := validateCreateVM(fleetRequest.Template); err != nil {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: message,
+			Error:   err.Error(),
 		})
 		return
 	}
 
+	// Logged in redacted form, see contracts.CreateFleetRequest.LogValue
+	h.logger.Debug("create fleet request", slog.Any("request", fleetRequest))
+
 	// Adapt API contract to service params
-	vmParams := h.spAdapter.AdaptDeleteCluster(deleteRequest)
+	fleetParams := h.spAdapter.AdaptCreateFleet(fleetRequest)
 
 	ctx := request.Context()
-	if err := h.vmService.DeleteCluster(ctx, vmParams); err != nil {
-		writeResult(writer, http.StatusInternalServerError, GenericResponse{
+	vms, err := h.vmService.CreateFleet(ctx, fleetParams)
+	if err != nil {
+		writeCreateError(writer, err, "failed to create virtual machine fleet")
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    h.spAdapter.AdaptFleetMembersToAPI(vms),
+		Message: "created virtual machine fleet successfully",
+	})
+}
+
+// validateCreateVM checks the configuration of a VM to create, returning a response message with the error
+func validateCreateVM(vm contracts.CreateVMRequest) (string, error) {
+	if err := labels.Validate(vm.Labels); err != nil {
+		return "invalid labels for virtual machine " + vm.Name, err
+	}
+	if err := validateSizes(vm.Memory, vm.MemoryMB, vm.DiskSize, vm.DiskSizeGB); err != nil {
+		return "invalid sizes for virtual machine " + vm.Name, err
+	}
+	if err := validateExpiry(vm); err != nil {
+		return "invalid ttl for virtual machine " + vm.Name, err
+	}
+	if err := validateNetboot(vm); err != nil {
+		return "invalid netboot configuration for virtual machine " + vm.Name, err
+	}
+	if err := validateReadinessProbes(vm.ReadinessProbes); err != nil {
+		return "invalid readiness probes for virtual machine " + vm.Name, err
+	}
+	if err := validateContainer(vm); err != nil {
+		return "invalid container configuration for virtual machine " + vm.Name, err
+	}
+	if err := validateDiskOptions(vm.DiskOptions); err != nil {
+		return "invalid disk options for virtual machine " + vm.Name, err
+	}
+	if err := validateProxy(vm.Proxy); err != nil {
+		return "invalid proxy for virtual machine " + vm.Name, err
+	}
+	if vm.IPFromPool != "" && vm.Netboot != nil && vm.Netboot.Server != nil {
+		return "invalid ip_from_pool for virtual machine " + vm.Name, errors.New("netboot VMs get their address from the netboot DHCP server")
+	}
+	return "", nil
+}
+
+// writeCreateError writes the response for a failed cluster or fleet creation
+func writeCreateError(writer http.ResponseWriter, err error, message string) {
+	if errors.Is(err, service.ErrInvalidCluster) {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
 			Body:    nil,
-			Message: "failed to delete virtual machine cluster",
+			Message: "invalid virtual machines",
 			Error:   err.Error(),
 		})
 		return
 	}
-
-	writeResult(writer, http.StatusOK, GenericResponse{
-		Body:    deleteRequest,
-		Message: "deleted virtual machine cluster successfully",
+	if errors.Is(err, service.ErrSSHKeyNotFound) || errors.Is(err, service.ErrInvalidSSHKey) {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "invalid ssh keys",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, service.ErrQuotaExceeded) {
+		writeResult(writer, http.StatusForbidden, GenericResponse{
+			Body:    nil,
+			Message: "resource quota exceeded",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, pathpolicy.ErrNotAllowed) {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "virtual machine paths are outside the allowed directories",
+			Error:   err.Error(),
+		})
+		return
+	}
+	writeResult(writer, serviceErrorStatus(err), GenericResponse{
+		Body:    nil,
+		Message: message,
+		Error:   err.Error(),
 	})
 }
 
-// StartCluster handles POST /start/cluster requests to start multiple VMs
-func (h *VirtualMachine) StartCluster(writer http.ResponseWriter, request *http.Request) {
-	var startRequest contracts.StartClusterRequest
-	cb, err := parseBodyAndHandleError(writer, request, &startRequest, true)
+// CloneCluster handles POST /clone/cluster requests to clone a base VM into multiple VMs. With
+// ?async=true the VMs are cloned by a job and the request returns as soon as it started.
+func (h *VirtualMachine) CloneCluster(writer http.ResponseWriter, request *http.Request) {
+	async, err := parseAsync(request)
+	if err != nil {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "invalid async parameter",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	var cloneRequest contracts.CloneClusterRequest
+	cb, err := parseBodyAndHandleError(writer, request, &cloneRequest, true)
 	if err != nil {
 		cb()
 		return
 	}
 
-	if len(startRequest.VirtualMachines) == 0 {
+	if err := expandRequestVariables(&cloneRequest, &cloneRequest.Variables); err != nil {
 		writeResult(writer, http.StatusBadRequest, GenericResponse{
 			Body:    nil,
-			Message: "no virtual machines specified in request",
+			Message: "invalid request variables",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if cloneRequest.BaseVM.Name == "" || len(cloneRequest.TargetVMs) == 0 {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "a base virtual machine and at least one target are required",
 		})
 		return
 	}
 
+	for _, target := range cloneRequest.TargetVMs {
+		if err := validateSizes(target.Memory, target.MemoryMB, target.DiskSize, target.DiskSizeGB); err != nil {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: "invalid sizes for virtual machine " + target.Name,
+				Error:   err.Error(),
+			})
+			return
+		}
+		if err := validateNetworkConfig(target.Network); err != nil {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: "invalid network for virtual machine " + target.Name,
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+
+	// Logged in redacted form, see contracts.CloneClusterRequest.LogValue
+	h.logger.Debug("clone cluster request", slog.Any("request", cloneRequest))
+
 	// Adapt API contract to service params
-	vmParams := h.spAdapter.AdaptStartCluster(startRequest)
+	cloneParams := h.spAdapter.AdaptCloneCluster(cloneRequest)
+
+	if async {
+		h.startJob(writer, request, service.JobCloneCluster, targetNames(cloneParams), func(ctx context.Context) error {
+			return h.vmService.CloneCluster(ctx, cloneParams)
+		})
+		return
+	}
 
 	ctx := request.Context()
-	if err := h.vmService.StartCluster(ctx, vmParams); err != nil {
-		writeResult(writer, http.StatusInternalServerError, GenericResponse{
+	if err := h.vmService.CloneCluster(ctx, cloneParams); err != nil {
+		if errors.Is(err, service.ErrInvalidCluster) {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: "invalid clone targets",
+				Error:   err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, service.ErrQuotaExceeded) {
+			writeResult(writer, http.StatusForbidden, GenericResponse{
+				Body:    nil,
+				Message: "resource quota exceeded",
+				Error:   err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, pathpolicy.ErrNotAllowed) {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: "virtual machine paths are outside the allowed directories",
+				Error:   err.Error(),
+			})
+			return
+		}
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
 			Body:    nil,
-			Message: "failed to start virtual machine cluster",
+			Message: "failed to clone virtual machine cluster",
 			Error:   err.Error(),
 		})
 		return
 	}
 
 	writeResult(writer, http.StatusOK, GenericResponse{
-		Body:    startRequest,
-		Message: "started virtual machine cluster successfully",
+		Body:    cloneRequest.Redacted(),
+		Message: "cloned virtual machine cluster successfully",
 	})
 }
 
-// QueryCluster handles GET /query/cluster requests to query VM information
-func (h *VirtualMachine) QueryCluster(writer http.ResponseWriter, request *http.Request) {
-	ctx := request.Context()
+// validateSizes checks the size strings of a VM, which replace the numeric fields in MiB and GiB.
+func validateSizes(memory string, memoryMB int64, diskSize string, diskSizeGB int64) error {
+	if memory != "" {
+		if memoryMB != 0 {
+			return errors.New("set either memory or memory_mb, not both")
+		}
+		if _, err := units.ParseSize(memory); err != nil {
+			return fmt.Errorf("memory: %w", err)
+		}
+	}
+	if diskSize != "" {
+		if diskSizeGB != 0 {
+			return errors.New("set either disk_size or disk_size_gb, not both")
+		}
+		if _, err := units.ParseSize(diskSize); err != nil {
+			return fmt.Errorf("disk_size: %w", err)
+		}
+	}
+	return nil
+}
 
-	// Check if specific VMs are requested via query parameter or body
-	var vmParams []parameters.QueryVM
-	var queryRequest contracts.QueryClusterRequest
+// validateNetworkConfig checks the addresses of a static guest network configuration.
+func validateNetworkConfig(network *contracts.NetworkConfig) error {
+	if network == nil {
+		return nil
+	}
+	if network.IPv4Address != "" {
+		prefix, err := netip.ParsePrefix(network.IPv4Address)
+		if err != nil || !prefix.Addr().Is4() {
+			return fmt.Errorf("ipv4_address must be an IPv4 address in CIDR notation, got %q", network.IPv4Address)
+		}
+	} else if network.IPv4Gateway != "" {
+		return fmt.Errorf("ipv4_gateway requires ipv4_address")
+	}
+	if network.IPv4Gateway != "" {
+		if addr, err := netip.ParseAddr(network.IPv4Gateway); err != nil || !addr.Is4() {
+			return fmt.Errorf("ipv4_gateway must be an IPv4 address, got %q", network.IPv4Gateway)
+		}
+	}
+	for _, nameserver := range network.Nameservers {
+		if _, err := netip.ParseAddr(nameserver); err != nil {
+			return fmt.Errorf("invalid nameserver %q", nameserver)
+		}
+	}
+	return nil
+}
 
-	// Try to parse body if present (for POST requests)
-	if request.Method == http.MethodPost {
-		cb, err := parseBodyAndHandleError(writer, request, &queryRequest, false)
+// noProxyPattern matches comma-separated hosts, domains, addresses and CIDRs.
+var noProxyPattern = regexp.MustCompile(`^[A-Za-z0-9*._:/\[\],-]*$`)
+
+// validateProxy checks the proxy URLs, which are rendered into cloud-init files and shell commands.
+func validateProxy(proxy *contracts.ProxyConfig) error {
+	if proxy == nil {
+		return nil
+	}
+	for field, value := range map[string]string{"http_proxy": proxy.HTTPProxy, "https_proxy": proxy.HTTPSProxy} {
+		if value == "" {
+			continue
+		}
+		parsed, err := url.Parse(value)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("%s must be an http:// or https:// URL, got %q", field, value)
+		}
+		if strings.ContainsAny(value, "\"'` \t\r\n") {
+			return fmt.Errorf("%s must not contain quotes or whitespace", field)
+		}
+	}
+	if !noProxyPattern.MatchString(proxy.NoProxy) {
+		return fmt.Errorf("no_proxy must be a comma-separated list of hosts, domains and CIDRs, got %q", proxy.NoProxy)
+	}
+	return nil
+}
+
+// netbootPathPattern restricts netboot paths to characters passed safely to the hypervisor shell.
+var netbootPathPattern = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+
+// bridgeNamePattern matches Linux interface names.
+var bridgeNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,15}$`)
+
+// validateExpiry checks the ttl and expiry action of a VM.
+func validateExpiry(vm contracts.CreateVMRequest) error {
+	if vm.TTL == "" {
+		if vm.OnExpiry != "" {
+			return fmt.Errorf("on_expiry requires a ttl")
+		}
+		return nil
+	}
+	ttl, err := time.ParseDuration(vm.TTL)
+	if err != nil || ttl <= 0 {
+		return fmt.Errorf("ttl must be a positive duration such as 30m or 4h, got '%s'", vm.TTL)
+	}
+	switch vm.OnExpiry {
+	case "", service.ExpiryActionDelete:
+	case service.ExpiryActionStop:
+		if vm.KeepRunning {
+			return fmt.Errorf("on_expiry '%s' conflicts with keep_running", service.ExpiryActionStop)
+		}
+	default:
+		return fmt.Errorf("on_expiry must be '%s' or '%s', got '%s'", service.ExpiryActionDelete, service.ExpiryActionStop, vm.OnExpiry)
+	}
+	return nil
+}
+
+// validateDiskOptions checks the qemu-img options of a disk.
+func validateDiskOptions(options *contracts.DiskOptions) error {
+	if options == nil {
+		return nil
+	}
+	formatOptions := qemuimg.FormatOptions{
+		Preallocation:   options.Preallocation,
+		CompressionType: options.CompressionType,
+	}
+	if options.ClusterSize != "" {
+		clusterSize, err := qemuimg.ParseClusterSize(options.ClusterSize)
 		if err != nil {
-			cb()
-			return
+			return err
 		}
-		if len(queryRequest.VirtualMachines) > 0 {
-			vmParams = h.spAdapter.AdaptQueryCluster(queryRequest)
+		formatOptions.ClusterSize = clusterSize
+	}
+	return formatOptions.Validate()
+}
+
+// validateReadinessProbes checks the type and target of every readiness probe.
+func validateReadinessProbes(probes []contracts.ReadinessProbe) error {
+	for i, probe := range probes {
+		if probe.Port < 0 || probe.Port > 65535 {
+			return fmt.Errorf("probe %d: port must be between 1 and 65535, got %d", i, probe.Port)
+		}
+		if probe.Timeout != "" {
+			if timeout, err := time.ParseDuration(probe.Timeout); err != nil || timeout <= 0 {
+				return fmt.Errorf("probe %d: timeout must be a positive duration such as 3s, got '%s'", i, probe.Timeout)
+			}
+		}
+
+		switch probe.Type {
+		case service.ProbeTCP:
+			if probe.Port == 0 {
+				return fmt.Errorf("probe %d: tcp probe requires a port", i)
+			}
+		case service.ProbeSSH, service.ProbeCloudInit:
+		case service.ProbeHTTP:
+			// Probes only reach the VM itself, so an absolute URL cannot aim them at other hosts.
+			target, err := url.Parse(probe.URL)
+			if err != nil || !strings.HasPrefix(probe.URL, "/") || target.Scheme != "" || target.Host != "" {
+				return fmt.Errorf("probe %d: http probe requires a path on the VM such as /healthz, got %q", i, probe.URL)
+			}
+		default:
+			return fmt.Errorf("probe %d: type must be '%s', '%s', '%s' or '%s', got '%s'", i,
+				service.ProbeTCP, service.ProbeSSH, service.ProbeHTTP, service.ProbeCloudInit, probe.Type)
 		}
 	}
+	return nil
+}
 
-	// Query the service
-	vmInfos, err := h.vmService.QueryCluster(ctx, vmParams)
-	if err != nil {
-		writeResult(writer, http.StatusInternalServerError, GenericResponse{
-			Body:    nil,
-			Message: "failed to query virtual machines",
-			Error:   err.Error(),
-		})
-		return
+// leaseTimePattern matches dnsmasq lease times such as 12h, 30m or infinite.
+var leaseTimePattern = regexp.MustCompile(`^([0-9]+[smhdw]?|infinite)$`)
+
+// validateNetboot checks the disk and DHCP/TFTP helper settings of a network-booted VM.
+func validateNetboot(vm contracts.CreateVMRequest) error {
+	if vm.Netboot == nil {
+		return nil
+	}
+	if vm.DiskPath != "" && vm.BaseImagePath == "" && vm.DiskSizeGB <= 0 && vm.DiskSize == "" {
+		return fmt.Errorf("disk_size_gb or disk_size is required for an empty disk")
 	}
 
-	// Convert service VMInfo to API VMInfo
-	response := contracts.QueryClusterResponse{
-		VirtualMachines: h.spAdapter.AdaptVMInfoToAPI(vmInfos),
+	server := vm.Netboot.Server
+	if server == nil {
+		return nil
+	}
+	if !bridgeNamePattern.MatchString(vm.BridgeNetworkInterface) {
+		return fmt.Errorf("netboot server requires a valid bridge_network_interface, got %q", vm.BridgeNetworkInterface)
 	}
 
-	writeResult(writer, http.StatusOK, GenericResponse{
-		Body:    response,
-		Message: "queried virtual machines successfully",
+	parts := strings.Split(server.DHCPRange, ",")
+	if len(parts) < 2 || len(parts) > 4 {
+		return fmt.Errorf("dhcp_range must be start,end[,netmask][,lease time], got %q", server.DHCPRange)
+	}
+	for i, part := range parts {
+		if i == len(parts)-1 && i >= 2 && leaseTimePattern.MatchString(part) {
+			continue
+		}
+		if addr, err := netip.ParseAddr(part); err != nil || !addr.Is4() || i > 2 {
+			return fmt.Errorf("dhcp_range must be start,end[,netmask][,lease time], got %q", server.DHCPRange)
+		}
+	}
+
+	if !filepath.IsAbs(server.TFTPRoot) || !netbootPathPattern.MatchString(server.TFTPRoot) {
+		return fmt.Errorf("tftp_root must be an absolute path, got %q", server.TFTPRoot)
+	}
+	if !netbootPathPattern.MatchString(server.BootFile) || filepath.IsAbs(server.BootFile) || slices.Contains(strings.Split(server.BootFile, "/"), "..") {
+		return fmt.Errorf("boot_file must be a path relative to tftp_root, got %q", server.BootFile)
+	}
+	return nil
+}
+
+// rootfsArchivePattern matches the root filesystem tarballs containers are unpacked from.
+var rootfsArchivePattern = regexp.MustCompile(`\.(tar|tar\.gz|tgz|tar\.xz|txz|tar\.zst)$`)
+
+// validateContainer checks that a container names its root filesystem and asks for no hardware
+// a system container cannot have.
+func validateContainer(vm contracts.CreateVMRequest) error {
+	if vm.MachineType != constants.MACHINE_TYPE_CONTAINER {
+		return nil
+	}
+	if vm.DiskPath == "" {
+		return fmt.Errorf("disk_path must name the root filesystem directory of a container")
+	}
+	if !rootfsArchivePattern.MatchString(strings.ToLower(vm.BaseImagePath)) {
+		return fmt.Errorf("base_image_path must be a root filesystem tarball for a container, got %q", vm.BaseImagePath)
+	}
+
+	var unsupported []string
+	for option, set := range map[string]bool{
+		"cloud_init_iso_path": vm.CloudInitISOPath != "",
+		"firmware":            vm.Firmware != "",
+		"secure_boot":         vm.SecureBoot,
+		"nvram_path":          vm.NVRAMPath != "",
+		"tpm":                 vm.TPM,
+		"tuning":              vm.Tuning != nil,
+		"hostdevs":            len(vm.HostDevices) > 0,
+		"usb_devices":         len(vm.USBDevices) > 0,
+		"serial_devices":      len(vm.SerialDevices) > 0,
+		"cdroms":              len(vm.CDROMs) > 0,
+		"watchdog":            vm.Watchdog != nil,
+		"graphics":            vm.Graphics != nil,
+		"netboot":             vm.Netboot != nil,
+		"disk_options":        vm.DiskOptions != nil,
+	} {
+		if set {
+			unsupported = append(unsupported, option)
+		}
+	}
+	if len(unsupported) > 0 {
+		slices.Sort(unsupported)
+		return fmt.Errorf("containers do not support %s", strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// DeleteCluster handles POST /delete/cluster requests to delete multiple VMs
+func (h *VirtualMachine) DeleteCluster(writer http.ResponseWriter, request *http.Request) {
+	selector, cb, err := parseSelector(writer, request)
+	if err != nil {
+		cb()
+		return
+	}
+
+	// A selector replaces the request body
+	var deleteRequest contracts.DeleteClusterRequest
+	cb, err = parseBodyAndHandleError(writer, request, &deleteRequest, selector.Empty())
+	if err != nil {
+		cb()
+		return
+	}
+
+	if !selector.Empty() {
+		names, err := h.selectVirtualMachineNames(request.Context(), selector)
+		if err != nil {
+			writeResult(writer, serviceErrorStatus(err), GenericResponse{
+				Body:    nil,
+				Message: "failed to resolve label selector",
+				Error:   err.Error(),
+			})
+			return
+		}
+		for _, name := range names {
+			deleteRequest.VirtualMachines = append(deleteRequest.VirtualMachines, contracts.DeleteVMRequest{Name: name})
+		}
+	}
+
+	if len(deleteRequest.VirtualMachines) == 0 {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "no virtual machines specified in request",
+		})
+		return
+	}
+
+	// Adapt API contract to service params
+	vmParams := h.spAdapter.AdaptDeleteCluster(deleteRequest)
+
+	ctx := request.Context()
+	if err := h.vmService.DeleteCluster(ctx, vmParams); err != nil {
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to delete virtual machine cluster",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    deleteRequest,
+		Message: "deleted virtual machine cluster successfully",
+	})
+}
+
+// Adopt handles POST /adopt requests to take over a libvirt domain defined outside homonculus
+func (h *VirtualMachine) Adopt(writer http.ResponseWriter, request *http.Request) {
+	var adoptRequest contracts.AdoptVMRequest
+	cb, err := parseBodyAndHandleError(writer, request, &adoptRequest, true)
+	if err != nil {
+		cb()
+		return
+	}
+
+	if adoptRequest.Name == "" {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "a virtual machine name is required",
+		})
+		return
+	}
+	adoptLabels := maps.Clone(adoptRequest.Labels)
+	if adoptRequest.Cluster != "" {
+		if adoptLabels == nil {
+			adoptLabels = make(map[string]string)
+		}
+		adoptLabels[service.LabelCluster] = adoptRequest.Cluster
+	}
+	if err := labels.Validate(adoptLabels); err != nil {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "invalid labels for virtual machine " + adoptRequest.Name,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	vmInfo, err := h.vmService.AdoptVirtualMachine(request.Context(), h.spAdapter.AdaptAdoptVM(adoptRequest))
+	if err != nil {
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to adopt virtual machine",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    h.spAdapter.AdaptVMInfoToAPI([]parameters.VMInfo{vmInfo})[0],
+		Message: "adopted virtual machine successfully",
+	})
+}
+
+// StartCluster handles POST /start/cluster requests to start multiple VMs. Dependent VMs wait
+// for their dependencies to become ready, so with ?async=true the VMs are started by a job.
+func (h *VirtualMachine) StartCluster(writer http.ResponseWriter, request *http.Request) {
+	async, err := parseAsync(request)
+	if err != nil {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "invalid async parameter",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	selector, cb, err := parseSelector(writer, request)
+	if err != nil {
+		cb()
+		return
+	}
+
+	// A selector replaces the request body
+	var startRequest contracts.StartClusterRequest
+	cb, err = parseBodyAndHandleError(writer, request, &startRequest, selector.Empty())
+	if err != nil {
+		cb()
+		return
+	}
+
+	if !selector.Empty() {
+		names, err := h.selectVirtualMachineNames(request.Context(), selector)
+		if err != nil {
+			writeResult(writer, serviceErrorStatus(err), GenericResponse{
+				Body:    nil,
+				Message: "failed to resolve label selector",
+				Error:   err.Error(),
+			})
+			return
+		}
+		for _, name := range names {
+			startRequest.VirtualMachines = append(startRequest.VirtualMachines, contracts.StartVMRequest{Name: name})
+		}
+	}
+
+	if len(startRequest.VirtualMachines) == 0 {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "no virtual machines specified in request",
+		})
+		return
+	}
+
+	readyTimeout := defaultReadyTimeout
+	if startRequest.ReadyTimeout != "" {
+		if readyTimeout = h.spAdapter.AdaptDuration(startRequest.ReadyTimeout); readyTimeout <= 0 {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: "ready_timeout must be a positive duration such as 5m",
+			})
+			return
+		}
+	}
+
+	// Adapt API contract to service params
+	vmParams := h.spAdapter.AdaptStartCluster(startRequest)
+
+	if async {
+		names := make([]string, len(vmParams))
+		for i, vm := range vmParams {
+			names[i] = vm.Name
+		}
+		h.startJob(writer, request, service.JobStartCluster, names, func(ctx context.Context) error {
+			return h.vmService.StartCluster(ctx, vmParams, readyTimeout)
+		})
+		return
+	}
+
+	ctx := request.Context()
+	if err := h.vmService.StartCluster(ctx, vmParams, readyTimeout); err != nil {
+		if errors.Is(err, service.ErrInvalidDependencies) {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: "invalid virtual machine dependencies",
+				Error:   err.Error(),
+			})
+			return
+		}
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to start virtual machine cluster",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    startRequest,
+		Message: "started virtual machine cluster successfully",
+	})
+}
+
+// defaultShutdownTimeout bounds how long stopping VMs waits for the VMs depending on them to shut off.
+const defaultShutdownTimeout = 5 * time.Minute
+
+// StopCluster handles POST /stop/cluster requests to gracefully shut down multiple VMs. VMs
+// others depend on wait for those to shut off, so with ?async=true the VMs are stopped by a job.
+func (h *VirtualMachine) StopCluster(writer http.ResponseWriter, request *http.Request) {
+	async, err := parseAsync(request)
+	if err != nil {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "invalid async parameter",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	selector, cb, err := parseSelector(writer, request)
+	if err != nil {
+		cb()
+		return
+	}
+
+	// A selector replaces the request body
+	var stopRequest contracts.StopClusterRequest
+	cb, err = parseBodyAndHandleError(writer, request, &stopRequest, selector.Empty())
+	if err != nil {
+		cb()
+		return
+	}
+
+	if !selector.Empty() {
+		names, err := h.selectVirtualMachineNames(request.Context(), selector)
+		if err != nil {
+			writeResult(writer, serviceErrorStatus(err), GenericResponse{
+				Body:    nil,
+				Message: "failed to resolve label selector",
+				Error:   err.Error(),
+			})
+			return
+		}
+		for _, name := range names {
+			stopRequest.VirtualMachines = append(stopRequest.VirtualMachines, contracts.StopVMRequest{Name: name})
+		}
+	}
+
+	if len(stopRequest.VirtualMachines) == 0 {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "no virtual machines specified in request",
+		})
+		return
+	}
+
+	shutdownTimeout := defaultShutdownTimeout
+	if stopRequest.ShutdownTimeout != "" {
+		if shutdownTimeout = h.spAdapter.AdaptDuration(stopRequest.ShutdownTimeout); shutdownTimeout <= 0 {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: "shutdown_timeout must be a positive duration such as 5m",
+			})
+			return
+		}
+	}
+
+	// Adapt API contract to service params
+	vmParams := h.spAdapter.AdaptStopCluster(stopRequest)
+
+	if async {
+		names := make([]string, len(vmParams))
+		for i, vm := range vmParams {
+			names[i] = vm.Name
+		}
+		h.startJob(writer, request, service.JobStopCluster, names, func(ctx context.Context) error {
+			return h.vmService.StopCluster(ctx, vmParams, shutdownTimeout)
+		})
+		return
+	}
+
+	ctx := request.Context()
+	if err := h.vmService.StopCluster(ctx, vmParams, shutdownTimeout); err != nil {
+		if errors.Is(err, service.ErrInvalidDependencies) {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: "invalid virtual machine dependencies",
+				Error:   err.Error(),
+			})
+			return
+		}
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to stop virtual machine cluster",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    stopRequest,
+		Message: "stopped virtual machine cluster successfully",
+	})
+}
+
+// ResetCluster handles POST /reset/cluster requests to revert every VM of a named cluster to a snapshot
+func (h *VirtualMachine) ResetCluster(writer http.ResponseWriter, request *http.Request) {
+	var resetRequest contracts.ResetClusterRequest
+	cb, err := parseBodyAndHandleError(writer, request, &resetRequest, true)
+	if err != nil {
+		cb()
+		return
+	}
+
+	if resetRequest.Cluster == "" || resetRequest.Snapshot == "" {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "cluster and snapshot are required",
+		})
+		return
+	}
+
+	ctx := request.Context()
+	if err := h.vmService.ResetCluster(ctx, resetRequest.Cluster, resetRequest.Snapshot); err != nil {
+		status := serviceErrorStatus(err)
+		if errors.Is(err, service.ErrClusterNotFound) {
+			status = http.StatusNotFound
+		}
+		writeResult(writer, status, GenericResponse{
+			Body:    nil,
+			Message: "failed to reset virtual machine cluster",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    resetRequest,
+		Message: "reset virtual machine cluster successfully",
+	})
+}
+
+// QueryCluster handles GET /query/cluster requests to query VM information
+func (h *VirtualMachine) QueryCluster(writer http.ResponseWriter, request *http.Request) {
+	ctx := request.Context()
+
+	selector, cb, err := parseSelector(writer, request)
+	if err != nil {
+		cb()
+		return
+	}
+
+	if !selector.Empty() {
+		vmInfos, err := h.vmService.SelectVirtualMachines(ctx, selector)
+		if err != nil {
+			writeResult(writer, serviceErrorStatus(err), GenericResponse{
+				Body:    nil,
+				Message: "failed to query virtual machines",
+				Error:   err.Error(),
+			})
+			return
+		}
+
+		writeResult(writer, http.StatusOK, GenericResponse{
+			Body: contracts.QueryClusterResponse{
+				VirtualMachines: h.spAdapter.AdaptVMInfoToAPI(vmInfos),
+			},
+			Message: "queried virtual machines successfully",
+		})
+		return
+	}
+
+	// Check if specific VMs are requested via query parameter or body
+	var vmParams []parameters.QueryVM
+	var queryRequest contracts.QueryClusterRequest
+
+	// Try to parse body if present (for POST requests)
+	if request.Method == http.MethodPost {
+		cb, err := parseBodyAndHandleError(writer, request, &queryRequest, false)
+		if err != nil {
+			cb()
+			return
+		}
+		if len(queryRequest.VirtualMachines) > 0 {
+			vmParams = h.spAdapter.AdaptQueryCluster(queryRequest)
+		}
+	}
+
+	// Query the service
+	vmInfos, err := h.vmService.QueryCluster(ctx, vmParams)
+	if err != nil {
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to query virtual machines",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// Convert service VMInfo to API VMInfo
+	response := contracts.QueryClusterResponse{
+		VirtualMachines: h.spAdapter.AdaptVMInfoToAPI(vmInfos),
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    response,
+		Message: "queried virtual machines successfully",
+	})
+}
+
+// selectVirtualMachineNames resolves a label selector into the names of matching VMs
+func (h *VirtualMachine) selectVirtualMachineNames(ctx context.Context, selector labels.Selector) ([]string, error) {
+	vmInfos, err := h.vmService.SelectVirtualMachines(ctx, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(vmInfos))
+	for i, vmInfo := range vmInfos {
+		names[i] = vmInfo.Name
+	}
+	return names, nil
+}
+
+// AttachDevices handles POST /attach/devices requests to hotplug USB and serial devices into a VM
+func (h *VirtualMachine) AttachDevices(writer http.ResponseWriter, request *http.Request) {
+	var attachRequest contracts.AttachDevicesRequest
+	cb, err := parseBodyAndHandleError(writer, request, &attachRequest, true)
+	if err != nil {
+		cb()
+		return
+	}
+
+	if attachRequest.Name == "" || (len(attachRequest.USBDevices) == 0 && len(attachRequest.SerialDevices) == 0) {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "a virtual machine name and at least one device are required",
+		})
+		return
+	}
+
+	ports, err := h.vmService.AttachDevices(request.Context(), h.spAdapter.AdaptAttachDevices(attachRequest))
+	if err != nil {
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to attach devices",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    contracts.AttachDevicesResponse{Name: attachRequest.Name, SerialPorts: ports},
+		Message: "attached devices successfully",
+	})
+}
+
+// DetachDevices handles POST /detach/devices requests to unplug USB and serial devices from a VM
+func (h *VirtualMachine) DetachDevices(writer http.ResponseWriter, request *http.Request) {
+	var detachRequest contracts.DetachDevicesRequest
+	cb, err := parseBodyAndHandleError(writer, request, &detachRequest, true)
+	if err != nil {
+		cb()
+		return
+	}
+
+	if detachRequest.Name == "" || (len(detachRequest.USBDevices) == 0 && len(detachRequest.SerialPorts) == 0) {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "a virtual machine name and at least one device are required",
+		})
+		return
+	}
+
+	if err := h.vmService.DetachDevices(request.Context(), h.spAdapter.AdaptDetachDevices(detachRequest)); err != nil {
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to detach devices",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    detachRequest,
+		Message: "detached devices successfully",
+	})
+}
+
+// InsertMedia handles POST /insert/media requests to insert an ISO into a CD-ROM drive of a VM
+func (h *VirtualMachine) InsertMedia(writer http.ResponseWriter, request *http.Request) {
+	var insertRequest contracts.InsertMediaRequest
+	cb, err := parseBodyAndHandleError(writer, request, &insertRequest, true)
+	if err != nil {
+		cb()
+		return
+	}
+
+	if insertRequest.Name == "" || insertRequest.Path == "" {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "a virtual machine name and a media path are required",
+		})
+		return
+	}
+
+	if err := h.vmService.InsertMedia(request.Context(), h.spAdapter.AdaptInsertMedia(insertRequest)); err != nil {
+		if errors.Is(err, pathpolicy.ErrNotAllowed) {
+			writeResult(writer, http.StatusBadRequest, GenericResponse{
+				Body:    nil,
+				Message: "media path is outside the allowed directories",
+				Error:   err.Error(),
+			})
+			return
+		}
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to insert media",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    insertRequest,
+		Message: "inserted media successfully",
+	})
+}
+
+// EjectMedia handles POST /eject/media requests to empty a CD-ROM drive of a VM
+func (h *VirtualMachine) EjectMedia(writer http.ResponseWriter, request *http.Request) {
+	var ejectRequest contracts.EjectMediaRequest
+	cb, err := parseBodyAndHandleError(writer, request, &ejectRequest, true)
+	if err != nil {
+		cb()
+		return
+	}
+
+	if ejectRequest.Name == "" {
+		writeResult(writer, http.StatusBadRequest, GenericResponse{
+			Body:    nil,
+			Message: "a virtual machine name is required",
+		})
+		return
+	}
+
+	if err := h.vmService.EjectMedia(request.Context(), h.spAdapter.AdaptEjectMedia(ejectRequest)); err != nil {
+		writeResult(writer, serviceErrorStatus(err), GenericResponse{
+			Body:    nil,
+			Message: "failed to eject media",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    ejectRequest,
+		Message: "ejected media successfully",
+	})
+}
+
+// CreateSchedule handles POST /schedules requests to register a recurring action
+func (h *VirtualMachine) CreateSchedule(writer http.ResponseWriter, request *http.Request) {
+	var scheduleRequest contracts.CreateScheduleRequest
+	cb, err := parseBodyAndHandleError(writer, request, &scheduleRequest, true)
+	if err != nil {
+		cb()
+		return
+	}
+
+	schedule, err := h.vmService.CreateSchedule(h.spAdapter.AdaptCreateSchedule(scheduleRequest))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrInvalidSchedule) {
+			status = http.StatusBadRequest
+		}
+		writeResult(writer, status, GenericResponse{
+			Body:    nil,
+			Message: "failed to create schedule",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    h.spAdapter.AdaptScheduleToAPI(schedule),
+		Message: "created schedule successfully",
+	})
+}
+
+// ListSchedules handles GET /schedules requests
+func (h *VirtualMachine) ListSchedules(writer http.ResponseWriter, request *http.Request) {
+	schedules, err := h.vmService.ListSchedules()
+	if err != nil {
+		writeResult(writer, http.StatusInternalServerError, GenericResponse{
+			Body:    nil,
+			Message: "failed to list schedules",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    contracts.ListSchedulesResponse{Schedules: h.spAdapter.AdaptSchedulesToAPI(schedules)},
+		Message: "listed schedules successfully",
+	})
+}
+
+// DeleteSchedule handles DELETE /schedules/{id} requests to cancel a schedule
+func (h *VirtualMachine) DeleteSchedule(writer http.ResponseWriter, request *http.Request) {
+	id := request.PathValue("id")
+
+	found, err := h.vmService.DeleteSchedule(id)
+	if err != nil {
+		writeResult(writer, http.StatusInternalServerError, GenericResponse{
+			Body:    nil,
+			Message: "failed to delete schedule",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if !found {
+		writeResult(writer, http.StatusNotFound, GenericResponse{
+			Body:    nil,
+			Message: "schedule not found",
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    nil,
+		Message: "deleted schedule successfully",
+	})
+}
+
+// GenerateSSHKey handles POST /sshkeys requests to generate a keypair
+func (h *VirtualMachine) GenerateSSHKey(writer http.ResponseWriter, request *http.Request) {
+	var keyRequest contracts.GenerateSSHKeyRequest
+	cb, err := parseBodyAndHandleError(writer, request, &keyRequest, true)
+	if err != nil {
+		cb()
+		return
+	}
+
+	key, err := h.vmService.GenerateSSHKey(h.spAdapter.AdaptGenerateSSHKey(keyRequest))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrInvalidSSHKey) {
+			status = http.StatusBadRequest
+		}
+		writeResult(writer, status, GenericResponse{
+			Body:    nil,
+			Message: "failed to generate ssh key",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    h.spAdapter.AdaptSSHKeyToAPI(key),
+		Message: "generated ssh key successfully",
+	})
+}
+
+// ListSSHKeys handles GET /sshkeys requests
+func (h *VirtualMachine) ListSSHKeys(writer http.ResponseWriter, request *http.Request) {
+	keys, err := h.vmService.ListSSHKeys()
+	if err != nil {
+		writeResult(writer, http.StatusInternalServerError, GenericResponse{
+			Body:    nil,
+			Message: "failed to list ssh keys",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    contracts.ListSSHKeysResponse{SSHKeys: h.spAdapter.AdaptSSHKeysToAPI(keys)},
+		Message: "listed ssh keys successfully",
+	})
+}
+
+// ListIPPools handles GET /ip-pools requests to list the configured IP pools and their allocations
+func (h *VirtualMachine) ListIPPools(writer http.ResponseWriter, request *http.Request) {
+	pools, err := h.vmService.ListIPPools()
+	if err != nil {
+		writeResult(writer, http.StatusInternalServerError, GenericResponse{
+			Body:    nil,
+			Message: "failed to list ip pools",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    contracts.ListIPPoolsResponse{IPPools: h.spAdapter.AdaptIPPoolsToAPI(pools)},
+		Message: "listed ip pools successfully",
+	})
+}
+
+// GetSSHPrivateKey handles GET /sshkeys/{name}/private requests to retrieve a stored private key
+func (h *VirtualMachine) GetSSHPrivateKey(writer http.ResponseWriter, request *http.Request) {
+	key, err := h.vmService.GetSSHPrivateKey(request.PathValue("name"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, service.ErrSSHKeyNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, service.ErrInvalidSSHKey):
+			status = http.StatusBadRequest
+		}
+		writeResult(writer, status, GenericResponse{
+			Body:    nil,
+			Message: "failed to get ssh private key",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    h.spAdapter.AdaptSSHKeyToAPI(key),
+		Message: "retrieved ssh private key successfully",
+	})
+}
+
+// DeleteSSHKey handles DELETE /sshkeys/{name} requests
+func (h *VirtualMachine) DeleteSSHKey(writer http.ResponseWriter, request *http.Request) {
+	found, err := h.vmService.DeleteSSHKey(request.PathValue("name"))
+	if err != nil {
+		writeResult(writer, http.StatusInternalServerError, GenericResponse{
+			Body:    nil,
+			Message: "failed to delete ssh key",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if !found {
+		writeResult(writer, http.StatusNotFound, GenericResponse{
+			Body:    nil,
+			Message: "ssh key not found",
+		})
+		return
+	}
+
+	writeResult(writer, http.StatusOK, GenericResponse{
+		Body:    nil,
+		Message: "deleted ssh key successfully",
 	})
 }
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// isYAML reports whether a Content-Type header names a YAML document.
+func isYAML(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/yaml", "application/x-yaml", "text/yaml":
+		return true
+	}
+	return false
+}
+
+// decodeYAML decodes a YAML document into target through its JSON encoding, so the json tags
+// and decoding rules of the request contracts apply.
+func decodeYAML(r io.Reader, target any) error {
+	var document any
+	if err := yaml.NewDecoder(r).Decode(&document); err != nil {
+		return err
+	}
+	data, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	return json.NewDecoder(bytes.NewReader(data)).Decode(target)
+}
+
+// marshalYAML encodes v as YAML with the keys and omissions of its JSON encoding, so the
+// document reads back through decodeYAML.
+func marshalYAML(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	resetYAMLStyle(&node)
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// resetYAMLStyle drops the flow and quoting styles decoded from JSON, so nodes are written in
+// block style and scalars are only quoted where YAML requires it.
+func resetYAMLStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetYAMLStyle(child)
+	}
+}
@@ -0,0 +1,311 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/terabiome/homonculus/internal/api/contracts"
+	"github.com/terabiome/homonculus/internal/api/handler"
+	"github.com/terabiome/homonculus/internal/service"
+)
+
+const (
+	// AuthMethodToken identifies callers authenticated with a bearer token.
+	AuthMethodToken = "token"
+
+	// AuthMethodCertificate identifies callers authenticated with a verified client certificate.
+	AuthMethodCertificate = "certificate"
+
+	// AuthMethodAnonymous identifies unauthenticated callers of read-only requests.
+	AuthMethodAnonymous = "anonymous"
+)
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	Name   string
+	Method string
+	Role   Role
+	// Namespace confines the caller to one namespace, or is empty for unconfined callers.
+	Namespace string
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying identity. Authenticated callers are also
+// passed to the VM service, which records them as the owners of the VMs they create.
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	if identity.Method != AuthMethodAnonymous {
+		ctx = service.WithCaller(ctx, identity.Name)
+	}
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the authenticated caller stored in ctx, if any.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+// Token is a named bearer token, identified by the SHA-256 hash of its value.
+// An empty role falls back to the authenticator's default role. A token bound to a
+// namespace only operates on the VMs of that namespace.
+type Token struct {
+	Name      string
+	SHA256    string
+	Role      Role
+	Namespace string
+}
+
+// Options configures an Authenticator.
+type Options struct {
+	Tokens []Token
+	// CertificateRoles maps client certificate common names to roles.
+	CertificateRoles map[string]Role
+	// DefaultRole is granted to tokens and certificates without an explicit role.
+	DefaultRole Role
+	// AnonymousRead allows read-only requests without credentials, with the viewer role.
+	AnonymousRead bool
+}
+
+// HashToken returns the hex SHA-256 hash a token is configured with.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadTokenFile reads tokens from a file with one "name:sha256[:role]" entry per line.
+// Blank lines and lines starting with # are ignored.
+func LoadTokenFile(path string) ([]Token, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open token file: %w", err)
+	}
+	defer file.Close()
+
+	var tokens []Token
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 2 || len(fields) > 3 || strings.TrimSpace(fields[0]) == "" || strings.TrimSpace(fields[1]) == "" {
+			return nil, fmt.Errorf("token file %s line %d: expected name:sha256[:role]", path, lineNumber)
+		}
+		token := Token{Name: strings.TrimSpace(fields[0]), SHA256: strings.TrimSpace(fields[1])}
+		if len(fields) == 3 {
+			role, err := ParseRole(strings.TrimSpace(fields[2]))
+			if err != nil {
+				return nil, fmt.Errorf("token file %s line %d: %w", path, lineNumber, err)
+			}
+			token.Role = role
+		}
+		tokens = append(tokens, token)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+	return tokens, nil
+}
+
+// Authenticator resolves the identity of API callers from bearer tokens and client certificates.
+type Authenticator struct {
+	tokens           map[[sha256.Size]byte]Token
+	certificateRoles map[string]Role
+	defaultRole      Role
+	anonymousRead    bool
+	logger           *slog.Logger
+}
+
+// NewAuthenticator creates an authenticator from options.
+func NewAuthenticator(options Options, logger *slog.Logger) (*Authenticator, error) {
+	if _, err := ParseRole(string(options.DefaultRole)); err != nil {
+		return nil, fmt.Errorf("default role: %w", err)
+	}
+
+	authenticator := &Authenticator{
+		tokens:           make(map[[sha256.Size]byte]Token, len(options.Tokens)),
+		certificateRoles: make(map[string]Role, len(options.CertificateRoles)),
+		defaultRole:      options.DefaultRole,
+		anonymousRead:    options.AnonymousRead,
+		logger:           logger,
+	}
+
+	for _, token := range options.Tokens {
+		decoded, err := hex.DecodeString(strings.ToLower(token.SHA256))
+		if err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("token %s: sha256 must be %d hex characters", token.Name, 2*sha256.Size)
+		}
+		hash := [sha256.Size]byte(decoded)
+		if existing, ok := authenticator.tokens[hash]; ok {
+			return nil, fmt.Errorf("token %s: same hash as token %s", token.Name, existing.Name)
+		}
+		if token.Role == "" {
+			token.Role = options.DefaultRole
+		}
+		if _, err := ParseRole(string(token.Role)); err != nil {
+			return nil, fmt.Errorf("token %s: %w", token.Name, err)
+		}
+		if token.Namespace != "" {
+			if err := service.ValidateNamespace(token.Namespace); err != nil {
+				return nil, fmt.Errorf("token %s: %w", token.Name, err)
+			}
+		}
+		authenticator.tokens[hash] = token
+	}
+
+	for commonName, role := range options.CertificateRoles {
+		if _, err := ParseRole(string(role)); err != nil {
+			return nil, fmt.Errorf("certificate %s: %w", commonName, err)
+		}
+		authenticator.certificateRoles[commonName] = role
+	}
+
+	return authenticator, nil
+}
+
+// Middleware rejects requests without valid credentials and stores the caller identity in the request context.
+// A nil authenticator disables authentication.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		identity, ok, err := a.authenticate(request)
+		if err != nil {
+			a.logger.Warn("rejected API request",
+				slog.String("method", request.Method),
+				slog.String("path", request.URL.Path),
+				slog.String("remote", request.RemoteAddr),
+				slog.String("error", err.Error()),
+			)
+			writeUnauthorized(writer, err)
+			return
+		}
+
+		if !ok {
+			if requiresIdentity(request) || !a.anonymousRead {
+				writeUnauthorized(writer, fmt.Errorf("missing credentials"))
+				return
+			}
+			identity = Identity{Name: AuthMethodAnonymous, Method: AuthMethodAnonymous, Role: RoleViewer}
+		}
+
+		next.ServeHTTP(writer, request.WithContext(WithIdentity(request.Context(), identity)))
+	})
+}
+
+// authenticate returns the caller identity, or ok=false when the request carries no credentials.
+func (a *Authenticator) authenticate(request *http.Request) (Identity, bool, error) {
+	token, found, err := bearerToken(request)
+	if err != nil {
+		return Identity{}, false, err
+	}
+	if found {
+		matched, ok := a.lookupToken(token)
+		if !ok {
+			return Identity{}, false, fmt.Errorf("invalid token")
+		}
+		return Identity{Name: matched.Name, Method: AuthMethodToken, Role: matched.Role, Namespace: matched.Namespace}, true, nil
+	}
+
+	// The TLS listener only populates verified chains for certificates signed by the client CA.
+	if request.TLS != nil && len(request.TLS.VerifiedChains) > 0 && len(request.TLS.VerifiedChains[0]) > 0 {
+		leaf := request.TLS.VerifiedChains[0][0]
+		if leaf.Subject.CommonName == "" {
+			return Identity{}, false, fmt.Errorf("client certificate has no common name")
+		}
+		role, ok := a.certificateRoles[leaf.Subject.CommonName]
+		if !ok {
+			role = a.defaultRole
+		}
+		return Identity{Name: leaf.Subject.CommonName, Method: AuthMethodCertificate, Role: role}, true, nil
+	}
+
+	return Identity{}, false, nil
+}
+
+// WebsocketTokenPrefix starts the websocket subprotocol carrying a bearer token, base64url encoded
+// without padding. Browsers cannot set the Authorization header of websockets, but can offer
+// subprotocols; the server never selects this one.
+const WebsocketTokenPrefix = "bearer.homonculus.io."
+
+// bearerToken returns the bearer token of the Authorization header or, for websocket upgrades
+// without that header, of a subprotocol starting with WebsocketTokenPrefix.
+func bearerToken(request *http.Request) (string, bool, error) {
+	if header := request.Header.Get("Authorization"); header != "" {
+		scheme, token, found := strings.Cut(header, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+			return "", false, fmt.Errorf("malformed authorization header")
+		}
+		return strings.TrimSpace(token), true, nil
+	}
+
+	if !strings.EqualFold(request.Header.Get("Upgrade"), "websocket") {
+		return "", false, nil
+	}
+	for _, protocol := range websocket.Subprotocols(request) {
+		encoded, found := strings.CutPrefix(protocol, WebsocketTokenPrefix)
+		if !found {
+			continue
+		}
+		token, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil || len(token) == 0 {
+			return "", false, fmt.Errorf("malformed token subprotocol")
+		}
+		return string(token), true, nil
+	}
+	return "", false, nil
+}
+
+// lookupToken returns the token matching value.
+func (a *Authenticator) lookupToken(value string) (Token, bool) {
+	hash := sha256.Sum256([]byte(value))
+	for candidate, token := range a.tokens {
+		if subtle.ConstantTimeCompare(candidate[:], hash[:]) == 1 {
+			return token, true
+		}
+	}
+	return Token{}, false
+}
+
+// requiresIdentity reports whether a request mutates state: any non-read method, or a websocket upgrade.
+func requiresIdentity(request *http.Request) bool {
+	switch request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return strings.EqualFold(request.Header.Get("Upgrade"), "websocket")
+	default:
+		return true
+	}
+}
+
+// writeUnauthorized writes a 401 response asking for bearer credentials.
+func writeUnauthorized(writer http.ResponseWriter, err error) {
+	writer.Header().Set("WWW-Authenticate", `Bearer realm="homonculus"`)
+	writeError(writer, http.StatusUnauthorized, "unauthorized", err)
+}
+
+// writeError writes an error response in the API's standard response format.
+func writeError(writer http.ResponseWriter, statusCode int, message string, err error) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(statusCode)
+	json.NewEncoder(writer).Encode(handler.GenericResponse{
+		Message: message,
+		Error:   err.Error(),
+		TraceID: writer.Header().Get(contracts.TraceIDHeader),
+		JobID:   writer.Header().Get(contracts.JobIDHeader),
+	})
+}
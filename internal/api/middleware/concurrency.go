@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/terabiome/homonculus/internal/opslot"
+)
+
+// OperationLimiter caps the number of provisioning operations running at once across all clients.
+type OperationLimiter struct {
+	slots  chan struct{}
+	logger *slog.Logger
+}
+
+// NewOperationLimiter creates a limiter admitting at most maxConcurrent operations.
+func NewOperationLimiter(maxConcurrent int, logger *slog.Logger) *OperationLimiter {
+	return &OperationLimiter{
+		slots:  make(chan struct{}, maxConcurrent),
+		logger: logger,
+	}
+}
+
+// Guard wraps next so it is rejected with 409 Conflict while all operation slots are taken.
+// A nil limiter disables the cap.
+func (l *OperationLimiter) Guard(next http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return next
+	}
+
+	return func(writer http.ResponseWriter, request *http.Request) {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			l.logger.Warn("rejected provisioning operation",
+				slog.String("method", request.Method),
+				slog.String("path", request.URL.Path),
+				slog.Int("max_concurrent", cap(l.slots)),
+			)
+			writeError(writer, http.StatusConflict, "too many concurrent operations",
+				fmt.Errorf("%d provisioning operations already running, retry later", cap(l.slots)))
+			return
+		}
+		// Jobs started by the request take the slot over until they finish.
+		ctx, release := opslot.With(request.Context(), func() { <-l.slots })
+		defer release()
+
+		next(writer, request.WithContext(ctx))
+	}
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// InFlight tracks running provisioning operations, so shutdown can wait for them to finish.
+type InFlight struct {
+	wg    sync.WaitGroup
+	count atomic.Int64
+}
+
+// NewInFlight creates an empty tracker.
+func NewInFlight() *InFlight {
+	return &InFlight{}
+}
+
+// Track wraps next so it is counted while it runs. A nil tracker passes requests through.
+func (t *InFlight) Track(next http.HandlerFunc) http.HandlerFunc {
+	if t == nil {
+		return next
+	}
+
+	return func(writer http.ResponseWriter, request *http.Request) {
+		t.wg.Add(1)
+		t.count.Add(1)
+		defer func() {
+			t.count.Add(-1)
+			t.wg.Done()
+		}()
+
+		next(writer, request)
+	}
+}
+
+// Count returns the number of operations currently running.
+func (t *InFlight) Count() int64 {
+	if t == nil {
+		return 0
+	}
+	return t.count.Load()
+}
+
+// Wait blocks until no operation is running, or returns ctx's error once it is done.
+func (t *InFlight) Wait(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/terabiome/homonculus/internal/service"
+)
+
+// NamespaceHeader selects the namespace an API request operates in. The namespace query
+// parameter is accepted as well, for clients that cannot set headers, such as websockets.
+const NamespaceHeader = "X-Homonculus-Namespace"
+
+// Namespace scopes requests to the namespace they select: VM and cluster names are taken
+// relative to it, and queries only see its VMs. Callers whose token is bound to a namespace
+// always operate in it and may not select another one. Requests without a namespace are unscoped.
+func Namespace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		namespace := request.Header.Get(NamespaceHeader)
+		if namespace == "" {
+			namespace = request.URL.Query().Get("namespace")
+		}
+
+		if identity, ok := IdentityFromContext(request.Context()); ok && identity.Namespace != "" {
+			if namespace != "" && namespace != identity.Namespace {
+				writeError(writer, http.StatusForbidden, "forbidden", fmt.Errorf("token is bound to namespace %q", identity.Namespace))
+				return
+			}
+			namespace = identity.Namespace
+		}
+
+		if namespace == "" {
+			next.ServeHTTP(writer, request)
+			return
+		}
+		if err := service.ValidateNamespace(namespace); err != nil {
+			writeError(writer, http.StatusBadRequest, "invalid namespace", err)
+			return
+		}
+		next.ServeHTTP(writer, request.WithContext(service.WithNamespace(request.Context(), namespace)))
+	})
+}
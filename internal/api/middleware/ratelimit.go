@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// idleBucketTTL is how long a client's bucket is kept after its last request.
+const idleBucketTTL = 10 * time.Minute
+
+// bucket is a token bucket refilled continuously at the limiter's rate.
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// RateLimiter limits requests per client with a token bucket. Clients are identified by
+// their authenticated identity, falling back to the remote IP address.
+type RateLimiter struct {
+	rate   float64
+	burst  float64
+	logger *slog.Logger
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter creates a limiter allowing requestsPerSecond per client with bursts of up to burst requests.
+func NewRateLimiter(requestsPerSecond float64, burst int, logger *slog.Logger) *RateLimiter {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(requestsPerSecond)))
+	}
+	return &RateLimiter{
+		rate:    requestsPerSecond,
+		burst:   float64(burst),
+		logger:  logger,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Middleware rejects requests of clients over their rate with 429 Too Many Requests.
+// A nil limiter disables rate limiting.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		client := clientKey(request)
+		allowed, retryAfter := l.allow(client, time.Now())
+		if !allowed {
+			l.logger.Warn("rate limited API request",
+				slog.String("client", client),
+				slog.String("method", request.Method),
+				slog.String("path", request.URL.Path),
+			)
+			writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			writeError(writer, http.StatusTooManyRequests, "too many requests", fmt.Errorf("rate limit of %g requests per second exceeded", l.rate))
+			return
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// allow takes a token from the client's bucket, or reports how long until one is available.
+func (l *RateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > idleBucketTTL {
+		for key, b := range l.buckets {
+			if now.Sub(b.lastSeen) > idleBucketTTL {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst}
+		l.buckets[client] = b
+	} else {
+		b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.lastSeen).Seconds()*l.rate)
+	}
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// clientKey identifies the caller of a request for rate limiting.
+func clientKey(request *http.Request) string {
+	if identity, ok := IdentityFromContext(request.Context()); ok && identity.Method != AuthMethodAnonymous {
+		return identity.Method + ":" + identity.Name
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return "ip:" + request.RemoteAddr
+	}
+	return "ip:" + host
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// Role is the set of API operations granted to a caller. Each role includes the ones below it.
+type Role string
+
+const (
+	// RoleViewer may query VMs, clusters and host capacity, and watch serial consoles.
+	RoleViewer Role = "viewer"
+
+	// RoleOperator may additionally start and stop VMs and open their consoles for input.
+	RoleOperator Role = "operator"
+
+	// RoleAdmin may additionally create and delete VMs, change their devices and bootstrap k3s.
+	RoleAdmin Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// ParseRole validates a role name.
+func ParseRole(name string) (Role, error) {
+	role := Role(name)
+	if _, ok := roleRanks[role]; !ok {
+		return "", fmt.Errorf("invalid role: %s (valid: viewer, operator, admin)", name)
+	}
+	return role, nil
+}
+
+// Allows reports whether r grants the operations of required.
+func (r Role) Allows(required Role) bool {
+	return roleRanks[r] >= roleRanks[required]
+}
+
+// Require wraps next so it only runs for callers holding at least role.
+// A nil authenticator disables authorization.
+func (a *Authenticator) Require(role Role, next http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return next
+	}
+
+	return func(writer http.ResponseWriter, request *http.Request) {
+		identity, ok := IdentityFromContext(request.Context())
+		if !ok || !identity.Role.Allows(role) {
+			a.logger.Warn("denied API request",
+				slog.String("method", request.Method),
+				slog.String("path", request.URL.Path),
+				slog.String("identity", identity.Name),
+				slog.String("role", string(identity.Role)),
+				slog.String("required_role", string(role)),
+			)
+			writeError(writer, http.StatusForbidden, "forbidden", fmt.Errorf("role %q required", role))
+			return
+		}
+		next(writer, request)
+	}
+}
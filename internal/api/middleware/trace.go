@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/terabiome/homonculus/internal/api/contracts"
+	"github.com/terabiome/homonculus/internal/service"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Trace starts a span for every request and gives it a job ID, and returns both IDs in the
+// correlation headers, so a caller can hand operators an ID that leads to the trace and to the
+// events the request recorded. A W3C traceparent header continues the trace of the caller.
+// With telemetry disabled no span is recorded, but requests still get a trace ID.
+func Trace(next http.Handler) http.Handler {
+	tracer := otel.Tracer("homonculus/api")
+	propagator := propagation.TraceContext{}
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ctx := propagator.Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+		ctx, span := tracer.Start(ctx, request.Method+" "+request.URL.Path, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+		if !span.SpanContext().HasTraceID() {
+			ctx = trace.ContextWithSpanContext(ctx, newSpanContext())
+		}
+
+		jobID := uuid.NewString()
+		span.SetAttributes(attribute.String("homonculus.job_id", jobID))
+		writer.Header().Set(contracts.TraceIDHeader, trace.SpanContextFromContext(ctx).TraceID().String())
+		writer.Header().Set(contracts.JobIDHeader, jobID)
+
+		next.ServeHTTP(writer, request.WithContext(service.WithJob(ctx, jobID)))
+	})
+}
+
+// newSpanContext returns a span context with random IDs, for requests not traced otherwise.
+func newSpanContext() trace.SpanContext {
+	var traceID trace.TraceID
+	var spanID trace.SpanID
+	rand.Read(traceID[:])
+	rand.Read(spanID[:])
+	return trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID})
+}
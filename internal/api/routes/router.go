@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/terabiome/homonculus/internal/api/handler"
+	"github.com/terabiome/homonculus/internal/api/middleware"
 )
 
 // Router wraps http.ServeMux and provides route setup
@@ -11,44 +12,127 @@ type Router struct {
 	*http.ServeMux
 }
 
-// V1Handler returns a handler for v1 API routes
-func (router *Router) V1Handler(vmHandler *handler.VirtualMachine, k3sHandler *handler.K3s, systemHandler *handler.System) http.Handler {
+// Guards are the middlewares protecting API routes. Nil guards are disabled.
+type Guards struct {
+	Authenticator *middleware.Authenticator
+	RateLimiter   *middleware.RateLimiter
+	Operations    *middleware.OperationLimiter
+	InFlight      *middleware.InFlight
+}
+
+// V1Handler returns a handler for v1 API routes, each guarded by the role it requires.
+// Provisioning routes additionally take an operation slot.
+func (router *Router) V1Handler(vmHandler *handler.VirtualMachine, k3sHandler *handler.K3s, systemHandler *handler.System, integrationHandler *handler.Integration, guards Guards) http.Handler {
 	mux := http.NewServeMux()
+	authenticator := guards.Authenticator
+	provision := func(next http.HandlerFunc) http.HandlerFunc {
+		return guards.InFlight.Track(guards.Operations.Guard(next))
+	}
+	viewer := func(next http.HandlerFunc) http.HandlerFunc {
+		return authenticator.Require(middleware.RoleViewer, next)
+	}
+	operator := func(next http.HandlerFunc) http.HandlerFunc {
+		return authenticator.Require(middleware.RoleOperator, next)
+	}
+	admin := func(next http.HandlerFunc) http.HandlerFunc {
+		return authenticator.Require(middleware.RoleAdmin, next)
+	}
 
 	// Setup virtual machine routes
 	vmMux := http.NewServeMux()
-	vmMux.HandleFunc("POST /create/cluster", vmHandler.CreateCluster)
-	vmMux.HandleFunc("POST /delete/cluster", vmHandler.DeleteCluster)
-	vmMux.HandleFunc("POST /start/cluster", vmHandler.StartCluster)
-	vmMux.HandleFunc("GET /query/cluster", vmHandler.QueryCluster)
-	vmMux.HandleFunc("POST /query/cluster", vmHandler.QueryCluster)
+	vmMux.HandleFunc("POST /create/cluster", admin(provision(vmHandler.CreateCluster)))
+	vmMux.HandleFunc("POST /create/fleet", admin(provision(vmHandler.CreateFleet)))
+	vmMux.HandleFunc("POST /diff", viewer(vmHandler.Diff))
+	vmMux.HandleFunc("POST /clone/cluster", admin(provision(vmHandler.CloneCluster)))
+	vmMux.HandleFunc("POST /delete/cluster", admin(provision(vmHandler.DeleteCluster)))
+	vmMux.HandleFunc("POST /adopt", admin(provision(vmHandler.Adopt)))
+	vmMux.HandleFunc("POST /start/cluster", operator(vmHandler.StartCluster))
+	vmMux.HandleFunc("POST /stop/cluster", operator(vmHandler.StopCluster))
+	vmMux.HandleFunc("POST /reset/cluster", operator(vmHandler.ResetCluster))
+	vmMux.HandleFunc("POST /attach/devices", admin(vmHandler.AttachDevices))
+	vmMux.HandleFunc("POST /detach/devices", admin(vmHandler.DetachDevices))
+	vmMux.HandleFunc("POST /insert/media", admin(vmHandler.InsertMedia))
+	vmMux.HandleFunc("POST /eject/media", admin(vmHandler.EjectMedia))
+	vmMux.HandleFunc("POST /schedules", operator(vmHandler.CreateSchedule))
+	vmMux.HandleFunc("GET /schedules", viewer(vmHandler.ListSchedules))
+	vmMux.HandleFunc("DELETE /schedules/{id}", operator(vmHandler.DeleteSchedule))
+	vmMux.HandleFunc("POST /sshkeys", admin(vmHandler.GenerateSSHKey))
+	vmMux.HandleFunc("GET /sshkeys", viewer(vmHandler.ListSSHKeys))
+	vmMux.HandleFunc("GET /sshkeys/{name}/private", admin(vmHandler.GetSSHPrivateKey))
+	vmMux.HandleFunc("DELETE /sshkeys/{name}", admin(vmHandler.DeleteSSHKey))
+	vmMux.HandleFunc("GET /ip-pools", viewer(vmHandler.ListIPPools))
+	vmMux.HandleFunc("GET /query/cluster", viewer(vmHandler.QueryCluster))
+	vmMux.HandleFunc("POST /query/cluster", viewer(vmHandler.QueryCluster))
+	vmMux.HandleFunc("GET /{name}/console", operator(vmHandler.Console))
+	vmMux.HandleFunc("POST /{name}/console/ticket", operator(vmHandler.ConsoleTicket))
+	vmMux.HandleFunc("GET /{name}/serial", viewer(vmHandler.SerialConsole))
+	vmMux.HandleFunc("GET /{name}/serial/rw", operator(vmHandler.InteractiveSerialConsole))
+	vmMux.HandleFunc("GET /{name}/stats/stream", viewer(vmHandler.StatsStream))
+	vmMux.HandleFunc("GET /{name}/artifacts", admin(vmHandler.Artifacts))
+	vmMux.HandleFunc("GET /{name}/cloud-init", viewer(vmHandler.CloudInit))
 	mux.Handle("/virtualmachine/", http.StripPrefix("/virtualmachine", vmMux))
 
 	// Setup K3s routes
 	k3sMux := http.NewServeMux()
-	k3sMux.HandleFunc("POST /generate-token", k3sHandler.GenerateToken)
-	k3sMux.HandleFunc("POST /bootstrap/master", k3sHandler.BootstrapMaster)
-	k3sMux.HandleFunc("POST /bootstrap/worker", k3sHandler.BootstrapWorker)
+	k3sMux.HandleFunc("POST /generate-token", admin(k3sHandler.GenerateToken))
+	k3sMux.HandleFunc("POST /bootstrap/master", admin(provision(k3sHandler.BootstrapMaster)))
+	k3sMux.HandleFunc("POST /bootstrap/worker", admin(provision(k3sHandler.BootstrapWorker)))
+	k3sMux.HandleFunc("POST /etcd-snapshots/save", admin(provision(k3sHandler.SaveEtcdSnapshot)))
+	k3sMux.HandleFunc("POST /etcd-snapshots/list", admin(k3sHandler.ListEtcdSnapshots))
+	k3sMux.HandleFunc("POST /etcd-snapshots/download", admin(k3sHandler.DownloadEtcdSnapshot))
+	k3sMux.HandleFunc("POST /etcd-snapshots/restore", admin(provision(k3sHandler.RestoreEtcdSnapshot)))
+	k3sMux.HandleFunc("POST /remove-node", admin(provision(k3sHandler.RemoveNode)))
 	mux.Handle("/k3s/", http.StripPrefix("/k3s", k3sMux))
 
 	// Setup system routes
 	systemMux := http.NewServeMux()
-	systemMux.HandleFunc("GET /cpu-topology", systemHandler.CPUTopology)
+	systemMux.HandleFunc("GET /cpu-topology", viewer(systemHandler.CPUTopology))
+	systemMux.HandleFunc("GET /capacity", viewer(systemHandler.Capacity))
+	systemMux.HandleFunc("GET /maintenance", viewer(systemHandler.ListMaintenance))
+	systemMux.HandleFunc("POST /maintenance", admin(provision(systemHandler.Maintenance)))
 	mux.Handle("/system/", http.StripPrefix("/system", systemMux))
 
+	mux.HandleFunc("GET /events", viewer(systemHandler.Events))
+	mux.HandleFunc("GET /jobs/{id}", viewer(vmHandler.GetJob))
+	mux.HandleFunc("GET /clusters/{name}/spec", admin(vmHandler.ExportClusterSpec))
+	mux.HandleFunc("POST /admin/gc", admin(provision(systemHandler.CollectGarbage)))
+	mux.HandleFunc("GET /admin/templates", admin(systemHandler.TemplateRollout))
+	mux.HandleFunc("POST /admin/templates/stage", admin(systemHandler.StageTemplates))
+	mux.HandleFunc("POST /admin/templates/switch", admin(systemHandler.SwitchTemplates))
+	mux.HandleFunc("POST /admin/templates/discard", admin(systemHandler.DiscardTemplates))
+
+	// Setup integration routes
+	integrationMux := http.NewServeMux()
+	integrationMux.HandleFunc("GET /ansible/inventory", viewer(integrationHandler.AnsibleInventory))
+	integrationMux.HandleFunc("GET /terraform/virtualmachines/{name}", viewer(integrationHandler.TerraformVirtualMachine))
+	integrationMux.HandleFunc("DELETE /terraform/virtualmachines/{name}", admin(provision(integrationHandler.DeleteTerraformVirtualMachine)))
+	integrationMux.HandleFunc("GET /terraform/clusters/{name}", viewer(integrationHandler.TerraformCluster))
+	mux.Handle("/integrations/", http.StripPrefix("/integrations", integrationMux))
+
 	return mux
 }
 
-// SetupMux creates and configures the main router
-func SetupMux(vmHandler *handler.VirtualMachine, k3sHandler *handler.K3s, systemHandler *handler.System) *Router {
+// SetupMux creates and configures the main router.
+// API requests are given trace and job IDs first, so even rejected requests can be correlated,
+// then authenticated, so rate limits apply per identity, and then scoped to the namespace they select.
+// A non-nil metrics handler is served unauthenticated on /metrics for Prometheus scrapes,
+// and /readyz reports unauthenticated whether the libvirt daemons of all hosts are reachable.
+func SetupMux(vmHandler *handler.VirtualMachine, k3sHandler *handler.K3s, systemHandler *handler.System, integrationHandler *handler.Integration, guards Guards, metrics http.Handler) *Router {
 	router := Router{http.NewServeMux()}
 
-	router.ServeMux.Handle("/api/v1/", http.StripPrefix("/api/v1", router.V1Handler(vmHandler, k3sHandler, systemHandler)))
+	v1 := router.V1Handler(vmHandler, k3sHandler, systemHandler, integrationHandler, guards)
+	router.ServeMux.Handle("/api/v1/", middleware.Trace(guards.Authenticator.Middleware(middleware.Namespace(guards.RateLimiter.Middleware(http.StripPrefix("/api/v1", v1))))))
 
 	router.ServeMux.HandleFunc("/heartbeat", func(writer http.ResponseWriter, request *http.Request) {
 		writer.WriteHeader(200)
 		writer.Write([]byte("i have not exploded"))
 	})
 
+	router.ServeMux.HandleFunc("GET /readyz", systemHandler.Ready)
+
+	if metrics != nil {
+		router.ServeMux.Handle("GET /metrics", metrics)
+	}
+
 	return &router
 }
@@ -2,20 +2,209 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"text/template"
+	"time"
 
 	"github.com/spf13/viper"
+	"github.com/terabiome/homonculus/pkg/labels"
+)
+
+// HypervisorSSHConfig contains SSH details used to run host-side commands on a remote hypervisor.
+type HypervisorSSHConfig struct {
+	Host    string `mapstructure:"host"`
+	Port    int    `mapstructure:"port"`
+	User    string `mapstructure:"user"`
+	KeyPath string `mapstructure:"key_path"`
+}
+
+// HypervisorConfig describes one hypervisor host VMs can be scheduled onto.
+type HypervisorConfig struct {
+	Name string               `mapstructure:"name"`
+	URI  string               `mapstructure:"uri"`
+	SSH  *HypervisorSSHConfig `mapstructure:"ssh"`
+	// MaxConnections caps the pooled libvirt connections to the host (0 uses the default).
+	MaxConnections int `mapstructure:"max_connections"`
+}
+
+// AuthTokenConfig is a named API bearer token, stored as the hex SHA-256 hash of the token.
+type AuthTokenConfig struct {
+	Name   string `mapstructure:"name"`
+	SHA256 string `mapstructure:"sha256"`
+	Role   string `mapstructure:"role"`
+	// Namespace confines the token to the VMs of one namespace.
+	Namespace string `mapstructure:"namespace"`
+}
+
+// AuthConfig controls authentication of API requests.
+type AuthConfig struct {
+	Enabled       bool              `mapstructure:"enabled"`
+	Tokens        []AuthTokenConfig `mapstructure:"tokens"`
+	TokenFile     string            `mapstructure:"token_file"`
+	ClientCAPath  string            `mapstructure:"client_ca_path"`
+	AnonymousRead bool              `mapstructure:"anonymous_read"`
+	// DefaultRole is granted to tokens and client certificates without an explicit role.
+	DefaultRole string `mapstructure:"default_role"`
+	// CertificateRoles maps client certificate common names to roles.
+	CertificateRoles map[string]string `mapstructure:"certificate_roles"`
+}
+
+// ACMEConfig requests API server certificates from an ACME CA such as Let's Encrypt.
+type ACMEConfig struct {
+	Domains      []string `mapstructure:"domains"`
+	Email        string   `mapstructure:"email"`
+	CacheDir     string   `mapstructure:"cache_dir"`
+	DirectoryURL string   `mapstructure:"directory_url"`
+}
+
+// TLSConfig controls HTTPS on the API server. The certificate comes from cert_path/key_path,
+// is generated at startup when self_signed is set, or is obtained through ACME.
+type TLSConfig struct {
+	CertPath        string     `mapstructure:"cert_path"`
+	KeyPath         string     `mapstructure:"key_path"`
+	SelfSigned      bool       `mapstructure:"self_signed"`
+	SelfSignedHosts []string   `mapstructure:"self_signed_hosts"`
+	ACME            ACMEConfig `mapstructure:"acme"`
+	MinVersion      string     `mapstructure:"min_version"`
+}
+
+// Enabled reports whether the API server is served over HTTPS.
+func (t TLSConfig) Enabled() bool {
+	return t.CertPath != "" || t.SelfSigned || len(t.ACME.Domains) > 0
+}
+
+// VaultConfig locates the Vault KV v2 engine secrets are read from.
+type VaultConfig struct {
+	Address   string `mapstructure:"address"`
+	Token     string `mapstructure:"token"`
+	TokenFile string `mapstructure:"token_file"`
+	Mount     string `mapstructure:"mount"`
+}
+
+// SecretsConfig selects where secret:// references in requests are resolved.
+type SecretsConfig struct {
+	Backend   string      `mapstructure:"backend"`
+	EnvPrefix string      `mapstructure:"env_prefix"`
+	Dir       string      `mapstructure:"dir"`
+	Vault     VaultConfig `mapstructure:"vault"`
+}
+
+// StateEncryptionConfig encrypts the values of the state store at rest. The first key encrypts;
+// the others only decrypt values written before a key rotation, which are encrypted again with
+// the first key on startup.
+type StateEncryptionConfig struct {
+	Keys []StateEncryptionKeyConfig `mapstructure:"keys"`
+}
+
+// StateEncryptionKeyConfig is a state encryption key. Key may be a secret:// reference; ID is
+// recorded with every value encrypted with it and must not change.
+type StateEncryptionKeyConfig struct {
+	ID  string `mapstructure:"id"`
+	Key string `mapstructure:"key"`
+}
+
+// LimitsConfig protects the hypervisor from request floods. Zero values disable a limit;
+// request rates and concurrent operations are unlimited unless configured.
+type LimitsConfig struct {
+	RequestsPerSecond       float64 `mapstructure:"requests_per_second"`
+	Burst                   int     `mapstructure:"burst"`
+	MaxConcurrentOperations int     `mapstructure:"max_concurrent_operations"`
+	// CreateParallelism caps how many VMs of one create request are provisioned at once.
+	CreateParallelism int `mapstructure:"create_parallelism"`
+}
+
+// AdmissionConfig holds back creating and starting VMs on loaded hosts. Zero thresholds disable a check.
+type AdmissionConfig struct {
+	// MaxLoadPerCPU is the highest 1-minute load average per host CPU new VMs are admitted at.
+	MaxLoadPerCPU float64 `mapstructure:"max_load_per_cpu"`
+	// MinFreeMemoryMB is the host memory that must remain free once the VM runs.
+	MinFreeMemoryMB int64 `mapstructure:"min_free_memory_mb"`
+	// QueueTimeout is how long a request waits for its host to drain before it is rejected.
+	QueueTimeout time.Duration `mapstructure:"queue_timeout"`
+}
+
+// QuotaConfig caps the VMs owned by one auth identity, or the VMs matching a label selector.
+// Zero limits are unlimited.
+type QuotaConfig struct {
+	Identity    string `mapstructure:"identity"`
+	Selector    string `mapstructure:"selector"`
+	MaxVMs      int    `mapstructure:"max_vms"`
+	MaxVCPUs    int    `mapstructure:"max_vcpus"`
+	MaxMemoryMB int64  `mapstructure:"max_memory_mb"`
+	MaxDiskGB   int64  `mapstructure:"max_disk_gb"`
+}
+
+// IPPoolConfig is a named range of static IPv4 addresses VMs can request with ip_from_pool.
+// Start and end default to the first and last host address of the network.
+type IPPoolConfig struct {
+	Name        string   `mapstructure:"name"`
+	Network     string   `mapstructure:"network"` // CIDR notation, e.g. 10.0.0.0/24
+	Start       string   `mapstructure:"start"`
+	End         string   `mapstructure:"end"`
+	Gateway     string   `mapstructure:"gateway"`
+	Nameservers []string `mapstructure:"nameservers"`
+}
+
+// Backends that provision VMs: libvirt talks to the configured hypervisors, qemu launches
+// QEMU processes on them without libvirtd, and fake keeps VMs in memory.
+const (
+	BackendLibvirt = "libvirt"
+	BackendQEMU    = "qemu"
+	BackendFake    = "fake"
+)
+
+// Policies for CPU pins of a new VM that overlap the pins of VMs already defined on its host.
+const (
+	PinConflictWarn = "warn"
+	PinConflictFail = "fail"
 )
 
 type Config struct {
+	Backend                        string
 	LibvirtURI                     string
+	LibvirtKeepaliveInterval       time.Duration
+	LibvirtKeepaliveCount          uint
+	AllowDegradedStart             bool
+	Hypervisors                    []HypervisorConfig
 	LibvirtTemplatePath            string
+	LibvirtContainerTemplatePath   string
 	CloudInitUserDataTemplate      string
 	CloudInitMetaDataTemplate      string
 	CloudInitNetworkConfigTemplate string
+	CloudInitISOCache              bool
+	WorkspaceDir                   string
 	LogLevel                       string
 	LogFormat                      string
 	TelemetryEnabled               bool
+	StatePath                      string
+	EventLogPath                   string
+	JobLogPath                     string
+	StateEncryption                StateEncryptionConfig
+	ReconcileEnabled               bool
+	ReconcileInterval              time.Duration
+	GCEnabled                      bool
+	GCInterval                     time.Duration
+	GCGracePeriod                  time.Duration
+	ExpiryCheckInterval            time.Duration
+	HealthCheckInterval            time.Duration
+	QueryCacheTTL                  time.Duration
+	ShutdownTimeout                time.Duration
+	Auth                           AuthConfig
+	TLS                            TLSConfig
+	Secrets                        SecretsConfig
+	Limits                         LimitsConfig
+	Admission                      AdmissionConfig
+	Quotas                         []QuotaConfig
+	IPPools                        []IPPoolConfig
+	DiskDir                        string
+	ISODir                         string
+	DiskPathTemplate               string
+	ISOPathTemplate                string
+	AllowedPaths                   []string
+	PinConflictPolicy              string
+	MemoryOvercommitRatio          float64
 }
 
 func Load() (*Config, error) {
@@ -31,27 +220,119 @@ func Load() (*Config, error) {
 		}
 	}
 
+	viper.SetDefault("backend", BackendLibvirt)
 	viper.SetDefault("libvirt_uri", "qemu:///system")
+	viper.SetDefault("libvirt_keepalive_interval", "5s")
+	viper.SetDefault("libvirt_keepalive_count", 3)
+	viper.SetDefault("allow_degraded_start", false)
 	viper.SetDefault("libvirt_template", "./templates/libvirt/domain.xml.tpl")
+	viper.SetDefault("libvirt_container_template", "./templates/libvirt/container.xml.tpl")
 	viper.SetDefault("cloudinit_user_data_template", "./templates/cloudinit/user-data.tpl")
 	viper.SetDefault("cloudinit_meta_data_template", "./templates/cloudinit/meta-data.tpl")
 	viper.SetDefault("cloudinit_network_config_template", "./templates/cloudinit/network-config.tpl")
+	viper.SetDefault("cloudinit_iso_cache", false)
 	viper.SetDefault("log_level", "info")
 	viper.SetDefault("log_format", "text")
 	viper.SetDefault("telemetry_enabled", false)
+	viper.SetDefault("state_path", "./homonculus.state.json")
+	viper.SetDefault("event_log_path", "./homonculus.events.jsonl")
+	viper.SetDefault("job_log_path", "./homonculus.jobs.jsonl")
+	viper.SetDefault("reconcile_enabled", false)
+	viper.SetDefault("reconcile_interval", "1m")
+	viper.SetDefault("gc_enabled", false)
+	viper.SetDefault("gc_interval", "10m")
+	viper.SetDefault("gc_grace_period", "1h")
+	viper.SetDefault("expiry_check_interval", "1m")
+	viper.SetDefault("health_check_interval", "30s")
+	viper.SetDefault("query_cache_ttl", "0s")
+	viper.SetDefault("shutdown_timeout", "5m")
+	viper.SetDefault("auth.default_role", "viewer")
+	viper.SetDefault("tls.min_version", "1.2")
+	viper.SetDefault("tls.acme.cache_dir", "/var/lib/homonculus/acme")
+	viper.SetDefault("secrets.backend", "env")
+	viper.SetDefault("secrets.env_prefix", "HOMONCULUS_SECRET_")
+	viper.SetDefault("secrets.dir", "/etc/homonculus/secrets")
+	viper.SetDefault("secrets.vault.mount", "secret")
+	viper.SetDefault("limits.create_parallelism", 4)
+	viper.SetDefault("pin_conflict_policy", PinConflictWarn)
+	viper.SetDefault("memory_overcommit_ratio", 1.0)
+	viper.SetDefault("disk_path_template", "{{.VMName}}.qcow2")
+	viper.SetDefault("iso_path_template", "{{.VMName}}-cloud-init.iso")
 
 	viper.SetEnvPrefix("homonculus")
 	viper.AutomaticEnv()
 
 	cfg := &Config{
+		Backend:                        viper.GetString("backend"),
 		LibvirtURI:                     viper.GetString("libvirt_uri"),
+		LibvirtKeepaliveInterval:       viper.GetDuration("libvirt_keepalive_interval"),
+		LibvirtKeepaliveCount:          viper.GetUint("libvirt_keepalive_count"),
+		AllowDegradedStart:             viper.GetBool("allow_degraded_start"),
 		LibvirtTemplatePath:            viper.GetString("libvirt_template"),
+		LibvirtContainerTemplatePath:   viper.GetString("libvirt_container_template"),
 		CloudInitUserDataTemplate:      viper.GetString("cloudinit_user_data_template"),
 		CloudInitMetaDataTemplate:      viper.GetString("cloudinit_meta_data_template"),
 		CloudInitNetworkConfigTemplate: viper.GetString("cloudinit_network_config_template"),
+		CloudInitISOCache:              viper.GetBool("cloudinit_iso_cache"),
+		WorkspaceDir:                   viper.GetString("workspace_dir"),
 		LogLevel:                       viper.GetString("log_level"),
 		LogFormat:                      viper.GetString("log_format"),
 		TelemetryEnabled:               viper.GetBool("telemetry_enabled"),
+		StatePath:                      viper.GetString("state_path"),
+		EventLogPath:                   viper.GetString("event_log_path"),
+		JobLogPath:                     viper.GetString("job_log_path"),
+		ReconcileEnabled:               viper.GetBool("reconcile_enabled"),
+		ReconcileInterval:              viper.GetDuration("reconcile_interval"),
+		GCEnabled:                      viper.GetBool("gc_enabled"),
+		GCInterval:                     viper.GetDuration("gc_interval"),
+		GCGracePeriod:                  viper.GetDuration("gc_grace_period"),
+		ExpiryCheckInterval:            viper.GetDuration("expiry_check_interval"),
+		HealthCheckInterval:            viper.GetDuration("health_check_interval"),
+		QueryCacheTTL:                  viper.GetDuration("query_cache_ttl"),
+		ShutdownTimeout:                viper.GetDuration("shutdown_timeout"),
+		AllowedPaths:                   viper.GetStringSlice("allowed_paths"),
+		PinConflictPolicy:              viper.GetString("pin_conflict_policy"),
+		MemoryOvercommitRatio:          viper.GetFloat64("memory_overcommit_ratio"),
+		DiskDir:                        viper.GetString("disk_dir"),
+		ISODir:                         viper.GetString("iso_dir"),
+		DiskPathTemplate:               viper.GetString("disk_path_template"),
+		ISOPathTemplate:                viper.GetString("iso_path_template"),
+	}
+
+	if err := viper.UnmarshalKey("hypervisors", &cfg.Hypervisors); err != nil {
+		return nil, fmt.Errorf("error reading hypervisors: %w", err)
+	}
+	if len(cfg.Hypervisors) == 0 {
+		cfg.Hypervisors = []HypervisorConfig{{Name: "default", URI: cfg.LibvirtURI}}
+	}
+
+	if err := viper.UnmarshalKey("auth", &cfg.Auth); err != nil {
+		return nil, fmt.Errorf("error reading auth: %w", err)
+	}
+	if err := viper.UnmarshalKey("tls", &cfg.TLS); err != nil {
+		return nil, fmt.Errorf("error reading tls: %w", err)
+	}
+
+	if err := viper.UnmarshalKey("secrets", &cfg.Secrets); err != nil {
+		return nil, fmt.Errorf("error reading secrets: %w", err)
+	}
+	if err := viper.UnmarshalKey("state_encryption", &cfg.StateEncryption); err != nil {
+		return nil, fmt.Errorf("error reading state_encryption: %w", err)
+	}
+	if err := viper.UnmarshalKey("limits", &cfg.Limits); err != nil {
+		return nil, fmt.Errorf("error reading limits: %w", err)
+	}
+	if err := viper.UnmarshalKey("admission", &cfg.Admission); err != nil {
+		return nil, fmt.Errorf("error reading admission: %w", err)
+	}
+	if err := viper.UnmarshalKey("quotas", &cfg.Quotas); err != nil {
+		return nil, fmt.Errorf("error reading quotas: %w", err)
+	}
+	if err := viper.UnmarshalKey("ip_pools", &cfg.IPPools); err != nil {
+		return nil, fmt.Errorf("error reading ip_pools: %w", err)
+	}
+	if cfg.Secrets.Vault.Token == "" {
+		cfg.Secrets.Vault.Token = os.Getenv("VAULT_TOKEN")
 	}
 
 	if err := cfg.Validate(); err != nil {
@@ -62,10 +343,22 @@ func Load() (*Config, error) {
 }
 
 func (c *Config) Validate() error {
+	switch c.Backend {
+	case BackendLibvirt, BackendQEMU, BackendFake:
+	default:
+		return fmt.Errorf("invalid backend: %s (valid: %s, %s, %s)", c.Backend, BackendLibvirt, BackendQEMU, BackendFake)
+	}
+
 	if err := validateFileExists(c.LibvirtTemplatePath); err != nil {
 		return fmt.Errorf("libvirt template: %w", err)
 	}
 
+	if c.LibvirtContainerTemplatePath != "" {
+		if err := validateFileExists(c.LibvirtContainerTemplatePath); err != nil {
+			return fmt.Errorf("libvirt container template: %w", err)
+		}
+	}
+
 	if err := validateFileExists(c.CloudInitUserDataTemplate); err != nil {
 		return fmt.Errorf("cloud-init user-data template: %w", err)
 	}
@@ -82,6 +375,49 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	switch c.PinConflictPolicy {
+	case PinConflictWarn, PinConflictFail:
+	default:
+		return fmt.Errorf("invalid pin_conflict_policy: %s (valid: %s, %s)", c.PinConflictPolicy, PinConflictWarn, PinConflictFail)
+	}
+
+	if c.MemoryOvercommitRatio < 0 {
+		return fmt.Errorf("memory_overcommit_ratio must not be negative, got %g", c.MemoryOvercommitRatio)
+	}
+
+	if c.Admission.MaxLoadPerCPU < 0 || c.Admission.MinFreeMemoryMB < 0 || c.Admission.QueueTimeout < 0 {
+		return fmt.Errorf("admission: max_load_per_cpu, min_free_memory_mb and queue_timeout must not be negative")
+	}
+
+	poolNames := make(map[string]bool)
+	for i, pool := range c.IPPools {
+		if pool.Name == "" || pool.Network == "" {
+			return fmt.Errorf("ip pool #%d: name and network are required", i+1)
+		}
+		if poolNames[pool.Name] {
+			return fmt.Errorf("duplicate ip pool name: %s", pool.Name)
+		}
+		poolNames[pool.Name] = true
+		if network, err := netip.ParsePrefix(pool.Network); err != nil || !network.Addr().Is4() {
+			return fmt.Errorf("ip pool %s: network must be IPv4 in CIDR notation, got %q", pool.Name, pool.Network)
+		}
+	}
+
+	for _, layout := range []struct{ option, dir, template string }{
+		{"disk", c.DiskDir, c.DiskPathTemplate},
+		{"iso", c.ISODir, c.ISOPathTemplate},
+	} {
+		if layout.dir == "" {
+			continue
+		}
+		if !filepath.IsAbs(layout.dir) {
+			return fmt.Errorf("%s_dir must be an absolute path, got %q", layout.option, layout.dir)
+		}
+		if _, err := template.New(layout.option).Option("missingkey=error").Parse(layout.template); err != nil {
+			return fmt.Errorf("invalid %s_path_template: %w", layout.option, err)
+		}
+	}
+
 	validLogLevels := map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
 	if !validLogLevels[c.LogLevel] {
 		return fmt.Errorf("invalid log level: %s (valid: debug, info, warn, error)", c.LogLevel)
@@ -92,6 +428,151 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid log format: %s (valid: text, json)", c.LogFormat)
 	}
 
+	hypervisorNames := make(map[string]bool)
+	for i, hypervisor := range c.Hypervisors {
+		if hypervisor.Name == "" || hypervisor.URI == "" {
+			return fmt.Errorf("hypervisor #%d: name and uri are required", i+1)
+		}
+		if hypervisorNames[hypervisor.Name] {
+			return fmt.Errorf("duplicate hypervisor name: %s", hypervisor.Name)
+		}
+		hypervisorNames[hypervisor.Name] = true
+		if hypervisor.MaxConnections < 0 {
+			return fmt.Errorf("hypervisor %s: max_connections must not be negative", hypervisor.Name)
+		}
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("invalid shutdown timeout: %s (must be positive)", c.ShutdownTimeout)
+	}
+
+	if c.QueryCacheTTL < 0 {
+		return fmt.Errorf("invalid query cache ttl: %s (must not be negative)", c.QueryCacheTTL)
+	}
+
+	if c.ReconcileEnabled && c.ReconcileInterval <= 0 {
+		return fmt.Errorf("invalid reconcile interval: %s (must be positive)", c.ReconcileInterval)
+	}
+
+	if c.GCEnabled && c.GCInterval <= 0 {
+		return fmt.Errorf("invalid gc interval: %s (must be positive)", c.GCInterval)
+	}
+
+	if c.GCGracePeriod < 0 {
+		return fmt.Errorf("invalid gc grace period: %s (must not be negative)", c.GCGracePeriod)
+	}
+
+	if c.ExpiryCheckInterval <= 0 {
+		return fmt.Errorf("invalid expiry check interval: %s (must be positive)", c.ExpiryCheckInterval)
+	}
+
+	if c.HealthCheckInterval <= 0 {
+		return fmt.Errorf("invalid health check interval: %s (must be positive)", c.HealthCheckInterval)
+	}
+
+	if c.LibvirtKeepaliveInterval < 0 {
+		return fmt.Errorf("invalid libvirt keepalive interval: %s (must not be negative)", c.LibvirtKeepaliveInterval)
+	}
+	if c.LibvirtKeepaliveInterval > 0 && c.LibvirtKeepaliveCount == 0 {
+		return fmt.Errorf("invalid libvirt keepalive count: must be positive when keepalives are enabled")
+	}
+
+	if (c.TLS.CertPath == "") != (c.TLS.KeyPath == "") {
+		return fmt.Errorf("tls: cert_path and key_path must be set together")
+	}
+	certificateSources := 0
+	for _, set := range []bool{c.TLS.CertPath != "", c.TLS.SelfSigned, len(c.TLS.ACME.Domains) > 0} {
+		if set {
+			certificateSources++
+		}
+	}
+	if certificateSources > 1 {
+		return fmt.Errorf("tls: cert_path, self_signed and acme are mutually exclusive")
+	}
+	if c.TLS.MinVersion != "1.2" && c.TLS.MinVersion != "1.3" {
+		return fmt.Errorf("invalid tls min version: %s (valid: 1.2, 1.3)", c.TLS.MinVersion)
+	}
+	if c.TLS.CertPath != "" {
+		if err := validateFileExists(c.TLS.CertPath); err != nil {
+			return fmt.Errorf("tls certificate: %w", err)
+		}
+		if err := validateFileExists(c.TLS.KeyPath); err != nil {
+			return fmt.Errorf("tls key: %w", err)
+		}
+	}
+
+	if c.Auth.Enabled {
+		if len(c.Auth.Tokens) == 0 && c.Auth.TokenFile == "" && c.Auth.ClientCAPath == "" {
+			return fmt.Errorf("auth: enabled but no tokens, token_file or client_ca_path configured")
+		}
+		for i, token := range c.Auth.Tokens {
+			if token.Name == "" || token.SHA256 == "" {
+				return fmt.Errorf("auth token #%d: name and sha256 are required", i+1)
+			}
+		}
+		if c.Auth.TokenFile != "" {
+			if err := validateFileExists(c.Auth.TokenFile); err != nil {
+				return fmt.Errorf("auth token file: %w", err)
+			}
+		}
+	}
+	if c.Auth.ClientCAPath != "" {
+		if !c.TLS.Enabled() {
+			return fmt.Errorf("auth: client_ca_path requires tls")
+		}
+		if err := validateFileExists(c.Auth.ClientCAPath); err != nil {
+			return fmt.Errorf("auth client CA: %w", err)
+		}
+	}
+
+	for _, dir := range c.AllowedPaths {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("allowed path %s is not absolute", dir)
+		}
+	}
+
+	if c.Limits.RequestsPerSecond < 0 || c.Limits.Burst < 0 || c.Limits.MaxConcurrentOperations < 0 || c.Limits.CreateParallelism < 0 {
+		return fmt.Errorf("limits: values must not be negative")
+	}
+
+	for i, quota := range c.Quotas {
+		if (quota.Identity == "") == (quota.Selector == "") {
+			return fmt.Errorf("quota #%d: exactly one of identity and selector is required", i+1)
+		}
+		if quota.Selector != "" {
+			if _, err := labels.Parse(quota.Selector); err != nil {
+				return fmt.Errorf("quota #%d: %w", i+1, err)
+			}
+		}
+		if quota.MaxVMs < 0 || quota.MaxVCPUs < 0 || quota.MaxMemoryMB < 0 || quota.MaxDiskGB < 0 {
+			return fmt.Errorf("quota #%d: limits must not be negative", i+1)
+		}
+	}
+
+	keyIDs := make(map[string]bool)
+	for i, key := range c.StateEncryption.Keys {
+		if key.ID == "" || key.Key == "" {
+			return fmt.Errorf("state_encryption key #%d: id and key are required", i+1)
+		}
+		if keyIDs[key.ID] {
+			return fmt.Errorf("state_encryption key #%d: duplicate id %s", i+1, key.ID)
+		}
+		keyIDs[key.ID] = true
+	}
+
+	switch c.Secrets.Backend {
+	case "env", "file":
+	case "vault":
+		if c.Secrets.Vault.Address == "" {
+			return fmt.Errorf("secrets: vault backend requires vault.address")
+		}
+		if c.Secrets.Vault.Token == "" && c.Secrets.Vault.TokenFile == "" {
+			return fmt.Errorf("secrets: vault backend requires vault.token, vault.token_file or VAULT_TOKEN")
+		}
+	default:
+		return fmt.Errorf("invalid secrets backend: %s (valid: env, file, vault)", c.Secrets.Backend)
+	}
+
 	return nil
 }
 
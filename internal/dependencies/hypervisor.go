@@ -8,7 +8,12 @@ import (
 // HypervisorContext holds runtime dependencies for interacting with a hypervisor.
 // These fields are injected by the provisioner and contain active connections and executors.
 type HypervisorContext struct {
+	Host     string            `json:"-"`
 	URI      string            `json:"-"`
 	Conn     *libvirt.Connect  `json:"-"`
 	Executor executor.Executor `json:"-"`
+
+	// Reconnect replaces Conn in place with a new connection after the connection to
+	// libvirtd was lost. It is nil when the hypervisor has no libvirt connection.
+	Reconnect func() error `json:"-"`
 }
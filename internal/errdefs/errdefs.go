@@ -0,0 +1,53 @@
+// Package errdefs defines the error kinds shared by the service layer and its managers,
+// so handlers can map failures to HTTP statuses without matching error messages.
+package errdefs
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrVMExists is returned when a VM that should be new is already defined.
+	ErrVMExists = errors.New("virtual machine already exists")
+	// ErrVMNotFound is returned when a named VM is not defined on its hypervisor.
+	ErrVMNotFound = errors.New("virtual machine not found")
+	// ErrDeviceNotFound is returned when a device of a VM, such as a CD-ROM drive, does not exist.
+	ErrDeviceNotFound = errors.New("device not found")
+	// ErrHypervisorUnavailable is returned when no connection to a hypervisor host can be made.
+	ErrHypervisorUnavailable = errors.New("hypervisor unavailable")
+	// ErrNotSupported is returned when the hypervisor driver cannot provide a requested feature.
+	ErrNotSupported = errors.New("not supported by the hypervisor driver")
+	// ErrPinConflict is returned when a VM pins host CPUs another VM on the host already pins.
+	ErrPinConflict = errors.New("CPU pins conflict with another virtual machine")
+	// ErrHostOverloaded is returned when admission control holds back a VM because its host is too loaded.
+	ErrHostOverloaded = errors.New("hypervisor host overloaded")
+	// ErrVMNotManaged is returned when a domain homonculus did not define would be changed or deleted.
+	ErrVMNotManaged = errors.New("virtual machine not managed by homonculus")
+	// ErrConsoleBusy is returned when the serial console of a VM is attached to another session.
+	ErrConsoleBusy = errors.New("serial console attached to another session")
+)
+
+const (
+	// DiskStageValidate is the pre-flight validation of the base image, rejecting the request.
+	DiskStageValidate = "validate"
+	// DiskStageInspect is reading the base image or target path on the host during validation.
+	DiskStageInspect = "inspect"
+	// DiskStageCreate is the qemu-img invocation creating the disk.
+	DiskStageCreate = "create"
+)
+
+// ErrDiskCreateFailed is returned when creating the disk of a VM fails, recording at which stage.
+type ErrDiskCreateFailed struct {
+	Stage string
+	Path  string
+	Err   error
+}
+
+func (e *ErrDiskCreateFailed) Error() string {
+	return fmt.Sprintf("failed to create disk %s at stage %s: %v", e.Path, e.Stage, e.Err)
+}
+
+func (e *ErrDiskCreateFailed) Unwrap() error {
+	return e.Err
+}
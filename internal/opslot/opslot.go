@@ -0,0 +1,41 @@
+// Package opslot hands the operation slot an API request holds over to the background job
+// the request starts, so the slot stays taken until the job finishes.
+package opslot
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+type slotKey struct{}
+
+// slot is the operation slot a request holds. Once a job took it over, the request no
+// longer releases it when it returns.
+type slot struct {
+	release   func()
+	handedOff atomic.Bool
+}
+
+// With returns a copy of ctx holding the operation slot of a request, which release frees.
+// A job started with the returned context takes the slot over and frees it when it finishes;
+// the returned function frees it otherwise, and is meant to run when the request returns.
+func With(ctx context.Context, release func()) (context.Context, func()) {
+	s := &slot{release: sync.OnceFunc(release)}
+	return context.WithValue(ctx, slotKey{}, s), func() {
+		if !s.handedOff.Load() {
+			s.release()
+		}
+	}
+}
+
+// Take takes over the operation slot of ctx and returns the function freeing it, which does
+// nothing if ctx holds no slot.
+func Take(ctx context.Context) func() {
+	s, ok := ctx.Value(slotKey{}).(*slot)
+	if !ok {
+		return func() {}
+	}
+	s.handedOff.Store(true)
+	return s.release
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/errdefs"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/units"
+)
+
+// admissionPollInterval is how often a queued request checks the load of its host again.
+const admissionPollInterval = 5 * time.Second
+
+// AdmissionPolicy holds back creating and starting VMs on hosts that are already loaded.
+// Zero thresholds disable a check; without a queue timeout, requests over a threshold fail at once.
+type AdmissionPolicy struct {
+	MaxLoadPerCPU   float64 // 1-minute load average per host CPU
+	MinFreeMemoryMB int64   // host memory that must remain free once the VM runs
+	QueueTimeout    time.Duration
+}
+
+func (p AdmissionPolicy) enabled() bool {
+	return p.MaxLoadPerCPU > 0 || p.MinFreeMemoryMB > 0
+}
+
+// check returns why a host cannot take a VM needing memoryKiB, or "" if it can.
+func (p AdmissionPolicy) check(capacity parameters.HostCapacity, memoryKiB uint64) string {
+	if p.MaxLoadPerCPU > 0 && capacity.CPUs > 0 {
+		if load := capacity.LoadAverage / float64(capacity.CPUs); load > p.MaxLoadPerCPU {
+			return fmt.Sprintf("load average of %.2f per CPU exceeds %.2f", load, p.MaxLoadPerCPU)
+		}
+	}
+	if p.MinFreeMemoryMB > 0 {
+		required := memoryKiB + units.MiBToKiB(uint64(p.MinFreeMemoryMB))
+		if capacity.FreeMemoryKiB < required {
+			return fmt.Sprintf("%d MiB of memory free, %d MiB needed", units.KiBToMiB(capacity.FreeMemoryKiB), units.KiBToMiB(required))
+		}
+	}
+	return ""
+}
+
+// admit waits until the host has room for a VM needing memoryKiB under the admission policy,
+// and fails with errdefs.ErrHostOverloaded once the queue timeout has passed. The memory stays
+// reserved on the host until release is called, so VMs admitted together do not count the same
+// free memory.
+func (s *VMService) admit(ctx context.Context, host, name string, memoryKiB uint64) (release func(), err error) {
+	release = func() {}
+	if !s.admission.enabled() {
+		return release, nil
+	}
+
+	deadline := time.Now().Add(s.admission.QueueTimeout)
+	queued := false
+	for {
+		var capacity parameters.HostCapacity
+		err := s.withHypervisor(ctx, host, func(hypervisor dependencies.HypervisorContext) error {
+			var err error
+			capacity, err = s.libvirtManager.GetHostCapacity(ctx, hypervisor)
+			return err
+		})
+		if err != nil {
+			return release, fmt.Errorf("failed to check load of host %s: %w", host, err)
+		}
+
+		s.admissionMu.Lock()
+		reason := s.admission.check(capacity, s.admittedKiB[host]+memoryKiB)
+		if reason == "" {
+			s.admittedKiB[host] += memoryKiB
+			s.admissionMu.Unlock()
+			if queued {
+				s.logger.Info("admitted queued VM", slog.String("vm", name), slog.String("host", host))
+			}
+			return func() {
+				s.admissionMu.Lock()
+				defer s.admissionMu.Unlock()
+				s.admittedKiB[host] -= memoryKiB
+				if s.admittedKiB[host] == 0 {
+					delete(s.admittedKiB, host)
+				}
+			}, nil
+		}
+		s.admissionMu.Unlock()
+
+		if !time.Now().Before(deadline) {
+			return release, fmt.Errorf("%w: %s: %s", errdefs.ErrHostOverloaded, host, reason)
+		}
+		if !queued {
+			s.logger.Info("queued VM until its host drains",
+				slog.String("vm", name),
+				slog.String("host", host),
+				slog.String("reason", reason),
+			)
+			queued = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return release, ctx.Err()
+		case <-time.After(min(admissionPollInterval, time.Until(deadline))):
+		}
+	}
+}
+
+// admitStart admits starting a defined VM on its host. VMs already running need no admission.
+func (s *VMService) admitStart(ctx context.Context, host, name string) (release func(), err error) {
+	if !s.admission.enabled() {
+		return func() {}, nil
+	}
+
+	var vmInfo parameters.VMInfo
+	err = s.withHypervisor(ctx, host, func(hypervisor dependencies.HypervisorContext) error {
+		var err error
+		vmInfo, err = s.libvirtManager.GetVirtualMachineInfo(ctx, hypervisor, parameters.QueryVM{Name: name})
+		return err
+	})
+	if err != nil {
+		return func() {}, err
+	}
+	if vmInfo.State == "running" {
+		return func() {}, nil
+	}
+	return s.admit(ctx, host, name, units.MiBToKiB(uint64(vmInfo.MemoryMB)))
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/errdefs"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/libvirt"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+)
+
+// bucketAdopted holds the specs extracted from adopted domains. They are kept apart from the
+// cluster specs, which the reconciler recreates VMs from: an extracted spec does not say how
+// the disk of the VM was provisioned.
+const bucketAdopted = "adopted"
+
+// AdoptVirtualMachine takes over a domain defined outside homonculus. Its spec is extracted
+// from the definition and stored, and its labels and ownership are recorded in its metadata,
+// after which it can be queried, snapshotted and deleted like the VMs homonculus created.
+// Deleting an adopted VM keeps its disks.
+func (s *VMService) AdoptVirtualMachine(ctx context.Context, params parameters.AdoptVM) (parameters.VMInfo, error) {
+	name := qualify(ctx, params.Name)
+	unlock := s.vmLocks.lock(name)
+	defer unlock()
+
+	host := params.Host
+	if host == "" {
+		host = s.locateVirtualMachine(ctx, name)
+	}
+
+	var spec parameters.CreateVM
+	err := s.withHypervisor(ctx, host, func(hypervisor dependencies.HypervisorContext) error {
+		defer s.vmInfos.invalidate()
+		host = hypervisor.Host
+
+		domainXML, err := s.libvirtManager.GetVirtualMachineXML(hypervisor, name)
+		if err != nil {
+			return err
+		}
+		metadata, err := libvirt.ParseDomainMetadata(domainXML)
+		if err != nil {
+			return err
+		}
+		if metadata.Managed() {
+			return fmt.Errorf("%w: %s is already managed", errdefs.ErrVMExists, name)
+		}
+
+		spec = libvirt.SpecFromDomain(domainXML)
+		spec.Host = hypervisor.Host
+		spec.Owner = callerFromContext(ctx)
+		spec.Labels = maps.Clone(params.Labels)
+		if spec.Labels == nil {
+			spec.Labels = make(map[string]string)
+		}
+		if params.Cluster != "" {
+			spec.Labels = withClusterLabel(spec.Labels, qualify(ctx, params.Cluster))
+		}
+		if namespace := namespaceFromContext(ctx); namespace != "" {
+			spec.Labels[LabelNamespace] = namespace
+		}
+		spec.Ownership, err = newOwnership(ctx, spec, time.Now())
+		if err != nil {
+			return err
+		}
+		spec.Ownership.Adopted = true
+
+		return s.libvirtManager.SetVirtualMachineMetadata(ctx, hypervisor, name, spec.Labels, spec.Ownership)
+	})
+	if err != nil {
+		s.recordEvent(ctx, EventVMAdoptFailed, name, host, "failed to adopt virtual machine", err)
+		return parameters.VMInfo{}, fmt.Errorf("failed to adopt VM %s: %w", name, err)
+	}
+
+	if err := s.store.Put(bucketAdopted, name, spec); err != nil {
+		s.logger.Warn("failed to record adopted VM spec", slog.String("vm", name), slog.String("error", err.Error()))
+	}
+	s.recordPlacement(spec.Labels[LabelCluster], spec)
+	s.recordEvent(ctx, EventVMAdopted, name, host, "adopted virtual machine", nil)
+	s.logger.Info("adopted VM", slog.String("vm", name), slog.String("host", host))
+
+	vmInfo, found, err := s.GetVirtualMachine(ctx, name)
+	if err != nil {
+		return parameters.VMInfo{}, err
+	}
+	if !found {
+		return parameters.VMInfo{}, fmt.Errorf("%w: %s", errdefs.ErrVMNotFound, localName(ctx, name))
+	}
+	return vmInfo, nil
+}
+
+// forgetAdoption removes the stored spec of a deleted adopted VM.
+func (s *VMService) forgetAdoption(name string) {
+	if err := s.store.Delete(bucketAdopted, name); err != nil {
+		s.logger.Warn("failed to remove adopted VM spec", slog.String("vm", name), slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/cloudinit"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/libvirt"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/executor/fileops"
+	"github.com/terabiome/homonculus/pkg/executor/mkisofs"
+	"libvirt.org/go/libvirtxml"
+)
+
+// cloudInitFiles are the cloud-init files a VM may have been provisioned with.
+var cloudInitFiles = []string{"user-data", "meta-data", "network-config"}
+
+// GetVirtualMachineArtifacts collects the files describing how a VM was provisioned: its domain
+// XML, the cloud-init files read back from its ISO or container seed, and its provisioning log
+// built from the event log. A cloud-init file that cannot be read is replaced by a .error file
+// holding the reason, so the other artifacts are still returned.
+func (s *VMService) GetVirtualMachineArtifacts(ctx context.Context, name string) ([]parameters.VMArtifact, error) {
+	name = qualify(ctx, name)
+	var artifacts []parameters.VMArtifact
+	err := s.withVirtualMachineHypervisor(ctx, name, func(hypervisor dependencies.HypervisorContext) error {
+		domainXML, err := s.libvirtManager.GetVirtualMachineXML(hypervisor, name)
+		if err != nil {
+			return err
+		}
+		definition, err := domainXML.Marshal()
+		if err != nil {
+			return fmt.Errorf("could not serialize domain XML: %w", err)
+		}
+		artifacts = append(artifacts, parameters.VMArtifact{Name: "domain.xml", Data: []byte(definition)})
+
+		artifacts = append(artifacts, s.cloudInitArtifacts(ctx, hypervisor, name, domainXML)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := s.ListEvents(parameters.EventFilter{VM: name})
+	if err != nil {
+		return nil, err
+	}
+	var log strings.Builder
+	for _, event := range events {
+		fmt.Fprintf(&log, "%s %s", event.Time.Format(time.RFC3339), event.Type)
+		if event.Host != "" {
+			fmt.Fprintf(&log, " host=%s", event.Host)
+		}
+		if event.Actor != "" {
+			fmt.Fprintf(&log, " actor=%s", event.Actor)
+		}
+		fmt.Fprintf(&log, ": %s", event.Message)
+		if event.Error != "" {
+			fmt.Fprintf(&log, ": %s", event.Error)
+		}
+		log.WriteString("\n")
+	}
+	artifacts = append(artifacts, parameters.VMArtifact{Name: "provisioning.log", Data: []byte(log.String())})
+	return artifacts, nil
+}
+
+// cloudInitArtifacts reads the cloud-init files of a VM back from its ISO, or from the seed
+// directory of a container.
+func (s *VMService) cloudInitArtifacts(ctx context.Context, hypervisor dependencies.HypervisorContext, name string, domainXML libvirtxml.Domain) []parameters.VMArtifact {
+	var artifacts []parameters.VMArtifact
+	failed := func(file string, err error) {
+		s.logger.Warn("failed to read cloud-init files",
+			slog.String("vm", name),
+			slog.String("file", file),
+			slog.String("error", err.Error()),
+		)
+		artifacts = append(artifacts, parameters.VMArtifact{Name: file + ".error", Data: []byte(err.Error() + "\n")})
+	}
+
+	if rootfs := libvirt.ContainerRootfs(domainXML); rootfs != "" {
+		seedDir := path.Join(rootfs, cloudinit.NocloudSeedDir)
+		for _, file := range cloudInitFiles {
+			exists, _, err := fileops.FileStatus(ctx, hypervisor.Executor, path.Join(seedDir, file))
+			if err == nil && !exists {
+				continue
+			}
+			data, err := fileops.ReadFile(ctx, hypervisor.Executor, path.Join(seedDir, file))
+			if err != nil {
+				failed(file, err)
+				continue
+			}
+			artifacts = append(artifacts, parameters.VMArtifact{Name: file, Data: data})
+		}
+		return artifacts
+	}
+
+	isoPath := libvirt.CloudInitISOPath(domainXML)
+	if isoPath == "" {
+		return nil
+	}
+	files, err := mkisofs.ReadFiles(ctx, hypervisor.Executor, isoPath)
+	if err != nil {
+		failed("cloud-init", err)
+		return artifacts
+	}
+	for _, file := range cloudInitFiles {
+		if data, ok := files[file]; ok {
+			artifacts = append(artifacts, parameters.VMArtifact{Name: file, Data: data})
+		}
+	}
+	return artifacts
+}
@@ -0,0 +1,280 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/errdefs"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/internal/store"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// bucketBoots holds the last measured boot of every VM, keyed by VM name.
+const bucketBoots = "boots"
+
+const (
+	// bootPollInterval is how often BootWatcher checks the VMs that are still booting.
+	bootPollInterval = time.Second
+	// bootWatchTimeout is how long after a start a VM is watched for its boot milestones.
+	bootWatchTimeout = 15 * time.Minute
+)
+
+// bootRecord is the persisted last measured boot of a VM.
+type bootRecord struct {
+	VM   string
+	Boot parameters.BootTime
+}
+
+// bootWatch is a started VM whose boot is being measured.
+type bootWatch struct {
+	// ctx carries the job and trace of the request that started the VM, without its deadline.
+	ctx       context.Context
+	host      string
+	baseImage string
+	boot      parameters.BootTime
+	// noAgent is set once the domain turned out to have no guest agent to wait for.
+	noAgent bool
+}
+
+// watchBoot starts measuring the boot of a VM that was just started on host. The boot is
+// complete once libvirt saw a DHCP lease and the guest agent answered.
+func (s *VMService) watchBoot(ctx context.Context, name, host, baseImage string) {
+	watch := &bootWatch{
+		ctx:       context.WithoutCancel(ctx),
+		host:      host,
+		baseImage: baseImage,
+		boot:      parameters.BootTime{StartedAt: time.Now().UTC(), Pending: true},
+	}
+
+	s.bootMu.Lock()
+	defer s.bootMu.Unlock()
+
+	s.booting[name] = watch
+	s.saveBoot(name, watch.boot)
+}
+
+// forgetBoot stops measuring the boot of a deleted VM and removes its last measurement.
+func (s *VMService) forgetBoot(name string) {
+	s.bootMu.Lock()
+	defer s.bootMu.Unlock()
+
+	delete(s.booting, name)
+	if err := s.store.Delete(bucketBoots, name); err != nil {
+		s.logger.Warn("failed to remove boot time", slog.String("vm", name), slog.String("error", err.Error()))
+	}
+}
+
+// baseImageOf returns the base image in the stored spec of a VM, or "" if it has none.
+func (s *VMService) baseImageOf(name string) string {
+	vm, _, err := s.findVirtualMachineSpec(name)
+	if err != nil {
+		s.logger.Debug("failed to look up VM spec", slog.String("vm", name), slog.String("error", err.Error()))
+	}
+	return vm.BaseImagePath
+}
+
+// saveBoot stores the boot of a VM. Callers hold bootMu.
+func (s *VMService) saveBoot(name string, boot parameters.BootTime) {
+	if err := s.store.Put(bucketBoots, name, bootRecord{VM: name, Boot: boot}); err != nil {
+		s.logger.Warn("failed to record boot time", slog.String("vm", name), slog.String("error", err.Error()))
+	}
+}
+
+// attachBootTimes sets the last measured boot on the VMs that have one. Measurements that
+// were still pending when homonculus restarted are reported as they stopped.
+func (s *VMService) attachBootTimes(vmInfos []parameters.VMInfo) {
+	s.bootMu.Lock()
+	defer s.bootMu.Unlock()
+
+	records, err := store.List[bootRecord](s.store, bucketBoots)
+	if err != nil {
+		s.logger.Warn("failed to attach boot times", slog.String("error", err.Error()))
+		return
+	}
+	byName := make(map[string]parameters.BootTime, len(records))
+	for _, record := range records {
+		if _, watched := s.booting[record.VM]; !watched {
+			record.Boot.Pending = false
+		}
+		byName[record.VM] = record.Boot
+	}
+	for i := range vmInfos {
+		if boot, ok := byName[vmInfos[i].Name]; ok {
+			vmInfos[i].Boot = &boot
+		}
+	}
+}
+
+// CheckBoots checks every VM that is still booting once for a DHCP lease and a responding guest
+// agent. VMs that reached both, stopped or ran out of time are reported and no longer watched.
+func (s *VMService) CheckBoots(ctx context.Context) {
+	s.bootMu.Lock()
+	names := make([]string, 0, len(s.booting))
+	for name := range s.booting {
+		names = append(names, name)
+	}
+	s.bootMu.Unlock()
+
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.bootMu.Lock()
+		watch, ok := s.booting[name]
+		s.bootMu.Unlock()
+		if !ok {
+			continue
+		}
+
+		running := s.checkBoot(ctx, name, watch)
+		done := watch.boot.IPAcquired != 0 && (watch.boot.GuestAgentReady != 0 || watch.noAgent)
+
+		s.bootMu.Lock()
+		if s.booting[name] != watch {
+			// Deleted or started again meanwhile.
+			s.bootMu.Unlock()
+			continue
+		}
+		if done || !running || time.Since(watch.boot.StartedAt) > bootWatchTimeout {
+			watch.boot.Pending = false
+			delete(s.booting, name)
+		}
+		s.saveBoot(name, watch.boot)
+		s.bootMu.Unlock()
+
+		if !watch.boot.Pending {
+			s.reportBoot(name, watch)
+		}
+	}
+}
+
+// checkBoot records the boot milestones a VM reached since it was last checked. It reports
+// false if the VM is no longer running.
+func (s *VMService) checkBoot(ctx context.Context, name string, watch *bootWatch) bool {
+	running := true
+	err := s.withHypervisor(ctx, watch.host, func(hypervisor dependencies.HypervisorContext) error {
+		vmInfo, err := s.libvirtManager.GetVirtualMachineInfo(ctx, hypervisor, parameters.QueryVM{Name: name})
+		if err != nil {
+			return err
+		}
+		if vmInfo.State != "running" {
+			running = false
+			return nil
+		}
+
+		if watch.boot.IPAcquired == 0 && vmInfo.IPAddress != "" {
+			watch.boot.IPAcquired = time.Since(watch.boot.StartedAt)
+			s.recordPhase(name, PhaseIPAcquired)
+		}
+		if watch.boot.GuestAgentReady == 0 && !watch.noAgent {
+			ready, err := s.libvirtManager.GuestAgentReady(hypervisor, name)
+			switch {
+			case errors.Is(err, errdefs.ErrNotSupported):
+				watch.noAgent = true
+			case err != nil:
+				return err
+			case ready:
+				watch.boot.GuestAgentReady = time.Since(watch.boot.StartedAt)
+				s.recordPhase(name, PhaseAgentReady)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Debug("failed to check VM boot", slog.String("vm", name), slog.String("error", err.Error()))
+	}
+	return running
+}
+
+// reportBoot records the boot time of a VM whose boot is no longer measured as an event of
+// the job that started it and as metrics.
+func (s *VMService) reportBoot(name string, watch *bootWatch) {
+	boot := watch.boot
+
+	var reached, missing []string
+	if boot.IPAcquired != 0 {
+		reached = append(reached, fmt.Sprintf("DHCP lease after %s", boot.IPAcquired.Round(100*time.Millisecond)))
+	} else {
+		missing = append(missing, "DHCP lease")
+	}
+	if boot.GuestAgentReady != 0 {
+		reached = append(reached, fmt.Sprintf("guest agent after %s", boot.GuestAgentReady.Round(100*time.Millisecond)))
+	} else if !watch.noAgent {
+		missing = append(missing, "guest agent")
+	}
+
+	message := "booted"
+	if len(missing) > 0 {
+		message = "boot incomplete"
+	}
+	if len(reached) > 0 {
+		message += ": " + strings.Join(reached, ", ")
+	}
+	var err error
+	if len(missing) > 0 {
+		err = fmt.Errorf("no %s within %s of the start", strings.Join(missing, " or "), time.Since(boot.StartedAt).Round(time.Second))
+	}
+	s.logger.Info("measured VM boot",
+		slog.String("vm", name),
+		slog.Duration("ip_acquired", boot.IPAcquired),
+		slog.Duration("guest_agent_ready", boot.GuestAgentReady),
+	)
+	s.recordEvent(watch.ctx, EventVMBooted, name, watch.host, message, err)
+
+	if s.vmBootDuration == nil {
+		return
+	}
+	milestones := map[string]time.Duration{PhaseIPAcquired: boot.IPAcquired, PhaseAgentReady: boot.GuestAgentReady}
+	for milestone, elapsed := range milestones {
+		if elapsed == 0 {
+			continue
+		}
+		s.vmBootDuration.Record(watch.ctx, elapsed.Seconds(), metric.WithAttributes(
+			attribute.String("vm.name", name),
+			attribute.String("vm.base_image", watch.baseImage),
+			attribute.String("milestone", milestone),
+		))
+	}
+}
+
+// BootWatcher periodically runs VMService.CheckBoots in the background.
+type BootWatcher struct {
+	vmService *VMService
+	interval  time.Duration
+	logger    *slog.Logger
+}
+
+// NewBootWatcher creates a new BootWatcher.
+func NewBootWatcher(vmService *VMService, logger *slog.Logger) *BootWatcher {
+	return &BootWatcher{
+		vmService: vmService,
+		interval:  bootPollInterval,
+		logger:    logger.With(slog.String("component", "boot-watcher")),
+	}
+}
+
+// Run checks booting VMs on every interval until ctx is cancelled.
+func (w *BootWatcher) Run(ctx context.Context) {
+	w.logger.Info("boot watcher started", slog.Duration("interval", w.interval))
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			w.logger.Info("boot watcher stopped")
+			return
+		case <-ticker.C:
+			w.vmService.CheckBoots(ctx)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/service/parameters"
+)
+
+// vmInfoCache keeps the VM listing of every host for a short time, so dashboards polling the
+// list-all query do not hit libvirt on every request. A zero TTL disables caching.
+type vmInfoCache struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	infos    []parameters.VMInfo
+	expires  time.Time
+	revision uint64
+}
+
+func newVMInfoCache(ttl time.Duration) *vmInfoCache {
+	return &vmInfoCache{ttl: ttl}
+}
+
+// get returns the cached listing while it is fresh.
+func (c *vmInfoCache) get() ([]parameters.VMInfo, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.infos == nil || time.Now().After(c.expires) {
+		return nil, false
+	}
+	return append([]parameters.VMInfo(nil), c.infos...), true
+}
+
+// snapshot returns the current revision, to be passed to put once a listing was fetched.
+func (c *vmInfoCache) snapshot() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.revision
+}
+
+// put stores a listing fetched at revision, unless the cache was invalidated meanwhile.
+func (c *vmInfoCache) put(revision uint64, infos []parameters.VMInfo) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if revision != c.revision {
+		return
+	}
+	c.infos = append([]parameters.VMInfo(nil), infos...)
+	c.expires = time.Now().Add(c.ttl)
+}
+
+// invalidate drops the cached listing after a VM was changed.
+func (c *vmInfoCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.revision++
+	c.infos = nil
+}
+
+// cachedListAllVirtualMachines lists the VMs of every host, served from the cache while it is fresh.
+// Readiness results, provisioning timelines and boot times are not cached; callers returning the listing attach them.
+func (s *VMService) cachedListAllVirtualMachines(ctx context.Context) ([]parameters.VMInfo, error) {
+	if vmInfos, ok := s.vmInfos.get(); ok {
+		s.logger.Debug("serving VM listing from cache", slog.Int("count", len(vmInfos)))
+		return vmInfos, nil
+	}
+
+	revision := s.vmInfos.snapshot()
+	vmInfos, err := s.listAllVirtualMachines(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.vmInfos.put(revision, vmInfos)
+	return vmInfos, nil
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/errdefs"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/libvirt"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"libvirt.org/go/libvirtxml"
+)
+
+// cloneISOPath returns where the cloud-init ISO of a clone is written: where the ISO layout
+// puts it, or next to its disk.
+func cloneISOPath(target parameters.TargetVMSpec) string {
+	if target.CloudInitISOPath != "" {
+		return target.CloudInitISOPath
+	}
+	return filepath.Join(filepath.Dir(target.DiskPath), target.Name+"-cloud-init.iso")
+}
+
+// CloneCluster clones a shut-off base VM into the target VMs, on the host of the base VM.
+// Every clone gets a fresh machine identity and, when the base VM uses cloud-init, its own
+// cloud-init ISO rendered from the stored spec of the base VM under the clone's name.
+// Clones are labelled with the cluster of the request and drop the CPU pins of the base VM.
+func (s *VMService) CloneCluster(ctx context.Context, params parameters.CloneVM) error {
+	params.Cluster = qualify(ctx, params.Cluster)
+	params.BaseVMName = qualify(ctx, params.BaseVMName)
+	params.TargetSpecs = slices.Clone(params.TargetSpecs)
+	clones := make([]parameters.CreateVM, 0, len(params.TargetSpecs))
+	for i := range params.TargetSpecs {
+		params.TargetSpecs[i].Name = qualify(ctx, params.TargetSpecs[i].Name)
+		if err := sanitizeCloneTarget(&params.TargetSpecs[i]); err != nil {
+			return err
+		}
+		if err := s.applyCloneLayout(&params.TargetSpecs[i]); err != nil {
+			return err
+		}
+		target := params.TargetSpecs[i]
+		clones = append(clones, parameters.CreateVM{Name: target.Name, DiskPath: target.DiskPath, CloudInitISOPath: cloneISOPath(target)})
+	}
+	if err := checkDuplicates(clones); err != nil {
+		return err
+	}
+
+	host := s.locateVirtualMachine(ctx, params.BaseVMName)
+	if s.inMaintenance(host) {
+		return fmt.Errorf("%w: base VM %s is on host %s", ErrHostInMaintenance, params.BaseVMName, host)
+	}
+
+	var baseDomainXML libvirtxml.Domain
+	err := s.withHypervisor(ctx, host, func(hypervisor dependencies.HypervisorContext) error {
+		info, err := s.libvirtManager.GetVirtualMachineInfo(ctx, hypervisor, parameters.QueryVM{Name: params.BaseVMName})
+		if err != nil {
+			return err
+		}
+		// A running base VM keeps writing to the disk its clones would be backed by.
+		if info.State != "shutoff" {
+			return fmt.Errorf("base VM %s must be shut off to be cloned, it is %s", params.BaseVMName, info.State)
+		}
+		baseDomainXML, err = s.libvirtManager.GetVirtualMachineXML(hypervisor, params.BaseVMName)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read base VM %s: %w", params.BaseVMName, err)
+	}
+	if baseDomainXML.Type == "lxc" {
+		return fmt.Errorf("%w: base VM %s is a container, which cannot be cloned", errdefs.ErrNotSupported, params.BaseVMName)
+	}
+
+	baseSpec, found, err := s.findVirtualMachineSpec(params.BaseVMName)
+	if err != nil {
+		s.logger.Warn("failed to read stored spec of base VM", slog.String("vm", params.BaseVMName), slog.String("error", err.Error()))
+	}
+	if !found {
+		s.logger.Debug("base VM has no stored spec, clones get a minimal cloud-init configuration",
+			slog.String("vm", params.BaseVMName),
+		)
+	}
+
+	owner := callerFromContext(ctx)
+	planned := make([]parameters.CreateVM, 0, len(params.TargetSpecs))
+	for _, target := range params.TargetSpecs {
+		vm := cloneSpec(baseSpec, target, params.Cluster, host, false)
+		vm.Owner = owner
+		planned = append(planned, vm)
+	}
+	release, err := s.reserveQuota(ctx, planned)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var failedVMs []string
+	var failures []error
+	for i, target := range params.TargetSpecs {
+		if err := ctx.Err(); err != nil {
+			return cancelledError("clone", i, len(params.TargetSpecs), failedVMs, err)
+		}
+
+		if target.BaseImagePath == "" {
+			target.BaseImagePath = libvirt.BaseDiskPath(baseDomainXML)
+		}
+
+		withISO := libvirt.HasCloudInitISO(baseDomainXML)
+		if !withISO && customizesCloudInit(target) {
+			s.logger.Error("cannot customize clone without cloud-init",
+				slog.String("base", params.BaseVMName),
+				slog.String("vm", target.Name),
+			)
+			failedVMs = append(failedVMs, target.Name)
+			failures = append(failures, fmt.Errorf("cannot customize clone %s of base VM %s without cloud-init", target.Name, params.BaseVMName))
+			continue
+		}
+
+		vm := cloneSpec(baseSpec, target, params.Cluster, host, withISO)
+		vm.Owner = owner
+		if withISO && vm.Hostname == "" {
+			vm.Hostname = localName(ctx, vm.Name)
+		}
+		err := s.withHypervisor(ctx, host, func(hypervisor dependencies.HypervisorContext) error {
+			return s.cloneVirtualMachine(ctx, hypervisor, baseDomainXML, target, vm)
+		})
+		status := "success"
+		if err != nil {
+			s.logger.Error("failed to clone VM",
+				slog.String("base", params.BaseVMName),
+				slog.String("vm", target.Name),
+				slog.String("error", err.Error()),
+			)
+			failedVMs = append(failedVMs, target.Name)
+			failures = append(failures, err)
+			status = "failed"
+			s.recordEvent(ctx, EventVMCloneFailed, target.Name, host, "failed to clone virtual machine from "+params.BaseVMName, err)
+		} else {
+			s.recordPlacement(params.Cluster, vm)
+			s.recordEvent(ctx, EventVMCloned, target.Name, host, "cloned virtual machine from "+params.BaseVMName, nil)
+		}
+		if s.vmCloneCounter != nil {
+			s.vmCloneCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("status", status)))
+		}
+	}
+
+	if len(failedVMs) > 0 {
+		return &batchError{action: "clone", vms: failedVMs, errs: failures}
+	}
+	return nil
+}
+
+// customizesCloudInit reports whether a clone target overrides cloud-init settings of its base VM.
+func customizesCloudInit(target parameters.TargetVMSpec) bool {
+	return target.Hostname != "" || len(target.UserConfigs) > 0 || len(target.Runcmds) > 0 || target.Network != nil
+}
+
+// cloneSpec derives the spec of a clone from the spec of its base VM: the clone keeps the
+// base's cloud-init settings unless the target overrides them, and gets its own name,
+// cluster, resources and files. Pinned host CPUs stay with the base VM.
+func cloneSpec(base parameters.CreateVM, target parameters.TargetVMSpec, cluster, host string, withISO bool) parameters.CreateVM {
+	vm := base
+	vm.Name = target.Name
+	vm.Labels = cloneLabels(base.Labels, cluster)
+	if base.Tuning != nil {
+		tuning := *base.Tuning
+		tuning.VCPUPins = nil
+		tuning.EmulatorCPUSet = ""
+		tuning.IOThreadPins = nil
+		vm.Tuning = &tuning
+	}
+	vm.VCPUCount = target.VCPUCount
+	vm.MemoryMB = target.MemoryMB
+	vm.DiskPath = target.DiskPath
+	vm.DiskSizeGB = target.DiskSizeGB
+	vm.BaseImagePath = target.BaseImagePath
+	vm.Host = host
+	vm.NVRAMPath = ""
+	vm.HostDevices = nil
+	vm.Start = target.Start
+	vm.KeepArtifactsOnFailure = false
+	vm.Hostname = target.Hostname
+	if len(target.UserConfigs) > 0 {
+		vm.UserConfigs = target.UserConfigs
+	}
+	if len(target.Runcmds) > 0 {
+		vm.Runcmds = target.Runcmds
+	}
+	if target.Network != nil {
+		vm.Network = target.Network
+	}
+	vm.CloudInitISOPath = ""
+	if withISO {
+		vm.CloudInitISOPath = cloneISOPath(target)
+	}
+	return vm
+}
+
+// cloneLabels returns the labels of a clone: those of its base VM, with the cluster replaced.
+func cloneLabels(labels map[string]string, cluster string) map[string]string {
+	labels = maps.Clone(labels)
+	delete(labels, LabelCluster)
+	if cluster != "" {
+		labels = withClusterLabel(labels, cluster)
+	}
+	return labels
+}
+
+// cloneVirtualMachine creates the disk and cloud-init ISO of a clone and defines it from the
+// base definition. On failure the completed steps are rolled back.
+func (s *VMService) cloneVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, baseDomainXML libvirtxml.Domain, target parameters.TargetVMSpec, vm parameters.CreateVM) error {
+	startTime := time.Now()
+	virtualMachineUUID := uuid.New()
+	defer s.vmInfos.invalidate()
+
+	exists, err := s.libvirtManager.CheckVirtualMachineExistence(hypervisor, target.Name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("%w: %s", errdefs.ErrVMExists, target.Name)
+	}
+
+	if err := s.checkPaths(vm); err != nil {
+		return err
+	}
+
+	unresolved := vm
+	vm, err = s.resolveSecrets(ctx, vm)
+	if err != nil {
+		return err
+	}
+
+	undo := newRollback(target.Name)
+
+	if err := s.diskManager.CreateDiskForClone(ctx, hypervisor, target); err != nil {
+		if ctx.Err() != nil {
+			undo.push("disk "+target.DiskPath, s.removeFileStep(hypervisor, target.DiskPath))
+		}
+		s.rollBack(ctx, vm, undo)
+		return err
+	}
+	undo.push("disk "+target.DiskPath, s.removeFileStep(hypervisor, target.DiskPath))
+
+	if vm.CloudInitISOPath != "" {
+		// A fresh instance id makes cloud-init treat the clone as a new machine.
+		if err := s.cloudinitManager.CreateISO(ctx, hypervisor, vm, virtualMachineUUID); err != nil {
+			undo.push("cloud-init ISO "+vm.CloudInitISOPath, s.removeFileStep(hypervisor, vm.CloudInitISOPath))
+			s.rollBack(ctx, vm, undo)
+			return err
+		}
+		undo.push("cloud-init ISO "+vm.CloudInitISOPath, s.removeFileStep(hypervisor, vm.CloudInitISOPath))
+	}
+
+	// The clone keeps the labels of its base apart from the cluster, and records its own ownership.
+	metadata, err := libvirt.ParseDomainMetadata(baseDomainXML)
+	if err != nil {
+		s.rollBack(ctx, vm, undo)
+		return err
+	}
+	ownership, err := newOwnership(ctx, unresolved, startTime)
+	if err != nil {
+		s.rollBack(ctx, vm, undo)
+		return err
+	}
+	metadata.SetLabels(cloneLabels(metadata.LabelMap(), vm.Labels[LabelCluster]))
+	metadata.SetOwnership(ownership)
+	if err := libvirt.ReplaceDomainMetadata(&baseDomainXML, metadata); err != nil {
+		s.rollBack(ctx, vm, undo)
+		return err
+	}
+	libvirt.DropCPUPins(&baseDomainXML)
+
+	s.defineMu.Lock()
+	err = s.libvirtManager.CloneVirtualMachine(ctx, hypervisor, baseDomainXML, target, virtualMachineUUID, vm.CloudInitISOPath)
+	s.defineMu.Unlock()
+	if err != nil {
+		s.rollBack(ctx, vm, undo)
+		return err
+	}
+	undo.push("domain definition", func(ctx context.Context) error {
+		return s.libvirtManager.UndefineVirtualMachine(ctx, hypervisor, target.Name)
+	})
+
+	if vm.Start {
+		if err := s.libvirtManager.StartVirtualMachine(ctx, hypervisor, parameters.StartVM{Name: target.Name}); err != nil {
+			s.logger.Error("failed to start cloned VM",
+				slog.String("vm", target.Name),
+				slog.String("error", err.Error()),
+			)
+			if !s.rollBack(ctx, vm, undo) {
+				s.recordFailedVM(hypervisor, vm, err)
+			}
+			return err
+		}
+		s.watchBoot(ctx, target.Name, hypervisor.Host, vm.BaseImagePath)
+	}
+
+	if vm.CloudInitISOPath != "" {
+		s.recordCloudInit(unresolved, virtualMachineUUID)
+	}
+	s.forgetFailedVM(target.Name)
+
+	s.logger.Info("successfully cloned VM",
+		slog.String("vm", target.Name),
+		slog.String("uuid", virtualMachineUUID.String()),
+	)
+	if s.vmCloneDuration != nil {
+		s.vmCloneDuration.Record(ctx, time.Since(startTime).Seconds(), metric.WithAttributes(
+			attribute.String("vm.name", target.Name),
+		))
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/secrets"
+)
+
+// bucketCloudInit records the cloud-init documents every VM was provisioned with, keyed by VM name.
+const bucketCloudInit = "cloud_init"
+
+// cloudInitRecord holds the redacted cloud-init documents rendered for a VM.
+type cloudInitRecord struct {
+	VM         string            `json:"vm"`
+	InstanceID string            `json:"instance_id"`
+	RenderedAt time.Time         `json:"rendered_at"`
+	Documents  map[string]string `json:"documents"` // by file name, e.g. user-data
+}
+
+// recordCloudInit renders the cloud-init documents of a VM once more, from its spec before
+// secret resolution and with passwords and proxy credentials masked, and keeps them in the
+// state store. Failures are logged, never returned, so recording cannot fail a creation.
+func (s *VMService) recordCloudInit(vm parameters.CreateVM, instanceID uuid.UUID) {
+	documents, err := s.cloudinitManager.Render(redactCloudInit(vm), instanceID)
+	if err != nil {
+		s.logger.Warn("failed to render cloud-init documents for the record", slog.String("vm", vm.Name), slog.String("error", err.Error()))
+		return
+	}
+	if len(documents) == 0 {
+		return
+	}
+
+	record := cloudInitRecord{
+		VM:         vm.Name,
+		InstanceID: instanceID.String(),
+		RenderedAt: time.Now().UTC(),
+		Documents:  make(map[string]string, len(documents)),
+	}
+	for _, document := range documents {
+		record.Documents[document.Name] = string(document.Data)
+	}
+	if err := s.store.Put(bucketCloudInit, vm.Name, record); err != nil {
+		s.logger.Warn("failed to record cloud-init documents", slog.String("vm", vm.Name), slog.String("error", err.Error()))
+	}
+}
+
+// redactCloudInit returns a copy of vm whose cloud-init documents hold no credentials: passwords
+// that are not secret:// references are masked, as are passwords in proxy URLs.
+func redactCloudInit(vm parameters.CreateVM) parameters.CreateVM {
+	vm.UserConfigs = slices.Clone(vm.UserConfigs)
+	for i := range vm.UserConfigs {
+		if vm.UserConfigs[i].Password != "" && !secrets.IsRef(vm.UserConfigs[i].Password) {
+			vm.UserConfigs[i].Password = secrets.Mask
+		}
+	}
+	if vm.Proxy != nil {
+		proxy := *vm.Proxy
+		for _, value := range []*string{&proxy.HTTPProxy, &proxy.HTTPSProxy} {
+			if parsed, err := url.Parse(*value); err == nil && parsed.User != nil {
+				*value = parsed.Redacted()
+			}
+		}
+		vm.Proxy = &proxy
+	}
+	return vm
+}
+
+// forgetCloudInit drops the cloud-init record of a deleted VM.
+func (s *VMService) forgetCloudInit(name string) {
+	if err := s.store.Delete(bucketCloudInit, name); err != nil {
+		s.logger.Warn("failed to forget cloud-init documents", slog.String("vm", name), slog.String("error", err.Error()))
+	}
+}
+
+// GetCloudInit returns the recorded cloud-init documents of a VM. It reports false if none
+// were recorded, as for VMs without cloud-init or created before documents were recorded.
+func (s *VMService) GetCloudInit(name string) (parameters.CloudInitDocuments, bool, error) {
+	var record cloudInitRecord
+	found, err := s.store.Get(bucketCloudInit, name, &record)
+	if err != nil {
+		return parameters.CloudInitDocuments{}, false, fmt.Errorf("failed to load cloud-init documents: %w", err)
+	}
+	if !found {
+		return parameters.CloudInitDocuments{}, false, nil
+	}
+	return parameters.CloudInitDocuments{
+		VM:         record.VM,
+		InstanceID: record.InstanceID,
+		RenderedAt: record.RenderedAt,
+		Documents:  record.Documents,
+	}, true, nil
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/internal/store"
+)
+
+const (
+	// bucketClusters holds the desired-state spec of every named cluster.
+	bucketClusters = "clusters"
+
+	// LabelCluster is the label key identifying the cluster a VM belongs to.
+	LabelCluster = "cluster"
+)
+
+// withClusterLabel returns a copy of labels carrying the cluster label, unless the caller set one explicitly.
+func withClusterLabel(labels map[string]string, clusterName string) map[string]string {
+	result := maps.Clone(labels)
+	if result == nil {
+		result = make(map[string]string)
+	}
+	if _, ok := result[LabelCluster]; !ok {
+		result[LabelCluster] = clusterName
+	}
+	return result
+}
+
+// saveClusterSpec merges the VMs of a create request into the stored spec of the cluster.
+func (s *VMService) saveClusterSpec(cluster parameters.CreateCluster) error {
+	s.clustersMu.Lock()
+	defer s.clustersMu.Unlock()
+
+	stored := parameters.CreateCluster{Name: cluster.Name}
+	if _, err := s.store.Get(bucketClusters, cluster.Name, &stored); err != nil {
+		return fmt.Errorf("failed to load cluster spec %s: %w", cluster.Name, err)
+	}
+
+	for _, vm := range cluster.VirtualMachines {
+		replaced := false
+		for i, existing := range stored.VirtualMachines {
+			if existing.Name == vm.Name {
+				stored.VirtualMachines[i] = vm
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			stored.VirtualMachines = append(stored.VirtualMachines, vm)
+		}
+	}
+
+	if err := s.store.Put(bucketClusters, cluster.Name, stored); err != nil {
+		return fmt.Errorf("failed to save cluster spec %s: %w", cluster.Name, err)
+	}
+	s.logger.Debug("saved cluster spec",
+		slog.String("cluster", cluster.Name),
+		slog.Int("vms", len(stored.VirtualMachines)),
+	)
+	return nil
+}
+
+// forgetVirtualMachine removes a VM from every stored cluster spec, dropping clusters left empty.
+func (s *VMService) forgetVirtualMachine(name string) error {
+	s.clustersMu.Lock()
+	defer s.clustersMu.Unlock()
+
+	clusters, err := store.List[parameters.CreateCluster](s.store, bucketClusters)
+	if err != nil {
+		return err
+	}
+
+	for _, cluster := range clusters {
+		remaining := cluster.VirtualMachines[:0]
+		for _, vm := range cluster.VirtualMachines {
+			if vm.Name != name {
+				remaining = append(remaining, vm)
+			}
+		}
+		if len(remaining) == len(cluster.VirtualMachines) {
+			continue
+		}
+
+		if len(remaining) == 0 {
+			if err := s.store.Delete(bucketClusters, cluster.Name); err != nil {
+				return err
+			}
+			s.logger.Info("removed empty cluster spec", slog.String("cluster", cluster.Name))
+			continue
+		}
+
+		cluster.VirtualMachines = remaining
+		if err := s.store.Put(bucketClusters, cluster.Name, cluster); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListClusterSpecs returns the stored desired-state spec of every named cluster.
+func (s *VMService) ListClusterSpecs() ([]parameters.CreateCluster, error) {
+	return store.List[parameters.CreateCluster](s.store, bucketClusters)
+}
+
+// GetClusterSpec returns the stored desired-state spec of a named cluster. It reports false if there is none.
+func (s *VMService) GetClusterSpec(name string) (parameters.CreateCluster, bool, error) {
+	var cluster parameters.CreateCluster
+	found, err := s.store.Get(bucketClusters, name, &cluster)
+	if err != nil {
+		return parameters.CreateCluster{}, false, fmt.Errorf("failed to load cluster spec %s: %w", name, err)
+	}
+	return cluster, found, nil
+}
+
+// updateVirtualMachineSpec applies fn to the stored spec of a VM, if the VM belongs to a named cluster.
+func (s *VMService) updateVirtualMachineSpec(name string, fn func(vm *parameters.CreateVM)) error {
+	s.clustersMu.Lock()
+	defer s.clustersMu.Unlock()
+
+	clusters, err := store.List[parameters.CreateCluster](s.store, bucketClusters)
+	if err != nil {
+		return err
+	}
+
+	for _, cluster := range clusters {
+		for i := range cluster.VirtualMachines {
+			if cluster.VirtualMachines[i].Name != name {
+				continue
+			}
+			fn(&cluster.VirtualMachines[i])
+			return s.store.Put(bucketClusters, cluster.Name, cluster)
+		}
+	}
+	return nil
+}
+
+// findVirtualMachineSpec returns the stored spec of a VM, if it belongs to a named cluster.
+func (s *VMService) findVirtualMachineSpec(name string) (parameters.CreateVM, bool, error) {
+	clusters, err := store.List[parameters.CreateCluster](s.store, bucketClusters)
+	if err != nil {
+		return parameters.CreateVM{}, false, err
+	}
+
+	for _, cluster := range clusters {
+		for _, vm := range cluster.VirtualMachines {
+			if vm.Name == name {
+				return vm, true, nil
+			}
+		}
+	}
+	return parameters.CreateVM{}, false, nil
+}
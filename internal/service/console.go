@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/executor"
+)
+
+// OpenConsole connects to the graphical console of a running VM from its hypervisor host.
+// The hypervisor is only held while connecting; the caller owns and closes the returned connection.
+func (s *VMService) OpenConsole(ctx context.Context, name string) (net.Conn, error) {
+	name = qualify(ctx, name)
+	var conn net.Conn
+	err := s.withVirtualMachineHypervisor(ctx, name, func(hypervisor dependencies.HypervisorContext) error {
+		address, err := s.libvirtManager.GetConsoleAddress(ctx, hypervisor, name)
+		if err != nil {
+			return err
+		}
+
+		dialer, ok := hypervisor.Executor.(executor.Dialer)
+		if !ok {
+			return fmt.Errorf("executor %s of host %s cannot open network connections", hypervisor.Executor.Name(), hypervisor.Host)
+		}
+
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return fmt.Errorf("failed to connect to console at %s: %w", address, err)
+		}
+
+		s.logger.Info("opened console connection",
+			slog.String("vm", name),
+			slog.String("host", hypervisor.Host),
+			slog.String("address", address),
+		)
+		return nil
+	})
+	return conn, err
+}
+
+// errReadOnlyConsole is returned when writing to a read-only serial console session.
+var errReadOnlyConsole = errors.New("serial console session is read-only")
+
+// serialConsole is a serial console session holding the hypervisor connection its stream lives on.
+type serialConsole struct {
+	io.ReadWriteCloser
+	readOnly  bool
+	release   func()
+	closeOnce sync.Once
+}
+
+func (c *serialConsole) Write(p []byte) (int, error) {
+	if c.readOnly {
+		return 0, errReadOnlyConsole
+	}
+	return c.ReadWriteCloser.Write(p)
+}
+
+func (c *serialConsole) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		err = c.ReadWriteCloser.Close()
+		c.release()
+	})
+	return err
+}
+
+// OpenSerialConsole attaches to the serial console of a running VM. A read-write session takes
+// the console over from any other session, so an operator can always get in; a read-only one
+// fails while another session is attached, and refuses writes. The hypervisor connection is held
+// until the caller closes the returned console.
+func (s *VMService) OpenSerialConsole(ctx context.Context, name string, readWrite bool) (io.ReadWriteCloser, error) {
+	name = qualify(ctx, name)
+	host := s.locateVirtualMachine(ctx, name)
+	hypervisor, release, err := s.acquireHypervisor(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	console, err := s.libvirtManager.OpenSerialConsole(ctx, hypervisor, name, readWrite)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	mode := "read-only"
+	if readWrite {
+		mode = "read-write"
+		s.recordEvent(ctx, EventVMConsoleAttached, name, host, "attached read-write serial console", nil)
+	}
+	s.logger.Info("opened serial console session", slog.String("vm", name), slog.String("host", host), slog.String("mode", mode))
+	return &serialConsole{ReadWriteCloser: console, readOnly: !readWrite, release: release}, nil
+}
+
+// consoleTicketAlphabet is the alphabet of console ticket passwords.
+const consoleTicketAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz23456789"
+
+// GrantConsoleTicket sets a random password on the graphical console of a running VM that
+// expires after ttl, and returns what a VNC or SPICE client needs to connect. The password is
+// as long as VNC allows, and never stored. A console listening on a wildcard address is
+// reached through the hypervisor host named in its connection URI.
+func (s *VMService) GrantConsoleTicket(ctx context.Context, name string, ttl time.Duration) (parameters.ConsoleTicket, error) {
+	name = qualify(ctx, name)
+	password, err := randomConsolePassword()
+	if err != nil {
+		return parameters.ConsoleTicket{}, err
+	}
+	expiresAt := time.Now().Add(ttl).UTC().Truncate(time.Second)
+
+	var ticket parameters.ConsoleTicket
+	err = s.withVirtualMachineHypervisor(ctx, name, func(hypervisor dependencies.HypervisorContext) error {
+		graphics, err := s.libvirtManager.SetGraphicsPassword(ctx, hypervisor, name, password, expiresAt)
+		if err != nil {
+			return err
+		}
+
+		host := graphics.Listen
+		if host == "" || net.ParseIP(host).IsUnspecified() {
+			host = "127.0.0.1"
+			if uri, err := url.Parse(hypervisor.URI); err == nil && uri.Hostname() != "" {
+				host = uri.Hostname()
+			}
+		}
+		ticket = parameters.ConsoleTicket{
+			VM:        name,
+			Type:      graphics.Type,
+			Host:      host,
+			Port:      graphics.Port,
+			Password:  password,
+			ExpiresAt: expiresAt,
+		}
+		s.recordEvent(ctx, EventVMConsoleTicket, name, hypervisor.Host, "issued "+graphics.Type+" console ticket valid until "+expiresAt.Format(time.RFC3339), nil)
+		return nil
+	})
+	return ticket, err
+}
+
+// randomConsolePassword returns a password of the maximum VNC password length. Random bytes
+// beyond the last whole multiple of the alphabet size are skipped, so every character is
+// equally likely.
+func randomConsolePassword() (string, error) {
+	limit := 256 - 256%len(consoleTicketAlphabet)
+	password := make([]byte, 0, 8)
+	random := make([]byte, 16)
+	for len(password) < cap(password) {
+		if _, err := rand.Read(random); err != nil {
+			return "", fmt.Errorf("could not generate console password: %w", err)
+		}
+		for _, b := range random {
+			if int(b) < limit && len(password) < cap(password) {
+				password = append(password, consoleTicketAlphabet[int(b)%len(consoleTicketAlphabet)])
+			}
+		}
+	}
+	return string(password), nil
+}
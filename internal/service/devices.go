@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+)
+
+// AttachDevices hotplugs USB and serial devices into a VM and returns the guest ports of the serial devices.
+// Devices are attached one at a time; if one fails, those already attached are detached again.
+// The stored cluster spec is updated so the reconciler recreates the VM with its devices.
+func (s *VMService) AttachDevices(ctx context.Context, params parameters.AttachDevices) ([]int, error) {
+	params.Name = qualify(ctx, params.Name)
+	unlock := s.vmLocks.lock(params.Name)
+	defer unlock()
+
+	var ports []int
+	err := s.withVirtualMachineHypervisor(ctx, params.Name, func(hypervisor dependencies.HypervisorContext) error {
+		defer s.vmInfos.invalidate()
+		var err error
+		ports, err = s.attachDevices(ctx, hypervisor, params)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach devices to VM %s: %w", params.Name, err)
+	}
+
+	err = s.updateVirtualMachineSpec(params.Name, func(vm *parameters.CreateVM) {
+		vm.USBDevices = append(vm.USBDevices, params.USBDevices...)
+		vm.SerialDevices = append(vm.SerialDevices, params.SerialDevices...)
+	})
+	if err != nil {
+		s.logger.Warn("failed to record attached devices in cluster spec", slog.String("vm", params.Name), slog.String("error", err.Error()))
+	}
+
+	return ports, nil
+}
+
+// attachDevices attaches the devices of params one at a time, recording how to detach each,
+// and rolls the attached ones back when a device fails.
+func (s *VMService) attachDevices(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.AttachDevices) ([]int, error) {
+	undo := newRollback(params.Name)
+	fail := func(err error) ([]int, error) {
+		s.rollBack(ctx, parameters.CreateVM{}, undo)
+		return nil, err
+	}
+
+	for i, device := range params.USBDevices {
+		attach := parameters.AttachDevices{Name: params.Name, USBDevices: []parameters.USBDevice{device}}
+		if _, err := s.libvirtManager.AttachDevices(ctx, hypervisor, attach); err != nil {
+			return fail(fmt.Errorf("usb_devices[%d]: %w", i, err))
+		}
+		undo.push("USB device "+device.VendorProduct, func(ctx context.Context) error {
+			detach := parameters.DetachDevices{Name: params.Name, USBDevices: []parameters.USBDevice{device}}
+			return s.libvirtManager.DetachDevices(ctx, hypervisor, detach)
+		})
+	}
+
+	var ports []int
+	for i, device := range params.SerialDevices {
+		attach := parameters.AttachDevices{Name: params.Name, SerialDevices: []parameters.SerialDevice{device}}
+		attached, err := s.libvirtManager.AttachDevices(ctx, hypervisor, attach)
+		if err != nil {
+			return fail(fmt.Errorf("serial_devices[%d]: %w", i, err))
+		}
+		ports = append(ports, attached...)
+		undo.push(fmt.Sprintf("serial port %v", attached), func(ctx context.Context) error {
+			detach := parameters.DetachDevices{Name: params.Name, SerialPorts: attached}
+			return s.libvirtManager.DetachDevices(ctx, hypervisor, detach)
+		})
+	}
+	return ports, nil
+}
+
+// DetachDevices unplugs USB devices and serial ports from a VM.
+func (s *VMService) DetachDevices(ctx context.Context, params parameters.DetachDevices) error {
+	params.Name = qualify(ctx, params.Name)
+	unlock := s.vmLocks.lock(params.Name)
+	defer unlock()
+
+	err := s.withVirtualMachineHypervisor(ctx, params.Name, func(hypervisor dependencies.HypervisorContext) error {
+		defer s.vmInfos.invalidate()
+		return s.libvirtManager.DetachDevices(ctx, hypervisor, params)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to detach devices from VM %s: %w", params.Name, err)
+	}
+
+	err = s.updateVirtualMachineSpec(params.Name, func(vm *parameters.CreateVM) {
+		vm.USBDevices = slices.DeleteFunc(vm.USBDevices, func(device parameters.USBDevice) bool {
+			return slices.Contains(params.USBDevices, device)
+		})
+		// Extra serial devices occupy guest ports 1..n in spec order.
+		var remaining []parameters.SerialDevice
+		for i, device := range vm.SerialDevices {
+			if !slices.Contains(params.SerialPorts, i+1) {
+				remaining = append(remaining, device)
+			}
+		}
+		vm.SerialDevices = remaining
+	})
+	if err != nil {
+		s.logger.Warn("failed to record detached devices in cluster spec", slog.String("vm", params.Name), slog.String("error", err.Error()))
+	}
+
+	return nil
+}
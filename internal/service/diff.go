@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/libvirt"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+)
+
+// DiffCluster renders the domain every VM of a cluster spec would be defined with and compares
+// it with the domain currently defined, without changing anything. VMs that are not defined
+// report no drift. Secrets are left unresolved, and graphics passwords are removed from the
+// rendered XML, so no credential is returned.
+func (s *VMService) DiffCluster(ctx context.Context, cluster parameters.CreateCluster) ([]parameters.DomainDiff, error) {
+	vms, err := expandVirtualMachines(cluster.VirtualMachines)
+	if err != nil {
+		return nil, err
+	}
+	qualifyCreateVMs(ctx, vms)
+	cluster.Name = qualify(ctx, cluster.Name)
+	if err := s.applyPathLayout(vms, cluster.Name); err != nil {
+		return nil, err
+	}
+
+	diffs := make([]parameters.DomainDiff, 0, len(vms))
+	for _, vm := range vms {
+		if cluster.Name != "" {
+			vm.Labels = withClusterLabel(vm.Labels, cluster.Name)
+		}
+		if vm.Host == "" {
+			vm.Host = s.locateVirtualMachine(ctx, vm.Name)
+		}
+
+		diff := parameters.DomainDiff{VM: vm.Name, Host: vm.Host}
+		err := s.withHypervisor(ctx, vm.Host, func(hypervisor dependencies.HypervisorContext) error {
+			exists, err := s.libvirtManager.CheckVirtualMachineExistence(hypervisor, vm.Name)
+			if err != nil || !exists {
+				return err
+			}
+			diff.Defined = true
+
+			live, err := s.libvirtManager.GetVirtualMachineXML(hypervisor, vm.Name)
+			if err != nil {
+				return err
+			}
+			virtualMachineUUID, err := uuid.Parse(live.UUID)
+			if err != nil {
+				return fmt.Errorf("invalid UUID %q of defined domain: %w", live.UUID, err)
+			}
+
+			rendered, err := s.libvirtManager.RenderVirtualMachineXML(ctx, hypervisor, vm, virtualMachineUUID)
+			if err != nil {
+				return err
+			}
+			if rendered.Devices != nil {
+				for i := range rendered.Devices.Graphics {
+					if graphic := rendered.Devices.Graphics[i].VNC; graphic != nil {
+						graphic.Passwd = ""
+					}
+					if graphic := rendered.Devices.Graphics[i].Spice; graphic != nil {
+						graphic.Passwd = ""
+					}
+				}
+			}
+
+			diff.Drift = libvirt.CompareDomains(rendered, live)
+			if diff.RenderedXML, err = rendered.Marshal(); err != nil {
+				return fmt.Errorf("could not serialize rendered domain XML: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to diff VM %s: %w", vm.Name, err)
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/internal/store"
+	pkglibvirt "github.com/terabiome/homonculus/pkg/libvirt"
+)
+
+const (
+	EventVMCreated          = "vm.created"
+	EventVMCreateFailed     = "vm.create_failed"
+	EventVMCloned           = "vm.cloned"
+	EventVMCloneFailed      = "vm.clone_failed"
+	EventVMAdopted          = "vm.adopted"
+	EventVMAdoptFailed      = "vm.adopt_failed"
+	EventVMDeleted          = "vm.deleted"
+	EventVMDeleteFailed     = "vm.delete_failed"
+	EventVMStarted          = "vm.started"
+	EventVMStartFailed      = "vm.start_failed"
+	EventVMStopped          = "vm.stopped"
+	EventVMStopFailed       = "vm.stop_failed"
+	EventVMReset            = "vm.reset"
+	EventVMResetFailed      = "vm.reset_failed"
+	EventVMSnapshotted      = "vm.snapshotted"
+	EventVMSnapshotFailed   = "vm.snapshot_failed"
+	EventVMReady            = "vm.ready"
+	EventVMBooted           = "vm.booted"
+	EventVMMigrated         = "vm.migrated"
+	EventVMMigrateFailed    = "vm.migrate_failed"
+	EventVMConsoleAttached  = "vm.console_attached"
+	EventVMConsoleTicket    = "vm.console_ticket"
+	EventHostMaintenance    = "host.maintenance"
+	EventHostConnectionLost = "host.connection_lost"
+	EventHostReconnected    = "host.reconnected"
+	EventReconcilerDrift    = "reconciler.drift"
+	EventReconcilerRepaired = "reconciler.repaired"
+	EventReaperExpired      = "reaper.expired"
+	EventTemplatesStaged    = "templates.staged"
+	EventTemplatesSwitched  = "templates.switched"
+	EventTemplatesDiscarded = "templates.discarded"
+)
+
+// recordEvent appends an event to the event log. Failures are logged, never returned,
+// so the event log cannot fail the operation it describes.
+func (s *VMService) recordEvent(ctx context.Context, eventType, vm, host, message string, err error) {
+	now := time.Now().UTC()
+	event := parameters.Event{
+		ID:      now.Format("20060102T150405.000000000Z") + "-" + uuid.NewString()[:8],
+		Time:    now,
+		Type:    eventType,
+		VM:      vm,
+		Host:    host,
+		Actor:   callerFromContext(ctx),
+		Message: message,
+		TraceID: traceIDFromContext(ctx),
+		JobID:   jobFromContext(ctx),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	s.recordJobProgress(event)
+
+	if err := s.events.Append(event); err != nil {
+		s.logger.Warn("failed to record event", slog.String("type", eventType), slog.String("error", err.Error()))
+	}
+}
+
+// ListEvents returns the recorded events matching the filter, oldest first.
+func (s *VMService) ListEvents(filter parameters.EventFilter) ([]parameters.Event, error) {
+	events, err := store.Records[parameters.Event](s.events)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load events: %w", err)
+	}
+
+	var result []parameters.Event
+	for _, event := range events {
+		if filter.VM != "" && event.VM != filter.VM {
+			continue
+		}
+		if filter.TraceID != "" && event.TraceID != filter.TraceID {
+			continue
+		}
+		if filter.JobID != "" && event.JobID != filter.JobID {
+			continue
+		}
+		if filter.Type != "" && event.Type != filter.Type && !strings.HasPrefix(event.Type, filter.Type+".") {
+			continue
+		}
+		if !filter.Since.IsZero() && event.Time.Before(filter.Since) {
+			continue
+		}
+		result = append(result, event)
+	}
+
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[len(result)-filter.Limit:]
+	}
+	return result, nil
+}
+
+// EventWatcher records the domain lifecycle events libvirt reports on every hypervisor host.
+type EventWatcher struct {
+	vmService *VMService
+	retry     time.Duration
+	logger    *slog.Logger
+}
+
+// NewEventWatcher creates a new EventWatcher.
+func NewEventWatcher(vmService *VMService, logger *slog.Logger) *EventWatcher {
+	return &EventWatcher{
+		vmService: vmService,
+		retry:     30 * time.Second,
+		logger:    logger.With(slog.String("component", "event-watcher")),
+	}
+}
+
+// Run watches every host until ctx is cancelled, reconnecting after lost connections.
+func (w *EventWatcher) Run(ctx context.Context) {
+	w.logger.Info("event watcher started")
+
+	for _, host := range w.vmService.hosts.Names() {
+		go w.watch(ctx, host)
+	}
+
+	<-ctx.Done()
+	w.logger.Info("event watcher stopped")
+}
+
+// watch records the lifecycle events of one host.
+func (w *EventWatcher) watch(ctx context.Context, host string) {
+	connManager, ok := w.vmService.hosts.Get(host)
+	if !ok {
+		return
+	}
+
+	for {
+		err := connManager.WatchLifecycleEvents(ctx, func(event pkglibvirt.LifecycleEvent) {
+			w.vmService.recordEvent(context.Background(), "libvirt."+event.Event, event.Domain, host, event.Description, nil)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		w.logger.Warn("lost libvirt event stream",
+			slog.String("host", host),
+			slog.String("error", err.Error()),
+			slog.Duration("retry", w.retry),
+		)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.retry):
+		}
+	}
+}
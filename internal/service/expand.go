@@ -0,0 +1,105 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+
+	"github.com/terabiome/homonculus/internal/service/parameters"
+)
+
+// ErrInvalidCluster is returned for create requests whose VMs cannot be expanded into distinct VMs.
+var ErrInvalidCluster = errors.New("invalid cluster")
+
+// maxExpandedVMs caps how many VMs a single name_prefix entry expands into.
+const maxExpandedVMs = 100
+
+// expandVirtualMachines replaces every VM with a name prefix by count VMs named <prefix>-1..<prefix>-<count>.
+// The {name} and {index} placeholders in their disk, cloud-init ISO and NVRAM paths and hostname are filled in per VM.
+// Every resulting VM name, hostname and path is then validated and normalized.
+func expandVirtualMachines(vms []parameters.CreateVM) ([]parameters.CreateVM, error) {
+	var expanded []parameters.CreateVM
+	for _, vm := range vms {
+		if vm.NamePrefix == "" {
+			if vm.Count != 0 {
+				return nil, fmt.Errorf("%w: count requires name_prefix", ErrInvalidCluster)
+			}
+			expanded = append(expanded, vm)
+			continue
+		}
+
+		if vm.Name != "" {
+			return nil, fmt.Errorf("%w: name and name_prefix are mutually exclusive (%s, %s)", ErrInvalidCluster, vm.Name, vm.NamePrefix)
+		}
+		if vm.Count < 1 || vm.Count > maxExpandedVMs {
+			return nil, fmt.Errorf("%w: count of name_prefix %s must be between 1 and %d", ErrInvalidCluster, vm.NamePrefix, maxExpandedVMs)
+		}
+		for field, path := range map[string]string{
+			"disk_path":           vm.DiskPath,
+			"cloud_init_iso_path": vm.CloudInitISOPath,
+			"nvram_path":          vm.NVRAMPath,
+		} {
+			if vm.Count > 1 && path != "" && !strings.Contains(path, "{name}") && !strings.Contains(path, "{index}") {
+				return nil, fmt.Errorf("%w: %s of name_prefix %s must contain {name} or {index} to be unique per VM", ErrInvalidCluster, field, vm.NamePrefix)
+			}
+		}
+
+		for i := 1; i <= vm.Count; i++ {
+			instance := vm
+			instance.Name = vm.NamePrefix + "-" + strconv.Itoa(i)
+			instance.NamePrefix = ""
+			instance.Count = 0
+			instance.Labels = maps.Clone(vm.Labels)
+
+			placeholders := strings.NewReplacer("{name}", instance.Name, "{index}", strconv.Itoa(i))
+			instance.DiskPath = placeholders.Replace(vm.DiskPath)
+			instance.CloudInitISOPath = placeholders.Replace(vm.CloudInitISOPath)
+			instance.NVRAMPath = placeholders.Replace(vm.NVRAMPath)
+			instance.Hostname = placeholders.Replace(vm.Hostname)
+			expanded = append(expanded, instance)
+		}
+	}
+
+	for i := range expanded {
+		if err := sanitizeVirtualMachine(&expanded[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkDuplicates(expanded); err != nil {
+		return nil, err
+	}
+	return expanded, nil
+}
+
+// checkDuplicates rejects VMs sharing a name or a file they would write, since the later VM
+// would overwrite the disk, cloud-init ISO or NVRAM store of the earlier one.
+func checkDuplicates(vms []parameters.CreateVM) error {
+	names := make(map[string]bool, len(vms))
+	// owners maps every file path to the VM and field claiming it.
+	owners := make(map[string]string)
+	for _, vm := range vms {
+		if names[vm.Name] {
+			return fmt.Errorf("%w: duplicate VM name %s", ErrInvalidCluster, vm.Name)
+		}
+		names[vm.Name] = true
+
+		for _, file := range []struct{ field, path string }{
+			{"disk_path", vm.DiskPath},
+			{"cloud_init_iso_path", vm.CloudInitISOPath},
+			{"nvram_path", vm.NVRAMPath},
+		} {
+			if file.path == "" {
+				continue
+			}
+			owner := "VM " + vm.Name + " " + file.field
+			if previous, ok := owners[file.path]; ok {
+				return fmt.Errorf("%w: %s and %s are both %s", ErrInvalidCluster, previous, owner, file.path)
+			}
+			owners[file.path] = owner
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/internal/store"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// bucketExpiries records when VMs created with a ttl expire.
+const bucketExpiries = "expiries"
+
+const (
+	ExpiryActionDelete = "delete"
+	ExpiryActionStop   = "stop"
+)
+
+// Expiry records when a VM expires and what happens to it then.
+type Expiry struct {
+	VM        string    `json:"vm"`
+	Cluster   string    `json:"cluster,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Action    string    `json:"action"`
+}
+
+// recordExpiry persists the expiry of a VM created with a ttl. An existing expiry is kept,
+// so recreating the VM, e.g. by the reconciler, does not extend its lifetime.
+func (s *VMService) recordExpiry(clusterName string, vm parameters.CreateVM) {
+	if vm.TTL <= 0 {
+		return
+	}
+
+	var existing Expiry
+	if found, _ := s.store.Get(bucketExpiries, vm.Name, &existing); found {
+		return
+	}
+
+	action := vm.OnExpiry
+	if action == "" {
+		action = ExpiryActionDelete
+	}
+	expiry := Expiry{
+		VM:        vm.Name,
+		Cluster:   clusterName,
+		ExpiresAt: time.Now().UTC().Add(vm.TTL),
+		Action:    action,
+	}
+	if err := s.store.Put(bucketExpiries, vm.Name, expiry); err != nil {
+		s.logger.Warn("failed to record VM expiry", slog.String("vm", vm.Name), slog.String("error", err.Error()))
+	}
+}
+
+// forgetExpiry removes the expiry of a deleted VM.
+func (s *VMService) forgetExpiry(name string) {
+	if err := s.store.Delete(bucketExpiries, name); err != nil {
+		s.logger.Warn("failed to remove VM expiry", slog.String("vm", name), slog.String("error", err.Error()))
+	}
+}
+
+// ReapReport summarizes the actions taken by a single reaper pass.
+type ReapReport struct {
+	Deleted []string
+	Stopped []string
+	Failed  []string
+}
+
+// ReapExpired deletes or stops every VM whose ttl elapsed by now.
+// Stopped VMs keep no expiry; deleted VMs are also removed from their stored cluster spec.
+func (s *VMService) ReapExpired(ctx context.Context, now time.Time) (ReapReport, error) {
+	var report ReapReport
+
+	expiries, err := store.List[Expiry](s.store, bucketExpiries)
+	if err != nil {
+		return report, fmt.Errorf("failed to load VM expiries: %w", err)
+	}
+
+	for _, expiry := range expiries {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if now.Before(expiry.ExpiresAt) {
+			continue
+		}
+
+		var exists bool
+		err := s.withVirtualMachineHypervisor(ctx, expiry.VM, func(hypervisor dependencies.HypervisorContext) error {
+			var err error
+			exists, err = s.libvirtManager.CheckVirtualMachineExistence(hypervisor, expiry.VM)
+			return err
+		})
+		if err == nil && !exists {
+			s.logger.Info("expired VM no longer exists", slog.String("vm", expiry.VM))
+			s.forgetExpiry(expiry.VM)
+			continue
+		}
+
+		s.logger.Info("VM expired",
+			slog.String("vm", expiry.VM),
+			slog.String("cluster", expiry.Cluster),
+			slog.Time("expires_at", expiry.ExpiresAt),
+			slog.String("action", expiry.Action),
+		)
+
+		switch expiry.Action {
+		case ExpiryActionStop:
+			err = s.StopCluster(ctx, []parameters.StopVM{{Name: expiry.VM}}, 0)
+		default:
+			err = s.DeleteCluster(ctx, []parameters.DeleteVM{{Name: expiry.VM}})
+		}
+		if err != nil {
+			s.logger.Error("failed to reap expired VM", slog.String("vm", expiry.VM), slog.String("error", err.Error()))
+			s.recordExpired(ctx, expiry, "failed")
+			report.Failed = append(report.Failed, expiry.VM)
+			continue
+		}
+
+		s.recordExpired(ctx, expiry, "success")
+		if expiry.Action == ExpiryActionStop {
+			s.forgetExpiry(expiry.VM)
+			report.Stopped = append(report.Stopped, expiry.VM)
+		} else {
+			report.Deleted = append(report.Deleted, expiry.VM)
+		}
+	}
+
+	if len(report.Failed) > 0 {
+		return report, fmt.Errorf("failed to reap %d expired VM(s): %v", len(report.Failed), report.Failed)
+	}
+	return report, nil
+}
+
+// recordExpired counts a reaped VM and records it in the event log.
+func (s *VMService) recordExpired(ctx context.Context, expiry Expiry, status string) {
+	s.recordEvent(ctx, EventReaperExpired, expiry.VM, "", fmt.Sprintf("ttl elapsed at %s, action %s: %s",
+		expiry.ExpiresAt.Format(time.RFC3339), expiry.Action, status), nil)
+	if s.vmExpiredCounter != nil {
+		s.vmExpiredCounter.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("cluster", expiry.Cluster),
+			attribute.String("action", expiry.Action),
+			attribute.String("status", status),
+		))
+	}
+}
+
+// Reaper periodically runs VMService.ReapExpired in the background.
+type Reaper struct {
+	vmService *VMService
+	interval  time.Duration
+	logger    *slog.Logger
+}
+
+// NewReaper creates a new Reaper.
+func NewReaper(vmService *VMService, interval time.Duration, logger *slog.Logger) *Reaper {
+	return &Reaper{
+		vmService: vmService,
+		interval:  interval,
+		logger:    logger.With(slog.String("component", "reaper")),
+	}
+}
+
+// Run reaps immediately and then on every interval until ctx is cancelled.
+func (r *Reaper) Run(ctx context.Context) {
+	r.logger.Info("reaper started", slog.Duration("interval", r.interval))
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := r.vmService.ReapExpired(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			r.logger.Error("reaping failed", slog.String("error", err.Error()))
+		}
+		if len(report.Deleted) > 0 || len(report.Stopped) > 0 {
+			r.logger.Info("reaped expired VMs",
+				slog.Any("deleted", report.Deleted),
+				slog.Any("stopped", report.Stopped),
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			r.logger.Info("reaper stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
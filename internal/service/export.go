@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/libvirt"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/constants"
+	"github.com/terabiome/homonculus/pkg/secrets"
+)
+
+// ExportClusterSpec reconstructs the spec of a cluster from the VMs that currently belong to it,
+// so an environment that was changed by hand can be captured and created again elsewhere. Every
+// VM starts from its stored spec, or the spec extracted when it was adopted, and takes sizing,
+// network and firmware from its live domain and labels from its metadata. Placement and
+// ownership are left out, and names are relative to the namespace of ctx. Passwords that are not
+// secret:// references are masked and must be filled in again before the spec is applied. It
+// reports false if no VM belongs to the cluster.
+func (s *VMService) ExportClusterSpec(ctx context.Context, name string) (parameters.CreateCluster, bool, error) {
+	clusterName := qualify(ctx, name)
+
+	vmInfos, err := s.cachedListAllVirtualMachines(ctx)
+	if err != nil {
+		return parameters.CreateCluster{}, false, err
+	}
+	var members []parameters.VMInfo
+	for _, vmInfo := range managedVMInfos(vmInfos) {
+		if vmInfo.Labels[LabelCluster] == clusterName {
+			members = append(members, vmInfo)
+		}
+	}
+	if len(members) == 0 {
+		return parameters.CreateCluster{}, false, nil
+	}
+	slices.SortFunc(members, func(a, b parameters.VMInfo) int { return strings.Compare(a.Name, b.Name) })
+
+	stored, _, err := s.GetClusterSpec(clusterName)
+	if err != nil {
+		return parameters.CreateCluster{}, false, err
+	}
+	cluster := parameters.CreateCluster{
+		Name:    localName(ctx, clusterName),
+		SSHKeys: stored.SSHKeys,
+	}
+	for _, vmInfo := range members {
+		vm, err := s.exportVirtualMachineSpec(ctx, stored, vmInfo)
+		if err != nil {
+			return parameters.CreateCluster{}, false, fmt.Errorf("failed to export VM %s: %w", localName(ctx, vmInfo.Name), err)
+		}
+		cluster.VirtualMachines = append(cluster.VirtualMachines, vm)
+	}
+	return cluster, true, nil
+}
+
+// exportVirtualMachineSpec reconstructs the spec of a cluster member from its stored spec and
+// its live domain.
+func (s *VMService) exportVirtualMachineSpec(ctx context.Context, stored parameters.CreateCluster, vmInfo parameters.VMInfo) (parameters.CreateVM, error) {
+	vm := parameters.CreateVM{Name: vmInfo.Name}
+	found := false
+	for _, storedVM := range stored.VirtualMachines {
+		if storedVM.Name == vmInfo.Name {
+			vm, found = storedVM, true
+			break
+		}
+	}
+	if !found {
+		if _, err := s.store.Get(bucketAdopted, vmInfo.Name, &vm); err != nil {
+			s.logger.Warn("failed to load adopted VM spec", slog.String("vm", vmInfo.Name), slog.String("error", err.Error()))
+		}
+	}
+
+	err := s.withHypervisor(ctx, vmInfo.Host, func(hypervisor dependencies.HypervisorContext) error {
+		domainXML, err := s.libvirtManager.GetVirtualMachineXML(hypervisor, vmInfo.Name)
+		if err != nil {
+			return err
+		}
+		live := libvirt.SpecFromDomain(domainXML)
+		if live.VCPUCount != 0 {
+			vm.VCPUCount = live.VCPUCount
+		}
+		if live.MemoryMB != 0 {
+			vm.MemoryMB = live.MemoryMB
+		}
+		if live.BridgeNetworkInterface != "" {
+			vm.BridgeNetworkInterface = live.BridgeNetworkInterface
+		}
+		// Container domains have no firmware, machine type or TPM of their own.
+		if vm.MachineType != string(constants.MACHINE_TYPE_CONTAINER) {
+			vm.Firmware = live.Firmware
+			vm.SecureBoot = live.SecureBoot
+			vm.TPM = live.TPM
+			if live.MachineType != "" {
+				vm.MachineType = live.MachineType
+			}
+		}
+		if vm.DiskPath == "" {
+			vm.DiskPath = live.DiskPath
+		}
+		return nil
+	})
+	if err != nil {
+		return parameters.CreateVM{}, err
+	}
+
+	// The cluster and namespace labels are added again when the spec is applied.
+	vm.Labels = maps.Clone(vmInfo.Labels)
+	delete(vm.Labels, LabelCluster)
+	delete(vm.Labels, LabelNamespace)
+	if len(vm.Labels) == 0 {
+		vm.Labels = nil
+	}
+
+	vm.Name = localName(ctx, vm.Name)
+	if vm.Hostname == vm.Name {
+		vm.Hostname = ""
+	}
+	vm.NamePrefix = ""
+	vm.Count = 0
+	vm.Host = ""
+	vm.MACAddress = ""
+	vm.Network = nil
+	vm.Owner = ""
+	vm.Ownership = parameters.Ownership{}
+
+	vm = redactCloudInit(vm)
+	if vm.Graphics != nil && vm.Graphics.Password != "" && !secrets.IsRef(vm.Graphics.Password) {
+		graphics := *vm.Graphics
+		graphics.Password = secrets.Mask
+		vm.Graphics = &graphics
+	}
+	return vm, nil
+}
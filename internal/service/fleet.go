@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+
+	"github.com/terabiome/homonculus/internal/service/infrastructure/libvirt"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/internal/store"
+)
+
+// CreateFleet creates Count linked clones of the golden image of the template, named
+// <NamePrefix>-1..<NamePrefix>-<Count>. Every clone gets a qcow2 overlay backed directly by the
+// golden image, so the fleet shares one backing chain; a generated MAC address; the next free
+// address of the pool, if any; and its own cloud-init instance. The fleet is stored as a named
+// cluster. Fleets are created one at a time, so concurrent fleets never draw the same address.
+// It returns the VMs of the fleet.
+func (s *VMService) CreateFleet(ctx context.Context, fleet parameters.CreateFleet) ([]parameters.CreateVM, error) {
+	if fleet.Name == "" {
+		return nil, fmt.Errorf("%w: a fleet needs a name", ErrInvalidCluster)
+	}
+	if fleet.NamePrefix == "" {
+		return nil, fmt.Errorf("%w: fleet %s needs a name_prefix", ErrInvalidCluster, fleet.Name)
+	}
+	if fleet.Template.BaseImagePath == "" {
+		return nil, fmt.Errorf("%w: the template of fleet %s needs a base_image_path to clone", ErrInvalidCluster, fleet.Name)
+	}
+	if fleet.Template.Name != "" || fleet.Template.NamePrefix != "" {
+		return nil, fmt.Errorf("%w: the template of fleet %s must not be named; VMs are named after name_prefix", ErrInvalidCluster, fleet.Name)
+	}
+
+	if fleet.Count > 1 && fleet.Template.Network != nil && fleet.Template.Network.IPv4Address != "" {
+		return nil, fmt.Errorf("%w: the VMs of fleet %s cannot share the static address of the template; use an address pool", ErrInvalidCluster, fleet.Name)
+	}
+
+	template := fleet.Template
+	template.NamePrefix = fleet.NamePrefix
+	template.Count = fleet.Count
+	template.MACAddress = ""
+	vms, err := expandVirtualMachines([]parameters.CreateVM{template})
+	if err != nil {
+		return nil, err
+	}
+	qualifyCreateVMs(ctx, vms)
+	fleet.Name = qualify(ctx, fleet.Name)
+	if err := s.applyPathLayout(vms, fleet.Name); err != nil {
+		return nil, err
+	}
+
+	s.fleetMu.Lock()
+	defer s.fleetMu.Unlock()
+
+	usedMACs, usedAddresses, err := s.claimedNetworkIdentities()
+	if err != nil {
+		return nil, err
+	}
+
+	var nextAddress func() (netip.Prefix, error)
+	if fleet.AddressPool != nil {
+		if nextAddress, err = addressAllocator(*fleet.AddressPool, usedAddresses); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range vms {
+		for vms[i].MACAddress == "" || usedMACs[vms[i].MACAddress] {
+			if vms[i].MACAddress, err = libvirt.RandomMAC(); err != nil {
+				return nil, err
+			}
+		}
+		usedMACs[vms[i].MACAddress] = true
+
+		if nextAddress != nil {
+			address, err := nextAddress()
+			if err != nil {
+				return nil, err
+			}
+			vms[i].Network = &parameters.NetworkConfig{
+				IPv4Address: address.String(),
+				IPv4Gateway: fleet.AddressPool.Gateway,
+				Nameservers: fleet.AddressPool.Nameservers,
+			}
+		}
+	}
+
+	s.logger.Info("creating fleet",
+		slog.String("fleet", fleet.Name),
+		slog.Int("count", len(vms)),
+		slog.String("golden_image", fleet.Template.BaseImagePath),
+	)
+
+	err = s.CreateCluster(ctx, parameters.CreateCluster{
+		Name:            fleet.Name,
+		SSHKeys:         fleet.SSHKeys,
+		VirtualMachines: vms,
+	})
+	for i := range vms {
+		vms[i].Name = localName(ctx, vms[i].Name)
+	}
+	return vms, err
+}
+
+// claimedNetworkIdentities returns the MAC and IPv4 addresses of the VMs of every stored cluster,
+// together with the addresses allocated from IP pools.
+func (s *VMService) claimedNetworkIdentities() (map[string]bool, map[netip.Addr]bool, error) {
+	clusters, err := store.List[parameters.CreateCluster](s.store, bucketClusters)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load cluster specs: %w", err)
+	}
+
+	macs := make(map[string]bool)
+	addresses := make(map[netip.Addr]bool)
+	for _, cluster := range clusters {
+		for _, vm := range cluster.VirtualMachines {
+			if vm.MACAddress != "" {
+				macs[strings.ToLower(vm.MACAddress)] = true
+			}
+			if vm.Network == nil || vm.Network.IPv4Address == "" {
+				continue
+			}
+			if prefix, err := netip.ParsePrefix(vm.Network.IPv4Address); err == nil {
+				addresses[prefix.Addr()] = true
+			}
+		}
+	}
+
+	// Addresses allocated from IP pools count from the moment they are allocated.
+	records, err := store.List[addressRecord](s.store, bucketAddresses)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load address allocations: %w", err)
+	}
+	for _, record := range records {
+		if prefix, err := netip.ParsePrefix(record.Address); err == nil {
+			addresses[prefix.Addr()] = true
+		}
+	}
+	return macs, addresses, nil
+}
+
+// addressAllocator returns a function handing out the addresses of a pool in order, skipping
+// the network and broadcast addresses, the gateway and the used addresses.
+func addressAllocator(pool parameters.AddressPool, used map[netip.Addr]bool) (func() (netip.Prefix, error), error) {
+	network, err := netip.ParsePrefix(pool.Network)
+	if err != nil || !network.Addr().Is4() {
+		return nil, fmt.Errorf("%w: address pool network must be IPv4 in CIDR notation, got %q", ErrInvalidCluster, pool.Network)
+	}
+	network = network.Masked()
+
+	base := binary.BigEndian.Uint32(network.Addr().AsSlice())
+	size := uint32(1) << (32 - network.Bits())
+	first, last := base+1, base+size-2
+	if size <= 2 {
+		// /31 and /32 networks have no network and broadcast addresses.
+		first, last = base, base+size-1
+	}
+
+	parse := func(field, value string, fallback uint32) (uint32, error) {
+		if value == "" {
+			return fallback, nil
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil || !network.Contains(addr) {
+			return 0, fmt.Errorf("%w: address pool %s %q is not an address of %s", ErrInvalidCluster, field, value, network)
+		}
+		return binary.BigEndian.Uint32(addr.AsSlice()), nil
+	}
+	start, err := parse("start", pool.Start, first)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parse("end", pool.End, last)
+	if err != nil {
+		return nil, err
+	}
+	if start > end {
+		return nil, fmt.Errorf("%w: address pool start %s is after its end %s", ErrInvalidCluster, pool.Start, pool.End)
+	}
+
+	var gateway netip.Addr
+	if pool.Gateway != "" {
+		if gateway, err = netip.ParseAddr(pool.Gateway); err != nil || !gateway.Is4() {
+			return nil, fmt.Errorf("%w: address pool gateway must be an IPv4 address, got %q", ErrInvalidCluster, pool.Gateway)
+		}
+	}
+	for _, nameserver := range pool.Nameservers {
+		if _, err := netip.ParseAddr(nameserver); err != nil {
+			return nil, fmt.Errorf("%w: invalid address pool nameserver %q", ErrInvalidCluster, nameserver)
+		}
+	}
+
+	next := uint64(start)
+	return func() (netip.Prefix, error) {
+		for ; next <= uint64(end); next++ {
+			addr := netip.AddrFrom4([4]byte(binary.BigEndian.AppendUint32(nil, uint32(next))))
+			if addr == gateway || used[addr] {
+				continue
+			}
+			next++
+			return netip.PrefixFrom(addr, network.Bits()), nil
+		}
+		return netip.Prefix{}, fmt.Errorf("%w: address pool %s is exhausted", ErrInvalidCluster, pool.Network)
+	}, nil
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/internal/store"
+)
+
+// bucketFailedVMs records VMs whose creation failed after their domain was defined and
+// whose rollback left the domain or its files behind, keyed by VM name.
+const bucketFailedVMs = "failed_vms"
+
+// FailedVM is a VM left half-created by a failed creation.
+type FailedVM struct {
+	VM        string    `json:"vm"`
+	Host      string    `json:"host"`
+	DiskPath  string    `json:"disk_path,omitempty"`
+	ISOPath   string    `json:"iso_path,omitempty"`
+	Container bool      `json:"container,omitempty"`
+	Error     string    `json:"error"`
+	FailedAt  time.Time `json:"failed_at"`
+}
+
+// recordFailedVM tracks a VM whose creation failed after its domain was defined, when
+// the rollback kept or failed to remove what was created, so garbage collection can remove it.
+func (s *VMService) recordFailedVM(hypervisor dependencies.HypervisorContext, vm parameters.CreateVM, cause error) {
+	failed := FailedVM{
+		VM:        vm.Name,
+		Host:      hypervisor.Host,
+		DiskPath:  vm.DiskPath,
+		ISOPath:   vm.CloudInitISOPath,
+		Container: isContainer(vm),
+		Error:     cause.Error(),
+		FailedAt:  time.Now().UTC(),
+	}
+	if err := s.store.Put(bucketFailedVMs, vm.Name, failed); err != nil {
+		s.logger.Warn("failed to record failed VM", slog.String("vm", vm.Name), slog.String("error", err.Error()))
+	}
+}
+
+// forgetFailedVM stops tracking a VM that was created successfully or deleted.
+func (s *VMService) forgetFailedVM(name string) {
+	if err := s.store.Delete(bucketFailedVMs, name); err != nil {
+		s.logger.Warn("failed to remove failed VM record", slog.String("vm", name), slog.String("error", err.Error()))
+	}
+}
+
+// ListFailedVMs returns the VMs left half-created by failed creations.
+func (s *VMService) ListFailedVMs() ([]FailedVM, error) {
+	failed, err := store.List[FailedVM](s.store, bucketFailedVMs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load failed VMs: %w", err)
+	}
+	return failed, nil
+}
+
+// GCReport summarizes a garbage collection pass over failed VMs.
+type GCReport struct {
+	Collected []string
+	Adopted   []string
+	Failed    []string
+}
+
+// CollectFailedVMs removes the domains, disks and cloud-init ISOs of VMs whose creation
+// failed before failedBefore. A failed VM whose domain is still defined is deleted like any
+// VM; otherwise the files it recorded are removed. A failed VM that has been started since
+// is adopted: it is no longer tracked and left alone.
+func (s *VMService) CollectFailedVMs(ctx context.Context, failedBefore time.Time) (GCReport, error) {
+	var report GCReport
+
+	failedVMs, err := s.ListFailedVMs()
+	if err != nil {
+		return report, err
+	}
+
+	for _, failed := range failedVMs {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if !failed.FailedAt.Before(failedBefore) {
+			continue
+		}
+
+		adopted, err := s.collectFailedVM(ctx, failed)
+		if err != nil {
+			s.logger.Error("failed to collect failed VM",
+				slog.String("vm", failed.VM),
+				slog.String("host", failed.Host),
+				slog.String("error", err.Error()),
+			)
+			report.Failed = append(report.Failed, failed.VM)
+			continue
+		}
+
+		s.forgetFailedVM(failed.VM)
+		if adopted {
+			s.logger.Info("failed VM has been started since, no longer tracking it", slog.String("vm", failed.VM))
+			report.Adopted = append(report.Adopted, failed.VM)
+		} else {
+			report.Collected = append(report.Collected, failed.VM)
+		}
+	}
+
+	if len(report.Failed) > 0 {
+		return report, fmt.Errorf("failed to collect %d failed VM(s): %v", len(report.Failed), report.Failed)
+	}
+	return report, nil
+}
+
+// collectFailedVM removes one failed VM and reports whether it was adopted instead.
+func (s *VMService) collectFailedVM(ctx context.Context, failed FailedVM) (bool, error) {
+	var exists, running bool
+	err := s.withHypervisor(ctx, failed.Host, func(hypervisor dependencies.HypervisorContext) error {
+		var err error
+		if exists, err = s.libvirtManager.CheckVirtualMachineExistence(hypervisor, failed.VM); err != nil || !exists {
+			return err
+		}
+		info, err := s.libvirtManager.GetVirtualMachineInfo(ctx, hypervisor, parameters.QueryVM{Name: failed.VM})
+		if err != nil {
+			return err
+		}
+		running = info.State == "running"
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if running {
+		return true, nil
+	}
+
+	s.logger.Info("collecting failed VM",
+		slog.String("vm", failed.VM),
+		slog.String("host", failed.Host),
+		slog.Time("failed_at", failed.FailedAt),
+	)
+
+	if exists {
+		return false, s.DeleteCluster(ctx, []parameters.DeleteVM{{Name: failed.VM}})
+	}
+
+	// The domain is gone, so the recorded files belong to no VM.
+	return false, s.withHypervisor(ctx, failed.Host, func(hypervisor dependencies.HypervisorContext) error {
+		if failed.ISOPath != "" {
+			if err := s.paths.Check(failed.ISOPath); err != nil {
+				return fmt.Errorf("cloud-init ISO %s: %w", failed.ISOPath, err)
+			}
+			if err := s.removeFileStep(hypervisor, failed.ISOPath)(ctx); err != nil {
+				return err
+			}
+		}
+		if failed.DiskPath != "" {
+			if err := s.removeOrphanedDiskStep(hypervisor, failed.VM, failed.DiskPath, failed.Container)(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GarbageCollector periodically runs VMService.CollectFailedVMs in the background.
+type GarbageCollector struct {
+	vmService   *VMService
+	interval    time.Duration
+	gracePeriod time.Duration
+	logger      *slog.Logger
+}
+
+// NewGarbageCollector creates a new GarbageCollector collecting VMs that failed more than
+// gracePeriod ago, which leaves time to inspect them.
+func NewGarbageCollector(vmService *VMService, interval, gracePeriod time.Duration, logger *slog.Logger) *GarbageCollector {
+	return &GarbageCollector{
+		vmService:   vmService,
+		interval:    interval,
+		gracePeriod: gracePeriod,
+		logger:      logger.With(slog.String("component", "gc")),
+	}
+}
+
+// Run collects immediately and then on every interval until ctx is cancelled.
+func (g *GarbageCollector) Run(ctx context.Context) {
+	g.logger.Info("garbage collector started",
+		slog.Duration("interval", g.interval),
+		slog.Duration("grace_period", g.gracePeriod),
+	)
+
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := g.vmService.CollectFailedVMs(ctx, time.Now().Add(-g.gracePeriod))
+		if err != nil && ctx.Err() == nil {
+			g.logger.Error("garbage collection failed", slog.String("error", err.Error()))
+		}
+		if len(report.Collected) > 0 || len(report.Adopted) > 0 {
+			g.logger.Info("garbage collection applied changes",
+				slog.Any("collected", report.Collected),
+				slog.Any("adopted", report.Adopted),
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			g.logger.Info("garbage collector stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
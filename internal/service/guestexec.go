@@ -0,0 +1,94 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/executor"
+)
+
+// GuestExecutor runs commands inside a VM through its QEMU guest agent, over the hypervisor
+// connection, for VMs whose network is not reachable from homonculus. Output is returned once a
+// command exited rather than streamed.
+type GuestExecutor struct {
+	service *VMService
+	vm      string
+}
+
+// GuestExecutor returns an executor running commands inside the named VM through its guest agent.
+func (s *VMService) GuestExecutor(name string) *GuestExecutor {
+	return &GuestExecutor{service: s, vm: name}
+}
+
+// Name identifies the executor in logs.
+func (e *GuestExecutor) Name() string {
+	return "guest-agent-" + e.vm
+}
+
+// Execute runs a command like ExecuteWithInput, without input.
+func (e *GuestExecutor) Execute(ctx context.Context, stdout, stderr io.Writer, command string, args ...string) (int, error) {
+	return e.ExecuteWithInput(ctx, nil, stdout, stderr, command, args...)
+}
+
+// ExecuteWithInput runs a shell command inside the VM as root, feeding it stdin. Arguments are
+// quoted for the guest shell. A non-zero exit code is returned along with an error.
+func (e *GuestExecutor) ExecuteWithInput(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, command string, args ...string) (int, error) {
+	params := parameters.GuestExec{Name: e.vm, Command: command}
+	if len(args) > 0 {
+		quoted := make([]string, len(args))
+		for i, arg := range args {
+			quoted[i] = executor.ShellQuote(arg)
+		}
+		params.Command += " " + strings.Join(quoted, " ")
+	}
+	if stdin != nil {
+		input, err := io.ReadAll(stdin)
+		if err != nil {
+			return -1, fmt.Errorf("failed to read command input: %w", err)
+		}
+		params.Stdin = input
+	}
+
+	e.service.logger.Debug("executing command via guest agent", slog.String("vm", e.vm), slog.String("cmd", params.Command))
+
+	var result parameters.GuestExecResult
+	err := e.service.withVirtualMachineHypervisor(ctx, e.vm, func(hypervisor dependencies.HypervisorContext) error {
+		var err error
+		result, err = e.service.libvirtManager.GuestExec(ctx, hypervisor, params)
+		return err
+	})
+	if err != nil {
+		return -1, fmt.Errorf("command execution failed: %w", err)
+	}
+
+	if stdout != nil {
+		if _, err := io.Copy(stdout, bytes.NewReader(result.Stdout)); err != nil {
+			return result.ExitCode, fmt.Errorf("failed to write command output: %w", err)
+		}
+	}
+	if stderr != nil {
+		if _, err := io.Copy(stderr, bytes.NewReader(result.Stderr)); err != nil {
+			return result.ExitCode, fmt.Errorf("failed to write command error output: %w", err)
+		}
+	}
+	if result.ExitCode != 0 {
+		e.service.logger.Warn("guest agent command failed",
+			slog.String("vm", e.vm),
+			slog.String("cmd", params.Command),
+			slog.Int("exit_code", result.ExitCode),
+		)
+		return result.ExitCode, fmt.Errorf("command exited with code %d", result.ExitCode)
+	}
+	return 0, nil
+}
+
+// Close releases nothing: every command looks up the hypervisor connection of the VM anew.
+func (e *GuestExecutor) Close() error {
+	return nil
+}
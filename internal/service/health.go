@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/libvirt"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// vmStates are the states homonculus_vm_state reports for every VM, so alerts can match a 0.
+var vmStates = []string{"running", "blocked", "paused", "shutdown", "shutoff", "crashed", "pmsuspended"}
+
+// cloudInitStatuses are the statuses homonculus_vm_cloudinit reports for every VM probed.
+var cloudInitStatuses = []string{libvirt.CloudInitDone, libvirt.CloudInitFailed, libvirt.CloudInitPending, libvirt.CloudInitUnknown}
+
+// vmHealth is the last observed health of a VM.
+type vmHealth struct {
+	Name            string
+	Host            string
+	State           string
+	ExpectedRunning bool
+	CloudInit       string // empty if never probed or the VM has no cloud-init ISO
+}
+
+// healthSnapshot holds the health observed by the last health check, read by the metric callback.
+type healthSnapshot struct {
+	mu  sync.RWMutex
+	vms []vmHealth
+	// cloudInit caches final cloud-init statuses by VM UUID, so finished guests are not probed again.
+	cloudInit map[string]string
+	// unreachable holds the error of the last connection check of every host it failed for.
+	unreachable map[string]string
+}
+
+// registerHealthMetrics exports the health snapshot as gauges.
+func (s *VMService) registerHealthMetrics(meter metric.Meter) error {
+	vmState, err := meter.Int64ObservableGauge(
+		"homonculus.vm.state",
+		metric.WithDescription("1 for the current state of a VM, 0 for every other state"),
+	)
+	if err != nil {
+		return err
+	}
+	expectedRunning, err := meter.Int64ObservableGauge(
+		"homonculus.vm.expected_running",
+		metric.WithDescription("1 if the stored cluster spec marks a VM keep_running"),
+	)
+	if err != nil {
+		return err
+	}
+	cloudInit, err := meter.Int64ObservableGauge(
+		"homonculus.vm.cloudinit",
+		metric.WithDescription("1 for the cloud-init status of a running VM, 0 for every other status"),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, observer metric.Observer) error {
+		s.health.mu.RLock()
+		defer s.health.mu.RUnlock()
+
+		for _, vm := range s.health.vms {
+			vmAttributes := []attribute.KeyValue{attribute.String("vm", vm.Name), attribute.String("host", vm.Host)}
+			for _, state := range vmStates {
+				observer.ObserveInt64(vmState, boolValue(vm.State == state),
+					metric.WithAttributes(append(vmAttributes, attribute.String("state", state))...))
+			}
+			observer.ObserveInt64(expectedRunning, boolValue(vm.ExpectedRunning), metric.WithAttributes(vmAttributes...))
+			if vm.CloudInit == "" {
+				continue
+			}
+			for _, status := range cloudInitStatuses {
+				observer.ObserveInt64(cloudInit, boolValue(vm.CloudInit == status),
+					metric.WithAttributes(append(vmAttributes, attribute.String("status", status))...))
+			}
+		}
+		return nil
+	}, vmState, expectedRunning, cloudInit)
+	return err
+}
+
+func boolValue(value bool) int64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// CheckHealth refreshes the health snapshot: the state of every VM, whether its spec expects it
+// to run, and the cloud-init status of running VMs that have not finished provisioning yet.
+// It also evaluates the readiness probes of running VMs that are not ready yet.
+func (s *VMService) CheckHealth(ctx context.Context) error {
+	vmInfos, err := s.listAllVirtualMachines(ctx)
+	if err != nil {
+		return err
+	}
+
+	expected := make(map[string]bool)
+	clusters, err := s.ListClusterSpecs()
+	if err != nil {
+		return err
+	}
+	for _, cluster := range clusters {
+		for _, vm := range cluster.VirtualMachines {
+			expected[vm.Name] = vm.KeepRunning
+		}
+	}
+
+	s.health.mu.RLock()
+	previous := s.health.cloudInit
+	s.health.mu.RUnlock()
+
+	// Only VMs that still exist are carried over, so deleted VMs drop out of the cache.
+	finished := make(map[string]string)
+	vms := make([]vmHealth, 0, len(vmInfos))
+	for _, vmInfo := range vmInfos {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		health := vmHealth{
+			Name:            vmInfo.Name,
+			Host:            vmInfo.Host,
+			State:           vmInfo.State,
+			ExpectedRunning: expected[vmInfo.Name],
+			CloudInit:       previous[vmInfo.UUID],
+		}
+		if health.CloudInit == "" && vmInfo.State == "running" {
+			health.CloudInit = s.probeCloudInit(ctx, vmInfo)
+		}
+		if health.CloudInit == libvirt.CloudInitDone || health.CloudInit == libvirt.CloudInitFailed {
+			finished[vmInfo.UUID] = health.CloudInit
+		}
+		vms = append(vms, health)
+	}
+
+	s.health.mu.Lock()
+	s.health.vms = vms
+	s.health.cloudInit = finished
+	s.health.mu.Unlock()
+
+	return s.checkReadiness(ctx, vmInfos)
+}
+
+// probeCloudInit asks the guest agent of a running VM for its cloud-init status.
+func (s *VMService) probeCloudInit(ctx context.Context, vmInfo parameters.VMInfo) string {
+	status := libvirt.CloudInitUnknown
+	err := s.withHypervisor(ctx, vmInfo.Host, func(hypervisor dependencies.HypervisorContext) error {
+		var err error
+		status, err = s.libvirtManager.CloudInitStatus(hypervisor, vmInfo.Name)
+		return err
+	})
+	if err != nil {
+		s.logger.Debug("failed to probe cloud-init status", slog.String("vm", vmInfo.Name), slog.String("error", err.Error()))
+	}
+	return status
+}
+
+// CheckConnections checks the idle libvirt connections of every host, replacing dead ones, and
+// records an event when a host becomes unreachable and when its connection is restored.
+func (s *VMService) CheckConnections(ctx context.Context) {
+	for _, host := range s.hosts.Names() {
+		connManager, ok := s.hosts.Get(host)
+		if !ok {
+			continue
+		}
+		check, err := connManager.CheckConnections()
+
+		s.health.mu.Lock()
+		_, wasUnreachable := s.health.unreachable[host]
+		if err != nil {
+			if s.health.unreachable == nil {
+				s.health.unreachable = make(map[string]string)
+			}
+			s.health.unreachable[host] = err.Error()
+		} else {
+			delete(s.health.unreachable, host)
+		}
+		s.health.mu.Unlock()
+
+		switch {
+		case err != nil && !wasUnreachable:
+			s.logger.Error("lost libvirt connection to host", slog.String("host", host), slog.String("error", err.Error()))
+			s.recordEvent(ctx, EventHostConnectionLost, "", host, "libvirt connection lost", err)
+		case err != nil:
+			s.logger.Debug("host still unreachable", slog.String("host", host), slog.String("error", err.Error()))
+		case wasUnreachable:
+			s.logger.Info("restored libvirt connection to host", slog.String("host", host))
+			s.recordEvent(ctx, EventHostReconnected, "", host, "libvirt connection restored", nil)
+		case check.Dropped > 0:
+			s.logger.Warn("replaced dead libvirt connections", slog.String("host", host), slog.Int("dropped", check.Dropped))
+			s.recordEvent(ctx, EventHostReconnected, "", host, fmt.Sprintf("replaced %d dead libvirt connection(s)", check.Dropped), nil)
+		}
+	}
+}
+
+// HostConnections reports for every host whether its libvirt connection worked at the last
+// connection check. Hosts are reachable until a check failed.
+func (s *VMService) HostConnections() []parameters.HostConnection {
+	s.health.mu.RLock()
+	defer s.health.mu.RUnlock()
+
+	hosts := s.hosts.Names()
+	connections := make([]parameters.HostConnection, len(hosts))
+	for i, host := range hosts {
+		reason, unreachable := s.health.unreachable[host]
+		connections[i] = parameters.HostConnection{Host: host, Reachable: !unreachable, Error: reason}
+	}
+	return connections
+}
+
+// HealthMonitor periodically runs VMService.CheckConnections and VMService.CheckHealth in the background.
+type HealthMonitor struct {
+	vmService *VMService
+	interval  time.Duration
+	logger    *slog.Logger
+}
+
+// NewHealthMonitor creates a new HealthMonitor.
+func NewHealthMonitor(vmService *VMService, interval time.Duration, logger *slog.Logger) *HealthMonitor {
+	return &HealthMonitor{
+		vmService: vmService,
+		interval:  interval,
+		logger:    logger.With(slog.String("component", "health-monitor")),
+	}
+}
+
+// Run checks health immediately and then on every interval until ctx is cancelled.
+func (m *HealthMonitor) Run(ctx context.Context) {
+	m.logger.Info("health monitor started", slog.Duration("interval", m.interval))
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		// Connections go first, so the health check does not run into a dead one.
+		m.vmService.CheckConnections(ctx)
+		if err := m.vmService.CheckHealth(ctx); err != nil && ctx.Err() == nil {
+			m.logger.Error("health check failed", slog.String("error", err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			m.logger.Info("health monitor stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/errdefs"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+)
+
+// acquireHypervisor takes a pooled connection to the named host. The returned func releases it.
+func (s *VMService) acquireHypervisor(ctx context.Context, host string) (dependencies.HypervisorContext, func(), error) {
+	connManager, ok := s.hosts.Get(host)
+	if !ok {
+		return dependencies.HypervisorContext{}, nil, fmt.Errorf("unknown hypervisor host: %s", host)
+	}
+
+	conn, exec, release, err := connManager.GetHypervisor(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return dependencies.HypervisorContext{}, nil, fmt.Errorf("failed to get hypervisor connection to %s: %w", host, err)
+		}
+		return dependencies.HypervisorContext{}, nil, fmt.Errorf("%w: failed to get connection to %s: %w", errdefs.ErrHypervisorUnavailable, host, err)
+	}
+
+	hypervisor := dependencies.HypervisorContext{
+		Host:     host,
+		URI:      connManager.GetURI(),
+		Conn:     conn,
+		Executor: exec,
+	}
+	if conn != nil {
+		hypervisor.Reconnect = func() error { return connManager.Reconnect(conn) }
+	}
+	return hypervisor, release, nil
+}
+
+// withHypervisor runs fn with a pooled connection to the named host.
+func (s *VMService) withHypervisor(ctx context.Context, host string, fn func(hypervisor dependencies.HypervisorContext) error) error {
+	hypervisor, release, err := s.acquireHypervisor(ctx, host)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return fn(hypervisor)
+}
+
+// withVirtualMachineHypervisor runs fn against the host the named VM lives on.
+func (s *VMService) withVirtualMachineHypervisor(ctx context.Context, name string, fn func(hypervisor dependencies.HypervisorContext) error) error {
+	return s.withHypervisor(ctx, s.locateVirtualMachine(ctx, name), fn)
+}
+
+// locateVirtualMachine returns the host a VM lives on: its recorded placement if any,
+// otherwise the first host where a domain of that name exists, otherwise the default host.
+func (s *VMService) locateVirtualMachine(ctx context.Context, name string) string {
+	var placement Placement
+	found, err := s.store.Get(bucketPlacements, name, &placement)
+	if err != nil {
+		s.logger.Warn("failed to read VM placement", slog.String("vm", name), slog.String("error", err.Error()))
+	}
+	if found {
+		if _, ok := s.hosts.Get(placement.Host); ok {
+			return placement.Host
+		}
+		s.logger.Warn("VM placed on unknown host, falling back to lookup",
+			slog.String("vm", name),
+			slog.String("host", placement.Host),
+		)
+	}
+
+	if s.hosts.Len() > 1 {
+		for _, host := range s.hosts.Names() {
+			var exists bool
+			err := s.withHypervisor(ctx, host, func(hypervisor dependencies.HypervisorContext) error {
+				var err error
+				exists, err = s.libvirtManager.CheckVirtualMachineExistence(hypervisor, name)
+				return err
+			})
+			if err == nil && exists {
+				return host
+			}
+		}
+	}
+
+	return s.hosts.Default()
+}
+
+// listAllVirtualMachines lists the VMs of every configured host.
+// Hosts that cannot be listed are logged and skipped.
+func (s *VMService) listAllVirtualMachines(ctx context.Context) ([]parameters.VMInfo, error) {
+	var vmInfos []parameters.VMInfo
+	var lastErr error
+
+	for _, host := range s.hosts.Names() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		err := s.withHypervisor(ctx, host, func(hypervisor dependencies.HypervisorContext) error {
+			hostVMInfos, err := s.libvirtManager.ListAllVirtualMachines(ctx, hypervisor)
+			if err != nil {
+				return err
+			}
+			for i := range hostVMInfos {
+				hostVMInfos[i].Host = host
+			}
+			vmInfos = append(vmInfos, hostVMInfos...)
+			return nil
+		})
+		if err != nil {
+			s.logger.Error("failed to list VMs of host", slog.String("host", host), slog.String("error", err.Error()))
+			lastErr = err
+		}
+	}
+
+	if lastErr != nil && len(vmInfos) == 0 {
+		return nil, lastErr
+	}
+	return vmInfos, nil
+}
+
+// ListHostCapacities reports the resource inventory of every configured host.
+// Hosts that cannot be queried are logged and skipped.
+func (s *VMService) ListHostCapacities(ctx context.Context) ([]parameters.HostCapacity, error) {
+	var capacities []parameters.HostCapacity
+	var lastErr error
+
+	for _, host := range s.hosts.Names() {
+		err := s.withHypervisor(ctx, host, func(hypervisor dependencies.HypervisorContext) error {
+			capacity, err := s.libvirtManager.GetHostCapacity(ctx, hypervisor)
+			if err != nil {
+				return err
+			}
+			capacities = append(capacities, capacity)
+			return nil
+		})
+		if err != nil {
+			s.logger.Error("failed to get capacity of host", slog.String("host", host), slog.String("error", err.Error()))
+			lastErr = err
+		}
+	}
+
+	if lastErr != nil && len(capacities) == 0 {
+		return nil, lastErr
+	}
+	return capacities, nil
+}
@@ -2,65 +2,82 @@ package cloudinit
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/terabiome/homonculus/internal/dependencies"
 	"github.com/terabiome/homonculus/internal/service/parameters"
 
 	"github.com/terabiome/homonculus/pkg/constants"
+	"github.com/terabiome/homonculus/pkg/executor/fileops"
 	"github.com/terabiome/homonculus/pkg/executor/mkisofs"
 	"github.com/terabiome/homonculus/pkg/templator"
+	"github.com/terabiome/homonculus/pkg/workspace"
+)
+
+// NocloudSeedDir is where cloud-init looks for NoCloud files inside a root filesystem.
+const NocloudSeedDir = "var/lib/cloud/seed/nocloud"
+
+const (
+	// isoCacheDir is the directory next to cloud-init ISOs holding cached ISOs by content
+	// hash. On the same filesystem as the ISOs, they can be hard links to the cached ones.
+	isoCacheDir = ".iso-cache"
+	// isoCacheRetention is how long a cached ISO no VM links to anymore is kept for reuse.
+	isoCacheRetention = 24 * time.Hour
 )
 
 // Manager manages cloud-init ISO operations.
 type Manager struct {
-	engine *templator.Engine
-	logger *slog.Logger
+	engine     *templator.Engine
+	workspaces *workspace.Root
+	isoCache   bool
+	logger     *slog.Logger
 }
 
-// NewManager creates a new cloud-init manager.
-func NewManager(engine *templator.Engine, logger *slog.Logger) *Manager {
+// NewManager creates a new cloud-init manager, which stages the documents of every VM in a
+// workspace of its own. With isoCache, VMs whose cloud-init documents are identical share
+// one ISO instead of running mkisofs for every VM.
+func NewManager(engine *templator.Engine, workspaces *workspace.Root, isoCache bool, logger *slog.Logger) *Manager {
 	return &Manager{
-		engine: engine,
-		logger: logger.With(slog.String("component", "cloudinit")),
+		engine:     engine,
+		workspaces: workspaces,
+		isoCache:   isoCache,
+		logger:     logger.With(slog.String("component", "cloudinit")),
 	}
 }
 
 // CreateISO creates a cloud-init ISO from templates.
 func (m *Manager) CreateISO(ctx context.Context, hypervisor dependencies.HypervisorContext, vmParams parameters.CreateVM, instanceID uuid.UUID) error {
-	tempDir, err := os.MkdirTemp("", fmt.Sprintf("cloud-init-%s-", vmParams.Name))
+	staging, err := m.workspaces.New("cloud-init-" + vmParams.Name)
 	if err != nil {
-		return fmt.Errorf("failed to create temp dir for cloud-init: %w", err)
+		return err
 	}
+	defer staging.Close()
 
-	userDataPath := filepath.Join(tempDir, "user-data")
-	if err := m.renderUserData(userDataPath, vmParams); err != nil {
-		return fmt.Errorf("failed to render user-data: %w", err)
+	isoFiles, err := m.renderFiles(staging.Dir(), vmParams, instanceID)
+	if err != nil {
+		return err
 	}
-	m.logger.Debug("rendered user-data", slog.String("vm", vmParams.Name))
-
-	isoFiles := []string{userDataPath}
 
-	if m.engine.HasTemplate(constants.TemplateCloudInitMetaData) {
-		metaDataPath := filepath.Join(tempDir, "meta-data")
-		if err := m.renderMetaData(metaDataPath, vmParams, instanceID); err != nil {
-			return fmt.Errorf("failed to render meta-data: %w", err)
-		}
-		isoFiles = append(isoFiles, metaDataPath)
-		m.logger.Debug("rendered meta-data", slog.String("vm", vmParams.Name))
+	if err := fileops.CreateDirectory(ctx, hypervisor.Executor, path.Dir(vmParams.CloudInitISOPath)); err != nil {
+		return err
 	}
 
-	if m.engine.HasTemplate(constants.TemplateCloudInitNetworkConfig) {
-		networkConfigPath := filepath.Join(tempDir, "network-config")
-		if err := m.renderNetworkConfig(networkConfigPath, vmParams); err != nil {
-			return fmt.Errorf("failed to render network-config: %w", err)
+	if m.isoCache {
+		cached, err := m.linkCachedISO(ctx, hypervisor, vmParams, isoFiles)
+		if err != nil {
+			return err
+		}
+		if cached {
+			return nil
 		}
-		isoFiles = append(isoFiles, networkConfigPath)
-		m.logger.Debug("rendered network-config", slog.String("vm", vmParams.Name))
 	}
 
 	err = mkisofs.CreateISO(ctx, hypervisor.Executor, mkisofs.ISOOptions{
@@ -81,31 +98,255 @@ func (m *Manager) CreateISO(ctx context.Context, hypervisor dependencies.Hypervi
 	return nil
 }
 
+// linkCachedISO makes the ISO of a VM a hard link to the cached ISO of the same cloud-init
+// documents, building the cached ISO first if there is none. Cached ISOs no VM links to
+// anymore are removed once they were unused for isoCacheRetention. It reports false if the
+// ISO could not be linked, in which case the caller builds it for the VM alone.
+func (m *Manager) linkCachedISO(ctx context.Context, hypervisor dependencies.HypervisorContext, vmParams parameters.CreateVM, isoFiles []string) (bool, error) {
+	digest, err := contentDigest(isoFiles)
+	if err != nil {
+		return false, err
+	}
+	cacheDir := path.Join(path.Dir(vmParams.CloudInitISOPath), isoCacheDir)
+	cachedISO := path.Join(cacheDir, digest+".iso")
+	log := m.logger.With(slog.String("vm", vmParams.Name), slog.String("cached_iso", cachedISO))
+
+	exists, _, err := fileops.FileStatus(ctx, hypervisor.Executor, cachedISO)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		if err := fileops.CreateDirectory(ctx, hypervisor.Executor, cacheDir); err != nil {
+			return false, err
+		}
+		// Built under a name of its own and moved into place, so VMs with the same documents
+		// created concurrently never link to a partial ISO.
+		partialISO := cachedISO + ".partial-" + vmParams.Name
+		err := mkisofs.CreateISO(ctx, hypervisor.Executor, mkisofs.ISOOptions{
+			OutputFile: partialISO,
+			VolumeID:   "cidata",
+			Files:      isoFiles,
+		})
+		if err == nil {
+			err = fileops.MoveFile(ctx, hypervisor.Executor, partialISO, cachedISO)
+		}
+		if err != nil {
+			if removeErr := fileops.RemoveFile(ctx, hypervisor.Executor, partialISO); removeErr != nil {
+				log.Warn("failed to remove partial cached cloud-init ISO", slog.String("error", removeErr.Error()))
+			}
+			return false, err
+		}
+		log.Debug("cached cloud-init ISO")
+	}
+
+	if err := fileops.LinkFile(ctx, hypervisor.Executor, cachedISO, vmParams.CloudInitISOPath); err != nil {
+		log.Warn("failed to link cached cloud-init ISO, building it for the VM", slog.String("error", err.Error()))
+		return false, nil
+	}
+	if err := fileops.RemoveUnlinkedFiles(ctx, hypervisor.Executor, cacheDir, "*.iso", isoCacheRetention); err != nil {
+		log.Warn("failed to prune cached cloud-init ISOs", slog.String("error", err.Error()))
+	}
+
+	log.Info("linked cloud-init ISO to cached ISO",
+		slog.String("path", vmParams.CloudInitISOPath),
+		slog.Bool("reused", exists),
+	)
+	return true, nil
+}
+
+// contentDigest returns the hex sha256 digest of the names and contents of rendered files.
+func contentDigest(files []string) (string, error) {
+	hash := sha256.New()
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read rendered %s: %w", filepath.Base(file), err)
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00", filepath.Base(file), len(data))
+		hash.Write(data)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// CreateSeed writes the cloud-init files of a container into the NoCloud seed directory of its
+// root filesystem, which cloud-init reads at first boot like the files of an ISO.
+func (m *Manager) CreateSeed(ctx context.Context, hypervisor dependencies.HypervisorContext, vmParams parameters.CreateVM, instanceID uuid.UUID) error {
+	staging, err := m.workspaces.New("cloud-init-" + vmParams.Name)
+	if err != nil {
+		return err
+	}
+	defer staging.Close()
+
+	seedFiles, err := m.renderFiles(staging.Dir(), vmParams, instanceID)
+	if err != nil {
+		return err
+	}
+
+	seedDir := path.Join(vmParams.DiskPath, NocloudSeedDir)
+	if err := fileops.CreateDirectory(ctx, hypervisor.Executor, seedDir); err != nil {
+		return err
+	}
+
+	// NoCloud requires meta-data, which only the ISO may go without.
+	if !m.engine.HasTemplate(constants.TemplateCloudInitMetaData) {
+		metaData := fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", instanceID, hostname(vmParams))
+		if err := fileops.WriteFile(ctx, hypervisor.Executor, path.Join(seedDir, "meta-data"), []byte(metaData)); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range seedFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read rendered %s: %w", filepath.Base(file), err)
+		}
+		if err := fileops.WriteFile(ctx, hypervisor.Executor, path.Join(seedDir, filepath.Base(file)), data); err != nil {
+			return err
+		}
+	}
+
+	m.logger.Info("created cloud-init seed",
+		slog.String("vm", vmParams.Name),
+		slog.String("path", seedDir),
+		slog.Int("files", len(seedFiles)),
+	)
+
+	return nil
+}
+
+// Render renders the cloud-init documents of a VM in memory, as CreateISO would write them.
+func (m *Manager) Render(vmParams parameters.CreateVM, instanceID uuid.UUID) ([]parameters.VMArtifact, error) {
+	staging, err := m.workspaces.New("cloud-init-" + vmParams.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer staging.Close()
+
+	files, err := m.renderFiles(staging.Dir(), vmParams, instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	documents := make([]parameters.VMArtifact, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rendered %s: %w", filepath.Base(file), err)
+		}
+		documents = append(documents, parameters.VMArtifact{Name: filepath.Base(file), Data: data})
+	}
+	return documents, nil
+}
+
+// renderFiles renders user-data, and meta-data and network-config when their templates are
+// loaded, into dir and returns their paths.
+func (m *Manager) renderFiles(dir string, vmParams parameters.CreateVM, instanceID uuid.UUID) ([]string, error) {
+	userDataPath := filepath.Join(dir, "user-data")
+	if err := m.renderUserData(userDataPath, vmParams); err != nil {
+		return nil, fmt.Errorf("failed to render user-data: %w", err)
+	}
+	m.logger.Debug("rendered user-data", slog.String("vm", vmParams.Name))
+
+	files := []string{userDataPath}
+
+	if m.engine.HasTemplate(constants.TemplateCloudInitMetaData) {
+		metaDataPath := filepath.Join(dir, "meta-data")
+		if err := m.renderMetaData(metaDataPath, vmParams, instanceID); err != nil {
+			return nil, fmt.Errorf("failed to render meta-data: %w", err)
+		}
+		files = append(files, metaDataPath)
+		m.logger.Debug("rendered meta-data", slog.String("vm", vmParams.Name))
+	}
+
+	if m.engine.HasTemplate(constants.TemplateCloudInitNetworkConfig) {
+		networkConfigPath := filepath.Join(dir, "network-config")
+		if err := m.renderNetworkConfig(networkConfigPath, vmParams); err != nil {
+			return nil, fmt.Errorf("failed to render network-config: %w", err)
+		}
+		files = append(files, networkConfigPath)
+		m.logger.Debug("rendered network-config", slog.String("vm", vmParams.Name))
+	}
+
+	return files, nil
+}
+
 func (m *Manager) renderUserData(path string, vmParams parameters.CreateVM) error {
 	vars := UserDataTemplateVars{
-		Hostname:         vmParams.Name,
+		Hostname:         hostname(vmParams),
 		UserConfigs:      vmParams.UserConfigs,
 		DoPackageUpdate:  vmParams.DoPackageUpdate,
 		DoPackageUpgrade: vmParams.DoPackageUpgrade,
 		Runcmds:          vmParams.Runcmds,
+		Proxy:            vmParams.Proxy,
+	}
+	// Containers get their bind mounts from the domain, not from guest fstab entries.
+	if vmParams.MachineType != string(constants.MACHINE_TYPE_CONTAINER) {
+		vars.Mounts = mountEntries(vmParams.HostBindMounts)
 	}
 
-	return m.engine.RenderToFile(constants.TemplateCloudInitUserData, path, vars)
+	if err := m.engine.RenderToFile(constants.TemplateCloudInitUserData, path, vars); err != nil {
+		return err
+	}
+	m.engine.Canary(vmParams.Name, constants.TemplateCloudInitUserData, vars)
+	return nil
+}
+
+// mountEntries maps host bind mounts to guest fstab entries keyed by their mount tag.
+func mountEntries(hostBindMounts []parameters.HostBindMount) []MountEntry {
+	entries := make([]MountEntry, 0, len(hostBindMounts))
+	for _, mount := range hostBindMounts {
+		entry := MountEntry{
+			Tag:     mount.Tag,
+			Path:    mount.TargetDir,
+			FSType:  "virtiofs",
+			Options: "defaults,nofail",
+		}
+		if mount.Driver == "9p" {
+			entry.FSType = "9p"
+			entry.Options = "trans=virtio,version=9p2000.L,nofail"
+		}
+		if mount.ReadOnly {
+			entry.Options += ",ro"
+		}
+		entries = append(entries, entry)
+	}
+	return entries
 }
 
 func (m *Manager) renderMetaData(path string, vmParams parameters.CreateVM, instanceID uuid.UUID) error {
 	vars := MetaDataTemplateVars{
 		InstanceID: instanceID.String(),
-		Hostname:   vmParams.Name,
+		Hostname:   hostname(vmParams),
 	}
 
-	return m.engine.RenderToFile(constants.TemplateCloudInitMetaData, path, vars)
+	if err := m.engine.RenderToFile(constants.TemplateCloudInitMetaData, path, vars); err != nil {
+		return err
+	}
+	m.engine.Canary(vmParams.Name, constants.TemplateCloudInitMetaData, vars)
+	return nil
 }
 
 func (m *Manager) renderNetworkConfig(path string, vmParams parameters.CreateVM) error {
 	vars := NetworkConfigTemplateVars{
-		Hostname: vmParams.Name,
+		Hostname: hostname(vmParams),
+	}
+	if vmParams.Network != nil {
+		vars.IPv4Address = vmParams.Network.IPv4Address
+		vars.IPv4GatewayAddress = vmParams.Network.IPv4Gateway
+		vars.Nameservers = vmParams.Network.Nameservers
 	}
 
-	return m.engine.RenderToFile(constants.TemplateCloudInitNetworkConfig, path, vars)
+	if err := m.engine.RenderToFile(constants.TemplateCloudInitNetworkConfig, path, vars); err != nil {
+		return err
+	}
+	m.engine.Canary(vmParams.Name, constants.TemplateCloudInitNetworkConfig, vars)
+	return nil
+}
+
+// hostname returns the guest hostname of a VM, which defaults to its name.
+func hostname(vmParams parameters.CreateVM) string {
+	if vmParams.Hostname != "" {
+		return vmParams.Hostname
+	}
+	return vmParams.Name
 }
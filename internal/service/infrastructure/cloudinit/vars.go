@@ -8,6 +8,16 @@ type UserDataTemplateVars struct {
 	DoPackageUpdate  bool
 	DoPackageUpgrade bool
 	Runcmds          []string
+	Mounts           []MountEntry
+	Proxy            *parameters.ProxyConfig
+}
+
+// MountEntry is a cloud-init mounts entry for a host bind mount.
+type MountEntry struct {
+	Tag     string
+	Path    string
+	FSType  string
+	Options string
 }
 
 type MetaDataTemplateVars struct {
@@ -19,4 +29,5 @@ type NetworkConfigTemplateVars struct {
 	Hostname           string
 	IPv4Address        string
 	IPv4GatewayAddress string
+	Nameservers        []string
 }
@@ -2,16 +2,26 @@ package disk
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"path"
 	"strings"
 
 	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/errdefs"
 	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/constants"
+	"github.com/terabiome/homonculus/pkg/executor/fileops"
 	"github.com/terabiome/homonculus/pkg/executor/qemuimg"
 )
 
+// bytesPerGiB converts disk_size_gb, which qemu-img reads as GiB, to bytes.
+const bytesPerGiB = 1 << 30
+
+// ErrInvalidBaseImage is returned when a base image fails pre-flight validation.
+var ErrInvalidBaseImage = errors.New("invalid base image")
+
 // Manager manages disk operations.
 type Manager struct {
 	logger *slog.Logger
@@ -25,7 +35,16 @@ func NewManager(logger *slog.Logger) *Manager {
 }
 
 // CreateDisk creates a QCOW2 disk with a backing file.
+// Network-booted VMs without a base image get an empty disk to install onto,
+// and containers get their root filesystem directory.
 func (m *Manager) CreateDisk(ctx context.Context, hypervisor dependencies.HypervisorContext, req parameters.CreateVM) error {
+	if req.MachineType == string(constants.MACHINE_TYPE_CONTAINER) {
+		return m.createRootfs(ctx, hypervisor, req)
+	}
+	if req.Netboot != nil && req.BaseImagePath == "" {
+		return m.createBlankDisk(ctx, hypervisor, req)
+	}
+
 	m.logger.Debug("creating qcow2 disk",
 		slog.String("path", req.DiskPath),
 		slog.String("base", req.BaseImagePath),
@@ -34,12 +53,21 @@ func (m *Manager) CreateDisk(ctx context.Context, hypervisor dependencies.Hyperv
 
 	backingFileFormat, err := parseBackingFileFormat(req.BaseImagePath)
 	if err != nil {
-		return err
+		return diskError(errdefs.DiskStageValidate, req.DiskPath, err)
 	}
 
 	outputFileFormat, err := parseOutputFileFormat(req.DiskPath)
 	if err != nil {
-		return err
+		return diskError(errdefs.DiskStageValidate, req.DiskPath, err)
+	}
+
+	if err := m.validateBaseImage(ctx, hypervisor, req.BaseImagePath, backingFileFormat, req.DiskSizeGB); err != nil {
+		return diskError(validationStage(err), req.DiskPath, err)
+	}
+
+	// Paths computed from the disk layout may name a directory that does not exist yet.
+	if err := fileops.CreateDirectory(ctx, hypervisor.Executor, path.Dir(req.DiskPath)); err != nil {
+		return diskError(errdefs.DiskStageCreate, req.DiskPath, err)
 	}
 
 	err = qemuimg.CreateBackingImage(ctx, hypervisor.Executor, qemuimg.BackingImageOptions{
@@ -48,17 +76,91 @@ func (m *Manager) CreateDisk(ctx context.Context, hypervisor dependencies.Hyperv
 		OutputFile:        req.DiskPath,
 		OutputFileFormat:  outputFileFormat,
 		SizeGB:            req.DiskSizeGB,
+		Format:            formatOptions(req.DiskOptions),
 	})
 
 	if err != nil {
-		return err
+		return diskError(errdefs.DiskStageCreate, req.DiskPath, err)
 	}
 
 	m.logger.Info("created qcow2 disk",
 		slog.String("path", req.DiskPath),
 		slog.Int64("size_gb", req.DiskSizeGB),
+		slog.Any("options", req.DiskOptions),
+	)
+
+	return nil
+}
+
+// createBlankDisk creates an empty QCOW2 disk.
+func (m *Manager) createBlankDisk(ctx context.Context, hypervisor dependencies.HypervisorContext, req parameters.CreateVM) error {
+	m.logger.Debug("creating blank qcow2 disk",
+		slog.String("path", req.DiskPath),
+		slog.Int64("size_gb", req.DiskSizeGB),
 	)
 
+	outputFileFormat, err := parseOutputFileFormat(req.DiskPath)
+	if err != nil {
+		return diskError(errdefs.DiskStageValidate, req.DiskPath, err)
+	}
+
+	if err := fileops.CreateDirectory(ctx, hypervisor.Executor, path.Dir(req.DiskPath)); err != nil {
+		return diskError(errdefs.DiskStageCreate, req.DiskPath, err)
+	}
+
+	err = qemuimg.CreateImage(ctx, hypervisor.Executor, qemuimg.ImageOptions{
+		OutputFile:       req.DiskPath,
+		OutputFileFormat: outputFileFormat,
+		SizeGB:           req.DiskSizeGB,
+		Format:           formatOptions(req.DiskOptions),
+	})
+	if err != nil {
+		return diskError(errdefs.DiskStageCreate, req.DiskPath, err)
+	}
+
+	m.logger.Info("created blank qcow2 disk",
+		slog.String("path", req.DiskPath),
+		slog.Int64("size_gb", req.DiskSizeGB),
+		slog.Any("options", req.DiskOptions),
+	)
+
+	return nil
+}
+
+// createRootfs unpacks the root filesystem tarball of a container into its own directory.
+// An existing directory is refused, since rolling back the container would remove it.
+func (m *Manager) createRootfs(ctx context.Context, hypervisor dependencies.HypervisorContext, req parameters.CreateVM) error {
+	m.logger.Debug("creating container root filesystem",
+		slog.String("path", req.DiskPath),
+		slog.String("base", req.BaseImagePath),
+	)
+
+	exists, readable, err := fileops.FileStatus(ctx, hypervisor.Executor, req.BaseImagePath)
+	if err != nil {
+		return diskError(errdefs.DiskStageInspect, req.DiskPath, err)
+	}
+	if !exists || !readable {
+		return diskError(errdefs.DiskStageValidate, req.DiskPath,
+			fmt.Errorf("%w: %s is not a readable file on host %s", ErrInvalidBaseImage, req.BaseImagePath, hypervisor.Host))
+	}
+
+	exists, err = fileops.IsDirectory(ctx, hypervisor.Executor, req.DiskPath)
+	if err != nil {
+		return diskError(errdefs.DiskStageInspect, req.DiskPath, err)
+	}
+	if exists {
+		return diskError(errdefs.DiskStageValidate, req.DiskPath, fmt.Errorf("root filesystem %s already exists", req.DiskPath))
+	}
+
+	if err := fileops.CreateDirectory(ctx, hypervisor.Executor, req.DiskPath); err != nil {
+		return diskError(errdefs.DiskStageCreate, req.DiskPath, err)
+	}
+	if err := fileops.ExtractArchive(ctx, hypervisor.Executor, req.BaseImagePath, req.DiskPath); err != nil {
+		return diskError(errdefs.DiskStageCreate, req.DiskPath, err)
+	}
+
+	m.logger.Info("created container root filesystem", slog.String("path", req.DiskPath))
+
 	return nil
 }
 
@@ -72,12 +174,16 @@ func (m *Manager) CreateDiskForClone(ctx context.Context, hypervisor dependencie
 
 	backingFileFormat, err := parseBackingFileFormat(req.BaseImagePath)
 	if err != nil {
-		return err
+		return diskError(errdefs.DiskStageValidate, req.DiskPath, err)
 	}
 
 	outputFileFormat, err := parseOutputFileFormat(req.DiskPath)
 	if err != nil {
-		return err
+		return diskError(errdefs.DiskStageValidate, req.DiskPath, err)
+	}
+
+	if err := m.validateBaseImage(ctx, hypervisor, req.BaseImagePath, backingFileFormat, req.DiskSizeGB); err != nil {
+		return diskError(validationStage(err), req.DiskPath, err)
 	}
 
 	err = qemuimg.CreateBackingImage(ctx, hypervisor.Executor, qemuimg.BackingImageOptions{
@@ -88,7 +194,7 @@ func (m *Manager) CreateDiskForClone(ctx context.Context, hypervisor dependencie
 		SizeGB:            req.DiskSizeGB,
 	})
 	if err != nil {
-		return err
+		return diskError(errdefs.DiskStageCreate, req.DiskPath, err)
 	}
 
 	m.logger.Info("created qcow2 disk for clone",
@@ -99,6 +205,68 @@ func (m *Manager) CreateDiskForClone(ctx context.Context, hypervisor dependencie
 	return nil
 }
 
+// validateBaseImage checks that a base image exists, is readable, has the format its extension
+// declares and fits into a disk of sizeGB, before qemu-img create fails on it with less context.
+func (m *Manager) validateBaseImage(ctx context.Context, hypervisor dependencies.HypervisorContext, baseImagePath, format string, sizeGB int64) error {
+	exists, readable, err := fileops.FileStatus(ctx, hypervisor.Executor, baseImagePath)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s does not exist on host %s", ErrInvalidBaseImage, baseImagePath, hypervisor.Host)
+	}
+	if !readable {
+		return fmt.Errorf("%w: %s is not readable on host %s", ErrInvalidBaseImage, baseImagePath, hypervisor.Host)
+	}
+
+	info, err := qemuimg.Inspect(ctx, hypervisor.Executor, baseImagePath)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrInvalidBaseImage, baseImagePath, err)
+	}
+	if info.Format != format {
+		return fmt.Errorf("%w: %s is a %s image, but its extension declares %s", ErrInvalidBaseImage, baseImagePath, info.Format, format)
+	}
+
+	if sizeGB*bytesPerGiB < info.VirtualSizeBytes {
+		minimumGB := (info.VirtualSizeBytes + bytesPerGiB - 1) / bytesPerGiB
+		return fmt.Errorf("%w: disk_size_gb %d is smaller than the virtual size of %s, use at least %d",
+			ErrInvalidBaseImage, sizeGB, baseImagePath, minimumGB)
+	}
+
+	m.logger.Debug("validated base image",
+		slog.String("path", baseImagePath),
+		slog.String("format", info.Format),
+		slog.Int64("virtual_size_bytes", info.VirtualSizeBytes),
+	)
+	return nil
+}
+
+// diskError records the stage at which creating a disk failed.
+func diskError(stage, path string, err error) error {
+	return &errdefs.ErrDiskCreateFailed{Stage: stage, Path: path, Err: err}
+}
+
+// validationStage returns the stage of a failed base image validation: validate if the image
+// was rejected, inspect if it could not be read on the host.
+func validationStage(err error) string {
+	if errors.Is(err, ErrInvalidBaseImage) {
+		return errdefs.DiskStageValidate
+	}
+	return errdefs.DiskStageInspect
+}
+
+// formatOptions converts the disk options of a VM, if any, to qemu-img options.
+func formatOptions(options *parameters.DiskOptions) qemuimg.FormatOptions {
+	if options == nil {
+		return qemuimg.FormatOptions{}
+	}
+	return qemuimg.FormatOptions{
+		Preallocation:   options.Preallocation,
+		ClusterSize:     options.ClusterSize,
+		CompressionType: options.CompressionType,
+	}
+}
+
 func parseBackingFileFormat(backingFilePath string) (string, error) {
 	backingFileFormat := strings.ToLower(path.Ext(backingFilePath))
 
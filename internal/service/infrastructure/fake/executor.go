@@ -0,0 +1,34 @@
+package fake
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Executor accepts every host-side command without running it, so cleanup and netboot helpers
+// of the fake backend never touch the machine homonculus runs on.
+type Executor struct {
+	logger *slog.Logger
+}
+
+// NewExecutor creates a new fake executor.
+func NewExecutor(logger *slog.Logger) *Executor {
+	return &Executor{
+		logger: logger,
+	}
+}
+
+func (e *Executor) Name() string {
+	return "fake"
+}
+
+// Execute logs the command and reports success without output.
+func (e *Executor) Execute(ctx context.Context, stdout, stderr io.Writer, command string, args ...string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return -1, err
+	}
+	e.logger.Debug("skipping command on fake backend", slog.String("cmd", strings.Join(append([]string{command}, args...), " ")))
+	return 0, nil
+}
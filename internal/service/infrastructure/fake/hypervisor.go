@@ -0,0 +1,706 @@
+// Package fake implements the libvirt, disk, cloud-init and netboot managers in memory, so the API
+// can be exercised on machines without libvirt or KVM. Nothing is written to or run on a host.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/errdefs"
+	"github.com/terabiome/homonculus/internal/service"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/libvirt"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/units"
+	"libvirt.org/go/libvirtxml"
+)
+
+const (
+	// HostCPUs and HostMemoryKiB are the resources every fake host reports.
+	HostCPUs      = 64
+	HostMemoryKiB = 256 << 20
+)
+
+var (
+	_ service.LibvirtManager   = (*Hypervisor)(nil)
+	_ service.DiskManager      = (*Hypervisor)(nil)
+	_ service.CloudInitManager = (*Hypervisor)(nil)
+	_ service.NetbootManager   = (*Hypervisor)(nil)
+)
+
+// domain is a VM defined on a fake host.
+type domain struct {
+	definition  libvirtxml.Domain
+	running     bool
+	startedAt   time.Time
+	ipAddress   string
+	snapshots   []string
+	usbDevices  []string
+	serialPorts []int
+}
+
+// Hypervisor keeps the domains of every fake host in memory, keyed by host name.
+// VMs start instantly and report a documentation-range IP address while running.
+type Hypervisor struct {
+	mu     sync.Mutex
+	hosts  map[string]map[string]*domain
+	nextIP int
+	logger *slog.Logger
+}
+
+// NewHypervisor creates a fake hypervisor without any domains.
+func NewHypervisor(logger *slog.Logger) *Hypervisor {
+	return &Hypervisor{
+		hosts:  make(map[string]map[string]*domain),
+		logger: logger.With(slog.String("component", "fake-hypervisor")),
+	}
+}
+
+// domains returns the domains of a host. The caller must hold mu.
+func (h *Hypervisor) domains(hypervisor dependencies.HypervisorContext) map[string]*domain {
+	domains, ok := h.hosts[hypervisor.Host]
+	if !ok {
+		domains = make(map[string]*domain)
+		h.hosts[hypervisor.Host] = domains
+	}
+	return domains
+}
+
+// lookup returns a domain by name. The caller must hold mu.
+func (h *Hypervisor) lookup(hypervisor dependencies.HypervisorContext, name string) (*domain, error) {
+	d, ok := h.domains(hypervisor)[name]
+	if !ok {
+		return nil, fmt.Errorf("could not look up VM by name: %w: %s", errdefs.ErrVMNotFound, name)
+	}
+	return d, nil
+}
+
+// define adds a domain. The caller must hold mu.
+func (h *Hypervisor) define(hypervisor dependencies.HypervisorContext, definition libvirtxml.Domain) error {
+	domains := h.domains(hypervisor)
+	if _, exists := domains[definition.Name]; exists {
+		return fmt.Errorf("could not define VM from Libvirt XML: %w: %s", errdefs.ErrVMExists, definition.Name)
+	}
+	domains[definition.Name] = &domain{definition: definition}
+	return nil
+}
+
+// CreateVirtualMachine defines a VM without starting it.
+func (h *Hypervisor) CreateVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.CreateVM, virtualMachineUUID uuid.UUID) error {
+	definition, err := buildDomain(params, virtualMachineUUID)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.define(hypervisor, definition); err != nil {
+		return err
+	}
+	h.logger.Info("defined fake VM", slog.String("host", hypervisor.Host), slog.String("vm", params.Name))
+	return nil
+}
+
+// RenderVirtualMachineXML builds the definition a VM spec would be defined with, without defining it.
+func (h *Hypervisor) RenderVirtualMachineXML(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.CreateVM, virtualMachineUUID uuid.UUID) (libvirtxml.Domain, error) {
+	return buildDomain(params, virtualMachineUUID)
+}
+
+// buildDomain builds the definition of a fake VM.
+func buildDomain(params parameters.CreateVM, virtualMachineUUID uuid.UUID) (libvirtxml.Domain, error) {
+	metadata, err := libvirt.NewDomainMetadata(params).Render()
+	if err != nil {
+		return libvirtxml.Domain{}, err
+	}
+
+	disks := []libvirtxml.DomainDisk{fileDisk("disk", "vda", params.DiskPath)}
+	if params.CloudInitISOPath != "" {
+		disks = append(disks, fileDisk("cdrom", libvirt.CloudInitTarget, params.CloudInitISOPath))
+	}
+	for i, cdrom := range params.CDROMs {
+		disks = append(disks, fileDisk("cdrom", libvirt.CDROMTarget(i), cdrom.Path))
+	}
+
+	definition := libvirtxml.Domain{
+		Type:     "kvm",
+		Name:     params.Name,
+		UUID:     virtualMachineUUID.String(),
+		Metadata: &libvirtxml.DomainMetadata{XML: metadata},
+		VCPU:     &libvirtxml.DomainVCPU{Value: uint(params.VCPUCount)},
+		Devices:  &libvirtxml.DomainDeviceList{Disks: disks},
+	}
+	libvirt.SetDomainMemory(&definition, params.MemoryMB)
+	return definition, nil
+}
+
+// fileDisk builds a disk or CD-ROM drive backed by path; CD-ROM drives without a path are empty.
+func fileDisk(device, target, path string) libvirtxml.DomainDisk {
+	disk := libvirtxml.DomainDisk{
+		Device: device,
+		Target: &libvirtxml.DomainDiskTarget{Dev: target},
+	}
+	if path != "" {
+		disk.Source = &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: path}}
+	}
+	return disk
+}
+
+// CloneVirtualMachine defines a copy of a base definition without starting it.
+func (h *Hypervisor) CloneVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, baseDomainXML libvirtxml.Domain, targetInfo parameters.TargetVMSpec, virtualMachineUUID uuid.UUID, cloudInitISOPath string) error {
+	serialized, err := baseDomainXML.Marshal()
+	if err != nil {
+		return fmt.Errorf("could not serialize Libvirt XML to string: %w", err)
+	}
+	definition := libvirtxml.Domain{}
+	if err := definition.Unmarshal(serialized); err != nil {
+		return fmt.Errorf("could not parse domain XML: %w", err)
+	}
+
+	definition.Name = targetInfo.Name
+	definition.UUID = virtualMachineUUID.String()
+	definition.VCPU = &libvirtxml.DomainVCPU{Value: uint(targetInfo.VCPUCount)}
+	libvirt.SetDomainMemory(&definition, targetInfo.MemoryMB)
+	if definition.Devices == nil {
+		definition.Devices = &libvirtxml.DomainDeviceList{}
+	}
+
+	var disks []libvirtxml.DomainDisk
+	for _, disk := range definition.Devices.Disks {
+		switch {
+		case disk.Device == "disk" && disk.Target != nil && disk.Target.Dev == "vda":
+			disk = fileDisk("disk", "vda", targetInfo.DiskPath)
+		case disk.Device == "cdrom" && disk.Target != nil && disk.Target.Dev == libvirt.CloudInitTarget:
+			if cloudInitISOPath == "" {
+				continue
+			}
+			disk = fileDisk("cdrom", libvirt.CloudInitTarget, cloudInitISOPath)
+		}
+		disks = append(disks, disk)
+	}
+	definition.Devices.Disks = disks
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.define(hypervisor, definition); err != nil {
+		return err
+	}
+	h.logger.Info("defined cloned fake VM", slog.String("host", hypervisor.Host), slog.String("vm", targetInfo.Name))
+	return nil
+}
+
+// UndefineVirtualMachine removes a VM and its snapshots, stopping it first if needed.
+func (h *Hypervisor) UndefineVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, name string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, err := h.lookup(hypervisor, name); err != nil {
+		return err
+	}
+	delete(h.domains(hypervisor), name)
+	h.logger.Info("undefined fake VM", slog.String("host", hypervisor.Host), slog.String("vm", name))
+	return nil
+}
+
+// DiskReferences returns no references, as fake VMs have no disk files.
+func (h *Hypervisor) DiskReferences(ctx context.Context, hypervisor dependencies.HypervisorContext) (parameters.DiskReferences, error) {
+	return parameters.DiskReferences{}, nil
+}
+
+// DeleteVirtualMachine removes a VM along with its snapshots, as libvirt.Manager does, and returns its UUID.
+func (h *Hypervisor) DeleteVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.DeleteVM, references parameters.DiskReferences) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.lookup(hypervisor, params.Name)
+	if err != nil {
+		return "", err
+	}
+	delete(h.domains(hypervisor), params.Name)
+	h.logger.Info("deleted fake VM", slog.String("host", hypervisor.Host), slog.String("vm", params.Name))
+	return d.definition.UUID, nil
+}
+
+// RemoveDisk only logs, as fake VMs have no disk files.
+func (h *Hypervisor) RemoveDisk(ctx context.Context, hypervisor dependencies.HypervisorContext, vmName, path string, container bool) error {
+	h.logger.Info("removed fake disk", slog.String("host", hypervisor.Host), slog.String("vm", vmName), slog.String("path", path))
+	return nil
+}
+
+// StartVirtualMachine starts a VM, which is running and has an IP address right away.
+func (h *Hypervisor) StartVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.StartVM) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.lookup(hypervisor, params.Name)
+	if err != nil {
+		return err
+	}
+	if d.running {
+		return fmt.Errorf("could not start VM: domain %s is already running", params.Name)
+	}
+	d.running = true
+	d.startedAt = time.Now()
+	if d.ipAddress == "" {
+		// 192.0.2.0/24 is reserved for documentation, so probes never reach a real guest.
+		d.ipAddress = fmt.Sprintf("192.0.2.%d", h.nextIP%254+1)
+		h.nextIP++
+	}
+	h.logger.Info("started fake VM", slog.String("host", hypervisor.Host), slog.String("vm", params.Name))
+	return nil
+}
+
+// StopVirtualMachine stops a VM; stopping a stopped VM is a no-op.
+func (h *Hypervisor) StopVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.StopVM) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.lookup(hypervisor, params.Name)
+	if err != nil {
+		return err
+	}
+	d.running = false
+	h.logger.Info("stopped fake VM", slog.String("host", hypervisor.Host), slog.String("vm", params.Name))
+	return nil
+}
+
+// MigrateVirtualMachine moves a VM to another fake host, keeping it running if it was.
+func (h *Hypervisor) MigrateVirtualMachine(ctx context.Context, source, destination dependencies.HypervisorContext, name string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.lookup(source, name)
+	if err != nil {
+		return err
+	}
+	if _, exists := h.domains(destination)[name]; exists {
+		return fmt.Errorf("could not migrate VM: %w: %s on host %s", errdefs.ErrVMExists, name, destination.Host)
+	}
+	delete(h.domains(source), name)
+	h.domains(destination)[name] = d
+	h.logger.Info("migrated fake VM", slog.String("vm", name), slog.String("from", source.Host), slog.String("to", destination.Host))
+	return nil
+}
+
+// OpenSerialConsole attaches to an echoing console of a running fake VM.
+func (h *Hypervisor) OpenSerialConsole(ctx context.Context, hypervisor dependencies.HypervisorContext, name string, takeOver bool) (io.ReadWriteCloser, error) {
+	h.mu.Lock()
+	d, err := h.lookup(hypervisor, name)
+	running := err == nil && d.running
+	h.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if !running {
+		return nil, fmt.Errorf("VM %s is not running", name)
+	}
+
+	console, guest := net.Pipe()
+	go func() {
+		defer guest.Close()
+		io.Copy(guest, guest)
+	}()
+	return console, nil
+}
+
+// CheckVirtualMachineExistence checks if a VM exists.
+func (h *Hypervisor) CheckVirtualMachineExistence(hypervisor dependencies.HypervisorContext, name string) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, exists := h.domains(hypervisor)[name]
+	return exists, nil
+}
+
+// GetVirtualMachineInfo returns the details of a VM.
+func (h *Hypervisor) GetVirtualMachineInfo(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.QueryVM) (parameters.VMInfo, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.lookup(hypervisor, params.Name)
+	if err != nil {
+		return parameters.VMInfo{}, err
+	}
+	return d.info(), nil
+}
+
+// info reports a domain the way libvirt.Manager does.
+func (d *domain) info() parameters.VMInfo {
+	memoryKiB := libvirt.DomainCurrentMemoryKiB(d.definition)
+	vmInfo := parameters.VMInfo{
+		Name:        d.definition.Name,
+		UUID:        d.definition.UUID,
+		State:       "shutoff",
+		VCPUCount:   d.definition.VCPU.Value,
+		MemoryMB:    uint(units.KiBToMiB(memoryKiB)),
+		MemoryBytes: units.KiBToBytes(memoryKiB),
+		Persistent:  true,
+	}
+	for _, disk := range d.definition.Devices.Disks {
+		if disk.Source != nil && disk.Source.File != nil {
+			vmInfo.Disks = append(vmInfo.Disks, parameters.DiskInfo{Path: disk.Source.File.File, Device: disk.Device})
+		}
+	}
+	if metadata, err := libvirt.ParseDomainMetadata(d.definition); err == nil {
+		vmInfo.Labels = metadata.LabelMap()
+		vmInfo.Ownership = metadata.Ownership()
+	}
+	if d.running {
+		vmInfo.State = "running"
+		vmInfo.Hostname = d.definition.Name
+		vmInfo.IPAddress = d.ipAddress
+	}
+	return vmInfo
+}
+
+// GetVirtualMachineXML returns a copy of the definition of a VM.
+func (h *Hypervisor) GetVirtualMachineXML(hypervisor dependencies.HypervisorContext, name string) (libvirtxml.Domain, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.lookup(hypervisor, name)
+	if err != nil {
+		return libvirtxml.Domain{}, err
+	}
+
+	serialized, err := d.definition.Marshal()
+	if err != nil {
+		return libvirtxml.Domain{}, fmt.Errorf("could not read domain XML: %w", err)
+	}
+	definition := libvirtxml.Domain{}
+	if err := definition.Unmarshal(serialized); err != nil {
+		return libvirtxml.Domain{}, fmt.Errorf("could not parse domain XML: %w", err)
+	}
+	return definition, nil
+}
+
+// ListAllVirtualMachines returns the details of every VM of a host, sorted by name.
+func (h *Hypervisor) ListAllVirtualMachines(ctx context.Context, hypervisor dependencies.HypervisorContext) ([]parameters.VMInfo, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var vmInfos []parameters.VMInfo
+	for _, d := range h.domains(hypervisor) {
+		vmInfos = append(vmInfos, d.info())
+	}
+	slices.SortFunc(vmInfos, func(a, b parameters.VMInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return vmInfos, nil
+}
+
+// GetHostCapacity reports the fixed resources of a fake host and those claimed by its VMs.
+func (h *Hypervisor) GetHostCapacity(ctx context.Context, hypervisor dependencies.HypervisorContext) (parameters.HostCapacity, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	capacity := parameters.HostCapacity{
+		Host:           hypervisor.Host,
+		TotalMemoryKiB: HostMemoryKiB,
+		FreeMemoryKiB:  HostMemoryKiB,
+		CPUs:           HostCPUs,
+	}
+	for _, d := range h.domains(hypervisor) {
+		capacity.DefinedVMs++
+		capacity.AllocatedMemoryKiB += libvirt.DomainMemoryKiB(d.definition)
+		capacity.AllocatedVCPUs += d.definition.VCPU.Value
+		if d.running {
+			capacity.RunningVMs++
+			capacity.FreeMemoryKiB -= min(capacity.FreeMemoryKiB, libvirt.DomainMemoryKiB(d.definition))
+		}
+	}
+	return capacity, nil
+}
+
+// GetConsoleAddress fails, as fake VMs have no graphical console.
+func (h *Hypervisor) GetConsoleAddress(ctx context.Context, hypervisor dependencies.HypervisorContext, name string) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, err := h.lookup(hypervisor, name); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("VM %s has no graphical console on the fake backend", name)
+}
+
+// SetGraphicsPassword fails like GetConsoleAddress: fake VMs have no graphical console.
+func (h *Hypervisor) SetGraphicsPassword(ctx context.Context, hypervisor dependencies.HypervisorContext, name, password string, validTo time.Time) (parameters.GraphicsInfo, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, err := h.lookup(hypervisor, name); err != nil {
+		return parameters.GraphicsInfo{}, err
+	}
+	return parameters.GraphicsInfo{}, fmt.Errorf("VM %s has no graphical console on the fake backend", name)
+}
+
+// GetVirtualMachineStats reports a running VM as using a quarter of its vCPUs and all of its memory.
+func (h *Hypervisor) GetVirtualMachineStats(hypervisor dependencies.HypervisorContext, name string) (parameters.VMStatsSample, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.lookup(hypervisor, name)
+	if err != nil {
+		return parameters.VMStatsSample{}, err
+	}
+	sample := parameters.VMStatsSample{
+		Time:      time.Now(),
+		State:     "shutoff",
+		VCPUCount: d.definition.VCPU.Value,
+		MemoryKiB: libvirt.DomainCurrentMemoryKiB(d.definition),
+	}
+	if d.running {
+		sample.State = "running"
+		sample.CPUTimeNs = uint64(sample.Time.Sub(d.startedAt)) * uint64(sample.VCPUCount) / 4
+		sample.MemoryRSSKiB = sample.MemoryKiB
+	}
+	return sample, nil
+}
+
+// GuestAgentReady reports the guest agent of running VMs as ready.
+func (h *Hypervisor) GuestAgentReady(hypervisor dependencies.HypervisorContext, name string) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.lookup(hypervisor, name)
+	if err != nil {
+		return false, err
+	}
+	return d.running, nil
+}
+
+// CloudInitStatus reports cloud-init as done for running VMs with a cloud-init ISO.
+func (h *Hypervisor) CloudInitStatus(hypervisor dependencies.HypervisorContext, name string) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.lookup(hypervisor, name)
+	if err != nil {
+		return libvirt.CloudInitUnknown, err
+	}
+	if !libvirt.HasCloudInitISO(d.definition) {
+		return "", nil
+	}
+	if !d.running {
+		return libvirt.CloudInitUnknown, nil
+	}
+	return libvirt.CloudInitDone, nil
+}
+
+// GuestExec runs nothing and reports success for running VMs.
+func (h *Hypervisor) GuestExec(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.GuestExec) (parameters.GuestExecResult, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.lookup(hypervisor, params.Name)
+	if err != nil {
+		return parameters.GuestExecResult{}, err
+	}
+	if !d.running {
+		return parameters.GuestExecResult{}, fmt.Errorf("could not run guest command: domain %s is not running", params.Name)
+	}
+	return parameters.GuestExecResult{}, nil
+}
+
+// AttachDevices records USB devices and serial ports; serial ports are numbered from 1.
+func (h *Hypervisor) AttachDevices(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.AttachDevices) ([]int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.lookup(hypervisor, params.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, device := range params.USBDevices {
+		d.usbDevices = append(d.usbDevices, device.VendorProduct)
+	}
+
+	var ports []int
+	for range params.SerialDevices {
+		port := 1
+		for slices.Contains(d.serialPorts, port) {
+			port++
+		}
+		d.serialPorts = append(d.serialPorts, port)
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// DetachDevices removes recorded USB devices and serial ports.
+func (h *Hypervisor) DetachDevices(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.DetachDevices) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.lookup(hypervisor, params.Name)
+	if err != nil {
+		return err
+	}
+	for _, device := range params.USBDevices {
+		i := slices.Index(d.usbDevices, device.VendorProduct)
+		if i < 0 {
+			return fmt.Errorf("could not detach USB device %s: not attached", device.VendorProduct)
+		}
+		d.usbDevices = slices.Delete(d.usbDevices, i, i+1)
+	}
+	for _, port := range params.SerialPorts {
+		i := slices.Index(d.serialPorts, port)
+		if i < 0 {
+			return fmt.Errorf("VM %s has no serial device on port %d", params.Name, port)
+		}
+		d.serialPorts = slices.Delete(d.serialPorts, i, i+1)
+	}
+	return nil
+}
+
+// ChangeMedia inserts an ISO into, or ejects the media of, a CD-ROM drive of a VM.
+func (h *Hypervisor) ChangeMedia(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.ChangeMedia) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.lookup(hypervisor, params.Name)
+	if err != nil {
+		return err
+	}
+
+	target := params.Device
+	if target == "" {
+		target = libvirt.CDROMTarget(0)
+	}
+	for i, disk := range d.definition.Devices.Disks {
+		if disk.Device == "cdrom" && disk.Target != nil && disk.Target.Dev == target {
+			d.definition.Devices.Disks[i] = fileDisk("cdrom", target, params.Path)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: VM %s has no CD-ROM drive %s", errdefs.ErrDeviceNotFound, params.Name, target)
+}
+
+// SetVirtualMachineMetadata records labels and ownership in the definition of a VM.
+func (h *Hypervisor) SetVirtualMachineMetadata(ctx context.Context, hypervisor dependencies.HypervisorContext, name string, labels map[string]string, ownership parameters.Ownership) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.lookup(hypervisor, name)
+	if err != nil {
+		return err
+	}
+	return libvirt.ReplaceDomainMetadata(&d.definition, libvirt.NewDomainMetadata(parameters.CreateVM{Labels: labels, Ownership: ownership}))
+}
+
+// CreateSnapshot records a snapshot name.
+func (h *Hypervisor) CreateSnapshot(ctx context.Context, hypervisor dependencies.HypervisorContext, vmName, snapshotName, description string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.lookup(hypervisor, vmName)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(d.snapshots, snapshotName) {
+		return fmt.Errorf("could not create snapshot %s: already exists", snapshotName)
+	}
+	d.snapshots = append(d.snapshots, snapshotName)
+	return nil
+}
+
+// ListSnapshots returns the snapshot names of a VM.
+func (h *Hypervisor) ListSnapshots(hypervisor dependencies.HypervisorContext, vmName string) ([]string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.lookup(hypervisor, vmName)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(d.snapshots), nil
+}
+
+// DeleteSnapshot deletes a snapshot of a VM.
+func (h *Hypervisor) DeleteSnapshot(hypervisor dependencies.HypervisorContext, vmName, snapshotName string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.lookup(hypervisor, vmName)
+	if err != nil {
+		return err
+	}
+	i := slices.Index(d.snapshots, snapshotName)
+	if i < 0 {
+		return fmt.Errorf("could not look up snapshot %s: not found", snapshotName)
+	}
+	d.snapshots = slices.Delete(d.snapshots, i, i+1)
+	return nil
+}
+
+// RevertSnapshot reverts a VM to a snapshot, which leaves it running.
+func (h *Hypervisor) RevertSnapshot(hypervisor dependencies.HypervisorContext, vmName, snapshotName string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.lookup(hypervisor, vmName)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(d.snapshots, snapshotName) {
+		return fmt.Errorf("could not look up snapshot %s: not found", snapshotName)
+	}
+	d.running = true
+	d.startedAt = time.Now()
+	if d.ipAddress == "" {
+		d.ipAddress = fmt.Sprintf("192.0.2.%d", h.nextIP%254+1)
+		h.nextIP++
+	}
+	h.logger.Info("reverted fake VM to snapshot", slog.String("host", hypervisor.Host), slog.String("vm", vmName), slog.String("snapshot", snapshotName))
+	return nil
+}
+
+// CreateDisk pretends to create the disk of a VM.
+func (h *Hypervisor) CreateDisk(ctx context.Context, hypervisor dependencies.HypervisorContext, req parameters.CreateVM) error {
+	h.logger.Debug("created fake disk", slog.String("host", hypervisor.Host), slog.String("path", req.DiskPath))
+	return nil
+}
+
+// CreateDiskForClone pretends to create the disk of a clone.
+func (h *Hypervisor) CreateDiskForClone(ctx context.Context, hypervisor dependencies.HypervisorContext, req parameters.TargetVMSpec) error {
+	h.logger.Debug("created fake disk", slog.String("host", hypervisor.Host), slog.String("path", req.DiskPath))
+	return nil
+}
+
+// CreateISO pretends to build the cloud-init ISO of a VM.
+func (h *Hypervisor) CreateISO(ctx context.Context, hypervisor dependencies.HypervisorContext, vmParams parameters.CreateVM, instanceID uuid.UUID) error {
+	h.logger.Debug("created fake cloud-init ISO", slog.String("host", hypervisor.Host), slog.String("path", vmParams.CloudInitISOPath))
+	return nil
+}
+
+// CreateSeed pretends to write the cloud-init seed of a container.
+func (h *Hypervisor) CreateSeed(ctx context.Context, hypervisor dependencies.HypervisorContext, vmParams parameters.CreateVM, instanceID uuid.UUID) error {
+	h.logger.Debug("created fake cloud-init seed", slog.String("host", hypervisor.Host), slog.String("path", vmParams.DiskPath))
+	return nil
+}
+
+// Render renders no cloud-init documents: the fake backend loads no templates.
+func (h *Hypervisor) Render(vmParams parameters.CreateVM, instanceID uuid.UUID) ([]parameters.VMArtifact, error) {
+	return nil, nil
+}
+
+// EnsureServer pretends to start the netboot helper of a bridge.
+func (h *Hypervisor) EnsureServer(ctx context.Context, hypervisor dependencies.HypervisorContext, bridge string, server parameters.NetbootServer) error {
+	h.logger.Debug("started fake netboot server", slog.String("host", hypervisor.Host), slog.String("bridge", bridge))
+	return nil
+}
+
+// StopServer pretends to stop the netboot helper of a bridge.
+func (h *Hypervisor) StopServer(ctx context.Context, hypervisor dependencies.HypervisorContext, bridge string) error {
+	h.logger.Debug("stopped fake netboot server", slog.String("host", hypervisor.Host), slog.String("bridge", bridge))
+	return nil
+}
@@ -0,0 +1,97 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/constants"
+	"github.com/terabiome/homonculus/pkg/units"
+	"libvirt.org/go/libvirt"
+	"libvirt.org/go/libvirtxml"
+)
+
+// metadataKey is the namespace prefix libvirt gives the homonculus metadata element.
+const metadataKey = "homonculus"
+
+// SpecFromDomain extracts the create spec of a domain defined outside homonculus, as far as
+// the definition tells: sizing, primary disk, bridge interface, cloud-init ISO and firmware.
+// Provisioning details such as the base image and cloud-init configuration are unknown.
+func SpecFromDomain(domainXML libvirtxml.Domain) parameters.CreateVM {
+	spec := parameters.CreateVM{
+		Name:             domainXML.Name,
+		DiskPath:         BaseDiskPath(domainXML),
+		CloudInitISOPath: CloudInitISOPath(domainXML),
+	}
+	if domainXML.VCPU != nil {
+		spec.VCPUCount = int(domainXML.VCPU.Value)
+	}
+	if domainXML.Memory != nil {
+		spec.MemoryMB = int64(units.KiBToMiB(DomainMemoryKiB(domainXML)))
+	}
+	if domainXML.OS != nil {
+		if domainXML.OS.Firmware == "efi" || (domainXML.OS.Loader != nil && domainXML.OS.Loader.Type == "pflash") {
+			spec.Firmware = string(constants.FIRMWARE_UEFI)
+			spec.SecureBoot = domainXML.OS.Loader != nil && domainXML.OS.Loader.Secure == "yes"
+		}
+		if domainXML.OS.NVRam != nil {
+			spec.NVRAMPath = domainXML.OS.NVRam.NVRam
+		}
+		// Libvirt expands the machine type to a versioned one, e.g. pc-q35-8.2.
+		if domainXML.OS.Type != nil && domainXML.OS.Type.Machine != "" {
+			spec.MachineType = string(constants.MACHINE_TYPE_PC)
+			if strings.Contains(domainXML.OS.Type.Machine, "q35") {
+				spec.MachineType = string(constants.MACHINE_TYPE_Q35)
+			}
+		}
+	}
+	if domainXML.Devices != nil {
+		spec.TPM = len(domainXML.Devices.TPMs) > 0
+		for _, iface := range domainXML.Devices.Interfaces {
+			if iface.Source == nil || iface.Source.Bridge == nil {
+				continue
+			}
+			spec.BridgeNetworkInterface = iface.Source.Bridge.Bridge
+			if iface.MAC != nil {
+				spec.MACAddress = iface.MAC.Address
+			}
+			break
+		}
+	}
+	return spec
+}
+
+// SetVirtualMachineMetadata records labels and ownership in the metadata of a VM, in its
+// persistent definition and, while it runs, in the live domain. Metadata of other applications
+// is kept.
+func (m *Manager) SetVirtualMachineMetadata(ctx context.Context, hypervisor dependencies.HypervisorContext, name string, labels map[string]string, ownership parameters.Ownership) error {
+	metadata, err := NewDomainMetadata(parameters.CreateVM{Labels: labels, Ownership: ownership}).Render()
+	if err != nil {
+		return err
+	}
+
+	domain, err := m.lookupDomain(hypervisor, name)
+	if err != nil {
+		return fmt.Errorf("could not look up VM by name: %w", err)
+	}
+	defer domain.Close()
+
+	active, err := domain.IsActive()
+	if err != nil {
+		return fmt.Errorf("could not get VM state: %w", err)
+	}
+	flags := libvirt.DOMAIN_AFFECT_CONFIG
+	if active {
+		flags |= libvirt.DOMAIN_AFFECT_LIVE
+	}
+
+	err = m.retry(hypervisor, "set metadata", func() error {
+		return domain.SetMetadata(libvirt.DOMAIN_METADATA_ELEMENT, metadata, metadataKey, MetadataNamespace, flags)
+	})
+	if err != nil {
+		return fmt.Errorf("could not set metadata of VM %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/errdefs"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/cpuset"
+	"github.com/terabiome/homonculus/pkg/units"
+	"libvirt.org/go/libvirt"
+)
+
+// autoPin computes a pinning layout for a VM with tuning.auto_pin: every vCPU is pinned to its own
+// host CPU on a single NUMA node, one further CPU of that node is reserved for emulator and I/O threads,
+// and CPUs already pinned by other domains on the host are avoided. Memory is bound to the chosen node.
+func (m *Manager) autoPin(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.CreateVM) (*parameters.VMTuning, error) {
+	tuning := params.Tuning
+	if len(tuning.VCPUPins) > 0 || tuning.EmulatorCPUSet != "" || len(tuning.IOThreadPins) > 0 {
+		return nil, fmt.Errorf("auto_pin cannot be combined with vcpu_pins, emulator_cpuset or iothread_pins")
+	}
+
+	numaNodes, err := m.GetNUMATopology(ctx, hypervisor)
+	if err != nil {
+		return nil, fmt.Errorf("could not load host topology: %w", err)
+	}
+	if len(numaNodes) == 0 {
+		return nil, fmt.Errorf("auto_pin requires NUMA topology but host %s reports none", hypervisor.Host)
+	}
+
+	vcpuPins, threadPins, err := m.pinnedHostCPUs(hypervisor, params.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	required := params.VCPUCount + 1
+	memoryKiB := units.MiBToKiB(uint64(params.MemoryMB))
+
+	var best *parameters.NUMANode
+	var bestFree []parameters.HostCPU
+	for i := range numaNodes {
+		node := &numaNodes[i]
+		if node.FreeMemoryKiB > 0 && node.FreeMemoryKiB < memoryKiB {
+			continue
+		}
+
+		var free []parameters.HostCPU
+		for _, cpu := range node.CPUs {
+			_, vcpuPinned := vcpuPins[cpu.ID]
+			_, threadPinned := threadPins[cpu.ID]
+			if !vcpuPinned && !threadPinned {
+				free = append(free, cpu)
+			}
+		}
+		if len(free) < required {
+			continue
+		}
+
+		// Best fit keeps larger nodes available for larger VMs.
+		if best == nil || len(free) < len(bestFree) ||
+			(len(free) == len(bestFree) && node.FreeMemoryKiB > best.FreeMemoryKiB) {
+			best, bestFree = node, free
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("auto_pin: no NUMA node on host %s has %d unpinned CPUs and %d MiB free memory for VM %s",
+			hypervisor.Host, required, params.MemoryMB, params.Name)
+	}
+
+	// Keep hyperthread siblings adjacent so consecutive vCPUs share physical cores.
+	sort.SliceStable(bestFree, func(i, j int) bool {
+		a, b := bestFree[i], bestFree[j]
+		if a.SocketID != b.SocketID {
+			return a.SocketID < b.SocketID
+		}
+		if a.CoreID != b.CoreID {
+			return a.CoreID < b.CoreID
+		}
+		return a.ID < b.ID
+	})
+
+	result := &parameters.VMTuning{
+		AutoPin:        true,
+		Hugepages:      tuning.Hugepages,
+		VCPUPins:       make([]string, params.VCPUCount),
+		EmulatorCPUSet: cpuset.Format([]int{bestFree[params.VCPUCount].ID}),
+		IOThreads:      tuning.IOThreads,
+		NUMAMemory: &parameters.NUMAMemory{
+			Nodeset: cpuset.Format([]int{best.ID}),
+			Mode:    "strict",
+		},
+	}
+	for i := 0; i < params.VCPUCount; i++ {
+		result.VCPUPins[i] = cpuset.Format([]int{bestFree[i].ID})
+	}
+	for i := 0; i < tuning.IOThreads; i++ {
+		result.IOThreadPins = append(result.IOThreadPins, result.EmulatorCPUSet)
+	}
+	if tuning.NUMAMemory != nil && tuning.NUMAMemory.Mode != "" {
+		result.NUMAMemory.Mode = tuning.NUMAMemory.Mode
+	}
+
+	m.logger.Info("computed automatic CPU pinning",
+		slog.String("vm", params.Name),
+		slog.Int("numa_node", best.ID),
+		slog.Any("vcpu_pins", result.VCPUPins),
+		slog.String("emulator_cpuset", result.EmulatorCPUSet),
+	)
+
+	return result, nil
+}
+
+// pinnedHostCPUs returns the host CPUs pinned by the vCPUs, and those pinned by the emulator and I/O
+// threads, of every domain on the host except the named one, each mapped to a domain pinning it.
+func (m *Manager) pinnedHostCPUs(hypervisor dependencies.HypervisorContext, exclude string) (vcpus, threads map[int]string, err error) {
+	domains, err := m.listDomains(hypervisor, libvirt.CONNECT_LIST_DOMAINS_ACTIVE|libvirt.CONNECT_LIST_DOMAINS_INACTIVE)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not list domains: %w", err)
+	}
+	defer domains.Close()
+
+	vcpus = make(map[int]string)
+	threads = make(map[int]string)
+	for _, domain := range domains {
+		domainXML, err := m.ToLibvirtXML(&domain)
+		if err != nil {
+			m.logger.Warn("could not read domain XML", slog.String("error", err.Error()))
+			continue
+		}
+		if domainXML.Name == exclude || domainXML.CPUTune == nil {
+			continue
+		}
+
+		for _, pin := range domainXML.CPUTune.VCPUPin {
+			m.collectPinned(vcpus, domainXML.Name, pin.CPUSet)
+		}
+		if domainXML.CPUTune.EmulatorPin != nil {
+			m.collectPinned(threads, domainXML.Name, domainXML.CPUTune.EmulatorPin.CPUSet)
+		}
+		for _, pin := range domainXML.CPUTune.IOThreadPin {
+			m.collectPinned(threads, domainXML.Name, pin.CPUSet)
+		}
+	}
+
+	return vcpus, threads, nil
+}
+
+// collectPinned records the CPUs of a cpuset pinned by the named domain.
+func (m *Manager) collectPinned(pinned map[int]string, name, set string) {
+	ids, err := cpuset.Parse(set)
+	if err != nil {
+		m.logger.Warn("ignoring unparsable cpuset", slog.String("vm", name), slog.String("cpuset", set))
+		return
+	}
+	for _, id := range ids {
+		pinned[id] = name
+	}
+}
+
+// checkPinConflicts finds the host CPUs a VM pins that other domains on the host already pin:
+// vCPU pins must not overlap any pin, while emulator and I/O threads may share CPUs with the
+// threads of other domains. Overlaps are logged, or rejected with ErrPinConflict when the manager is strict.
+func (m *Manager) checkPinConflicts(hypervisor dependencies.HypervisorContext, params parameters.CreateVM) error {
+	tuning := params.Tuning
+	if tuning == nil || (len(tuning.VCPUPins) == 0 && tuning.EmulatorCPUSet == "" && len(tuning.IOThreadPins) == 0) {
+		return nil
+	}
+
+	vcpuPins, threadPins, err := m.pinnedHostCPUs(hypervisor, params.Name)
+	if err != nil {
+		return err
+	}
+
+	conflicts := make(map[string]map[int]bool)
+	addConflicts := func(set string, pinned ...map[int]string) {
+		// The sets were validated against the host topology already.
+		ids, _ := cpuset.Parse(set)
+		for _, id := range ids {
+			for _, owners := range pinned {
+				if owner, ok := owners[id]; ok {
+					if conflicts[owner] == nil {
+						conflicts[owner] = make(map[int]bool)
+					}
+					conflicts[owner][id] = true
+				}
+			}
+		}
+	}
+	for _, set := range tuning.VCPUPins {
+		addConflicts(set, vcpuPins, threadPins)
+	}
+	if tuning.EmulatorCPUSet != "" {
+		addConflicts(tuning.EmulatorCPUSet, vcpuPins)
+	}
+	for _, set := range tuning.IOThreadPins {
+		addConflicts(set, vcpuPins)
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	owners := make([]string, 0, len(conflicts))
+	for owner := range conflicts {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+	overlaps := make([]string, 0, len(owners))
+	for _, owner := range owners {
+		overlaps = append(overlaps, fmt.Sprintf("CPUs %s with VM %s", cpuset.Format(sortedIDs(conflicts[owner])), owner))
+	}
+	description := strings.Join(overlaps, "; ")
+
+	if m.failOnPinConflict {
+		return fmt.Errorf("%w: VM %s overlaps %s on host %s", errdefs.ErrPinConflict, params.Name, description, hypervisor.Host)
+	}
+	m.logger.Warn("CPU pins overlap other VMs",
+		slog.String("vm", params.Name),
+		slog.String("overlaps", description),
+	)
+	return nil
+}
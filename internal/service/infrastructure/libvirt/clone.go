@@ -0,0 +1,162 @@
+package libvirt
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+
+	"libvirt.org/go/libvirtxml"
+)
+
+// CopyDomainXML returns a deep copy of a domain definition, so clones never share
+// device slices with the base definition or with each other.
+func CopyDomainXML(domainXML libvirtxml.Domain) (libvirtxml.Domain, error) {
+	serialized, err := domainXML.Marshal()
+	if err != nil {
+		return libvirtxml.Domain{}, fmt.Errorf("could not serialize Libvirt XML to string: %w", err)
+	}
+	copied := libvirtxml.Domain{}
+	if err := copied.Unmarshal(serialized); err != nil {
+		return libvirtxml.Domain{}, fmt.Errorf("could not parse domain XML: %w", err)
+	}
+	return copied, nil
+}
+
+// DropCPUPins removes the vCPU, emulator and I/O thread pins of a domain definition and keeps
+// its other CPU tuning. A clone taking over the pins of its base would share their host CPUs.
+func DropCPUPins(domainXML *libvirtxml.Domain) {
+	if domainXML.CPUTune == nil {
+		return
+	}
+	tune := *domainXML.CPUTune
+	tune.VCPUPin = nil
+	tune.EmulatorPin = nil
+	tune.IOThreadPin = nil
+	domainXML.CPUTune = &tune
+}
+
+// RandomMAC returns a random MAC address in the locally administered range used by QEMU.
+func RandomMAC() (string, error) {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("could not generate MAC address: %w", err)
+	}
+	return fmt.Sprintf("52:54:00:%02x:%02x:%02x", suffix[0], suffix[1], suffix[2]), nil
+}
+
+// regenerateIdentity replaces everything in a cloned definition that identifies the base VM:
+// interface MACs, the SMBIOS system UUID and serial, the NVRAM file and fixed console ports.
+// Passed-through host devices can only belong to one VM and are dropped.
+func (m *Manager) regenerateIdentity(domainXML *libvirtxml.Domain) error {
+	if domainXML.Devices != nil {
+		for i := range domainXML.Devices.Interfaces {
+			mac, err := RandomMAC()
+			if err != nil {
+				return err
+			}
+			domainXML.Devices.Interfaces[i].MAC = &libvirtxml.DomainInterfaceMAC{Address: mac}
+		}
+
+		for _, graphic := range domainXML.Devices.Graphics {
+			if graphic.VNC != nil && graphic.VNC.Port > 0 {
+				graphic.VNC.Port = 0
+				graphic.VNC.AutoPort = "yes"
+			}
+			if graphic.Spice != nil && (graphic.Spice.Port > 0 || graphic.Spice.TLSPort > 0) {
+				graphic.Spice.Port = 0
+				graphic.Spice.TLSPort = 0
+				graphic.Spice.AutoPort = "yes"
+			}
+		}
+
+		if len(domainXML.Devices.Hostdevs) > 0 {
+			m.logger.Warn("dropping passed-through host devices from clone",
+				slog.String("vm", domainXML.Name),
+				slog.Int("devices", len(domainXML.Devices.Hostdevs)),
+			)
+			domainXML.Devices.Hostdevs = nil
+		}
+	}
+
+	for _, sysInfo := range domainXML.SysInfo {
+		if sysInfo.SMBIOS == nil || sysInfo.SMBIOS.System == nil {
+			continue
+		}
+		entries := sysInfo.SMBIOS.System.Entry[:0]
+		for _, entry := range sysInfo.SMBIOS.System.Entry {
+			switch entry.Name {
+			case "uuid":
+				entry.Value = domainXML.UUID
+			case "serial":
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		sysInfo.SMBIOS.System.Entry = entries
+	}
+
+	// Without a path libvirt creates a fresh variable store for the clone from the template.
+	if domainXML.OS != nil && domainXML.OS.NVRam != nil {
+		domainXML.OS.NVRam.NVRam = ""
+		domainXML.OS.NVRam.Source = nil
+	}
+
+	return nil
+}
+
+// ReplaceCloudInitISO points the cloud-init CD-ROM of a cloned definition at the clone's own ISO,
+// or removes it when the clone has none.
+func ReplaceCloudInitISO(domainXML *libvirtxml.Domain, isoPath string) {
+	if domainXML.Devices == nil {
+		return
+	}
+
+	disks := domainXML.Devices.Disks[:0]
+	for _, disk := range domainXML.Devices.Disks {
+		if isCloudInitCDROM(disk) {
+			if isoPath == "" {
+				continue
+			}
+			disk.Source.File.File = isoPath
+		}
+		disks = append(disks, disk)
+	}
+	domainXML.Devices.Disks = disks
+}
+
+// BaseDiskPath returns the primary disk file of a definition, which clones use as their backing file.
+func BaseDiskPath(domainXML libvirtxml.Domain) string {
+	if domainXML.Devices == nil {
+		return ""
+	}
+	for _, disk := range domainXML.Devices.Disks {
+		if disk.Device == "disk" && disk.Source != nil && disk.Source.File != nil {
+			return disk.Source.File.File
+		}
+	}
+	return ""
+}
+
+// isCloudInitCDROM reports whether disk is the file-backed cloud-init CD-ROM; extra CD-ROM drives keep their media.
+func isCloudInitCDROM(disk libvirtxml.DomainDisk) bool {
+	return disk.Device == "cdrom" && disk.Target != nil && disk.Target.Dev == CloudInitTarget &&
+		disk.Source != nil && disk.Source.File != nil
+}
+
+// HasCloudInitISO reports whether a definition carries a file-backed cloud-init CD-ROM.
+func HasCloudInitISO(domainXML libvirtxml.Domain) bool {
+	return CloudInitISOPath(domainXML) != ""
+}
+
+// CloudInitISOPath returns the file of the cloud-init CD-ROM of a definition, or "" if it has none.
+func CloudInitISOPath(domainXML libvirtxml.Domain) string {
+	if domainXML.Devices == nil {
+		return ""
+	}
+	for _, disk := range domainXML.Devices.Disks {
+		if isCloudInitCDROM(disk) {
+			return disk.Source.File.File
+		}
+	}
+	return ""
+}
@@ -0,0 +1,107 @@
+package libvirt
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/errdefs"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/constants"
+	"github.com/terabiome/homonculus/pkg/units"
+	"libvirt.org/go/libvirtxml"
+)
+
+// createContainer defines an LXC system container booting the init system of its root filesystem.
+func (m *Manager) createContainer(hypervisor dependencies.HypervisorContext, params parameters.CreateVM, virtualMachineUUID uuid.UUID) error {
+	domainXML, err := m.renderContainerXML(hypervisor, params, virtualMachineUUID, false)
+	if err != nil {
+		return err
+	}
+
+	if err := m.defineDomain(hypervisor, domainXML); err != nil {
+		return fmt.Errorf("could not define container from Libvirt XML: %w", err)
+	}
+	m.logger.Info("defined container in libvirt", slog.String("vm", params.Name))
+
+	return nil
+}
+
+// renderContainerXML renders the domain XML of a container. The domain type only exists on LXC
+// connections, so the host must be configured with one. A preview records no canary rendering.
+func (m *Manager) renderContainerXML(hypervisor dependencies.HypervisorContext, params parameters.CreateVM, virtualMachineUUID uuid.UUID, preview bool) (string, error) {
+	driver, err := hypervisor.Conn.GetType()
+	if err != nil {
+		return "", fmt.Errorf("could not get hypervisor driver: %w", err)
+	}
+	if driver != "LXC" {
+		return "", fmt.Errorf("%w: containers need an LXC hypervisor such as lxc:///, host %s uses %s",
+			errdefs.ErrNotSupported, hypervisor.Host, driver)
+	}
+
+	if !m.engine.HasTemplate(constants.TemplateLibvirtContainer) {
+		return "", fmt.Errorf("%w: no libvirt_container_template is configured", errdefs.ErrNotSupported)
+	}
+
+	lifecycle, err := resolveLifecycle(params)
+	if err != nil {
+		return "", err
+	}
+
+	metadata, err := NewDomainMetadata(params).Render()
+	if err != nil {
+		return "", err
+	}
+
+	bindMounts := make([]ContainerBindMount, 0, len(params.HostBindMounts))
+	for _, mount := range params.HostBindMounts {
+		bindMounts = append(bindMounts, ContainerBindMount{
+			SourceDir: mount.SourceDir,
+			TargetDir: mount.TargetDir,
+			ReadOnly:  mount.ReadOnly,
+		})
+	}
+
+	vars := ContainerTemplateVars{
+		Name:                   params.Name,
+		UUID:                   virtualMachineUUID,
+		MemoryKiB:              units.MiBToKiB(params.MemoryMB),
+		VCPUCount:              params.VCPUCount,
+		RootfsPath:             params.DiskPath,
+		BridgeNetworkInterface: params.BridgeNetworkInterface,
+		MACAddress:             params.MACAddress,
+		HostBindMounts:         bindMounts,
+		Lifecycle:              lifecycle,
+		Metadata:               metadata,
+	}
+
+	bytes, err := m.engine.RenderToBytes(constants.TemplateLibvirtContainer, vars)
+	if err != nil {
+		return "", fmt.Errorf("could not create Libvirt XML in memory: %w", err)
+	}
+	if !preview {
+		m.engine.Canary(params.Name, constants.TemplateLibvirtContainer, vars)
+	}
+	m.logger.Debug("rendered libvirt container XML", slog.String("vm", params.Name))
+
+	return string(bytes), nil
+}
+
+// ContainerRootfs returns the cleaned root filesystem directory of a container domain,
+// or "" for other domains.
+func ContainerRootfs(domainXML libvirtxml.Domain) string {
+	if domainXML.Type != "lxc" || domainXML.Devices == nil {
+		return ""
+	}
+	for _, filesystem := range domainXML.Devices.Filesystems {
+		if filesystem.Target == nil || filesystem.Target.Dir != "/" {
+			continue
+		}
+		if filesystem.Source != nil && filesystem.Source.Mount != nil && filesystem.Source.Mount.Dir != "" {
+			return filepath.Clean(filesystem.Source.Mount.Dir)
+		}
+	}
+	return ""
+}
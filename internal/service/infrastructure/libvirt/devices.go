@@ -0,0 +1,313 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"libvirt.org/go/libvirt"
+	"libvirt.org/go/libvirtxml"
+)
+
+// firstExtraSerialPort is the first serial port available to extra serial devices; port 0 is the console.
+const firstExtraSerialPort = 1
+
+// usbDeviceXML builds the hostdev definition of a USB device selected by vendor:product id.
+func usbDeviceXML(device parameters.USBDevice) (string, error) {
+	vendorID, productID, err := parseUSBID(device.VendorProduct)
+	if err != nil {
+		return "", err
+	}
+
+	hostdev := libvirtxml.DomainHostdev{
+		Managed: "yes",
+		SubsysUSB: &libvirtxml.DomainHostdevSubsysUSB{
+			Source: &libvirtxml.DomainHostdevSubsysUSBSource{
+				Vendor:  &libvirtxml.DomainHostDevProductVendorID{ID: "0x" + vendorID},
+				Product: &libvirtxml.DomainHostDevProductVendorID{ID: "0x" + productID},
+			},
+		},
+	}
+	return hostdev.Marshal()
+}
+
+// serialDeviceXML builds the definition of a serial device on the given guest port.
+func serialDeviceXML(device parameters.SerialDevice, port uint) (string, error) {
+	source := &libvirtxml.DomainChardevSource{}
+	switch device.Type {
+	case "", "pty":
+		source.Pty = &libvirtxml.DomainChardevSourcePty{}
+	case "tcp":
+		if device.Port <= 0 {
+			return "", fmt.Errorf("serial device of type tcp requires a port")
+		}
+		host := device.Host
+		if host == "" {
+			host = "127.0.0.1"
+		}
+		source.TCP = &libvirtxml.DomainChardevSourceTCP{Mode: "bind", Host: host, Service: strconv.Itoa(device.Port)}
+	case "unix":
+		if device.Path == "" {
+			return "", fmt.Errorf("serial device of type unix requires a path")
+		}
+		source.UNIX = &libvirtxml.DomainChardevSourceUNIX{Mode: "bind", Path: device.Path}
+	case "file":
+		if device.Path == "" {
+			return "", fmt.Errorf("serial device of type file requires a path")
+		}
+		source.File = &libvirtxml.DomainChardevSourceFile{Path: device.Path}
+	case "dev":
+		if device.Path == "" {
+			return "", fmt.Errorf("serial device of type dev requires a path")
+		}
+		source.Dev = &libvirtxml.DomainChardevSourceDev{Path: device.Path}
+	default:
+		return "", fmt.Errorf("invalid serial device type '%s': must be 'pty', 'tcp', 'unix', 'file' or 'dev'", device.Type)
+	}
+
+	serial := libvirtxml.DomainSerial{
+		Source: source,
+		Target: &libvirtxml.DomainSerialTarget{Port: &port},
+	}
+	if device.Type == "tcp" {
+		serial.Protocol = &libvirtxml.DomainChardevProtocol{Type: "raw"}
+	}
+	return serial.Marshal()
+}
+
+// parseUSBID splits and normalizes a "vvvv:pppp" USB id.
+func parseUSBID(value string) (string, string, error) {
+	vendorID, productID, found := strings.Cut(value, ":")
+	vendorID, productID = normalizePCIID(vendorID), normalizePCIID(productID)
+	if !found || len(vendorID) != 4 || len(productID) != 4 {
+		return "", "", fmt.Errorf("invalid USB id %q: expected vvvv:pppp", value)
+	}
+	return vendorID, productID, nil
+}
+
+// checkUSBDevicePresent verifies that exactly one USB device with the vendor:product id is plugged into the host.
+func (m *Manager) checkUSBDevicePresent(hypervisor dependencies.HypervisorContext, device parameters.USBDevice) error {
+	vendorID, productID, err := parseUSBID(device.VendorProduct)
+	if err != nil {
+		return err
+	}
+
+	nodeDevices, err := hypervisor.Conn.ListAllNodeDevices(libvirt.CONNECT_LIST_NODE_DEVICES_CAP_USB_DEV)
+	if err != nil {
+		return fmt.Errorf("could not list host USB devices: %w", err)
+	}
+
+	matches := 0
+	for _, nodeDevice := range nodeDevices {
+		xmlString, err := nodeDevice.GetXMLDesc(0)
+		nodeDevice.Free()
+		if err != nil {
+			continue
+		}
+
+		nodeDeviceXML := libvirtxml.NodeDevice{}
+		if err := nodeDeviceXML.Unmarshal(xmlString); err != nil || nodeDeviceXML.Capability.USBDevice == nil {
+			continue
+		}
+		usb := nodeDeviceXML.Capability.USBDevice
+		if normalizePCIID(usb.Vendor.ID) == vendorID && normalizePCIID(usb.Product.ID) == productID {
+			matches++
+		}
+	}
+
+	switch {
+	case matches == 0:
+		return fmt.Errorf("USB device %s is not plugged into host %s", device.VendorProduct, hypervisor.Host)
+	case matches > 1:
+		return fmt.Errorf("USB device %s matches %d devices on host %s; passthrough by vendor:product needs a unique device", device.VendorProduct, matches, hypervisor.Host)
+	}
+	return nil
+}
+
+// buildExtraDevices validates and renders the USB, extra serial, extra CD-ROM and watchdog devices of a new VM.
+func (m *Manager) buildExtraDevices(hypervisor dependencies.HypervisorContext, params parameters.CreateVM) ([]string, error) {
+	var devices []string
+
+	for i, device := range params.USBDevices {
+		if err := m.checkUSBDevicePresent(hypervisor, device); err != nil {
+			return nil, fmt.Errorf("usb_devices[%d]: %w", i, err)
+		}
+		deviceXML, err := usbDeviceXML(device)
+		if err != nil {
+			return nil, fmt.Errorf("usb_devices[%d]: %w", i, err)
+		}
+		devices = append(devices, deviceXML)
+	}
+
+	for i, device := range params.SerialDevices {
+		deviceXML, err := serialDeviceXML(device, uint(firstExtraSerialPort+i))
+		if err != nil {
+			return nil, fmt.Errorf("serial_devices[%d]: %w", i, err)
+		}
+		devices = append(devices, deviceXML)
+	}
+
+	if len(params.CDROMs) > MaxCDROMs {
+		return nil, fmt.Errorf("at most %d extra CD-ROM drives are supported, got %d", MaxCDROMs, len(params.CDROMs))
+	}
+	for i, cdrom := range params.CDROMs {
+		deviceXML, err := cdromXML(CDROMTarget(i), cdrom.Path)
+		if err != nil {
+			return nil, fmt.Errorf("cdroms[%d]: %w", i, err)
+		}
+		devices = append(devices, deviceXML)
+	}
+
+	if params.Watchdog != nil {
+		deviceXML, err := watchdogXML(*params.Watchdog)
+		if err != nil {
+			return nil, fmt.Errorf("watchdog: %w", err)
+		}
+		devices = append(devices, deviceXML)
+	}
+
+	return devices, nil
+}
+
+// deviceModifyFlags applies a device change to the persistent config, and to the live domain if it runs.
+func deviceModifyFlags(domain *libvirt.Domain) (libvirt.DomainDeviceModifyFlags, error) {
+	active, err := domain.IsActive()
+	if err != nil {
+		return 0, fmt.Errorf("could not get VM state: %w", err)
+	}
+
+	flags := libvirt.DOMAIN_DEVICE_MODIFY_CONFIG
+	if active {
+		flags |= libvirt.DOMAIN_DEVICE_MODIFY_LIVE
+	}
+	return flags, nil
+}
+
+// AttachDevices hotplugs USB and serial devices into a VM and persists them in its definition.
+// Serial devices are returned with the guest port they were assigned.
+func (m *Manager) AttachDevices(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.AttachDevices) ([]int, error) {
+	domain, err := m.lookupDomain(hypervisor, params.Name)
+	if err != nil {
+		return nil, fmt.Errorf("could not look up VM by name: %w", err)
+	}
+	defer domain.Close()
+
+	flags, err := deviceModifyFlags(domain.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, device := range params.USBDevices {
+		if err := m.checkUSBDevicePresent(hypervisor, device); err != nil {
+			return nil, err
+		}
+		deviceXML, err := usbDeviceXML(device)
+		if err != nil {
+			return nil, err
+		}
+		if err := domain.AttachDeviceFlags(deviceXML, flags); err != nil {
+			return nil, fmt.Errorf("could not attach USB device %s: %w", device.VendorProduct, err)
+		}
+		m.logger.Info("attached USB device", slog.String("vm", params.Name), slog.String("device", device.VendorProduct))
+	}
+
+	var ports []int
+	for _, device := range params.SerialDevices {
+		domainXML, err := m.ToLibvirtXML(domain.Domain)
+		if err != nil {
+			return ports, err
+		}
+		port := nextSerialPort(domainXML)
+
+		deviceXML, err := serialDeviceXML(device, port)
+		if err != nil {
+			return ports, err
+		}
+		if err := domain.AttachDeviceFlags(deviceXML, flags); err != nil {
+			return ports, fmt.Errorf("could not attach serial device on port %d: %w", port, err)
+		}
+		ports = append(ports, int(port))
+		m.logger.Info("attached serial device", slog.String("vm", params.Name), slog.Int("port", int(port)))
+	}
+
+	return ports, nil
+}
+
+// DetachDevices hot-unplugs USB devices and serial ports from a VM and removes them from its definition.
+func (m *Manager) DetachDevices(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.DetachDevices) error {
+	domain, err := m.lookupDomain(hypervisor, params.Name)
+	if err != nil {
+		return fmt.Errorf("could not look up VM by name: %w", err)
+	}
+	defer domain.Close()
+
+	flags, err := deviceModifyFlags(domain.Domain)
+	if err != nil {
+		return err
+	}
+
+	for i, device := range params.USBDevices {
+		deviceXML, err := usbDeviceXML(device)
+		if err != nil {
+			return fmt.Errorf("usb_devices[%d]: %w", i, err)
+		}
+		if err := domain.DetachDeviceFlags(deviceXML, flags); err != nil {
+			return fmt.Errorf("could not detach USB device %s: %w", device.VendorProduct, err)
+		}
+		m.logger.Info("detached USB device", slog.String("vm", params.Name), slog.String("device", device.VendorProduct))
+	}
+
+	if len(params.SerialPorts) == 0 {
+		return nil
+	}
+
+	domainXML, err := m.ToLibvirtXML(domain.Domain)
+	if err != nil {
+		return err
+	}
+	for _, port := range params.SerialPorts {
+		if port < firstExtraSerialPort {
+			return fmt.Errorf("serial port %d is the console and cannot be detached", port)
+		}
+
+		serial := findSerialPort(domainXML, uint(port))
+		if serial == nil {
+			return fmt.Errorf("VM %s has no serial device on port %d", params.Name, port)
+		}
+		deviceXML, err := serial.Marshal()
+		if err != nil {
+			return fmt.Errorf("could not serialize serial device: %w", err)
+		}
+		if err := domain.DetachDeviceFlags(deviceXML, flags); err != nil {
+			return fmt.Errorf("could not detach serial device on port %d: %w", port, err)
+		}
+		m.logger.Info("detached serial device", slog.String("vm", params.Name), slog.Int("port", port))
+	}
+
+	return nil
+}
+
+// nextSerialPort returns the lowest free guest serial port above the console.
+func nextSerialPort(domainXML libvirtxml.Domain) uint {
+	port := uint(firstExtraSerialPort)
+	for findSerialPort(domainXML, port) != nil {
+		port++
+	}
+	return port
+}
+
+func findSerialPort(domainXML libvirtxml.Domain, port uint) *libvirtxml.DomainSerial {
+	if domainXML.Devices == nil {
+		return nil
+	}
+	for i, serial := range domainXML.Devices.Serials {
+		if serial.Target != nil && serial.Target.Port != nil && *serial.Target.Port == port {
+			return &domainXML.Devices.Serials[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/executor/fileops"
+	"github.com/terabiome/homonculus/pkg/executor/qemuimg"
+	"libvirt.org/go/libvirt"
+	"libvirt.org/go/libvirtxml"
+)
+
+// domainDiskFiles returns the cleaned source paths of the file-backed disks of a domain.
+func domainDiskFiles(domainXML libvirtxml.Domain) []string {
+	var files []string
+	for _, disk := range domainXML.Devices.Disks {
+		if disk.Source == nil || disk.Source.File == nil || disk.Source.File.File == "" {
+			continue
+		}
+		files = append(files, filepath.Clean(disk.Source.File.File))
+	}
+	return files
+}
+
+// removableDisk reports whether a disk file of a VM may be deleted: no other domain uses it,
+// directly or as a backing file, and it lies within the allowed paths. Kept disks are logged.
+func (m *Manager) removableDisk(vmName, path string, references parameters.DiskReferences) bool {
+	if user := otherDiskUser(references, vmName, path); user != "" {
+		m.logger.Warn("keeping disk used by another VM",
+			slog.String("vm", vmName),
+			slog.String("path", path),
+			slog.String("used_by", user),
+		)
+		return false
+	}
+
+	// Domains may be edited outside homonculus, so their disk paths are not trusted.
+	if err := m.paths.Check(path); err != nil {
+		m.logger.Warn("keeping disk outside allowed paths",
+			slog.String("vm", vmName),
+			slog.String("path", path),
+		)
+		return false
+	}
+	return true
+}
+
+// RemoveDisk deletes the disk, or container root filesystem, of a VM whose domain is not defined,
+// such as one whose creation was interrupted. Like DeleteVirtualMachine, it keeps files that
+// defined domains use and files outside the allowed paths.
+func (m *Manager) RemoveDisk(ctx context.Context, hypervisor dependencies.HypervisorContext, vmName, path string, container bool) error {
+	path = filepath.Clean(path)
+	references, err := m.DiskReferences(ctx, hypervisor)
+	if err != nil {
+		return fmt.Errorf("could not determine disks used by other VMs: %w", err)
+	}
+	if !m.removableDisk(vmName, path, references) {
+		return nil
+	}
+	if container {
+		return fileops.RemoveDirectory(ctx, hypervisor.Executor, path)
+	}
+	return fileops.RemoveFile(ctx, hypervisor.Executor, path)
+}
+
+// DiskReferences returns every file used by defined domains: their disk sources and the
+// backing chains of those disks. Reading the chains runs qemu-img on every disk, so callers
+// deleting several VMs build it once and pass it to each DeleteVirtualMachine.
+func (m *Manager) DiskReferences(ctx context.Context, hypervisor dependencies.HypervisorContext) (parameters.DiskReferences, error) {
+	domains, err := m.listDomains(hypervisor, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not list domains: %w", err)
+	}
+	defer domains.Close()
+
+	references := make(parameters.DiskReferences)
+	for _, domain := range domains {
+		// A cancelled scan would under-report references, so it must not be treated as complete.
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		name, err := domain.GetName()
+		if err != nil {
+			return nil, fmt.Errorf("could not get domain name: %w", err)
+		}
+		xmlDesc, err := domain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE)
+		if err != nil {
+			return nil, fmt.Errorf("could not read domain XML of %s: %w", name, err)
+		}
+		domainXML := libvirtxml.Domain{}
+		if err := domainXML.Unmarshal(xmlDesc); err != nil {
+			return nil, fmt.Errorf("could not parse domain XML of %s: %w", name, err)
+		}
+
+		for _, file := range domainDiskFiles(domainXML) {
+			references[file] = append(references[file], name)
+
+			chain, err := qemuimg.BackingChain(ctx, hypervisor.Executor, file)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				// Missing or unreadable images have no chain to protect.
+				m.logger.Debug("could not read backing chain",
+					slog.String("vm", name),
+					slog.String("path", file),
+					slog.String("error", err.Error()),
+				)
+				continue
+			}
+			for _, backing := range chain {
+				references[backing] = append(references[backing], name)
+			}
+		}
+	}
+	return references, nil
+}
+
+// otherDiskUser returns a VM other than vmName that references lists for path, or "".
+func otherDiskUser(references parameters.DiskReferences, vmName, path string) string {
+	for _, user := range references[path] {
+		if user != vmName {
+			return user
+		}
+	}
+	return ""
+}
+
+// forgetDiskUser removes a deleted VM from references.
+func forgetDiskUser(references parameters.DiskReferences, vmName string) {
+	for path, users := range references {
+		users = slices.DeleteFunc(users, func(user string) bool { return user == vmName })
+		if len(users) == 0 {
+			delete(references, path)
+			continue
+		}
+		references[path] = users
+	}
+}
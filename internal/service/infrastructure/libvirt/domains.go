@@ -0,0 +1,121 @@
+package libvirt
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/errdefs"
+	"libvirt.org/go/libvirt"
+	"libvirt.org/go/libvirtxml"
+)
+
+// Every domain handle obtained from libvirt holds a reference that must be released, or it leaks for
+// the lifetime of the connection. Lookups and listings go through the helpers below, and their
+// results are released with defer Close() right after the error check. The helpers retry transient
+// libvirt errors, see retry.go.
+
+// domainRef owns a libvirt domain handle until Close frees it.
+type domainRef struct {
+	*libvirt.Domain
+}
+
+// lookupDomain looks up a domain by name. Unknown domains yield an error wrapping errdefs.ErrVMNotFound.
+func (m *Manager) lookupDomain(hypervisor dependencies.HypervisorContext, name string) (*domainRef, error) {
+	var domain *libvirt.Domain
+	err := m.retry(hypervisor, "lookup domain", func() error {
+		var err error
+		domain, err = hypervisor.Conn.LookupDomainByName(name)
+		return err
+	})
+	if err != nil {
+		if hasErrorCode(err, libvirt.ERR_NO_DOMAIN) {
+			return nil, fmt.Errorf("%w: %s on host %s: %w", errdefs.ErrVMNotFound, name, hypervisor.Host, err)
+		}
+		return nil, err
+	}
+	return &domainRef{Domain: domain}, nil
+}
+
+// Close frees the domain handle. Closing a nil or already closed ref does nothing.
+func (d *domainRef) Close() {
+	if d == nil || d.Domain == nil {
+		return
+	}
+	d.Domain.Free()
+	d.Domain = nil
+}
+
+// domainList owns the domain handles of a listing until Close frees them all.
+type domainList []libvirt.Domain
+
+// listDomains lists the domains of the host matching flags.
+func (m *Manager) listDomains(hypervisor dependencies.HypervisorContext, flags libvirt.ConnectListAllDomainsFlags) (domainList, error) {
+	var domains []libvirt.Domain
+	err := m.retry(hypervisor, "list domains", func() error {
+		var err error
+		domains, err = hypervisor.Conn.ListAllDomains(flags)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return domainList(domains), nil
+}
+
+// Close frees every domain handle of the listing.
+func (l domainList) Close() {
+	for i := range l {
+		l[i].Free()
+	}
+}
+
+// defineDomain defines a persistent domain from XML and releases the returned handle.
+// A clashing domain yields an error wrapping errdefs.ErrVMExists.
+// Defining is retried, as defining the same XML again only updates the domain.
+func (m *Manager) defineDomain(hypervisor dependencies.HypervisorContext, domainXML string) error {
+	var domain *libvirt.Domain
+	err := m.retry(hypervisor, "define domain", func() error {
+		var err error
+		domain, err = hypervisor.Conn.DomainDefineXML(domainXML)
+		return err
+	})
+	if err != nil {
+		if hasErrorCode(err, libvirt.ERR_DOM_EXIST) {
+			return fmt.Errorf("%w: %w", errdefs.ErrVMExists, err)
+		}
+		return err
+	}
+	domain.Free()
+	return nil
+}
+
+// undefineDomain undefines a domain together with its UEFI variable store, its snapshot metadata
+// and, when the domain has a TPM, its TPM state. Daemons that predate the TPM flag reject it; the
+// domain is then undefined without it and the TPM state is left behind.
+func (m *Manager) undefineDomain(domain *domainRef, domainXML libvirtxml.Domain) error {
+	hasTPM := domainXML.Devices != nil && len(domainXML.Devices.TPMs) > 0
+	err := domain.UndefineFlags(undefineFlags(hasTPM))
+	if !hasTPM || !hasErrorCode(err, libvirt.ERR_INVALID_ARG) && !hasErrorCode(err, libvirt.ERR_NO_SUPPORT) {
+		return err
+	}
+	m.logger.Warn("libvirt cannot remove TPM state, keeping it", slog.String("error", err.Error()))
+	return domain.UndefineFlags(undefineFlags(false))
+}
+
+// undefineFlags returns the flags undefineDomain passes, with the TPM flag when tpm is set.
+// Snapshots block undefining a domain unless their metadata goes with it.
+func undefineFlags(tpm bool) libvirt.DomainUndefineFlagsValues {
+	flags := libvirt.DOMAIN_UNDEFINE_NVRAM | libvirt.DOMAIN_UNDEFINE_SNAPSHOTS_METADATA
+	if tpm {
+		flags |= libvirt.DOMAIN_UNDEFINE_TPM
+	}
+	return flags
+}
+
+// hasErrorCode reports whether err is a libvirt error with the given code.
+func hasErrorCode(err error, code libvirt.ErrorNumber) bool {
+	var libvirtErr libvirt.Error
+	return errors.As(err, &libvirtErr) && libvirtErr.Code == code
+}
@@ -0,0 +1,22 @@
+package libvirt
+
+import (
+	"testing"
+
+	"libvirt.org/go/libvirt"
+)
+
+func TestUndefineFlags(t *testing.T) {
+	for _, tpm := range []bool{false, true} {
+		flags := undefineFlags(tpm)
+		if flags&libvirt.DOMAIN_UNDEFINE_SNAPSHOTS_METADATA == 0 {
+			t.Errorf("undefineFlags(%v) = %#x, keeps the snapshot metadata that blocks undefining", tpm, flags)
+		}
+		if flags&libvirt.DOMAIN_UNDEFINE_NVRAM == 0 {
+			t.Errorf("undefineFlags(%v) = %#x, keeps the UEFI variable store", tpm, flags)
+		}
+		if got := flags&libvirt.DOMAIN_UNDEFINE_TPM != 0; got != tpm {
+			t.Errorf("undefineFlags(%v) = %#x, TPM flag set %v", tpm, flags, got)
+		}
+	}
+}
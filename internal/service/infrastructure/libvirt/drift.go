@@ -0,0 +1,131 @@
+package libvirt
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"libvirt.org/go/libvirtxml"
+)
+
+// CompareDomains reports the fields in which a live domain differs from the domain a spec
+// renders to: memory, vCPUs and their pins, and the disks, interfaces, host devices,
+// filesystems and graphics. Device fields are keyed by what identifies the device, such
+// as the target of a disk. A MAC address is only compared where the spec sets one, since
+// libvirt generates the others.
+func CompareDomains(spec, live libvirtxml.Domain) []parameters.DomainDrift {
+	specFields, liveFields := domainFields(spec), domainFields(live)
+	for field := range specFields {
+		if strings.HasSuffix(field, ".mac") && specFields[field] == "" {
+			delete(specFields, field)
+			delete(liveFields, field)
+		}
+	}
+
+	var fields []string
+	for field := range specFields {
+		fields = append(fields, field)
+	}
+	for field := range liveFields {
+		if _, ok := specFields[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	slices.Sort(fields)
+
+	var drift []parameters.DomainDrift
+	for _, field := range fields {
+		if specFields[field] != liveFields[field] {
+			drift = append(drift, parameters.DomainDrift{Field: field, Spec: specFields[field], Live: liveFields[field]})
+		}
+	}
+	return drift
+}
+
+// domainFields flattens the compared parts of a domain into field names and values.
+func domainFields(domainXML libvirtxml.Domain) map[string]string {
+	fields := make(map[string]string)
+	if domainXML.Memory != nil {
+		fields["memory_kib"] = strconv.FormatUint(DomainMemoryKiB(domainXML), 10)
+	}
+	if domainXML.VCPU != nil {
+		fields["vcpus"] = strconv.FormatUint(uint64(domainXML.VCPU.Value), 10)
+	}
+	if domainXML.CPUTune != nil {
+		for _, pin := range domainXML.CPUTune.VCPUPin {
+			fields[fmt.Sprintf("vcpu_pin[%d]", pin.VCPU)] = pin.CPUSet
+		}
+		if domainXML.CPUTune.EmulatorPin != nil {
+			fields["emulator_pin"] = domainXML.CPUTune.EmulatorPin.CPUSet
+		}
+	}
+
+	if domainXML.Devices == nil {
+		return fields
+	}
+	for _, disk := range domainXML.Devices.Disks {
+		if disk.Target == nil {
+			continue
+		}
+		var source string
+		if disk.Source != nil && disk.Source.File != nil {
+			source = disk.Source.File.File
+		} else if disk.Source != nil && disk.Source.Block != nil {
+			source = disk.Source.Block.Dev
+		}
+		fields[fmt.Sprintf("disk[%s]", disk.Target.Dev)] = disk.Device + " " + source
+	}
+	for i, iface := range domainXML.Devices.Interfaces {
+		key := fmt.Sprintf("interface[%d]", i)
+		if iface.Source != nil && iface.Source.Bridge != nil {
+			fields[key+".bridge"] = iface.Source.Bridge.Bridge
+		}
+		if iface.Model != nil {
+			fields[key+".model"] = iface.Model.Type
+		}
+		if iface.MAC != nil {
+			fields[key+".mac"] = strings.ToLower(iface.MAC.Address)
+		} else {
+			fields[key+".mac"] = ""
+		}
+	}
+	for _, hostdev := range domainXML.Devices.Hostdevs {
+		switch {
+		case hostdev.SubsysPCI != nil && hostdev.SubsysPCI.Source != nil && hostdev.SubsysPCI.Source.Address != nil:
+			source := hostdev.SubsysPCI.Source.Address
+			if address, ok := pciAddressFromXML(source.Domain, source.Bus, source.Slot, source.Function); ok {
+				fields["hostdev[pci "+address.String()+"]"] = "attached"
+			}
+		case hostdev.SubsysUSB != nil && hostdev.SubsysUSB.Source != nil:
+			source := hostdev.SubsysUSB.Source
+			if source.Vendor != nil && source.Product != nil {
+				fields["hostdev[usb "+normalizePCIID(source.Vendor.ID)+":"+normalizePCIID(source.Product.ID)+"]"] = "attached"
+			}
+		}
+	}
+	for _, filesystem := range domainXML.Devices.Filesystems {
+		if filesystem.Target == nil {
+			continue
+		}
+		var source string
+		if filesystem.Source != nil && filesystem.Source.Mount != nil {
+			source = filesystem.Source.Mount.Dir
+		}
+		fields["filesystem["+filesystem.Target.Dir+"]"] = source
+	}
+	var graphics []string
+	for _, graphic := range domainXML.Devices.Graphics {
+		switch {
+		case graphic.VNC != nil:
+			graphics = append(graphics, "vnc")
+		case graphic.Spice != nil:
+			graphics = append(graphics, "spice")
+		}
+	}
+	if len(graphics) > 0 {
+		fields["graphics"] = strings.Join(graphics, ",")
+	}
+	return fields
+}
@@ -0,0 +1,56 @@
+package libvirt
+
+import (
+	"fmt"
+
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/constants"
+)
+
+// firmwareVars holds the resolved boot firmware settings of a domain.
+type firmwareVars struct {
+	Firmware    string
+	SecureBoot  bool
+	NVRAMPath   string
+	MachineType string
+}
+
+// resolveFirmware validates the firmware and machine type of a VM and maps them to domain XML values.
+func resolveFirmware(params parameters.CreateVM) (firmwareVars, error) {
+	vars := firmwareVars{
+		SecureBoot: params.SecureBoot,
+		NVRAMPath:  params.NVRAMPath,
+	}
+
+	switch constants.Firmware(params.Firmware) {
+	case "", constants.FIRMWARE_BIOS:
+		if params.SecureBoot {
+			return vars, fmt.Errorf("secure_boot requires firmware '%s'", constants.FIRMWARE_UEFI)
+		}
+		if params.NVRAMPath != "" {
+			return vars, fmt.Errorf("nvram_path requires firmware '%s'", constants.FIRMWARE_UEFI)
+		}
+	case constants.FIRMWARE_UEFI:
+		vars.Firmware = "efi"
+	default:
+		return vars, fmt.Errorf("invalid firmware '%s': must be '%s' or '%s'", params.Firmware, constants.FIRMWARE_BIOS, constants.FIRMWARE_UEFI)
+	}
+
+	switch constants.MachineType(params.MachineType) {
+	case "":
+		if params.SecureBoot {
+			// Secure boot needs SMM, which QEMU only supports on q35.
+			vars.MachineType = string(constants.MACHINE_TYPE_Q35)
+		}
+	case constants.MACHINE_TYPE_Q35, constants.MACHINE_TYPE_PC:
+		vars.MachineType = params.MachineType
+	default:
+		return vars, fmt.Errorf("invalid machine_type '%s': must be '%s' or '%s'", params.MachineType, constants.MACHINE_TYPE_Q35, constants.MACHINE_TYPE_PC)
+	}
+
+	if params.SecureBoot && vars.MachineType != string(constants.MACHINE_TYPE_Q35) {
+		return vars, fmt.Errorf("secure_boot requires machine_type '%s'", constants.MACHINE_TYPE_Q35)
+	}
+
+	return vars, nil
+}
@@ -0,0 +1,182 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"libvirt.org/go/libvirt"
+	"libvirt.org/go/libvirtxml"
+)
+
+const (
+	defaultGraphicsType   = "vnc"
+	defaultGraphicsListen = "0.0.0.0"
+
+	// vncMaxPasswordLength is the longest password the VNC authentication scheme accepts.
+	vncMaxPasswordLength = 8
+)
+
+// resolveGraphics validates the graphics options of a VM and applies the defaults.
+func resolveGraphics(params parameters.CreateVM) (Graphics, error) {
+	graphics := Graphics{Type: defaultGraphicsType, Listen: defaultGraphicsListen}
+	if params.Graphics == nil {
+		return graphics, nil
+	}
+
+	switch params.Graphics.Type {
+	case "":
+	case "none", "vnc", "spice":
+		graphics.Type = params.Graphics.Type
+	default:
+		return graphics, fmt.Errorf("invalid graphics type '%s': must be 'none', 'vnc' or 'spice'", params.Graphics.Type)
+	}
+
+	if params.Graphics.Listen != "" {
+		if net.ParseIP(params.Graphics.Listen) == nil {
+			return graphics, fmt.Errorf("invalid graphics listen address '%s'", params.Graphics.Listen)
+		}
+		graphics.Listen = params.Graphics.Listen
+	}
+
+	if port := params.Graphics.Port; port != 0 && (port < 5900 || port > 65535) {
+		return graphics, fmt.Errorf("invalid graphics port %d: must be between 5900 and 65535", port)
+	}
+	graphics.Port = params.Graphics.Port
+
+	if graphics.Type == "vnc" && len(params.Graphics.Password) > vncMaxPasswordLength {
+		return graphics, fmt.Errorf("VNC passwords are limited to %d characters", vncMaxPasswordLength)
+	}
+	graphics.Password = params.Graphics.Password
+
+	return graphics, nil
+}
+
+// graphicsInfo extracts the consoles of a domain. Ports allocated with autoport
+// are only present in the live XML of a running domain.
+func graphicsInfo(domainXML libvirtxml.Domain) []parameters.GraphicsInfo {
+	if domainXML.Devices == nil {
+		return nil
+	}
+
+	var infos []parameters.GraphicsInfo
+	for _, graphic := range domainXML.Devices.Graphics {
+		switch {
+		case graphic.VNC != nil:
+			infos = append(infos, parameters.GraphicsInfo{Type: "vnc", Listen: graphic.VNC.Listen, Port: max(graphic.VNC.Port, 0)})
+		case graphic.Spice != nil:
+			infos = append(infos, parameters.GraphicsInfo{Type: "spice", Listen: graphic.Spice.Listen, Port: max(graphic.Spice.Port, 0)})
+		}
+	}
+	return infos
+}
+
+// GetConsoleAddress returns the address of the graphical console of a running VM, as reachable from
+// its hypervisor host. Consoles listening on a wildcard address are reached through loopback.
+func (m *Manager) GetConsoleAddress(ctx context.Context, hypervisor dependencies.HypervisorContext, name string) (string, error) {
+	domain, err := m.lookupDomain(hypervisor, name)
+	if err != nil {
+		return "", fmt.Errorf("could not look up VM by name: %w", err)
+	}
+	defer domain.Close()
+
+	active, err := domain.IsActive()
+	if err != nil {
+		return "", fmt.Errorf("could not get VM state: %w", err)
+	}
+	if !active {
+		return "", fmt.Errorf("VM %s is not running", name)
+	}
+
+	liveXMLString, err := domain.GetXMLDesc(0)
+	if err != nil {
+		return "", fmt.Errorf("could not read domain XML: %w", err)
+	}
+	liveXML := libvirtxml.Domain{}
+	if err := liveXML.Unmarshal(liveXMLString); err != nil {
+		return "", fmt.Errorf("could not parse domain XML: %w", err)
+	}
+
+	for _, info := range graphicsInfo(liveXML) {
+		if info.Port <= 0 {
+			continue
+		}
+		listen := info.Listen
+		if listen == "" || net.ParseIP(listen).IsUnspecified() {
+			listen = "127.0.0.1"
+		}
+		address := net.JoinHostPort(listen, strconv.Itoa(info.Port))
+		m.logger.Debug("resolved console address", slog.String("vm", name), slog.String("type", info.Type), slog.String("address", address))
+		return address, nil
+	}
+
+	return "", fmt.Errorf("VM %s has no VNC or SPICE console", name)
+}
+
+// SetGraphicsPassword sets a password on the first graphical console of a running VM that
+// expires at validTo, and returns that console. The password only lives in the running domain;
+// the persistent definition is left unchanged.
+func (m *Manager) SetGraphicsPassword(ctx context.Context, hypervisor dependencies.HypervisorContext, name, password string, validTo time.Time) (parameters.GraphicsInfo, error) {
+	domain, err := m.lookupDomain(hypervisor, name)
+	if err != nil {
+		return parameters.GraphicsInfo{}, fmt.Errorf("could not look up VM by name: %w", err)
+	}
+	defer domain.Close()
+
+	active, err := domain.IsActive()
+	if err != nil {
+		return parameters.GraphicsInfo{}, fmt.Errorf("could not get VM state: %w", err)
+	}
+	if !active {
+		return parameters.GraphicsInfo{}, fmt.Errorf("VM %s is not running", name)
+	}
+
+	liveXMLString, err := domain.GetXMLDesc(0)
+	if err != nil {
+		return parameters.GraphicsInfo{}, fmt.Errorf("could not read domain XML: %w", err)
+	}
+	liveXML := libvirtxml.Domain{}
+	if err := liveXML.Unmarshal(liveXMLString); err != nil {
+		return parameters.GraphicsInfo{}, fmt.Errorf("could not parse domain XML: %w", err)
+	}
+
+	if liveXML.Devices != nil {
+		expiry := validTo.UTC().Format("2006-01-02T15:04:05")
+		for _, graphic := range liveXML.Devices.Graphics {
+			var info parameters.GraphicsInfo
+			switch {
+			case graphic.VNC != nil:
+				graphic.VNC.Passwd = password
+				graphic.VNC.PasswdValidTo = expiry
+				info = parameters.GraphicsInfo{Type: "vnc", Listen: graphic.VNC.Listen, Port: max(graphic.VNC.Port, 0)}
+			case graphic.Spice != nil:
+				graphic.Spice.Passwd = password
+				graphic.Spice.PasswdValidTo = expiry
+				info = parameters.GraphicsInfo{Type: "spice", Listen: graphic.Spice.Listen, Port: max(graphic.Spice.Port, 0)}
+			default:
+				continue
+			}
+
+			graphicXML, err := graphic.Marshal()
+			if err != nil {
+				return parameters.GraphicsInfo{}, fmt.Errorf("could not serialize graphics device: %w", err)
+			}
+			if err := domain.UpdateDeviceFlags(graphicXML, libvirt.DOMAIN_DEVICE_MODIFY_LIVE); err != nil {
+				return parameters.GraphicsInfo{}, fmt.Errorf("could not set console password: %w", err)
+			}
+			m.logger.Info("set temporary console password",
+				slog.String("vm", name),
+				slog.String("type", info.Type),
+				slog.Time("valid_to", validTo),
+			)
+			return info, nil
+		}
+	}
+
+	return parameters.GraphicsInfo{}, fmt.Errorf("VM %s has no VNC or SPICE console", name)
+}
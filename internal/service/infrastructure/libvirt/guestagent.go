@@ -0,0 +1,233 @@
+package libvirt
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/errdefs"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"libvirt.org/go/libvirt"
+	"libvirt.org/go/libvirtxml"
+)
+
+// cloudInitResultPath is written by cloud-init once the final stage finished. It survives reboots.
+const cloudInitResultPath = "/var/lib/cloud/data/result.json"
+
+// maxGuestFileBytes bounds how much of a guest file is read through the guest agent.
+const maxGuestFileBytes = 64 * 1024
+
+const (
+	CloudInitDone    = "done"
+	CloudInitFailed  = "failed"
+	CloudInitPending = "pending"
+	CloudInitUnknown = "unknown" // the guest agent is not reachable
+)
+
+// CloudInitStatus reads the cloud-init result of a running VM through the QEMU guest agent.
+// It returns an empty status for VMs without a cloud-init ISO.
+func (m *Manager) CloudInitStatus(hypervisor dependencies.HypervisorContext, name string) (string, error) {
+	domain, err := m.lookupDomain(hypervisor, name)
+	if err != nil {
+		return CloudInitUnknown, fmt.Errorf("could not look up VM by name: %w", err)
+	}
+	defer domain.Close()
+
+	domainXML, err := m.ToLibvirtXML(domain.Domain)
+	if err != nil {
+		return CloudInitUnknown, err
+	}
+	if !HasCloudInitISO(domainXML) {
+		return "", nil
+	}
+
+	if _, err := guestAgentCommand(domain.Domain, "guest-ping", nil); err != nil {
+		return CloudInitUnknown, nil
+	}
+
+	data, found, err := readGuestFile(domain.Domain, cloudInitResultPath)
+	if err != nil {
+		return CloudInitUnknown, err
+	}
+	if !found {
+		return CloudInitPending, nil
+	}
+
+	var result struct {
+		V1 struct {
+			Errors []any `json:"errors"`
+		} `json:"v1"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return CloudInitUnknown, fmt.Errorf("failed to parse %s: %w", cloudInitResultPath, err)
+	}
+	if len(result.V1.Errors) > 0 {
+		return CloudInitFailed, nil
+	}
+	return CloudInitDone, nil
+}
+
+// guestAgentChannel is the name of the virtio channel the QEMU guest agent listens on.
+const guestAgentChannel = "org.qemu.guest_agent.0"
+
+// GuestAgentReady reports whether the guest agent of a running VM answers a ping. Domains
+// without a guest agent channel, such as containers, report errdefs.ErrNotSupported.
+func (m *Manager) GuestAgentReady(hypervisor dependencies.HypervisorContext, name string) (bool, error) {
+	domain, err := m.lookupDomain(hypervisor, name)
+	if err != nil {
+		return false, fmt.Errorf("could not look up VM by name: %w", err)
+	}
+	defer domain.Close()
+
+	domainXML, err := m.ToLibvirtXML(domain.Domain)
+	if err != nil {
+		return false, err
+	}
+	if !hasGuestAgentChannel(domainXML) {
+		return false, fmt.Errorf("%w: VM %s has no guest agent channel", errdefs.ErrNotSupported, name)
+	}
+
+	_, err = guestAgentCommand(domain.Domain, "guest-ping", nil)
+	return err == nil, nil
+}
+
+// hasGuestAgentChannel reports whether a domain has a channel for the QEMU guest agent.
+func hasGuestAgentChannel(domainXML libvirtxml.Domain) bool {
+	if domainXML.Devices == nil {
+		return false
+	}
+	for _, channel := range domainXML.Devices.Channels {
+		if channel.Target != nil && channel.Target.VirtIO != nil && channel.Target.VirtIO.Name == guestAgentChannel {
+			return true
+		}
+	}
+	return false
+}
+
+// readGuestFile reads a file of the guest through the guest agent. It reports false if the file cannot be opened.
+func readGuestFile(domain *libvirt.Domain, path string) ([]byte, bool, error) {
+	var handle int
+	response, err := guestAgentCommand(domain, "guest-file-open", map[string]any{"path": path, "mode": "r"})
+	if err != nil {
+		return nil, false, nil
+	}
+	if err := json.Unmarshal(response, &handle); err != nil {
+		return nil, false, fmt.Errorf("unexpected guest-file-open response: %w", err)
+	}
+	defer guestAgentCommand(domain, "guest-file-close", map[string]any{"handle": handle})
+
+	var read struct {
+		Buffer string `json:"buf-b64"`
+	}
+	response, err = guestAgentCommand(domain, "guest-file-read", map[string]any{"handle": handle, "count": maxGuestFileBytes})
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err := json.Unmarshal(response, &read); err != nil {
+		return nil, true, fmt.Errorf("unexpected guest-file-read response: %w", err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(read.Buffer)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return data, true, nil
+}
+
+// guestAgentCommand runs a guest agent command and returns its "return" member.
+func guestAgentCommand(domain *libvirt.Domain, command string, arguments map[string]any) (json.RawMessage, error) {
+	request := map[string]any{"execute": command}
+	if arguments != nil {
+		request["arguments"] = arguments
+	}
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := domain.QemuAgentCommand(string(payload), libvirt.DOMAIN_QEMU_AGENT_COMMAND_DEFAULT, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Return json.RawMessage `json:"return"`
+	}
+	if err := json.Unmarshal([]byte(output), &response); err != nil {
+		return nil, fmt.Errorf("unexpected %s response: %w", command, err)
+	}
+	return response.Return, nil
+}
+
+// guestExecPollInterval is how often a command started through the guest agent is checked for completion.
+const guestExecPollInterval = 500 * time.Millisecond
+
+// GuestExec runs a shell command inside a running VM through the QEMU guest agent and waits for
+// it to exit. The guest agent runs commands as root and returns their output once they exited.
+func (m *Manager) GuestExec(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.GuestExec) (parameters.GuestExecResult, error) {
+	domain, err := m.lookupDomain(hypervisor, params.Name)
+	if err != nil {
+		return parameters.GuestExecResult{}, fmt.Errorf("could not look up VM by name: %w", err)
+	}
+	defer domain.Close()
+
+	arguments := map[string]any{
+		"path":           "/bin/sh",
+		"arg":            []string{"-c", params.Command},
+		"capture-output": true,
+	}
+	if len(params.Stdin) > 0 {
+		arguments["input-data"] = base64.StdEncoding.EncodeToString(params.Stdin)
+	}
+	response, err := guestAgentCommand(domain.Domain, "guest-exec", arguments)
+	if err != nil {
+		return parameters.GuestExecResult{}, fmt.Errorf("failed to start command through the guest agent: %w", err)
+	}
+	var started struct {
+		PID int `json:"pid"`
+	}
+	if err := json.Unmarshal(response, &started); err != nil {
+		return parameters.GuestExecResult{}, fmt.Errorf("unexpected guest-exec response: %w", err)
+	}
+
+	for {
+		response, err := guestAgentCommand(domain.Domain, "guest-exec-status", map[string]any{"pid": started.PID})
+		if err != nil {
+			return parameters.GuestExecResult{}, fmt.Errorf("failed to read command status through the guest agent: %w", err)
+		}
+		var status struct {
+			Exited   bool   `json:"exited"`
+			ExitCode int    `json:"exitcode"`
+			Signal   int    `json:"signal"`
+			OutData  string `json:"out-data"`
+			ErrData  string `json:"err-data"`
+		}
+		if err := json.Unmarshal(response, &status); err != nil {
+			return parameters.GuestExecResult{}, fmt.Errorf("unexpected guest-exec-status response: %w", err)
+		}
+
+		if status.Exited {
+			result := parameters.GuestExecResult{ExitCode: status.ExitCode}
+			if status.Signal != 0 {
+				result.ExitCode = 128 + status.Signal
+			}
+			if result.Stdout, err = base64.StdEncoding.DecodeString(status.OutData); err != nil {
+				return result, fmt.Errorf("failed to decode command output: %w", err)
+			}
+			if result.Stderr, err = base64.StdEncoding.DecodeString(status.ErrData); err != nil {
+				return result, fmt.Errorf("failed to decode command error output: %w", err)
+			}
+			return result, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			// The guest agent cannot kill the command; it keeps running in the guest.
+			return parameters.GuestExecResult{ExitCode: -1}, fmt.Errorf("command cancelled, pid %d keeps running in the guest: %w", started.PID, ctx.Err())
+		case <-time.After(guestExecPollInterval):
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/executor"
+	"github.com/terabiome/homonculus/pkg/executor/fileops"
+	"github.com/terabiome/homonculus/pkg/units"
+	"libvirt.org/go/libvirt"
+	"libvirt.org/go/libvirtxml"
+)
+
+// GetHostCapacity reports total and free host resources along with the resources claimed by defined domains.
+func (m *Manager) GetHostCapacity(ctx context.Context, hypervisor dependencies.HypervisorContext) (parameters.HostCapacity, error) {
+	capacity := parameters.HostCapacity{Host: hypervisor.Host}
+
+	nodeInfo, err := hypervisor.Conn.GetNodeInfo()
+	if err != nil {
+		return capacity, fmt.Errorf("could not get node info: %w", err)
+	}
+	capacity.TotalMemoryKiB = nodeInfo.Memory
+	capacity.CPUs = nodeInfo.Cpus
+
+	freeMemory, err := hypervisor.Conn.GetFreeMemory()
+	if err != nil {
+		return capacity, fmt.Errorf("could not get free memory: %w", err)
+	}
+	capacity.FreeMemoryKiB = units.BytesToKiB(freeMemory)
+
+	domains, err := m.listDomains(hypervisor, libvirt.CONNECT_LIST_DOMAINS_ACTIVE|libvirt.CONNECT_LIST_DOMAINS_INACTIVE)
+	if err != nil {
+		return capacity, fmt.Errorf("could not list domains: %w", err)
+	}
+	defer domains.Close()
+
+	for _, domain := range domains {
+		info, err := domain.GetInfo()
+		if err != nil {
+			m.logger.Warn("could not get domain info", slog.String("error", err.Error()))
+			continue
+		}
+		capacity.DefinedVMs++
+		capacity.AllocatedMemoryKiB += info.MaxMem
+		capacity.AllocatedVCPUs += info.NrVirtCpu
+		if info.State == libvirt.DOMAIN_RUNNING {
+			capacity.RunningVMs++
+		}
+	}
+
+	numaNodes, err := m.GetNUMATopology(ctx, hypervisor)
+	if err != nil {
+		m.logger.Warn("could not get NUMA topology", slog.String("host", hypervisor.Host), slog.String("error", err.Error()))
+	}
+	capacity.NUMANodes = numaNodes
+
+	storagePools, err := m.listStoragePoolCapacities(hypervisor)
+	if err != nil {
+		m.logger.Warn("could not get storage pools", slog.String("host", hypervisor.Host), slog.String("error", err.Error()))
+	}
+	capacity.StoragePools = storagePools
+
+	loadAverage, err := ReadLoadAverage(ctx, hypervisor.Executor)
+	if err != nil {
+		m.logger.Warn("could not get load average", slog.String("host", hypervisor.Host), slog.String("error", err.Error()))
+	}
+	capacity.LoadAverage = loadAverage
+
+	m.logger.Debug("retrieved host capacity",
+		slog.String("host", hypervisor.Host),
+		slog.Uint64("total_memory_kib", capacity.TotalMemoryKiB),
+		slog.Uint64("allocated_memory_kib", capacity.AllocatedMemoryKiB),
+		slog.Int("defined_vms", capacity.DefinedVMs),
+	)
+
+	return capacity, nil
+}
+
+// ReadLoadAverage reads the 1-minute load average of a host from /proc/loadavg.
+func ReadLoadAverage(ctx context.Context, exec executor.Executor) (float64, error) {
+	data, err := fileops.ReadFile(ctx, exec, "/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected /proc/loadavg content %q", data)
+	}
+	loadAverage, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse load average: %w", err)
+	}
+	return loadAverage, nil
+}
+
+// GetNUMATopology reads the host NUMA cells and their CPUs from the libvirt capabilities.
+func (m *Manager) GetNUMATopology(ctx context.Context, hypervisor dependencies.HypervisorContext) ([]parameters.NUMANode, error) {
+	capsXMLString, err := hypervisor.Conn.GetCapabilities()
+	if err != nil {
+		return nil, fmt.Errorf("could not get capabilities: %w", err)
+	}
+
+	caps := libvirtxml.Caps{}
+	if err := caps.Unmarshal(capsXMLString); err != nil {
+		return nil, fmt.Errorf("could not parse capabilities: %w", err)
+	}
+
+	if caps.Host.NUMA == nil || caps.Host.NUMA.Cells == nil {
+		return nil, nil
+	}
+
+	cells := caps.Host.NUMA.Cells.Cells
+	freeMemory, err := hypervisor.Conn.GetCellsFreeMemory(0, len(cells))
+	if err != nil {
+		m.logger.Warn("could not get per-cell free memory", slog.String("error", err.Error()))
+	}
+
+	nodes := make([]parameters.NUMANode, 0, len(cells))
+	for i, cell := range cells {
+		node := parameters.NUMANode{ID: cell.ID}
+		if cell.Memory != nil {
+			node.MemoryKiB = units.LibvirtToKiB(cell.Memory.Size, cell.Memory.Unit)
+		}
+		if i < len(freeMemory) {
+			node.FreeMemoryKiB = units.BytesToKiB(freeMemory[i])
+		}
+		if cell.CPUS != nil {
+			for _, cpu := range cell.CPUS.CPUs {
+				hostCPU := parameters.HostCPU{ID: cpu.ID, Siblings: cpu.Siblings}
+				if cpu.SocketID != nil {
+					hostCPU.SocketID = *cpu.SocketID
+				}
+				if cpu.CoreID != nil {
+					hostCPU.CoreID = *cpu.CoreID
+				}
+				node.CPUs = append(node.CPUs, hostCPU)
+			}
+		}
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+// listStoragePoolCapacities reports capacity and usage of every storage pool.
+func (m *Manager) listStoragePoolCapacities(hypervisor dependencies.HypervisorContext) ([]parameters.StoragePoolCapacity, error) {
+	pools, err := hypervisor.Conn.ListAllStoragePools(0)
+	if err != nil {
+		return nil, fmt.Errorf("could not list storage pools: %w", err)
+	}
+
+	var capacities []parameters.StoragePoolCapacity
+	for _, pool := range pools {
+		name, err := pool.GetName()
+		if err != nil {
+			m.logger.Warn("could not get storage pool name", slog.String("error", err.Error()))
+			pool.Free()
+			continue
+		}
+
+		info, err := pool.GetInfo()
+		if err != nil {
+			m.logger.Warn("could not get storage pool info", slog.String("pool", name), slog.String("error", err.Error()))
+			pool.Free()
+			continue
+		}
+
+		capacities = append(capacities, parameters.StoragePoolCapacity{
+			Name:            name,
+			Active:          info.State == libvirt.STORAGE_POOL_RUNNING,
+			CapacityBytes:   info.Capacity,
+			AllocationBytes: info.Allocation,
+			AvailableBytes:  info.Available,
+		})
+		pool.Free()
+	}
+
+	return capacities, nil
+}
@@ -0,0 +1,111 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/cpuset"
+	"github.com/terabiome/homonculus/pkg/units"
+)
+
+// parsePageSizeKiB converts a hugepage size such as "2M", "1G" or "2048K" into KiB.
+// A bare number is interpreted as KiB, matching libvirt's default unit.
+func parsePageSizeKiB(size string) (uint64, error) {
+	value := strings.ToUpper(strings.TrimSpace(size))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+
+	multiplier := uint64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		value = strings.TrimSuffix(value, "K")
+	case strings.HasSuffix(value, "M"):
+		value, multiplier = strings.TrimSuffix(value, "M"), 1<<10
+	case strings.HasSuffix(value, "G"):
+		value, multiplier = strings.TrimSuffix(value, "G"), 1<<20
+	}
+
+	number, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || number == 0 {
+		return 0, fmt.Errorf("invalid hugepage size %q", size)
+	}
+	return number * multiplier, nil
+}
+
+// hugepageBacking resolves the hugepage backing of a VM without checking the host for free pages.
+func hugepageBacking(hugepages *parameters.Hugepages) (*Hugepages, error) {
+	pageSizeKiB, err := parsePageSizeKiB(hugepages.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	return &Hugepages{PageSizeKiB: pageSizeKiB, Nodeset: hugepages.Nodeset}, nil
+}
+
+// prepareHugepages resolves the hugepage backing of a VM and verifies that the host has
+// enough free pages of the requested size on the selected NUMA nodes.
+func (m *Manager) prepareHugepages(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.CreateVM) (*Hugepages, error) {
+	hugepages := params.Tuning.Hugepages
+
+	pageSizeKiB, err := parsePageSizeKiB(hugepages.PageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	memoryKiB := units.MiBToKiB(uint64(params.MemoryMB))
+	if memoryKiB%pageSizeKiB != 0 {
+		return nil, fmt.Errorf("memory_mb (%d) is not a multiple of hugepage size %s", params.MemoryMB, hugepages.PageSize)
+	}
+	requiredPages := memoryKiB / pageSizeKiB
+
+	numaNodes, err := m.GetNUMATopology(ctx, hypervisor)
+	if err != nil {
+		return nil, fmt.Errorf("could not load host topology: %w", err)
+	}
+	cellCount := len(numaNodes)
+	if cellCount == 0 {
+		cellCount = 1
+	}
+
+	// Pages are drawn from the explicit nodeset, or the NUMA memory nodeset, or any node.
+	nodeset := hugepages.Nodeset
+	if nodeset == "" && params.Tuning.NUMAMemory != nil {
+		nodeset = params.Tuning.NUMAMemory.Nodeset
+	}
+	nodes := make(map[int]bool)
+	if nodeset != "" {
+		ids, err := cpuset.Parse(nodeset)
+		if err != nil {
+			return nil, fmt.Errorf("hugepages.nodeset: %w", err)
+		}
+		for _, id := range ids {
+			if id >= cellCount {
+				return nil, fmt.Errorf("hugepages.nodeset refers to NUMA node %d which does not exist on host %s", id, hypervisor.Host)
+			}
+			nodes[id] = true
+		}
+	}
+
+	freePages, err := hypervisor.Conn.GetFreePages([]uint64{pageSizeKiB}, 0, uint(cellCount), 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not get free %s hugepages on host %s: %w", hugepages.PageSize, hypervisor.Host, err)
+	}
+
+	var available uint64
+	for cell, count := range freePages {
+		if len(nodes) == 0 || nodes[cell] {
+			available += count
+		}
+	}
+	if available < requiredPages {
+		return nil, fmt.Errorf("host %s has %d free %s hugepages on NUMA nodes %q, VM %s needs %d",
+			hypervisor.Host, available, hugepages.PageSize, nodeset, params.Name, requiredPages)
+	}
+
+	return &Hugepages{
+		PageSizeKiB: pageSizeKiB,
+		Nodeset:     hugepages.Nodeset,
+	}, nil
+}
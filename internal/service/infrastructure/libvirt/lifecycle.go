@@ -0,0 +1,72 @@
+package libvirt
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"libvirt.org/go/libvirtxml"
+)
+
+const (
+	defaultWatchdogModel  = "i6300esb"
+	defaultWatchdogAction = "reset"
+)
+
+var (
+	lifecycleActions      = []string{"destroy", "restart", "preserve", "rename-restart"}
+	crashLifecycleActions = append(slices.Clone(lifecycleActions), "coredump-destroy", "coredump-restart")
+	watchdogModels        = []string{"i6300esb", "ib700", "itco"}
+	watchdogActions       = []string{"reset", "shutdown", "poweroff", "pause", "dump", "inject-nmi", "none"}
+)
+
+// Lifecycle contains the actions libvirt takes when the guest powers off, reboots or crashes.
+// Empty actions keep the libvirt defaults.
+type Lifecycle struct {
+	OnPoweroff string
+	OnReboot   string
+	OnCrash    string
+}
+
+// resolveLifecycle validates the lifecycle actions of a VM.
+func resolveLifecycle(params parameters.CreateVM) (Lifecycle, error) {
+	lifecycle := Lifecycle{
+		OnPoweroff: params.OnPoweroff,
+		OnReboot:   params.OnReboot,
+		OnCrash:    params.OnCrash,
+	}
+
+	if lifecycle.OnPoweroff != "" && !slices.Contains(lifecycleActions, lifecycle.OnPoweroff) {
+		return lifecycle, fmt.Errorf("invalid on_poweroff action '%s': must be one of %v", lifecycle.OnPoweroff, lifecycleActions)
+	}
+	if lifecycle.OnReboot != "" && !slices.Contains(lifecycleActions, lifecycle.OnReboot) {
+		return lifecycle, fmt.Errorf("invalid on_reboot action '%s': must be one of %v", lifecycle.OnReboot, lifecycleActions)
+	}
+	if lifecycle.OnCrash != "" && !slices.Contains(crashLifecycleActions, lifecycle.OnCrash) {
+		return lifecycle, fmt.Errorf("invalid on_crash action '%s': must be one of %v", lifecycle.OnCrash, crashLifecycleActions)
+	}
+
+	return lifecycle, nil
+}
+
+// watchdogXML validates a watchdog, applies the defaults and builds its definition.
+func watchdogXML(watchdog parameters.Watchdog) (string, error) {
+	model := watchdog.Model
+	if model == "" {
+		model = defaultWatchdogModel
+	}
+	if !slices.Contains(watchdogModels, model) {
+		return "", fmt.Errorf("invalid watchdog model '%s': must be one of %v", model, watchdogModels)
+	}
+
+	action := watchdog.Action
+	if action == "" {
+		action = defaultWatchdogAction
+	}
+	if !slices.Contains(watchdogActions, action) {
+		return "", fmt.Errorf("invalid watchdog action '%s': must be one of %v", action, watchdogActions)
+	}
+
+	device := libvirtxml.DomainWatchdog{Model: model, Action: action}
+	return device.Marshal()
+}
@@ -2,47 +2,113 @@ package libvirt
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 
 	"github.com/google/uuid"
 	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/errdefs"
 	"github.com/terabiome/homonculus/internal/service/parameters"
 
 	"github.com/terabiome/homonculus/pkg/constants"
 	"github.com/terabiome/homonculus/pkg/executor/fileops"
+	"github.com/terabiome/homonculus/pkg/pathpolicy"
 	"github.com/terabiome/homonculus/pkg/templator"
+	"github.com/terabiome/homonculus/pkg/units"
+	"golang.org/x/sync/errgroup"
 	"libvirt.org/go/libvirt"
 	"libvirt.org/go/libvirtxml"
 )
 
 // Manager manages libvirt VM operations.
 type Manager struct {
-	engine *templator.Engine
-	logger *slog.Logger
+	engine                *templator.Engine
+	paths                 *pathpolicy.AllowList
+	failOnPinConflict     bool
+	memoryOvercommitRatio float64
+	logger                *slog.Logger
 }
 
-// NewManager creates a new libvirt manager.
-func NewManager(engine *templator.Engine, logger *slog.Logger) *Manager {
+// NewManager creates a new libvirt manager. With failOnPinConflict, VMs pinning CPUs other VMs
+// on the host pin already are rejected rather than created with a warning. VMs whose memory, with
+// the memory of the other VMs on the host, exceeds memoryOvercommitRatio times the host memory are
+// logged; a ratio of 0 disables the warning.
+func NewManager(engine *templator.Engine, allowedPaths *pathpolicy.AllowList, failOnPinConflict bool, memoryOvercommitRatio float64, logger *slog.Logger) *Manager {
 	return &Manager{
-		engine: engine,
-		logger: logger.With(slog.String("component", "libvirt")),
+		engine:                engine,
+		paths:                 allowedPaths,
+		failOnPinConflict:     failOnPinConflict,
+		memoryOvercommitRatio: memoryOvercommitRatio,
+		logger:                logger.With(slog.String("component", "libvirt")),
 	}
 }
 
 // CreateVirtualMachine creates a virtual machine without starting it.
 func (m *Manager) CreateVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.CreateVM, virtualMachineUUID uuid.UUID) error {
+	if params.MachineType == string(constants.MACHINE_TYPE_CONTAINER) {
+		return m.createContainer(hypervisor, params, virtualMachineUUID)
+	}
+
+	domainXML, err := m.renderDomainXML(ctx, hypervisor, params, virtualMachineUUID, false)
+	if err != nil {
+		return err
+	}
+
+	if err := m.defineDomain(hypervisor, domainXML); err != nil {
+		return fmt.Errorf("could not define VM from Libvirt XML: %w", err)
+	}
+	m.logger.Info("defined VM in libvirt", slog.String("vm", params.Name))
+
+	return nil
+}
+
+// RenderVirtualMachineXML renders the domain XML a VM spec would be defined with, without
+// defining it. Checks that would count an already defined VM against itself are skipped,
+// and auto_pin is not resolved.
+func (m *Manager) RenderVirtualMachineXML(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.CreateVM, virtualMachineUUID uuid.UUID) (libvirtxml.Domain, error) {
+	var rendered string
+	var err error
+	if params.MachineType == string(constants.MACHINE_TYPE_CONTAINER) {
+		rendered, err = m.renderContainerXML(hypervisor, params, virtualMachineUUID, true)
+	} else {
+		rendered, err = m.renderDomainXML(ctx, hypervisor, params, virtualMachineUUID, true)
+	}
+	if err != nil {
+		return libvirtxml.Domain{}, err
+	}
+
+	var domainXML libvirtxml.Domain
+	if err := domainXML.Unmarshal(rendered); err != nil {
+		return libvirtxml.Domain{}, fmt.Errorf("could not parse rendered domain XML: %w", err)
+	}
+	return domainXML, nil
+}
+
+// renderDomainXML validates a VM spec against the host and renders its domain XML. A preview
+// skips the checks against the other domains of the host and records no canary rendering.
+func (m *Manager) renderDomainXML(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.CreateVM, virtualMachineUUID uuid.UUID, preview bool) (string, error) {
 	var vcpuPins []VCPUPin
 	var emulatorCPUSet string
+	var ioThreads int
+	var ioThreadPins []IOThreadPin
 	var numaMemory *NUMAMemory
-	var hostBindMounts = make([]HostBindMount, 0)
+	var hugepages *Hugepages
+
+	if params.Tuning != nil && params.Tuning.AutoPin && !preview {
+		tuning, err := m.autoPin(ctx, hypervisor, params)
+		if err != nil {
+			return "", err
+		}
+		params.Tuning = tuning
+	}
 
 	// Process tuning configuration if present
 	if params.Tuning != nil {
 		// Validate CPU pinning configuration
 		if len(params.Tuning.VCPUPins) > 0 {
 			if len(params.Tuning.VCPUPins) > params.VCPUCount {
-				return fmt.Errorf("vcpu_pins length (%d) exceeds vcpu_count (%d)", len(params.Tuning.VCPUPins), params.VCPUCount)
+				return "", fmt.Errorf("vcpu_pins length (%d) exceeds vcpu_count (%d)", len(params.Tuning.VCPUPins), params.VCPUCount)
 			}
 			if len(params.Tuning.VCPUPins) < params.VCPUCount {
 				m.logger.Warn("partial CPU pinning detected",
@@ -66,6 +132,21 @@ func (m *Manager) CreateVirtualMachine(ctx context.Context, hypervisor dependenc
 		// Process emulator CPU set
 		emulatorCPUSet = params.Tuning.EmulatorCPUSet
 
+		// Process I/O threads; libvirt numbers them from 1
+		if params.Tuning.IOThreads < 0 {
+			return "", fmt.Errorf("iothreads must not be negative, got %d", params.Tuning.IOThreads)
+		}
+		if len(params.Tuning.IOThreadPins) > params.Tuning.IOThreads {
+			return "", fmt.Errorf("iothread_pins length (%d) exceeds iothreads (%d)", len(params.Tuning.IOThreadPins), params.Tuning.IOThreads)
+		}
+		ioThreads = params.Tuning.IOThreads
+		for i, cpuset := range params.Tuning.IOThreadPins {
+			ioThreadPins = append(ioThreadPins, IOThreadPin{
+				IOThread: i + 1,
+				CPUSet:   cpuset,
+			})
+		}
+
 		// Process NUMA memory configuration
 		if params.Tuning.NUMAMemory != nil {
 			mode := params.Tuning.NUMAMemory.Mode
@@ -75,7 +156,7 @@ func (m *Manager) CreateVirtualMachine(ctx context.Context, hypervisor dependenc
 			}
 			// Validate mode
 			if mode != "strict" && mode != "preferred" && mode != "interleave" {
-				return fmt.Errorf("invalid NUMA memory mode '%s': must be 'strict', 'preferred', or 'interleave'", mode)
+				return "", fmt.Errorf("invalid NUMA memory mode '%s': must be 'strict', 'preferred', or 'interleave'", mode)
 			}
 
 			numaMemory = &NUMAMemory{
@@ -85,48 +166,172 @@ func (m *Manager) CreateVirtualMachine(ctx context.Context, hypervisor dependenc
 		}
 	}
 
-	for _, hostBindMount := range params.HostBindMounts {
-		hostBindMounts = append(hostBindMounts, HostBindMount(hostBindMount))
+	if err := m.validateTuningTopology(ctx, hypervisor, params.Tuning); err != nil {
+		return "", err
+	}
+
+	// A preview may describe a VM that is already defined, which the pin, memory and
+	// hugepage checks would count against itself.
+	if !preview {
+		// Automatic pinning already avoids the CPUs other domains pin.
+		if params.Tuning != nil && !params.Tuning.AutoPin {
+			if err := m.checkPinConflicts(hypervisor, params); err != nil {
+				return "", err
+			}
+		}
+
+		if err := m.validateMemory(ctx, hypervisor, params); err != nil {
+			return "", err
+		}
+	}
+
+	if params.Tuning != nil && params.Tuning.Hugepages != nil {
+		var err error
+		if preview {
+			hugepages, err = hugepageBacking(params.Tuning.Hugepages)
+		} else {
+			hugepages, err = m.prepareHugepages(ctx, hypervisor, params)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	firmware, err := resolveFirmware(params)
+	if err != nil {
+		return "", err
+	}
+
+	hostDevices, err := m.resolveHostDevices(hypervisor, params)
+	if err != nil {
+		return "", err
+	}
+
+	extraDevices, err := m.buildExtraDevices(hypervisor, params)
+	if err != nil {
+		return "", err
+	}
+
+	hostBindMounts, virtiofsdPath, err := m.prepareHostBindMounts(ctx, hypervisor, params)
+	if err != nil {
+		return "", err
+	}
+
+	graphics, err := resolveGraphics(params)
+	if err != nil {
+		return "", err
+	}
+
+	lifecycle, err := resolveLifecycle(params)
+	if err != nil {
+		return "", err
+	}
+
+	metadata, err := NewDomainMetadata(params).Render()
+	if err != nil {
+		return "", err
 	}
 
 	vars := LibvirtTemplateVars{
 		Name:                   params.Name,
 		UUID:                   virtualMachineUUID,
 		VCPUCount:              params.VCPUCount,
-		MemoryKiB:              params.MemoryMB << 10,
+		MemoryKiB:              units.MiBToKiB(params.MemoryMB),
 		DiskPath:               params.DiskPath,
 		CloudInitISOPath:       params.CloudInitISOPath,
 		HostBindMounts:         hostBindMounts,
+		VirtiofsdPath:          virtiofsdPath,
+		SharedMemory:           virtiofsdPath != "",
 		BridgeNetworkInterface: params.BridgeNetworkInterface,
+		MACAddress:             params.MACAddress,
 		VCPUPins:               vcpuPins,
 		EmulatorCPUSet:         emulatorCPUSet,
+		IOThreads:              ioThreads,
+		IOThreadPins:           ioThreadPins,
 		NUMAMemory:             numaMemory,
+		Hugepages:              hugepages,
+		Firmware:               firmware.Firmware,
+		SecureBoot:             firmware.SecureBoot,
+		NVRAMPath:              firmware.NVRAMPath,
+		MachineType:            firmware.MachineType,
+		TPM:                    params.TPM,
+		HostDevices:            hostDevices,
+		ExtraDevices:           extraDevices,
+		Graphics:               graphics,
+		Netboot:                params.Netboot != nil,
+		Lifecycle:              lifecycle,
+		Metadata:               metadata,
 	}
 
 	bytes, err := m.engine.RenderToBytes(constants.TemplateLibvirt, vars)
 	if err != nil {
-		return fmt.Errorf("could not create Libvirt XML in memory: %w", err)
+		return "", fmt.Errorf("could not create Libvirt XML in memory: %w", err)
+	}
+	if !preview {
+		m.engine.Canary(params.Name, constants.TemplateLibvirt, vars)
 	}
 	m.logger.Debug("rendered libvirt XML", slog.String("vm", params.Name))
 
-	_, err = hypervisor.Conn.DomainDefineXML(string(bytes))
+	return string(bytes), nil
+}
+
+// UndefineVirtualMachine removes the definition of a virtual machine, stopping it first if needed.
+// Disks are left in place; it is the rollback counterpart of CreateVirtualMachine.
+func (m *Manager) UndefineVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, name string) error {
+	domain, err := m.lookupDomain(hypervisor, name)
 	if err != nil {
-		return fmt.Errorf("could not define VM from Libvirt XML: %w", err)
+		return fmt.Errorf("could not look up VM by name: %w", err)
 	}
-	m.logger.Info("defined VM in libvirt", slog.String("vm", params.Name))
+	defer domain.Close()
+
+	if state, _, _ := domain.GetState(); state != libvirt.DOMAIN_SHUTOFF {
+		if err := domain.Destroy(); err != nil {
+			return fmt.Errorf("could not destroy VM: %w", err)
+		}
+	}
+
+	domainXML, err := m.ToLibvirtXML(domain.Domain)
+	if err != nil {
+		return err
+	}
+	if err := m.undefineDomain(domain, domainXML); err != nil {
+		return fmt.Errorf("could not undefine VM: %w", err)
+	}
+	m.logger.Info("undefined VM from libvirt", slog.String("vm", name))
 
 	return nil
 }
 
 // StartVirtualMachine starts a virtual machine by name.
 func (m *Manager) StartVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.StartVM) error {
-	domain, err := hypervisor.Conn.LookupDomainByName(params.Name)
+	domain, err := m.lookupDomain(hypervisor, params.Name)
 	if err != nil {
 		return fmt.Errorf("could not look up VM by name: %w", err)
 	}
+	defer func() { domain.Close() }()
 	m.logger.Debug("found VM", slog.String("vm", params.Name))
 
-	if err = domain.Create(); err != nil {
+	// A handle from a lost connection is useless after reconnecting, so every retry looks the
+	// domain up again. A retry finding the domain running means an earlier attempt started it.
+	retried := false
+	err = m.retry(hypervisor, "start domain", func() error {
+		if retried {
+			domain.Close()
+			var err error
+			if domain, err = m.lookupDomain(hypervisor, params.Name); err != nil {
+				return err
+			}
+		}
+		err := domain.Create()
+		if retried && hasErrorCode(err, libvirt.ERR_OPERATION_INVALID) {
+			if active, activeErr := domain.IsActive(); activeErr == nil && active {
+				return nil
+			}
+		}
+		retried = true
+		return err
+	})
+	if err != nil {
 		return fmt.Errorf("could not start VM: %w", err)
 	}
 	m.logger.Info("started VM", slog.String("vm", params.Name))
@@ -134,13 +339,48 @@ func (m *Manager) StartVirtualMachine(ctx context.Context, hypervisor dependenci
 	return nil
 }
 
+// StopVirtualMachine requests a graceful ACPI shutdown of a virtual machine by name.
+func (m *Manager) StopVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.StopVM) error {
+	domain, err := m.lookupDomain(hypervisor, params.Name)
+	if err != nil {
+		return fmt.Errorf("could not look up VM by name: %w", err)
+	}
+	defer domain.Close()
+	m.logger.Debug("found VM", slog.String("vm", params.Name))
+
+	if state, _, _ := domain.GetState(); state == libvirt.DOMAIN_SHUTOFF {
+		m.logger.Debug("VM already shut off", slog.String("vm", params.Name))
+		return nil
+	}
+
+	if err = domain.Shutdown(); err != nil {
+		return fmt.Errorf("could not shut down VM: %w", err)
+	}
+	m.logger.Info("requested VM shutdown", slog.String("vm", params.Name))
+
+	return nil
+}
+
 // GetVirtualMachineInfo retrieves detailed information about a virtual machine.
 func (m *Manager) GetVirtualMachineInfo(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.QueryVM) (parameters.VMInfo, error) {
-	domain, err := hypervisor.Conn.LookupDomainByName(params.Name)
+	domain, err := m.lookupDomain(hypervisor, params.Name)
 	if err != nil {
 		return parameters.VMInfo{}, fmt.Errorf("could not look up VM by name: %w", err)
 	}
+	defer domain.Close()
 
+	vmInfo, err := m.domainInfo(domain.Domain, params.Name)
+	if err != nil {
+		return parameters.VMInfo{}, err
+	}
+
+	m.logger.Debug("retrieved VM info", slog.String("vm", params.Name), slog.String("state", vmInfo.State))
+
+	return vmInfo, nil
+}
+
+// domainInfo gathers the details of a domain, reading and parsing its XML once.
+func (m *Manager) domainInfo(domain *libvirt.Domain, name string) (parameters.VMInfo, error) {
 	// Get UUID
 	uuidStr, err := domain.GetUUIDString()
 	if err != nil {
@@ -153,8 +393,9 @@ func (m *Manager) GetVirtualMachineInfo(ctx context.Context, hypervisor dependen
 		return parameters.VMInfo{}, fmt.Errorf("could not get VM state: %w", err)
 	}
 
-	// Get XML description
-	domainXMLString, err := domain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE)
+	// The live XML of a running domain also carries the console ports allocated at start;
+	// for inactive domains it is the persistent configuration.
+	domainXMLString, err := domain.GetXMLDesc(0)
 	if err != nil {
 		return parameters.VMInfo{}, fmt.Errorf("could not read domain XML: %w", err)
 	}
@@ -181,29 +422,39 @@ func (m *Manager) GetVirtualMachineInfo(ctx context.Context, hypervisor dependen
 		}
 	}
 
+	metadata, err := ParseDomainMetadata(domainXML)
+	if err != nil {
+		m.logger.Warn("could not parse homonculus metadata", slog.String("vm", name), slog.String("error", err.Error()))
+	}
+
 	// Get autostart status
 	autoStart, err := domain.GetAutostart()
 	if err != nil {
-		m.logger.Warn("could not get autostart status", slog.String("vm", params.Name), slog.String("error", err.Error()))
+		m.logger.Warn("could not get autostart status", slog.String("vm", name), slog.String("error", err.Error()))
 		autoStart = false
 	}
 
 	// Check if persistent
 	persistent, err := domain.IsPersistent()
 	if err != nil {
-		m.logger.Warn("could not get persistent status", slog.String("vm", params.Name), slog.String("error", err.Error()))
+		m.logger.Warn("could not get persistent status", slog.String("vm", name), slog.String("error", err.Error()))
 		persistent = false
 	}
 
+	memoryKiB := DomainCurrentMemoryKiB(domainXML)
 	vmInfo := parameters.VMInfo{
-		Name:       params.Name,
-		UUID:       uuidStr,
-		State:      domainStateToString(state), // Convert to string for JSON API
-		VCPUCount:  domainXML.VCPU.Value,
-		MemoryMB:   domainXML.CurrentMemory.Value / 1024, // Convert from KiB to MiB
-		Disks:      disks,
-		AutoStart:  autoStart,
-		Persistent: persistent,
+		Name:        name,
+		UUID:        uuidStr,
+		State:       domainStateToString(state), // Convert to string for JSON API
+		VCPUCount:   domainXML.VCPU.Value,
+		MemoryMB:    uint(units.KiBToMiB(memoryKiB)),
+		MemoryBytes: units.KiBToBytes(memoryKiB),
+		Disks:       disks,
+		AutoStart:   autoStart,
+		Persistent:  persistent,
+		Labels:      metadata.LabelMap(),
+		Ownership:   metadata.Ownership(),
+		Graphics:    graphicsInfo(domainXML),
 	}
 
 	// Try to get DHCP lease information (hostname and IP)
@@ -215,24 +466,24 @@ func (m *Manager) GetVirtualMachineInfo(ctx context.Context, hypervisor dependen
 				m.logger.Warn("retrieved empty hostname")
 			} else {
 				vmInfo.Hostname = hostname
-				m.logger.Debug("retrieved hostname from DHCP lease", slog.String("vm", params.Name), slog.String("hostname", hostname))
+				m.logger.Debug("retrieved hostname from DHCP lease", slog.String("vm", name), slog.String("hostname", hostname))
 			}
 		} else {
-			m.logger.Warn("could not get hostname", slog.String("vm", params.Name), slog.String("error", err.Error()))
+			m.logger.Warn("could not get hostname", slog.String("vm", name), slog.String("error", err.Error()))
 		}
 
 		// Get IP addresses from domain interfaces
 		ifaces, err := domain.ListAllInterfaceAddresses(libvirt.DOMAIN_INTERFACE_ADDRESSES_SRC_LEASE)
 		if err == nil {
 			if len(ifaces) < 1 {
-				m.logger.Warn("retrieved no interface", slog.String("vm", params.Name))
+				m.logger.Warn("retrieved no interface", slog.String("vm", name))
 			} else {
 				// Get the first non-loopback IPv4 address
 				for _, iface := range ifaces {
 					for _, addr := range iface.Addrs {
 						if addr.Type == libvirt.IP_ADDR_TYPE_IPV4 && addr.Addr != "127.0.0.1" {
 							vmInfo.IPAddress = addr.Addr
-							m.logger.Debug("retrieved IP address from DHCP lease", slog.String("vm", params.Name), slog.String("ip", addr.Addr))
+							m.logger.Debug("retrieved IP address from DHCP lease", slog.String("vm", name), slog.String("ip", addr.Addr))
 							break
 						}
 					}
@@ -242,38 +493,61 @@ func (m *Manager) GetVirtualMachineInfo(ctx context.Context, hypervisor dependen
 				}
 			}
 		} else {
-			m.logger.Warn("could not get network interface(s)", slog.String("vm", params.Name), slog.String("error", err.Error()))
+			m.logger.Warn("could not get network interface(s)", slog.String("vm", name), slog.String("error", err.Error()))
 		}
 	}
 
-	m.logger.Debug("retrieved VM info", slog.String("vm", params.Name), slog.String("state", vmInfo.State))
-
 	return vmInfo, nil
 }
 
-// ListAllVirtualMachines retrieves information about all virtual machines.
+// listConcurrency bounds the domains whose details are gathered at once when listing a host.
+const listConcurrency = 8
+
+// ListAllVirtualMachines retrieves information about all virtual machines, gathering
+// the details of the listed domains concurrently.
 func (m *Manager) ListAllVirtualMachines(ctx context.Context, hypervisor dependencies.HypervisorContext) ([]parameters.VMInfo, error) {
 	// List all domains (both active and inactive)
-	domains, err := hypervisor.Conn.ListAllDomains(libvirt.CONNECT_LIST_DOMAINS_ACTIVE | libvirt.CONNECT_LIST_DOMAINS_INACTIVE)
+	domains, err := m.listDomains(hypervisor, libvirt.CONNECT_LIST_DOMAINS_ACTIVE|libvirt.CONNECT_LIST_DOMAINS_INACTIVE)
 	if err != nil {
 		return nil, fmt.Errorf("could not list domains: %w", err)
 	}
+	defer domains.Close()
 
-	var vmInfos []parameters.VMInfo
-	for _, domain := range domains {
-		name, err := domain.GetName()
-		if err != nil {
-			m.logger.Warn("could not get domain name", slog.String("error", err.Error()))
-			continue
-		}
+	results := make([]*parameters.VMInfo, len(domains))
 
-		vmInfo, err := m.GetVirtualMachineInfo(ctx, hypervisor, parameters.QueryVM{Name: name})
-		if err != nil {
-			m.logger.Warn("could not get VM info", slog.String("vm", name), slog.String("error", err.Error()))
-			continue
-		}
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(listConcurrency)
+	for i := range domains {
+		group.Go(func() error {
+			if err := groupCtx.Err(); err != nil {
+				return err
+			}
+
+			name, err := domains[i].GetName()
+			if err != nil {
+				m.logger.Warn("could not get domain name", slog.String("error", err.Error()))
+				return nil
+			}
+
+			vmInfo, err := m.domainInfo(&domains[i], name)
+			if err != nil {
+				// The domain may have been undefined since it was listed.
+				m.logger.Warn("could not get VM info", slog.String("vm", name), slog.String("error", err.Error()))
+				return nil
+			}
+			results[i] = &vmInfo
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
 
-		vmInfos = append(vmInfos, vmInfo)
+	var vmInfos []parameters.VMInfo
+	for _, vmInfo := range results {
+		if vmInfo != nil {
+			vmInfos = append(vmInfos, *vmInfo)
+		}
 	}
 
 	m.logger.Debug("listed all VMs", slog.Int("count", len(vmInfos)))
@@ -305,12 +579,14 @@ func domainStateToString(state libvirt.DomainState) string {
 	}
 }
 
-// DeleteVirtualMachine stops and removes a virtual machine.
-func (m *Manager) DeleteVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.DeleteVM) (string, error) {
-	domain, err := hypervisor.Conn.LookupDomainByName(params.Name)
+// DeleteVirtualMachine stops and removes a virtual machine. Disks that references lists for
+// other domains are kept; on success the VM is removed from references.
+func (m *Manager) DeleteVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.DeleteVM, references parameters.DiskReferences) (string, error) {
+	domain, err := m.lookupDomain(hypervisor, params.Name)
 	if err != nil {
 		return "", fmt.Errorf("could not look up VM by name: %w", err)
 	}
+	defer domain.Close()
 	m.logger.Debug("found VM", slog.String("vm", params.Name))
 
 	domainXMLString, err := domain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE)
@@ -329,17 +605,25 @@ func (m *Manager) DeleteVirtualMachine(ctx context.Context, hypervisor dependenc
 		return "", fmt.Errorf("could not get VM UUID: %w", err)
 	}
 
-	for _, disk := range domainXML.Devices.Disks {
+	// Disks shared with other domains, directly or as backing files, must survive this VM.
+	for _, path := range domainDiskFiles(domainXML) {
+		if params.KeepDisks {
+			m.logger.Info("keeping disk", slog.String("vm", params.Name), slog.String("path", path))
+			continue
+		}
+		if !m.removableDisk(params.Name, path, references) {
+			continue
+		}
+
 		m.logger.Debug("deleting disk",
 			slog.String("vm", params.Name),
-			slog.String("type", disk.Driver.Type),
-			slog.String("path", disk.Source.File.File),
+			slog.String("path", path),
 		)
 
-		if err := fileops.RemoveFile(ctx, hypervisor.Executor, disk.Source.File.File); err != nil {
+		if err := fileops.RemoveFile(ctx, hypervisor.Executor, path); err != nil {
 			m.logger.Warn("failed to delete disk",
 				slog.String("vm", params.Name),
-				slog.String("path", disk.Source.File.File),
+				slog.String("path", path),
 				slog.String("error", err.Error()),
 			)
 		}
@@ -352,17 +636,34 @@ func (m *Manager) DeleteVirtualMachine(ctx context.Context, hypervisor dependenc
 		m.logger.Debug("destroyed running VM", slog.String("vm", params.Name))
 	}
 
-	if err = domain.Undefine(); err != nil {
+	// A container's root filesystem is removed once nothing runs from it anymore.
+	if rootfs := ContainerRootfs(domainXML); rootfs != "" && rootfs != "/" && !params.KeepDisks {
+		if err := m.paths.Check(rootfs); err != nil {
+			m.logger.Warn("keeping root filesystem outside allowed paths",
+				slog.String("vm", params.Name),
+				slog.String("path", rootfs),
+			)
+		} else if err := fileops.RemoveDirectory(ctx, hypervisor.Executor, rootfs); err != nil {
+			m.logger.Warn("failed to delete root filesystem",
+				slog.String("vm", params.Name),
+				slog.String("path", rootfs),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+
+	if err = m.undefineDomain(domain, domainXML); err != nil {
 		return "", fmt.Errorf("could not undefine VM: %w", err)
 	}
 	m.logger.Info("undefined VM from libvirt", slog.String("vm", params.Name))
+	forgetDiskUser(references, params.Name)
 
 	return vmUUID, nil
 }
 
-// FindVirtualMachine looks up a virtual machine by name.
-func (m *Manager) FindVirtualMachine(hypervisor dependencies.HypervisorContext, name string) (*libvirt.Domain, error) {
-	domain, err := hypervisor.Conn.LookupDomainByName(name)
+// FindVirtualMachine looks up a virtual machine by name. The caller must Close the returned domain.
+func (m *Manager) FindVirtualMachine(hypervisor dependencies.HypervisorContext, name string) (*domainRef, error) {
+	domain, err := m.lookupDomain(hypervisor, name)
 	if err != nil {
 		return nil, fmt.Errorf("could not look up VM by name: %w", err)
 	}
@@ -373,13 +674,14 @@ func (m *Manager) FindVirtualMachine(hypervisor dependencies.HypervisorContext,
 
 // CheckVirtualMachineExistence checks if a VM exists.
 func (m *Manager) CheckVirtualMachineExistence(hypervisor dependencies.HypervisorContext, name string) (bool, error) {
-	_, err := hypervisor.Conn.LookupDomainByName(name)
+	domain, err := m.lookupDomain(hypervisor, name)
 	if err != nil {
-		if err.(libvirt.Error).Code == libvirt.ERR_NO_DOMAIN {
+		if errors.Is(err, errdefs.ErrVMNotFound) {
 			return false, nil
 		}
 		return false, fmt.Errorf("error checking if VM exists: %w", err)
 	}
+	domain.Close()
 
 	return true, nil
 }
@@ -401,30 +703,48 @@ func (m *Manager) ToLibvirtXML(domain *libvirt.Domain) (libvirtxml.Domain, error
 	return domainXML, nil
 }
 
-// CloneVirtualMachine clones a VM from a base domain XML without starting it.
-func (m *Manager) CloneVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, baseDomainXML libvirtxml.Domain, targetInfo parameters.TargetVMSpec, virtualMachineUUID uuid.UUID) error {
-	newDomainXML := baseDomainXML
+// GetVirtualMachineXML reads the persistent definition of a virtual machine.
+func (m *Manager) GetVirtualMachineXML(hypervisor dependencies.HypervisorContext, name string) (libvirtxml.Domain, error) {
+	domain, err := m.lookupDomain(hypervisor, name)
+	if err != nil {
+		return libvirtxml.Domain{}, fmt.Errorf("could not look up VM by name: %w", err)
+	}
+	defer domain.Close()
+
+	return m.ToLibvirtXML(domain.Domain)
+}
+
+// CloneVirtualMachine clones a VM from a base domain XML without starting it. The clone gets
+// its own machine identity, and its cloud-init CD-ROM is pointed at cloudInitISOPath, or
+// removed when that is empty.
+func (m *Manager) CloneVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, baseDomainXML libvirtxml.Domain, targetInfo parameters.TargetVMSpec, virtualMachineUUID uuid.UUID, cloudInitISOPath string) error {
+	newDomainXML, err := CopyDomainXML(baseDomainXML)
+	if err != nil {
+		return err
+	}
 	newDomainXML.Name = targetInfo.Name
 	newDomainXML.UUID = virtualMachineUUID.String()
 	newDomainXML.VCPU.Value = uint(targetInfo.VCPUCount)
-	newDomainXML.CurrentMemory.Value = uint(targetInfo.MemoryMB << 10)
-	newDomainXML.CurrentMemory.Unit = "KiB"
-	newDomainXML.Memory.Value = uint(targetInfo.MemoryMB << 10)
-	newDomainXML.Memory.Unit = "KiB"
+	SetDomainMemory(&newDomainXML, targetInfo.MemoryMB)
 	for idx, disk := range newDomainXML.Devices.Disks {
-		if disk.Driver.Type == "qcow2" {
+		if disk.Device == "disk" && disk.Source != nil && disk.Source.File != nil {
 			disk.Source.File.File = targetInfo.DiskPath
 			newDomainXML.Devices.Disks[idx] = disk
 			break
 		}
 	}
+	ReplaceCloudInitISO(&newDomainXML, cloudInitISOPath)
+
+	if err := m.regenerateIdentity(&newDomainXML); err != nil {
+		return err
+	}
 
 	newDomainXMLString, err := newDomainXML.Marshal()
 	if err != nil {
 		return fmt.Errorf("could not serialize Libvirt XML to string: %w", err)
 	}
 
-	_, err = hypervisor.Conn.DomainDefineXML(newDomainXMLString)
+	err = m.defineDomain(hypervisor, newDomainXMLString)
 	if err != nil {
 		return fmt.Errorf("could not define VM from Libvirt XML: %w", err)
 	}
@@ -0,0 +1,99 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/errdefs"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"libvirt.org/go/libvirtxml"
+)
+
+// MaxCDROMs bounds the extra CD-ROM drives of a VM.
+const MaxCDROMs = 8
+
+// CloudInitTarget is the target of the cloud-init CD-ROM drive in the domain template.
+const CloudInitTarget = "hdc"
+
+// CDROMTarget returns the SATA target of the i-th extra CD-ROM drive.
+// Targets start at sdd, as the cloud-init drive takes SATA unit 2.
+func CDROMTarget(i int) string {
+	return "sd" + string(rune('d'+i))
+}
+
+// cdromXML builds the definition of a CD-ROM drive holding path, or an empty drive.
+func cdromXML(target, path string) (string, error) {
+	disk := libvirtxml.DomainDisk{
+		Device:   "cdrom",
+		Driver:   &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "raw"},
+		Target:   &libvirtxml.DomainDiskTarget{Dev: target, Bus: "sata"},
+		ReadOnly: &libvirtxml.DomainDiskReadOnly{},
+	}
+	if path != "" {
+		disk.Source = &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: path}}
+	}
+	return disk.Marshal()
+}
+
+func findCDROM(domainXML libvirtxml.Domain, target string) *libvirtxml.DomainDisk {
+	if domainXML.Devices == nil {
+		return nil
+	}
+	for i, disk := range domainXML.Devices.Disks {
+		if disk.Device == "cdrom" && disk.Target != nil && disk.Target.Dev == target {
+			return &domainXML.Devices.Disks[i]
+		}
+	}
+	return nil
+}
+
+// ChangeMedia inserts an ISO into, or ejects the media of, a CD-ROM drive of a VM.
+// The change applies to the running guest and persists in its definition.
+func (m *Manager) ChangeMedia(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.ChangeMedia) error {
+	domain, err := m.lookupDomain(hypervisor, params.Name)
+	if err != nil {
+		return fmt.Errorf("could not look up VM by name: %w", err)
+	}
+	defer domain.Close()
+
+	domainXML, err := m.ToLibvirtXML(domain.Domain)
+	if err != nil {
+		return err
+	}
+
+	target := params.Device
+	if target == "" {
+		target = CDROMTarget(0)
+	}
+	cdrom := findCDROM(domainXML, target)
+	if cdrom == nil {
+		return fmt.Errorf("%w: VM %s has no CD-ROM drive %s", errdefs.ErrDeviceNotFound, params.Name, target)
+	}
+
+	if params.Path == "" {
+		cdrom.Source = nil
+	} else {
+		cdrom.Source = &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: params.Path}}
+	}
+	deviceXML, err := cdrom.Marshal()
+	if err != nil {
+		return fmt.Errorf("could not serialize CD-ROM drive: %w", err)
+	}
+
+	flags, err := deviceModifyFlags(domain.Domain)
+	if err != nil {
+		return err
+	}
+	if err := domain.UpdateDeviceFlags(deviceXML, flags); err != nil {
+		return fmt.Errorf("could not change media of CD-ROM drive %s: %w", target, err)
+	}
+
+	if params.Path == "" {
+		m.logger.Info("ejected media", slog.String("vm", params.Name), slog.String("device", target))
+	} else {
+		m.logger.Info("inserted media", slog.String("vm", params.Name), slog.String("device", target), slog.String("path", params.Path))
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/cpuset"
+	"github.com/terabiome/homonculus/pkg/units"
+	"libvirt.org/go/libvirt"
+	"libvirt.org/go/libvirtxml"
+)
+
+// validateMemory checks the memory of a VM against the host. A VM bound strictly to NUMA nodes
+// must fit into their free memory, since it cannot fall back to other nodes. Otherwise the VM must
+// fit into the host at all, and memory beyond the free memory or the overcommit ratio is logged.
+// Hugepage-backed VMs are checked against their free pages instead.
+func (m *Manager) validateMemory(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.CreateVM) error {
+	tuning := params.Tuning
+	if tuning != nil && tuning.Hugepages != nil {
+		return nil
+	}
+	memoryKiB := units.MiBToKiB(uint64(params.MemoryMB))
+
+	if tuning != nil && tuning.NUMAMemory != nil && tuning.NUMAMemory.Mode == "strict" && tuning.NUMAMemory.Nodeset != "" {
+		return m.validateNodeMemory(ctx, hypervisor, params.Name, memoryKiB, tuning.NUMAMemory.Nodeset)
+	}
+
+	nodeInfo, err := hypervisor.Conn.GetNodeInfo()
+	if err != nil {
+		return fmt.Errorf("could not get node info: %w", err)
+	}
+	if memoryKiB > nodeInfo.Memory {
+		return fmt.Errorf("memory_mb (%d) exceeds the %d MiB of memory of host %s", params.MemoryMB, units.KiBToMiB(nodeInfo.Memory), hypervisor.Host)
+	}
+
+	freeMemory, err := hypervisor.Conn.GetFreeMemory()
+	if err != nil {
+		return fmt.Errorf("could not get free memory: %w", err)
+	}
+	if freeKiB := units.BytesToKiB(freeMemory); memoryKiB > freeKiB {
+		m.logger.Warn("VM memory exceeds free host memory",
+			slog.String("vm", params.Name),
+			slog.Int64("memory_mb", params.MemoryMB),
+			slog.Uint64("free_memory_mb", units.KiBToMiB(freeKiB)),
+		)
+	}
+
+	if m.memoryOvercommitRatio <= 0 {
+		return nil
+	}
+	allocatedKiB, err := m.allocatedMemoryKiB(hypervisor, params.Name)
+	if err != nil {
+		return err
+	}
+	limitKiB := uint64(float64(nodeInfo.Memory) * m.memoryOvercommitRatio)
+	if allocatedKiB+memoryKiB > limitKiB {
+		m.logger.Warn("VM memory overcommits host",
+			slog.String("vm", params.Name),
+			slog.Int64("memory_mb", params.MemoryMB),
+			slog.Uint64("allocated_memory_mb", units.KiBToMiB(allocatedKiB)),
+			slog.Uint64("total_memory_mb", units.KiBToMiB(nodeInfo.Memory)),
+			slog.Float64("overcommit_ratio", m.memoryOvercommitRatio),
+		)
+	}
+	return nil
+}
+
+// validateNodeMemory checks that memoryKiB fits into the free memory of the NUMA nodes of nodeset.
+// Hosts that do not report per-node free memory are not checked.
+func (m *Manager) validateNodeMemory(ctx context.Context, hypervisor dependencies.HypervisorContext, name string, memoryKiB uint64, nodeset string) error {
+	ids, err := cpuset.Parse(nodeset)
+	if err != nil {
+		return fmt.Errorf("numa_memory.nodeset: %w", err)
+	}
+	selected := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	numaNodes, err := m.GetNUMATopology(ctx, hypervisor)
+	if err != nil {
+		return fmt.Errorf("could not load host topology: %w", err)
+	}
+
+	var freeKiB uint64
+	for _, node := range numaNodes {
+		if selected[node.ID] {
+			freeKiB += node.FreeMemoryKiB
+		}
+	}
+	if freeKiB == 0 {
+		m.logger.Debug("host reports no free memory for NUMA nodes, skipping check",
+			slog.String("vm", name),
+			slog.String("nodeset", nodeset),
+		)
+		return nil
+	}
+
+	if memoryKiB > freeKiB {
+		return fmt.Errorf("memory_mb (%d) exceeds the %d MiB free on NUMA nodes %s of host %s, which strict numa_memory confines the VM to",
+			units.KiBToMiB(memoryKiB), units.KiBToMiB(freeKiB), nodeset, hypervisor.Host)
+	}
+	return nil
+}
+
+// allocatedMemoryKiB sums the maximum memory of every domain on the host except the named one.
+func (m *Manager) allocatedMemoryKiB(hypervisor dependencies.HypervisorContext, exclude string) (uint64, error) {
+	domains, err := m.listDomains(hypervisor, libvirt.CONNECT_LIST_DOMAINS_ACTIVE|libvirt.CONNECT_LIST_DOMAINS_INACTIVE)
+	if err != nil {
+		return 0, fmt.Errorf("could not list domains: %w", err)
+	}
+	defer domains.Close()
+
+	var allocated uint64
+	for _, domain := range domains {
+		name, err := domain.GetName()
+		if err != nil || name == exclude {
+			continue
+		}
+		info, err := domain.GetInfo()
+		if err != nil {
+			m.logger.Warn("could not get domain info", slog.String("vm", name), slog.String("error", err.Error()))
+			continue
+		}
+		allocated += info.MaxMem
+	}
+	return allocated, nil
+}
+
+// SetDomainMemory sets the maximum and current memory of a domain to memoryMB MiB, in KiB.
+func SetDomainMemory(domainXML *libvirtxml.Domain, memoryMB int64) {
+	memoryKiB := uint(units.MiBToKiB(memoryMB))
+	domainXML.Memory = &libvirtxml.DomainMemory{Value: memoryKiB, Unit: "KiB"}
+	domainXML.CurrentMemory = &libvirtxml.DomainCurrentMemory{Value: memoryKiB, Unit: "KiB"}
+}
+
+// DomainMemoryKiB returns the maximum memory of a domain in KiB, whatever unit it is given in.
+func DomainMemoryKiB(domainXML libvirtxml.Domain) uint64 {
+	if domainXML.Memory == nil {
+		return 0
+	}
+	return units.LibvirtToKiB(uint64(domainXML.Memory.Value), domainXML.Memory.Unit)
+}
+
+// DomainCurrentMemoryKiB returns the memory currently assigned to a domain in KiB, or its
+// maximum memory if the definition does not set it.
+func DomainCurrentMemoryKiB(domainXML libvirtxml.Domain) uint64 {
+	if domainXML.CurrentMemory == nil {
+		return DomainMemoryKiB(domainXML)
+	}
+	return units.LibvirtToKiB(uint64(domainXML.CurrentMemory.Value), domainXML.CurrentMemory.Unit)
+}
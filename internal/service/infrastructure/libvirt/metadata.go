@@ -0,0 +1,162 @@
+package libvirt
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"libvirt.org/go/libvirtxml"
+)
+
+// MetadataNamespace is the XML namespace of the homonculus element stored in a domain's <metadata>.
+const MetadataNamespace = "https://github.com/terabiome/homonculus"
+
+// ManagedBy is the value of the managed-by element of the domains homonculus defines.
+const ManagedBy = "homonculus"
+
+// DomainMetadata is the homonculus-owned section of a domain's <metadata> element. Besides the
+// labels it records who defined the domain and from which spec, so the ownership of a domain
+// can be told from its definition alone, without the state store.
+type DomainMetadata struct {
+	XMLName   xml.Name        `xml:"https://github.com/terabiome/homonculus instance"`
+	ManagedBy string          `xml:"managed-by,omitempty"`
+	Cluster   string          `xml:"cluster,omitempty"`
+	Namespace string          `xml:"namespace,omitempty"`
+	Owner     string          `xml:"owner,omitempty"`
+	SpecHash  string          `xml:"spec-hash,omitempty"`
+	CreatedAt string          `xml:"created-at,omitempty"` // RFC 3339
+	Adopted   bool            `xml:"adopted,omitempty"`
+	Labels    []MetadataLabel `xml:"labels>label,omitempty"`
+}
+
+// MetadataLabel is a single key/value label entry.
+type MetadataLabel struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// NewDomainMetadata builds the metadata of the domain a VM is defined with, from its ownership
+// and labels. Labels are sorted by key for stable output.
+func NewDomainMetadata(params parameters.CreateVM) DomainMetadata {
+	metadata := DomainMetadata{}
+	metadata.SetOwnership(params.Ownership)
+	metadata.SetLabels(params.Labels)
+	return metadata
+}
+
+// SetLabels replaces the labels, sorted by key for stable output.
+func (m *DomainMetadata) SetLabels(labels map[string]string) {
+	m.Labels = nil
+	for key, value := range labels {
+		m.Labels = append(m.Labels, MetadataLabel{Key: key, Value: value})
+	}
+	sort.Slice(m.Labels, func(i, j int) bool {
+		return m.Labels[i].Key < m.Labels[j].Key
+	})
+}
+
+// LabelMap returns the labels as a map.
+func (m DomainMetadata) LabelMap() map[string]string {
+	if len(m.Labels) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(m.Labels))
+	for _, label := range m.Labels {
+		result[label.Key] = label.Value
+	}
+	return result
+}
+
+// SetOwnership records the ownership of the domain, marking it as managed by homonculus.
+func (m *DomainMetadata) SetOwnership(ownership parameters.Ownership) {
+	m.ManagedBy = ManagedBy
+	m.Cluster = ownership.Cluster
+	m.Namespace = ownership.Namespace
+	m.Owner = ownership.Owner
+	m.SpecHash = ownership.SpecHash
+	m.Adopted = ownership.Adopted
+	m.CreatedAt = ""
+	if !ownership.CreatedAt.IsZero() {
+		m.CreatedAt = ownership.CreatedAt.UTC().Format(time.RFC3339)
+	}
+}
+
+// Managed reports whether the domain was defined by homonculus. Domains defined before
+// ownership was recorded only carry labels, and count as managed as well.
+func (m DomainMetadata) Managed() bool {
+	return m.ManagedBy == ManagedBy || m.XMLName.Local != ""
+}
+
+// Ownership returns the recorded ownership of the domain, or nil if homonculus did not define it.
+func (m DomainMetadata) Ownership() *parameters.Ownership {
+	if !m.Managed() {
+		return nil
+	}
+	ownership := &parameters.Ownership{
+		Cluster:   m.Cluster,
+		Namespace: m.Namespace,
+		Owner:     m.Owner,
+		SpecHash:  m.SpecHash,
+		Adopted:   m.Adopted,
+	}
+	if createdAt, err := time.Parse(time.RFC3339, m.CreatedAt); err == nil {
+		ownership.CreatedAt = createdAt
+	}
+	return ownership
+}
+
+// Render serializes the metadata into an XML fragment suitable for embedding in <metadata>.
+func (m DomainMetadata) Render() (string, error) {
+	bytes, err := xml.Marshal(m)
+	if err != nil {
+		return "", fmt.Errorf("could not serialize domain metadata: %w", err)
+	}
+	return string(bytes), nil
+}
+
+// ParseDomainMetadata extracts the homonculus metadata element from a parsed domain.
+// Domains without homonculus metadata yield an empty DomainMetadata.
+func ParseDomainMetadata(domainXML libvirtxml.Domain) (DomainMetadata, error) {
+	metadata := DomainMetadata{}
+	if domainXML.Metadata == nil || domainXML.Metadata.XML == "" {
+		return metadata, nil
+	}
+
+	decoder := xml.NewDecoder(strings.NewReader(domainXML.Metadata.XML))
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return metadata, nil
+		}
+		if err != nil {
+			return metadata, fmt.Errorf("could not parse domain metadata: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Space != MetadataNamespace || start.Name.Local != "instance" {
+			continue
+		}
+
+		if err := decoder.DecodeElement(&metadata, &start); err != nil {
+			return metadata, fmt.Errorf("could not decode homonculus metadata: %w", err)
+		}
+		return metadata, nil
+	}
+}
+
+// ReplaceDomainMetadata replaces the <metadata> of a domain with the homonculus metadata,
+// e.g. for a clone, which must not inherit the ownership of its base. Metadata of other
+// applications is dropped.
+func ReplaceDomainMetadata(domainXML *libvirtxml.Domain, metadata DomainMetadata) error {
+	rendered, err := metadata.Render()
+	if err != nil {
+		return err
+	}
+	domainXML.Metadata = &libvirtxml.DomainMetadata{XML: rendered}
+	return nil
+}
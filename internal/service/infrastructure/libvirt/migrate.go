@@ -0,0 +1,47 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"libvirt.org/go/libvirt"
+)
+
+// MigrateVirtualMachine moves a VM to another host. A running VM is migrated live and a
+// stopped one offline; either way the definition moves with it and is removed from the source.
+// Disks are not copied, so they must be on storage both hosts share.
+func (m *Manager) MigrateVirtualMachine(ctx context.Context, source, destination dependencies.HypervisorContext, name string) error {
+	domain, err := m.lookupDomain(source, name)
+	if err != nil {
+		return fmt.Errorf("could not look up VM by name: %w", err)
+	}
+	defer domain.Close()
+
+	state, _, err := domain.GetState()
+	if err != nil {
+		return fmt.Errorf("could not get VM state: %w", err)
+	}
+
+	flags := libvirt.MIGRATE_PERSIST_DEST | libvirt.MIGRATE_UNDEFINE_SOURCE
+	if state == libvirt.DOMAIN_RUNNING || state == libvirt.DOMAIN_PAUSED {
+		flags |= libvirt.MIGRATE_LIVE
+	} else {
+		flags |= libvirt.MIGRATE_OFFLINE
+	}
+
+	migrated, err := domain.Migrate(destination.Conn, flags, "", "", 0)
+	if err != nil {
+		return fmt.Errorf("could not migrate VM from %s to %s: %w", source.Host, destination.Host, err)
+	}
+	migrated.Free()
+
+	m.logger.Info("migrated VM",
+		slog.String("vm", name),
+		slog.String("from", source.Host),
+		slog.String("to", destination.Host),
+		slog.Bool("live", flags&libvirt.MIGRATE_LIVE != 0),
+	)
+	return nil
+}
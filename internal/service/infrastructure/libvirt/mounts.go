@@ -0,0 +1,66 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/executor/fileops"
+)
+
+// virtiofsdPaths are the locations distributions install virtiofsd to.
+var virtiofsdPaths = []string{
+	"/usr/libexec/virtiofsd",
+	"/usr/lib/qemu/virtiofsd",
+	"/usr/lib/virtiofsd",
+	"/usr/bin/virtiofsd",
+}
+
+// prepareHostBindMounts validates the bind mounts of a VM against the host. libvirt spawns one
+// virtiofsd per virtiofs mount when the domain starts; its binary is located here so a missing
+// daemon fails the create instead of the first boot.
+func (m *Manager) prepareHostBindMounts(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.CreateVM) ([]HostBindMount, string, error) {
+	mounts := make([]HostBindMount, 0, len(params.HostBindMounts))
+	needsVirtiofsd := false
+
+	for i, mount := range params.HostBindMounts {
+		switch mount.Driver {
+		case "", "virtiofs":
+			mount.Driver = "virtiofs"
+			needsVirtiofsd = true
+		case "9p":
+		default:
+			return nil, "", fmt.Errorf("host_bind_mounts[%d]: invalid driver '%s': must be 'virtiofs' or '9p'", i, mount.Driver)
+		}
+
+		if mount.SourceDir == "" || mount.TargetDir == "" {
+			return nil, "", fmt.Errorf("host_bind_mounts[%d]: source_dir and target_dir are required", i)
+		}
+
+		isDir, err := fileops.IsDirectory(ctx, hypervisor.Executor, mount.SourceDir)
+		if err != nil {
+			return nil, "", fmt.Errorf("host_bind_mounts[%d]: %w", i, err)
+		}
+		if !isDir {
+			return nil, "", fmt.Errorf("host_bind_mounts[%d]: source_dir %s is not a directory on host %s", i, mount.SourceDir, hypervisor.Host)
+		}
+
+		mounts = append(mounts, HostBindMount{
+			SourceDir: mount.SourceDir,
+			Driver:    mount.Driver,
+			ReadOnly:  mount.ReadOnly,
+			Tag:       mount.Tag,
+		})
+	}
+
+	if !needsVirtiofsd {
+		return mounts, "", nil
+	}
+
+	virtiofsdPath, err := fileops.FindExecutable(ctx, hypervisor.Executor, virtiofsdPaths...)
+	if err != nil {
+		return nil, "", fmt.Errorf("virtiofs mounts need virtiofsd on host %s: %w", hypervisor.Host, err)
+	}
+	return mounts, virtiofsdPath, nil
+}
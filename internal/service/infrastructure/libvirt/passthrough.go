@@ -0,0 +1,260 @@
+package libvirt
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"libvirt.org/go/libvirt"
+	"libvirt.org/go/libvirtxml"
+)
+
+// pciAddress identifies a host PCI function.
+type pciAddress struct {
+	Domain, Bus, Slot, Function uint
+}
+
+func (a pciAddress) String() string {
+	return fmt.Sprintf("%04x:%02x:%02x.%x", a.Domain, a.Bus, a.Slot, a.Function)
+}
+
+// parsePCIAddress parses "0000:41:00.0" or the short form "41:00.0".
+func parsePCIAddress(value string) (pciAddress, error) {
+	var address pciAddress
+	if strings.Count(value, ":") == 1 {
+		value = "0000:" + value
+	}
+	if _, err := fmt.Sscanf(value, "%x:%x:%x.%x", &address.Domain, &address.Bus, &address.Slot, &address.Function); err != nil {
+		return address, fmt.Errorf("invalid PCI address %q: expected [dddd:]bb:ss.f", value)
+	}
+	return address, nil
+}
+
+func pciAddressFromXML(domain, bus, slot, function *uint) (pciAddress, bool) {
+	if domain == nil || bus == nil || slot == nil || function == nil {
+		return pciAddress{}, false
+	}
+	return pciAddress{Domain: *domain, Bus: *bus, Slot: *slot, Function: *function}, true
+}
+
+// hostPCIDevice is a PCI function of the host as reported by libvirt.
+type hostPCIDevice struct {
+	address    pciAddress
+	vendorID   string
+	productID  string
+	iommuGroup int
+	groupPeers []pciAddress
+	bridge     bool
+}
+
+// normalizePCIID strips the 0x prefix and lowercases a vendor or product id.
+func normalizePCIID(id string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(id, "0x"), "0X"))
+}
+
+// listHostPCIDevices returns every PCI function of the host, ordered by address.
+func (m *Manager) listHostPCIDevices(hypervisor dependencies.HypervisorContext) (map[pciAddress]hostPCIDevice, []pciAddress, error) {
+	nodeDevices, err := hypervisor.Conn.ListAllNodeDevices(libvirt.CONNECT_LIST_NODE_DEVICES_CAP_PCI_DEV)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not list host PCI devices: %w", err)
+	}
+
+	devices := make(map[pciAddress]hostPCIDevice)
+	var order []pciAddress
+	for _, nodeDevice := range nodeDevices {
+		xmlString, err := nodeDevice.GetXMLDesc(0)
+		nodeDevice.Free()
+		if err != nil {
+			m.logger.Warn("could not read node device XML", slog.String("error", err.Error()))
+			continue
+		}
+
+		nodeDeviceXML := libvirtxml.NodeDevice{}
+		if err := nodeDeviceXML.Unmarshal(xmlString); err != nil {
+			m.logger.Warn("could not parse node device XML", slog.String("error", err.Error()))
+			continue
+		}
+
+		pci := nodeDeviceXML.Capability.PCI
+		if pci == nil {
+			continue
+		}
+		address, ok := pciAddressFromXML(pci.Domain, pci.Bus, pci.Slot, pci.Function)
+		if !ok {
+			continue
+		}
+
+		device := hostPCIDevice{
+			address:    address,
+			vendorID:   normalizePCIID(pci.Vendor.ID),
+			productID:  normalizePCIID(pci.Product.ID),
+			iommuGroup: -1,
+		}
+		if pci.IOMMUGroup != nil {
+			device.iommuGroup = pci.IOMMUGroup.Number
+			for _, peer := range pci.IOMMUGroup.Address {
+				if peerAddress, ok := pciAddressFromXML(peer.Domain, peer.Bus, peer.Slot, peer.Function); ok && peerAddress != address {
+					device.groupPeers = append(device.groupPeers, peerAddress)
+				}
+			}
+		}
+		for _, capability := range pci.Capabilities {
+			if capability.Bridge != nil {
+				device.bridge = true
+			}
+		}
+
+		devices[address] = device
+		order = append(order, address)
+	}
+
+	sort.Slice(order, func(i, j int) bool {
+		return order[i].String() < order[j].String()
+	})
+	return devices, order, nil
+}
+
+// assignedPCIDevices maps every PCI function passed through to a domain on the host, except
+// the named one, to the domain that owns it.
+func (m *Manager) assignedPCIDevices(hypervisor dependencies.HypervisorContext, exclude string) (map[pciAddress]string, error) {
+	domains, err := m.listDomains(hypervisor, libvirt.CONNECT_LIST_DOMAINS_ACTIVE|libvirt.CONNECT_LIST_DOMAINS_INACTIVE)
+	if err != nil {
+		return nil, fmt.Errorf("could not list domains: %w", err)
+	}
+	defer domains.Close()
+
+	assigned := make(map[pciAddress]string)
+	for _, domain := range domains {
+		domainXML, err := m.ToLibvirtXML(&domain)
+		if err != nil {
+			m.logger.Warn("could not read domain XML", slog.String("error", err.Error()))
+			continue
+		}
+		if domainXML.Name == exclude || domainXML.Devices == nil {
+			continue
+		}
+
+		for _, hostdev := range domainXML.Devices.Hostdevs {
+			if hostdev.SubsysPCI == nil || hostdev.SubsysPCI.Source == nil || hostdev.SubsysPCI.Source.Address == nil {
+				continue
+			}
+			source := hostdev.SubsysPCI.Source.Address
+			if address, ok := pciAddressFromXML(source.Domain, source.Bus, source.Slot, source.Function); ok {
+				assigned[address] = domainXML.Name
+			}
+		}
+	}
+
+	return assigned, nil
+}
+
+// resolveHostDevices turns the requested PCI addresses and vendor:device selectors into concrete host
+// functions. Every function must sit in an IOMMU group whose members are not passed through to other
+// VMs; non-bridge group members that were not requested explicitly are passed through as well.
+func (m *Manager) resolveHostDevices(hypervisor dependencies.HypervisorContext, params parameters.CreateVM) ([]HostDevice, error) {
+	if len(params.HostDevices) == 0 {
+		return nil, nil
+	}
+
+	devices, order, err := m.listHostPCIDevices(hypervisor)
+	if err != nil {
+		return nil, err
+	}
+	assigned, err := m.assignedPCIDevices(hypervisor, params.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[pciAddress]bool)
+	var selection []pciAddress
+
+	for i, request := range params.HostDevices {
+		field := fmt.Sprintf("hostdevs[%d]", i)
+
+		var address pciAddress
+		switch {
+		case request.Address != "" && request.VendorDevice != "":
+			return nil, fmt.Errorf("%s: set either address or vendor_device, not both", field)
+		case request.Address != "":
+			address, err = parsePCIAddress(request.Address)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", field, err)
+			}
+			if _, ok := devices[address]; !ok {
+				return nil, fmt.Errorf("%s: PCI device %s does not exist on host %s", field, address, hypervisor.Host)
+			}
+			if owner, ok := assigned[address]; ok {
+				return nil, fmt.Errorf("%s: PCI device %s is already passed through to VM %s", field, address, owner)
+			}
+		case request.VendorDevice != "":
+			vendorID, productID, found := strings.Cut(request.VendorDevice, ":")
+			if !found {
+				return nil, fmt.Errorf("%s: invalid vendor_device %q: expected vvvv:dddd", field, request.VendorDevice)
+			}
+			vendorID, productID = normalizePCIID(vendorID), normalizePCIID(productID)
+
+			matched := false
+			for _, candidate := range order {
+				device := devices[candidate]
+				if device.vendorID != vendorID || device.productID != productID || selected[candidate] {
+					continue
+				}
+				if _, taken := assigned[candidate]; taken {
+					continue
+				}
+				address, matched = candidate, true
+				break
+			}
+			if !matched {
+				return nil, fmt.Errorf("%s: no unassigned PCI device %s on host %s", field, request.VendorDevice, hypervisor.Host)
+			}
+		default:
+			return nil, fmt.Errorf("%s: address or vendor_device is required", field)
+		}
+
+		if selected[address] {
+			return nil, fmt.Errorf("%s: PCI device %s is requested more than once", field, address)
+		}
+		selected[address] = true
+		selection = append(selection, address)
+	}
+
+	for _, address := range append([]pciAddress(nil), selection...) {
+		device := devices[address]
+		if device.iommuGroup < 0 {
+			return nil, fmt.Errorf("PCI device %s has no IOMMU group on host %s; is the IOMMU enabled?", address, hypervisor.Host)
+		}
+
+		for _, peer := range device.groupPeers {
+			if owner, ok := assigned[peer]; ok {
+				return nil, fmt.Errorf("PCI device %s shares IOMMU group %d with %s, which is passed through to VM %s",
+					address, device.iommuGroup, peer, owner)
+			}
+			if selected[peer] || devices[peer].bridge {
+				continue
+			}
+			m.logger.Info("passing through IOMMU group peer",
+				slog.String("vm", params.Name),
+				slog.String("device", address.String()),
+				slog.String("peer", peer.String()),
+				slog.Int("iommu_group", device.iommuGroup),
+			)
+			selected[peer] = true
+			selection = append(selection, peer)
+		}
+	}
+
+	hostDevices := make([]HostDevice, len(selection))
+	for i, address := range selection {
+		hostDevices[i] = HostDevice{
+			Domain:   fmt.Sprintf("0x%04x", address.Domain),
+			Bus:      fmt.Sprintf("0x%02x", address.Bus),
+			Slot:     fmt.Sprintf("0x%02x", address.Slot),
+			Function: fmt.Sprintf("0x%x", address.Function),
+		}
+	}
+	return hostDevices, nil
+}
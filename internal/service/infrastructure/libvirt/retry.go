@@ -0,0 +1,104 @@
+package libvirt
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"libvirt.org/go/libvirt"
+)
+
+// Transient libvirt errors are retried up to retryAttempts times in all, waiting
+// retryInitialBackoff before the first retry and twice as long before every further one,
+// up to retryMaxBackoff. That rides out a libvirtd restart of a few seconds.
+const (
+	retryAttempts       = 5
+	retryInitialBackoff = 500 * time.Millisecond
+	retryMaxBackoff     = 4 * time.Second
+)
+
+// retry runs fn, running it again with backoff while it fails with a transient libvirt error.
+// When the connection to libvirtd was lost, it is reopened before the next attempt.
+// fn must be safe to repeat.
+func (m *Manager) retry(hypervisor dependencies.HypervisorContext, op string, fn func() error) error {
+	backoff := retryInitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt == retryAttempts || !isTransient(err) {
+			return err
+		}
+
+		m.logger.Warn("retrying libvirt operation after transient error",
+			slog.String("host", hypervisor.Host),
+			slog.String("operation", op),
+			slog.Int("attempt", attempt),
+			slog.Duration("backoff", backoff),
+			slog.String("error", err.Error()),
+		)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, retryMaxBackoff)
+
+		if isConnectionLost(err) && hypervisor.Reconnect != nil {
+			if err := hypervisor.Reconnect(); err != nil {
+				m.logger.Warn("failed to reconnect to libvirt", slog.String("host", hypervisor.Host), slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+// isTransient reports whether an operation failing with err may succeed when repeated:
+// the connection to libvirtd was lost, or the domain was busy with another operation.
+func isTransient(err error) bool {
+	return isConnectionLost(err) || isDomainBusy(err)
+}
+
+// isConnectionLost reports whether err means the connection to libvirtd broke, e.g.
+// because libvirtd restarted.
+func isConnectionLost(err error) bool {
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var libvirtErr libvirt.Error
+	if !errors.As(err, &libvirtErr) {
+		return false
+	}
+	switch libvirtErr.Code {
+	case libvirt.ERR_NO_CONNECT, libvirt.ERR_INVALID_CONN, libvirt.ERR_RPC:
+		return true
+	case libvirt.ERR_SYSTEM_ERROR, libvirt.ERR_INTERNAL_ERROR:
+		// e.g. "Cannot recv data: Connection reset by peer" or "client socket is closed"
+		return libvirtErr.Domain == libvirt.FROM_RPC || containsAny(libvirtErr.Message,
+			"connection reset", "broken pipe", "end of file", "socket is closed")
+	}
+	return false
+}
+
+// isDomainBusy reports whether err means the domain was locked by another operation,
+// e.g. "Timed out during operation: cannot acquire state change lock".
+func isDomainBusy(err error) bool {
+	var libvirtErr libvirt.Error
+	if !errors.As(err, &libvirtErr) {
+		return false
+	}
+	switch libvirtErr.Code {
+	case libvirt.ERR_OPERATION_TIMEOUT:
+		return true
+	case libvirt.ERR_OPERATION_FAILED:
+		return containsAny(libvirtErr.Message, "busy", "in progress")
+	}
+	return false
+}
+
+// containsAny reports whether s contains any of substrs, ignoring case.
+func containsAny(s string, substrs ...string) bool {
+	s = strings.ToLower(s)
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
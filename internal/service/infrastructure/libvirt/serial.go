@@ -0,0 +1,87 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/errdefs"
+	"libvirt.org/go/libvirt"
+)
+
+// consoleStream adapts the libvirt stream of a console to an io.ReadWriteCloser.
+type consoleStream struct {
+	stream    *libvirt.Stream
+	closeOnce sync.Once
+}
+
+func (c *consoleStream) Read(p []byte) (int, error) {
+	n, err := c.stream.Recv(p)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (c *consoleStream) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n, err := c.stream.Send(p[written:])
+		if err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Close aborts the stream, which also ends a Read blocked on it.
+func (c *consoleStream) Close() error {
+	c.closeOnce.Do(func() {
+		c.stream.Abort()
+		c.stream.Free()
+	})
+	return nil
+}
+
+// OpenSerialConsole attaches to the serial console of a running VM. With takeOver the session
+// replaces any other session attached to the console; otherwise it fails with
+// errdefs.ErrConsoleBusy while one is. The stream lives on the hypervisor connection, which
+// must be held until the returned console is closed.
+func (m *Manager) OpenSerialConsole(ctx context.Context, hypervisor dependencies.HypervisorContext, name string, takeOver bool) (io.ReadWriteCloser, error) {
+	domain, err := m.lookupDomain(hypervisor, name)
+	if err != nil {
+		return nil, fmt.Errorf("could not look up VM by name: %w", err)
+	}
+	defer domain.Close()
+
+	if state, _, _ := domain.GetState(); state != libvirt.DOMAIN_RUNNING && state != libvirt.DOMAIN_PAUSED {
+		return nil, fmt.Errorf("VM %s is not running", name)
+	}
+
+	stream, err := hypervisor.Conn.NewStream(0)
+	if err != nil {
+		return nil, fmt.Errorf("could not create console stream: %w", err)
+	}
+
+	flags := libvirt.DOMAIN_CONSOLE_SAFE
+	if takeOver {
+		flags |= libvirt.DOMAIN_CONSOLE_FORCE
+	}
+	if err := domain.OpenConsole("", stream, flags); err != nil {
+		stream.Free()
+		if hasErrorCode(err, libvirt.ERR_OPERATION_FAILED) {
+			return nil, fmt.Errorf("%w: %s: %w", errdefs.ErrConsoleBusy, name, err)
+		}
+		return nil, fmt.Errorf("could not open serial console: %w", err)
+	}
+
+	m.logger.Info("opened serial console", slog.String("vm", name), slog.Bool("take_over", takeOver))
+	return &consoleStream{stream: stream}, nil
+}
@@ -0,0 +1,104 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"libvirt.org/go/libvirt"
+	"libvirt.org/go/libvirtxml"
+)
+
+// CreateSnapshot takes a snapshot of a VM, including its memory state if it runs.
+func (m *Manager) CreateSnapshot(ctx context.Context, hypervisor dependencies.HypervisorContext, vmName, snapshotName, description string) error {
+	domain, err := m.lookupDomain(hypervisor, vmName)
+	if err != nil {
+		return fmt.Errorf("could not look up VM by name: %w", err)
+	}
+	defer domain.Close()
+
+	snapshotXML, err := (&libvirtxml.DomainSnapshot{Name: snapshotName, Description: description}).Marshal()
+	if err != nil {
+		return fmt.Errorf("could not serialize snapshot: %w", err)
+	}
+
+	snapshot, err := domain.CreateSnapshotXML(snapshotXML, 0)
+	if err != nil {
+		return fmt.Errorf("could not create snapshot %s: %w", snapshotName, err)
+	}
+	snapshot.Free()
+	m.logger.Info("created snapshot", slog.String("vm", vmName), slog.String("snapshot", snapshotName))
+
+	return nil
+}
+
+// ListSnapshots returns the snapshot names of a VM.
+func (m *Manager) ListSnapshots(hypervisor dependencies.HypervisorContext, vmName string) ([]string, error) {
+	domain, err := m.lookupDomain(hypervisor, vmName)
+	if err != nil {
+		return nil, fmt.Errorf("could not look up VM by name: %w", err)
+	}
+	defer domain.Close()
+
+	snapshots, err := domain.ListAllSnapshots(0)
+	if err != nil {
+		return nil, fmt.Errorf("could not list snapshots: %w", err)
+	}
+
+	var names []string
+	for _, snapshot := range snapshots {
+		name, err := snapshot.GetName()
+		snapshot.Free()
+		if err != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// DeleteSnapshot deletes a snapshot of a VM.
+func (m *Manager) DeleteSnapshot(hypervisor dependencies.HypervisorContext, vmName, snapshotName string) error {
+	domain, err := m.lookupDomain(hypervisor, vmName)
+	if err != nil {
+		return fmt.Errorf("could not look up VM by name: %w", err)
+	}
+	defer domain.Close()
+
+	snapshot, err := domain.SnapshotLookupByName(snapshotName, 0)
+	if err != nil {
+		return fmt.Errorf("could not look up snapshot %s: %w", snapshotName, err)
+	}
+	defer snapshot.Free()
+
+	if err := snapshot.Delete(0); err != nil {
+		return fmt.Errorf("could not delete snapshot %s: %w", snapshotName, err)
+	}
+	m.logger.Info("deleted snapshot", slog.String("vm", vmName), slog.String("snapshot", snapshotName))
+
+	return nil
+}
+
+// RevertSnapshot reverts a VM to a snapshot and leaves it running, booting it if the snapshot
+// was taken while it was shut off.
+func (m *Manager) RevertSnapshot(hypervisor dependencies.HypervisorContext, vmName, snapshotName string) error {
+	domain, err := m.lookupDomain(hypervisor, vmName)
+	if err != nil {
+		return fmt.Errorf("could not look up VM by name: %w", err)
+	}
+	defer domain.Close()
+
+	snapshot, err := domain.SnapshotLookupByName(snapshotName, 0)
+	if err != nil {
+		return fmt.Errorf("could not look up snapshot %s: %w", snapshotName, err)
+	}
+	defer snapshot.Free()
+
+	if err := snapshot.RevertToSnapshot(libvirt.DOMAIN_SNAPSHOT_REVERT_RUNNING); err != nil {
+		return fmt.Errorf("could not revert to snapshot %s: %w", snapshotName, err)
+	}
+	m.logger.Info("reverted to snapshot", slog.String("vm", vmName), slog.String("snapshot", snapshotName))
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package libvirt
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"libvirt.org/go/libvirt"
+)
+
+// GetVirtualMachineStats reads the cumulative CPU, memory, disk and network counters of a VM.
+// Counters a stopped VM does not report stay zero.
+func (m *Manager) GetVirtualMachineStats(hypervisor dependencies.HypervisorContext, name string) (parameters.VMStatsSample, error) {
+	domain, err := m.lookupDomain(hypervisor, name)
+	if err != nil {
+		return parameters.VMStatsSample{}, fmt.Errorf("could not look up VM by name: %w", err)
+	}
+	defer domain.Close()
+
+	info, err := domain.GetInfo()
+	if err != nil {
+		return parameters.VMStatsSample{}, fmt.Errorf("could not get VM info: %w", err)
+	}
+	sample := parameters.VMStatsSample{
+		Time:      time.Now(),
+		State:     domainStateToString(info.State),
+		VCPUCount: info.NrVirtCpu,
+		CPUTimeNs: info.CpuTime,
+		MemoryKiB: info.Memory,
+	}
+	if info.State != libvirt.DOMAIN_RUNNING && info.State != libvirt.DOMAIN_PAUSED {
+		return sample, nil
+	}
+
+	statsTypes := libvirt.DOMAIN_STATS_BALLOON | libvirt.DOMAIN_STATS_BLOCK | libvirt.DOMAIN_STATS_INTERFACE
+	domainStats, err := hypervisor.Conn.GetAllDomainStats([]*libvirt.Domain{domain.Domain}, statsTypes, 0)
+	if err != nil {
+		return sample, fmt.Errorf("could not get VM stats: %w", err)
+	}
+	for _, stats := range domainStats {
+		// The stats hold their own reference to the domain.
+		if stats.Domain != nil {
+			stats.Domain.Free()
+		}
+
+		if stats.Balloon != nil && stats.Balloon.RssSet {
+			sample.MemoryRSSKiB = stats.Balloon.Rss
+		}
+		for _, block := range stats.Block {
+			sample.DiskReadBytes += block.RdBytes
+			sample.DiskWriteBytes += block.WrBytes
+		}
+		for _, net := range stats.Net {
+			sample.NetRxBytes += net.RxBytes
+			sample.NetTxBytes += net.TxBytes
+		}
+	}
+
+	return sample, nil
+}
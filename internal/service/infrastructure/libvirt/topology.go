@@ -0,0 +1,115 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/cpuset"
+)
+
+// hostTopology is the set of CPU and NUMA node ids present on a host.
+type hostTopology struct {
+	cpus  map[int]bool
+	nodes map[int]bool
+}
+
+// loadHostTopology collects host CPU and NUMA node ids, falling back to a single node
+// spanning every online CPU when libvirt reports no NUMA cells.
+func (m *Manager) loadHostTopology(ctx context.Context, hypervisor dependencies.HypervisorContext) (hostTopology, error) {
+	topology := hostTopology{cpus: make(map[int]bool), nodes: make(map[int]bool)}
+
+	numaNodes, err := m.GetNUMATopology(ctx, hypervisor)
+	if err != nil {
+		return topology, err
+	}
+
+	for _, node := range numaNodes {
+		topology.nodes[node.ID] = true
+		for _, cpu := range node.CPUs {
+			topology.cpus[cpu.ID] = true
+		}
+	}
+
+	if len(topology.cpus) == 0 {
+		nodeInfo, err := hypervisor.Conn.GetNodeInfo()
+		if err != nil {
+			return topology, fmt.Errorf("could not get node info: %w", err)
+		}
+		for id := 0; id < int(nodeInfo.Cpus); id++ {
+			topology.cpus[id] = true
+		}
+		topology.nodes[0] = true
+	}
+
+	return topology, nil
+}
+
+// validateTuningTopology checks that every cpuset and nodeset of the tuning refers to
+// CPUs and NUMA nodes that exist on the target host.
+func (m *Manager) validateTuningTopology(ctx context.Context, hypervisor dependencies.HypervisorContext, tuning *parameters.VMTuning) error {
+	if tuning == nil {
+		return nil
+	}
+	if len(tuning.VCPUPins) == 0 && tuning.EmulatorCPUSet == "" && len(tuning.IOThreadPins) == 0 && tuning.NUMAMemory == nil {
+		return nil
+	}
+
+	topology, err := m.loadHostTopology(ctx, hypervisor)
+	if err != nil {
+		return fmt.Errorf("could not load host topology: %w", err)
+	}
+
+	for i, pin := range tuning.VCPUPins {
+		if err := checkSet(fmt.Sprintf("vcpu_pins[%d]", i), pin, "CPU", topology.cpus, hypervisor.Host); err != nil {
+			return err
+		}
+	}
+
+	if tuning.EmulatorCPUSet != "" {
+		if err := checkSet("emulator_cpuset", tuning.EmulatorCPUSet, "CPU", topology.cpus, hypervisor.Host); err != nil {
+			return err
+		}
+	}
+
+	for i, pin := range tuning.IOThreadPins {
+		if err := checkSet(fmt.Sprintf("iothread_pins[%d]", i), pin, "CPU", topology.cpus, hypervisor.Host); err != nil {
+			return err
+		}
+	}
+
+	if tuning.NUMAMemory != nil {
+		if err := checkSet("numa_memory.nodeset", tuning.NUMAMemory.Nodeset, "NUMA node", topology.nodes, hypervisor.Host); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkSet parses a cpuset expression and reports the first id missing from available.
+func checkSet(field, expr, kind string, available map[int]bool, host string) error {
+	ids, err := cpuset.Parse(expr)
+	if err != nil {
+		return fmt.Errorf("%s: %w", field, err)
+	}
+
+	for _, id := range ids {
+		if !available[id] {
+			return fmt.Errorf("%s refers to host %s %d which does not exist on host %s (available: %s)",
+				field, kind, id, host, cpuset.Format(sortedIDs(available)))
+		}
+	}
+	return nil
+}
+
+func sortedIDs(set map[int]bool) []int {
+	ids := make([]int, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
@@ -8,16 +8,46 @@ type VCPUPin struct {
 	CPUSet string
 }
 
+// IOThreadPin represents an iothreadpin entry for the domain XML.
+type IOThreadPin struct {
+	IOThread int
+	CPUSet   string
+}
+
 // NUMAMemory contains NUMA memory tuning configuration.
 type NUMAMemory struct {
 	Nodeset string
 	Mode    string
 }
 
+// Hugepages contains hugepage memory backing configuration.
+type Hugepages struct {
+	PageSizeKiB uint64
+	Nodeset     string
+}
+
+// HostDevice is a host PCI function passed through to the domain, with hex-formatted address parts.
+type HostDevice struct {
+	Domain   string
+	Bus      string
+	Slot     string
+	Function string
+}
+
+// Graphics contains the graphical console of the domain.
+type Graphics struct {
+	Type     string
+	Listen   string
+	Port     int
+	Password string
+}
+
 // HostBindMount contains list of mount points from host on virtual machines
 type HostBindMount struct {
 	SourceDir string
-	TargetDir string
+	Driver    string
+	ReadOnly  bool
+	Tag       string
 }
 
 type LibvirtTemplateVars struct {
@@ -26,10 +56,48 @@ type LibvirtTemplateVars struct {
 	MemoryKiB              int64
 	VCPUCount              int
 	BridgeNetworkInterface string
+	MACAddress             string
 	DiskPath               string
 	CloudInitISOPath       string
 	VCPUPins               []VCPUPin
 	HostBindMounts         []HostBindMount
+	VirtiofsdPath          string
+	SharedMemory           bool
 	EmulatorCPUSet         string
+	IOThreads              int
+	IOThreadPins           []IOThreadPin
 	NUMAMemory             *NUMAMemory
+	Hugepages              *Hugepages
+	Firmware               string
+	SecureBoot             bool
+	NVRAMPath              string
+	MachineType            string
+	TPM                    bool
+	HostDevices            []HostDevice
+	ExtraDevices           []string
+	Graphics               Graphics
+	Netboot                bool
+	Lifecycle              Lifecycle
+	Metadata               string
+}
+
+// ContainerBindMount is a host directory bind-mounted into a container.
+type ContainerBindMount struct {
+	SourceDir string
+	TargetDir string
+	ReadOnly  bool
+}
+
+// ContainerTemplateVars are the values of the domain template of an LXC system container.
+type ContainerTemplateVars struct {
+	Name                   string
+	UUID                   uuid.UUID
+	MemoryKiB              int64
+	VCPUCount              int
+	RootfsPath             string
+	BridgeNetworkInterface string
+	MACAddress             string
+	HostBindMounts         []ContainerBindMount
+	Lifecycle              Lifecycle
+	Metadata               string
 }
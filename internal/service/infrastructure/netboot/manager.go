@@ -0,0 +1,77 @@
+package netboot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/executor/dnsmasq"
+	"github.com/terabiome/homonculus/pkg/executor/fileops"
+)
+
+// runDir holds the pid and lease files of the DHCP/TFTP helpers on the hypervisor.
+const runDir = "/run/homonculus"
+
+// Manager manages the dnsmasq DHCP/TFTP helpers serving network-booted VMs.
+type Manager struct {
+	logger *slog.Logger
+}
+
+// NewManager creates a new netboot manager.
+func NewManager(logger *slog.Logger) *Manager {
+	return &Manager{
+		logger: logger.With(slog.String("component", "netboot")),
+	}
+}
+
+func pidFile(bridge string) string {
+	return path.Join(runDir, "dnsmasq-"+bridge+".pid")
+}
+
+// EnsureServer starts the helper on bridge unless one is already running there.
+// A running helper is reused as is, even if its settings differ from server.
+func (m *Manager) EnsureServer(ctx context.Context, hypervisor dependencies.HypervisorContext, bridge string, server parameters.NetbootServer) error {
+	running, err := dnsmasq.IsRunning(ctx, hypervisor.Executor, pidFile(bridge))
+	if err != nil {
+		return err
+	}
+	if running {
+		m.logger.Debug("netboot server already running", slog.String("bridge", bridge))
+		return nil
+	}
+
+	if err := fileops.CreateDirectory(ctx, hypervisor.Executor, runDir); err != nil {
+		return err
+	}
+
+	err = dnsmasq.StartServer(ctx, hypervisor.Executor, dnsmasq.ServerOptions{
+		Interface: bridge,
+		DHCPRange: server.DHCPRange,
+		TFTPRoot:  server.TFTPRoot,
+		BootFile:  server.BootFile,
+		PIDFile:   pidFile(bridge),
+		LeaseFile: path.Join(runDir, "dnsmasq-"+bridge+".leases"),
+	})
+	if err != nil {
+		return fmt.Errorf("could not start netboot server on %s: %w", bridge, err)
+	}
+
+	m.logger.Info("started netboot server",
+		slog.String("bridge", bridge),
+		slog.String("dhcp_range", server.DHCPRange),
+		slog.String("boot_file", server.BootFile),
+	)
+	return nil
+}
+
+// StopServer stops the helper on bridge, if any.
+func (m *Manager) StopServer(ctx context.Context, hypervisor dependencies.HypervisorContext, bridge string) error {
+	if err := dnsmasq.StopServer(ctx, hypervisor.Executor, pidFile(bridge)); err != nil {
+		return fmt.Errorf("could not stop netboot server on %s: %w", bridge, err)
+	}
+	m.logger.Info("stopped netboot server", slog.String("bridge", bridge))
+	return nil
+}
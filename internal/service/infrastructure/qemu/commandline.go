@@ -0,0 +1,106 @@
+package qemu
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/terabiome/homonculus/internal/service/infrastructure/libvirt"
+	"github.com/terabiome/homonculus/pkg/constants"
+	"github.com/terabiome/homonculus/pkg/units"
+	"libvirt.org/go/libvirtxml"
+)
+
+// escapeOption escapes a value embedded in a comma-separated QEMU option.
+func escapeOption(value string) string {
+	return strings.ReplaceAll(value, ",", ",,")
+}
+
+// commandLine builds the arguments of the daemonized QEMU process of a definition.
+// CD-ROM drives get their target as drive id, so monitor commands can address them.
+func commandLine(domainXML libvirtxml.Domain) ([]string, error) {
+	if domainXML.VCPU == nil || domainXML.Memory == nil {
+		return nil, fmt.Errorf("definition of VM %s has no vcpu or memory", domainXML.Name)
+	}
+
+	machine := string(constants.MACHINE_TYPE_Q35)
+	if domainXML.OS != nil && domainXML.OS.Type != nil && domainXML.OS.Type.Machine != "" {
+		machine = domainXML.OS.Type.Machine
+	}
+
+	name := domainXML.Name
+	args := []string{
+		"-name", "guest=" + escapeOption(name),
+		"-uuid", domainXML.UUID,
+		"-machine", escapeOption(machine) + ",accel=kvm",
+		"-cpu", "host",
+		"-smp", strconv.FormatUint(uint64(domainXML.VCPU.Value), 10),
+		"-m", strconv.FormatUint(units.KiBToMiB(libvirt.DomainMemoryKiB(domainXML)), 10) + "M",
+		"-boot", "order=cd",
+		"-display", "none",
+		"-serial", "file:" + escapeOption(consoleLog(name)),
+		"-monitor", "unix:" + escapeOption(monitorSocket(name)) + ",server=on,wait=off",
+		"-pidfile", pidFile(name),
+		"-daemonize",
+	}
+
+	if domainXML.Devices == nil {
+		return args, nil
+	}
+
+	for _, disk := range domainXML.Devices.Disks {
+		var file string
+		if disk.Source != nil && disk.Source.File != nil {
+			file = disk.Source.File.File
+		}
+
+		switch disk.Device {
+		case "disk":
+			if file == "" {
+				continue
+			}
+			format := "qcow2"
+			if disk.Driver != nil && disk.Driver.Type != "" {
+				format = disk.Driver.Type
+			}
+			args = append(args, "-drive", fmt.Sprintf("file=%s,format=%s,if=virtio,cache=none,aio=native", escapeOption(file), escapeOption(format)))
+		case "cdrom":
+			if disk.Target == nil {
+				continue
+			}
+			drive := fmt.Sprintf("id=%s,media=cdrom,readonly=on", escapeOption(disk.Target.Dev))
+			if file != "" {
+				drive = fmt.Sprintf("file=%s,format=raw,%s", escapeOption(file), drive)
+			}
+			args = append(args, "-drive", drive)
+		}
+	}
+
+	for i, iface := range domainXML.Devices.Interfaces {
+		if iface.Source == nil || iface.Source.Bridge == nil {
+			continue
+		}
+		netdev := fmt.Sprintf("net%d", i)
+		args = append(args, "-netdev", fmt.Sprintf("bridge,id=%s,br=%s", netdev, escapeOption(iface.Source.Bridge.Bridge)))
+
+		device := "virtio-net-pci,netdev=" + netdev
+		if iface.MAC != nil {
+			device += ",mac=" + iface.MAC.Address
+		}
+		args = append(args, "-device", device)
+	}
+
+	for _, graphic := range domainXML.Devices.Graphics {
+		if graphic.VNC == nil || graphic.VNC.Port < vncBasePort {
+			continue
+		}
+		listen := graphic.VNC.Listen
+		if listen == "" {
+			listen = "127.0.0.1"
+		}
+		args = append(args, "-vnc", fmt.Sprintf("%s:%d", listen, graphic.VNC.Port-vncBasePort))
+		break
+	}
+
+	return args, nil
+}
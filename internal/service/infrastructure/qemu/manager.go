@@ -0,0 +1,595 @@
+// Package qemu runs VMs as QEMU processes launched through the executor, for hosts where running
+// libvirtd is undesirable. Definitions are stored on the host as libvirt domain XML, so cloning
+// and querying behave as with the libvirt driver.
+package qemu
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/errdefs"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/libvirt"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/constants"
+	"github.com/terabiome/homonculus/pkg/executor/fileops"
+	"github.com/terabiome/homonculus/pkg/executor/qemusystem"
+	"github.com/terabiome/homonculus/pkg/pathpolicy"
+	"libvirt.org/go/libvirtxml"
+)
+
+// stateDir holds one directory per VM on the hypervisor, with its definition, pid file and monitor socket.
+const stateDir = "/var/lib/homonculus/qemu"
+
+// vncBasePort is the TCP port of VNC display 0.
+const vncBasePort = 5900
+
+// Manager manages VMs as plain QEMU processes.
+type Manager struct {
+	paths  *pathpolicy.AllowList
+	logger *slog.Logger
+}
+
+// NewManager creates a new QEMU manager.
+func NewManager(allowedPaths *pathpolicy.AllowList, logger *slog.Logger) *Manager {
+	return &Manager{
+		paths:  allowedPaths,
+		logger: logger.With(slog.String("component", "qemu")),
+	}
+}
+
+func vmDir(name string) string {
+	return path.Join(stateDir, name)
+}
+
+func definitionFile(name string) string {
+	return path.Join(vmDir(name), "domain.xml")
+}
+
+func pidFile(name string) string {
+	return path.Join(vmDir(name), "qemu.pid")
+}
+
+func monitorSocket(name string) string {
+	return path.Join(vmDir(name), "monitor.sock")
+}
+
+func consoleLog(name string) string {
+	return path.Join(vmDir(name), "console.log")
+}
+
+func unsupported(feature string) error {
+	return fmt.Errorf("%w: %s", errdefs.ErrNotSupported, feature)
+}
+
+// checkSupported rejects the features only the libvirt driver provides.
+func checkSupported(params parameters.CreateVM) error {
+	switch {
+	case params.MachineType == string(constants.MACHINE_TYPE_CONTAINER):
+		return unsupported("containers")
+	case params.Tuning != nil:
+		return unsupported("tuning")
+	case len(params.HostDevices) > 0:
+		return unsupported("host_devices")
+	case len(params.USBDevices) > 0 || len(params.SerialDevices) > 0:
+		return unsupported("usb_devices and serial_devices")
+	case len(params.HostBindMounts) > 0:
+		return unsupported("host_bind_mounts")
+	case params.TPM:
+		return unsupported("tpm")
+	case params.Watchdog != nil:
+		return unsupported("watchdog")
+	case params.Netboot != nil:
+		return unsupported("netboot")
+	case params.Firmware == string(constants.FIRMWARE_UEFI) || params.SecureBoot || params.NVRAMPath != "":
+		return unsupported("uefi firmware")
+	case params.OnPoweroff != "" || params.OnReboot != "" || params.OnCrash != "":
+		return unsupported("lifecycle actions")
+	}
+
+	if graphics := params.Graphics; graphics != nil && graphics.Type != "" && graphics.Type != "none" {
+		if graphics.Type != "vnc" || graphics.Port < vncBasePort || graphics.Password != "" {
+			return unsupported("graphics other than vnc on a fixed port without password")
+		}
+	}
+	return nil
+}
+
+// readDefinition reads the stored definition of a VM.
+func (m *Manager) readDefinition(ctx context.Context, hypervisor dependencies.HypervisorContext, name string) (libvirtxml.Domain, error) {
+	exists, _, err := fileops.FileStatus(ctx, hypervisor.Executor, definitionFile(name))
+	if err != nil {
+		return libvirtxml.Domain{}, fmt.Errorf("could not look up VM by name: %w", err)
+	}
+	if !exists {
+		return libvirtxml.Domain{}, fmt.Errorf("could not look up VM by name: %w: %s", errdefs.ErrVMNotFound, name)
+	}
+
+	data, err := fileops.ReadFile(ctx, hypervisor.Executor, definitionFile(name))
+	if err != nil {
+		return libvirtxml.Domain{}, err
+	}
+	domainXML := libvirtxml.Domain{}
+	if err := domainXML.Unmarshal(string(data)); err != nil {
+		return libvirtxml.Domain{}, fmt.Errorf("could not parse domain XML: %w", err)
+	}
+	return domainXML, nil
+}
+
+// writeDefinition stores the definition of a VM, replacing an earlier one.
+func (m *Manager) writeDefinition(ctx context.Context, hypervisor dependencies.HypervisorContext, domainXML libvirtxml.Domain) error {
+	data, err := domainXML.Marshal()
+	if err != nil {
+		return fmt.Errorf("could not serialize Libvirt XML to string: %w", err)
+	}
+	if err := fileops.CreateDirectory(ctx, hypervisor.Executor, vmDir(domainXML.Name)); err != nil {
+		return err
+	}
+	return fileops.WriteFile(ctx, hypervisor.Executor, definitionFile(domainXML.Name), []byte(data))
+}
+
+// define stores the definition of a new VM.
+func (m *Manager) define(ctx context.Context, hypervisor dependencies.HypervisorContext, domainXML libvirtxml.Domain) error {
+	exists, err := m.CheckVirtualMachineExistence(hypervisor, domainXML.Name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("could not define VM: %w: %s", errdefs.ErrVMExists, domainXML.Name)
+	}
+	return m.writeDefinition(ctx, hypervisor, domainXML)
+}
+
+func (m *Manager) isRunning(ctx context.Context, hypervisor dependencies.HypervisorContext, name string) (bool, error) {
+	return qemusystem.IsRunning(ctx, hypervisor.Executor, pidFile(name))
+}
+
+// CreateVirtualMachine defines a virtual machine without starting it.
+func (m *Manager) CreateVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.CreateVM, virtualMachineUUID uuid.UUID) error {
+	domainXML, err := buildDomain(params, virtualMachineUUID)
+	if err != nil {
+		return err
+	}
+
+	if err := m.define(ctx, hypervisor, domainXML); err != nil {
+		return err
+	}
+	m.logger.Info("defined VM", slog.String("vm", params.Name))
+
+	return nil
+}
+
+// RenderVirtualMachineXML builds the definition a VM spec would be defined with, without
+// defining it. Interfaces keep no MAC address unless the spec sets one.
+func (m *Manager) RenderVirtualMachineXML(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.CreateVM, virtualMachineUUID uuid.UUID) (libvirtxml.Domain, error) {
+	domainXML, err := buildDomain(params, virtualMachineUUID)
+	if err != nil {
+		return libvirtxml.Domain{}, err
+	}
+	if params.MACAddress == "" {
+		for i := range domainXML.Devices.Interfaces {
+			domainXML.Devices.Interfaces[i].MAC = nil
+		}
+	}
+	return domainXML, nil
+}
+
+// buildDomain builds the definition of a VM. Interfaces without a MAC address get a random one.
+func buildDomain(params parameters.CreateVM, virtualMachineUUID uuid.UUID) (libvirtxml.Domain, error) {
+	if err := checkSupported(params); err != nil {
+		return libvirtxml.Domain{}, err
+	}
+
+	machine := params.MachineType
+	switch constants.MachineType(machine) {
+	case "":
+		machine = string(constants.MACHINE_TYPE_Q35)
+	case constants.MACHINE_TYPE_Q35, constants.MACHINE_TYPE_PC:
+	default:
+		return libvirtxml.Domain{}, fmt.Errorf("invalid machine_type '%s': must be '%s' or '%s'", machine, constants.MACHINE_TYPE_Q35, constants.MACHINE_TYPE_PC)
+	}
+
+	metadata, err := libvirt.NewDomainMetadata(params).Render()
+	if err != nil {
+		return libvirtxml.Domain{}, err
+	}
+
+	devices := &libvirtxml.DomainDeviceList{}
+	if params.DiskPath != "" {
+		devices.Disks = append(devices.Disks, libvirtxml.DomainDisk{
+			Device: "disk",
+			Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "qcow2"},
+			Source: &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: params.DiskPath}},
+			Target: &libvirtxml.DomainDiskTarget{Dev: "vdb", Bus: "virtio"},
+		})
+	}
+	if params.CloudInitISOPath != "" {
+		devices.Disks = append(devices.Disks, cdromDisk(libvirt.CloudInitTarget, params.CloudInitISOPath))
+	}
+	for i, cdrom := range params.CDROMs {
+		devices.Disks = append(devices.Disks, cdromDisk(libvirt.CDROMTarget(i), cdrom.Path))
+	}
+
+	if params.BridgeNetworkInterface != "" {
+		mac := params.MACAddress
+		if mac == "" {
+			if mac, err = libvirt.RandomMAC(); err != nil {
+				return libvirtxml.Domain{}, err
+			}
+		}
+		devices.Interfaces = append(devices.Interfaces, libvirtxml.DomainInterface{
+			MAC:    &libvirtxml.DomainInterfaceMAC{Address: mac},
+			Source: &libvirtxml.DomainInterfaceSource{Bridge: &libvirtxml.DomainInterfaceSourceBridge{Bridge: params.BridgeNetworkInterface}},
+			Model:  &libvirtxml.DomainInterfaceModel{Type: "virtio"},
+		})
+	}
+
+	if params.Graphics != nil && params.Graphics.Type == "vnc" {
+		devices.Graphics = append(devices.Graphics, libvirtxml.DomainGraphic{
+			VNC: &libvirtxml.DomainGraphicVNC{Port: params.Graphics.Port, Listen: params.Graphics.Listen},
+		})
+	}
+
+	domainXML := libvirtxml.Domain{
+		Type:     "kvm",
+		Name:     params.Name,
+		UUID:     virtualMachineUUID.String(),
+		Metadata: &libvirtxml.DomainMetadata{XML: metadata},
+		VCPU:     &libvirtxml.DomainVCPU{Value: uint(params.VCPUCount)},
+		OS:       &libvirtxml.DomainOS{Type: &libvirtxml.DomainOSType{Arch: "x86_64", Machine: machine, Type: "hvm"}},
+		Devices:  devices,
+	}
+	libvirt.SetDomainMemory(&domainXML, params.MemoryMB)
+
+	return domainXML, nil
+}
+
+// cdromDisk builds a CD-ROM drive holding path, or an empty drive.
+func cdromDisk(target, path string) libvirtxml.DomainDisk {
+	disk := libvirtxml.DomainDisk{
+		Device:   "cdrom",
+		Driver:   &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "raw"},
+		Target:   &libvirtxml.DomainDiskTarget{Dev: target, Bus: "sata"},
+		ReadOnly: &libvirtxml.DomainDiskReadOnly{},
+	}
+	if path != "" {
+		disk.Source = &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: path}}
+	}
+	return disk
+}
+
+// CloneVirtualMachine clones a VM from a base definition without starting it. The clone gets
+// new interface MACs and no fixed VNC port, which would clash with the base VM.
+func (m *Manager) CloneVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, baseDomainXML libvirtxml.Domain, targetInfo parameters.TargetVMSpec, virtualMachineUUID uuid.UUID, cloudInitISOPath string) error {
+	domainXML, err := libvirt.CopyDomainXML(baseDomainXML)
+	if err != nil {
+		return err
+	}
+	domainXML.Name = targetInfo.Name
+	domainXML.UUID = virtualMachineUUID.String()
+	domainXML.VCPU = &libvirtxml.DomainVCPU{Value: uint(targetInfo.VCPUCount)}
+	libvirt.SetDomainMemory(&domainXML, targetInfo.MemoryMB)
+
+	if domainXML.Devices != nil {
+		for i, disk := range domainXML.Devices.Disks {
+			if disk.Device == "disk" && disk.Source != nil && disk.Source.File != nil {
+				domainXML.Devices.Disks[i].Source.File.File = targetInfo.DiskPath
+				break
+			}
+		}
+		for i := range domainXML.Devices.Interfaces {
+			mac, err := libvirt.RandomMAC()
+			if err != nil {
+				return err
+			}
+			domainXML.Devices.Interfaces[i].MAC = &libvirtxml.DomainInterfaceMAC{Address: mac}
+		}
+		domainXML.Devices.Graphics = nil
+	}
+	libvirt.ReplaceCloudInitISO(&domainXML, cloudInitISOPath)
+
+	if err := m.define(ctx, hypervisor, domainXML); err != nil {
+		return err
+	}
+	m.logger.Info("defined cloned VM", slog.String("vm", targetInfo.Name))
+
+	return nil
+}
+
+// UndefineVirtualMachine removes the definition of a virtual machine, killing it first if needed.
+// Disks are left in place; it is the rollback counterpart of CreateVirtualMachine.
+func (m *Manager) UndefineVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, name string) error {
+	if _, err := m.readDefinition(ctx, hypervisor, name); err != nil {
+		return err
+	}
+	if err := qemusystem.Kill(ctx, hypervisor.Executor, pidFile(name)); err != nil {
+		return fmt.Errorf("could not destroy VM: %w", err)
+	}
+	if err := fileops.RemoveDirectory(ctx, hypervisor.Executor, vmDir(name)); err != nil {
+		return fmt.Errorf("could not undefine VM: %w", err)
+	}
+	m.logger.Info("undefined VM", slog.String("vm", name))
+
+	return nil
+}
+
+// DeleteVirtualMachine kills and removes a virtual machine along with the disks that references
+// lists for no other VM. On success the VM is removed from references.
+func (m *Manager) DeleteVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.DeleteVM, references parameters.DiskReferences) (string, error) {
+	domainXML, err := m.readDefinition(ctx, hypervisor, params.Name)
+	if err != nil {
+		return "", err
+	}
+
+	if err := qemusystem.Kill(ctx, hypervisor.Executor, pidFile(params.Name)); err != nil {
+		return "", fmt.Errorf("could not destroy VM: %w", err)
+	}
+
+	for _, file := range diskFiles(domainXML) {
+		if params.KeepDisks {
+			m.logger.Info("keeping disk", slog.String("vm", params.Name), slog.String("path", file))
+			continue
+		}
+		// Disks shared with other VMs must survive this VM.
+		if !m.removableDisk(params.Name, file, references) {
+			continue
+		}
+		if err := fileops.RemoveFile(ctx, hypervisor.Executor, file); err != nil {
+			m.logger.Warn("failed to delete disk",
+				slog.String("vm", params.Name),
+				slog.String("path", file),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+
+	if err := fileops.RemoveDirectory(ctx, hypervisor.Executor, vmDir(params.Name)); err != nil {
+		return "", fmt.Errorf("could not undefine VM: %w", err)
+	}
+	m.logger.Info("deleted VM", slog.String("vm", params.Name))
+	forgetDiskUser(references, params.Name)
+
+	return domainXML.UUID, nil
+}
+
+// DiskReferences returns the disk files of the defined VMs.
+func (m *Manager) DiskReferences(ctx context.Context, hypervisor dependencies.HypervisorContext) (parameters.DiskReferences, error) {
+	definitions, err := m.definitions(ctx, hypervisor)
+	if err != nil {
+		return nil, fmt.Errorf("could not determine disks used by other VMs: %w", err)
+	}
+	references := make(parameters.DiskReferences)
+	for _, definition := range definitions {
+		for _, file := range diskFiles(definition) {
+			references[file] = append(references[file], definition.Name)
+		}
+	}
+	return references, nil
+}
+
+// otherDiskUser returns a VM other than vmName that references lists for file, or "".
+func otherDiskUser(references parameters.DiskReferences, vmName, file string) string {
+	for _, user := range references[file] {
+		if user != vmName {
+			return user
+		}
+	}
+	return ""
+}
+
+// forgetDiskUser removes a deleted VM from references.
+func forgetDiskUser(references parameters.DiskReferences, vmName string) {
+	for file, users := range references {
+		users = slices.DeleteFunc(users, func(user string) bool { return user == vmName })
+		if len(users) == 0 {
+			delete(references, file)
+			continue
+		}
+		references[file] = users
+	}
+}
+
+// removableDisk reports whether a disk file of a VM may be deleted: no other VM uses it and it
+// lies within the allowed paths. Kept disks are logged.
+func (m *Manager) removableDisk(vmName, file string, references parameters.DiskReferences) bool {
+	if user := otherDiskUser(references, vmName, file); user != "" {
+		m.logger.Warn("keeping disk used by another VM",
+			slog.String("vm", vmName),
+			slog.String("path", file),
+			slog.String("used_by", user),
+		)
+		return false
+	}
+	if err := m.paths.Check(file); err != nil {
+		m.logger.Warn("keeping disk outside allowed paths", slog.String("vm", vmName), slog.String("path", file))
+		return false
+	}
+	return true
+}
+
+// RemoveDisk deletes the disk, or container root filesystem, of a VM that is not defined, such
+// as one whose creation was interrupted. Like DeleteVirtualMachine, it keeps files other VMs use
+// and files outside the allowed paths.
+func (m *Manager) RemoveDisk(ctx context.Context, hypervisor dependencies.HypervisorContext, vmName, file string, container bool) error {
+	file = path.Clean(file)
+	references, err := m.DiskReferences(ctx, hypervisor)
+	if err != nil {
+		return err
+	}
+	if !m.removableDisk(vmName, file, references) {
+		return nil
+	}
+	if container {
+		return fileops.RemoveDirectory(ctx, hypervisor.Executor, file)
+	}
+	return fileops.RemoveFile(ctx, hypervisor.Executor, file)
+}
+
+// diskFiles returns the files backing the disks and CD-ROM media of a definition.
+func diskFiles(domainXML libvirtxml.Domain) []string {
+	if domainXML.Devices == nil {
+		return nil
+	}
+	var files []string
+	for _, disk := range domainXML.Devices.Disks {
+		if disk.Source != nil && disk.Source.File != nil && disk.Source.File.File != "" {
+			files = append(files, path.Clean(disk.Source.File.File))
+		}
+	}
+	return files
+}
+
+// StartVirtualMachine launches the QEMU process of a virtual machine.
+func (m *Manager) StartVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.StartVM) error {
+	domainXML, err := m.readDefinition(ctx, hypervisor, params.Name)
+	if err != nil {
+		return err
+	}
+
+	running, err := m.isRunning(ctx, hypervisor, params.Name)
+	if err != nil {
+		return err
+	}
+	if running {
+		return fmt.Errorf("could not start VM: %s is already running", params.Name)
+	}
+
+	binary, err := fileops.FindExecutable(ctx, hypervisor.Executor, qemusystem.Binaries...)
+	if err != nil {
+		return fmt.Errorf("could not find qemu: %w", err)
+	}
+	args, err := commandLine(domainXML)
+	if err != nil {
+		return err
+	}
+	if err := qemusystem.Start(ctx, hypervisor.Executor, binary, args); err != nil {
+		return fmt.Errorf("could not start VM: %w", err)
+	}
+	m.logger.Info("started VM", slog.String("vm", params.Name))
+
+	return nil
+}
+
+// StopVirtualMachine requests a graceful ACPI shutdown of a virtual machine through its monitor.
+func (m *Manager) StopVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.StopVM) error {
+	if _, err := m.readDefinition(ctx, hypervisor, params.Name); err != nil {
+		return err
+	}
+
+	running, err := m.isRunning(ctx, hypervisor, params.Name)
+	if err != nil {
+		return err
+	}
+	if !running {
+		m.logger.Debug("VM already shut off", slog.String("vm", params.Name))
+		return nil
+	}
+
+	if _, err := qemusystem.Monitor(ctx, hypervisor.Executor, monitorSocket(params.Name), "system_powerdown"); err != nil {
+		return fmt.Errorf("could not shut down VM: %w", err)
+	}
+	m.logger.Info("requested VM shutdown", slog.String("vm", params.Name))
+
+	return nil
+}
+
+// CheckVirtualMachineExistence checks if a VM exists.
+func (m *Manager) CheckVirtualMachineExistence(hypervisor dependencies.HypervisorContext, name string) (bool, error) {
+	exists, _, err := fileops.FileStatus(context.Background(), hypervisor.Executor, definitionFile(name))
+	if err != nil {
+		return false, fmt.Errorf("error checking if VM exists: %w", err)
+	}
+	return exists, nil
+}
+
+// GetVirtualMachineXML reads the stored definition of a virtual machine.
+func (m *Manager) GetVirtualMachineXML(hypervisor dependencies.HypervisorContext, name string) (libvirtxml.Domain, error) {
+	return m.readDefinition(context.Background(), hypervisor, name)
+}
+
+// CloudInitStatus reports an unknown status for VMs with a cloud-init ISO: plain QEMU
+// processes have no guest agent channel to read it through.
+func (m *Manager) CloudInitStatus(hypervisor dependencies.HypervisorContext, name string) (string, error) {
+	domainXML, err := m.readDefinition(context.Background(), hypervisor, name)
+	if err != nil {
+		return libvirt.CloudInitUnknown, err
+	}
+	if !libvirt.HasCloudInitISO(domainXML) {
+		return "", nil
+	}
+	return libvirt.CloudInitUnknown, nil
+}
+
+// GuestAgentReady is not supported by the QEMU driver: plain QEMU processes have no guest agent channel.
+func (m *Manager) GuestAgentReady(hypervisor dependencies.HypervisorContext, name string) (bool, error) {
+	return false, unsupported("guest agent")
+}
+
+// GuestExec is not supported by the QEMU driver: plain QEMU processes have no guest agent channel.
+func (m *Manager) GuestExec(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.GuestExec) (parameters.GuestExecResult, error) {
+	return parameters.GuestExecResult{}, unsupported("guest agent commands")
+}
+
+// AttachDevices is not supported by the QEMU driver.
+func (m *Manager) AttachDevices(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.AttachDevices) ([]int, error) {
+	return nil, unsupported("device hotplug")
+}
+
+// DetachDevices is not supported by the QEMU driver.
+func (m *Manager) DetachDevices(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.DetachDevices) error {
+	return unsupported("device hotplug")
+}
+
+// CreateSnapshot is not supported by the QEMU driver.
+func (m *Manager) CreateSnapshot(ctx context.Context, hypervisor dependencies.HypervisorContext, vmName, snapshotName, description string) error {
+	return unsupported("snapshots")
+}
+
+// ListSnapshots is not supported by the QEMU driver.
+func (m *Manager) ListSnapshots(hypervisor dependencies.HypervisorContext, vmName string) ([]string, error) {
+	return nil, unsupported("snapshots")
+}
+
+// DeleteSnapshot is not supported by the QEMU driver.
+func (m *Manager) DeleteSnapshot(hypervisor dependencies.HypervisorContext, vmName, snapshotName string) error {
+	return unsupported("snapshots")
+}
+
+// RevertSnapshot is not supported by the QEMU driver.
+func (m *Manager) RevertSnapshot(hypervisor dependencies.HypervisorContext, vmName, snapshotName string) error {
+	return unsupported("snapshots")
+}
+
+// MigrateVirtualMachine is not supported by the QEMU driver.
+func (m *Manager) MigrateVirtualMachine(ctx context.Context, source, destination dependencies.HypervisorContext, name string) error {
+	return unsupported("migration")
+}
+
+// OpenSerialConsole is not supported by the QEMU driver, which logs the serial console to a file.
+func (m *Manager) OpenSerialConsole(ctx context.Context, hypervisor dependencies.HypervisorContext, name string, takeOver bool) (io.ReadWriteCloser, error) {
+	return nil, unsupported("interactive serial console")
+}
+
+// SetGraphicsPassword is not supported by the QEMU driver, whose VNC consoles take no password.
+func (m *Manager) SetGraphicsPassword(ctx context.Context, hypervisor dependencies.HypervisorContext, name, password string, validTo time.Time) (parameters.GraphicsInfo, error) {
+	return parameters.GraphicsInfo{}, unsupported("console passwords")
+}
+
+// SetVirtualMachineMetadata records labels and ownership in the stored definition of a VM.
+func (m *Manager) SetVirtualMachineMetadata(ctx context.Context, hypervisor dependencies.HypervisorContext, name string, labels map[string]string, ownership parameters.Ownership) error {
+	domainXML, err := m.readDefinition(ctx, hypervisor, name)
+	if err != nil {
+		return err
+	}
+	metadata := libvirt.NewDomainMetadata(parameters.CreateVM{Labels: labels, Ownership: ownership})
+	if err := libvirt.ReplaceDomainMetadata(&domainXML, metadata); err != nil {
+		return err
+	}
+	return m.writeDefinition(ctx, hypervisor, domainXML)
+}
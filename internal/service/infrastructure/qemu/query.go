@@ -0,0 +1,322 @@
+package qemu
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/errdefs"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/libvirt"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/executor"
+	"github.com/terabiome/homonculus/pkg/executor/fileops"
+	"github.com/terabiome/homonculus/pkg/executor/qemusystem"
+	"github.com/terabiome/homonculus/pkg/units"
+	"libvirt.org/go/libvirtxml"
+)
+
+// definitions reads the definitions of every VM on the hypervisor. Unreadable ones are logged and skipped.
+func (m *Manager) definitions(ctx context.Context, hypervisor dependencies.HypervisorContext) ([]libvirtxml.Domain, error) {
+	names, err := fileops.ListDirectories(ctx, hypervisor.Executor, stateDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not list VMs: %w", err)
+	}
+
+	var domains []libvirtxml.Domain
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		domainXML, err := m.readDefinition(ctx, hypervisor, name)
+		if err != nil {
+			m.logger.Warn("could not read VM definition", slog.String("vm", name), slog.String("error", err.Error()))
+			continue
+		}
+		domains = append(domains, domainXML)
+	}
+	return domains, nil
+}
+
+// neighbors returns the IPv4 addresses the hypervisor has seen by MAC, or nothing if they cannot be read.
+func (m *Manager) neighbors(ctx context.Context, hypervisor dependencies.HypervisorContext) map[string]string {
+	neighbors, err := qemusystem.Neighbors(ctx, hypervisor.Executor)
+	if err != nil {
+		m.logger.Warn("could not read neighbor table", slog.String("error", err.Error()))
+	}
+	return neighbors
+}
+
+// domainInfo reports the details of a VM. The IP address is looked up in the neighbor table
+// of the hypervisor, which only knows guests that sent traffic over a bridge recently.
+func (m *Manager) domainInfo(domainXML libvirtxml.Domain, running bool, neighbors map[string]string) parameters.VMInfo {
+	vmInfo := parameters.VMInfo{
+		Name:       domainXML.Name,
+		UUID:       domainXML.UUID,
+		State:      "shutoff",
+		Persistent: true,
+	}
+	if domainXML.VCPU != nil {
+		vmInfo.VCPUCount = domainXML.VCPU.Value
+	}
+	memoryKiB := libvirt.DomainCurrentMemoryKiB(domainXML)
+	vmInfo.MemoryMB = uint(units.KiBToMiB(memoryKiB))
+	vmInfo.MemoryBytes = units.KiBToBytes(memoryKiB)
+
+	metadata, err := libvirt.ParseDomainMetadata(domainXML)
+	if err != nil {
+		m.logger.Warn("could not parse homonculus metadata", slog.String("vm", domainXML.Name), slog.String("error", err.Error()))
+	}
+	vmInfo.Labels = metadata.LabelMap()
+	vmInfo.Ownership = metadata.Ownership()
+
+	if domainXML.Devices == nil {
+		return vmInfo
+	}
+	for _, disk := range domainXML.Devices.Disks {
+		if disk.Source == nil || disk.Source.File == nil {
+			continue
+		}
+		diskInfo := parameters.DiskInfo{Path: disk.Source.File.File, Device: disk.Device}
+		if disk.Driver != nil {
+			diskInfo.Type = disk.Driver.Type
+		}
+		vmInfo.Disks = append(vmInfo.Disks, diskInfo)
+	}
+	for _, graphic := range domainXML.Devices.Graphics {
+		if graphic.VNC != nil {
+			vmInfo.Graphics = append(vmInfo.Graphics, parameters.GraphicsInfo{Type: "vnc", Listen: graphic.VNC.Listen, Port: graphic.VNC.Port})
+		}
+	}
+
+	if running {
+		vmInfo.State = "running"
+		for _, iface := range domainXML.Devices.Interfaces {
+			if iface.MAC == nil {
+				continue
+			}
+			if address, ok := neighbors[strings.ToLower(iface.MAC.Address)]; ok {
+				vmInfo.IPAddress = address
+				break
+			}
+		}
+	}
+	return vmInfo
+}
+
+// GetVirtualMachineInfo retrieves detailed information about a virtual machine.
+func (m *Manager) GetVirtualMachineInfo(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.QueryVM) (parameters.VMInfo, error) {
+	domainXML, err := m.readDefinition(ctx, hypervisor, params.Name)
+	if err != nil {
+		return parameters.VMInfo{}, err
+	}
+
+	running, err := m.isRunning(ctx, hypervisor, params.Name)
+	if err != nil {
+		return parameters.VMInfo{}, fmt.Errorf("could not get VM state: %w", err)
+	}
+	var neighbors map[string]string
+	if running {
+		neighbors = m.neighbors(ctx, hypervisor)
+	}
+
+	return m.domainInfo(domainXML, running, neighbors), nil
+}
+
+// ListAllVirtualMachines retrieves information about all virtual machines.
+func (m *Manager) ListAllVirtualMachines(ctx context.Context, hypervisor dependencies.HypervisorContext) ([]parameters.VMInfo, error) {
+	domains, err := m.definitions(ctx, hypervisor)
+	if err != nil {
+		return nil, err
+	}
+	neighbors := m.neighbors(ctx, hypervisor)
+
+	var vmInfos []parameters.VMInfo
+	for _, domainXML := range domains {
+		running, err := m.isRunning(ctx, hypervisor, domainXML.Name)
+		if err != nil {
+			m.logger.Warn("could not get VM state", slog.String("vm", domainXML.Name), slog.String("error", err.Error()))
+			continue
+		}
+		vmInfos = append(vmInfos, m.domainInfo(domainXML, running, neighbors))
+	}
+
+	m.logger.Debug("listed all VMs", slog.Int("count", len(vmInfos)))
+
+	return vmInfos, nil
+}
+
+// GetHostCapacity reports total and available host resources along with the resources claimed by defined VMs.
+func (m *Manager) GetHostCapacity(ctx context.Context, hypervisor dependencies.HypervisorContext) (parameters.HostCapacity, error) {
+	capacity := parameters.HostCapacity{Host: hypervisor.Host}
+
+	result, err := executor.RunAndCapture(ctx, hypervisor.Executor, "nproc")
+	if err != nil {
+		return capacity, fmt.Errorf("could not count host CPUs: %w\nstderr: %s", err, result.Stderr)
+	}
+	cpus, err := strconv.ParseUint(strings.TrimSpace(result.Stdout), 10, 32)
+	if err != nil {
+		return capacity, fmt.Errorf("could not parse nproc output %q: %w", result.Stdout, err)
+	}
+	capacity.CPUs = uint(cpus)
+
+	meminfo, err := fileops.ReadFile(ctx, hypervisor.Executor, "/proc/meminfo")
+	if err != nil {
+		return capacity, err
+	}
+	for _, line := range strings.Split(string(meminfo), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			capacity.TotalMemoryKiB = value
+		case "MemAvailable:":
+			capacity.FreeMemoryKiB = value
+		}
+	}
+
+	capacity.LoadAverage, err = libvirt.ReadLoadAverage(ctx, hypervisor.Executor)
+	if err != nil {
+		m.logger.Warn("could not get load average", slog.String("host", hypervisor.Host), slog.String("error", err.Error()))
+	}
+
+	domains, err := m.definitions(ctx, hypervisor)
+	if err != nil {
+		return capacity, err
+	}
+	for _, domainXML := range domains {
+		capacity.DefinedVMs++
+		capacity.AllocatedMemoryKiB += libvirt.DomainMemoryKiB(domainXML)
+		if domainXML.VCPU != nil {
+			capacity.AllocatedVCPUs += domainXML.VCPU.Value
+		}
+		if running, err := m.isRunning(ctx, hypervisor, domainXML.Name); err == nil && running {
+			capacity.RunningVMs++
+		}
+	}
+
+	return capacity, nil
+}
+
+// GetConsoleAddress returns the host address of the VNC console of a running VM.
+func (m *Manager) GetConsoleAddress(ctx context.Context, hypervisor dependencies.HypervisorContext, name string) (string, error) {
+	domainXML, err := m.readDefinition(ctx, hypervisor, name)
+	if err != nil {
+		return "", err
+	}
+
+	running, err := m.isRunning(ctx, hypervisor, name)
+	if err != nil {
+		return "", fmt.Errorf("could not get VM state: %w", err)
+	}
+	if !running {
+		return "", fmt.Errorf("VM %s is not running", name)
+	}
+
+	if domainXML.Devices != nil {
+		for _, graphic := range domainXML.Devices.Graphics {
+			if graphic.VNC == nil || graphic.VNC.Port <= 0 {
+				continue
+			}
+			listen := graphic.VNC.Listen
+			if listen == "" || net.ParseIP(listen).IsUnspecified() {
+				listen = "127.0.0.1"
+			}
+			return net.JoinHostPort(listen, strconv.Itoa(graphic.VNC.Port)), nil
+		}
+	}
+	return "", fmt.Errorf("VM %s has no graphical console", name)
+}
+
+// ChangeMedia inserts an ISO into, or ejects the media of, a CD-ROM drive of a VM.
+// The change applies to the running guest through its monitor and persists in its definition.
+func (m *Manager) ChangeMedia(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.ChangeMedia) error {
+	domainXML, err := m.readDefinition(ctx, hypervisor, params.Name)
+	if err != nil {
+		return err
+	}
+
+	target := params.Device
+	if target == "" {
+		target = libvirt.CDROMTarget(0)
+	}
+	index := -1
+	if domainXML.Devices != nil {
+		for i, disk := range domainXML.Devices.Disks {
+			if disk.Device == "cdrom" && disk.Target != nil && disk.Target.Dev == target {
+				index = i
+				break
+			}
+		}
+	}
+	if index < 0 {
+		return fmt.Errorf("%w: VM %s has no CD-ROM drive %s", errdefs.ErrDeviceNotFound, params.Name, target)
+	}
+	domainXML.Devices.Disks[index] = cdromDisk(target, params.Path)
+
+	running, err := m.isRunning(ctx, hypervisor, params.Name)
+	if err != nil {
+		return fmt.Errorf("could not get VM state: %w", err)
+	}
+	if running {
+		command := "eject -f " + target
+		if params.Path != "" {
+			command = "change " + target + " " + params.Path
+		}
+		if _, err := qemusystem.Monitor(ctx, hypervisor.Executor, monitorSocket(params.Name), command); err != nil {
+			return fmt.Errorf("could not change media of CD-ROM drive %s: %w", target, err)
+		}
+	}
+
+	if err := m.writeDefinition(ctx, hypervisor, domainXML); err != nil {
+		return err
+	}
+
+	if params.Path == "" {
+		m.logger.Info("ejected media", slog.String("vm", params.Name), slog.String("device", target))
+	} else {
+		m.logger.Info("inserted media", slog.String("vm", params.Name), slog.String("device", target), slog.String("path", params.Path))
+	}
+	return nil
+}
+
+// GetVirtualMachineStats reads the CPU time and resident memory of the QEMU process of a VM.
+// Disk and network counters are not available without libvirt and stay zero.
+func (m *Manager) GetVirtualMachineStats(hypervisor dependencies.HypervisorContext, name string) (parameters.VMStatsSample, error) {
+	ctx := context.Background()
+	domainXML, err := m.readDefinition(ctx, hypervisor, name)
+	if err != nil {
+		return parameters.VMStatsSample{}, err
+	}
+
+	sample := parameters.VMStatsSample{Time: time.Now(), State: "shutoff"}
+	if domainXML.VCPU != nil {
+		sample.VCPUCount = domainXML.VCPU.Value
+	}
+	sample.MemoryKiB = libvirt.DomainCurrentMemoryKiB(domainXML)
+
+	running, err := m.isRunning(ctx, hypervisor, name)
+	if err != nil {
+		return sample, fmt.Errorf("could not get VM state: %w", err)
+	}
+	if !running {
+		return sample, nil
+	}
+	sample.State = "running"
+
+	sample.CPUTimeNs, sample.MemoryRSSKiB, err = qemusystem.ProcessStats(ctx, hypervisor.Executor, pidFile(name))
+	if err != nil {
+		return sample, err
+	}
+	return sample, nil
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/internal/store"
+)
+
+// bucketAddresses records the address every VM holds from an IP pool, keyed by VM name.
+const bucketAddresses = "ip_addresses"
+
+// IPPool is a named range of static IPv4 addresses VMs can request with IPFromPool.
+type IPPool struct {
+	Name string
+	Pool parameters.AddressPool
+}
+
+// addressRecord is an address allocated to a VM from an IP pool.
+type addressRecord struct {
+	VM      string `json:"vm"`
+	Pool    string `json:"pool"`
+	Address string `json:"address"` // CIDR notation
+}
+
+// allocateAddresses gives every VM requesting an IP pool the next free address of the pool as
+// its static network configuration. A VM that already holds an address of the pool keeps it.
+// Allocations are recorded before the VMs are created, so concurrent requests never draw the
+// same address; they are released when the VM is deleted.
+func (s *VMService) allocateAddresses(vms []parameters.CreateVM) error {
+	requested := false
+	for _, vm := range vms {
+		if vm.IPFromPool == "" {
+			continue
+		}
+		requested = true
+		if _, ok := s.ipPools[vm.IPFromPool]; !ok {
+			return fmt.Errorf("%w: VM %s requests unknown ip pool %s", ErrInvalidCluster, vm.Name, vm.IPFromPool)
+		}
+		if vm.Network != nil && vm.Network.IPv4Address != "" {
+			return fmt.Errorf("%w: VM %s sets both ip_from_pool and a static address", ErrInvalidCluster, vm.Name)
+		}
+	}
+	if !requested {
+		return nil
+	}
+
+	s.addressMu.Lock()
+	defer s.addressMu.Unlock()
+
+	_, used, err := s.claimedNetworkIdentities()
+	if err != nil {
+		return err
+	}
+
+	allocators := make(map[string]func() (netip.Prefix, error))
+	var allocated []string
+	for i := range vms {
+		vm := &vms[i]
+		if vm.IPFromPool == "" {
+			continue
+		}
+		pool := s.ipPools[vm.IPFromPool]
+
+		var record addressRecord
+		found, err := s.store.Get(bucketAddresses, vm.Name, &record)
+		if err != nil {
+			s.releaseAllocations(allocated)
+			return err
+		}
+		if !found || record.Pool != pool.Name {
+			next, ok := allocators[pool.Name]
+			if !ok {
+				if next, err = addressAllocator(pool.Pool, used); err != nil {
+					s.releaseAllocations(allocated)
+					return fmt.Errorf("ip pool %s: %w", pool.Name, err)
+				}
+				allocators[pool.Name] = next
+			}
+			address, err := next()
+			if err != nil {
+				s.releaseAllocations(allocated)
+				return fmt.Errorf("ip pool %s: %w", pool.Name, err)
+			}
+			used[address.Addr()] = true
+
+			record = addressRecord{VM: vm.Name, Pool: pool.Name, Address: address.String()}
+			if err := s.store.Put(bucketAddresses, vm.Name, record); err != nil {
+				s.releaseAllocations(allocated)
+				return fmt.Errorf("failed to record address of VM %s: %w", vm.Name, err)
+			}
+			allocated = append(allocated, vm.Name)
+			s.logger.Info("allocated address from ip pool",
+				slog.String("vm", vm.Name),
+				slog.String("pool", pool.Name),
+				slog.String("address", record.Address),
+			)
+		}
+
+		vm.Network = &parameters.NetworkConfig{
+			IPv4Address: record.Address,
+			IPv4Gateway: pool.Pool.Gateway,
+			Nameservers: pool.Pool.Nameservers,
+		}
+	}
+	return nil
+}
+
+// releaseAllocations releases the addresses allocated to VMs of a request that failed.
+func (s *VMService) releaseAllocations(names []string) {
+	for _, name := range names {
+		s.releaseAddress(name)
+	}
+}
+
+// releaseAddress returns the address of a deleted VM to its pool.
+func (s *VMService) releaseAddress(name string) {
+	var record addressRecord
+	found, err := s.store.Get(bucketAddresses, name, &record)
+	if err != nil || !found {
+		return
+	}
+	if err := s.store.Delete(bucketAddresses, name); err != nil {
+		s.logger.Warn("failed to release address", slog.String("vm", name), slog.String("error", err.Error()))
+		return
+	}
+	s.logger.Info("released address to ip pool",
+		slog.String("vm", name),
+		slog.String("pool", record.Pool),
+		slog.String("address", record.Address),
+	)
+}
+
+// ListIPPools returns the configured IP pools with the addresses allocated from them, in name order.
+func (s *VMService) ListIPPools() ([]parameters.IPPoolUsage, error) {
+	records, err := store.List[addressRecord](s.store, bucketAddresses)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load address allocations: %w", err)
+	}
+
+	allocations := make(map[string][]parameters.AddressAllocation)
+	for _, record := range records {
+		allocations[record.Pool] = append(allocations[record.Pool], parameters.AddressAllocation{
+			VM:      record.VM,
+			Address: record.Address,
+		})
+	}
+
+	names := make([]string, 0, len(s.ipPools))
+	for name := range s.ipPools {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	pools := make([]parameters.IPPoolUsage, len(names))
+	for i, name := range names {
+		pools[i] = parameters.IPPoolUsage{
+			Name:        name,
+			Pool:        s.ipPools[name].Pool,
+			Allocations: allocations[name],
+		}
+	}
+	return pools, nil
+}
@@ -0,0 +1,305 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/terabiome/homonculus/internal/opslot"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/internal/store"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MaxJobs bounds the finished jobs kept; the oldest are dropped first. The job log records
+// every job twice, when it starts and when it finishes.
+const MaxJobs = 1000
+
+// Operations run as jobs.
+const (
+	JobCreateCluster = "create_cluster"
+	JobCloneCluster  = "clone_cluster"
+	JobStartCluster  = "start_cluster"
+	JobStopCluster   = "stop_cluster"
+	JobMaintenance   = "maintenance"
+)
+
+// States of jobs and of the VMs of a job.
+const (
+	JobPending   = "pending" // VMs only
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// jobVMStates maps the events recording the outcome of a VM to the state of the VM in its job.
+var jobVMStates = map[string]string{
+	EventVMCreated:      JobSucceeded,
+	EventVMCreateFailed: JobFailed,
+	EventVMCloned:       JobSucceeded,
+	EventVMCloneFailed:  JobFailed,
+	EventVMStarted:      JobSucceeded,
+	EventVMStartFailed:  JobFailed,
+	EventVMStopped:      JobSucceeded,
+	EventVMStopFailed:   JobFailed,
+}
+
+type jobKey struct{}
+
+// WithJob returns a copy of ctx carrying the ID of the API request, or job, that operations
+// run for. Events recorded by the operations carry it along with the ID of the trace of ctx.
+func WithJob(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, jobKey{}, id)
+}
+
+// jobFromContext returns the job ID stored in ctx, or "" for background operations.
+func jobFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(jobKey{}).(string)
+	return id
+}
+
+// traceIDFromContext returns the ID of the trace of ctx, or "" if it is not traced.
+func traceIDFromContext(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.HasTraceID() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
+
+// ClusterVMNames returns the names of the VMs a create cluster request expands into, so a job
+// can report their progress before they are created. It fails like CreateCluster for VMs that
+// cannot be expanded.
+func (s *VMService) ClusterVMNames(cluster parameters.CreateCluster) ([]string, error) {
+	vms, err := expandVirtualMachines(cluster.VirtualMachines)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(vms))
+	for i, vm := range vms {
+		names[i] = vm.Name
+	}
+	return names, nil
+}
+
+// StartJob runs an operation on the VMs named vms in the background and returns the job
+// tracking it. The job takes the job ID of ctx, so the events the operation records carry it,
+// the namespace and caller of ctx and its operation slot, but not its cancellation: it runs
+// until run returns or CancelJobs is called. The progress of every VM follows the events
+// recording its outcome; it is kept in memory, and the job log records only its start and outcome.
+func (s *VMService) StartJob(ctx context.Context, operation string, vms []string, run func(ctx context.Context) error) (parameters.Job, error) {
+	id := jobFromContext(ctx)
+	if id == "" {
+		id = uuid.NewString()
+		ctx = WithJob(ctx, id)
+	}
+	job := parameters.Job{
+		ID:        id,
+		Operation: operation,
+		State:     JobRunning,
+		Actor:     callerFromContext(ctx),
+		Namespace: namespaceFromContext(ctx),
+		CreatedAt: time.Now().UTC(),
+	}
+	for _, name := range vms {
+		job.VMs = append(job.VMs, parameters.JobVM{Name: qualify(ctx, name), State: JobPending})
+	}
+
+	jobCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	s.jobsMu.Lock()
+	if _, running := s.runningJobs[id]; running {
+		s.jobsMu.Unlock()
+		cancel()
+		return parameters.Job{}, fmt.Errorf("job %s is already running", id)
+	}
+	if err := s.jobLog.Append(job); err != nil {
+		s.jobsMu.Unlock()
+		cancel()
+		return parameters.Job{}, fmt.Errorf("failed to record job: %w", err)
+	}
+	s.jobs[id] = job
+	s.runningJobs[id] = cancel
+	s.jobsWG.Add(1)
+	s.jobsMu.Unlock()
+
+	release := opslot.Take(ctx)
+	s.logger.Info("job started", slog.String("job", id), slog.String("operation", operation), slog.Int("vms", len(vms)))
+	go func() {
+		defer s.jobsWG.Done()
+		defer release()
+		defer cancel()
+		s.finishJob(id, run(jobCtx))
+	}()
+	return scopeJob(ctx, job), nil
+}
+
+// GetJob returns a job by ID. Scoped to a namespace, only jobs started in it are found.
+func (s *VMService) GetJob(ctx context.Context, id string) (parameters.Job, bool, error) {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	job, found := s.jobs[id]
+	if !found {
+		return parameters.Job{}, false, nil
+	}
+	if namespace := namespaceFromContext(ctx); namespace != "" && job.Namespace != namespace {
+		return parameters.Job{}, false, nil
+	}
+	return scopeJob(ctx, job), true, nil
+}
+
+// CancelJobs cancels every running job. Cancelled operations roll back their partial work.
+func (s *VMService) CancelJobs() {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	for _, cancel := range s.runningJobs {
+		cancel()
+	}
+}
+
+// RunningJobs returns the number of jobs currently running.
+func (s *VMService) RunningJobs() int {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	return len(s.runningJobs)
+}
+
+// WaitForJobs waits until every running job finished or ctx is done.
+func (s *VMService) WaitForJobs(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.jobsWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// recordJobProgress updates the state of the VM of an event in the running job that recorded
+// it. Events of synchronous requests, which run no job, are ignored.
+func (s *VMService) recordJobProgress(event parameters.Event) {
+	state, ok := jobVMStates[event.Type]
+	if !ok || event.JobID == "" {
+		return
+	}
+
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	if _, running := s.runningJobs[event.JobID]; !running {
+		return
+	}
+	job := s.jobs[event.JobID]
+	i := slices.IndexFunc(job.VMs, func(vm parameters.JobVM) bool { return vm.Name == event.VM })
+	if i < 0 {
+		job.VMs = append(job.VMs, parameters.JobVM{Name: event.VM})
+		i = len(job.VMs) - 1
+	}
+	job.VMs[i].State = state
+	job.VMs[i].Error = event.Error
+	s.jobs[event.JobID] = job
+}
+
+// finishJob records the outcome of a job whose operation returned err.
+func (s *VMService) finishJob(id string, err error) {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	delete(s.runningJobs, id)
+	job := s.jobs[id]
+	closeJob(&job, err)
+	if err := s.jobLog.Append(job); err != nil {
+		s.logger.Warn("failed to record job outcome", slog.String("job", id), slog.String("error", err.Error()))
+	}
+	s.recordFinishedJobLocked(job)
+
+	if err != nil {
+		s.logger.Error("job failed", slog.String("job", id), slog.String("error", err.Error()))
+		return
+	}
+	s.logger.Info("job succeeded", slog.String("job", id))
+}
+
+// restoreJobs loads the jobs of the job log and marks the jobs that were running when
+// homonculus stopped as failed.
+func (s *VMService) restoreJobs() {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	jobs, err := store.Records[parameters.Job](s.jobLog)
+	if err != nil {
+		s.logger.Warn("failed to load jobs", slog.String("error", err.Error()))
+		return
+	}
+
+	// A job is recorded when it starts and again when it finishes, so its last record wins.
+	for _, job := range jobs {
+		if job.State == JobRunning {
+			s.jobs[job.ID] = job
+			continue
+		}
+		s.recordFinishedJobLocked(job)
+	}
+	for _, job := range s.jobs {
+		if job.State != JobRunning {
+			continue
+		}
+		s.logger.Warn("job was interrupted", slog.String("job", job.ID), slog.String("operation", job.Operation))
+		closeJob(&job, fmt.Errorf("interrupted by a restart of homonculus"))
+		if err := s.jobLog.Append(job); err != nil {
+			s.logger.Warn("failed to record job outcome", slog.String("job", job.ID), slog.String("error", err.Error()))
+		}
+		s.recordFinishedJobLocked(job)
+	}
+}
+
+// recordFinishedJobLocked keeps a finished job in memory, dropping the oldest finished jobs
+// beyond MaxJobs. Callers hold jobsMu and record the job in the job log themselves.
+func (s *VMService) recordFinishedJobLocked(job parameters.Job) {
+	s.jobs[job.ID] = job
+	s.finishedJobs = append(slices.DeleteFunc(s.finishedJobs, func(id string) bool { return id == job.ID }), job.ID)
+	for len(s.finishedJobs) > MaxJobs {
+		delete(s.jobs, s.finishedJobs[0])
+		s.finishedJobs = s.finishedJobs[1:]
+	}
+}
+
+// closeJob sets the final state of a job whose operation returned err. VMs the operation
+// never reached fail along with it.
+func closeJob(job *parameters.Job, err error) {
+	job.FinishedAt = time.Now().UTC()
+	if err == nil {
+		job.State = JobSucceeded
+		return
+	}
+	job.State = JobFailed
+	job.Error = err.Error()
+	for i := range job.VMs {
+		if job.VMs[i].State == JobPending {
+			job.VMs[i].State = JobFailed
+		}
+	}
+}
+
+// scopeJob names the VMs of a job relative to the namespace of ctx and counts the VMs it completed.
+func scopeJob(ctx context.Context, job parameters.Job) parameters.Job {
+	job.VMs = slices.Clone(job.VMs)
+	job.Completed = 0
+	for i := range job.VMs {
+		job.VMs[i].Name = localName(ctx, job.VMs[i].Name)
+		if job.VMs[i].State != JobPending {
+			job.Completed++
+		}
+	}
+	return job
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/terabiome/homonculus/internal/service/parameters"
+)
+
+// PathLayout computes the disk and cloud-init ISO paths of VMs whose requests leave them out.
+// A path is the template, executed with the cluster and VM name, inside its directory.
+// An empty directory leaves the path to the request.
+type PathLayout struct {
+	DiskDir      string
+	DiskTemplate *template.Template
+	ISODir       string
+	ISOTemplate  *template.Template
+}
+
+// layoutVars are the fields path templates can use.
+type layoutVars struct {
+	ClusterName string
+	VMName      string
+}
+
+// NewPathLayout parses the disk and ISO path templates of a layout.
+func NewPathLayout(diskDir, diskTemplate, isoDir, isoTemplate string) (PathLayout, error) {
+	layout := PathLayout{DiskDir: diskDir, ISODir: isoDir}
+	var err error
+	if diskDir != "" {
+		if layout.DiskTemplate, err = template.New("disk").Option("missingkey=error").Parse(diskTemplate); err != nil {
+			return PathLayout{}, fmt.Errorf("disk path template: %w", err)
+		}
+	}
+	if isoDir != "" {
+		if layout.ISOTemplate, err = template.New("iso").Option("missingkey=error").Parse(isoTemplate); err != nil {
+			return PathLayout{}, fmt.Errorf("iso path template: %w", err)
+		}
+	}
+	return layout, nil
+}
+
+// applyPathLayout fills in the disk path of VMs that boot an image or ask for a disk size, and
+// the cloud-init ISO path of VMs with a disk, where the request left them empty. Containers
+// keep the root filesystem directory they name. The computed paths are validated like
+// requested ones, and must stay inside their directory.
+func (s *VMService) applyPathLayout(vms []parameters.CreateVM, clusterName string) error {
+	if s.layout.DiskDir == "" && s.layout.ISODir == "" {
+		return nil
+	}
+
+	for i := range vms {
+		vm := &vms[i]
+		if isContainer(*vm) {
+			continue
+		}
+		vars := layoutVars{ClusterName: clusterName, VMName: vm.Name}
+
+		if s.layout.DiskDir != "" && vm.DiskPath == "" && (vm.BaseImagePath != "" || vm.DiskSizeGB > 0) {
+			path, err := layoutPath(s.layout.DiskDir, s.layout.DiskTemplate, vars)
+			if err != nil {
+				return fmt.Errorf("%w: VM %s disk_path: %w", ErrInvalidCluster, vm.Name, err)
+			}
+			vm.DiskPath = path
+		}
+		if s.layout.ISODir != "" && vm.CloudInitISOPath == "" && vm.DiskPath != "" {
+			path, err := layoutPath(s.layout.ISODir, s.layout.ISOTemplate, vars)
+			if err != nil {
+				return fmt.Errorf("%w: VM %s cloud_init_iso_path: %w", ErrInvalidCluster, vm.Name, err)
+			}
+			vm.CloudInitISOPath = path
+		}
+		if err := sanitizeVirtualMachine(vm); err != nil {
+			return err
+		}
+	}
+	return checkDuplicates(vms)
+}
+
+// layoutPath executes a path template and places the result inside dir.
+func layoutPath(dir string, tmpl *template.Template, vars layoutVars) (string, error) {
+	var name strings.Builder
+	if err := tmpl.Execute(&name, vars); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name.String())
+	if rel, err := filepath.Rel(dir, path); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("path template yields %q outside %s", name.String(), dir)
+	}
+	return path, nil
+}
+
+// applyCloneLayout fills in the disk path of a clone target that leaves it empty, and places
+// its cloud-init ISO according to the ISO layout. Clones belong to no cluster.
+func (s *VMService) applyCloneLayout(target *parameters.TargetVMSpec) error {
+	vars := layoutVars{VMName: target.Name}
+	if s.layout.DiskDir != "" && target.DiskPath == "" {
+		path, err := layoutPath(s.layout.DiskDir, s.layout.DiskTemplate, vars)
+		if err != nil {
+			return fmt.Errorf("%w: VM %s disk_path: %w", ErrInvalidCluster, target.Name, err)
+		}
+		target.DiskPath = path
+	}
+	if s.layout.ISODir != "" {
+		path, err := layoutPath(s.layout.ISODir, s.layout.ISOTemplate, vars)
+		if err != nil {
+			return fmt.Errorf("%w: VM %s cloud_init_iso_path: %w", ErrInvalidCluster, target.Name, err)
+		}
+		target.CloudInitISOPath = path
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package service
+
+import "sync"
+
+// vmLocks serializes the changes of single VMs, so a conditional change sees the VM it checked.
+// A VM's lock is dropped once nobody holds or waits for it.
+type vmLocks struct {
+	mu    sync.Mutex
+	locks map[string]*vmLock
+}
+
+type vmLock struct {
+	mu      sync.Mutex
+	holders int
+}
+
+// lock takes the lock of the VM name and returns its release.
+func (l *vmLocks) lock(name string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*vmLock)
+	}
+	vm, ok := l.locks[name]
+	if !ok {
+		vm = &vmLock{}
+		l.locks[name] = vm
+	}
+	vm.holders++
+	l.mu.Unlock()
+
+	vm.mu.Lock()
+	return func() {
+		vm.mu.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		vm.holders--
+		if vm.holders == 0 {
+			delete(l.locks, name)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/internal/store"
+	"github.com/terabiome/homonculus/pkg/units"
+)
+
+// bucketMaintenance records the hosts in maintenance and what was done with their VMs, keyed by host.
+const bucketMaintenance = "maintenance"
+
+// Maintenance modes: what happens to the VMs placed on a host entering maintenance.
+const (
+	MaintenanceModeNone     = "none"
+	MaintenanceModeMigrate  = "migrate"
+	MaintenanceModeShutdown = "shutdown"
+)
+
+var (
+	// ErrInvalidMaintenance is returned for maintenance requests naming an unknown host or mode.
+	ErrInvalidMaintenance = errors.New("invalid maintenance request")
+	// ErrHostInMaintenance is returned when placing VMs on a host in maintenance, or when
+	// entering maintenance again with a different mode.
+	ErrHostInMaintenance = errors.New("host is in maintenance")
+	// ErrHostNotInMaintenance is returned when exiting maintenance on a host that is not in it.
+	ErrHostNotInMaintenance = errors.New("host is not in maintenance")
+)
+
+// inMaintenance reports whether a host is in maintenance.
+func (s *VMService) inMaintenance(host string) bool {
+	var maintenance parameters.HostMaintenance
+	found, err := s.store.Get(bucketMaintenance, host, &maintenance)
+	if err != nil {
+		s.logger.Warn("failed to read host maintenance", slog.String("host", host), slog.String("error", err.Error()))
+	}
+	return found
+}
+
+// ListMaintenance returns the hosts in maintenance.
+func (s *VMService) ListMaintenance() ([]parameters.HostMaintenance, error) {
+	maintenance, err := store.List[parameters.HostMaintenance](s.store, bucketMaintenance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load host maintenance: %w", err)
+	}
+	return maintenance, nil
+}
+
+// CheckMaintenance returns the error EnterMaintenance, or ExitMaintenance unless enter is set,
+// would fail with before touching any VM, so a request can be rejected before it runs as a job.
+func (s *VMService) CheckMaintenance(host, mode string, enter bool) error {
+	var maintenance parameters.HostMaintenance
+	found, err := s.store.Get(bucketMaintenance, host, &maintenance)
+	if err != nil {
+		return fmt.Errorf("failed to read host maintenance: %w", err)
+	}
+	if !enter {
+		if !found {
+			return fmt.Errorf("%w: %s", ErrHostNotInMaintenance, host)
+		}
+		return nil
+	}
+
+	if err := s.validateMaintenance(host, mode); err != nil {
+		return err
+	}
+	if found && maintenance.Mode != mode {
+		return fmt.Errorf("%w: %s is in %s maintenance", ErrHostInMaintenance, host, maintenance.Mode)
+	}
+	return nil
+}
+
+// validateMaintenance checks the host and mode of a request to enter maintenance.
+func (s *VMService) validateMaintenance(host, mode string) error {
+	if _, ok := s.hosts.Get(host); !ok {
+		return fmt.Errorf("%w: unknown hypervisor host %s", ErrInvalidMaintenance, host)
+	}
+	if mode != MaintenanceModeNone && mode != MaintenanceModeMigrate && mode != MaintenanceModeShutdown {
+		return fmt.Errorf("%w: mode must be '%s', '%s' or '%s', got '%s'",
+			ErrInvalidMaintenance, MaintenanceModeNone, MaintenanceModeMigrate, MaintenanceModeShutdown, mode)
+	}
+	if mode == MaintenanceModeMigrate && s.hosts.Len() < 2 {
+		return fmt.Errorf("%w: there is no other host to migrate VMs to", ErrInvalidMaintenance)
+	}
+	return nil
+}
+
+// EnterMaintenance marks a host unschedulable and, depending on mode, live-migrates the VMs
+// placed on it to other hosts or shuts them down gracefully, waiting up to shutdownTimeout.
+// Stopped VMs migrate offline. Entering again with the same mode retries the VMs that failed.
+func (s *VMService) EnterMaintenance(ctx context.Context, host, mode string, shutdownTimeout time.Duration) (parameters.MaintenanceReport, error) {
+	report := parameters.MaintenanceReport{Host: host, Mode: mode, InMaintenance: true}
+	if err := s.validateMaintenance(host, mode); err != nil {
+		return report, err
+	}
+
+	maintenance := parameters.HostMaintenance{Host: host, Mode: mode, StartedAt: time.Now().UTC(), Migrated: make(map[string]string)}
+	found, err := s.store.Get(bucketMaintenance, host, &maintenance)
+	if err != nil {
+		return report, fmt.Errorf("failed to read host maintenance: %w", err)
+	}
+	if found && maintenance.Mode != mode {
+		return report, fmt.Errorf("%w: %s is in %s maintenance", ErrHostInMaintenance, host, maintenance.Mode)
+	}
+	if maintenance.Migrated == nil {
+		maintenance.Migrated = make(map[string]string)
+	}
+	// Saved before VMs are moved, so nothing is scheduled onto the host meanwhile.
+	if err := s.store.Put(bucketMaintenance, host, maintenance); err != nil {
+		return report, fmt.Errorf("failed to record host maintenance: %w", err)
+	}
+	if !found {
+		s.logger.Warn("host entered maintenance", slog.String("host", host), slog.String("mode", mode))
+		s.recordEvent(ctx, EventHostMaintenance, "", host, "host entered "+mode+" maintenance", nil)
+	}
+
+	placements, err := s.hostPlacements(host)
+	if err != nil {
+		return report, err
+	}
+
+	switch mode {
+	case MaintenanceModeMigrate:
+		s.evacuateHost(ctx, &maintenance, placements, &report)
+	case MaintenanceModeShutdown:
+		s.shutDownHost(ctx, &maintenance, placements, shutdownTimeout, &report)
+	}
+
+	if err := s.store.Put(bucketMaintenance, host, maintenance); err != nil {
+		return report, fmt.Errorf("failed to record host maintenance: %w", err)
+	}
+	if len(report.Failed) > 0 {
+		return report, fmt.Errorf("failed to %s %d VM(s) of host %s: %v", mode, len(report.Failed), host, report.Failed)
+	}
+	return report, nil
+}
+
+// ExitMaintenance migrates the VMs moved off a host back to it, or starts the VMs shut down
+// on it, and makes the host schedulable again. VMs deleted or moved elsewhere since are left
+// alone. If any VM fails, the host stays in maintenance and exiting again retries it.
+func (s *VMService) ExitMaintenance(ctx context.Context, host string) (parameters.MaintenanceReport, error) {
+	report := parameters.MaintenanceReport{Host: host, InMaintenance: true}
+
+	var maintenance parameters.HostMaintenance
+	found, err := s.store.Get(bucketMaintenance, host, &maintenance)
+	if err != nil {
+		return report, fmt.Errorf("failed to read host maintenance: %w", err)
+	}
+	if !found {
+		return report, fmt.Errorf("%w: %s", ErrHostNotInMaintenance, host)
+	}
+	report.Mode = maintenance.Mode
+
+	for _, name := range slices.Sorted(maps.Keys(maintenance.Migrated)) {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		from := maintenance.Migrated[name]
+		if current := s.placementHost(name); current != from {
+			delete(maintenance.Migrated, name)
+			continue
+		}
+		if err := s.migrateVirtualMachine(ctx, name, from, host); err != nil {
+			report.Failed = append(report.Failed, name)
+			continue
+		}
+		delete(maintenance.Migrated, name)
+		report.Migrated = append(report.Migrated, name)
+	}
+
+	var stopped []string
+	for _, name := range maintenance.Stopped {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if s.placementHost(name) != host {
+			continue
+		}
+		if err := s.startVirtualMachine(ctx, parameters.StartVM{Name: name}); err != nil {
+			stopped = append(stopped, name)
+			report.Failed = append(report.Failed, name)
+			continue
+		}
+		report.Started = append(report.Started, name)
+	}
+	maintenance.Stopped = stopped
+
+	if len(report.Failed) > 0 {
+		if err := s.store.Put(bucketMaintenance, host, maintenance); err != nil {
+			s.logger.Warn("failed to record host maintenance", slog.String("host", host), slog.String("error", err.Error()))
+		}
+		return report, fmt.Errorf("failed to restore %d VM(s) of host %s: %v", len(report.Failed), host, report.Failed)
+	}
+
+	if err := s.store.Delete(bucketMaintenance, host); err != nil {
+		return report, fmt.Errorf("failed to remove host maintenance: %w", err)
+	}
+	report.InMaintenance = false
+	s.logger.Info("host exited maintenance", slog.String("host", host))
+	s.recordEvent(ctx, EventHostMaintenance, "", host, "host exited maintenance", nil)
+	return report, nil
+}
+
+// hostPlacements returns the recorded placements of the VMs on a host.
+func (s *VMService) hostPlacements(host string) ([]Placement, error) {
+	placements, err := store.List[Placement](s.store, bucketPlacements)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load placements: %w", err)
+	}
+	return slices.DeleteFunc(placements, func(placement Placement) bool {
+		return placement.Host != host
+	}), nil
+}
+
+// placementHost returns the recorded host of a VM, or "" if it has none.
+func (s *VMService) placementHost(name string) string {
+	var placement Placement
+	if found, err := s.store.Get(bucketPlacements, name, &placement); err != nil || !found {
+		return ""
+	}
+	return placement.Host
+}
+
+// evacuateHost migrates the VMs placed on a host in maintenance to the other hosts,
+// scheduling them like new VMs of their cluster.
+func (s *VMService) evacuateHost(ctx context.Context, maintenance *parameters.HostMaintenance, placements []Placement, report *parameters.MaintenanceReport) {
+	scheduled := make(map[string]bool, len(placements))
+	for _, placement := range placements {
+		scheduled[placement.VM] = true
+	}
+	candidates, err := s.loadHostCandidates(ctx, scheduled)
+	if err != nil {
+		s.logger.Error("failed to load hosts to migrate to", slog.String("error", err.Error()))
+		for _, placement := range placements {
+			report.Failed = append(report.Failed, placement.VM)
+		}
+		return
+	}
+
+	for _, placement := range placements {
+		if ctx.Err() != nil {
+			report.Failed = append(report.Failed, placement.VM)
+			continue
+		}
+
+		var vmInfo parameters.VMInfo
+		err := s.withHypervisor(ctx, maintenance.Host, func(hypervisor dependencies.HypervisorContext) error {
+			var err error
+			vmInfo, err = s.libvirtManager.GetVirtualMachineInfo(ctx, hypervisor, parameters.QueryVM{Name: placement.VM})
+			return err
+		})
+		if err != nil {
+			s.logger.Error("failed to query VM to migrate", slog.String("vm", placement.VM), slog.String("error", err.Error()))
+			report.Failed = append(report.Failed, placement.VM)
+			continue
+		}
+
+		vm := parameters.CreateVM{
+			Name:          placement.VM,
+			VCPUCount:     int(vmInfo.VCPUCount),
+			MemoryMB:      int64(vmInfo.MemoryMB),
+			Role:          placement.Role,
+			SpreadGroup:   placement.SpreadGroup,
+			ColocateGroup: placement.ColocateGroup,
+		}
+		chosen, err := s.pickHost(ctx, candidates, placement.Cluster, vm, 0)
+		if err == nil {
+			err = s.migrateVirtualMachine(ctx, placement.VM, maintenance.Host, chosen.name)
+		}
+		if err != nil {
+			s.logger.Error("failed to migrate VM off host in maintenance",
+				slog.String("vm", placement.VM),
+				slog.String("host", maintenance.Host),
+				slog.String("error", err.Error()),
+			)
+			report.Failed = append(report.Failed, placement.VM)
+			continue
+		}
+
+		chosen.pendingMemoryKiB += units.MiBToKiB(uint64(vm.MemoryMB))
+		chosen.pendingVCPUs += uint(vm.VCPUCount)
+		if key := spreadKey(placement.Cluster, vm); key != "" {
+			chosen.spreadAssignments[key]++
+		}
+		if key := groupKey(placement.Cluster, vm.SpreadGroup); key != "" {
+			chosen.spreadGroups[key] = true
+		}
+		maintenance.Migrated[placement.VM] = chosen.name
+		report.Migrated = append(report.Migrated, placement.VM)
+	}
+}
+
+// shutDownHost gracefully shuts down the running VMs placed on a host in maintenance.
+func (s *VMService) shutDownHost(ctx context.Context, maintenance *parameters.HostMaintenance, placements []Placement, shutdownTimeout time.Duration, report *parameters.MaintenanceReport) {
+	var stopping []string
+	for _, placement := range placements {
+		vmInfo, found, err := s.GetVirtualMachine(ctx, placement.VM)
+		if err != nil {
+			report.Failed = append(report.Failed, placement.VM)
+			continue
+		}
+		if !found || vmInfo.State != "running" {
+			continue
+		}
+		if err := s.stopVirtualMachine(ctx, parameters.StopVM{Name: placement.VM}); err != nil {
+			report.Failed = append(report.Failed, placement.VM)
+			continue
+		}
+		stopping = append(stopping, placement.VM)
+		if !slices.Contains(maintenance.Stopped, placement.VM) {
+			maintenance.Stopped = append(maintenance.Stopped, placement.VM)
+		}
+	}
+
+	if len(stopping) == 0 {
+		return
+	}
+	if err := s.WaitForShutoff(ctx, stopping, shutdownTimeout); err != nil {
+		s.logger.Error("VMs of host in maintenance did not shut off",
+			slog.String("host", maintenance.Host),
+			slog.String("error", err.Error()),
+		)
+		report.Failed = append(report.Failed, stopping...)
+		return
+	}
+	report.Stopped = append(report.Stopped, stopping...)
+}
+
+// migrateVirtualMachine migrates a VM between hosts and moves its recorded placement along.
+func (s *VMService) migrateVirtualMachine(ctx context.Context, name, from, to string) error {
+	unlock := s.vmLocks.lock(name)
+	defer unlock()
+
+	source, releaseSource, err := s.acquireHypervisor(ctx, from)
+	if err != nil {
+		return err
+	}
+	defer releaseSource()
+	destination, releaseDestination, err := s.acquireHypervisor(ctx, to)
+	if err != nil {
+		return err
+	}
+	defer releaseDestination()
+
+	defer s.vmInfos.invalidate()
+	if err := s.libvirtManager.MigrateVirtualMachine(ctx, source, destination, name); err != nil {
+		s.recordEvent(ctx, EventVMMigrateFailed, name, from, "failed to migrate virtual machine to "+to, err)
+		return err
+	}
+
+	var placement Placement
+	if _, err := s.store.Get(bucketPlacements, name, &placement); err != nil {
+		s.logger.Warn("failed to read VM placement", slog.String("vm", name), slog.String("error", err.Error()))
+	}
+	placement.VM = name
+	placement.Host = to
+	placement.PlacedAt = time.Now().UTC()
+	if err := s.store.Put(bucketPlacements, name, placement); err != nil {
+		s.logger.Warn("failed to record VM placement", slog.String("vm", name), slog.String("error", err.Error()))
+	}
+	s.recordEvent(ctx, EventVMMigrated, name, to, "migrated virtual machine from "+from, nil)
+	return nil
+}
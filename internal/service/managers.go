@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/cloudinit"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/disk"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/libvirt"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/netboot"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/qemu"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"libvirt.org/go/libvirtxml"
+)
+
+// The infrastructure managers implement the interfaces VMService depends on.
+var (
+	_ LibvirtManager   = (*libvirt.Manager)(nil)
+	_ LibvirtManager   = (*qemu.Manager)(nil)
+	_ DiskManager      = (*disk.Manager)(nil)
+	_ CloudInitManager = (*cloudinit.Manager)(nil)
+	_ NetbootManager   = (*netboot.Manager)(nil)
+)
+
+// LibvirtManager defines, controls and inspects the domains of a hypervisor.
+// It is implemented by libvirt.Manager, by qemu.Manager for hosts without libvirtd,
+// and in memory by fake.Hypervisor.
+type LibvirtManager interface {
+	CreateVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.CreateVM, virtualMachineUUID uuid.UUID) error
+	RenderVirtualMachineXML(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.CreateVM, virtualMachineUUID uuid.UUID) (libvirtxml.Domain, error)
+	CloneVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, baseDomainXML libvirtxml.Domain, targetInfo parameters.TargetVMSpec, virtualMachineUUID uuid.UUID, cloudInitISOPath string) error
+	UndefineVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, name string) error
+	DiskReferences(ctx context.Context, hypervisor dependencies.HypervisorContext) (parameters.DiskReferences, error)
+	DeleteVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.DeleteVM, references parameters.DiskReferences) (string, error)
+	RemoveDisk(ctx context.Context, hypervisor dependencies.HypervisorContext, vmName, path string, container bool) error
+	StartVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.StartVM) error
+	StopVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.StopVM) error
+	MigrateVirtualMachine(ctx context.Context, source, destination dependencies.HypervisorContext, name string) error
+	CheckVirtualMachineExistence(hypervisor dependencies.HypervisorContext, name string) (bool, error)
+	GetVirtualMachineInfo(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.QueryVM) (parameters.VMInfo, error)
+	GetVirtualMachineXML(hypervisor dependencies.HypervisorContext, name string) (libvirtxml.Domain, error)
+	ListAllVirtualMachines(ctx context.Context, hypervisor dependencies.HypervisorContext) ([]parameters.VMInfo, error)
+	GetHostCapacity(ctx context.Context, hypervisor dependencies.HypervisorContext) (parameters.HostCapacity, error)
+	GetConsoleAddress(ctx context.Context, hypervisor dependencies.HypervisorContext, name string) (string, error)
+	SetGraphicsPassword(ctx context.Context, hypervisor dependencies.HypervisorContext, name, password string, validTo time.Time) (parameters.GraphicsInfo, error)
+	OpenSerialConsole(ctx context.Context, hypervisor dependencies.HypervisorContext, name string, takeOver bool) (io.ReadWriteCloser, error)
+	GetVirtualMachineStats(hypervisor dependencies.HypervisorContext, name string) (parameters.VMStatsSample, error)
+	CloudInitStatus(hypervisor dependencies.HypervisorContext, name string) (string, error)
+	GuestAgentReady(hypervisor dependencies.HypervisorContext, name string) (bool, error)
+	GuestExec(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.GuestExec) (parameters.GuestExecResult, error)
+	AttachDevices(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.AttachDevices) ([]int, error)
+	DetachDevices(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.DetachDevices) error
+	ChangeMedia(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.ChangeMedia) error
+	SetVirtualMachineMetadata(ctx context.Context, hypervisor dependencies.HypervisorContext, name string, labels map[string]string, ownership parameters.Ownership) error
+	CreateSnapshot(ctx context.Context, hypervisor dependencies.HypervisorContext, vmName, snapshotName, description string) error
+	ListSnapshots(hypervisor dependencies.HypervisorContext, vmName string) ([]string, error)
+	DeleteSnapshot(hypervisor dependencies.HypervisorContext, vmName, snapshotName string) error
+	RevertSnapshot(hypervisor dependencies.HypervisorContext, vmName, snapshotName string) error
+}
+
+// DiskManager creates the disks of VMs on a hypervisor.
+type DiskManager interface {
+	CreateDisk(ctx context.Context, hypervisor dependencies.HypervisorContext, req parameters.CreateVM) error
+	CreateDiskForClone(ctx context.Context, hypervisor dependencies.HypervisorContext, req parameters.TargetVMSpec) error
+}
+
+// CloudInitManager builds the cloud-init ISOs of VMs, and the cloud-init seeds of containers, on a hypervisor.
+type CloudInitManager interface {
+	CreateISO(ctx context.Context, hypervisor dependencies.HypervisorContext, vmParams parameters.CreateVM, instanceID uuid.UUID) error
+	CreateSeed(ctx context.Context, hypervisor dependencies.HypervisorContext, vmParams parameters.CreateVM, instanceID uuid.UUID) error
+	Render(vmParams parameters.CreateVM, instanceID uuid.UUID) ([]parameters.VMArtifact, error)
+}
+
+// NetbootManager runs the DHCP/TFTP helpers of netbooted VMs on a hypervisor.
+type NetbootManager interface {
+	EnsureServer(ctx context.Context, hypervisor dependencies.HypervisorContext, bridge string, server parameters.NetbootServer) error
+	StopServer(ctx context.Context, hypervisor dependencies.HypervisorContext, bridge string) error
+}
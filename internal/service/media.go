@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/libvirt"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+)
+
+// InsertMedia inserts an ISO into a CD-ROM drive of a VM, replacing any media already in it.
+func (s *VMService) InsertMedia(ctx context.Context, params parameters.ChangeMedia) error {
+	params.Name = qualify(ctx, params.Name)
+	if err := s.paths.Check(params.Path); err != nil {
+		return fmt.Errorf("VM %s media path: %w", params.Name, err)
+	}
+	if err := s.changeMedia(ctx, params); err != nil {
+		return fmt.Errorf("failed to insert media into VM %s: %w", params.Name, err)
+	}
+	return nil
+}
+
+// EjectMedia empties a CD-ROM drive of a VM.
+func (s *VMService) EjectMedia(ctx context.Context, params parameters.ChangeMedia) error {
+	params.Name = qualify(ctx, params.Name)
+	params.Path = ""
+	if err := s.changeMedia(ctx, params); err != nil {
+		return fmt.Errorf("failed to eject media from VM %s: %w", params.Name, err)
+	}
+	return nil
+}
+
+// changeMedia applies a media change and records it in the stored cluster spec,
+// so the reconciler recreates extra CD-ROM drives with their current media.
+func (s *VMService) changeMedia(ctx context.Context, params parameters.ChangeMedia) error {
+	unlock := s.vmLocks.lock(params.Name)
+	defer unlock()
+
+	err := s.withVirtualMachineHypervisor(ctx, params.Name, func(hypervisor dependencies.HypervisorContext) error {
+		defer s.vmInfos.invalidate()
+		return s.libvirtManager.ChangeMedia(ctx, hypervisor, params)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.updateVirtualMachineSpec(params.Name, func(vm *parameters.CreateVM) {
+		for i := range vm.CDROMs {
+			if (params.Device == "" && i == 0) || params.Device == libvirt.CDROMTarget(i) {
+				vm.CDROMs[i].Path = params.Path
+			}
+		}
+	})
+	if err != nil {
+		s.logger.Warn("failed to record media change in cluster spec", slog.String("vm", params.Name), slog.String("error", err.Error()))
+	}
+	return nil
+}
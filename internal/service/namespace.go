@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"regexp"
+	"strings"
+
+	"github.com/terabiome/homonculus/internal/service/parameters"
+)
+
+// LabelNamespace is the label key recording the namespace a VM was created in.
+const LabelNamespace = "namespace"
+
+// namespaceSeparator joins a namespace and the name of a VM within it into the libvirt domain
+// name. Namespaces cannot contain it, so the domain name splits unambiguously.
+const namespaceSeparator = "."
+
+// namespacePattern matches valid namespaces: DNS labels of at most 32 characters.
+var namespacePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,30}[a-z0-9])?$`)
+
+type namespaceKey struct{}
+
+// WithNamespace returns a copy of ctx scoping the VM operations it is passed to to a namespace:
+// VM and cluster names are taken relative to the namespace, and queries only see its VMs.
+// Without a namespace, operations see every VM under its full name.
+func WithNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, namespace)
+}
+
+// namespaceFromContext returns the namespace stored in ctx, or "" for unscoped operations.
+func namespaceFromContext(ctx context.Context) string {
+	namespace, _ := ctx.Value(namespaceKey{}).(string)
+	return namespace
+}
+
+// ValidateNamespace checks that a namespace is a lowercase DNS label of at most 32 characters.
+func ValidateNamespace(namespace string) error {
+	if !namespacePattern.MatchString(namespace) {
+		return fmt.Errorf("invalid namespace %q: must be a lowercase DNS label of at most 32 characters", namespace)
+	}
+	return nil
+}
+
+// qualify returns the full name of a VM or cluster named relative to the namespace of ctx.
+// Names that are already qualified are returned as they are, so qualifying is idempotent.
+func qualify(ctx context.Context, name string) string {
+	namespace := namespaceFromContext(ctx)
+	if namespace == "" || name == "" || strings.HasPrefix(name, namespace+namespaceSeparator) {
+		return name
+	}
+	return namespace + namespaceSeparator + name
+}
+
+// localName returns the name of a VM or cluster relative to the namespace of ctx.
+func localName(ctx context.Context, name string) string {
+	namespace := namespaceFromContext(ctx)
+	if namespace == "" {
+		return name
+	}
+	return strings.TrimPrefix(name, namespace+namespaceSeparator)
+}
+
+// qualifyAll qualifies every name of names.
+func qualifyAll(ctx context.Context, names []string) []string {
+	if namespaceFromContext(ctx) == "" {
+		return names
+	}
+	qualified := make([]string, len(names))
+	for i, name := range names {
+		qualified[i] = qualify(ctx, name)
+	}
+	return qualified
+}
+
+// qualifyCreateVMs qualifies the names of VMs to create in the namespace of ctx and labels them
+// with it. Guests keep their name relative to the namespace as hostname.
+func qualifyCreateVMs(ctx context.Context, vms []parameters.CreateVM) {
+	namespace := namespaceFromContext(ctx)
+	if namespace == "" {
+		return
+	}
+	for i := range vms {
+		if vms[i].Hostname == "" {
+			vms[i].Hostname = localName(ctx, vms[i].Name)
+		}
+		vms[i].Name = qualify(ctx, vms[i].Name)
+		vms[i].Labels = maps.Clone(vms[i].Labels)
+		if vms[i].Labels == nil {
+			vms[i].Labels = make(map[string]string)
+		}
+		vms[i].Labels[LabelNamespace] = namespace
+	}
+}
+
+// scopeVMInfos sets the namespace of every VM. Scoped to a namespace, it keeps only the VMs of
+// that namespace and names them, and the cluster they belong to, relative to it.
+func scopeVMInfos(ctx context.Context, vmInfos []parameters.VMInfo) []parameters.VMInfo {
+	namespace := namespaceFromContext(ctx)
+	prefix := namespace + namespaceSeparator
+
+	scoped := make([]parameters.VMInfo, 0, len(vmInfos))
+	for _, vmInfo := range vmInfos {
+		vmInfo.Namespace = vmInfo.Labels[LabelNamespace]
+		if vmInfo.Ownership != nil && vmInfo.Ownership.Namespace != "" {
+			vmInfo.Namespace = vmInfo.Ownership.Namespace
+		}
+		if namespace != "" {
+			if vmInfo.Namespace != namespace || !strings.HasPrefix(vmInfo.Name, prefix) {
+				continue
+			}
+			vmInfo.Name = strings.TrimPrefix(vmInfo.Name, prefix)
+			if cluster, ok := vmInfo.Labels[LabelCluster]; ok {
+				vmInfo.Labels = maps.Clone(vmInfo.Labels)
+				vmInfo.Labels[LabelCluster] = strings.TrimPrefix(cluster, prefix)
+			}
+		}
+		scoped = append(scoped, vmInfo)
+	}
+	return scoped
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/internal/store"
+)
+
+// bucketNetboot records the DHCP/TFTP helpers started for network-booted VMs.
+const bucketNetboot = "netboot"
+
+// NetbootServer records a DHCP/TFTP helper and the VMs relying on it.
+type NetbootServer struct {
+	Host   string   `json:"host"`
+	Bridge string   `json:"bridge"`
+	VMs    []string `json:"vms"`
+}
+
+func netbootKey(host, bridge string) string {
+	return host + "/" + bridge
+}
+
+// acquireNetbootServer makes sure the helper requested by vm runs on its bridge and records vm as a user.
+func (s *VMService) acquireNetbootServer(ctx context.Context, hypervisor dependencies.HypervisorContext, vm parameters.CreateVM) error {
+	s.netbootMu.Lock()
+	defer s.netbootMu.Unlock()
+
+	key := netbootKey(hypervisor.Host, vm.BridgeNetworkInterface)
+	server := NetbootServer{Host: hypervisor.Host, Bridge: vm.BridgeNetworkInterface}
+	if _, err := s.store.Get(bucketNetboot, key, &server); err != nil {
+		return err
+	}
+
+	if err := s.netbootManager.EnsureServer(ctx, hypervisor, vm.BridgeNetworkInterface, *vm.Netboot.Server); err != nil {
+		return err
+	}
+
+	if !slices.Contains(server.VMs, vm.Name) {
+		server.VMs = append(server.VMs, vm.Name)
+	}
+	if err := s.store.Put(bucketNetboot, key, server); err != nil {
+		// An unrecorded helper would never be stopped, unless other VMs still rely on it.
+		if len(server.VMs) == 1 {
+			if err := s.netbootManager.StopServer(ctx, hypervisor, server.Bridge); err != nil {
+				s.logger.Warn("failed to stop netboot server", slog.String("bridge", server.Bridge), slog.String("error", err.Error()))
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// releaseNetbootServer drops name from the users of its helper and stops helpers left without
+// users. Callers hold the connection to the host of the VM, so no second connection is taken
+// while netbootMu is held. Helpers the VM used on other hosts, before it migrated, are left
+// running and recorded, so they are stopped along with the next VM reusing them.
+func (s *VMService) releaseNetbootServer(ctx context.Context, hypervisor dependencies.HypervisorContext, name string) {
+	s.netbootMu.Lock()
+	defer s.netbootMu.Unlock()
+
+	servers, err := store.List[NetbootServer](s.store, bucketNetboot)
+	if err != nil {
+		s.logger.Warn("failed to load netboot servers", slog.String("error", err.Error()))
+		return
+	}
+
+	for _, server := range servers {
+		if !slices.Contains(server.VMs, name) {
+			continue
+		}
+
+		key := netbootKey(server.Host, server.Bridge)
+		server.VMs = slices.DeleteFunc(server.VMs, func(vm string) bool { return vm == name })
+		if len(server.VMs) > 0 {
+			if err := s.store.Put(bucketNetboot, key, server); err != nil {
+				s.logger.Warn("failed to record netboot server", slog.String("bridge", server.Bridge), slog.String("error", err.Error()))
+			}
+			continue
+		}
+
+		var err error
+		if server.Host == hypervisor.Host {
+			err = s.netbootManager.StopServer(ctx, hypervisor, server.Bridge)
+		} else {
+			err = fmt.Errorf("%s is not the current host of VM %s", server.Host, name)
+		}
+		if err != nil {
+			// The record is kept, so the helper is stopped along with the next VM reusing it.
+			s.logger.Warn("failed to stop netboot server",
+				slog.String("host", server.Host),
+				slog.String("bridge", server.Bridge),
+				slog.String("error", err.Error()),
+			)
+			if err := s.store.Put(bucketNetboot, key, server); err != nil {
+				s.logger.Warn("failed to record netboot server", slog.String("bridge", server.Bridge), slog.String("error", err.Error()))
+			}
+			continue
+		}
+		if err := s.store.Delete(bucketNetboot, key); err != nil {
+			s.logger.Warn("failed to remove netboot server", slog.String("bridge", server.Bridge), slog.String("error", err.Error()))
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// ErrInvalidDependencies is returned for start and stop requests whose dependencies cannot be ordered.
+var ErrInvalidDependencies = errors.New("invalid dependencies")
+
+// dependencyTiers orders VMs by their dependencies: every VM is placed in the tier after the last
+// of the VMs it depends on, keeping request order within a tier. It also returns the indices of
+// the dependencies of every VM. Dependencies must name other VMs of the request and must not form a cycle.
+func dependencyTiers(names []string, dependsOn [][]string) (tiers [][]int, dependencies [][]int, err error) {
+	dependencies = make([][]int, len(names))
+	if !slices.ContainsFunc(dependsOn, func(names []string) bool { return len(names) > 0 }) {
+		// Without dependencies all VMs form one tier in request order, and names need not be unique.
+		tier := make([]int, len(names))
+		for i := range names {
+			tier[i] = i
+		}
+		return [][]int{tier}, dependencies, nil
+	}
+
+	index := make(map[string]int, len(names))
+	for i, name := range names {
+		if _, duplicate := index[name]; duplicate {
+			return nil, nil, fmt.Errorf("%w: VM %s is listed more than once", ErrInvalidDependencies, name)
+		}
+		index[name] = i
+	}
+
+	for i, dependencyNames := range dependsOn {
+		for _, dependency := range dependencyNames {
+			j, ok := index[dependency]
+			if !ok {
+				return nil, nil, fmt.Errorf("%w: VM %s depends on %s, which is not part of the request", ErrInvalidDependencies, names[i], dependency)
+			}
+			if j == i {
+				return nil, nil, fmt.Errorf("%w: VM %s depends on itself", ErrInvalidDependencies, names[i])
+			}
+			dependencies[i] = append(dependencies[i], j)
+		}
+	}
+
+	// A tier of -1 marks a VM whose tier is being computed, so reaching it again is a cycle.
+	tierOf := make([]int, len(names))
+	for i := range tierOf {
+		tierOf[i] = -2
+	}
+	var place func(i int) (int, error)
+	place = func(i int) (int, error) {
+		switch tierOf[i] {
+		case -1:
+			return 0, fmt.Errorf("%w: dependency cycle through VM %s", ErrInvalidDependencies, names[i])
+		case -2:
+		default:
+			return tierOf[i], nil
+		}
+
+		tierOf[i] = -1
+		tier := 0
+		for _, j := range dependencies[i] {
+			dependencyTier, err := place(j)
+			if err != nil {
+				return 0, err
+			}
+			tier = max(tier, dependencyTier+1)
+		}
+		tierOf[i] = tier
+		return tier, nil
+	}
+
+	for i := range names {
+		tier, err := place(i)
+		if err != nil {
+			return nil, nil, err
+		}
+		for len(tiers) <= tier {
+			tiers = append(tiers, nil)
+		}
+		tiers[tier] = append(tiers[tier], i)
+	}
+	return tiers, dependencies, nil
+}
+
+// reverseTiers turns start order into stop order: tiers run last to first, and every VM waits
+// for the VMs that depend on it instead of the VMs it depends on.
+func reverseTiers(tiers [][]int, dependencies [][]int) ([][]int, [][]int) {
+	reversed := make([][]int, 0, len(tiers))
+	for i := len(tiers) - 1; i >= 0; i-- {
+		reversed = append(reversed, tiers[i])
+	}
+	dependents := make([][]int, len(dependencies))
+	for i, vmDependencies := range dependencies {
+		for _, j := range vmDependencies {
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+	return reversed, dependents
+}
+
+// runTiers runs an action on the VMs tier by tier. After every tier but the last, gate is called
+// with the VMs the action succeeded on. A VM is skipped when the action failed on one of its
+// prerequisites, and every later VM is skipped when a gate fails.
+func (s *VMService) runTiers(ctx context.Context, action string, names []string, tiers [][]int, prerequisites [][]int,
+	run func(i int) error, gate func(ctx context.Context, names []string) error) error {
+	var (
+		failedVMs []string
+		failures  []error
+		done      int
+	)
+	failed := make([]bool, len(names))
+	fail := func(i int, err error) {
+		failed[i] = true
+		failedVMs = append(failedVMs, names[i])
+		failures = append(failures, err)
+	}
+
+	for t, tier := range tiers {
+		var succeeded []string
+		for _, i := range tier {
+			if err := ctx.Err(); err != nil {
+				return cancelledError(action, done, len(names), failedVMs, err)
+			}
+			done++
+
+			var skipReason error
+			for _, j := range prerequisites[i] {
+				if failed[j] {
+					skipReason = fmt.Errorf("skipped %s of VM %s: %s of VM %s failed", action, names[i], action, names[j])
+					break
+				}
+			}
+			if skipReason != nil {
+				s.logger.Warn("skipping VM", slog.String("vm", names[i]), slog.String("reason", skipReason.Error()))
+				fail(i, skipReason)
+				continue
+			}
+
+			if err := run(i); err != nil {
+				fail(i, err)
+				continue
+			}
+			succeeded = append(succeeded, names[i])
+		}
+
+		if t == len(tiers)-1 || len(succeeded) == 0 {
+			continue
+		}
+		s.logger.Info("waiting for tier before continuing", slog.String("action", action), slog.Int("tier", t+1), slog.Any("vms", succeeded))
+		if err := gate(ctx, succeeded); err != nil {
+			for _, later := range tiers[t+1:] {
+				for _, i := range later {
+					fail(i, fmt.Errorf("skipped %s of VM %s: %w", action, names[i], err))
+				}
+			}
+			break
+		}
+	}
+
+	if len(failedVMs) > 0 {
+		return &batchError{action: action, vms: failedVMs, errs: failures}
+	}
+	return nil
+}
+
+// WaitForShutoff polls the named VMs until every one is shut off or undefined,
+// or fails once timeout elapsed.
+func (s *VMService) WaitForShutoff(ctx context.Context, names []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pending := qualifyAll(ctx, names)
+	for {
+		var running []string
+		for _, name := range pending {
+			vmInfo, found, err := s.GetVirtualMachine(ctx, name)
+			if ctx.Err() != nil {
+				break
+			}
+			if err != nil {
+				s.logger.Debug("failed to query VM for shutdown", slog.String("vm", name), slog.String("error", err.Error()))
+			}
+			if err != nil || (found && vmInfo.State != "shutoff") {
+				running = append(running, name)
+			}
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("virtual machines not shut off after %s: %v", timeout, pending)
+		}
+		if len(running) == 0 {
+			return nil
+		}
+		pending = running
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("virtual machines not shut off after %s: %v", timeout, pending)
+		case <-time.After(readinessPollInterval):
+		}
+	}
+}
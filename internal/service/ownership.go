@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/errdefs"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/libvirt"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+)
+
+// newOwnership returns the ownership recorded in the domain metadata of a VM created in ctx.
+func newOwnership(ctx context.Context, vm parameters.CreateVM, created time.Time) (parameters.Ownership, error) {
+	hash, err := specHash(vm)
+	if err != nil {
+		return parameters.Ownership{}, err
+	}
+	return parameters.Ownership{
+		Cluster:   vm.Labels[LabelCluster],
+		Namespace: namespaceFromContext(ctx),
+		Owner:     vm.Owner,
+		SpecHash:  hash,
+		CreatedAt: created,
+	}, nil
+}
+
+// specHash returns the hex SHA-256 of the create spec of a VM, apart from its ownership.
+func specHash(vm parameters.CreateVM) (string, error) {
+	vm.Ownership = parameters.Ownership{}
+	data, err := json.Marshal(vm)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash spec of VM %s: %w", vm.Name, err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// managedVMInfos returns the VMs homonculus defined, leaving out domains defined by other means.
+func managedVMInfos(vmInfos []parameters.VMInfo) []parameters.VMInfo {
+	managed := make([]parameters.VMInfo, 0, len(vmInfos))
+	for _, vmInfo := range vmInfos {
+		if vmInfo.Ownership != nil {
+			managed = append(managed, vmInfo)
+		}
+	}
+	return managed
+}
+
+// checkOwnership refuses to touch a domain homonculus did not define, or one outside the
+// namespace of ctx, judging by the ownership recorded in its metadata, which it returns.
+func (s *VMService) checkOwnership(ctx context.Context, hypervisor dependencies.HypervisorContext, name string) (libvirt.DomainMetadata, error) {
+	domainXML, err := s.libvirtManager.GetVirtualMachineXML(hypervisor, name)
+	if err != nil {
+		return libvirt.DomainMetadata{}, err
+	}
+	metadata, err := libvirt.ParseDomainMetadata(domainXML)
+	if err != nil {
+		return metadata, err
+	}
+	if !metadata.Managed() {
+		return metadata, fmt.Errorf("%w: %s", errdefs.ErrVMNotManaged, name)
+	}
+
+	if namespace := namespaceFromContext(ctx); namespace != "" {
+		// Domains defined before ownership was recorded only carry the namespace label.
+		owned := metadata.Namespace
+		if owned == "" {
+			owned = metadata.LabelMap()[LabelNamespace]
+		}
+		if owned != namespace {
+			return metadata, fmt.Errorf("%w: %s", errdefs.ErrVMNotFound, localName(ctx, name))
+		}
+	}
+	return metadata, nil
+}
@@ -1,33 +1,170 @@
 package parameters
 
+import "time"
+
 // NUMAMemory contains NUMA memory tuning configuration.
 type NUMAMemory struct {
 	Nodeset string
 	Mode    string // strict, preferred, or interleave
 }
 
+// Hugepages contains hugepage memory backing configuration.
+type Hugepages struct {
+	PageSize string // e.g. 2M or 1G
+	Nodeset  string // NUMA nodes to allocate pages from (default: all)
+}
+
 // VMTuning contains virtual machine performance tuning configuration.
 type VMTuning struct {
+	AutoPin        bool
 	VCPUPins       []string
 	EmulatorCPUSet string
+	IOThreads      int
+	IOThreadPins   []string
 	NUMAMemory     *NUMAMemory
+	Hugepages      *Hugepages
+}
+
+// HostDevice selects a host PCI device to pass through, by address or vendor:device id.
+type HostDevice struct {
+	Address      string
+	VendorDevice string
+}
+
+// USBDevice selects a host USB device to pass through by vendor:product id.
+type USBDevice struct {
+	VendorProduct string
+}
+
+// SerialDevice describes an extra guest serial port.
+type SerialDevice struct {
+	Type string // pty, tcp, unix, file or dev
+	Path string
+	Host string
+	Port int
+}
+
+// DiskOptions are the qcow2 creation options of the disk of a VM. Zero values keep the qemu-img defaults.
+type DiskOptions struct {
+	Preallocation   string // off, metadata, falloc or full
+	ClusterSize     int64  // bytes
+	CompressionType string // zlib or zstd
+}
+
+// Watchdog describes an emulated hardware watchdog.
+type Watchdog struct {
+	Model  string
+	Action string
+}
+
+// CDROM describes an extra CD-ROM drive.
+type CDROM struct {
+	Path string // empty for an empty drive
+}
+
+// Graphics contains the graphical console configuration of a virtual machine.
+type Graphics struct {
+	Type     string // none, vnc or spice
+	Listen   string
+	Port     int // 0 allocates a port automatically
+	Password string
+}
+
+// Netboot configures a virtual machine that boots from the network instead of a base image.
+type Netboot struct {
+	Server *NetbootServer // nil relies on an existing PXE server
+}
+
+// NetbootServer configures the dnsmasq DHCP/TFTP helper started on the hypervisor.
+type NetbootServer struct {
+	DHCPRange string
+	TFTPRoot  string
+	BootFile  string
+}
+
+// GraphicsInfo describes a graphical console of a virtual machine.
+type GraphicsInfo struct {
+	Type   string
+	Listen string
+	Port   int
+}
+
+// NetworkConfig contains the static guest network configuration rendered into cloud-init.
+type NetworkConfig struct {
+	IPv4Address string // CIDR notation
+	IPv4Gateway string
+	Nameservers []string
+}
+
+// ProxyConfig contains the HTTP(S) proxy rendered into the guest environment through cloud-init.
+type ProxyConfig struct {
+	HTTPProxy  string
+	HTTPSProxy string
+	NoProxy    string
 }
 
 // HostBindMount contains list of mount points from host on virtual machines
 type HostBindMount struct {
 	SourceDir string
 	TargetDir string
+	Driver    string // virtiofs or 9p
+	ReadOnly  bool
+	Tag       string // mount tag shared by the domain device and the guest mount
+}
+
+// CreateCluster contains transport-agnostic parameters for creating a cluster of virtual machines.
+type CreateCluster struct {
+	Name            string
+	SSHKeys         []string // names of generated SSH keys authorized for every user
+	VirtualMachines []CreateVM
+}
+
+// CreateFleet contains transport-agnostic parameters for creating Count linked clones of the
+// golden image of Template, persisted as the named cluster Name.
+type CreateFleet struct {
+	Name        string
+	NamePrefix  string
+	Count       int
+	SSHKeys     []string
+	AddressPool *AddressPool // static addresses; without a pool the VMs use DHCP
+	Template    CreateVM
+}
+
+// AddressPool is a range of static IPv4 addresses handed out to the VMs of a fleet or an IP pool.
+type AddressPool struct {
+	Network     string // CIDR notation
+	Start       string // first address handed out; defaults to the first host address
+	End         string // last address handed out; defaults to the last host address
+	Gateway     string
+	Nameservers []string
+}
+
+// IPPoolUsage describes a configured IP pool and the addresses allocated from it.
+type IPPoolUsage struct {
+	Name        string
+	Pool        AddressPool
+	Allocations []AddressAllocation
+}
+
+// AddressAllocation is an address a VM holds from an IP pool.
+type AddressAllocation struct {
+	VM      string
+	Address string // CIDR notation
 }
 
 // CreateVM contains transport-agnostic parameters for creating a virtual machine.
 type CreateVM struct {
 	Name                   string
+	NamePrefix             string // with Count, expanded by the service into NamePrefix-1..NamePrefix-Count
+	Count                  int
 	VCPUCount              int
 	MemoryMB               int64
 	DiskPath               string
 	DiskSizeGB             int64
 	BaseImagePath          string
+	DiskOptions            *DiskOptions
 	BridgeNetworkInterface string
+	MACAddress             string // of the bridge interface; generated by the hypervisor if empty
 	CloudInitISOPath       string
 	HostBindMounts         []HostBindMount
 	Role                   string
@@ -36,16 +173,99 @@ type CreateVM struct {
 	UserConfigs            []UserConfig
 	Runcmds                []string
 	Tuning                 *VMTuning
+	Labels                 map[string]string
+	Owner                  string    // API caller that created the VM
+	Ownership              Ownership // recorded in the domain metadata; set by the service
+	KeepRunning            bool
+	TTL                    time.Duration // 0 never expires
+	OnExpiry               string        // delete or stop
+	Host                   string
+	SpreadGroup            string
+	ColocateGroup          string
+	Firmware               string
+	SecureBoot             bool
+	NVRAMPath              string
+	MachineType            string
+	TPM                    bool
+	HostDevices            []HostDevice
+	USBDevices             []USBDevice
+	SerialDevices          []SerialDevice
+	CDROMs                 []CDROM
+	Watchdog               *Watchdog
+	OnPoweroff             string
+	OnReboot               string
+	OnCrash                string
+	Graphics               *Graphics
+	Hostname               string // defaults to Name
+	Network                *NetworkConfig
+	IPFromPool             string // configured IP pool Network is allocated from
+	Proxy                  *ProxyConfig
+	Netboot                *Netboot
+	ReadinessProbes        []ReadinessProbe
+	Start                  bool
+	KeepArtifactsOnFailure bool
+}
+
+// Ownership records which request defined a domain. It is embedded in the domain metadata,
+// so it survives the loss of the state store.
+type Ownership struct {
+	Cluster   string
+	Namespace string
+	Owner     string
+	SpecHash  string // hex SHA-256 of the create spec
+	CreatedAt time.Time
+	Adopted   bool // defined outside homonculus and adopted later
+}
+
+// AdoptVM contains transport-agnostic parameters for adopting a domain defined outside homonculus.
+type AdoptVM struct {
+	Name    string
+	Host    string // located by name if empty
+	Cluster string
+	Labels  map[string]string
 }
 
 // DeleteVM contains transport-agnostic parameters for deleting a virtual machine.
 type DeleteVM struct {
-	Name string
+	Name      string
+	KeepDisks bool // undefine the VM but leave its disks in place
 }
 
+// DiskReferences maps the disk files of the VMs on a hypervisor, and the backing files of those
+// disks, to the names of the VMs using them. A cluster deletion builds it once per hypervisor.
+type DiskReferences map[string][]string
+
 // StartVM contains transport-agnostic parameters for starting a virtual machine.
 type StartVM struct {
-	Name string
+	Name      string
+	DependsOn []string // VMs of the same request that must be ready before this VM starts
+}
+
+// StopVM contains transport-agnostic parameters for stopping a virtual machine.
+type StopVM struct {
+	Name      string
+	DependsOn []string // VMs of the same request that only stop once this VM has shut off
+}
+
+// AttachDevices contains transport-agnostic parameters for hotplugging devices into a virtual machine.
+type AttachDevices struct {
+	Name          string
+	USBDevices    []USBDevice
+	SerialDevices []SerialDevice
+}
+
+// DetachDevices contains transport-agnostic parameters for unplugging devices from a virtual machine.
+type DetachDevices struct {
+	Name        string
+	USBDevices  []USBDevice
+	SerialPorts []int
+}
+
+// ChangeMedia contains transport-agnostic parameters for inserting or ejecting CD-ROM media.
+type ChangeMedia struct {
+	Name   string
+	Device string // empty selects the first extra CD-ROM drive
+	Path   string // empty ejects the media
 }
 
 // QueryVM contains transport-agnostic parameters for querying a virtual machine.
@@ -63,20 +283,165 @@ type DiskInfo struct {
 
 // VMInfo contains detailed information about a virtual machine.
 type VMInfo struct {
-	Name       string
-	UUID       string
-	State      string
-	VCPUCount  uint
-	MemoryMB   uint
-	Disks      []DiskInfo
-	AutoStart  bool
-	Persistent bool
-	Hostname   string
-	IPAddress  string
+	Name        string
+	Namespace   string // "" if the VM was not created in a namespace
+	UUID        string
+	State       string
+	VCPUCount   uint
+	MemoryMB    uint   // memory currently assigned, in MiB rounded down
+	MemoryBytes uint64 // memory currently assigned, exactly
+	Disks       []DiskInfo
+	AutoStart   bool
+	Persistent  bool
+	Hostname    string
+	IPAddress   string
+	Labels      map[string]string
+	Ownership   *Ownership // nil if homonculus did not define the domain
+	Host        string
+	Graphics    []GraphicsInfo
+	Readiness   *Readiness // nil if the VM has no readiness probes
+	Timeline    []ProvisioningPhase
+	Boot        *BootTime // nil if no start of the VM was measured
+}
+
+// BootTime is how long the last start of a VM took to reach the network and its guest agent.
+// Milestones that were not reached are zero.
+type BootTime struct {
+	StartedAt       time.Time
+	IPAcquired      time.Duration // until libvirt saw the first DHCP lease
+	GuestAgentReady time.Duration // until the guest agent answered
+	Pending         bool          // still being measured
+}
+
+// ProvisioningPhase is a phase a VM reached while it was provisioned, e.g. disk_created.
+type ProvisioningPhase struct {
+	Phase string
+	Time  time.Time
+}
+
+// VMArtifact is a named file of the provisioning artifacts of a VM.
+type VMArtifact struct {
+	Name string
+	Data []byte
+}
+
+// CloudInitDocuments are the redacted cloud-init documents recorded when a VM was created.
+type CloudInitDocuments struct {
+	VM         string
+	InstanceID string
+	RenderedAt time.Time
+	Documents  map[string]string // by file name, e.g. user-data
+}
+
+// GuestExec contains a shell command to run inside a VM through its guest agent.
+type GuestExec struct {
+	Name    string
+	Command string // run with /bin/sh -c
+	Stdin   []byte
+}
+
+// GuestExecResult contains the exit code and captured output of a command run through the guest agent.
+type GuestExecResult struct {
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+}
+
+// VMStatsSample is a reading of the cumulative resource counters of a VM.
+type VMStatsSample struct {
+	Time           time.Time
+	State          string
+	VCPUCount      uint
+	CPUTimeNs      uint64 // CPU time used since the VM started
+	MemoryKiB      uint64 // memory currently assigned to the guest
+	MemoryRSSKiB   uint64 // host memory resident for the VM, 0 if unknown
+	DiskReadBytes  uint64
+	DiskWriteBytes uint64
+	NetRxBytes     uint64
+	NetTxBytes     uint64
+}
+
+// VMStats is the resource usage of a VM over one interval of a stats stream.
+type VMStats struct {
+	Name            string
+	Time            time.Time
+	IntervalSeconds float64
+	State           string
+	CPUPercent      float64 // share of the VM's vCPUs in use, 0-100
+	MemoryKiB       uint64
+	MemoryRSSKiB    uint64
+	DiskReadBytes   uint64 // bytes read during the interval
+	DiskWriteBytes  uint64
+	NetRxBytes      uint64
+	NetTxBytes      uint64
+}
+
+// ReadinessProbe checks that the guest OS of a started VM is ready to be used.
+type ReadinessProbe struct {
+	Type    string        // tcp, ssh, http or cloudinit
+	Port    int           // tcp, ssh (default 22) and http (default 80)
+	URL     string        // http; a path such as /healthz, requested from the VM address
+	Timeout time.Duration // per attempt
+}
+
+// ProbeResult is the outcome of the last attempt of a readiness probe.
+type ProbeResult struct {
+	Type    string
+	Target  string
+	Ready   bool
+	Message string
+}
+
+// Readiness is the result of evaluating the readiness probes of a VM since it was last started.
+type Readiness struct {
+	Ready     bool
+	CheckedAt time.Time // zero until the probes were evaluated
+	Probes    []ProbeResult
+}
+
+// HostCapacity describes the resources of a hypervisor host and how much of them defined VMs claim.
+type HostCapacity struct {
+	Host               string
+	TotalMemoryKiB     uint64
+	FreeMemoryKiB      uint64
+	CPUs               uint
+	AllocatedMemoryKiB uint64
+	AllocatedVCPUs     uint
+	DefinedVMs         int
+	RunningVMs         int
+	LoadAverage        float64 // 1-minute load average, 0 if unknown
+	NUMANodes          []NUMANode
+	StoragePools       []StoragePoolCapacity
+}
+
+// NUMANode describes one NUMA cell of a hypervisor host.
+type NUMANode struct {
+	ID            int
+	MemoryKiB     uint64
+	FreeMemoryKiB uint64
+	CPUs          []HostCPU
+}
+
+// HostCPU describes one logical CPU of a hypervisor host.
+type HostCPU struct {
+	ID       int
+	SocketID int
+	CoreID   int
+	Siblings string
+}
+
+// StoragePoolCapacity describes the capacity of a libvirt storage pool.
+type StoragePoolCapacity struct {
+	Name            string
+	Active          bool
+	CapacityBytes   uint64
+	AllocationBytes uint64
+	AvailableBytes  uint64
 }
 
 // CloneVM contains transport-agnostic parameters for cloning virtual machines.
 type CloneVM struct {
+	Cluster     string
 	BaseVMName  string
 	TargetSpecs []TargetVMSpec
 }
@@ -89,6 +454,13 @@ type TargetVMSpec struct {
 	DiskPath      string
 	DiskSizeGB    int64
 	BaseImagePath string
+	Start         bool
+	Hostname      string
+	UserConfigs   []UserConfig
+	Runcmds       []string
+	Network       *NetworkConfig
+	// CloudInitISOPath is set from the ISO layout; otherwise the ISO is written next to the disk.
+	CloudInitISOPath string
 }
 
 // UserConfig represents a user account configuration for cloud-init.
@@ -97,3 +469,167 @@ type UserConfig struct {
 	SSHAuthorizedKeys []string
 	Password          string
 }
+
+// CreateSchedule contains transport-agnostic parameters for registering a recurring action.
+type CreateSchedule struct {
+	Cron     string
+	Timezone string
+	Action   string // start, stop or snapshot
+	Selector string
+	Retain   int // 0 keeps every snapshot
+}
+
+// Schedule is a recurring action on the VMs matching a label selector.
+type Schedule struct {
+	ID        string
+	Cron      string
+	Timezone  string
+	Action    string
+	Selector  string
+	Retain    int
+	CreatedAt time.Time
+	LastRun   time.Time // zero until the first run
+	LastError string
+	NextRun   time.Time // computed when read, zero if the expression never matches again
+}
+
+// Event is a recorded lifecycle event of a VM or of a background job.
+type Event struct {
+	ID      string
+	Time    time.Time
+	Type    string // e.g. vm.created, reconciler.drift, libvirt.stopped
+	VM      string
+	Host    string
+	Actor   string // the identity that requested the operation, empty for background jobs
+	Message string
+	Error   string
+	TraceID string // trace of the API request that recorded the event
+	JobID   string // API request that recorded the event, empty for background jobs
+}
+
+// Job is an API request whose operation runs on after the request returned, such as an
+// asynchronous cluster creation.
+type Job struct {
+	ID         string // the job ID of the request
+	Operation  string // e.g. create_cluster
+	State      string // running, succeeded or failed
+	Error      string
+	Actor      string
+	Namespace  string
+	CreatedAt  time.Time
+	FinishedAt time.Time // zero while running
+	VMs        []JobVM
+	Completed  int // VMs no longer pending
+}
+
+// JobVM is the progress of a job on one of its VMs.
+type JobVM struct {
+	Name  string
+	State string // pending, succeeded or failed
+	Error string
+}
+
+// TemplateVersion describes a loaded version of the libvirt and cloud-init templates.
+type TemplateVersion struct {
+	Name     string // blue or green
+	Active   bool
+	LoadedAt time.Time
+	Digests  map[string]string // sha256 of every template source, by template name
+}
+
+// TemplateDiff is the outcome of canary-rendering a template of a new VM with the candidate version.
+type TemplateDiff struct {
+	VM             string
+	Template       string
+	Time           time.Time
+	Identical      bool
+	Diff           string // unified diff from the active to the candidate rendering
+	CandidateError string
+}
+
+// TemplateRollout describes the loaded template versions and the canary renderings since
+// the candidate version was staged.
+type TemplateRollout struct {
+	Versions []TemplateVersion
+	Diffs    []TemplateDiff
+}
+
+// EventFilter selects recorded events. Zero fields match every event.
+type EventFilter struct {
+	VM      string
+	Type    string // a full type, or a source such as "libvirt" matching all of its types
+	TraceID string
+	JobID   string
+	Since   time.Time
+	Limit   int // newest events are kept when the limit cuts the result
+}
+
+// GenerateSSHKey contains transport-agnostic parameters for generating an SSH keypair.
+type GenerateSSHKey struct {
+	Name  string
+	Store bool
+}
+
+// SSHKey is a generated SSH keypair. PrivateKey is only set when the key is handed out.
+type SSHKey struct {
+	Name        string
+	PublicKey   string
+	Fingerprint string
+	Stored      bool
+	CreatedAt   time.Time
+	PrivateKey  string
+}
+
+// DomainDrift is a field in which a defined domain differs from the domain its spec renders to.
+type DomainDrift struct {
+	Field string
+	Spec  string
+	Live  string
+}
+
+// DomainDiff compares the domain a VM spec renders to with the domain currently defined.
+type DomainDiff struct {
+	VM          string
+	Host        string
+	Defined     bool
+	Drift       []DomainDrift
+	RenderedXML string
+}
+
+// HostMaintenance records a host in maintenance: it is left out of scheduling, and the
+// VMs migrated or shut down on entering are migrated back or started again on exit.
+type HostMaintenance struct {
+	Host      string
+	Mode      string
+	StartedAt time.Time
+	Migrated  map[string]string // destination host by VM name
+	Stopped   []string
+}
+
+// MaintenanceReport describes what entering or exiting maintenance did with the VMs of a host.
+type MaintenanceReport struct {
+	Host          string
+	Mode          string
+	InMaintenance bool
+	Migrated      []string
+	Stopped       []string
+	Started       []string
+	Failed        []string
+}
+
+// HostConnection reports whether the libvirt connection to a host worked at the last check.
+type HostConnection struct {
+	Host      string
+	Reachable bool
+	Error     string
+}
+
+// ConsoleTicket grants temporary access to the graphical console of a virtual machine.
+type ConsoleTicket struct {
+	VM        string
+	Type      string // vnc or spice
+	Host      string
+	Port      int
+	Password  string
+	ExpiresAt time.Time
+}
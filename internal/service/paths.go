@@ -0,0 +1,33 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/terabiome/homonculus/internal/service/parameters"
+)
+
+// checkPaths rejects VMs whose host files lie outside the allowed directories.
+func (s *VMService) checkPaths(vm parameters.CreateVM) error {
+	var tftpRoot string
+	if vm.Netboot != nil && vm.Netboot.Server != nil {
+		tftpRoot = vm.Netboot.Server.TFTPRoot
+	}
+
+	for field, path := range map[string]string{
+		"disk_path":           vm.DiskPath,
+		"base_image_path":     vm.BaseImagePath,
+		"cloud_init_iso_path": vm.CloudInitISOPath,
+		"nvram_path":          vm.NVRAMPath,
+		"netboot.tftp_root":   tftpRoot,
+	} {
+		if err := s.paths.Check(path); err != nil {
+			return fmt.Errorf("VM %s %s: %w", vm.Name, field, err)
+		}
+	}
+	for i, cdrom := range vm.CDROMs {
+		if err := s.paths.Check(cdrom.Path); err != nil {
+			return fmt.Errorf("VM %s cdroms[%d].path: %w", vm.Name, i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/internal/store"
+	"github.com/terabiome/homonculus/pkg/labels"
+)
+
+// ErrQuotaExceeded is returned when creating VMs would exceed a resource quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+type callerKey struct{}
+
+// WithCaller returns a copy of ctx carrying the name of the API caller, who owns the VMs it creates.
+func WithCaller(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, callerKey{}, name)
+}
+
+// callerFromContext returns the API caller stored in ctx, or "" for internal operations.
+func callerFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(callerKey{}).(string)
+	return name
+}
+
+// Quota caps the resources of the VMs owned by an identity, or of the VMs matching a selector.
+// Zero limits are unlimited.
+type Quota struct {
+	Identity    string
+	Selector    labels.Selector
+	MaxVMs      int
+	MaxVCPUs    int
+	MaxMemoryMB int64
+	MaxDiskGB   int64
+}
+
+func (q Quota) String() string {
+	if q.Identity != "" {
+		return "identity " + q.Identity
+	}
+	return "selector " + q.Selector.String()
+}
+
+// applies reports whether a VM counts against the quota.
+func (q Quota) applies(vm quotaVM) bool {
+	if q.Identity != "" {
+		return vm.owner == q.Identity
+	}
+	return q.Selector.Matches(vm.labels)
+}
+
+// quotaVM is the part of a VM quotas account for.
+type quotaVM struct {
+	name     string
+	owner    string
+	labels   map[string]string
+	vcpus    int
+	memoryMB int64
+	diskGB   int64
+}
+
+func quotaVMFromSpec(vm parameters.CreateVM) quotaVM {
+	return quotaVM{
+		name:     vm.Name,
+		owner:    vm.Owner,
+		labels:   vm.Labels,
+		vcpus:    vm.VCPUCount,
+		memoryMB: vm.MemoryMB,
+		diskGB:   vm.DiskSizeGB,
+	}
+}
+
+// reserveQuota checks that the VMs fit every quota applying to them, on top of the existing VMs
+// and the VMs of requests still in progress, and reserves their resources until release is called.
+// The existing VMs are listed afresh under quotaMu, so concurrent requests never both fit into
+// the same headroom.
+func (s *VMService) reserveQuota(ctx context.Context, vms []parameters.CreateVM) (release func(), err error) {
+	release = func() {}
+	if len(s.quotas) == 0 {
+		return release, nil
+	}
+
+	requested := make([]quotaVM, 0, len(vms))
+	for _, vm := range vms {
+		requested = append(requested, quotaVMFromSpec(vm))
+	}
+
+	var applicable []Quota
+	for _, quota := range s.quotas {
+		for _, vm := range requested {
+			if quota.applies(vm) {
+				applicable = append(applicable, quota)
+				break
+			}
+		}
+	}
+	if len(applicable) == 0 {
+		return release, nil
+	}
+
+	s.quotaMu.Lock()
+	defer s.quotaMu.Unlock()
+
+	vmInfos, err := s.listAllVirtualMachines(ctx)
+	if err != nil {
+		return release, fmt.Errorf("failed to list VMs for quota check: %w", err)
+	}
+	placements, err := store.List[Placement](s.store, bucketPlacements)
+	if err != nil {
+		return release, fmt.Errorf("failed to load VM owners for quota check: %w", err)
+	}
+	owners := make(map[string]string, len(placements))
+	for _, placement := range placements {
+		owners[placement.VM] = placement.Owner
+	}
+
+	// VMs being created may already be defined; the reservation counts them once.
+	pending := make(map[string]bool)
+	var existing []quotaVM
+	for _, reservation := range s.pendingVMs {
+		for _, vm := range reservation {
+			pending[vm.name] = true
+			existing = append(existing, vm)
+		}
+	}
+	for _, vmInfo := range vmInfos {
+		if pending[vmInfo.Name] {
+			continue
+		}
+		owner, ok := owners[vmInfo.Name]
+		if !ok && vmInfo.Ownership != nil {
+			owner = vmInfo.Ownership.Owner
+		}
+		existing = append(existing, quotaVM{
+			name:     vmInfo.Name,
+			owner:    owner,
+			labels:   vmInfo.Labels,
+			vcpus:    int(vmInfo.VCPUCount),
+			memoryMB: int64(vmInfo.MemoryMB),
+			diskGB:   diskSizeGB(vmInfo),
+		})
+	}
+
+	for _, quota := range applicable {
+		if err := checkQuota(quota, existing, requested); err != nil {
+			return release, err
+		}
+	}
+
+	// Reservations are kept per request, so requests naming the same VM do not release each other's.
+	s.nextReservation++
+	id := s.nextReservation
+	s.pendingVMs[id] = requested
+	return func() {
+		s.quotaMu.Lock()
+		defer s.quotaMu.Unlock()
+		delete(s.pendingVMs, id)
+	}, nil
+}
+
+// checkQuota sums the usage of the existing and requested VMs a quota applies to and compares it to the limits.
+func checkQuota(quota Quota, existing, requested []quotaVM) error {
+	var vmCount, vcpus int
+	var memoryMB, diskGB int64
+	for _, vm := range slices.Concat(existing, requested) {
+		if !quota.applies(vm) {
+			continue
+		}
+		vmCount++
+		vcpus += vm.vcpus
+		memoryMB += vm.memoryMB
+		diskGB += vm.diskGB
+	}
+
+	for _, limit := range []struct {
+		resource  string
+		used, max int64
+	}{
+		{"VMs", int64(vmCount), int64(quota.MaxVMs)},
+		{"vCPUs", int64(vcpus), int64(quota.MaxVCPUs)},
+		{"memory MB", memoryMB, quota.MaxMemoryMB},
+		{"disk GB", diskGB, quota.MaxDiskGB},
+	} {
+		if limit.max > 0 && limit.used > limit.max {
+			return fmt.Errorf("%w for %s: the request would bring usage to %d %s, the limit is %d", ErrQuotaExceeded, quota, limit.used, limit.resource, limit.max)
+		}
+	}
+	return nil
+}
+
+// diskSizeGB returns the total virtual size of the disks of a VM, excluding CD-ROMs.
+func diskSizeGB(vmInfo parameters.VMInfo) int64 {
+	var total int64
+	for _, disk := range vmInfo.Disks {
+		if disk.Device == "disk" {
+			total += disk.SizeGB
+		}
+	}
+	return total
+}
@@ -0,0 +1,317 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/service/infrastructure/libvirt"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/internal/store"
+	"github.com/terabiome/homonculus/pkg/probe"
+)
+
+// bucketReadiness holds the readiness probes of VMs and the result of their last evaluation.
+const bucketReadiness = "readiness"
+
+const (
+	ProbeTCP       = "tcp"
+	ProbeSSH       = "ssh"
+	ProbeHTTP      = "http"
+	ProbeCloudInit = "cloudinit"
+)
+
+const (
+	// defaultProbeTimeout bounds a probe attempt that sets no timeout.
+	defaultProbeTimeout = 5 * time.Second
+	// readinessPollInterval is how often WaitForReady evaluates VMs that are not ready yet.
+	readinessPollInterval = 5 * time.Second
+)
+
+// ErrNotReady is returned when VMs do not become ready in time.
+var ErrNotReady = errors.New("virtual machines not ready")
+
+// readinessRecord is the persisted form of the readiness probes of a VM.
+type readinessRecord struct {
+	VM     string
+	Probes []parameters.ReadinessProbe
+	Status parameters.Readiness
+}
+
+// recordReadinessProbes persists the readiness probes of a created VM, replacing an earlier result.
+func (s *VMService) recordReadinessProbes(vm parameters.CreateVM) {
+	if len(vm.ReadinessProbes) == 0 {
+		return
+	}
+
+	s.readinessMu.Lock()
+	defer s.readinessMu.Unlock()
+
+	record := readinessRecord{VM: vm.Name, Probes: vm.ReadinessProbes}
+	if err := s.store.Put(bucketReadiness, vm.Name, record); err != nil {
+		s.logger.Warn("failed to record readiness probes", slog.String("vm", vm.Name), slog.String("error", err.Error()))
+	}
+}
+
+// forgetReadiness removes the readiness probes of a deleted VM.
+func (s *VMService) forgetReadiness(name string) {
+	s.readinessMu.Lock()
+	defer s.readinessMu.Unlock()
+
+	if err := s.store.Delete(bucketReadiness, name); err != nil {
+		s.logger.Warn("failed to remove readiness probes", slog.String("vm", name), slog.String("error", err.Error()))
+	}
+}
+
+// resetReadiness clears the result of the probes of a VM that was started or stopped,
+// so they are evaluated again for the new boot.
+func (s *VMService) resetReadiness(name string) {
+	s.saveReadiness(name, parameters.Readiness{})
+}
+
+// saveReadiness stores the result of evaluating the probes of a VM, if it has any.
+func (s *VMService) saveReadiness(name string, status parameters.Readiness) {
+	s.readinessMu.Lock()
+	defer s.readinessMu.Unlock()
+
+	var record readinessRecord
+	found, err := s.store.Get(bucketReadiness, name, &record)
+	if err != nil || !found {
+		return
+	}
+	record.Status = status
+	if err := s.store.Put(bucketReadiness, name, record); err != nil {
+		s.logger.Warn("failed to save readiness", slog.String("vm", name), slog.String("error", err.Error()))
+	}
+}
+
+// loadReadiness returns the readiness records of all VMs by name.
+func (s *VMService) loadReadiness() (map[string]readinessRecord, error) {
+	records, err := store.List[readinessRecord](s.store, bucketReadiness)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load readiness probes: %w", err)
+	}
+
+	byName := make(map[string]readinessRecord, len(records))
+	for _, record := range records {
+		byName[record.VM] = record
+	}
+	return byName, nil
+}
+
+// attachReadiness sets the last readiness result on the VMs that have readiness probes.
+func (s *VMService) attachReadiness(vmInfos []parameters.VMInfo) {
+	records, err := s.loadReadiness()
+	if err != nil {
+		s.logger.Warn("failed to attach readiness", slog.String("error", err.Error()))
+		return
+	}
+
+	for i := range vmInfos {
+		if record, ok := records[vmInfos[i].Name]; ok {
+			status := record.Status
+			vmInfos[i].Readiness = &status
+		}
+	}
+}
+
+// updateReadiness evaluates the probes of a VM unless it already became ready since it was started.
+// Results of a VM that is no longer running are cleared. It returns whether the VM is ready.
+func (s *VMService) updateReadiness(ctx context.Context, vmInfo parameters.VMInfo, record readinessRecord) bool {
+	if vmInfo.State != "running" {
+		if !record.Status.CheckedAt.IsZero() {
+			s.resetReadiness(vmInfo.Name)
+		}
+		return false
+	}
+	if record.Status.Ready {
+		return true
+	}
+
+	status := s.evaluateReadiness(ctx, vmInfo, record.Probes)
+	if ctx.Err() != nil {
+		return false
+	}
+	s.saveReadiness(vmInfo.Name, status)
+
+	if status.Ready {
+		s.logger.Info("VM is ready", slog.String("vm", vmInfo.Name))
+		s.recordEvent(ctx, EventVMReady, vmInfo.Name, vmInfo.Host, "readiness probes succeeded", nil)
+	}
+	return status.Ready
+}
+
+// evaluateReadiness runs every probe of a running VM once.
+func (s *VMService) evaluateReadiness(ctx context.Context, vmInfo parameters.VMInfo, probes []parameters.ReadinessProbe) parameters.Readiness {
+	status := parameters.Readiness{
+		Ready:     true,
+		CheckedAt: time.Now().UTC(),
+		Probes:    make([]parameters.ProbeResult, 0, len(probes)),
+	}
+	for _, readinessProbe := range probes {
+		result := s.runProbe(ctx, vmInfo, readinessProbe)
+		status.Ready = status.Ready && result.Ready
+		status.Probes = append(status.Probes, result)
+	}
+	return status
+}
+
+// runProbe makes a single attempt of a readiness probe.
+func (s *VMService) runProbe(ctx context.Context, vmInfo parameters.VMInfo, readinessProbe parameters.ReadinessProbe) parameters.ProbeResult {
+	result := parameters.ProbeResult{Type: readinessProbe.Type}
+
+	if readinessProbe.Type == ProbeCloudInit {
+		result.Target = "guest-agent"
+		status := s.probeCloudInit(ctx, vmInfo)
+		switch status {
+		case "":
+			result.Ready = true
+			result.Message = "VM has no cloud-init ISO"
+		case libvirt.CloudInitDone:
+			result.Ready = true
+			result.Message = "cloud-init " + status
+		default:
+			result.Message = "cloud-init " + status
+		}
+		return result
+	}
+
+	target, err := probeTarget(vmInfo.IPAddress, readinessProbe)
+	result.Target = target
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+
+	timeout := readinessProbe.Timeout
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch readinessProbe.Type {
+	case ProbeTCP:
+		err = probe.TCP(probeCtx, target)
+		result.Message = "connected"
+	case ProbeSSH:
+		result.Message, err = probe.SSHBanner(probeCtx, target)
+	case ProbeHTTP:
+		var statusCode int
+		statusCode, err = probe.HTTP(probeCtx, target)
+		result.Message = "status " + strconv.Itoa(statusCode)
+	default:
+		err = fmt.Errorf("unknown probe type %q", readinessProbe.Type)
+	}
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.Ready = true
+	return result
+}
+
+// probeTarget returns the address or URL a network probe checks on a VM. HTTP probes take a
+// path, which is requested from the address of the VM and never from another host.
+func probeTarget(ipAddress string, readinessProbe parameters.ReadinessProbe) (string, error) {
+	var path *url.URL
+	if readinessProbe.Type == ProbeHTTP {
+		var err error
+		path, err = url.Parse(readinessProbe.URL)
+		if err != nil || !strings.HasPrefix(readinessProbe.URL, "/") || path.Scheme != "" || path.Host != "" {
+			return "", fmt.Errorf("http probe requires a path on the VM, got %q", readinessProbe.URL)
+		}
+	}
+	if ipAddress == "" {
+		return "", fmt.Errorf("VM has no IP address yet")
+	}
+
+	port := readinessProbe.Port
+	switch {
+	case port > 0:
+	case readinessProbe.Type == ProbeHTTP:
+		port = 80
+	default:
+		port = 22
+	}
+	address := net.JoinHostPort(ipAddress, strconv.Itoa(port))
+
+	if readinessProbe.Type == ProbeHTTP {
+		target := url.URL{Scheme: "http", Host: address, Path: path.Path, RawPath: path.RawPath, RawQuery: path.RawQuery}
+		return target.String(), nil
+	}
+	return address, nil
+}
+
+// checkReadiness evaluates the readiness probes of running VMs that are not ready yet,
+// and clears the results of VMs that stopped.
+func (s *VMService) checkReadiness(ctx context.Context, vmInfos []parameters.VMInfo) error {
+	records, err := s.loadReadiness()
+	if err != nil {
+		return err
+	}
+
+	for _, vmInfo := range vmInfos {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if record, ok := records[vmInfo.Name]; ok {
+			s.updateReadiness(ctx, vmInfo, record)
+		}
+	}
+	return nil
+}
+
+// WaitForReady polls the named VMs until every one is running and passes its readiness probes,
+// or returns ErrNotReady once timeout elapsed. VMs without probes are ready once running.
+func (s *VMService) WaitForReady(ctx context.Context, names []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pending := qualifyAll(ctx, names)
+	for {
+		records, err := s.loadReadiness()
+		if err != nil {
+			return err
+		}
+
+		var notReady []string
+		for _, name := range pending {
+			vmInfo, found, err := s.GetVirtualMachine(ctx, name)
+			if ctx.Err() != nil {
+				break
+			}
+			if err != nil {
+				s.logger.Debug("failed to query VM for readiness", slog.String("vm", name), slog.String("error", err.Error()))
+			}
+
+			ready := found && vmInfo.State == "running"
+			if record, ok := records[name]; ok && found {
+				ready = s.updateReadiness(ctx, vmInfo, record)
+			}
+			if !ready {
+				notReady = append(notReady, name)
+			}
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w after %s: %v", ErrNotReady, timeout, pending)
+		}
+		if len(notReady) == 0 {
+			s.logger.Info("VMs are ready", slog.Any("vms", names))
+			return nil
+		}
+		pending = notReady
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w after %s: %v", ErrNotReady, timeout, pending)
+		case <-time.After(readinessPollInterval):
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// ReconcileReport summarizes the actions taken by a single reconciliation pass.
+type ReconcileReport struct {
+	Recreated []string
+	Restarted []string
+	Failed    []string
+}
+
+// Reconcile converges every stored cluster spec with libvirt: missing VMs are recreated
+// and stopped VMs marked keep_running are started again. Hosts in maintenance are skipped.
+func (s *VMService) Reconcile(ctx context.Context) (ReconcileReport, error) {
+	var report ReconcileReport
+
+	clusters, err := s.ListClusterSpecs()
+	if err != nil {
+		return report, fmt.Errorf("failed to load cluster specs: %w", err)
+	}
+
+	for _, cluster := range clusters {
+		for _, vm := range cluster.VirtualMachines {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+
+			if vm.Host == "" {
+				vm.Host = s.locateVirtualMachine(ctx, vm.Name)
+			}
+			// VMs shut down for maintenance stay down until the host exits it.
+			if s.inMaintenance(vm.Host) {
+				continue
+			}
+
+			err := s.withHypervisor(ctx, vm.Host, func(hypervisor dependencies.HypervisorContext) error {
+				defer s.vmInfos.invalidate()
+				return s.reconcileVirtualMachine(ctx, hypervisor, cluster.Name, vm, &report)
+			})
+			if err != nil {
+				s.logger.Error("failed to reconcile VM",
+					slog.String("cluster", cluster.Name),
+					slog.String("vm", vm.Name),
+					slog.String("error", err.Error()),
+				)
+				report.Failed = append(report.Failed, vm.Name)
+			}
+		}
+	}
+
+	if len(report.Failed) > 0 {
+		return report, fmt.Errorf("failed to reconcile %d VM(s): %v", len(report.Failed), report.Failed)
+	}
+	return report, nil
+}
+
+// reconcileVirtualMachine recreates a missing VM and restarts a stopped keep_running VM.
+func (s *VMService) reconcileVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, clusterName string, vm parameters.CreateVM, report *ReconcileReport) error {
+	exists, err := s.libvirtManager.CheckVirtualMachineExistence(hypervisor, vm.Name)
+	if err != nil {
+		return fmt.Errorf("failed to check VM: %w", err)
+	}
+
+	if !exists {
+		s.recordDrift(ctx, clusterName, vm.Name, "missing")
+		if err := s.createVirtualMachine(ctx, hypervisor, vm); err != nil {
+			return fmt.Errorf("failed to recreate VM: %w", err)
+		}
+		s.recordPlacement(clusterName, vm)
+		s.recordEvent(ctx, EventReconcilerRepaired, vm.Name, hypervisor.Host, "recreated missing virtual machine", nil)
+		report.Recreated = append(report.Recreated, vm.Name)
+	}
+
+	if !vm.KeepRunning {
+		return nil
+	}
+
+	vmInfo, err := s.libvirtManager.GetVirtualMachineInfo(ctx, hypervisor, parameters.QueryVM{Name: vm.Name})
+	if err != nil {
+		return fmt.Errorf("failed to query VM: %w", err)
+	}
+
+	if vmInfo.State != "shutoff" && vmInfo.State != "crashed" {
+		return nil
+	}
+
+	if exists {
+		s.recordDrift(ctx, clusterName, vm.Name, vmInfo.State)
+	}
+	if err := s.libvirtManager.StartVirtualMachine(ctx, hypervisor, parameters.StartVM{Name: vm.Name}); err != nil {
+		return fmt.Errorf("failed to start VM: %w", err)
+	}
+	s.recordEvent(ctx, EventReconcilerRepaired, vm.Name, hypervisor.Host, "restarted virtual machine marked keep_running", nil)
+	report.Restarted = append(report.Restarted, vm.Name)
+	return nil
+}
+
+// recordDrift reports a divergence between the stored spec and libvirt.
+func (s *VMService) recordDrift(ctx context.Context, clusterName, vmName, drift string) {
+	s.logger.Warn("detected drift",
+		slog.String("cluster", clusterName),
+		slog.String("vm", vmName),
+		slog.String("drift", drift),
+	)
+	s.recordEvent(ctx, EventReconcilerDrift, vmName, "", "detected drift in cluster "+clusterName+": "+drift, nil)
+	if s.reconcileDriftCounter != nil {
+		s.reconcileDriftCounter.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("cluster", clusterName),
+			attribute.String("vm.name", vmName),
+			attribute.String("drift", drift),
+		))
+	}
+}
+
+// Reconciler periodically runs VMService.Reconcile in the background.
+type Reconciler struct {
+	vmService *VMService
+	interval  time.Duration
+	logger    *slog.Logger
+}
+
+// NewReconciler creates a new Reconciler.
+func NewReconciler(vmService *VMService, interval time.Duration, logger *slog.Logger) *Reconciler {
+	return &Reconciler{
+		vmService: vmService,
+		interval:  interval,
+		logger:    logger.With(slog.String("component", "reconciler")),
+	}
+}
+
+// Run reconciles immediately and then on every interval until ctx is cancelled.
+func (r *Reconciler) Run(ctx context.Context) {
+	r.logger.Info("reconciler started", slog.Duration("interval", r.interval))
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := r.vmService.Reconcile(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.logger.Error("reconciliation failed", slog.String("error", err.Error()))
+		}
+		if len(report.Recreated) > 0 || len(report.Restarted) > 0 {
+			r.logger.Info("reconciliation applied changes",
+				slog.Any("recreated", report.Recreated),
+				slog.Any("restarted", report.Restarted),
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			r.logger.Info("reconciler stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/internal/store"
+)
+
+// bucketOperations journals VM creations in progress, so creations interrupted by a crash
+// can be resumed or rolled back at the next startup.
+const bucketOperations = "operations"
+
+// Creation steps recorded in the journal, in the order they run.
+const (
+	stepDisk   = "disk"
+	stepISO    = "cloud-init-iso"
+	stepDomain = "domain"
+	stepStart  = "start"
+)
+
+// Operation is the journal entry of a VM creation in progress. Step is the step that was
+// started last; every earlier step completed.
+type Operation struct {
+	VM            string    `json:"vm"`
+	Host          string    `json:"host"`
+	DiskPath      string    `json:"disk_path"`
+	ISOPath       string    `json:"iso_path,omitempty"`
+	Container     bool      `json:"container,omitempty"`
+	Start         bool      `json:"start,omitempty"`
+	KeepArtifacts bool      `json:"keep_artifacts,omitempty"`
+	Step          string    `json:"step"`
+	StartedAt     time.Time `json:"started_at"`
+}
+
+// reached reports whether the operation got as far as starting step.
+func (o Operation) reached(step string) bool {
+	order := []string{stepDisk, stepISO, stepDomain, stepStart}
+	for _, s := range order {
+		if s == step {
+			return true
+		}
+		if s == o.Step {
+			return false
+		}
+	}
+	return false
+}
+
+// RecoveryReport summarizes the interrupted operations handled at startup.
+type RecoveryReport struct {
+	Resumed    []string
+	RolledBack []string
+	Failed     []string
+}
+
+// journalStep records that the creation of vm on host is starting step.
+func (s *VMService) journalStep(hypervisor dependencies.HypervisorContext, vm parameters.CreateVM, startedAt time.Time, step string) {
+	operation := Operation{
+		VM:            vm.Name,
+		Host:          hypervisor.Host,
+		DiskPath:      vm.DiskPath,
+		ISOPath:       vm.CloudInitISOPath,
+		Container:     isContainer(vm),
+		Start:         vm.Start,
+		KeepArtifacts: vm.KeepArtifactsOnFailure,
+		Step:          step,
+		StartedAt:     startedAt,
+	}
+	if err := s.store.Put(bucketOperations, vm.Name, operation); err != nil {
+		s.logger.Warn("failed to journal VM creation", slog.String("vm", vm.Name), slog.String("step", step), slog.String("error", err.Error()))
+	}
+}
+
+// finishJournal removes the journal entry of a creation that completed or was rolled back.
+func (s *VMService) finishJournal(name string) {
+	if err := s.store.Delete(bucketOperations, name); err != nil {
+		s.logger.Warn("failed to clear VM creation journal", slog.String("vm", name), slog.String("error", err.Error()))
+	}
+}
+
+// RecoverOperations handles VM creations left unfinished by a crash. Creations whose domain
+// was defined are resumed, starting the VM if requested; the others are rolled back by
+// removing the disk and cloud-init ISO they created.
+func (s *VMService) RecoverOperations(ctx context.Context) (RecoveryReport, error) {
+	var report RecoveryReport
+
+	operations, err := store.List[Operation](s.store, bucketOperations)
+	if err != nil {
+		return report, fmt.Errorf("failed to load interrupted operations: %w", err)
+	}
+
+	for _, operation := range operations {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		s.logger.Info("recovering interrupted VM creation",
+			slog.String("vm", operation.VM),
+			slog.String("host", operation.Host),
+			slog.String("step", operation.Step),
+			slog.Time("started_at", operation.StartedAt),
+		)
+
+		var resumed bool
+		err := s.withHypervisor(ctx, operation.Host, func(hypervisor dependencies.HypervisorContext) error {
+			defer s.vmInfos.invalidate()
+			var err error
+			resumed, err = s.recoverOperation(ctx, hypervisor, operation)
+			return err
+		})
+		if err != nil {
+			s.logger.Error("failed to recover interrupted VM creation",
+				slog.String("vm", operation.VM),
+				slog.String("error", err.Error()),
+			)
+			report.Failed = append(report.Failed, operation.VM)
+			continue
+		}
+
+		s.finishJournal(operation.VM)
+		if resumed {
+			report.Resumed = append(report.Resumed, operation.VM)
+		} else {
+			report.RolledBack = append(report.RolledBack, operation.VM)
+		}
+	}
+
+	if len(report.Failed) > 0 {
+		return report, fmt.Errorf("failed to recover %d operation(s): %v", len(report.Failed), report.Failed)
+	}
+	return report, nil
+}
+
+// recoverOperation resumes or rolls back one interrupted creation and reports whether it was resumed.
+func (s *VMService) recoverOperation(ctx context.Context, hypervisor dependencies.HypervisorContext, operation Operation) (bool, error) {
+	exists, err := s.libvirtManager.CheckVirtualMachineExistence(hypervisor, operation.VM)
+	if err != nil {
+		return false, err
+	}
+
+	if exists {
+		if operation.Start {
+			info, err := s.libvirtManager.GetVirtualMachineInfo(ctx, hypervisor, parameters.QueryVM{Name: operation.VM})
+			if err != nil {
+				return false, err
+			}
+			if info.State != "running" {
+				if err := s.libvirtManager.StartVirtualMachine(ctx, hypervisor, parameters.StartVM{Name: operation.VM}); err != nil {
+					return false, err
+				}
+			}
+		}
+		return true, nil
+	}
+
+	// The domain was never defined, so the disk and ISO belong to no VM.
+	undo := newRollback(operation.VM)
+	if operation.DiskPath != "" && operation.reached(stepDisk) {
+		undo.push("disk "+operation.DiskPath, s.removeOrphanedDiskStep(hypervisor, operation.VM, operation.DiskPath, operation.Container))
+	}
+	if operation.ISOPath != "" && operation.reached(stepISO) {
+		undo.push("cloud-init ISO "+operation.ISOPath, s.removeFileStep(hypervisor, operation.ISOPath))
+	}
+	s.rollBack(ctx, parameters.CreateVM{Name: operation.VM, KeepArtifactsOnFailure: operation.KeepArtifacts}, undo)
+	return false, nil
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+)
+
+// ErrClusterNotFound is returned when a named cluster has no stored spec.
+var ErrClusterNotFound = errors.New("cluster not found")
+
+// ResetCluster reverts every VM of a named cluster to a snapshot of the same name and leaves it running,
+// so a test environment returns to its golden state between runs. VMs are reverted in spec order;
+// a VM that fails does not stop the others.
+func (s *VMService) ResetCluster(ctx context.Context, clusterName, snapshotName string) error {
+	clusterName = qualify(ctx, clusterName)
+	cluster, found, err := s.GetClusterSpec(clusterName)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: %s", ErrClusterNotFound, clusterName)
+	}
+
+	var failedVMs []string
+	var failures []error
+
+	for i, vm := range cluster.VirtualMachines {
+		if err := ctx.Err(); err != nil {
+			return cancelledError("reset", i, len(cluster.VirtualMachines), failedVMs, err)
+		}
+
+		s.logger.Info("resetting VM", slog.String("vm", vm.Name), slog.String("snapshot", snapshotName))
+
+		var host string
+		err := s.withVirtualMachineHypervisor(ctx, vm.Name, func(hypervisor dependencies.HypervisorContext) error {
+			defer s.vmInfos.invalidate()
+			host = hypervisor.Host
+			return s.libvirtManager.RevertSnapshot(hypervisor, vm.Name, snapshotName)
+		})
+		if err != nil {
+			s.recordEvent(ctx, EventVMResetFailed, vm.Name, host, "failed to reset virtual machine to snapshot "+snapshotName, err)
+			s.logger.Error("failed to reset VM",
+				slog.String("vm", vm.Name),
+				slog.String("snapshot", snapshotName),
+				slog.String("error", err.Error()),
+			)
+			failedVMs = append(failedVMs, vm.Name)
+			failures = append(failures, err)
+			continue
+		}
+
+		s.resetReadiness(vm.Name)
+		s.recordEvent(ctx, EventVMReset, vm.Name, host, "reset virtual machine to snapshot "+snapshotName, nil)
+	}
+
+	if len(failedVMs) > 0 {
+		return &batchError{action: "reset", vms: failedVMs, errs: failures}
+	}
+
+	s.logger.Info("reset cluster",
+		slog.String("cluster", clusterName),
+		slog.String("snapshot", snapshotName),
+		slog.Int("vms", len(cluster.VirtualMachines)),
+	)
+	return nil
+}
+
+// SnapshotCluster takes a snapshot of the given name of every VM of a named cluster, so it can
+// later be reset to it, for instance alongside a K3s etcd snapshot. VMs are snapshotted in spec
+// order; a VM that fails does not stop the others.
+func (s *VMService) SnapshotCluster(ctx context.Context, clusterName, snapshotName, description string) error {
+	clusterName = qualify(ctx, clusterName)
+	cluster, found, err := s.GetClusterSpec(clusterName)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: %s", ErrClusterNotFound, clusterName)
+	}
+
+	var failedVMs []string
+	var failures []error
+
+	for i, vm := range cluster.VirtualMachines {
+		if err := ctx.Err(); err != nil {
+			return cancelledError("snapshot", i, len(cluster.VirtualMachines), failedVMs, err)
+		}
+
+		s.logger.Info("snapshotting VM", slog.String("vm", vm.Name), slog.String("snapshot", snapshotName))
+
+		var host string
+		err := s.withVirtualMachineHypervisor(ctx, vm.Name, func(hypervisor dependencies.HypervisorContext) error {
+			host = hypervisor.Host
+			return s.libvirtManager.CreateSnapshot(ctx, hypervisor, vm.Name, snapshotName, description)
+		})
+		if err != nil {
+			s.recordEvent(ctx, EventVMSnapshotFailed, vm.Name, host, "failed to take snapshot "+snapshotName, err)
+			s.logger.Error("failed to snapshot VM",
+				slog.String("vm", vm.Name),
+				slog.String("snapshot", snapshotName),
+				slog.String("error", err.Error()),
+			)
+			failedVMs = append(failedVMs, vm.Name)
+			failures = append(failures, err)
+			continue
+		}
+
+		s.recordEvent(ctx, EventVMSnapshotted, vm.Name, host, "took snapshot "+snapshotName, nil)
+	}
+
+	if len(failedVMs) > 0 {
+		return &batchError{action: "snapshot", vms: failedVMs, errs: failures}
+	}
+	return nil
+}
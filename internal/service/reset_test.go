@@ -0,0 +1,88 @@
+package service_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/service/infrastructure/fake"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+)
+
+func TestDeleteSnapshottedVM(t *testing.T) {
+	ctx := context.Background()
+	hypervisor := fake.NewHypervisor(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s := newTestService(t, hypervisor, hypervisor)
+
+	cluster := parameters.CreateCluster{Name: "lab", VirtualMachines: []parameters.CreateVM{testVM(t, "lab-1")}}
+	if err := s.CreateCluster(ctx, cluster); err != nil {
+		t.Fatalf("CreateCluster: %v", err)
+	}
+	if err := s.SnapshotCluster(ctx, "lab", "golden", ""); err != nil {
+		t.Fatalf("SnapshotCluster: %v", err)
+	}
+
+	if err := s.DeleteCluster(ctx, []parameters.DeleteVM{{Name: "lab-1"}}); err != nil {
+		t.Fatalf("DeleteCluster of a VM with a snapshot: %v", err)
+	}
+	if _, found, err := s.GetVirtualMachine(ctx, "lab-1"); err != nil || found {
+		t.Errorf("GetVirtualMachine(lab-1) after delete = found %v, error %v; want not found", found, err)
+	}
+
+	// The snapshot went with the VM, so a VM recreated under its name has none.
+	if err := s.CreateCluster(ctx, cluster); err != nil {
+		t.Fatalf("CreateCluster again: %v", err)
+	}
+	if err := s.ResetCluster(ctx, "lab", "golden"); err == nil {
+		t.Error("ResetCluster of the recreated VM to the snapshot of the deleted one succeeded")
+	}
+}
+
+func TestResetThenDeleteCluster(t *testing.T) {
+	ctx := context.Background()
+	hypervisor := fake.NewHypervisor(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s := newTestService(t, hypervisor, hypervisor)
+
+	cluster := parameters.CreateCluster{
+		Name:            "ci",
+		VirtualMachines: []parameters.CreateVM{testVM(t, "ci-1"), testVM(t, "ci-2")},
+	}
+	if err := s.CreateCluster(ctx, cluster); err != nil {
+		t.Fatalf("CreateCluster: %v", err)
+	}
+	if err := s.SnapshotCluster(ctx, "ci", "golden", "before the test run"); err != nil {
+		t.Fatalf("SnapshotCluster: %v", err)
+	}
+	if err := s.StopCluster(ctx, []parameters.StopVM{{Name: "ci-1"}, {Name: "ci-2"}}, time.Minute); err != nil {
+		t.Fatalf("StopCluster: %v", err)
+	}
+
+	if err := s.ResetCluster(ctx, "ci", "golden"); err != nil {
+		t.Fatalf("ResetCluster: %v", err)
+	}
+	vmInfos, err := s.QueryCluster(ctx, []parameters.QueryVM{{Name: "ci-1"}, {Name: "ci-2"}})
+	if err != nil {
+		t.Fatalf("QueryCluster: %v", err)
+	}
+	for _, vmInfo := range vmInfos {
+		if vmInfo.State != "running" {
+			t.Errorf("%s is %s after the reset, want running", vmInfo.Name, vmInfo.State)
+		}
+	}
+	if err := s.ResetCluster(ctx, "ci", "missing"); err == nil {
+		t.Error("ResetCluster to a missing snapshot succeeded")
+	}
+
+	if err := s.DeleteCluster(ctx, []parameters.DeleteVM{{Name: "ci-1"}, {Name: "ci-2"}}); err != nil {
+		t.Fatalf("DeleteCluster after the reset: %v", err)
+	}
+	vmInfos, err = s.QueryCluster(ctx, nil)
+	if err != nil {
+		t.Fatalf("QueryCluster(all): %v", err)
+	}
+	if len(vmInfos) != 0 {
+		t.Errorf("QueryCluster(all) after delete listed %d VMs, want none", len(vmInfos))
+	}
+}
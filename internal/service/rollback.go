@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/executor/fileops"
+)
+
+// RollbackTimeout bounds the undo steps of a failed or aborted VM creation.
+const RollbackTimeout = 2 * time.Minute
+
+// rollbackStep undoes one completed step of a VM creation.
+type rollbackStep struct {
+	description string
+	undo        func(ctx context.Context) error
+}
+
+// rollback is the stack of undo steps of one VM creation, run in reverse order.
+type rollback struct {
+	vmName string
+	steps  []rollbackStep
+}
+
+func newRollback(vmName string) *rollback {
+	return &rollback{vmName: vmName}
+}
+
+// push records the undo step of a completed creation step.
+func (r *rollback) push(description string, undo func(ctx context.Context) error) {
+	r.steps = append(r.steps, rollbackStep{description: description, undo: undo})
+}
+
+// removeFileStep returns an undo step deleting path on the hypervisor.
+func (s *VMService) removeFileStep(hypervisor dependencies.HypervisorContext, path string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return fileops.RemoveFile(ctx, hypervisor.Executor, path)
+	}
+}
+
+// removeOrphanedDiskStep returns an undo step deleting the disk of a VM whose domain is not
+// defined through the same guards as VM deletion, keeping disks other VMs use and disks
+// outside the allowed paths.
+func (s *VMService) removeOrphanedDiskStep(hypervisor dependencies.HypervisorContext, vmName, path string, container bool) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return s.libvirtManager.RemoveDisk(ctx, hypervisor, vmName, path, container)
+	}
+}
+
+// removeDiskStep returns an undo step deleting the disk of a VM, or the root filesystem
+// directory of a container, on the hypervisor.
+func (s *VMService) removeDiskStep(hypervisor dependencies.HypervisorContext, path string, container bool) func(ctx context.Context) error {
+	if !container {
+		return s.removeFileStep(hypervisor, path)
+	}
+	return func(ctx context.Context) error {
+		return fileops.RemoveDirectory(ctx, hypervisor.Executor, path)
+	}
+}
+
+// rollBack undoes the completed steps of a failed VM creation, newest first. Undo runs
+// detached from ctx's cancellation, so aborted operations still clean up after themselves.
+// VMs with KeepArtifactsOnFailure only log what was left behind. It reports whether every
+// step was undone.
+func (s *VMService) rollBack(ctx context.Context, vm parameters.CreateVM, r *rollback) bool {
+	if len(r.steps) == 0 {
+		return true
+	}
+
+	if vm.KeepArtifactsOnFailure {
+		for _, step := range r.steps {
+			s.logger.Warn("keeping artifact of failed VM",
+				slog.String("vm", r.vmName),
+				slog.String("artifact", step.description),
+			)
+		}
+		return false
+	}
+
+	undoCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), RollbackTimeout)
+	defer cancel()
+
+	complete := true
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		step := r.steps[i]
+		if err := step.undo(undoCtx); err != nil {
+			complete = false
+			s.logger.Warn("failed to roll back",
+				slog.String("vm", r.vmName),
+				slog.String("artifact", step.description),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		s.logger.Info("rolled back", slog.String("vm", r.vmName), slog.String("artifact", step.description))
+	}
+	return complete
+}
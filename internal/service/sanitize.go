@@ -0,0 +1,76 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/sanitize"
+)
+
+// sanitizeVirtualMachine validates the name and hostname of a VM and normalizes its paths,
+// before they end up in domain XML, cloud-init documents and hypervisor commands.
+func sanitizeVirtualMachine(vm *parameters.CreateVM) error {
+	if err := sanitize.ValidateName(vm.Name); err != nil {
+		return fmt.Errorf("%w: VM name: %w", ErrInvalidCluster, err)
+	}
+	if vm.Hostname != "" {
+		if err := sanitize.ValidateHostname(vm.Hostname); err != nil {
+			return fmt.Errorf("%w: VM %s: %w", ErrInvalidCluster, vm.Name, err)
+		}
+	}
+
+	paths := map[string]*string{
+		"disk_path":           &vm.DiskPath,
+		"base_image_path":     &vm.BaseImagePath,
+		"cloud_init_iso_path": &vm.CloudInitISOPath,
+		"nvram_path":          &vm.NVRAMPath,
+	}
+	if vm.Netboot != nil && vm.Netboot.Server != nil {
+		server := *vm.Netboot.Server
+		vm.Netboot = &parameters.Netboot{Server: &server}
+		paths["netboot.tftp_root"] = &server.TFTPRoot
+	}
+	vm.CDROMs = slices.Clone(vm.CDROMs)
+	for i := range vm.CDROMs {
+		paths[fmt.Sprintf("cdroms[%d].path", i)] = &vm.CDROMs[i].Path
+	}
+	vm.HostBindMounts = slices.Clone(vm.HostBindMounts)
+	for i := range vm.HostBindMounts {
+		paths[fmt.Sprintf("host_bind_mounts[%d].source_dir", i)] = &vm.HostBindMounts[i].SourceDir
+		paths[fmt.Sprintf("host_bind_mounts[%d].target_dir", i)] = &vm.HostBindMounts[i].TargetDir
+	}
+
+	for field, path := range paths {
+		cleaned, err := sanitize.CleanPath(*path)
+		if err != nil {
+			return fmt.Errorf("%w: VM %s %s: %w", ErrInvalidCluster, vm.Name, field, err)
+		}
+		*path = cleaned
+	}
+	return nil
+}
+
+// sanitizeCloneTarget validates the name and hostname of a clone and normalizes its paths.
+func sanitizeCloneTarget(target *parameters.TargetVMSpec) error {
+	if err := sanitize.ValidateName(target.Name); err != nil {
+		return fmt.Errorf("%w: VM name: %w", ErrInvalidCluster, err)
+	}
+	if target.Hostname != "" {
+		if err := sanitize.ValidateHostname(target.Hostname); err != nil {
+			return fmt.Errorf("%w: VM %s: %w", ErrInvalidCluster, target.Name, err)
+		}
+	}
+
+	for field, path := range map[string]*string{
+		"disk_path":       &target.DiskPath,
+		"base_image_path": &target.BaseImagePath,
+	} {
+		cleaned, err := sanitize.CleanPath(*path)
+		if err != nil {
+			return fmt.Errorf("%w: VM %s %s: %w", ErrInvalidCluster, target.Name, field, err)
+		}
+		*path = cleaned
+	}
+	return nil
+}
@@ -0,0 +1,405 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/internal/store"
+	"github.com/terabiome/homonculus/pkg/constants"
+	"github.com/terabiome/homonculus/pkg/executor/fileops"
+	"github.com/terabiome/homonculus/pkg/units"
+)
+
+// bucketPlacements records the host every provisioned VM was scheduled onto.
+const bucketPlacements = "placements"
+
+// Placement records which hypervisor host a VM was scheduled onto.
+type Placement struct {
+	VM            string    `json:"vm"`
+	Host          string    `json:"host"`
+	Cluster       string    `json:"cluster,omitempty"`
+	Role          string    `json:"role,omitempty"`
+	Owner         string    `json:"owner,omitempty"`
+	SpreadGroup   string    `json:"spread_group,omitempty"`
+	ColocateGroup string    `json:"colocate_group,omitempty"`
+	PlacedAt      time.Time `json:"placed_at"`
+}
+
+// hostCandidate tracks the remaining capacity of a host while a request is being scheduled.
+type hostCandidate struct {
+	name              string
+	capacity          parameters.HostCapacity
+	pendingMemoryKiB  uint64
+	pendingVCPUs      uint
+	diskSpace         map[string]diskSpace
+	spreadAssignments map[string]int
+	// spreadGroups and colocateGroups hold the placement groups with a member on the host.
+	spreadGroups   map[string]bool
+	colocateGroups map[string]bool
+}
+
+// diskSpace is the free space of a directory on a host, less the disks scheduled into it.
+type diskSpace struct {
+	freeBytes int64
+	// known is false if the free space could not be determined; such directories are not checked.
+	known bool
+}
+
+func (c *hostCandidate) memoryHeadroomKiB() int64 {
+	return int64(c.capacity.TotalMemoryKiB) - int64(c.capacity.AllocatedMemoryKiB) - int64(c.pendingMemoryKiB)
+}
+
+func (c *hostCandidate) vcpuRatio() float64 {
+	if c.capacity.CPUs == 0 {
+		return 0
+	}
+	return float64(c.capacity.AllocatedVCPUs+c.pendingVCPUs) / float64(c.capacity.CPUs)
+}
+
+// spreadKey returns the anti-affinity group of a VM: masters of the same cluster are spread across hosts.
+func spreadKey(clusterName string, vm parameters.CreateVM) string {
+	if vm.Role != string(constants.KUBERNETES_ROLE_MASTER) {
+		return ""
+	}
+	return clusterName + "/" + vm.Role
+}
+
+// groupKey scopes a spread or colocate group to its cluster.
+func groupKey(clusterName, group string) string {
+	if group == "" {
+		return ""
+	}
+	return clusterName + "/" + group
+}
+
+// scheduleCluster assigns a host to every VM of the request that does not name one explicitly.
+// With a single configured host every VM lands on it; otherwise VMs are placed on the host with
+// the most uncommitted memory that has room for them, spreading masters of a cluster across hosts.
+// Spread groups are strict: no two members share a host, so a single host takes at most one member
+// of each. Colocate groups share the host of their first member.
+// Hosts in maintenance take no VMs.
+func (s *VMService) scheduleCluster(ctx context.Context, cluster *parameters.CreateCluster) error {
+	for _, vm := range cluster.VirtualMachines {
+		if vm.Host != "" && s.inMaintenance(vm.Host) {
+			return fmt.Errorf("%w: VM %s requests host %s", ErrHostInMaintenance, vm.Name, vm.Host)
+		}
+	}
+
+	if s.hosts.Len() == 1 {
+		if host := s.hosts.Default(); s.inMaintenance(host) {
+			return fmt.Errorf("%w: %s is the only host", ErrHostInMaintenance, host)
+		}
+		for i := range cluster.VirtualMachines {
+			if cluster.VirtualMachines[i].Host == "" {
+				cluster.VirtualMachines[i].Host = s.hosts.Default()
+			}
+		}
+		return s.checkSingleHostSpreadGroups(*cluster)
+	}
+
+	scheduled := make(map[string]bool, len(cluster.VirtualMachines))
+	colocateMemoryMB := make(map[string]int64)
+	for _, vm := range cluster.VirtualMachines {
+		scheduled[vm.Name] = true
+		if key := groupKey(cluster.Name, vm.ColocateGroup); key != "" {
+			colocateMemoryMB[key] += vm.MemoryMB
+		}
+	}
+
+	candidates, err := s.loadHostCandidates(ctx, scheduled)
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("no hypervisor host available for scheduling")
+	}
+
+	for i := range cluster.VirtualMachines {
+		vm := &cluster.VirtualMachines[i]
+
+		if vm.Host == "" {
+			var placement Placement
+			if found, _ := s.store.Get(bucketPlacements, vm.Name, &placement); found {
+				vm.Host = placement.Host
+			}
+		}
+
+		var chosen *hostCandidate
+		if vm.Host != "" {
+			for _, candidate := range candidates {
+				if candidate.name == vm.Host {
+					chosen = candidate
+					break
+				}
+			}
+			if chosen == nil {
+				return fmt.Errorf("VM %s requests unknown or unavailable host %s", vm.Name, vm.Host)
+			}
+			if err := checkPlacementGroups(candidates, chosen, cluster.Name, *vm); err != nil {
+				return err
+			}
+		} else {
+			chosen, err = s.pickHost(ctx, candidates, cluster.Name, *vm, colocateMemoryMB[groupKey(cluster.Name, vm.ColocateGroup)])
+			if err != nil {
+				return err
+			}
+			vm.Host = chosen.name
+		}
+
+		chosen.pendingMemoryKiB += units.MiBToKiB(uint64(vm.MemoryMB))
+		chosen.pendingVCPUs += uint(vm.VCPUCount)
+		if vm.DiskPath != "" {
+			dir := filepath.Dir(vm.DiskPath)
+			if space := s.candidateDiskSpace(ctx, chosen, dir); space.known {
+				space.freeBytes -= vm.DiskSizeGB << 30
+				chosen.diskSpace[dir] = space
+			}
+		}
+		if key := spreadKey(cluster.Name, *vm); key != "" {
+			chosen.spreadAssignments[key]++
+		}
+		if key := groupKey(cluster.Name, vm.SpreadGroup); key != "" {
+			chosen.spreadGroups[key] = true
+		}
+		if key := groupKey(cluster.Name, vm.ColocateGroup); key != "" {
+			chosen.colocateGroups[key] = true
+			colocateMemoryMB[key] -= vm.MemoryMB
+		}
+
+		s.logger.Info("scheduled VM",
+			slog.String("vm", vm.Name),
+			slog.String("host", vm.Host),
+		)
+	}
+
+	return nil
+}
+
+// checkSingleHostSpreadGroups rejects a cluster placed on the only host if a spread group would
+// get a second member there, counting the members placed by earlier requests.
+func (s *VMService) checkSingleHostSpreadGroups(cluster parameters.CreateCluster) error {
+	members := make(map[string]int)
+	requested := make(map[string]bool, len(cluster.VirtualMachines))
+	for _, vm := range cluster.VirtualMachines {
+		requested[vm.Name] = true
+		if key := groupKey(cluster.Name, vm.SpreadGroup); key != "" {
+			members[key]++
+		}
+	}
+	if len(members) == 0 {
+		return nil
+	}
+
+	placements, err := store.List[Placement](s.store, bucketPlacements)
+	if err != nil {
+		return fmt.Errorf("failed to load placements: %w", err)
+	}
+	for _, placement := range placements {
+		if key := groupKey(placement.Cluster, placement.SpreadGroup); key != "" && !requested[placement.VM] && members[key] > 0 {
+			members[key]++
+		}
+	}
+
+	for _, vm := range cluster.VirtualMachines {
+		if key := groupKey(cluster.Name, vm.SpreadGroup); key != "" && members[key] > 1 {
+			return fmt.Errorf("%w: spread group %s has %d members, but only host %s is configured", ErrInvalidCluster, vm.SpreadGroup, members[key], s.hosts.Default())
+		}
+	}
+	return nil
+}
+
+// checkPlacementGroups rejects an explicitly requested host that breaks the spread or colocate group of a VM.
+func checkPlacementGroups(candidates []*hostCandidate, chosen *hostCandidate, clusterName string, vm parameters.CreateVM) error {
+	if key := groupKey(clusterName, vm.SpreadGroup); key != "" && chosen.spreadGroups[key] {
+		return fmt.Errorf("VM %s cannot be placed on host %s: another member of spread group %s is there", vm.Name, chosen.name, vm.SpreadGroup)
+	}
+	if key := groupKey(clusterName, vm.ColocateGroup); key != "" {
+		for _, candidate := range candidates {
+			if candidate != chosen && candidate.colocateGroups[key] {
+				return fmt.Errorf("VM %s cannot be placed on host %s: colocate group %s is on host %s", vm.Name, chosen.name, vm.ColocateGroup, candidate.name)
+			}
+		}
+	}
+	return nil
+}
+
+// pickHost filters hosts that can fit the VM and satisfy its placement groups, and ranks them by
+// anti-affinity, memory headroom and vCPU load. A host for the first member of a colocate group
+// must fit the remaining colocateMemoryMB of the group.
+func (s *VMService) pickHost(ctx context.Context, candidates []*hostCandidate, clusterName string, vm parameters.CreateVM, colocateMemoryMB int64) (*hostCandidate, error) {
+	memoryKiB := units.MiBToKiB(int64(vm.MemoryMB))
+	diskDir := filepath.Dir(vm.DiskPath)
+	key := spreadKey(clusterName, vm)
+	spreadGroup := groupKey(clusterName, vm.SpreadGroup)
+	colocateGroup := groupKey(clusterName, vm.ColocateGroup)
+
+	if colocateGroup != "" {
+		for _, candidate := range candidates {
+			if candidate.colocateGroups[colocateGroup] {
+				candidates = []*hostCandidate{candidate}
+				break
+			}
+		}
+		if len(candidates) > 1 && units.MiBToKiB(colocateMemoryMB) > memoryKiB {
+			memoryKiB = units.MiBToKiB(colocateMemoryMB)
+		}
+	}
+
+	var fitting []*hostCandidate
+	for _, candidate := range candidates {
+		if spreadGroup != "" && candidate.spreadGroups[spreadGroup] {
+			continue
+		}
+		if candidate.memoryHeadroomKiB() < memoryKiB {
+			continue
+		}
+
+		// Diskless VMs need no storage.
+		if vm.DiskPath != "" {
+			if space := s.candidateDiskSpace(ctx, candidate, diskDir); space.known && space.freeBytes < vm.DiskSizeGB<<30 {
+				continue
+			}
+		}
+
+		fitting = append(fitting, candidate)
+	}
+
+	if len(fitting) == 0 {
+		var groups string
+		if spreadGroup != "" {
+			groups += ", spread group " + vm.SpreadGroup
+		}
+		if colocateGroup != "" {
+			groups += ", colocate group " + vm.ColocateGroup
+		}
+		return nil, fmt.Errorf("no hypervisor host has enough capacity for VM %s (%d MiB memory, %d GB disk%s)", vm.Name, vm.MemoryMB, vm.DiskSizeGB, groups)
+	}
+
+	sort.SliceStable(fitting, func(i, j int) bool {
+		a, b := fitting[i], fitting[j]
+		if key != "" && a.spreadAssignments[key] != b.spreadAssignments[key] {
+			return a.spreadAssignments[key] < b.spreadAssignments[key]
+		}
+		if a.memoryHeadroomKiB() != b.memoryHeadroomKiB() {
+			return a.memoryHeadroomKiB() > b.memoryHeadroomKiB()
+		}
+		return a.vcpuRatio() < b.vcpuRatio()
+	})
+
+	return fitting[0], nil
+}
+
+// loadHostCandidates gathers capacity of every reachable host not in maintenance and the
+// anti-affinity and placement groups already placed on it. Placements of the VMs being
+// scheduled are ignored.
+func (s *VMService) loadHostCandidates(ctx context.Context, scheduled map[string]bool) ([]*hostCandidate, error) {
+	placements, err := store.List[Placement](s.store, bucketPlacements)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load placements: %w", err)
+	}
+
+	var candidates []*hostCandidate
+	for _, host := range s.hosts.Names() {
+		if s.inMaintenance(host) {
+			s.logger.Debug("skipping host in maintenance during scheduling", slog.String("host", host))
+			continue
+		}
+		candidate := &hostCandidate{
+			name:              host,
+			diskSpace:         make(map[string]diskSpace),
+			spreadAssignments: make(map[string]int),
+			spreadGroups:      make(map[string]bool),
+			colocateGroups:    make(map[string]bool),
+		}
+
+		err := s.withHypervisor(ctx, host, func(hypervisor dependencies.HypervisorContext) error {
+			capacity, err := s.libvirtManager.GetHostCapacity(ctx, hypervisor)
+			candidate.capacity = capacity
+			return err
+		})
+		if err != nil {
+			s.logger.Warn("skipping unreachable host during scheduling", slog.String("host", host), slog.String("error", err.Error()))
+			continue
+		}
+
+		for _, placement := range placements {
+			if placement.Host != host || scheduled[placement.VM] {
+				continue
+			}
+			key := spreadKey(placement.Cluster, parameters.CreateVM{Role: placement.Role})
+			if key != "" {
+				candidate.spreadAssignments[key]++
+			}
+			if key := groupKey(placement.Cluster, placement.SpreadGroup); key != "" {
+				candidate.spreadGroups[key] = true
+			}
+			if key := groupKey(placement.Cluster, placement.ColocateGroup); key != "" {
+				candidate.colocateGroups[key] = true
+			}
+		}
+
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, nil
+}
+
+// candidateDiskSpace returns the remaining space of a directory on a candidate host, measuring
+// it on first use.
+func (s *VMService) candidateDiskSpace(ctx context.Context, candidate *hostCandidate, dir string) diskSpace {
+	space, measured := candidate.diskSpace[dir]
+	if !measured {
+		space = s.freeDiskSpace(ctx, candidate.name, dir)
+		candidate.diskSpace[dir] = space
+	}
+	return space
+}
+
+// freeDiskSpace returns the free space of a directory on a host, unknown if it cannot be determined.
+func (s *VMService) freeDiskSpace(ctx context.Context, host, dir string) diskSpace {
+	var free int64
+	err := s.withHypervisor(ctx, host, func(hypervisor dependencies.HypervisorContext) error {
+		var err error
+		free, err = fileops.FreeSpace(ctx, hypervisor.Executor, dir)
+		return err
+	})
+	if err != nil {
+		s.logger.Warn("could not determine free disk space",
+			slog.String("host", host),
+			slog.String("dir", dir),
+			slog.String("error", err.Error()),
+		)
+		return diskSpace{}
+	}
+	return diskSpace{freeBytes: free, known: true}
+}
+
+// recordPlacement persists the host a VM was provisioned on.
+func (s *VMService) recordPlacement(clusterName string, vm parameters.CreateVM) {
+	placement := Placement{
+		VM:            vm.Name,
+		Host:          vm.Host,
+		Cluster:       clusterName,
+		Role:          vm.Role,
+		Owner:         vm.Owner,
+		SpreadGroup:   vm.SpreadGroup,
+		ColocateGroup: vm.ColocateGroup,
+		PlacedAt:      time.Now().UTC(),
+	}
+	if err := s.store.Put(bucketPlacements, vm.Name, placement); err != nil {
+		s.logger.Warn("failed to record VM placement", slog.String("vm", vm.Name), slog.String("error", err.Error()))
+	}
+}
+
+// forgetPlacement removes the recorded placement of a deleted VM.
+func (s *VMService) forgetPlacement(name string) {
+	if err := s.store.Delete(bucketPlacements, name); err != nil {
+		s.logger.Warn("failed to remove VM placement", slog.String("vm", name), slog.String("error", err.Error()))
+	}
+}
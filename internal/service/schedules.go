@@ -0,0 +1,315 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/internal/store"
+	"github.com/terabiome/homonculus/pkg/cron"
+	"github.com/terabiome/homonculus/pkg/labels"
+)
+
+// bucketSchedules holds the registered recurring actions.
+const bucketSchedules = "schedules"
+
+const (
+	ScheduleActionStart    = "start"
+	ScheduleActionStop     = "stop"
+	ScheduleActionSnapshot = "snapshot"
+)
+
+// scheduleGracePeriod is how late a run may still start. Runs missed for longer,
+// e.g. while the server was down, are skipped rather than replayed.
+const scheduleGracePeriod = 5 * time.Minute
+
+// ErrInvalidSchedule is returned for schedules that cannot be registered.
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// parseSchedule returns the cron expression of a schedule and the location it is evaluated in.
+func parseSchedule(expression, timezone string) (*cron.Schedule, *time.Location, error) {
+	location := time.Local
+	if timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(timezone); err != nil {
+			return nil, nil, fmt.Errorf("unknown timezone %q: %w", timezone, err)
+		}
+	}
+
+	schedule, err := cron.Parse(expression)
+	if err != nil {
+		return nil, nil, err
+	}
+	return schedule, location, nil
+}
+
+// nextRun returns when a schedule is due next after its last run, or after its creation if it never ran.
+func nextRun(schedule parameters.Schedule) time.Time {
+	expression, location, err := parseSchedule(schedule.Cron, schedule.Timezone)
+	if err != nil {
+		return time.Time{}
+	}
+	last := schedule.LastRun
+	if last.IsZero() {
+		last = schedule.CreatedAt
+	}
+	return expression.Next(last.In(location))
+}
+
+// CreateSchedule validates and registers a recurring action.
+func (s *VMService) CreateSchedule(params parameters.CreateSchedule) (parameters.Schedule, error) {
+	if _, _, err := parseSchedule(params.Cron, params.Timezone); err != nil {
+		return parameters.Schedule{}, fmt.Errorf("%w: %w", ErrInvalidSchedule, err)
+	}
+
+	switch params.Action {
+	case ScheduleActionStart, ScheduleActionStop, ScheduleActionSnapshot:
+	default:
+		return parameters.Schedule{}, fmt.Errorf("%w: action must be '%s', '%s' or '%s', got '%s'",
+			ErrInvalidSchedule, ScheduleActionStart, ScheduleActionStop, ScheduleActionSnapshot, params.Action)
+	}
+
+	selector, err := labels.Parse(params.Selector)
+	if err != nil {
+		return parameters.Schedule{}, fmt.Errorf("%w: %w", ErrInvalidSchedule, err)
+	}
+	if selector.Empty() {
+		return parameters.Schedule{}, fmt.Errorf("%w: a selector is required", ErrInvalidSchedule)
+	}
+
+	if params.Retain < 0 || (params.Retain > 0 && params.Action != ScheduleActionSnapshot) {
+		return parameters.Schedule{}, fmt.Errorf("%w: retain must be positive and only applies to snapshot schedules", ErrInvalidSchedule)
+	}
+
+	schedule := parameters.Schedule{
+		ID:        uuid.NewString(),
+		Cron:      params.Cron,
+		Timezone:  params.Timezone,
+		Action:    params.Action,
+		Selector:  selector.String(),
+		Retain:    params.Retain,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.store.Put(bucketSchedules, schedule.ID, schedule); err != nil {
+		return parameters.Schedule{}, fmt.Errorf("failed to save schedule: %w", err)
+	}
+	schedule.NextRun = nextRun(schedule)
+
+	s.logger.Info("registered schedule",
+		slog.String("schedule", schedule.ID),
+		slog.String("cron", schedule.Cron),
+		slog.String("action", schedule.Action),
+		slog.String("selector", schedule.Selector),
+	)
+	return schedule, nil
+}
+
+// ListSchedules returns the registered schedules with their next run.
+func (s *VMService) ListSchedules() ([]parameters.Schedule, error) {
+	schedules, err := store.List[parameters.Schedule](s.store, bucketSchedules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load schedules: %w", err)
+	}
+	for i := range schedules {
+		schedules[i].NextRun = nextRun(schedules[i])
+	}
+	return schedules, nil
+}
+
+// DeleteSchedule cancels a schedule and reports whether it existed.
+// A run already in progress completes.
+func (s *VMService) DeleteSchedule(id string) (bool, error) {
+	s.schedulesMu.Lock()
+	defer s.schedulesMu.Unlock()
+
+	var schedule parameters.Schedule
+	found, err := s.store.Get(bucketSchedules, id, &schedule)
+	if err != nil || !found {
+		return false, err
+	}
+	if err := s.store.Delete(bucketSchedules, id); err != nil {
+		return false, fmt.Errorf("failed to delete schedule: %w", err)
+	}
+
+	s.logger.Info("cancelled schedule", slog.String("schedule", id))
+	return true, nil
+}
+
+// RunDueSchedules runs every schedule due at now, one after another.
+func (s *VMService) RunDueSchedules(ctx context.Context, now time.Time) {
+	schedules, err := store.List[parameters.Schedule](s.store, bucketSchedules)
+	if err != nil {
+		s.logger.Error("failed to load schedules", slog.String("error", err.Error()))
+		return
+	}
+
+	for _, schedule := range schedules {
+		if ctx.Err() != nil {
+			return
+		}
+
+		due := nextRun(schedule)
+		if due.IsZero() || due.After(now) {
+			continue
+		}
+
+		if late := now.Sub(due); late > scheduleGracePeriod {
+			s.logger.Warn("skipping missed scheduled run",
+				slog.String("schedule", schedule.ID),
+				slog.Time("due", due),
+				slog.Duration("late", late),
+			)
+			schedule.LastError = fmt.Sprintf("skipped run due at %s", due.Format(time.RFC3339))
+		} else {
+			s.logger.Info("running schedule",
+				slog.String("schedule", schedule.ID),
+				slog.String("action", schedule.Action),
+				slog.String("selector", schedule.Selector),
+			)
+			schedule.LastError = ""
+			if err := s.runSchedule(ctx, schedule, now); err != nil {
+				s.logger.Error("scheduled run failed", slog.String("schedule", schedule.ID), slog.String("error", err.Error()))
+				schedule.LastError = err.Error()
+			}
+		}
+
+		schedule.LastRun = now
+		s.recordScheduleRun(schedule)
+	}
+}
+
+// recordScheduleRun stores the outcome of a run, unless the schedule was cancelled meanwhile.
+func (s *VMService) recordScheduleRun(schedule parameters.Schedule) {
+	s.schedulesMu.Lock()
+	defer s.schedulesMu.Unlock()
+
+	var existing parameters.Schedule
+	if found, _ := s.store.Get(bucketSchedules, schedule.ID, &existing); !found {
+		return
+	}
+	if err := s.store.Put(bucketSchedules, schedule.ID, schedule); err != nil {
+		s.logger.Warn("failed to record schedule run", slog.String("schedule", schedule.ID), slog.String("error", err.Error()))
+	}
+}
+
+// runSchedule applies the action of a schedule to the VMs its selector matches.
+func (s *VMService) runSchedule(ctx context.Context, schedule parameters.Schedule, now time.Time) error {
+	selector, err := labels.Parse(schedule.Selector)
+	if err != nil {
+		return err
+	}
+	vmInfos, err := s.SelectVirtualMachines(ctx, selector)
+	if err != nil {
+		return err
+	}
+
+	switch schedule.Action {
+	case ScheduleActionStart:
+		var vms []parameters.StartVM
+		for _, vmInfo := range vmInfos {
+			if vmInfo.State != "running" {
+				vms = append(vms, parameters.StartVM{Name: vmInfo.Name})
+			}
+		}
+		return s.StartCluster(ctx, vms, 0)
+	case ScheduleActionStop:
+		var vms []parameters.StopVM
+		for _, vmInfo := range vmInfos {
+			vms = append(vms, parameters.StopVM{Name: vmInfo.Name})
+		}
+		return s.StopCluster(ctx, vms, 0)
+	case ScheduleActionSnapshot:
+		return s.snapshotVirtualMachines(ctx, schedule, vmInfos, now)
+	}
+	return fmt.Errorf("unknown schedule action '%s'", schedule.Action)
+}
+
+// snapshotVirtualMachines snapshots each VM and prunes the oldest snapshots of the schedule beyond its retention.
+func (s *VMService) snapshotVirtualMachines(ctx context.Context, schedule parameters.Schedule, vmInfos []parameters.VMInfo, now time.Time) error {
+	// Timestamps sort chronologically, so pruning can go by name.
+	prefix := "scheduled-" + schedule.ID[:8] + "-"
+	snapshotName := prefix + now.UTC().Format("20060102-150405")
+
+	var failedVMs []string
+	for _, vmInfo := range vmInfos {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := s.withVirtualMachineHypervisor(ctx, vmInfo.Name, func(hypervisor dependencies.HypervisorContext) error {
+			err := s.libvirtManager.CreateSnapshot(ctx, hypervisor, vmInfo.Name, snapshotName, "Scheduled by "+schedule.ID)
+			if err != nil || schedule.Retain == 0 {
+				return err
+			}
+
+			names, err := s.libvirtManager.ListSnapshots(hypervisor, vmInfo.Name)
+			if err != nil {
+				return err
+			}
+			names = slices.DeleteFunc(names, func(name string) bool { return !strings.HasPrefix(name, prefix) })
+			slices.Sort(names)
+			for len(names) > schedule.Retain {
+				if err := s.libvirtManager.DeleteSnapshot(hypervisor, vmInfo.Name, names[0]); err != nil {
+					return err
+				}
+				names = names[1:]
+			}
+			return nil
+		})
+		if err != nil {
+			s.logger.Error("failed to snapshot VM",
+				slog.String("vm", vmInfo.Name),
+				slog.String("snapshot", snapshotName),
+				slog.String("error", err.Error()),
+			)
+			failedVMs = append(failedVMs, vmInfo.Name)
+		}
+	}
+
+	if len(failedVMs) > 0 {
+		return fmt.Errorf("failed to snapshot %d VM(s): %v", len(failedVMs), failedVMs)
+	}
+	return nil
+}
+
+// scheduleTick is how often the runner looks for due schedules; cron has minute resolution.
+const scheduleTick = 30 * time.Second
+
+// ScheduleRunner runs due schedules in the background.
+type ScheduleRunner struct {
+	vmService *VMService
+	logger    *slog.Logger
+}
+
+// NewScheduleRunner creates a new ScheduleRunner.
+func NewScheduleRunner(vmService *VMService, logger *slog.Logger) *ScheduleRunner {
+	return &ScheduleRunner{
+		vmService: vmService,
+		logger:    logger.With(slog.String("component", "schedules")),
+	}
+}
+
+// Run checks for due schedules on every tick until ctx is cancelled.
+func (r *ScheduleRunner) Run(ctx context.Context) {
+	r.logger.Info("schedule runner started")
+
+	ticker := time.NewTicker(scheduleTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.logger.Info("schedule runner stopped")
+			return
+		case now := <-ticker.C:
+			r.vmService.RunDueSchedules(ctx, now)
+		}
+	}
+}
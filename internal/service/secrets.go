@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/terabiome/homonculus/internal/service/parameters"
+)
+
+// resolveSecrets returns a copy of vm with secret:// references replaced by their values.
+// The stored cluster spec keeps the references, so resolved values never reach the state store.
+func (s *VMService) resolveSecrets(ctx context.Context, vm parameters.CreateVM) (parameters.CreateVM, error) {
+	if s.secrets == nil {
+		return vm, nil
+	}
+
+	vm.UserConfigs = slices.Clone(vm.UserConfigs)
+	for i := range vm.UserConfigs {
+		password, err := s.secrets.Resolve(ctx, vm.UserConfigs[i].Password)
+		if err != nil {
+			return vm, fmt.Errorf("user %s password: %w", vm.UserConfigs[i].Username, err)
+		}
+		vm.UserConfigs[i].Password = password
+	}
+
+	if vm.Graphics != nil {
+		graphics := *vm.Graphics
+		password, err := s.secrets.Resolve(ctx, graphics.Password)
+		if err != nil {
+			return vm, fmt.Errorf("graphics password: %w", err)
+		}
+		graphics.Password = password
+		vm.Graphics = &graphics
+	}
+
+	return vm, nil
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/internal/store"
+	"github.com/terabiome/homonculus/pkg/sshkeys"
+)
+
+// bucketSSHKeys holds generated SSH keys by name. Private keys are only stored in an encrypted store.
+const bucketSSHKeys = "ssh_keys"
+
+var sshKeyNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,62}$`)
+
+var (
+	// ErrInvalidSSHKey is returned for SSH key requests that cannot be served.
+	ErrInvalidSSHKey = errors.New("invalid ssh key request")
+	// ErrSSHKeyNotFound is returned for unknown SSH key names.
+	ErrSSHKeyNotFound = errors.New("ssh key not found")
+)
+
+// sshKeyRecord is the persisted form of a generated SSH key.
+type sshKeyRecord struct {
+	Name        string
+	PublicKey   string
+	Fingerprint string
+	CreatedAt   time.Time
+	PrivateKey  string // empty if the private key was handed out instead of stored
+}
+
+func (r sshKeyRecord) toParameters() parameters.SSHKey {
+	return parameters.SSHKey{
+		Name:        r.Name,
+		PublicKey:   r.PublicKey,
+		Fingerprint: r.Fingerprint,
+		Stored:      r.PrivateKey != "",
+		CreatedAt:   r.CreatedAt,
+	}
+}
+
+// GenerateSSHKey generates an ed25519 keypair under a new name. Unless it is stored, which the
+// state encryption keys must protect, the private key is only part of the returned key and is
+// not kept anywhere.
+func (s *VMService) GenerateSSHKey(params parameters.GenerateSSHKey) (parameters.SSHKey, error) {
+	if !sshKeyNamePattern.MatchString(params.Name) {
+		return parameters.SSHKey{}, fmt.Errorf("%w: name must be 1-63 alphanumerics, '.', '_' or '-', starting with an alphanumeric", ErrInvalidSSHKey)
+	}
+	if params.Store && !s.store.Encrypted() {
+		return parameters.SSHKey{}, fmt.Errorf("%w: storing private keys requires state_encryption", ErrInvalidSSHKey)
+	}
+
+	s.sshKeysMu.Lock()
+	defer s.sshKeysMu.Unlock()
+
+	var existing sshKeyRecord
+	found, err := s.store.Get(bucketSSHKeys, params.Name, &existing)
+	if err != nil {
+		return parameters.SSHKey{}, err
+	}
+	if found {
+		return parameters.SSHKey{}, fmt.Errorf("%w: ssh key %s already exists", ErrInvalidSSHKey, params.Name)
+	}
+
+	keyPair, err := sshkeys.Generate("homonculus-" + params.Name)
+	if err != nil {
+		return parameters.SSHKey{}, err
+	}
+
+	record := sshKeyRecord{
+		Name:        params.Name,
+		PublicKey:   keyPair.PublicKey,
+		Fingerprint: keyPair.Fingerprint,
+		CreatedAt:   time.Now().UTC(),
+	}
+	if params.Store {
+		record.PrivateKey = string(keyPair.PrivateKey)
+	}
+	if err := s.store.Put(bucketSSHKeys, record.Name, record); err != nil {
+		return parameters.SSHKey{}, fmt.Errorf("failed to save ssh key: %w", err)
+	}
+
+	s.logger.Info("generated ssh key",
+		slog.String("name", record.Name),
+		slog.String("fingerprint", record.Fingerprint),
+		slog.Bool("stored", params.Store),
+	)
+
+	key := record.toParameters()
+	key.PrivateKey = string(keyPair.PrivateKey)
+	return key, nil
+}
+
+// ListSSHKeys returns the generated SSH keys without their private keys.
+func (s *VMService) ListSSHKeys() ([]parameters.SSHKey, error) {
+	records, err := store.List[sshKeyRecord](s.store, bucketSSHKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ssh keys: %w", err)
+	}
+
+	keys := make([]parameters.SSHKey, len(records))
+	for i, record := range records {
+		keys[i] = record.toParameters()
+	}
+	return keys, nil
+}
+
+// GetSSHPrivateKey returns a stored SSH key including its private key.
+func (s *VMService) GetSSHPrivateKey(name string) (parameters.SSHKey, error) {
+	var record sshKeyRecord
+	found, err := s.store.Get(bucketSSHKeys, name, &record)
+	if err != nil {
+		return parameters.SSHKey{}, err
+	}
+	if !found {
+		return parameters.SSHKey{}, fmt.Errorf("%w: %s", ErrSSHKeyNotFound, name)
+	}
+	if record.PrivateKey == "" {
+		return parameters.SSHKey{}, fmt.Errorf("%w: the private key of %s was not stored", ErrInvalidSSHKey, name)
+	}
+
+	key := record.toParameters()
+	key.PrivateKey = record.PrivateKey
+	return key, nil
+}
+
+// DeleteSSHKey forgets an SSH key and reports whether it existed. VMs keep the public key they were given.
+func (s *VMService) DeleteSSHKey(name string) (bool, error) {
+	s.sshKeysMu.Lock()
+	defer s.sshKeysMu.Unlock()
+
+	var record sshKeyRecord
+	found, err := s.store.Get(bucketSSHKeys, name, &record)
+	if err != nil || !found {
+		return false, err
+	}
+	if err := s.store.Delete(bucketSSHKeys, name); err != nil {
+		return false, fmt.Errorf("failed to delete ssh key: %w", err)
+	}
+
+	s.logger.Info("deleted ssh key", slog.String("name", name))
+	return true, nil
+}
+
+// authorizeSSHKeys adds the public keys of the named SSH keys to every user of every VM.
+func (s *VMService) authorizeSSHKeys(names []string, vms []parameters.CreateVM) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	publicKeys := make([]string, 0, len(names))
+	for _, name := range names {
+		var record sshKeyRecord
+		found, err := s.store.Get(bucketSSHKeys, name, &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("%w: %s", ErrSSHKeyNotFound, name)
+		}
+		publicKeys = append(publicKeys, record.PublicKey)
+	}
+
+	for i := range vms {
+		if len(vms[i].UserConfigs) == 0 {
+			return fmt.Errorf("%w: VM %s has no user to authorize ssh keys for", ErrInvalidSSHKey, vms[i].Name)
+		}
+		vms[i].UserConfigs = slices.Clone(vms[i].UserConfigs)
+		for j := range vms[i].UserConfigs {
+			user := &vms[i].UserConfigs[j]
+			user.SSHAuthorizedKeys = slices.Clone(user.SSHAuthorizedKeys)
+			for _, publicKey := range publicKeys {
+				if !slices.Contains(user.SSHAuthorizedKeys, publicKey) {
+					user.SSHAuthorizedKeys = append(user.SSHAuthorizedKeys, publicKey)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+)
+
+// MinStatsInterval is the shortest interval a stats stream samples at.
+const MinStatsInterval = time.Second
+
+// StreamVirtualMachineStats samples the counters of a VM every interval and emits the usage since the
+// previous sample, until ctx is done or emit fails. The hypervisor is only held while sampling.
+// An error reading the first sample is returned before anything is emitted.
+func (s *VMService) StreamVirtualMachineStats(ctx context.Context, name string, interval time.Duration, emit func(parameters.VMStats) error) error {
+	name = qualify(ctx, name)
+	host := s.locateVirtualMachine(ctx, name)
+	sample := func() (parameters.VMStatsSample, error) {
+		var sample parameters.VMStatsSample
+		err := s.withHypervisor(ctx, host, func(hypervisor dependencies.HypervisorContext) error {
+			var err error
+			sample, err = s.libvirtManager.GetVirtualMachineStats(hypervisor, name)
+			return err
+		})
+		return sample, err
+	}
+
+	previous, err := sample()
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		current, err := sample()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if err := emit(statsDelta(name, previous, current)); err != nil {
+			return err
+		}
+		previous = current
+	}
+}
+
+// statsDelta computes the usage between two samples. A counter that went backwards,
+// as after a restart of the VM, counts from zero.
+func statsDelta(name string, previous, current parameters.VMStatsSample) parameters.VMStats {
+	delta := func(previous, current uint64) uint64 {
+		if current < previous {
+			return current
+		}
+		return current - previous
+	}
+
+	elapsed := current.Time.Sub(previous.Time)
+	stats := parameters.VMStats{
+		Name:            name,
+		Time:            current.Time,
+		IntervalSeconds: elapsed.Seconds(),
+		State:           current.State,
+		MemoryKiB:       current.MemoryKiB,
+		MemoryRSSKiB:    current.MemoryRSSKiB,
+		DiskReadBytes:   delta(previous.DiskReadBytes, current.DiskReadBytes),
+		DiskWriteBytes:  delta(previous.DiskWriteBytes, current.DiskWriteBytes),
+		NetRxBytes:      delta(previous.NetRxBytes, current.NetRxBytes),
+		NetTxBytes:      delta(previous.NetTxBytes, current.NetTxBytes),
+	}
+	if elapsed > 0 && current.VCPUCount > 0 {
+		cpuTime := delta(previous.CPUTimeNs, current.CPUTimeNs)
+		stats.CPUPercent = float64(cpuTime) / (float64(elapsed.Nanoseconds()) * float64(current.VCPUCount)) * 100
+		stats.CPUPercent = min(stats.CPUPercent, 100)
+	}
+	return stats
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/pkg/templator"
+)
+
+// ErrNoTemplateCandidate is returned when switching template versions while no candidate is staged.
+var ErrNoTemplateCandidate = templator.ErrNoCandidate
+
+// TemplateRollout returns the loaded template versions and the canary renderings of new VMs
+// since the candidate version was staged.
+func (s *VMService) TemplateRollout() parameters.TemplateRollout {
+	var rollout parameters.TemplateRollout
+	for _, version := range s.templates.Versions() {
+		rollout.Versions = append(rollout.Versions, parameters.TemplateVersion{
+			Name:     version.Name,
+			Active:   version.Active,
+			LoadedAt: version.LoadedAt,
+			Digests:  version.Digests,
+		})
+	}
+	for _, diff := range s.templates.Diffs() {
+		rollout.Diffs = append(rollout.Diffs, parameters.TemplateDiff{
+			VM:             diff.Subject,
+			Template:       diff.Template,
+			Time:           diff.Time,
+			Identical:      diff.Identical,
+			Diff:           diff.Diff,
+			CandidateError: diff.CandidateError,
+		})
+	}
+	return rollout
+}
+
+// StageTemplates reads the template files again into the inactive version, which becomes
+// the candidate: VMs keep being created from the active version, while their templates are
+// also rendered with the candidate so the differences can be reviewed before switching.
+func (s *VMService) StageTemplates(ctx context.Context) (parameters.TemplateRollout, error) {
+	version, err := s.templates.Stage()
+	if err != nil {
+		s.recordEvent(ctx, EventTemplatesStaged, "", "", "failed to stage template version", err)
+		return parameters.TemplateRollout{}, err
+	}
+	s.logger.Info("staged candidate template version", slog.String("version", version))
+	s.recordEvent(ctx, EventTemplatesStaged, "", "", "staged candidate template version "+version, nil)
+	return s.TemplateRollout(), nil
+}
+
+// SwitchTemplates makes the candidate template version active. The previous version is kept
+// as the candidate, so switching again rolls the change back.
+func (s *VMService) SwitchTemplates(ctx context.Context) (parameters.TemplateRollout, error) {
+	version, err := s.templates.Switch()
+	if err != nil {
+		return parameters.TemplateRollout{}, err
+	}
+	s.logger.Warn("switched active template version", slog.String("version", version))
+	s.recordEvent(ctx, EventTemplatesSwitched, "", "", "switched active template version to "+version, nil)
+	return s.TemplateRollout(), nil
+}
+
+// DiscardTemplates unloads the candidate template version, which ends canary rendering.
+func (s *VMService) DiscardTemplates(ctx context.Context) parameters.TemplateRollout {
+	s.templates.Discard()
+	s.logger.Info("discarded candidate template version")
+	s.recordEvent(ctx, EventTemplatesDiscarded, "", "", "discarded candidate template version", nil)
+	return s.TemplateRollout()
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/internal/store"
+)
+
+// bucketTimelines holds the provisioning timeline of every VM, keyed by VM name.
+const bucketTimelines = "timelines"
+
+// Provisioning phases, in the order a VM passes them.
+const (
+	PhaseValidated    = "validated"
+	PhaseDiskCreated  = "disk_created"
+	PhaseISOCreated   = "iso_created"
+	PhaseDefined      = "defined"
+	PhaseStarted      = "started"
+	PhaseIPAcquired   = "ip_acquired"
+	PhaseAgentReady   = "agent_ready"
+	PhaseBootstrapped = "bootstrapped"
+)
+
+// timelineRecord is the persisted provisioning timeline of a VM.
+type timelineRecord struct {
+	VM     string
+	Phases []parameters.ProvisioningPhase
+}
+
+// startTimeline begins a new provisioning timeline for a VM whose spec was validated,
+// replacing the timeline of an earlier creation.
+func (s *VMService) startTimeline(name string) {
+	s.timelineMu.Lock()
+	defer s.timelineMu.Unlock()
+
+	record := timelineRecord{
+		VM:     name,
+		Phases: []parameters.ProvisioningPhase{{Phase: PhaseValidated, Time: time.Now().UTC()}},
+	}
+	if err := s.store.Put(bucketTimelines, name, record); err != nil {
+		s.logger.Warn("failed to record provisioning timeline", slog.String("vm", name), slog.String("error", err.Error()))
+	}
+}
+
+// recordPhase adds a phase to the timeline of a VM. Phases are only recorded once per
+// creation, so starting a VM again does not move its timeline; VMs without a timeline,
+// such as VMs created before timelines were recorded, are left alone.
+func (s *VMService) recordPhase(name, phase string) {
+	s.timelineMu.Lock()
+	defer s.timelineMu.Unlock()
+
+	s.recordPhaseLocked(name, phase, time.Now().UTC())
+}
+
+// recordPhaseLocked is recordPhase for callers holding timelineMu.
+func (s *VMService) recordPhaseLocked(name, phase string, at time.Time) {
+	var record timelineRecord
+	found, err := s.store.Get(bucketTimelines, name, &record)
+	if err != nil || !found || hasPhase(record.Phases, phase) {
+		return
+	}
+	record.Phases = append(record.Phases, parameters.ProvisioningPhase{Phase: phase, Time: at})
+	if err := s.store.Put(bucketTimelines, name, record); err != nil {
+		s.logger.Warn("failed to record provisioning phase",
+			slog.String("vm", name),
+			slog.String("phase", phase),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// forgetTimeline removes the provisioning timeline of a deleted VM.
+func (s *VMService) forgetTimeline(name string) {
+	s.timelineMu.Lock()
+	defer s.timelineMu.Unlock()
+
+	if err := s.store.Delete(bucketTimelines, name); err != nil {
+		s.logger.Warn("failed to remove provisioning timeline", slog.String("vm", name), slog.String("error", err.Error()))
+	}
+}
+
+// attachTimelines sets the provisioning timeline on the VMs that have one. A started VM
+// reporting an IP address for the first time gets its ip_acquired phase recorded, so that
+// phase holds when homonculus first saw the address, e.g. while waiting for readiness.
+func (s *VMService) attachTimelines(vmInfos []parameters.VMInfo) {
+	s.timelineMu.Lock()
+	defer s.timelineMu.Unlock()
+
+	records, err := store.List[timelineRecord](s.store, bucketTimelines)
+	if err != nil {
+		s.logger.Warn("failed to attach provisioning timelines", slog.String("error", err.Error()))
+		return
+	}
+	byName := make(map[string]timelineRecord, len(records))
+	for _, record := range records {
+		byName[record.VM] = record
+	}
+
+	now := time.Now().UTC()
+	for i := range vmInfos {
+		record, ok := byName[vmInfos[i].Name]
+		if !ok {
+			continue
+		}
+		if vmInfos[i].IPAddress != "" && hasPhase(record.Phases, PhaseStarted) && !hasPhase(record.Phases, PhaseIPAcquired) {
+			s.recordPhaseLocked(record.VM, PhaseIPAcquired, now)
+			record.Phases = append(record.Phases, parameters.ProvisioningPhase{Phase: PhaseIPAcquired, Time: now})
+		}
+		vmInfos[i].Timeline = slices.Clone(record.Phases)
+	}
+}
+
+// RecordBootstrapped records the bootstrapped phase of the VMs of bootstrapped K3s nodes.
+// Nodes are matched to VMs by name, hostname or IP address; nodes that are not VMs of
+// this service are ignored.
+func (s *VMService) RecordBootstrapped(ctx context.Context, nodes []string) {
+	vmInfos, err := s.cachedListAllVirtualMachines(ctx)
+	if err != nil {
+		s.logger.Warn("failed to record bootstrapped VMs", slog.String("error", err.Error()))
+		return
+	}
+	for _, node := range nodes {
+		for _, vmInfo := range vmInfos {
+			if node != "" && (node == vmInfo.Name || node == vmInfo.Hostname || node == vmInfo.IPAddress) {
+				s.recordPhase(vmInfo.Name, PhaseBootstrapped)
+				break
+			}
+		}
+	}
+}
+
+// hasPhase reports whether a timeline contains a phase.
+func hasPhase(phases []parameters.ProvisioningPhase, phase string) bool {
+	return slices.ContainsFunc(phases, func(p parameters.ProvisioningPhase) bool {
+		return p.Phase == phase
+	})
+}
@@ -4,42 +4,128 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/terabiome/homonculus/internal/dependencies"
-	"github.com/terabiome/homonculus/internal/service/infrastructure/cloudinit"
-	"github.com/terabiome/homonculus/internal/service/infrastructure/disk"
-	"github.com/terabiome/homonculus/internal/service/infrastructure/libvirt"
+	"github.com/terabiome/homonculus/internal/errdefs"
 	"github.com/terabiome/homonculus/internal/service/parameters"
-	"github.com/terabiome/homonculus/pkg/executor/fileops"
+	"github.com/terabiome/homonculus/internal/store"
+	"github.com/terabiome/homonculus/pkg/constants"
+	"github.com/terabiome/homonculus/pkg/labels"
 	pkglibvirt "github.com/terabiome/homonculus/pkg/libvirt"
+	"github.com/terabiome/homonculus/pkg/pathpolicy"
+	"github.com/terabiome/homonculus/pkg/secrets"
+	"github.com/terabiome/homonculus/pkg/templator"
+	"github.com/terabiome/homonculus/pkg/units"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/sync/errgroup"
 )
 
 // VMService provides transport-agnostic VM operations.
 type VMService struct {
-	diskManager      *disk.Manager
-	cloudinitManager *cloudinit.Manager
-	libvirtManager   *libvirt.Manager
-	connManager      *pkglibvirt.ConnectionManager
+	diskManager      DiskManager
+	cloudinitManager CloudInitManager
+	netbootManager   NetbootManager
+	libvirtManager   LibvirtManager
+	hosts            *pkglibvirt.HostPool
+	store            *store.Store
+	secrets          *secrets.Resolver
+	paths            *pathpolicy.AllowList
+	templates        *templator.Engine
 	logger           *slog.Logger
 
-	vmDeleteCounter  metric.Int64Counter
-	vmCloneCounter   metric.Int64Counter
-	vmCreateDuration metric.Float64Histogram
-	vmDeleteDuration metric.Float64Histogram
-	vmCloneDuration  metric.Float64Histogram
+	// createParallelism bounds concurrent VM creations within one cluster request (0 is unbounded).
+	createParallelism int
+	// vmInfos caches list-all queries; it is invalidated by every operation changing a VM.
+	vmInfos *vmInfoCache
+	// defineMu serializes domain definitions, as auto-pinning reads the pins of already defined domains.
+	defineMu sync.Mutex
+	// netbootMu serializes starting, stopping and recording netboot helpers.
+	netbootMu sync.Mutex
+	// clustersMu serializes updates of stored cluster specs.
+	clustersMu sync.Mutex
+	// vmLocks serializes deleting, migrating and redefining a single VM.
+	vmLocks vmLocks
+	// schedulesMu keeps a cancelled schedule from being written back by a run finishing concurrently.
+	schedulesMu sync.Mutex
+	// events is the event log, kept apart from store as it is appended to on every lifecycle event.
+	events *store.Log
+	// health is refreshed by CheckHealth and exported as gauges.
+	health healthSnapshot
+	// sshKeysMu serializes generating and deleting SSH keys.
+	sshKeysMu sync.Mutex
+	// readinessMu serializes updates of stored readiness results.
+	readinessMu sync.Mutex
+	// timelineMu serializes updates of stored provisioning timelines.
+	timelineMu sync.Mutex
+	// booting holds the started VMs whose boot BootWatcher is measuring, by name.
+	booting map[string]*bootWatch
+	bootMu  sync.Mutex
+	// jobs holds the running jobs and the newest finished ones by ID, finishedJobs the IDs of
+	// the finished ones, oldest first, and runningJobs the cancel functions of the running ones.
+	// jobLog records jobs when they start and finish; jobsMu serializes all four.
+	jobs         map[string]parameters.Job
+	finishedJobs []string
+	runningJobs  map[string]context.CancelFunc
+	jobLog       *store.Log
+	jobsMu       sync.Mutex
+	jobsWG       sync.WaitGroup
+	// quotas are enforced on create and clone; pendingVMs holds the VMs of requests in progress,
+	// by reservation.
+	quotas          []Quota
+	quotaMu         sync.Mutex
+	pendingVMs      map[uint64][]quotaVM
+	nextReservation uint64
+	// admission holds back VMs on loaded hosts; admittedKiB is the memory of admitted VMs
+	// not yet running, by host.
+	admission   AdmissionPolicy
+	admissionMu sync.Mutex
+	admittedKiB map[string]uint64
+
+	// fleetMu serializes fleet creation so fleets never draw the same MAC or pool address.
+	fleetMu sync.Mutex
+
+	// ipPools are the configured IP pools by name; addressMu serializes allocations from them.
+	ipPools   map[string]IPPool
+	addressMu sync.Mutex
+
+	// layout computes the disk and cloud-init ISO paths requests leave out.
+	layout PathLayout
+
+	vmDeleteCounter       metric.Int64Counter
+	vmCloneCounter        metric.Int64Counter
+	vmCreateDuration      metric.Float64Histogram
+	vmDeleteDuration      metric.Float64Histogram
+	vmCloneDuration       metric.Float64Histogram
+	reconcileDriftCounter metric.Int64Counter
+	vmExpiredCounter      metric.Int64Counter
+	vmBootDuration        metric.Float64Histogram
 }
 
 // NewVMService creates a new VMService.
 func NewVMService(
-	diskManager *disk.Manager,
-	cloudinitManager *cloudinit.Manager,
-	libvirtManager *libvirt.Manager,
-	connManager *pkglibvirt.ConnectionManager,
+	diskManager DiskManager,
+	cloudinitManager CloudInitManager,
+	netbootManager NetbootManager,
+	libvirtManager LibvirtManager,
+	hosts *pkglibvirt.HostPool,
+	stateStore *store.Store,
+	eventLog *store.Log,
+	jobLog *store.Log,
+	secretResolver *secrets.Resolver,
+	allowedPaths *pathpolicy.AllowList,
+	templates *templator.Engine,
+	createParallelism int,
+	queryCacheTTL time.Duration,
+	quotas []Quota,
+	admission AdmissionPolicy,
+	ipPools []IPPool,
+	layout PathLayout,
 	logger *slog.Logger,
 ) *VMService {
 	meter := otel.Meter("homonculus/service")
@@ -89,74 +175,271 @@ func NewVMService(
 		logger.Warn("failed to create vmCloneDuration metric", slog.String("error", err.Error()))
 	}
 
-	return &VMService{
-		diskManager:      diskManager,
-		cloudinitManager: cloudinitManager,
-		libvirtManager:   libvirtManager,
-		connManager:      connManager,
-		logger:           logger.With(slog.String("service", "vm")),
-		vmDeleteCounter:  vmDeleteCounter,
-		vmCloneCounter:   vmCloneCounter,
-		vmCreateDuration: vmCreateDuration,
-		vmDeleteDuration: vmDeleteDuration,
-		vmCloneDuration:  vmCloneDuration,
+	reconcileDriftCounter, err := meter.Int64Counter(
+		"homonculus.reconcile.drift",
+		metric.WithDescription("Number of drifted VMs detected by the reconciler"),
+		metric.WithUnit("{vm}"),
+	)
+	if err != nil {
+		logger.Warn("failed to create reconcileDriftCounter metric", slog.String("error", err.Error()))
+	}
+
+	vmExpiredCounter, err := meter.Int64Counter(
+		"homonculus.vm.expired",
+		metric.WithDescription("Number of expired VMs deleted or stopped by the reaper"),
+		metric.WithUnit("{vm}"),
+	)
+	if err != nil {
+		logger.Warn("failed to create vmExpiredCounter metric", slog.String("error", err.Error()))
+	}
+
+	vmBootDuration, err := meter.Float64Histogram(
+		"homonculus.vm.boot.duration",
+		metric.WithDescription("Time from starting a VM until it got a DHCP lease or its guest agent answered"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		logger.Warn("failed to create vmBootDuration metric", slog.String("error", err.Error()))
+	}
+
+	s := &VMService{
+		diskManager:           diskManager,
+		cloudinitManager:      cloudinitManager,
+		netbootManager:        netbootManager,
+		libvirtManager:        libvirtManager,
+		hosts:                 hosts,
+		store:                 stateStore,
+		events:                eventLog,
+		secrets:               secretResolver,
+		paths:                 allowedPaths,
+		templates:             templates,
+		createParallelism:     createParallelism,
+		vmInfos:               newVMInfoCache(queryCacheTTL),
+		quotas:                quotas,
+		pendingVMs:            make(map[uint64][]quotaVM),
+		admission:             admission,
+		admittedKiB:           make(map[string]uint64),
+		booting:               make(map[string]*bootWatch),
+		jobs:                  make(map[string]parameters.Job),
+		runningJobs:           make(map[string]context.CancelFunc),
+		jobLog:                jobLog,
+		ipPools:               make(map[string]IPPool, len(ipPools)),
+		layout:                layout,
+		logger:                logger.With(slog.String("service", "vm")),
+		vmDeleteCounter:       vmDeleteCounter,
+		vmCloneCounter:        vmCloneCounter,
+		vmCreateDuration:      vmCreateDuration,
+		vmDeleteDuration:      vmDeleteDuration,
+		vmCloneDuration:       vmCloneDuration,
+		reconcileDriftCounter: reconcileDriftCounter,
+		vmExpiredCounter:      vmExpiredCounter,
+		vmBootDuration:        vmBootDuration,
+	}
+	for _, pool := range ipPools {
+		s.ipPools[pool.Name] = pool
 	}
+	if err := s.registerHealthMetrics(meter); err != nil {
+		logger.Warn("failed to create health metrics", slog.String("error", err.Error()))
+	}
+	s.restoreJobs()
+	return s
 }
 
 // CreateCluster creates multiple VMs from transport-agnostic parameters.
-func (s *VMService) CreateCluster(ctx context.Context, vms []parameters.CreateVM) error {
+// Named clusters are persisted as desired state for the reconciler. VMs are provisioned
+// concurrently, up to the configured create parallelism.
+func (s *VMService) CreateCluster(ctx context.Context, cluster parameters.CreateCluster) error {
 	tracer := otel.Tracer("homonculus/service")
 	ctx, span := tracer.Start(ctx, "CreateCluster")
 	defer span.End()
 
-	span.SetAttributes(attribute.Int("vm.count", len(vms)))
-
-	conn, exec, unlock, err := s.connManager.GetHypervisor()
+	vms, err := expandVirtualMachines(cluster.VirtualMachines)
 	if err != nil {
-		return fmt.Errorf("failed to get hypervisor connection: %w", err)
+		return err
 	}
-	defer unlock()
-
-	hypervisor := dependencies.HypervisorContext{
-		URI:      s.connManager.GetURI(),
-		Conn:     conn,
-		Executor: exec,
+	qualifyCreateVMs(ctx, vms)
+	cluster.Name = qualify(ctx, cluster.Name)
+	if err := s.applyPathLayout(vms, cluster.Name); err != nil {
+		return err
+	}
+	if err := s.authorizeSSHKeys(cluster.SSHKeys, vms); err != nil {
+		return err
 	}
+	cluster.VirtualMachines = vms
 
-	var failedVMs []string
+	span.SetAttributes(attribute.Int("vm.count", len(cluster.VirtualMachines)))
 
-	for _, vm := range vms {
-		startTime := time.Now()
-		_, vmSpan := tracer.Start(ctx, "CreateVM")
-		vmSpan.SetAttributes(attribute.String("vm.name", vm.Name))
-
-		virtualMachineUUID := uuid.New()
+	for _, vm := range cluster.VirtualMachines {
+		if err := s.checkPaths(vm); err != nil {
+			return err
+		}
+	}
 
-		exists, err := s.libvirtManager.CheckVirtualMachineExistence(hypervisor, vm.Name)
+	owner := callerFromContext(ctx)
+	created := time.Now()
+	for i := range cluster.VirtualMachines {
+		cluster.VirtualMachines[i].Owner = owner
+		if cluster.Name != "" {
+			cluster.VirtualMachines[i].Labels = withClusterLabel(cluster.VirtualMachines[i].Labels, cluster.Name)
+		}
+		ownership, err := newOwnership(ctx, cluster.VirtualMachines[i], created)
 		if err != nil {
-			s.logger.Error("failed to check if VM exists",
-				slog.String("vm", vm.Name),
-				slog.String("error", err.Error()),
-			)
-			vmSpan.End()
-			failedVMs = append(failedVMs, vm.Name)
-			continue
+			return err
 		}
+		cluster.VirtualMachines[i].Ownership = ownership
+	}
 
-		if exists {
-			s.logger.Warn("VM already exists, skipping",
-				slog.String("vm", vm.Name),
-			)
-			vmSpan.End()
-			continue
+	release, err := s.reserveQuota(ctx, cluster.VirtualMachines)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := s.scheduleCluster(ctx, &cluster); err != nil {
+		return fmt.Errorf("failed to schedule VMs: %w", err)
+	}
+
+	if err := s.allocateAddresses(cluster.VirtualMachines); err != nil {
+		return err
+	}
+
+	if cluster.Name != "" {
+		if err := s.saveClusterSpec(cluster); err != nil {
+			return err
 		}
+	}
+
+	var (
+		mu        sync.Mutex
+		failedVMs []string
+		failures  []error
+		done      int
+	)
+
+	// A plain group rather than errgroup.WithContext: one failed VM must not abort its siblings.
+	var group errgroup.Group
+	if s.createParallelism > 0 {
+		group.SetLimit(s.createParallelism)
+	}
+
+	for _, vm := range cluster.VirtualMachines {
+		group.Go(func() error {
+			if ctx.Err() != nil {
+				if cluster.Name == "" {
+					s.releaseAddress(vm.Name)
+				}
+				return nil
+			}
+
+			// VMs that are only defined take no memory yet, but still wait out a loaded host.
+			var memoryKiB uint64
+			if vm.Start {
+				memoryKiB = units.MiBToKiB(uint64(vm.MemoryMB))
+			}
+			release, err := s.admit(ctx, vm.Host, vm.Name, memoryKiB)
+			if err == nil {
+				err = s.withHypervisor(ctx, vm.Host, func(hypervisor dependencies.HypervisorContext) error {
+					return s.createVirtualMachine(ctx, hypervisor, vm)
+				})
+				release()
+			}
+			if err == nil {
+				s.recordPlacement(cluster.Name, vm)
+				s.recordExpiry(cluster.Name, vm)
+				s.recordReadinessProbes(vm)
+				s.recordEvent(ctx, EventVMCreated, vm.Name, vm.Host, "created virtual machine", nil)
+			} else {
+				s.recordEvent(ctx, EventVMCreateFailed, vm.Name, vm.Host, "failed to create virtual machine", err)
+				// VMs of named clusters keep their address, since the reconciler recreates them from the spec.
+				if cluster.Name == "" {
+					s.releaseAddress(vm.Name)
+				}
+			}
 
+			mu.Lock()
+			defer mu.Unlock()
+			done++
+			if err != nil {
+				failedVMs = append(failedVMs, vm.Name)
+				failures = append(failures, err)
+			}
+			return nil
+		})
+	}
+	group.Wait()
+
+	if err := ctx.Err(); err != nil && done < len(cluster.VirtualMachines) {
+		return cancelledError("create", done, len(cluster.VirtualMachines), failedVMs, err)
+	}
+
+	if len(failedVMs) > 0 {
+		return &batchError{action: "create", vms: failedVMs, errs: failures}
+	}
+	return nil
+}
+
+// createVirtualMachine provisions disk, cloud-init ISO and domain definition for a single VM,
+// and starts it when requested. Existing VMs are skipped. On failure every completed step is
+// rolled back, unless the VM asks to keep its artifacts.
+func (s *VMService) createVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, vm parameters.CreateVM) error {
+	startTime := time.Now()
+	_, vmSpan := otel.Tracer("homonculus/service").Start(ctx, "CreateVM")
+	defer vmSpan.End()
+	vmSpan.SetAttributes(attribute.String("vm.name", vm.Name))
+
+	virtualMachineUUID := uuid.New()
+	defer s.vmInfos.invalidate()
+
+	exists, err := s.libvirtManager.CheckVirtualMachineExistence(hypervisor, vm.Name)
+	if err != nil {
+		s.logger.Error("failed to check if VM exists",
+			slog.String("vm", vm.Name),
+			slog.String("error", err.Error()),
+		)
+		return err
+	}
+
+	if exists {
+		s.logger.Warn("VM already exists, skipping",
+			slog.String("vm", vm.Name),
+		)
+		return nil
+	}
+
+	// Stored specs are checked again, in case the allow list was narrowed since they were saved.
+	if err := s.checkPaths(vm); err != nil {
+		s.logger.Error("rejected VM paths",
+			slog.String("vm", vm.Name),
+			slog.String("error", err.Error()),
+		)
+		return err
+	}
+
+	unresolved := vm
+	vm, err = s.resolveSecrets(ctx, vm)
+	if err != nil {
+		s.logger.Error("failed to resolve secrets",
+			slog.String("vm", vm.Name),
+			slog.String("error", err.Error()),
+		)
+		return err
+	}
+
+	s.startTimeline(vm.Name)
+	undo := newRollback(vm.Name)
+
+	// The journal lets startup recovery finish or undo a creation interrupted by a crash.
+	defer s.finishJournal(vm.Name)
+
+	// Network-booted VMs may run diskless.
+	if vm.DiskPath != "" {
 		s.logger.Info("creating VM disk",
 			slog.String("vm", vm.Name),
 			slog.String("uuid", virtualMachineUUID.String()),
 			slog.String("path", vm.DiskPath),
 			slog.Int64("size_gb", vm.DiskSizeGB),
 		)
+		s.journalStep(hypervisor, vm, startTime, stepDisk)
 
 		if err := s.diskManager.CreateDisk(ctx, hypervisor, vm); err != nil {
 			s.logger.Error("failed to create disk",
@@ -164,96 +447,223 @@ func (s *VMService) CreateCluster(ctx context.Context, vms []parameters.CreateVM
 				slog.String("uuid", virtualMachineUUID.String()),
 				slog.String("error", err.Error()),
 			)
-			vmSpan.End()
-			failedVMs = append(failedVMs, vm.Name)
-			continue
-		}
-
-		if vm.CloudInitISOPath != "" {
-			if err := s.cloudinitManager.CreateISO(ctx, hypervisor, vm, virtualMachineUUID); err != nil {
-				s.logger.Error("failed to create cloud-init ISO",
-					slog.String("vm", vm.Name),
-					slog.String("uuid", virtualMachineUUID.String()),
-					slog.String("error", err.Error()),
-				)
-				if err := fileops.RemoveFile(ctx, hypervisor.Executor, vm.DiskPath); err != nil {
-					s.logger.Warn("failed to cleanup disk",
-						slog.String("path", vm.DiskPath),
-						slog.String("error", err.Error()),
-					)
-				}
-				vmSpan.End()
-				failedVMs = append(failedVMs, vm.Name)
-				continue
+			// An aborted qemu-img or tar may leave a partial image behind.
+			if ctx.Err() != nil {
+				undo.push("disk "+vm.DiskPath, s.removeDiskStep(hypervisor, vm.DiskPath, isContainer(vm)))
 			}
-		} else {
-			s.logger.Debug("skipping cloud-init ISO creation", slog.String("vm", vm.Name))
+			s.rollBack(ctx, vm, undo)
+			return err
 		}
+		undo.push("disk "+vm.DiskPath, s.removeDiskStep(hypervisor, vm.DiskPath, isContainer(vm)))
+		s.recordPhase(vm.Name, PhaseDiskCreated)
+	} else {
+		s.logger.Debug("skipping disk creation for diskless VM", slog.String("vm", vm.Name))
+	}
 
-		if err := s.libvirtManager.CreateVirtualMachine(ctx, hypervisor, vm, virtualMachineUUID); err != nil {
-			s.logger.Error("failed to create VM",
+	if err := ctx.Err(); err != nil {
+		s.logger.Warn("VM creation aborted", slog.String("vm", vm.Name), slog.String("error", err.Error()))
+		s.rollBack(ctx, vm, undo)
+		return err
+	}
+
+	if isContainer(vm) {
+		// The seed lives in the root filesystem, so removing the disk undoes it.
+		if err := s.cloudinitManager.CreateSeed(ctx, hypervisor, vm, virtualMachineUUID); err != nil {
+			s.logger.Error("failed to create cloud-init seed",
 				slog.String("vm", vm.Name),
 				slog.String("uuid", virtualMachineUUID.String()),
 				slog.String("error", err.Error()),
 			)
-			if err := fileops.RemoveFile(ctx, hypervisor.Executor, vm.DiskPath); err != nil {
-				s.logger.Warn("failed to cleanup disk",
-					slog.String("path", vm.DiskPath),
-					slog.String("error", err.Error()),
-				)
-			}
-			if vm.CloudInitISOPath != "" {
-				if err := fileops.RemoveFile(ctx, hypervisor.Executor, vm.CloudInitISOPath); err != nil {
-					s.logger.Warn("failed to cleanup cloud-init ISO",
-						slog.String("path", vm.CloudInitISOPath),
-						slog.String("error", err.Error()),
-					)
-				}
-			}
-			vmSpan.End()
-			failedVMs = append(failedVMs, vm.Name)
-			continue
+			s.rollBack(ctx, vm, undo)
+			return err
+		}
+	} else if vm.CloudInitISOPath != "" {
+		s.journalStep(hypervisor, vm, startTime, stepISO)
+		if err := s.cloudinitManager.CreateISO(ctx, hypervisor, vm, virtualMachineUUID); err != nil {
+			s.logger.Error("failed to create cloud-init ISO",
+				slog.String("vm", vm.Name),
+				slog.String("uuid", virtualMachineUUID.String()),
+				slog.String("error", err.Error()),
+			)
+			undo.push("cloud-init ISO "+vm.CloudInitISOPath, s.removeFileStep(hypervisor, vm.CloudInitISOPath))
+			s.rollBack(ctx, vm, undo)
+			return err
+		}
+		undo.push("cloud-init ISO "+vm.CloudInitISOPath, s.removeFileStep(hypervisor, vm.CloudInitISOPath))
+		s.recordPhase(vm.Name, PhaseISOCreated)
+	} else {
+		s.logger.Debug("skipping cloud-init ISO creation", slog.String("vm", vm.Name))
+	}
+
+	if err := ctx.Err(); err != nil {
+		s.logger.Warn("VM creation aborted", slog.String("vm", vm.Name), slog.String("error", err.Error()))
+		s.rollBack(ctx, vm, undo)
+		return err
+	}
+
+	if vm.Netboot != nil && vm.Netboot.Server != nil {
+		if err := s.acquireNetbootServer(ctx, hypervisor, vm); err != nil {
+			s.logger.Error("failed to start netboot server",
+				slog.String("vm", vm.Name),
+				slog.String("bridge", vm.BridgeNetworkInterface),
+				slog.String("error", err.Error()),
+			)
+			s.rollBack(ctx, vm, undo)
+			return err
 		}
+		undo.push("netboot server", func(ctx context.Context) error {
+			s.releaseNetbootServer(ctx, hypervisor, vm.Name)
+			return nil
+		})
+	}
 
-		s.logger.Info("successfully created VM",
+	s.journalStep(hypervisor, vm, startTime, stepDomain)
+	s.defineMu.Lock()
+	err = s.libvirtManager.CreateVirtualMachine(ctx, hypervisor, vm, virtualMachineUUID)
+	s.defineMu.Unlock()
+	if err != nil {
+		s.logger.Error("failed to create VM",
 			slog.String("vm", vm.Name),
 			slog.String("uuid", virtualMachineUUID.String()),
+			slog.String("error", err.Error()),
 		)
-		if s.vmCreateDuration != nil {
-			s.vmCreateDuration.Record(ctx, time.Since(startTime).Seconds(), metric.WithAttributes(
-				attribute.String("vm.name", vm.Name),
-			))
+		s.rollBack(ctx, vm, undo)
+		return err
+	}
+	undo.push("domain definition", func(ctx context.Context) error {
+		return s.libvirtManager.UndefineVirtualMachine(ctx, hypervisor, vm.Name)
+	})
+	s.recordPhase(vm.Name, PhaseDefined)
+
+	if vm.Start {
+		s.journalStep(hypervisor, vm, startTime, stepStart)
+		if err := s.libvirtManager.StartVirtualMachine(ctx, hypervisor, parameters.StartVM{Name: vm.Name}); err != nil {
+			s.logger.Error("failed to start VM",
+				slog.String("vm", vm.Name),
+				slog.String("uuid", virtualMachineUUID.String()),
+				slog.String("error", err.Error()),
+			)
+			if !s.rollBack(ctx, vm, undo) {
+				s.recordFailedVM(hypervisor, vm, err)
+			}
+			return err
 		}
-		vmSpan.End()
+		s.recordPhase(vm.Name, PhaseStarted)
+		s.watchBoot(ctx, vm.Name, hypervisor.Host, vm.BaseImagePath)
 	}
 
-	if len(failedVMs) > 0 {
-		return fmt.Errorf("failed to create %d VM(s): %v", len(failedVMs), failedVMs)
+	if isContainer(vm) || vm.CloudInitISOPath != "" {
+		s.recordCloudInit(unresolved, virtualMachineUUID)
+	}
+	s.forgetFailedVM(vm.Name)
+
+	s.logger.Info("successfully created VM",
+		slog.String("vm", vm.Name),
+		slog.String("uuid", virtualMachineUUID.String()),
+	)
+	if s.vmCreateDuration != nil {
+		s.vmCreateDuration.Record(ctx, time.Since(startTime).Seconds(), metric.WithAttributes(
+			attribute.String("vm.name", vm.Name),
+		))
 	}
 	return nil
 }
 
+// isContainer reports whether vm is an LXC system container rather than a KVM guest.
+func isContainer(vm parameters.CreateVM) bool {
+	return vm.MachineType == string(constants.MACHINE_TYPE_CONTAINER)
+}
+
+// batchError reports the VMs a cluster operation failed for. It unwraps to the error of every
+// failed VM, so errors.Is and errors.As see their kinds.
+type batchError struct {
+	action string
+	vms    []string
+	errs   []error
+}
+
+func (e *batchError) Error() string {
+	return fmt.Sprintf("failed to %s %d VM(s): %v", e.action, len(e.vms), e.vms)
+}
+
+func (e *batchError) Unwrap() []error {
+	return e.errs
+}
+
+// cancelledError reports a cluster operation stopped by ctx after done of total VMs.
+func cancelledError(action string, done, total int, failedVMs []string, err error) error {
+	if len(failedVMs) > 0 {
+		return fmt.Errorf("%s cancelled after %d of %d VM(s), %d failed %v: %w", action, done, total, len(failedVMs), failedVMs, err)
+	}
+	return fmt.Errorf("%s cancelled after %d of %d VM(s): %w", action, done, total, err)
+}
+
 // DeleteCluster deletes multiple VMs.
 func (s *VMService) DeleteCluster(ctx context.Context, vms []parameters.DeleteVM) error {
-	conn, exec, unlock, err := s.connManager.GetHypervisor()
-	if err != nil {
-		return fmt.Errorf("failed to get hypervisor connection: %w", err)
-	}
-	defer unlock()
+	return s.deleteVirtualMachines(ctx, vms, nil)
+}
 
-	hypervisor := dependencies.HypervisorContext{
-		URI:      s.connManager.GetURI(),
-		Conn:     conn,
-		Executor: exec,
+// DeleteVirtualMachineIf deletes a VM if precondition accepts its current state, returning the
+// error of precondition otherwise. The VM is locked from the check to the deletion, so homonculus
+// does not change it in between.
+func (s *VMService) DeleteVirtualMachineIf(ctx context.Context, name string, precondition func(parameters.VMInfo) error) error {
+	return s.deleteVirtualMachines(ctx, []parameters.DeleteVM{{Name: name}}, precondition)
+}
+
+// deleteVirtualMachines deletes VMs, checking each against precondition first unless it is nil.
+func (s *VMService) deleteVirtualMachines(ctx context.Context, vms []parameters.DeleteVM, precondition func(parameters.VMInfo) error) error {
+	vms = slices.Clone(vms)
+	for i := range vms {
+		vms[i].Name = qualify(ctx, vms[i].Name)
 	}
 
 	var failedVMs []string
+	var failures []error
+
+	// Finding the disks other VMs use reads every disk of the hypervisor, so it is done once per hypervisor.
+	references := make(map[string]parameters.DiskReferences)
+
+	for i, vm := range vms {
+		if err := ctx.Err(); err != nil {
+			return cancelledError("delete", i, len(vms), failedVMs, err)
+		}
 
-	for _, vm := range vms {
 		startTime := time.Now()
 		s.logger.Info("deleting VM", slog.String("vm", vm.Name))
 
-		if vmUUID, err := s.libvirtManager.DeleteVirtualMachine(ctx, hypervisor, vm); err != nil {
+		unlock := s.vmLocks.lock(vm.Name)
+		if precondition != nil {
+			if err := s.checkVirtualMachine(ctx, vm.Name, precondition); err != nil {
+				unlock()
+				return err
+			}
+		}
+		var vmUUID, host string
+		err := s.withVirtualMachineHypervisor(ctx, vm.Name, func(hypervisor dependencies.HypervisorContext) error {
+			defer s.vmInfos.invalidate()
+			host = hypervisor.Host
+			metadata, err := s.checkOwnership(ctx, hypervisor, vm.Name)
+			if err != nil {
+				return err
+			}
+			// Homonculus did not create the disks of adopted VMs, so it leaves them alone.
+			vm.KeepDisks = metadata.Adopted
+			hostReferences, ok := references[hypervisor.Host]
+			if !ok {
+				if hostReferences, err = s.libvirtManager.DiskReferences(ctx, hypervisor); err != nil {
+					return err
+				}
+				references[hypervisor.Host] = hostReferences
+			}
+			if vmUUID, err = s.libvirtManager.DeleteVirtualMachine(ctx, hypervisor, vm, hostReferences); err != nil {
+				return err
+			}
+			s.releaseNetbootServer(ctx, hypervisor, vm.Name)
+			return nil
+		})
+		unlock()
+		if err != nil {
+			s.recordEvent(ctx, EventVMDeleteFailed, vm.Name, host, "failed to delete virtual machine", err)
 			s.logger.Error("failed to delete VM",
 				slog.String("vm", vm.Name),
 				slog.String("uuid", vmUUID),
@@ -265,10 +675,27 @@ func (s *VMService) DeleteCluster(ctx context.Context, vms []parameters.DeleteVM
 				))
 			}
 			failedVMs = append(failedVMs, vm.Name)
+			failures = append(failures, err)
 			continue
 		}
 
 		s.logger.Info("successfully deleted VM", slog.String("vm", vm.Name))
+		s.recordEvent(ctx, EventVMDeleted, vm.Name, host, "deleted virtual machine", nil)
+		s.forgetPlacement(vm.Name)
+		s.forgetExpiry(vm.Name)
+		s.forgetReadiness(vm.Name)
+		s.forgetTimeline(vm.Name)
+		s.forgetBoot(vm.Name)
+		s.releaseAddress(vm.Name)
+		s.forgetCloudInit(vm.Name)
+		s.forgetFailedVM(vm.Name)
+		s.forgetAdoption(vm.Name)
+		if err := s.forgetVirtualMachine(vm.Name); err != nil {
+			s.logger.Warn("failed to remove VM from stored cluster spec",
+				slog.String("vm", vm.Name),
+				slog.String("error", err.Error()),
+			)
+		}
 		if s.vmDeleteCounter != nil {
 			s.vmDeleteCounter.Add(ctx, 1, metric.WithAttributes(
 				attribute.String("status", "success"),
@@ -282,98 +709,251 @@ func (s *VMService) DeleteCluster(ctx context.Context, vms []parameters.DeleteVM
 	}
 
 	if len(failedVMs) > 0 {
-		return fmt.Errorf("failed to delete %d VM(s): %v", len(failedVMs), failedVMs)
+		return &batchError{action: "delete", vms: failedVMs, errs: failures}
 	}
 	return nil
 }
 
-// StartCluster starts multiple VMs.
-func (s *VMService) StartCluster(ctx context.Context, vms []parameters.StartVM) error {
-	conn, exec, unlock, err := s.connManager.GetHypervisor()
+// checkVirtualMachine runs precondition against the current state of a VM.
+func (s *VMService) checkVirtualMachine(ctx context.Context, name string, precondition func(parameters.VMInfo) error) error {
+	vmInfo, found, err := s.GetVirtualMachine(ctx, name)
 	if err != nil {
-		return fmt.Errorf("failed to get hypervisor connection: %w", err)
+		return err
 	}
-	defer unlock()
+	if !found {
+		return fmt.Errorf("%w: %s", errdefs.ErrVMNotFound, localName(ctx, name))
+	}
+	return precondition(vmInfo)
+}
 
-	hypervisor := dependencies.HypervisorContext{
-		URI:      s.connManager.GetURI(),
-		Conn:     conn,
-		Executor: exec,
+// StartCluster starts multiple VMs. VMs start after the VMs they depend on, tier by tier,
+// and each tier waits up to readyTimeout for the VMs of the previous tier to become ready.
+func (s *VMService) StartCluster(ctx context.Context, vms []parameters.StartVM, readyTimeout time.Duration) error {
+	vms = slices.Clone(vms)
+	names := make([]string, len(vms))
+	dependsOn := make([][]string, len(vms))
+	for i := range vms {
+		vms[i].Name = qualify(ctx, vms[i].Name)
+		vms[i].DependsOn = qualifyAll(ctx, vms[i].DependsOn)
+		vm := vms[i]
+		names[i] = vm.Name
+		dependsOn[i] = vm.DependsOn
+	}
+	tiers, dependencies, err := dependencyTiers(names, dependsOn)
+	if err != nil {
+		return err
 	}
 
-	var failedVMs []string
+	gate := func(ctx context.Context, names []string) error {
+		return s.WaitForReady(ctx, names, readyTimeout)
+	}
+	return s.runTiers(ctx, "start", names, tiers, dependencies, func(i int) error {
+		return s.startVirtualMachine(ctx, vms[i])
+	}, gate)
+}
 
-	for _, vm := range vms {
-		s.logger.Info("starting VM", slog.String("vm", vm.Name))
+// startVirtualMachine starts a VM once its host admits it.
+func (s *VMService) startVirtualMachine(ctx context.Context, vm parameters.StartVM) error {
+	s.logger.Info("starting VM", slog.String("vm", vm.Name))
+
+	host := s.locateVirtualMachine(ctx, vm.Name)
+	release, err := s.admitStart(ctx, host, vm.Name)
+	if err == nil {
+		err = s.withHypervisor(ctx, host, func(hypervisor dependencies.HypervisorContext) error {
+			defer s.vmInfos.invalidate()
+			return s.libvirtManager.StartVirtualMachine(ctx, hypervisor, vm)
+		})
+		release()
+	}
+	if err != nil {
+		s.recordEvent(ctx, EventVMStartFailed, vm.Name, host, "failed to start virtual machine", err)
+		s.logger.Error("failed to start VM",
+			slog.String("vm", vm.Name),
+			slog.String("error", err.Error()),
+		)
+		return err
+	}
 
-		if err := s.libvirtManager.StartVirtualMachine(ctx, hypervisor, vm); err != nil {
-			s.logger.Error("failed to start VM",
-				slog.String("vm", vm.Name),
-				slog.String("error", err.Error()),
-			)
-			failedVMs = append(failedVMs, vm.Name)
-			continue
-		}
+	s.logger.Info("successfully started VM", slog.String("vm", vm.Name))
+	s.resetReadiness(vm.Name)
+	s.recordPhase(vm.Name, PhaseStarted)
+	s.watchBoot(ctx, vm.Name, host, s.baseImageOf(vm.Name))
+	s.recordEvent(ctx, EventVMStarted, vm.Name, host, "started virtual machine", nil)
+	return nil
+}
 
-		s.logger.Info("successfully started VM", slog.String("vm", vm.Name))
+// StopCluster gracefully shuts down multiple VMs. VMs stop before the VMs they depend on,
+// tier by tier, and each tier waits up to shutdownTimeout for the previous tier to shut off.
+func (s *VMService) StopCluster(ctx context.Context, vms []parameters.StopVM, shutdownTimeout time.Duration) error {
+	vms = slices.Clone(vms)
+	names := make([]string, len(vms))
+	dependsOn := make([][]string, len(vms))
+	for i := range vms {
+		vms[i].Name = qualify(ctx, vms[i].Name)
+		vms[i].DependsOn = qualifyAll(ctx, vms[i].DependsOn)
+		vm := vms[i]
+		names[i] = vm.Name
+		dependsOn[i] = vm.DependsOn
+	}
+	tiers, dependencies, err := dependencyTiers(names, dependsOn)
+	if err != nil {
+		return err
 	}
+	tiers, dependents := reverseTiers(tiers, dependencies)
 
-	if len(failedVMs) > 0 {
-		return fmt.Errorf("failed to start %d VM(s): %v", len(failedVMs), failedVMs)
+	gate := func(ctx context.Context, names []string) error {
+		return s.WaitForShutoff(ctx, names, shutdownTimeout)
 	}
+	return s.runTiers(ctx, "stop", names, tiers, dependents, func(i int) error {
+		return s.stopVirtualMachine(ctx, vms[i])
+	}, gate)
+}
+
+// stopVirtualMachine requests the shutdown of a VM.
+func (s *VMService) stopVirtualMachine(ctx context.Context, vm parameters.StopVM) error {
+	s.logger.Info("stopping VM", slog.String("vm", vm.Name))
+
+	var host string
+	err := s.withVirtualMachineHypervisor(ctx, vm.Name, func(hypervisor dependencies.HypervisorContext) error {
+		defer s.vmInfos.invalidate()
+		host = hypervisor.Host
+		return s.libvirtManager.StopVirtualMachine(ctx, hypervisor, vm)
+	})
+	if err != nil {
+		s.recordEvent(ctx, EventVMStopFailed, vm.Name, host, "failed to stop virtual machine", err)
+		s.logger.Error("failed to stop VM",
+			slog.String("vm", vm.Name),
+			slog.String("error", err.Error()),
+		)
+		return err
+	}
+
+	s.logger.Info("successfully stopped VM", slog.String("vm", vm.Name))
+	s.resetReadiness(vm.Name)
+	s.recordEvent(ctx, EventVMStopped, vm.Name, host, "stopped virtual machine", nil)
 	return nil
 }
 
-// QueryCluster queries information about multiple VMs.
-// If vms is empty, it lists all VMs. Otherwise, it queries specific VMs.
-func (s *VMService) QueryCluster(ctx context.Context, vms []parameters.QueryVM) ([]parameters.VMInfo, error) {
-	conn, exec, unlock, err := s.connManager.GetHypervisor()
+// SelectVirtualMachines returns information about all VMs whose labels match the selector.
+// Domains homonculus did not define are never selected.
+// Scoped to a namespace, the selector is matched against the VMs of the namespace only.
+func (s *VMService) SelectVirtualMachines(ctx context.Context, selector labels.Selector) ([]parameters.VMInfo, error) {
+	allVMInfos, err := s.cachedListAllVirtualMachines(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get hypervisor connection: %w", err)
+		return nil, fmt.Errorf("failed to list VMs: %w", err)
 	}
-	defer unlock()
 
-	hypervisor := dependencies.HypervisorContext{
-		URI:      s.connManager.GetURI(),
-		Conn:     conn,
-		Executor: exec,
+	var vmInfos []parameters.VMInfo
+	for _, vmInfo := range scopeVMInfos(ctx, managedVMInfos(allVMInfos)) {
+		if selector.Matches(vmInfo.Labels) {
+			vmInfos = append(vmInfos, vmInfo)
+		}
 	}
+	s.attachReadiness(vmInfos)
+	s.attachTimelines(vmInfos)
+	s.attachBootTimes(vmInfos)
+
+	s.logger.Debug("selected VMs",
+		slog.String("selector", selector.String()),
+		slog.Int("count", len(vmInfos)),
+	)
+	return vmInfos, nil
+}
 
+// QueryCluster queries information about multiple VMs.
+// If vms is empty, it lists all VMs homonculus defined. Otherwise, it queries specific VMs.
+func (s *VMService) QueryCluster(ctx context.Context, vms []parameters.QueryVM) ([]parameters.VMInfo, error) {
 	var vmInfos []parameters.VMInfo
 	var failedVMs []string
+	var failures []error
 
 	// If no VMs specified, list all VMs
 	if len(vms) == 0 {
 		s.logger.Debug("listing all VMs")
 
-		apiVMInfos, err := s.libvirtManager.ListAllVirtualMachines(ctx, hypervisor)
+		var err error
+		vmInfos, err = s.cachedListAllVirtualMachines(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("failed to list VMs: %w", err)
 		}
+		vmInfos = scopeVMInfos(ctx, managedVMInfos(vmInfos))
+		s.attachReadiness(vmInfos)
+		s.attachTimelines(vmInfos)
+		s.attachBootTimes(vmInfos)
 
-		s.logger.Info("listed all VMs", slog.Int("count", len(apiVMInfos)))
+		s.logger.Info("listed all VMs", slog.Int("count", len(vmInfos)))
 		return vmInfos, nil
 	}
 
 	// Query specific VMs
 	for _, vm := range vms {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		vm.Name = qualify(ctx, vm.Name)
+
 		s.logger.Debug("querying VM", slog.String("vm", vm.Name))
 
-		apiVMInfo, err := s.libvirtManager.GetVirtualMachineInfo(ctx, hypervisor, vm)
+		var apiVMInfo parameters.VMInfo
+		err := s.withVirtualMachineHypervisor(ctx, vm.Name, func(hypervisor dependencies.HypervisorContext) error {
+			var err error
+			apiVMInfo, err = s.libvirtManager.GetVirtualMachineInfo(ctx, hypervisor, vm)
+			apiVMInfo.Host = hypervisor.Host
+			return err
+		})
 		if err != nil {
 			s.logger.Error("failed to query VM",
 				slog.String("vm", vm.Name),
 				slog.String("error", err.Error()),
 			)
 			failedVMs = append(failedVMs, vm.Name)
+			failures = append(failures, err)
 			continue
 		}
 
 		s.logger.Debug("successfully queried VM", slog.String("vm", apiVMInfo.Name), slog.String("state", apiVMInfo.State))
+		vmInfos = append(vmInfos, apiVMInfo)
 	}
+	s.attachReadiness(vmInfos)
+	s.attachTimelines(vmInfos)
+	s.attachBootTimes(vmInfos)
+	vmInfos = scopeVMInfos(ctx, vmInfos)
 
 	if len(failedVMs) > 0 {
-		return vmInfos, fmt.Errorf("failed to query %d VM(s): %v", len(failedVMs), failedVMs)
+		return vmInfos, &batchError{action: "query", vms: failedVMs, errs: failures}
 	}
 	return vmInfos, nil
 }
+
+// GetVirtualMachine queries a single VM, bypassing the list cache. It reports false if the VM does not exist.
+func (s *VMService) GetVirtualMachine(ctx context.Context, name string) (parameters.VMInfo, bool, error) {
+	name = qualify(ctx, name)
+	var vmInfo parameters.VMInfo
+	found := false
+	err := s.withVirtualMachineHypervisor(ctx, name, func(hypervisor dependencies.HypervisorContext) error {
+		exists, err := s.libvirtManager.CheckVirtualMachineExistence(hypervisor, name)
+		if err != nil || !exists {
+			return err
+		}
+
+		vmInfo, err = s.libvirtManager.GetVirtualMachineInfo(ctx, hypervisor, parameters.QueryVM{Name: name})
+		vmInfo.Host = hypervisor.Host
+		found = err == nil
+		return err
+	})
+	if err != nil {
+		return parameters.VMInfo{}, false, fmt.Errorf("failed to query VM %s: %w", name, err)
+	}
+	if found {
+		vmInfos := []parameters.VMInfo{vmInfo}
+		s.attachReadiness(vmInfos)
+		s.attachTimelines(vmInfos)
+		s.attachBootTimes(vmInfos)
+		vmInfos = scopeVMInfos(ctx, managedVMInfos(vmInfos))
+		if len(vmInfos) == 0 {
+			return parameters.VMInfo{}, false, nil
+		}
+		vmInfo = vmInfos[0]
+	}
+	return vmInfo, found, nil
+}
@@ -0,0 +1,172 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/terabiome/homonculus/internal/dependencies"
+	"github.com/terabiome/homonculus/internal/service"
+	"github.com/terabiome/homonculus/internal/service/infrastructure/fake"
+	"github.com/terabiome/homonculus/internal/service/parameters"
+	"github.com/terabiome/homonculus/internal/store"
+	pkglibvirt "github.com/terabiome/homonculus/pkg/libvirt"
+	"github.com/terabiome/homonculus/pkg/pathpolicy"
+	"github.com/terabiome/homonculus/pkg/secrets"
+	"github.com/terabiome/homonculus/pkg/templator"
+)
+
+// testHost is the single fake host of the services built by newTestService.
+const testHost = "local"
+
+// newTestService creates a VMService on one fake host, keeping its state in a temporary directory.
+// libvirtManager is usually hypervisor itself, or a wrapper of it injecting failures.
+func newTestService(t *testing.T, hypervisor *fake.Hypervisor, libvirtManager service.LibvirtManager) *service.VMService {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+
+	hosts := pkglibvirt.NewHostPool()
+	if err := hosts.Add(testHost, pkglibvirt.NewOfflineConnectionManager("test:///default", fake.NewExecutor(logger), logger)); err != nil {
+		t.Fatal(err)
+	}
+	stateStore, err := store.Open(filepath.Join(dir, "state.json"), nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventLog, err := store.OpenLog(filepath.Join(dir, "events.jsonl"), "events", 100, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobLog, err := store.OpenLog(filepath.Join(dir, "jobs.jsonl"), "jobs", 2*service.MaxJobs, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowedPaths, err := pathpolicy.NewAllowList(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := service.NewVMService(
+		hypervisor,
+		hypervisor,
+		hypervisor,
+		libvirtManager,
+		hosts,
+		stateStore,
+		eventLog,
+		jobLog,
+		secrets.NewResolver(secrets.EnvProvider{}),
+		allowedPaths,
+		templator.NewEngine(),
+		0,
+		0,
+		nil,
+		service.AdmissionPolicy{},
+		nil,
+		service.PathLayout{},
+		logger,
+	)
+	t.Cleanup(func() {
+		s.CancelJobs()
+		s.WaitForJobs(context.Background())
+	})
+	return s
+}
+
+// testVM returns the spec of a small VM started on creation.
+func testVM(t *testing.T, name string) parameters.CreateVM {
+	return parameters.CreateVM{
+		Name:      name,
+		VCPUCount: 1,
+		MemoryMB:  512,
+		DiskPath:  filepath.Join(t.TempDir(), name+".qcow2"),
+		Start:     true,
+	}
+}
+
+func TestCreateQueryDeleteCluster(t *testing.T) {
+	ctx := context.Background()
+	hypervisor := fake.NewHypervisor(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s := newTestService(t, hypervisor, hypervisor)
+
+	cluster := parameters.CreateCluster{
+		Name:            "web",
+		VirtualMachines: []parameters.CreateVM{testVM(t, "web-1"), testVM(t, "web-2")},
+	}
+	if err := s.CreateCluster(ctx, cluster); err != nil {
+		t.Fatalf("CreateCluster: %v", err)
+	}
+
+	vmInfos, err := s.QueryCluster(ctx, []parameters.QueryVM{{Name: "web-1"}})
+	if err != nil {
+		t.Fatalf("QueryCluster: %v", err)
+	}
+	if len(vmInfos) != 1 || vmInfos[0].State != "running" || vmInfos[0].Host != testHost {
+		t.Fatalf("QueryCluster(web-1) = %+v, want web-1 running on %s", vmInfos, testHost)
+	}
+	if got := vmInfos[0].Labels[service.LabelCluster]; got != "web" {
+		t.Errorf("cluster label of web-1 = %q, want %q", got, "web")
+	}
+
+	vmInfos, err = s.QueryCluster(ctx, nil)
+	if err != nil {
+		t.Fatalf("QueryCluster(all): %v", err)
+	}
+	if len(vmInfos) != 2 {
+		t.Fatalf("QueryCluster(all) listed %d VMs, want 2", len(vmInfos))
+	}
+	for _, vmInfo := range vmInfos {
+		if len(vmInfo.Timeline) == 0 {
+			t.Errorf("QueryCluster(all) left out the provisioning timeline of %s", vmInfo.Name)
+		}
+	}
+
+	if err := s.DeleteCluster(ctx, []parameters.DeleteVM{{Name: "web-1"}}); err != nil {
+		t.Fatalf("DeleteCluster: %v", err)
+	}
+	if _, found, err := s.GetVirtualMachine(ctx, "web-1"); err != nil || found {
+		t.Errorf("GetVirtualMachine(web-1) after delete = found %v, error %v; want not found", found, err)
+	}
+	if _, found, err := s.GetVirtualMachine(ctx, "web-2"); err != nil || !found {
+		t.Errorf("GetVirtualMachine(web-2) = found %v, error %v; want found", found, err)
+	}
+}
+
+// failingStart is a fake hypervisor whose VMs fail to start.
+type failingStart struct {
+	*fake.Hypervisor
+}
+
+var errStart = errors.New("start failed")
+
+func (f failingStart) StartVirtualMachine(ctx context.Context, hypervisor dependencies.HypervisorContext, params parameters.StartVM) error {
+	return errStart
+}
+
+func TestCreateRollsBackFailedVM(t *testing.T) {
+	ctx := context.Background()
+	hypervisor := fake.NewHypervisor(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s := newTestService(t, hypervisor, failingStart{hypervisor})
+
+	err := s.CreateCluster(ctx, parameters.CreateCluster{VirtualMachines: []parameters.CreateVM{testVM(t, "db-1")}})
+	if !errors.Is(err, errStart) {
+		t.Fatalf("CreateCluster error = %v, want %v", err, errStart)
+	}
+	if _, found, err := s.GetVirtualMachine(ctx, "db-1"); err != nil || found {
+		t.Errorf("GetVirtualMachine(db-1) after rollback = found %v, error %v; want not found", found, err)
+	}
+
+	// A VM with KeepArtifactsOnFailure stays defined for inspection.
+	vm := testVM(t, "db-2")
+	vm.KeepArtifactsOnFailure = true
+	if err := s.CreateCluster(ctx, parameters.CreateCluster{VirtualMachines: []parameters.CreateVM{vm}}); !errors.Is(err, errStart) {
+		t.Fatalf("CreateCluster error = %v, want %v", err, errStart)
+	}
+	if _, found, err := s.GetVirtualMachine(ctx, "db-2"); err != nil || !found {
+		t.Errorf("GetVirtualMachine(db-2) = found %v, error %v; want found", found, err)
+	}
+}
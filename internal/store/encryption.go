@@ -0,0 +1,170 @@
+package store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// scrypt parameters deriving the AES-256 key of a passphrase, as recommended for interactive use.
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	saltSize     = 16
+	derivedBytes = 32
+)
+
+// sealedValue is the on-disk form of an encrypted value: the ID of the key it was sealed
+// with, the scrypt salt its AES key was derived with, and the random nonce followed by the
+// AES-256-GCM ciphertext.
+type sealedValue struct {
+	Sealed *sealedData `json:"$sealed"`
+}
+
+type sealedData struct {
+	Key  string `json:"key"`
+	Salt []byte `json:"salt"`
+	Data []byte `json:"data"`
+}
+
+// Key is a state encryption key. Its ID is recorded with every value sealed with it, so the
+// key needed to open a value is known after the keys were rotated.
+type Key struct {
+	ID         string
+	Passphrase string
+}
+
+// Keyring encrypts the values of the store at rest with AES-256-GCM, under keys derived from
+// passphrases with scrypt. The first key seals, with a salt drawn when the keyring is created;
+// every key opens, so values sealed before a rotation or a restart stay readable until they
+// are sealed again.
+type Keyring struct {
+	primary     string
+	salt        []byte
+	passphrases map[string]string
+
+	mu    sync.Mutex
+	aeads map[derivation]cipher.AEAD
+}
+
+// derivation identifies a derived key by the ID of its passphrase and its salt.
+type derivation struct {
+	id   string
+	salt string
+}
+
+// NewKeyring creates a keyring from keys, the sealing key first. It returns nil without keys,
+// which leaves the store unencrypted.
+func NewKeyring(keys []Key) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	keyring := &Keyring{
+		primary:     keys[0].ID,
+		salt:        make([]byte, saltSize),
+		passphrases: make(map[string]string, len(keys)),
+		aeads:       make(map[derivation]cipher.AEAD),
+	}
+	for _, key := range keys {
+		if key.ID == "" || key.Passphrase == "" {
+			return nil, errors.New("state encryption keys need an id and a key")
+		}
+		if _, ok := keyring.passphrases[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate state encryption key id %s", key.ID)
+		}
+		keyring.passphrases[key.ID] = key.Passphrase
+	}
+	if _, err := rand.Read(keyring.salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	if _, err := keyring.aead(keyring.primary, keyring.salt); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+// aead returns the cipher of the key id derived with salt, deriving it on first use.
+func (k *Keyring) aead(id string, salt []byte) (cipher.AEAD, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if aead, ok := k.aeads[derivation{id, string(salt)}]; ok {
+		return aead, nil
+	}
+	passphrase, ok := k.passphrases[id]
+	if !ok {
+		return nil, fmt.Errorf("value is encrypted with unknown key %s", id)
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, derivedBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key %s: %w", id, err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	k.aeads[derivation{id, string(salt)}] = aead
+	return aead, nil
+}
+
+// seal encrypts the value stored under location with the first key. The location is
+// authenticated, so a sealed value cannot be moved to another key or bucket.
+func (k *Keyring) seal(location string, plaintext json.RawMessage) (json.RawMessage, error) {
+	aead, err := k.aead(k.primary, k.salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return json.Marshal(sealedValue{Sealed: &sealedData{
+		Key:  k.primary,
+		Salt: k.salt,
+		Data: aead.Seal(nonce, nonce, plaintext, []byte(location)),
+	}})
+}
+
+// openValue returns the plaintext of a value read from disk and whether it is stale: stored
+// unencrypted, or sealed other than seal would seal it now. A nil keyring cannot open sealed values.
+func (k *Keyring) openValue(location string, raw json.RawMessage) (json.RawMessage, bool, error) {
+	var sealed sealedValue
+	if len(raw) == 0 || raw[0] != '{' || json.Unmarshal(raw, &sealed) != nil || sealed.Sealed == nil {
+		return raw, true, nil
+	}
+	if k == nil {
+		return nil, false, errors.New("value is encrypted, but no state encryption keys are configured")
+	}
+
+	if len(sealed.Sealed.Salt) == 0 {
+		return nil, false, fmt.Errorf("value sealed with key %s has no salt", sealed.Sealed.Key)
+	}
+	aead, err := k.aead(sealed.Sealed.Key, sealed.Sealed.Salt)
+	if err != nil {
+		return nil, false, err
+	}
+	data := sealed.Sealed.Data
+	if len(data) < aead.NonceSize() {
+		return nil, false, errors.New("ciphertext too short")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(location))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decrypt with key %s: %w", sealed.Sealed.Key, err)
+	}
+	stale := sealed.Sealed.Key != k.primary || !bytes.Equal(sealed.Sealed.Salt, k.salt)
+	return plaintext, stale, nil
+}
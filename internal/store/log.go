@@ -0,0 +1,204 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// maxLogLine bounds a single record of a log file.
+const maxLogLine = 1 << 20
+
+// Log is an append-only record log bounded to its newest records. Unlike Store, appending
+// writes one JSON line instead of rewriting the file; the file is compacted to the newest
+// records once it holds twice as many. An empty path keeps records in memory only.
+// With a keyring, records are encrypted on disk.
+type Log struct {
+	path     string
+	location string
+	limit    int
+	keyring  *Keyring
+	mu       sync.Mutex
+	records  []json.RawMessage
+	lines    int
+	file     *os.File
+	logger   *slog.Logger
+}
+
+// OpenLog loads the newest limit records of the log at path, creating the file on the first
+// append. The name is authenticated with every encrypted record, so records cannot be moved
+// between logs. With a keyring, records not sealed as they would be now are sealed again.
+func OpenLog(path, name string, limit int, keyring *Keyring, logger *slog.Logger) (*Log, error) {
+	l := &Log{
+		path:     path,
+		location: "log/" + name,
+		limit:    limit,
+		keyring:  keyring,
+		logger:   logger.With(slog.String("component", "store"), slog.String("log", name)),
+	}
+
+	if path == "" {
+		return l, nil
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	stale := false
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLogLine)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		l.lines++
+		record, staleRecord, err := keyring.openValue(l.location, line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open record %d of log file %s: %w", l.lines, path, err)
+		}
+		stale = stale || staleRecord
+		if !json.Valid(record) {
+			// A crash while appending leaves a partial last line behind.
+			l.logger.Warn("skipping malformed log record", slog.String("path", path), slog.Int("line", l.lines))
+			continue
+		}
+		l.push(bytes.Clone(record))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read log file %s: %w", path, err)
+	}
+	// Sealing the kept records again spares the next start deriving the keys of this one.
+	if keyring != nil && stale {
+		if err := l.compact(); err != nil {
+			return nil, err
+		}
+	}
+
+	l.logger.Info("loaded log", slog.String("path", path), slog.Int("records", len(l.records)))
+	return l, nil
+}
+
+// Append adds value as the newest record, dropping the oldest record beyond the limit.
+func (l *Log) Append(value any) error {
+	record, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode log record: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.push(record)
+	if l.path == "" {
+		return nil
+	}
+	if l.lines >= 2*l.limit {
+		return l.compact()
+	}
+	return l.write(record)
+}
+
+// Records decodes the records of a log, oldest first.
+func Records[T any](l *Log) ([]T, error) {
+	l.mu.Lock()
+	records := make([]json.RawMessage, len(l.records))
+	copy(records, l.records)
+	l.mu.Unlock()
+
+	values := make([]T, 0, len(records))
+	for i, record := range records {
+		var value T
+		if err := json.Unmarshal(record, &value); err != nil {
+			return nil, fmt.Errorf("failed to decode log record %d: %w", i, err)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// push keeps record in memory, dropping the oldest record beyond the limit. Callers must hold mu.
+func (l *Log) push(record json.RawMessage) {
+	l.records = append(l.records, record)
+	if len(l.records) > l.limit {
+		l.records = slices.Delete(l.records, 0, len(l.records)-l.limit)
+	}
+}
+
+// write appends one record to the log file. Callers must hold mu.
+func (l *Log) write(record json.RawMessage) error {
+	if l.file == nil {
+		if err := os.MkdirAll(filepath.Dir(l.path), 0o700); err != nil {
+			return fmt.Errorf("failed to create log directory: %w", err)
+		}
+		file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		l.file = file
+	}
+
+	line, err := l.encode(record)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(line); err != nil {
+		return fmt.Errorf("failed to append to log file: %w", err)
+	}
+	l.lines++
+	return nil
+}
+
+// compact rewrites the log file with the records kept in memory through a temp file and rename.
+// Callers must hold mu.
+func (l *Log) compact() error {
+	var data bytes.Buffer
+	for _, record := range l.records {
+		line, err := l.encode(record)
+		if err != nil {
+			return err
+		}
+		data.Write(line)
+	}
+
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	tmpPath := l.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("failed to write log file: %w", err)
+	}
+	if err := os.Rename(tmpPath, l.path); err != nil {
+		return fmt.Errorf("failed to replace log file: %w", err)
+	}
+	l.lines = len(l.records)
+	return nil
+}
+
+// encode returns the line of a record in the log file, sealed if the log is encrypted.
+func (l *Log) encode(record json.RawMessage) ([]byte, error) {
+	if l.keyring != nil {
+		sealed, err := l.keyring.seal(l.location, record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt log record: %w", err)
+		}
+		record = sealed
+	}
+	return append(bytes.Clone(record), '\n'), nil
+}
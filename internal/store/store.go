@@ -0,0 +1,203 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Store is a small JSON file-backed key/value store organised in buckets.
+// Every mutation is flushed to disk atomically; an empty path keeps state in memory only.
+// With a keyring, values are encrypted on disk; bucket names and keys are not.
+type Store struct {
+	path    string
+	keyring *Keyring
+	mu      sync.RWMutex
+	buckets map[string]map[string]json.RawMessage
+	logger  *slog.Logger
+}
+
+// Open loads the store from path, creating an empty one if the file does not exist yet.
+// With a keyring, values stored unencrypted, sealed with a rotated key or under the salt of an
+// earlier start are sealed again right away, so opening them later derives no further keys.
+func Open(path string, keyring *Keyring, logger *slog.Logger) (*Store, error) {
+	s := &Store{
+		path:    path,
+		keyring: keyring,
+		buckets: make(map[string]map[string]json.RawMessage),
+		logger:  logger.With(slog.String("component", "store")),
+	}
+
+	if path == "" {
+		s.logger.Warn("no state path configured, state will not survive restarts")
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		s.logger.Info("initialized empty state store", slog.String("path", path))
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file %s: %w", path, err)
+	}
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.buckets); err != nil {
+			return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+		}
+	}
+
+	resealed := 0
+	for bucket, values := range s.buckets {
+		for key, raw := range values {
+			plaintext, stale, err := keyring.openValue(bucket+"/"+key, raw)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open %s/%s in state file %s: %w", bucket, key, path, err)
+			}
+			if keyring != nil && stale {
+				resealed++
+			}
+			values[key] = plaintext
+		}
+	}
+	if resealed > 0 {
+		if err := s.flush(); err != nil {
+			return nil, err
+		}
+		s.logger.Info("sealed state values with the current encryption key", slog.Int("values", resealed))
+	}
+
+	s.logger.Info("loaded state store",
+		slog.String("path", path),
+		slog.Int("buckets", len(s.buckets)),
+		slog.Bool("encrypted", keyring != nil),
+	)
+	return s, nil
+}
+
+// Encrypted reports whether values are encrypted on disk.
+func (s *Store) Encrypted() bool {
+	return s.keyring != nil
+}
+
+// Put stores value under key in bucket, replacing any previous value.
+func (s *Store) Put(bucket, key string, value any) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s/%s: %w", bucket, key, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string]json.RawMessage)
+	}
+	s.buckets[bucket][key] = raw
+
+	return s.flush()
+}
+
+// Get decodes the value stored under key in bucket into target.
+// It reports false if the key does not exist.
+func (s *Store) Get(bucket, key string, target any) (bool, error) {
+	s.mu.RLock()
+	raw, ok := s.buckets[bucket][key]
+	s.mu.RUnlock()
+
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(raw, target); err != nil {
+		return true, fmt.Errorf("failed to decode %s/%s: %w", bucket, key, err)
+	}
+	return true, nil
+}
+
+// Delete removes key from bucket. Deleting a missing key is not an error.
+func (s *Store) Delete(bucket, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.buckets[bucket][key]; !ok {
+		return nil
+	}
+	delete(s.buckets[bucket], key)
+
+	return s.flush()
+}
+
+// Keys returns the sorted keys of a bucket.
+func (s *Store) Keys(bucket string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	for key := range s.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// List decodes every value of a bucket, ordered by key.
+func List[T any](s *Store, bucket string) ([]T, error) {
+	var values []T
+	for _, key := range s.Keys(bucket) {
+		var value T
+		found, err := s.Get(bucket, key, &value)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
+
+// flush writes the whole store to disk through a temp file and rename. Callers must hold mu.
+func (s *Store) flush() error {
+	if s.path == "" {
+		return nil
+	}
+
+	buckets := s.buckets
+	if s.keyring != nil {
+		buckets = make(map[string]map[string]json.RawMessage, len(s.buckets))
+		for bucket, values := range s.buckets {
+			sealed := make(map[string]json.RawMessage, len(values))
+			for key, raw := range values {
+				var err error
+				if sealed[key], err = s.keyring.seal(bucket+"/"+key, raw); err != nil {
+					return fmt.Errorf("failed to encrypt %s/%s: %w", bucket, key, err)
+				}
+			}
+			buckets[bucket] = sealed
+		}
+	}
+
+	data, err := json.MarshalIndent(buckets, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+
+	return nil
+}
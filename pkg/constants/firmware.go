@@ -0,0 +1,17 @@
+package constants
+
+type Firmware string
+
+const (
+	FIRMWARE_BIOS Firmware = "bios"
+	FIRMWARE_UEFI Firmware = "uefi"
+)
+
+type MachineType string
+
+const (
+	MACHINE_TYPE_Q35 MachineType = "q35"
+	MACHINE_TYPE_PC  MachineType = "pc"
+	// MACHINE_TYPE_CONTAINER provisions an LXC system container instead of a KVM guest.
+	MACHINE_TYPE_CONTAINER MachineType = "container"
+)
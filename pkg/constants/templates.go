@@ -1,9 +1,9 @@
 package constants
 
 const (
-	TemplateLibvirt             = "libvirt"
-	TemplateCloudInitUserData   = "cloudinit-user-data"
-	TemplateCloudInitMetaData   = "cloudinit-meta-data"
+	TemplateLibvirt                = "libvirt"
+	TemplateLibvirtContainer       = "libvirt-container"
+	TemplateCloudInitUserData      = "cloudinit-user-data"
+	TemplateCloudInitMetaData      = "cloudinit-meta-data"
 	TemplateCloudInitNetworkConfig = "cloudinit-network-config"
 )
-
@@ -0,0 +1,101 @@
+// Package cpuset parses and formats libvirt-style CPU and NUMA node sets such as "0-3,8,^2".
+package cpuset
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Parse expands a cpuset expression into a sorted list of unique ids.
+// Ranges ("0-3"), single ids ("8") and exclusions ("^2") separated by commas are supported.
+func Parse(expr string) ([]int, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, fmt.Errorf("empty cpuset")
+	}
+
+	included := make(map[int]bool)
+	var excluded []int
+
+	for _, part := range strings.Split(expr, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, fmt.Errorf("invalid cpuset %q: empty element", expr)
+		}
+
+		if strings.HasPrefix(part, "^") {
+			id, err := parseID(expr, strings.TrimPrefix(part, "^"))
+			if err != nil {
+				return nil, err
+			}
+			excluded = append(excluded, id)
+			continue
+		}
+
+		start, end := part, part
+		if before, after, found := strings.Cut(part, "-"); found {
+			start, end = before, after
+		}
+
+		first, err := parseID(expr, start)
+		if err != nil {
+			return nil, err
+		}
+		last, err := parseID(expr, end)
+		if err != nil {
+			return nil, err
+		}
+		if last < first {
+			return nil, fmt.Errorf("invalid cpuset %q: range %s is reversed", expr, part)
+		}
+
+		for id := first; id <= last; id++ {
+			included[id] = true
+		}
+	}
+
+	for _, id := range excluded {
+		delete(included, id)
+	}
+	if len(included) == 0 {
+		return nil, fmt.Errorf("invalid cpuset %q: selects no ids", expr)
+	}
+
+	ids := make([]int, 0, len(included))
+	for id := range included {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// Format renders ids as a compact cpuset expression, collapsing consecutive ids into ranges.
+func Format(ids []int) string {
+	sorted := append([]int(nil), ids...)
+	sort.Ints(sorted)
+
+	var parts []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] <= sorted[j]+1 {
+			j++
+		}
+		if sorted[i] == sorted[j] {
+			parts = append(parts, strconv.Itoa(sorted[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseID(expr, value string) (int, error) {
+	id, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("invalid cpuset %q: %q is not a valid id", expr, value)
+	}
+	return id, nil
+}
@@ -0,0 +1,115 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1,,2 * * * *",
+		"@every",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := Parse(expr); err == nil {
+				t.Errorf("Parse(%q) succeeded, want an error", expr)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	// 2026-10-16 is a Friday.
+	from := time.Date(2026, 10, 16, 10, 30, 45, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 10, 16, 10, 31, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2026, 10, 17, 10, 30, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 10, 16, 10, 45, 0, 0, time.UTC)},
+		{"0 */6 * * *", time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)},
+		{"0 9-17 * * *", time.Date(2026, 10, 16, 11, 0, 0, 0, time.UTC)},
+		{"5,35 * * * *", time.Date(2026, 10, 16, 10, 35, 0, 0, time.UTC)},
+		{"10/20 * * * *", time.Date(2026, 10, 16, 10, 50, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2026, 10, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1-5", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * 1 *", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 10, 16, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"  @midnight ", time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: either one matching is enough.
+		{"0 0 20 * 1", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 17 * 1", time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)},
+		// Only the day of week restricted: the day of month must not widen it.
+		{"0 0 */1 * 1", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			schedule, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.expr, err)
+			}
+			if got := schedule.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextIsStrictlyAfter(t *testing.T) {
+	schedule, err := Parse("30 10 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2026, 10, 16, 10, 30, 0, 0, time.UTC)
+	want := time.Date(2026, 10, 17, 10, 30, 0, 0, time.UTC)
+	if got := schedule.Next(from); !got.Equal(want) {
+		t.Errorf("Next(%s) = %s, want %s", from, got, want)
+	}
+}
+
+func TestNextKeepsLocation(t *testing.T) {
+	location := time.FixedZone("UTC+2", 2*60*60)
+	schedule, err := Parse("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2026, 10, 16, 10, 0, 0, 0, location)
+	want := time.Date(2026, 10, 17, 9, 0, 0, 0, location)
+	got := schedule.Next(from)
+	if !got.Equal(want) || got.Location() != location {
+		t.Errorf("Next(%s) = %s, want %s", from, got, want)
+	}
+}
+
+func TestNextWithoutMatch(t *testing.T) {
+	schedule, err := Parse("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := schedule.Next(time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)); !got.IsZero() {
+		t.Errorf("Next = %s, want the zero time", got)
+	}
+}
@@ -0,0 +1,69 @@
+package dnsmasq
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/terabiome/homonculus/pkg/executor"
+)
+
+type ServerOptions struct {
+	Interface string
+	DHCPRange string
+	TFTPRoot  string
+	BootFile  string
+	PIDFile   string
+	LeaseFile string
+}
+
+// StartServer launches a daemonized dnsmasq serving only DHCP and TFTP on one interface.
+func StartServer(ctx context.Context, exec executor.Executor, opts ServerOptions) error {
+	args := []string{
+		"--conf-file=/dev/null",
+		"--port=0",
+		"--interface=" + opts.Interface,
+		"--bind-interfaces",
+		"--except-interface=lo",
+		"--dhcp-range=" + opts.DHCPRange,
+		"--dhcp-boot=" + opts.BootFile,
+		"--dhcp-leasefile=" + opts.LeaseFile,
+		"--enable-tftp",
+		"--tftp-root=" + opts.TFTPRoot,
+		"--pid-file=" + opts.PIDFile,
+	}
+
+	result, err := executor.RunAndCapture(ctx, exec, "dnsmasq", args...)
+	if err != nil {
+		return fmt.Errorf("dnsmasq failed: %w\nstdout: %s\nstderr: %s",
+			err, result.Stdout, result.Stderr)
+	}
+
+	return nil
+}
+
+// IsRunning reports whether the process recorded in pidFile is alive.
+func IsRunning(ctx context.Context, exec executor.Executor, pidFile string) (bool, error) {
+	result, err := executor.RunAndCapture(ctx, exec, "pkill", "-0", "-F", pidFile)
+	if err != nil {
+		// pkill exits 1 when no process matched and 2 when the pid file is missing.
+		if result.ExitCode == 1 || result.ExitCode == 2 {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check dnsmasq: %w\nstderr: %s", err, result.Stderr)
+	}
+	return true, nil
+}
+
+// StopServer terminates the process recorded in pidFile. A server that is not running is not an error.
+func StopServer(ctx context.Context, exec executor.Executor, pidFile string) error {
+	running, err := IsRunning(ctx, exec, pidFile)
+	if err != nil || !running {
+		return err
+	}
+
+	result, err := executor.RunAndCapture(ctx, exec, "pkill", "-F", pidFile)
+	if err != nil {
+		return fmt.Errorf("failed to stop dnsmasq: %w\nstderr: %s", err, result.Stderr)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package executor
 import (
 	"context"
 	"io"
+	"net"
 )
 
 type Executor interface {
@@ -10,6 +11,11 @@ type Executor interface {
 	Name() string
 }
 
+// Dialer is implemented by executors that can open network connections from the host they run commands on.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
 type Result struct {
 	ExitCode int
 	Stdout   string
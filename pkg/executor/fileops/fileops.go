@@ -2,7 +2,11 @@ package fileops
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/terabiome/homonculus/pkg/executor"
 )
@@ -39,6 +43,26 @@ func CopyFile(ctx context.Context, exec executor.Executor, src, dst string) erro
 	return nil
 }
 
+// LinkFile makes dst a hard link to src, replacing dst if it exists.
+func LinkFile(ctx context.Context, exec executor.Executor, src, dst string) error {
+	result, err := executor.RunAndCapture(ctx, exec, "ln", "-f", src, dst)
+	if err != nil {
+		return fmt.Errorf("failed to link %s to %s: %w\nstderr: %s", dst, src, err, result.Stderr)
+	}
+	return nil
+}
+
+// RemoveUnlinkedFiles removes the files directly inside dir matching pattern that have no
+// other hard link and whose status did not change for olderThan.
+func RemoveUnlinkedFiles(ctx context.Context, exec executor.Executor, dir, pattern string, olderThan time.Duration) error {
+	minutes := strconv.Itoa(int(olderThan.Minutes()))
+	result, err := executor.RunAndCapture(ctx, exec, "find", dir, "-maxdepth", "1", "-type", "f", "-name", pattern, "-links", "1", "-cmin", "+"+minutes, "-delete")
+	if err != nil {
+		return fmt.Errorf("failed to remove unlinked files in %s: %w\nstderr: %s", dir, err, result.Stderr)
+	}
+	return nil
+}
+
 func MoveFile(ctx context.Context, exec executor.Executor, src, dst string) error {
 	result, err := executor.RunAndCapture(ctx, exec, "mv", src, dst)
 	if err != nil {
@@ -47,3 +71,107 @@ func MoveFile(ctx context.Context, exec executor.Executor, src, dst string) erro
 	return nil
 }
 
+func FreeSpace(ctx context.Context, exec executor.Executor, path string) (int64, error) {
+	result, err := executor.RunAndCapture(ctx, exec, "df", "--output=avail", "-B1", path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get free space of %s: %w\nstderr: %s", path, err, result.Stderr)
+	}
+
+	lines := strings.Fields(result.Stdout)
+	if len(lines) < 2 {
+		return 0, fmt.Errorf("unexpected df output for %s: %q", path, result.Stdout)
+	}
+
+	available, err := strconv.ParseInt(lines[len(lines)-1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse df output for %s: %w", path, err)
+	}
+	return available, nil
+}
+
+func IsDirectory(ctx context.Context, exec executor.Executor, path string) (bool, error) {
+	result, err := executor.RunAndCapture(ctx, exec, "test", "-d", path)
+	if err != nil {
+		if result.ExitCode == 1 {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check directory %s: %w\nstderr: %s", path, err, result.Stderr)
+	}
+	return true, nil
+}
+
+// FileStatus reports whether a regular file exists and is readable by the executor's user.
+func FileStatus(ctx context.Context, exec executor.Executor, path string) (exists, readable bool, err error) {
+	result, err := executor.RunAndCapture(ctx, exec, "test", "-f", path)
+	if err != nil {
+		if result.ExitCode == 1 {
+			return false, false, nil
+		}
+		return false, false, fmt.Errorf("failed to check file %s: %w\nstderr: %s", path, err, result.Stderr)
+	}
+
+	result, err = executor.RunAndCapture(ctx, exec, "test", "-r", path)
+	if err != nil {
+		if result.ExitCode == 1 {
+			return true, false, nil
+		}
+		return true, false, fmt.Errorf("failed to check file %s: %w\nstderr: %s", path, err, result.Stderr)
+	}
+	return true, true, nil
+}
+
+func FindExecutable(ctx context.Context, exec executor.Executor, candidates ...string) (string, error) {
+	for _, candidate := range candidates {
+		result, err := executor.RunAndCapture(ctx, exec, "test", "-x", candidate)
+		if err == nil {
+			return candidate, nil
+		}
+		if result.ExitCode != 1 {
+			return "", fmt.Errorf("failed to check executable %s: %w\nstderr: %s", candidate, err, result.Stderr)
+		}
+	}
+	return "", fmt.Errorf("none of %v is executable", candidates)
+}
+
+// WriteFile replaces the content of path. Executors have no stdin, so the content is
+// passed base64-encoded as an argument; it suits small files such as definitions.
+func WriteFile(ctx context.Context, exec executor.Executor, path string, data []byte) error {
+	script := `printf '%s' "$1" | base64 -d > "$2"`
+	result, err := executor.RunAndCapture(ctx, exec, "sh", "-c", script, "sh", base64.StdEncoding.EncodeToString(data), path)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w\nstderr: %s", path, err, result.Stderr)
+	}
+	return nil
+}
+
+func ReadFile(ctx context.Context, exec executor.Executor, path string) ([]byte, error) {
+	result, err := executor.RunAndCapture(ctx, exec, "cat", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w\nstderr: %s", path, err, result.Stderr)
+	}
+	return []byte(result.Stdout), nil
+}
+
+// ListDirectories returns the names of the directories directly inside dir, or nothing if dir does not exist.
+func ListDirectories(ctx context.Context, exec executor.Executor, dir string) ([]string, error) {
+	exists, err := IsDirectory(ctx, exec, dir)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	result, err := executor.RunAndCapture(ctx, exec, "find", dir, "-mindepth", "1", "-maxdepth", "1", "-type", "d", "-printf", "%f\n")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w\nstderr: %s", dir, err, result.Stderr)
+	}
+	return strings.Fields(result.Stdout), nil
+}
+
+// ExtractArchive unpacks a tarball into dir, keeping the ownership and permissions of its
+// entries. tar detects the compression itself.
+func ExtractArchive(ctx context.Context, exec executor.Executor, archive, dir string) error {
+	result, err := executor.RunAndCapture(ctx, exec, "tar", "-xpf", archive, "--numeric-owner", "-C", dir)
+	if err != nil {
+		return fmt.Errorf("failed to extract %s into %s: %w\nstderr: %s", archive, dir, err, result.Stderr)
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"os/exec"
 	"strings"
 )
@@ -58,6 +59,12 @@ func (e *Local) Execute(
 	return 0, nil
 }
 
+// DialContext opens a network connection from the local host.
+func (e *Local) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, address)
+}
+
 func (e *Local) buildCommandString(command string, args []string) string {
 	if len(args) == 0 {
 		return command
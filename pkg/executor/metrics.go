@@ -0,0 +1,101 @@
+package executor
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Outcomes of a command recorded by Instrumented.
+const (
+	OutcomeSuccess  = "success"
+	OutcomeExitCode = "exit_code" // the command ran and exited non-zero
+	OutcomeError    = "error"     // the command could not be run, e.g. its binary is missing
+)
+
+// Instrumented records metrics on the commands run by another executor: the number of
+// invocations by outcome and their duration, labeled by command binary and host, so that
+// recurring problems with host tooling show up on dashboards.
+type Instrumented struct {
+	Executor
+	host        string
+	invocations metric.Int64Counter
+	duration    metric.Float64Histogram
+}
+
+// instrumentedDialer is an Instrumented executor whose wrapped executor is also a Dialer.
+type instrumentedDialer struct {
+	*Instrumented
+	Dialer
+}
+
+// NewInstrumented wraps exec so that its commands are recorded as metrics for host.
+// The returned executor is a Dialer when exec is one.
+func NewInstrumented(exec Executor, host string, logger *slog.Logger) Executor {
+	meter := otel.Meter("homonculus/executor")
+
+	invocations, err := meter.Int64Counter(
+		"homonculus.executor.commands",
+		metric.WithDescription("Number of commands run by executors, by command, host and outcome"),
+		metric.WithUnit("{command}"),
+	)
+	if err != nil {
+		logger.Warn("failed to create executor command metric", slog.String("error", err.Error()))
+	}
+
+	duration, err := meter.Float64Histogram(
+		"homonculus.executor.command.duration",
+		metric.WithDescription("Duration of commands run by executors"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		logger.Warn("failed to create executor command duration metric", slog.String("error", err.Error()))
+	}
+
+	instrumented := &Instrumented{
+		Executor:    exec,
+		host:        host,
+		invocations: invocations,
+		duration:    duration,
+	}
+	if dialer, ok := exec.(Dialer); ok {
+		return instrumentedDialer{Instrumented: instrumented, Dialer: dialer}
+	}
+	return instrumented
+}
+
+// Execute runs the command with the wrapped executor and records it.
+func (e *Instrumented) Execute(
+	ctx context.Context,
+	stdout, stderr io.Writer,
+	command string, args ...string,
+) (int, error) {
+	start := time.Now()
+	exitCode, err := e.Executor.Execute(ctx, stdout, stderr, command, args...)
+
+	outcome := OutcomeSuccess
+	switch {
+	case err != nil && exitCode > 0:
+		outcome = OutcomeExitCode
+	case err != nil:
+		outcome = OutcomeError
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("command", filepath.Base(command)),
+		attribute.String("host", e.host),
+	}
+	if e.invocations != nil {
+		e.invocations.Add(ctx, 1, metric.WithAttributes(append(attrs, attribute.String("outcome", outcome))...))
+	}
+	if e.duration != nil {
+		e.duration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attrs...))
+	}
+	return exitCode, err
+}
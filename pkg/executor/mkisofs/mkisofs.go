@@ -2,7 +2,10 @@ package mkisofs
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
+	"strings"
+	"unicode/utf16"
 
 	"github.com/terabiome/homonculus/pkg/executor"
 )
@@ -30,3 +33,87 @@ func CreateISO(ctx context.Context, exec executor.Executor, opts ISOOptions) err
 
 	return nil
 }
+
+// sectorSize is the logical block size of ISO 9660 images.
+const sectorSize = 2048
+
+// ReadFiles reads the files in the root directory of an ISO built by CreateISO, by name.
+// The image is parsed here through its Joliet directory, so no ISO tools are needed.
+func ReadFiles(ctx context.Context, exec executor.Executor, isoFile string) (map[string][]byte, error) {
+	result, err := executor.RunAndCapture(ctx, exec, "cat", isoFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w\nstderr: %s", isoFile, err, result.Stderr)
+	}
+	files, err := rootFiles([]byte(result.Stdout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", isoFile, err)
+	}
+	return files, nil
+}
+
+// rootFiles returns the regular files of the Joliet root directory of an ISO 9660 image.
+func rootFiles(image []byte) (map[string][]byte, error) {
+	sector := func(lba, length uint32) ([]byte, error) {
+		start := uint64(lba) * sectorSize
+		end := start + uint64(length)
+		if end > uint64(len(image)) {
+			return nil, fmt.Errorf("extent at sector %d runs past the end of the image", lba)
+		}
+		return image[start:end], nil
+	}
+
+	// Volume descriptors start at sector 16; the Joliet one is a supplementary
+	// descriptor (type 2) with a UCS-2 escape sequence.
+	var root []byte
+	for lba := uint32(16); ; lba++ {
+		descriptor, err := sector(lba, sectorSize)
+		if err != nil {
+			return nil, fmt.Errorf("no Joliet volume descriptor")
+		}
+		if string(descriptor[1:6]) != "CD001" || descriptor[0] == 255 {
+			return nil, fmt.Errorf("no Joliet volume descriptor")
+		}
+		if descriptor[0] == 2 && descriptor[88] == '%' && descriptor[89] == '/' {
+			root = descriptor[156 : 156+34]
+			break
+		}
+	}
+
+	directory, err := sector(binary.LittleEndian.Uint32(root[2:6]), binary.LittleEndian.Uint32(root[10:14]))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte)
+	for offset := 0; offset < len(directory); {
+		length := int(directory[offset])
+		if length == 0 {
+			// Records do not cross sector boundaries; skip the padding to the next sector.
+			offset = (offset/sectorSize + 1) * sectorSize
+			continue
+		}
+		if offset+length > len(directory) || length < 34 {
+			return nil, fmt.Errorf("malformed directory record")
+		}
+		record := directory[offset : offset+length]
+		offset += length
+
+		nameLength := int(record[32])
+		if record[25]&0x02 != 0 || 33+nameLength > len(record) {
+			continue // a directory, including the . and .. entries
+		}
+		encoded := record[33 : 33+nameLength]
+		units := make([]uint16, len(encoded)/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(encoded[2*i:])
+		}
+		name, _, _ := strings.Cut(string(utf16.Decode(units)), ";")
+
+		data, err := sector(binary.LittleEndian.Uint32(record[2:6]), binary.LittleEndian.Uint32(record[10:14]))
+		if err != nil {
+			return nil, err
+		}
+		files[name] = data
+	}
+	return files, nil
+}
@@ -2,17 +2,102 @@ package qemuimg
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/terabiome/homonculus/pkg/executor"
 )
 
+// Preallocation modes of qcow2 images.
+const (
+	PreallocationOff      = "off"
+	PreallocationMetadata = "metadata"
+	PreallocationFalloc   = "falloc"
+	PreallocationFull     = "full"
+)
+
+// Compression types of the compressed clusters of qcow2 images.
+const (
+	CompressionZlib = "zlib"
+	CompressionZstd = "zstd"
+)
+
+const (
+	minClusterSize = 512
+	maxClusterSize = 2 << 20
+)
+
+// FormatOptions are the qcow2 creation options of an image. Zero values leave the qemu-img
+// defaults: no preallocation, 64 KiB clusters and zlib compression.
+type FormatOptions struct {
+	Preallocation   string
+	ClusterSize     int64 // bytes
+	CompressionType string
+}
+
+// Validate checks the options against the values qemu-img accepts.
+func (o FormatOptions) Validate() error {
+	switch o.Preallocation {
+	case "", PreallocationOff, PreallocationMetadata, PreallocationFalloc, PreallocationFull:
+	default:
+		return fmt.Errorf("preallocation must be off, metadata, falloc or full, got %q", o.Preallocation)
+	}
+	switch o.CompressionType {
+	case "", CompressionZlib, CompressionZstd:
+	default:
+		return fmt.Errorf("compression_type must be zlib or zstd, got %q", o.CompressionType)
+	}
+	if o.ClusterSize != 0 && (o.ClusterSize < minClusterSize || o.ClusterSize > maxClusterSize || o.ClusterSize&(o.ClusterSize-1) != 0) {
+		return fmt.Errorf("cluster_size must be a power of two from 512 to 2M, got %d", o.ClusterSize)
+	}
+	return nil
+}
+
+// ParseClusterSize parses a cluster size in bytes, or with a k or M suffix, e.g. "64k".
+func ParseClusterSize(value string) (int64, error) {
+	multiplier := int64(1)
+	number := value
+	switch {
+	case strings.HasSuffix(strings.ToLower(value), "k"):
+		multiplier, number = 1<<10, value[:len(value)-1]
+	case strings.HasSuffix(strings.ToUpper(value), "M"):
+		multiplier, number = 1<<20, value[:len(value)-1]
+	}
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid cluster_size %q", value)
+	}
+	return size * multiplier, nil
+}
+
+// args returns the -o argument setting the options, if any is set.
+func (o FormatOptions) args() []string {
+	var options []string
+	if o.Preallocation != "" {
+		options = append(options, "preallocation="+o.Preallocation)
+	}
+	if o.ClusterSize != 0 {
+		options = append(options, "cluster_size="+strconv.FormatInt(o.ClusterSize, 10))
+	}
+	if o.CompressionType != "" {
+		options = append(options, "compression_type="+o.CompressionType)
+	}
+	if len(options) == 0 {
+		return nil
+	}
+	return []string{"-o", strings.Join(options, ",")}
+}
+
 type BackingImageOptions struct {
 	BackingFile       string
 	BackingFileFormat string
 	OutputFile        string
 	OutputFileFormat  string
 	SizeGB            int64
+	Format            FormatOptions
 }
 
 func CreateBackingImage(ctx context.Context, exec executor.Executor, opts BackingImageOptions) error {
@@ -21,9 +106,34 @@ func CreateBackingImage(ctx context.Context, exec executor.Executor, opts Backin
 		"-b", opts.BackingFile,
 		"-F", opts.BackingFileFormat,
 		"-f", opts.OutputFileFormat,
-		opts.OutputFile,
-		fmt.Sprintf("%dG", opts.SizeGB),
 	}
+	args = append(args, opts.Format.args()...)
+	args = append(args, opts.OutputFile, fmt.Sprintf("%dG", opts.SizeGB))
+
+	result, err := executor.RunAndCapture(ctx, exec, "qemu-img", args...)
+	if err != nil {
+		return fmt.Errorf("qemu-img create failed: %w\nstdout: %s\nstderr: %s",
+			err, result.Stdout, result.Stderr)
+	}
+
+	return nil
+}
+
+type ImageOptions struct {
+	OutputFile       string
+	OutputFileFormat string
+	SizeGB           int64
+	Format           FormatOptions
+}
+
+// CreateImage creates an empty image without a backing file.
+func CreateImage(ctx context.Context, exec executor.Executor, opts ImageOptions) error {
+	args := []string{
+		"create",
+		"-f", opts.OutputFileFormat,
+	}
+	args = append(args, opts.Format.args()...)
+	args = append(args, opts.OutputFile, fmt.Sprintf("%dG", opts.SizeGB))
 
 	result, err := executor.RunAndCapture(ctx, exec, "qemu-img", args...)
 	if err != nil {
@@ -45,3 +155,58 @@ func Info(ctx context.Context, exec executor.Executor, opts InfoOptions) (string
 	}
 	return result.Stdout, nil
 }
+
+// ImageInfo describes a disk image as reported by qemu-img.
+type ImageInfo struct {
+	Format           string
+	VirtualSizeBytes int64
+}
+
+// Inspect reads the format and virtual size of an image. Images opened by running VMs are read with shared locks.
+func Inspect(ctx context.Context, exec executor.Executor, imagePath string) (ImageInfo, error) {
+	result, err := executor.RunAndCapture(ctx, exec, "qemu-img", "info", "-U", "--output=json", imagePath)
+	if err != nil {
+		return ImageInfo{}, fmt.Errorf("qemu-img info failed: %w\nstderr: %s", err, result.Stderr)
+	}
+
+	var info struct {
+		Format      string `json:"format"`
+		VirtualSize int64  `json:"virtual-size"`
+	}
+	if err := json.Unmarshal([]byte(result.Stdout), &info); err != nil {
+		return ImageInfo{}, fmt.Errorf("could not parse qemu-img info output: %w", err)
+	}
+	return ImageInfo{Format: info.Format, VirtualSizeBytes: info.VirtualSize}, nil
+}
+
+// BackingChain returns the backing files of an image, nearest first. Relative backing
+// file names are resolved against the directory of the image referencing them.
+// Images opened by running VMs are read with shared locks.
+func BackingChain(ctx context.Context, exec executor.Executor, imagePath string) ([]string, error) {
+	result, err := executor.RunAndCapture(ctx, exec, "qemu-img", "info", "-U", "--backing-chain", "--output=json", imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("qemu-img info failed: %w\nstderr: %s", err, result.Stderr)
+	}
+
+	var images []struct {
+		Filename string `json:"filename"`
+	}
+	if err := json.Unmarshal([]byte(result.Stdout), &images); err != nil {
+		return nil, fmt.Errorf("could not parse qemu-img info output: %w", err)
+	}
+
+	var chain []string
+	parentDir := filepath.Dir(imagePath)
+	for i, image := range images {
+		filename := image.Filename
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(parentDir, filename)
+		}
+		filename = filepath.Clean(filename)
+		parentDir = filepath.Dir(filename)
+		if i > 0 {
+			chain = append(chain, filename)
+		}
+	}
+	return chain, nil
+}
@@ -0,0 +1,127 @@
+package qemusystem
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/terabiome/homonculus/pkg/executor"
+)
+
+// Binaries are the paths qemu-system is looked up at, in order.
+var Binaries = []string{
+	"/usr/bin/qemu-system-x86_64",
+	"/usr/libexec/qemu-kvm",
+	"/usr/bin/qemu-kvm",
+}
+
+// Start launches a daemonized QEMU. args must include -daemonize and -pidfile.
+func Start(ctx context.Context, exec executor.Executor, binary string, args []string) error {
+	result, err := executor.RunAndCapture(ctx, exec, binary, args...)
+	if err != nil {
+		return fmt.Errorf("qemu failed: %w\nstdout: %s\nstderr: %s",
+			err, result.Stdout, result.Stderr)
+	}
+	return nil
+}
+
+// IsRunning reports whether the process recorded in pidFile is alive.
+func IsRunning(ctx context.Context, exec executor.Executor, pidFile string) (bool, error) {
+	result, err := executor.RunAndCapture(ctx, exec, "pkill", "-0", "-F", pidFile)
+	if err != nil {
+		// pkill exits 1 when no process matched and 2 when the pid file is missing.
+		if result.ExitCode == 1 || result.ExitCode == 2 {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check qemu: %w\nstderr: %s", err, result.Stderr)
+	}
+	return true, nil
+}
+
+// Kill terminates the process recorded in pidFile at once. A process that is not running is not an error.
+func Kill(ctx context.Context, exec executor.Executor, pidFile string) error {
+	running, err := IsRunning(ctx, exec, pidFile)
+	if err != nil || !running {
+		return err
+	}
+
+	result, err := executor.RunAndCapture(ctx, exec, "pkill", "-KILL", "-F", pidFile)
+	if err != nil {
+		return fmt.Errorf("failed to kill qemu: %w\nstderr: %s", err, result.Stderr)
+	}
+	return nil
+}
+
+// Monitor sends a human monitor command, such as system_powerdown, to the monitor socket of a QEMU
+// and returns its output. It needs socat on the host.
+func Monitor(ctx context.Context, exec executor.Executor, socket, command string) (string, error) {
+	script := `printf '%s\n' "$1" | socat - UNIX-CONNECT:"$2"`
+	result, err := executor.RunAndCapture(ctx, exec, "sh", "-c", script, "sh", command, socket)
+	if err != nil {
+		return "", fmt.Errorf("qemu monitor command %q failed: %w\nstderr: %s", command, err, result.Stderr)
+	}
+	return result.Stdout, nil
+}
+
+// Neighbors returns the IPv4 addresses the host has seen for MAC addresses, keyed by lowercase MAC.
+func Neighbors(ctx context.Context, exec executor.Executor) (map[string]string, error) {
+	result, err := executor.RunAndCapture(ctx, exec, "ip", "-4", "neigh", "show")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list neighbors: %w\nstderr: %s", err, result.Stderr)
+	}
+
+	// Lines look like: 192.168.122.10 dev br0 lladdr 52:54:00:12:34:56 REACHABLE
+	neighbors := make(map[string]string)
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		fields := strings.Fields(line)
+		for i := 1; i+1 < len(fields); i++ {
+			if fields[i] == "lladdr" {
+				neighbors[strings.ToLower(fields[i+1])] = fields[0]
+			}
+		}
+	}
+	return neighbors, nil
+}
+
+// ProcessStats reads the CPU time and resident memory of the process recorded in pidFile from /proc.
+func ProcessStats(ctx context.Context, exec executor.Executor, pidFile string) (cpuTimeNs, rssKiB uint64, err error) {
+	script := `pid=$(cat "$1") && getconf CLK_TCK && cat "/proc/$pid/stat" && grep '^VmRSS:' "/proc/$pid/status"`
+	result, err := executor.RunAndCapture(ctx, exec, "sh", "-c", script, "sh", pidFile)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read qemu process stats: %w\nstderr: %s", err, result.Stderr)
+	}
+
+	lines := strings.Split(strings.TrimSpace(result.Stdout), "\n")
+	if len(lines) < 3 {
+		return 0, 0, fmt.Errorf("unexpected qemu process stats: %q", result.Stdout)
+	}
+	ticks, err := strconv.ParseUint(strings.TrimSpace(lines[0]), 10, 64)
+	if err != nil || ticks == 0 {
+		return 0, 0, fmt.Errorf("unexpected clock ticks %q", lines[0])
+	}
+
+	// The command name in parentheses may contain spaces, so fields are counted after it.
+	// utime and stime are the 14th and 15th fields of /proc/<pid>/stat.
+	stat := lines[1]
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 13 {
+		return 0, 0, fmt.Errorf("unexpected /proc stat line %q", stat)
+	}
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not parse utime: %w", err)
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not parse stime: %w", err)
+	}
+	cpuTimeNs = (utime + stime) * uint64(time.Second) / ticks
+
+	// VmRSS:	  123456 kB
+	if rss := strings.Fields(lines[2]); len(rss) >= 2 {
+		rssKiB, _ = strconv.ParseUint(rss[1], 10, 64)
+	}
+	return cpuTimeNs, rssKiB, nil
+}
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"golang.org/x/crypto/ssh"
@@ -26,6 +28,7 @@ type SSHConfig struct {
 	Port    int
 	User    string
 	KeyPath string
+	Key     []byte // PEM private key, used instead of KeyPath when set
 }
 
 // NewSSH creates a new SSH executor with an established connection.
@@ -53,6 +56,11 @@ func (e *SSH) Close() error {
 	return nil
 }
 
+// DialContext opens a network connection from the remote host through the SSH connection.
+func (e *SSH) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return e.client.DialContext(ctx, network, address)
+}
+
 func (e *SSH) Name() string {
 	return fmt.Sprintf("ssh-%s", e.host)
 }
@@ -61,6 +69,17 @@ func (e *SSH) Execute(
 	ctx context.Context,
 	stdout, stderr io.Writer,
 	command string, args ...string,
+) (int, error) {
+	return e.ExecuteWithInput(ctx, nil, stdout, stderr, command, args...)
+}
+
+// ExecuteWithInput runs a command like Execute, feeding it stdin. Data passed this way,
+// such as file contents holding credentials, stays out of the logged command line.
+func (e *SSH) ExecuteWithInput(
+	ctx context.Context,
+	stdin io.Reader,
+	stdout, stderr io.Writer,
+	command string, args ...string,
 ) (int, error) {
 	cmdStr := e.buildCommandString(command, args)
 	e.logger.Debug("executing command via SSH", slog.String("cmd", cmdStr))
@@ -72,12 +91,26 @@ func (e *SSH) Execute(
 	}
 	defer session.Close()
 
-	// Set up output streams
+	// Set up input and output streams
+	session.Stdin = stdin
 	session.Stdout = stdout
 	session.Stderr = stderr
 
-	// Execute command
-	err = session.Run(cmdStr)
+	// Execute command, killing it when ctx is cancelled
+	if err := session.Start(cmdStr); err != nil {
+		return -1, fmt.Errorf("failed to start SSH command: %w", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		e.logger.Warn("cancelling SSH command", slog.String("cmd", cmdStr))
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		return -1, fmt.Errorf("command cancelled: %w", ctx.Err())
+	}
 	if err != nil {
 		if exitErr, ok := err.(*ssh.ExitError); ok {
 			exitCode := exitErr.ExitStatus()
@@ -99,11 +132,28 @@ func (e *SSH) Execute(
 	return 0, nil
 }
 
+// buildCommandString joins the command with its arguments, each quoted for the remote shell.
+// The command itself is passed as is, so callers may still run a shell snippet without arguments.
 func (e *SSH) buildCommandString(command string, args []string) string {
 	if len(args) == 0 {
 		return command
 	}
-	return command + " " + strings.Join(args, " ")
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = ShellQuote(arg)
+	}
+	return command + " " + strings.Join(quoted, " ")
+}
+
+// shellSafePattern matches arguments a POSIX shell passes through unchanged.
+var shellSafePattern = regexp.MustCompile(`^[A-Za-z0-9_./:=,+@%-]+$`)
+
+// ShellQuote quotes an argument for a POSIX shell. Safe arguments are left as is, keeping logged commands readable.
+func ShellQuote(arg string) string {
+	if shellSafePattern.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
 }
 
 // createSSHClient establishes an SSH connection from the given config.
@@ -113,20 +163,24 @@ func createSSHClient(config SSHConfig, logger *slog.Logger) (*ssh.Client, error)
 		port = 22
 	}
 
-	// Expand ~ in SSH key path
-	keyPath := config.KeyPath
-	if strings.HasPrefix(keyPath, "~/") {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get home directory: %w", err)
+	keyBytes := config.Key
+	if len(keyBytes) == 0 {
+		// Expand ~ in SSH key path
+		keyPath := config.KeyPath
+		if strings.HasPrefix(keyPath, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get home directory: %w", err)
+			}
+			keyPath = filepath.Join(home, keyPath[2:])
 		}
-		keyPath = filepath.Join(home, keyPath[2:])
-	}
 
-	// Read SSH private key
-	keyBytes, err := os.ReadFile(keyPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read SSH key %s: %w", keyPath, err)
+		// Read SSH private key
+		var err error
+		keyBytes, err = os.ReadFile(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SSH key %s: %w", keyPath, err)
+		}
 	}
 
 	// Parse private key
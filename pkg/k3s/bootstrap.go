@@ -28,11 +28,20 @@ func (p *LinePrefixer) Write(b []byte) (n int, err error) {
 	return len(b), err
 }
 
+// NodeExecutor runs commands on a node. It is implemented by executor.SSH, and by executors
+// reaching VMs through their guest agent.
+type NodeExecutor interface {
+	Execute(ctx context.Context, stdout, stderr io.Writer, command string, args ...string) (int, error)
+	ExecuteWithInput(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, command string, args ...string) (int, error)
+	Close() error
+}
+
 // BootstrapService handles K3s cluster bootstrapping via SSH.
 type BootstrapService struct {
-	logger *slog.Logger
-	stdout io.Writer
-	stderr io.Writer
+	logger        *slog.Logger
+	stdout        io.Writer
+	stderr        io.Writer
+	guestExecutor func(vm string) NodeExecutor
 }
 
 // NewBootstrapService creates a new K3s bootstrap service.
@@ -52,13 +61,29 @@ func (s *BootstrapService) WithOutput(stdout, stderr io.Writer) *BootstrapServic
 	return s
 }
 
+// WithGuestExecutor sets how commands reach nodes with a guest_agent_vm, which are not
+// reachable over SSH.
+func (s *BootstrapService) WithGuestExecutor(guestExecutor func(vm string) NodeExecutor) *BootstrapService {
+	s.guestExecutor = guestExecutor
+	return s
+}
+
 // BootstrapMasters installs K3s server on one or more master nodes.
 func (s *BootstrapService) BootstrapMasters(ctx context.Context, config contracts.K3sMasterBootstrapConfig) error {
 	s.logger.Info("starting K3s master bootstrap", slog.Int("nodes", len(config.Nodes)))
 
+	registries, err := RenderRegistries(config.Registries)
+	if err != nil {
+		return err
+	}
+
 	var writeMu sync.Mutex
 
 	for i, node := range config.Nodes {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("master bootstrap cancelled before %s: %w", node.Host, err)
+		}
+
 		s.logger.Info("bootstrapping K3s master",
 			slog.Int("index", i+1),
 			slog.Int("total", len(config.Nodes)),
@@ -68,7 +93,7 @@ func (s *BootstrapService) BootstrapMasters(ctx context.Context, config contract
 		nodeStdout := &LinePrefixer{prefix: node.Host, dest: s.stdout, mu: &writeMu}
 		nodeStderr := &LinePrefixer{prefix: node.Host, dest: s.stderr, mu: &writeMu}
 
-		if err := s.bootstrapMaster(ctx, node, nodeStdout, nodeStderr, config.Token); err != nil {
+		if err := s.bootstrapMaster(ctx, node, nodeStdout, nodeStderr, config.Token, registries, config.Proxy); err != nil {
 			s.logger.Error("failed to bootstrap master",
 				slog.String("host", node.Host),
 				slog.String("error", err.Error()),
@@ -89,6 +114,11 @@ func (s *BootstrapService) BootstrapWorkers(ctx context.Context, config contract
 		slog.String("master_url", config.MasterURL),
 	)
 
+	registries, err := RenderRegistries(config.Registries)
+	if err != nil {
+		return err
+	}
+
 	// Use errgroup for parallel execution with proper error handling
 	g, ctx := errgroup.WithContext(ctx)
 
@@ -109,7 +139,7 @@ func (s *BootstrapService) BootstrapWorkers(ctx context.Context, config contract
 			nodeStdout := &LinePrefixer{prefix: node.Host, dest: s.stdout, mu: &writeMu}
 			nodeStderr := &LinePrefixer{prefix: node.Host, dest: s.stderr, mu: &writeMu}
 
-			if err := s.bootstrapWorker(ctx, node, config.Token, config.MasterURL, nodeStdout, nodeStderr); err != nil {
+			if err := s.bootstrapWorker(ctx, node, config.Token, config.MasterURL, registries, config.Proxy, nodeStdout, nodeStderr); err != nil {
 				s.logger.Error("failed to bootstrap worker",
 					slog.String("host", node.Host),
 					slog.String("error", err.Error()),
@@ -131,7 +161,7 @@ func (s *BootstrapService) BootstrapWorkers(ctx context.Context, config contract
 	return nil
 }
 
-func (s *BootstrapService) bootstrapMaster(ctx context.Context, node contracts.K3sNodeConfig, stdout, stderr io.Writer, token string) error {
+func (s *BootstrapService) bootstrapMaster(ctx context.Context, node contracts.K3sNodeConfig, stdout, stderr io.Writer, token string, registries []byte, proxy *contracts.ProxyConfig) error {
 	// Create SSH executor with persistent connection
 	exec, err := s.createExecutor(node)
 	if err != nil {
@@ -139,9 +169,14 @@ func (s *BootstrapService) bootstrapMaster(ctx context.Context, node contracts.K
 	}
 	defer exec.Close()
 
+	// K3s only reads registries.yaml at startup, so it must be in place before the install starts it.
+	if err := s.writeRegistries(ctx, exec, node, registries); err != nil {
+		return err
+	}
+
 	// Use INSTALL_K3S_EXEC with environment variables as per K3s documentation
 	// Reference: https://docs.k3s.io/installation/configuration#configuration-with-install-script
-	cmd := fmt.Sprintf("curl -sfL https://get.k3s.io | INSTALL_K3S_EXEC=\"server --cluster-init\" K3S_TOKEN=%s sh -s -", token)
+	cmd := proxyExports(proxy) + fmt.Sprintf("curl -sfL https://get.k3s.io | INSTALL_K3S_EXEC=\"server --cluster-init\" K3S_TOKEN=%s sh -s -", token)
 
 	s.logger.Info("executing K3s master installation", slog.String("host", node.Host))
 
@@ -156,7 +191,7 @@ func (s *BootstrapService) bootstrapMaster(ctx context.Context, node contracts.K
 	return nil
 }
 
-func (s *BootstrapService) bootstrapWorker(ctx context.Context, node contracts.K3sNodeConfig, token, masterURL string, stdout, stderr io.Writer) error {
+func (s *BootstrapService) bootstrapWorker(ctx context.Context, node contracts.K3sNodeConfig, token, masterURL string, registries []byte, proxy *contracts.ProxyConfig, stdout, stderr io.Writer) error {
 	// Create SSH executor with persistent connection
 	exec, err := s.createExecutor(node)
 	if err != nil {
@@ -164,9 +199,14 @@ func (s *BootstrapService) bootstrapWorker(ctx context.Context, node contracts.K
 	}
 	defer exec.Close()
 
+	// K3s only reads registries.yaml at startup, so it must be in place before the install starts it.
+	if err := s.writeRegistries(ctx, exec, node, registries); err != nil {
+		return err
+	}
+
 	// Use INSTALL_K3S_EXEC with environment variables as per K3s documentation
 	// Reference: https://docs.k3s.io/installation/configuration#configuration-with-install-script
-	cmd := fmt.Sprintf("curl -sfL https://get.k3s.io | INSTALL_K3S_EXEC=\"agent\" K3S_URL=%s K3S_TOKEN=%s sh -s -", masterURL, token)
+	cmd := proxyExports(proxy) + fmt.Sprintf("curl -sfL https://get.k3s.io | INSTALL_K3S_EXEC=\"agent\" K3S_URL=%s K3S_TOKEN=%s sh -s -", masterURL, token)
 
 	s.logger.Info("executing K3s worker installation", slog.String("host", node.Host))
 
@@ -181,11 +221,19 @@ func (s *BootstrapService) bootstrapWorker(ctx context.Context, node contracts.K
 	return nil
 }
 
-func (s *BootstrapService) createExecutor(node contracts.K3sNodeConfig) (*executor.SSH, error) {
+// createExecutor connects to a node over SSH, or through the guest agent of its VM.
+func (s *BootstrapService) createExecutor(node contracts.K3sNodeConfig) (NodeExecutor, error) {
+	if node.GuestAgentVM != "" {
+		if s.guestExecutor == nil {
+			return nil, fmt.Errorf("node %s: guest agent execution is not available", node.Host)
+		}
+		return s.guestExecutor(node.GuestAgentVM), nil
+	}
 	return executor.NewSSH(executor.SSHConfig{
 		Host:    node.Host,
 		Port:    node.SSHPort,
 		User:    node.SSHUser,
 		KeyPath: node.SSHKey,
+		Key:     []byte(node.SSHPrivateKey),
 	}, s.logger)
 }
@@ -0,0 +1,211 @@
+package k3s
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/api/contracts"
+)
+
+var (
+	// ErrInvalidSnapshotName is returned for etcd snapshot names that are not plain file names.
+	ErrInvalidSnapshotName = errors.New("invalid etcd snapshot name")
+	// ErrSnapshotNotFound is returned when a server has no etcd snapshot of the given name.
+	ErrSnapshotNotFound = errors.New("etcd snapshot not found")
+)
+
+const (
+	// dataDir is the default K3s data directory.
+	dataDir = "/var/lib/rancher/k3s/server"
+	// snapshotDir is where K3s servers keep local etcd snapshots.
+	snapshotDir = dataDir + "/db/snapshots"
+)
+
+var snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidateSnapshotName checks that name can be passed to k3s and used as a file name.
+func ValidateSnapshotName(name string) error {
+	if !snapshotNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: %q (letters, digits, '.', '_' and '-' only)", ErrInvalidSnapshotName, name)
+	}
+	return nil
+}
+
+// rootCommand wraps a shell snippet so it runs as root, through sudo unless the SSH user is root.
+// The snippet must not contain single quotes.
+func rootCommand(snippet string) string {
+	return fmt.Sprintf(`s=; [ "$(id -u)" -eq 0 ] || s="sudo -n"; $s sh -c '%s'`, snippet)
+}
+
+// runOnServer runs a shell snippet as root on a server node and returns its standard output.
+func (s *BootstrapService) runOnServer(ctx context.Context, exec NodeExecutor, node contracts.K3sNodeConfig, snippet string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	if _, err := exec.Execute(ctx, &stdout, &stderr, rootCommand(snippet)); err != nil {
+		return "", fmt.Errorf("%s: %w: %s", node.Host, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// SaveEtcdSnapshot takes an on-demand etcd snapshot on a server node. K3s appends the node
+// name and a timestamp to name; the snapshots created are returned.
+func (s *BootstrapService) SaveEtcdSnapshot(ctx context.Context, node contracts.K3sNodeConfig, name string) ([]contracts.K3sEtcdSnapshot, error) {
+	if err := ValidateSnapshotName(name); err != nil {
+		return nil, err
+	}
+
+	exec, err := s.createExecutor(node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH executor: %w", err)
+	}
+	defer exec.Close()
+
+	s.logger.Info("saving etcd snapshot", slog.String("host", node.Host), slog.String("name", name))
+	if _, err := s.runOnServer(ctx, exec, node, "k3s etcd-snapshot save --name "+name); err != nil {
+		return nil, fmt.Errorf("failed to save etcd snapshot: %w", err)
+	}
+
+	snapshots, err := s.listEtcdSnapshots(ctx, exec, node)
+	if err != nil {
+		return nil, err
+	}
+	var saved []contracts.K3sEtcdSnapshot
+	for _, snapshot := range snapshots {
+		if strings.HasPrefix(snapshot.Name, name+"-") {
+			saved = append(saved, snapshot)
+		}
+	}
+	return saved, nil
+}
+
+// ListEtcdSnapshots lists the etcd snapshots known to a server node.
+func (s *BootstrapService) ListEtcdSnapshots(ctx context.Context, node contracts.K3sNodeConfig) ([]contracts.K3sEtcdSnapshot, error) {
+	exec, err := s.createExecutor(node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH executor: %w", err)
+	}
+	defer exec.Close()
+
+	return s.listEtcdSnapshots(ctx, exec, node)
+}
+
+func (s *BootstrapService) listEtcdSnapshots(ctx context.Context, exec NodeExecutor, node contracts.K3sNodeConfig) ([]contracts.K3sEtcdSnapshot, error) {
+	output, err := s.runOnServer(ctx, exec, node, "k3s etcd-snapshot ls")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list etcd snapshots: %w", err)
+	}
+	return parseSnapshotList(output), nil
+}
+
+// parseSnapshotList parses the table printed by k3s etcd-snapshot ls:
+// a header line, then name, location, size in bytes and creation time per snapshot.
+func parseSnapshotList(output string) []contracts.K3sEtcdSnapshot {
+	var snapshots []contracts.K3sEtcdSnapshot
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[0] == "Name" {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		snapshot := contracts.K3sEtcdSnapshot{Name: fields[0], Location: fields[1], Size: size}
+		if created, err := time.Parse(time.RFC3339, fields[3]); err == nil {
+			snapshot.CreatedAt = created
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots
+}
+
+// DownloadEtcdSnapshot streams a local etcd snapshot of a server node to w over the SSH connection.
+// It fails with ErrSnapshotNotFound before writing anything if the node has no such local snapshot.
+func (s *BootstrapService) DownloadEtcdSnapshot(ctx context.Context, node contracts.K3sNodeConfig, name string, w io.Writer) error {
+	if err := ValidateSnapshotName(name); err != nil {
+		return err
+	}
+
+	exec, err := s.createExecutor(node)
+	if err != nil {
+		return fmt.Errorf("failed to create SSH executor: %w", err)
+	}
+	defer exec.Close()
+
+	path := snapshotDir + "/" + name
+	if _, err := s.runOnServer(ctx, exec, node, "test -f "+path); err != nil {
+		return fmt.Errorf("%w: %s on %s", ErrSnapshotNotFound, name, node.Host)
+	}
+
+	s.logger.Info("downloading etcd snapshot", slog.String("host", node.Host), slog.String("name", name))
+	var stderr bytes.Buffer
+	if _, err := exec.Execute(ctx, w, &stderr, rootCommand("cat "+path)); err != nil {
+		return fmt.Errorf("failed to download etcd snapshot from %s: %w: %s", node.Host, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// RestoreEtcdSnapshot restores a cluster from a local etcd snapshot of its first server node.
+// K3s is stopped on every server, the first is reset from the snapshot and started again, and
+// the other servers rejoin with an empty datastore. Their previous datastore is moved aside to
+// db.pre-restore-<timestamp> rather than deleted.
+func (s *BootstrapService) RestoreEtcdSnapshot(ctx context.Context, nodes []contracts.K3sNodeConfig, name string) error {
+	if err := ValidateSnapshotName(name); err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return fmt.Errorf("no server nodes to restore")
+	}
+
+	executors := make([]NodeExecutor, len(nodes))
+	for i, node := range nodes {
+		exec, err := s.createExecutor(node)
+		if err != nil {
+			return fmt.Errorf("failed to create SSH executor for %s: %w", node.Host, err)
+		}
+		defer exec.Close()
+		executors[i] = exec
+	}
+
+	path := snapshotDir + "/" + name
+	if _, err := s.runOnServer(ctx, executors[0], nodes[0], "test -f "+path); err != nil {
+		return fmt.Errorf("%w: %s on %s", ErrSnapshotNotFound, name, nodes[0].Host)
+	}
+
+	s.logger.Info("restoring etcd snapshot",
+		slog.String("host", nodes[0].Host),
+		slog.String("name", name),
+		slog.Int("servers", len(nodes)),
+	)
+
+	for i, node := range nodes {
+		if _, err := s.runOnServer(ctx, executors[i], node, "systemctl stop k3s"); err != nil {
+			return fmt.Errorf("failed to stop k3s: %w", err)
+		}
+	}
+
+	reset := fmt.Sprintf("k3s server --cluster-reset --cluster-reset-restore-path=%s && systemctl start k3s", path)
+	if _, err := s.runOnServer(ctx, executors[0], nodes[0], reset); err != nil {
+		return fmt.Errorf("failed to reset cluster from etcd snapshot: %w", err)
+	}
+
+	aside := fmt.Sprintf("%s/db.pre-restore-%s", dataDir, time.Now().UTC().Format("20060102-150405"))
+	for i, node := range nodes[1:] {
+		rejoin := fmt.Sprintf("mv %s/db %s && systemctl start k3s", dataDir, aside)
+		if _, err := s.runOnServer(ctx, executors[i+1], node, rejoin); err != nil {
+			return fmt.Errorf("failed to rejoin server after restore: %w", err)
+		}
+	}
+
+	s.logger.Info("etcd snapshot restored", slog.String("host", nodes[0].Host), slog.String("name", name))
+	return nil
+}
@@ -0,0 +1,32 @@
+package k3s
+
+import (
+	"strings"
+
+	"github.com/terabiome/homonculus/internal/api/contracts"
+	"github.com/terabiome/homonculus/pkg/executor"
+)
+
+// proxyExports returns shell exports of the proxy settings to prefix an install command with.
+// curl downloads the install script through the proxy, and the install script copies the
+// exported variables into the environment file of the k3s service, which K3s passes on to
+// its embedded containerd for image pulls.
+func proxyExports(proxy *contracts.ProxyConfig) string {
+	if proxy == nil {
+		return ""
+	}
+	var exports []string
+	for _, variable := range []struct{ name, value string }{
+		{"HTTP_PROXY", proxy.HTTPProxy},
+		{"HTTPS_PROXY", proxy.HTTPSProxy},
+		{"NO_PROXY", proxy.NoProxy},
+	} {
+		if variable.value != "" {
+			exports = append(exports, variable.name+"="+executor.ShellQuote(variable.value))
+		}
+	}
+	if len(exports) == 0 {
+		return ""
+	}
+	return "export " + strings.Join(exports, " ") + "; "
+}
@@ -0,0 +1,115 @@
+package k3s
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/terabiome/homonculus/internal/api/contracts"
+	"go.yaml.in/yaml/v3"
+)
+
+// registriesPath is where K3s reads its container registry configuration from at startup.
+const registriesPath = "/etc/rancher/k3s/registries.yaml"
+
+// registriesFile is the layout of registries.yaml.
+// Reference: https://docs.k3s.io/installation/private-registry
+type registriesFile struct {
+	Mirrors map[string]registryMirror `yaml:"mirrors,omitempty"`
+	Configs map[string]registryConfig `yaml:"configs,omitempty"`
+}
+
+type registryMirror struct {
+	Endpoint []string          `yaml:"endpoint"`
+	Rewrite  map[string]string `yaml:"rewrite,omitempty"`
+}
+
+type registryConfig struct {
+	Auth *registryAuth `yaml:"auth,omitempty"`
+	TLS  *registryTLS  `yaml:"tls,omitempty"`
+}
+
+type registryAuth struct {
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	Token    string `yaml:"token,omitempty"`
+}
+
+type registryTLS struct {
+	CAFile             string `yaml:"ca_file,omitempty"`
+	CertFile           string `yaml:"cert_file,omitempty"`
+	KeyFile            string `yaml:"key_file,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+}
+
+// RenderRegistries renders the registries.yaml of a bootstrap config. It returns nil when
+// no registries are configured. Secret references must already be resolved.
+func RenderRegistries(registries *contracts.K3sRegistries) ([]byte, error) {
+	if registries == nil || (len(registries.Mirrors) == 0 && len(registries.Configs) == 0) {
+		return nil, nil
+	}
+
+	file := registriesFile{
+		Mirrors: make(map[string]registryMirror, len(registries.Mirrors)),
+		Configs: make(map[string]registryConfig, len(registries.Configs)),
+	}
+	for name, mirror := range registries.Mirrors {
+		if name == "" {
+			return nil, fmt.Errorf("registry mirror without a registry name")
+		}
+		for _, endpoint := range mirror.Endpoints {
+			if endpoint == "" {
+				return nil, fmt.Errorf("registry mirror %s: empty endpoint", name)
+			}
+		}
+		file.Mirrors[name] = registryMirror{Endpoint: mirror.Endpoints, Rewrite: mirror.Rewrite}
+	}
+	for host, config := range registries.Configs {
+		if host == "" {
+			return nil, fmt.Errorf("registry config without a registry host")
+		}
+		var rendered registryConfig
+		if auth := config.Auth; auth != nil {
+			if auth.Token == "" && auth.Username == "" {
+				return nil, fmt.Errorf("registry %s: auth needs a username or a token", host)
+			}
+			rendered.Auth = &registryAuth{Username: auth.Username, Password: auth.Password, Token: auth.Token}
+		}
+		if tls := config.TLS; tls != nil {
+			if (tls.CertFile == "") != (tls.KeyFile == "") {
+				return nil, fmt.Errorf("registry %s: tls cert_file and key_file must be set together", host)
+			}
+			rendered.TLS = &registryTLS{
+				CAFile:             tls.CAFile,
+				CertFile:           tls.CertFile,
+				KeyFile:            tls.KeyFile,
+				InsecureSkipVerify: tls.InsecureSkipVerify,
+			}
+		}
+		file.Configs[host] = rendered
+	}
+
+	data, err := yaml.Marshal(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render registries.yaml: %w", err)
+	}
+	return data, nil
+}
+
+// writeRegistries writes a rendered registries.yaml to a node. The content is passed on
+// standard input so registry credentials never appear in the logged command line.
+func (s *BootstrapService) writeRegistries(ctx context.Context, exec NodeExecutor, node contracts.K3sNodeConfig, data []byte) error {
+	if data == nil {
+		return nil
+	}
+
+	s.logger.Info("writing K3s registries configuration", slog.String("host", node.Host))
+	write := fmt.Sprintf("umask 077 && mkdir -p /etc/rancher/k3s && cat > %s", registriesPath)
+	var stdout, stderr bytes.Buffer
+	if _, err := exec.ExecuteWithInput(ctx, bytes.NewReader(data), &stdout, &stderr, rootCommand(write)); err != nil {
+		return fmt.Errorf("failed to write %s: %w: %s", registriesPath, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package k3s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/api/contracts"
+)
+
+// ErrInvalidNodeName is returned for names that are not valid Kubernetes node names.
+var ErrInvalidNodeName = errors.New("invalid node name")
+
+// nodeNamePattern matches Kubernetes node names, which are DNS subdomains.
+var nodeNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]{0,251}[a-z0-9])?$`)
+
+// ValidateNodeName checks that name is a valid Kubernetes node name.
+func ValidateNodeName(name string) error {
+	if !nodeNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: %q (lowercase letters, digits, '.' and '-' only)", ErrInvalidNodeName, name)
+	}
+	return nil
+}
+
+// uninstallCommand runs whichever uninstall script the K3s install script left on a node.
+// Nodes without K3s are left as they are.
+const uninstallCommand = `if [ -x /usr/local/bin/k3s-agent-uninstall.sh ]; then /usr/local/bin/k3s-agent-uninstall.sh; ` +
+	`elif [ -x /usr/local/bin/k3s-uninstall.sh ]; then /usr/local/bin/k3s-uninstall.sh; fi`
+
+// RemoveNode removes a node from its cluster: kubectl on master cordons and drains it and
+// deletes its node object, then K3s is uninstalled from the node. Without a node name, the
+// hostname of the node is used. The removed node name is returned.
+func (s *BootstrapService) RemoveNode(ctx context.Context, master, node contracts.K3sNodeConfig, nodeName string, drainTimeout time.Duration) (string, error) {
+	nodeExec, err := s.createExecutor(node)
+	if err != nil {
+		return "", fmt.Errorf("failed to create SSH executor for %s: %w", node.Host, err)
+	}
+	defer nodeExec.Close()
+
+	if nodeName == "" {
+		hostname, err := s.runOnServer(ctx, nodeExec, node, "hostname")
+		if err != nil {
+			return "", fmt.Errorf("failed to read node hostname: %w", err)
+		}
+		nodeName = strings.ToLower(strings.TrimSpace(hostname))
+	}
+	if err := ValidateNodeName(nodeName); err != nil {
+		return "", err
+	}
+
+	masterExec, err := s.createExecutor(master)
+	if err != nil {
+		return "", fmt.Errorf("failed to create SSH executor for %s: %w", master.Host, err)
+	}
+	defer masterExec.Close()
+
+	s.logger.Info("removing K3s node",
+		slog.String("node", nodeName),
+		slog.String("host", node.Host),
+		slog.String("master", master.Host),
+	)
+
+	if _, err := s.runOnServer(ctx, masterExec, master, "k3s kubectl cordon "+nodeName); err != nil {
+		return "", fmt.Errorf("failed to cordon node %s: %w", nodeName, err)
+	}
+
+	s.logger.Info("draining K3s node", slog.String("node", nodeName), slog.Duration("timeout", drainTimeout))
+	drain := fmt.Sprintf("k3s kubectl drain %s --ignore-daemonsets --delete-emptydir-data --timeout=%ds", nodeName, int(drainTimeout.Seconds()))
+	if _, err := s.runOnServer(ctx, masterExec, master, drain); err != nil {
+		// Leave the node cordoned, so nothing new lands on it while the drain is retried.
+		return "", fmt.Errorf("failed to drain node %s: %w", nodeName, err)
+	}
+
+	if _, err := s.runOnServer(ctx, masterExec, master, "k3s kubectl delete node "+nodeName); err != nil {
+		return "", fmt.Errorf("failed to delete node %s: %w", nodeName, err)
+	}
+
+	if _, err := s.runOnServer(ctx, nodeExec, node, uninstallCommand); err != nil {
+		return "", fmt.Errorf("failed to uninstall k3s: %w", err)
+	}
+
+	s.logger.Info("K3s node removed", slog.String("node", nodeName), slog.String("host", node.Host))
+	return nodeName, nil
+}
@@ -0,0 +1,353 @@
+package k3s
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/terabiome/homonculus/internal/api/contracts"
+	"github.com/terabiome/homonculus/pkg/executor"
+	"go.yaml.in/yaml/v3"
+)
+
+// ErrClusterUnhealthy is returned when a bootstrapped cluster did not become healthy in time.
+var ErrClusterUnhealthy = errors.New("cluster not healthy")
+
+// kubeconfigPath is where k3s servers write the admin kubeconfig.
+const kubeconfigPath = "/etc/rancher/k3s/k3s.yaml"
+
+// verifyPollInterval is how often the health of a cluster is checked while verifying it.
+const verifyPollInterval = 5 * time.Second
+
+// VerifyCluster reads the admin kubeconfig from a master and waits until the cluster is healthy:
+// every bootstrapped host is registered as a node, every node is Ready and every kube-system pod
+// runs. The API server is reached through an SSH tunnel to the master, so it need not be
+// reachable from here; for a master reached through its guest agent, the API is queried with
+// kubectl on the master instead. On timeout it returns the last observed health with ErrClusterUnhealthy.
+func (s *BootstrapService) VerifyCluster(ctx context.Context, master contracts.K3sNodeConfig, hosts []string, timeout time.Duration) (contracts.K3sClusterHealth, error) {
+	exec, err := s.createExecutor(master)
+	if err != nil {
+		return contracts.K3sClusterHealth{}, fmt.Errorf("failed to create SSH executor: %w", err)
+	}
+	defer exec.Close()
+
+	var stdout, stderr bytes.Buffer
+	if _, err := exec.Execute(ctx, &stdout, &stderr, rootCommand("cat "+kubeconfigPath)); err != nil {
+		return contracts.K3sClusterHealth{}, fmt.Errorf("failed to read kubeconfig from %s: %w: %s", master.Host, err, strings.TrimSpace(stderr.String()))
+	}
+
+	client, err := newKubeClient(stdout.Bytes())
+	if err != nil {
+		return contracts.K3sClusterHealth{}, fmt.Errorf("kubeconfig of %s: %w", master.Host, err)
+	}
+	if dialer, ok := exec.(executor.Dialer); ok {
+		// The kubeconfig points at the API server on the master itself.
+		client.http.Transport.(*http.Transport).DialContext = dialer.DialContext
+	} else {
+		client.exec = exec
+	}
+
+	s.logger.Info("verifying K3s cluster health",
+		slog.String("master", master.Host),
+		slog.Int("hosts", len(hosts)),
+		slog.Duration("timeout", timeout),
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var health contracts.K3sClusterHealth
+	var lastErr error
+	for {
+		current, err := client.health(ctx, hosts)
+		if err == nil {
+			health, lastErr = current, nil
+			if health.Healthy {
+				s.logger.Info("K3s cluster is healthy",
+					slog.Int("nodes", len(health.Nodes)),
+					slog.Int("pods", len(health.Pods)),
+				)
+				return health, nil
+			}
+		} else if ctx.Err() == nil {
+			lastErr = err
+			s.logger.Debug("failed to query K3s cluster health", slog.String("error", err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return health, fmt.Errorf("%w after %s: %w", ErrClusterUnhealthy, timeout, lastErr)
+			}
+			return health, fmt.Errorf("%w after %s: %s", ErrClusterUnhealthy, timeout, describeUnhealthy(health))
+		case <-time.After(verifyPollInterval):
+		}
+	}
+}
+
+// describeUnhealthy names what keeps a cluster from being healthy.
+func describeUnhealthy(health contracts.K3sClusterHealth) string {
+	var problems []string
+	if len(health.Missing) > 0 {
+		problems = append(problems, fmt.Sprintf("hosts not registered as nodes: %v", health.Missing))
+	}
+	var notReady []string
+	for _, node := range health.Nodes {
+		if !node.Ready {
+			notReady = append(notReady, node.Name)
+		}
+	}
+	if len(notReady) > 0 {
+		problems = append(problems, fmt.Sprintf("nodes not ready: %v", notReady))
+	}
+	var pending []string
+	for _, pod := range health.Pods {
+		if !pod.Ready {
+			pending = append(pending, pod.Name+" ("+pod.Phase+")")
+		}
+	}
+	if len(pending) > 0 {
+		problems = append(problems, fmt.Sprintf("kube-system pods not running: %v", pending))
+	}
+	if len(health.Pods) == 0 {
+		problems = append(problems, "no kube-system pods scheduled yet")
+	}
+	return strings.Join(problems, "; ")
+}
+
+// kubeClient queries a Kubernetes API server with the credentials of a kubeconfig.
+type kubeClient struct {
+	server string
+	token  string
+	http   *http.Client
+	exec   NodeExecutor // when set, requests are made with kubectl on the master instead
+}
+
+// kubeconfig is the part of a kubeconfig file needed to reach the API server of its current context.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Contexts       []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Clusters []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKeyData         string `yaml:"client-key-data"`
+			Token                 string `yaml:"token"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// newKubeClient builds a client for the current context of a kubeconfig. Only inline
+// certificate data and bearer tokens are supported, as written by k3s.
+func newKubeClient(data []byte) (*kubeClient, error) {
+	var config kubeconfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+
+	clusterName, userName := "", ""
+	for _, context := range config.Contexts {
+		if context.Name == config.CurrentContext || config.CurrentContext == "" {
+			clusterName, userName = context.Context.Cluster, context.Context.User
+			break
+		}
+	}
+
+	var server, caData string
+	var insecure, found bool
+	for _, cluster := range config.Clusters {
+		if cluster.Name == clusterName {
+			server, caData, insecure, found = cluster.Cluster.Server, cluster.Cluster.CertificateAuthorityData, cluster.Cluster.InsecureSkipTLSVerify, true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("cluster %q of the current context not found", clusterName)
+	}
+	if _, err := url.Parse(server); err != nil || server == "" {
+		return nil, fmt.Errorf("invalid server %q", server)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: insecure}
+	if caData != "" {
+		ca, err := base64.StdEncoding.DecodeString(caData)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate-authority-data: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("certificate-authority-data holds no certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	client := &kubeClient{server: strings.TrimSuffix(server, "/")}
+	for _, user := range config.Users {
+		if user.Name != userName {
+			continue
+		}
+		client.token = user.User.Token
+		if user.User.ClientCertificateData != "" {
+			cert, err := base64.StdEncoding.DecodeString(user.User.ClientCertificateData)
+			if err != nil {
+				return nil, fmt.Errorf("invalid client-certificate-data: %w", err)
+			}
+			key, err := base64.StdEncoding.DecodeString(user.User.ClientKeyData)
+			if err != nil {
+				return nil, fmt.Errorf("invalid client-key-data: %w", err)
+			}
+			keyPair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid client certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{keyPair}
+		}
+		break
+	}
+
+	client.http = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	return client, nil
+}
+
+// get decodes the JSON response of a GET request to an API path.
+func (c *kubeClient) get(ctx context.Context, path string, target any) error {
+	if c.exec != nil {
+		var stdout, stderr bytes.Buffer
+		if _, err := c.exec.Execute(ctx, &stdout, &stderr, rootCommand("k3s kubectl get --raw "+path)); err != nil {
+			return fmt.Errorf("GET %s: %w: %s", path, err, strings.TrimSpace(stderr.String()))
+		}
+		return json.Unmarshal(stdout.Bytes(), target)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.server+path, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("GET %s: %s: %s", path, response.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(response.Body).Decode(target)
+}
+
+// health lists the nodes and kube-system pods and reports whether the cluster is healthy.
+func (c *kubeClient) health(ctx context.Context, hosts []string) (contracts.K3sClusterHealth, error) {
+	var nodes struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Status struct {
+				Conditions []struct {
+					Type   string `json:"type"`
+					Status string `json:"status"`
+				} `json:"conditions"`
+				Addresses []struct {
+					Address string `json:"address"`
+				} `json:"addresses"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := c.get(ctx, "/api/v1/nodes", &nodes); err != nil {
+		return contracts.K3sClusterHealth{}, err
+	}
+
+	var pods struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Status struct {
+				Phase             string `json:"phase"`
+				ContainerStatuses []struct {
+					Ready bool `json:"ready"`
+				} `json:"containerStatuses"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := c.get(ctx, "/api/v1/namespaces/kube-system/pods", &pods); err != nil {
+		return contracts.K3sClusterHealth{}, err
+	}
+
+	health := contracts.K3sClusterHealth{Healthy: len(pods.Items) > 0}
+	registered := make(map[string]bool)
+	for _, item := range nodes.Items {
+		node := contracts.K3sNodeState{Name: item.Metadata.Name}
+		registered[item.Metadata.Name] = true
+		for _, address := range item.Status.Addresses {
+			node.Addresses = append(node.Addresses, address.Address)
+			registered[address.Address] = true
+		}
+		for _, condition := range item.Status.Conditions {
+			if condition.Type == "Ready" {
+				node.Ready = condition.Status == "True"
+			}
+		}
+		health.Healthy = health.Healthy && node.Ready
+		health.Nodes = append(health.Nodes, node)
+	}
+
+	for _, host := range hosts {
+		// Hosts may be given as FQDNs of nodes registered under their short hostname.
+		if !registered[host] && !registered[strings.SplitN(host, ".", 2)[0]] {
+			health.Missing = append(health.Missing, host)
+			health.Healthy = false
+		}
+	}
+
+	for _, item := range pods.Items {
+		pod := contracts.K3sPodState{Name: item.Metadata.Name, Phase: item.Status.Phase}
+		switch item.Status.Phase {
+		case "Succeeded":
+			// Completed jobs, such as the helm installs of bundled charts.
+			pod.Ready = true
+		case "Running":
+			pod.Ready = true
+			for _, container := range item.Status.ContainerStatuses {
+				pod.Ready = pod.Ready && container.Ready
+			}
+		}
+		health.Healthy = health.Healthy && pod.Ready
+		health.Pods = append(health.Pods, pod)
+	}
+	return health, nil
+}
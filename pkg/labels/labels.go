@@ -0,0 +1,138 @@
+package labels
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const maxLabelLength = 63
+
+var labelPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+
+// Validate checks that every label key and value is well-formed.
+// Keys are required; values may be empty.
+func Validate(labels map[string]string) error {
+	for key, value := range labels {
+		if err := validateToken(key); err != nil {
+			return fmt.Errorf("invalid label key %q: %w", key, err)
+		}
+		if value == "" {
+			continue
+		}
+		if err := validateToken(value); err != nil {
+			return fmt.Errorf("invalid value for label %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func validateToken(token string) error {
+	if token == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	if len(token) > maxLabelLength {
+		return fmt.Errorf("must be at most %d characters", maxLabelLength)
+	}
+	if !labelPattern.MatchString(token) {
+		return fmt.Errorf("must consist of alphanumerics, '.', '_', '-' or '/' and start and end with an alphanumeric")
+	}
+	return nil
+}
+
+// Operator is a selector requirement operator.
+type Operator string
+
+const (
+	OperatorEquals    Operator = "="
+	OperatorNotEquals Operator = "!="
+)
+
+// Requirement is a single key/value constraint of a selector.
+type Requirement struct {
+	Key      string
+	Operator Operator
+	Value    string
+}
+
+// Matches reports whether the labels satisfy the requirement.
+func (r Requirement) Matches(labels map[string]string) bool {
+	value, ok := labels[r.Key]
+	switch r.Operator {
+	case OperatorNotEquals:
+		return !ok || value != r.Value
+	default:
+		return ok && value == r.Value
+	}
+}
+
+// Selector is a conjunction of requirements, e.g. "cluster=prod,role!=master".
+type Selector []Requirement
+
+// Parse parses a comma-separated selector expression.
+// Supported forms are "key=value", "key==value" and "key!=value".
+func Parse(expression string) (Selector, error) {
+	expression = strings.TrimSpace(expression)
+	if expression == "" {
+		return nil, nil
+	}
+
+	var selector Selector
+	for _, term := range strings.Split(expression, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			return nil, fmt.Errorf("empty term in selector %q", expression)
+		}
+
+		var requirement Requirement
+		switch {
+		case strings.Contains(term, "!="):
+			key, value, _ := strings.Cut(term, "!=")
+			requirement = Requirement{Key: key, Operator: OperatorNotEquals, Value: value}
+		case strings.Contains(term, "=="):
+			key, value, _ := strings.Cut(term, "==")
+			requirement = Requirement{Key: key, Operator: OperatorEquals, Value: value}
+		case strings.Contains(term, "="):
+			key, value, _ := strings.Cut(term, "=")
+			requirement = Requirement{Key: key, Operator: OperatorEquals, Value: value}
+		default:
+			return nil, fmt.Errorf("selector term %q must be of the form key=value or key!=value", term)
+		}
+
+		requirement.Key = strings.TrimSpace(requirement.Key)
+		requirement.Value = strings.TrimSpace(requirement.Value)
+		if err := validateToken(requirement.Key); err != nil {
+			return nil, fmt.Errorf("invalid selector key %q: %w", requirement.Key, err)
+		}
+		selector = append(selector, requirement)
+	}
+
+	return selector, nil
+}
+
+// Empty reports whether the selector has no requirements.
+func (s Selector) Empty() bool {
+	return len(s) == 0
+}
+
+// Matches reports whether the labels satisfy every requirement of the selector.
+// An empty selector matches everything.
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, requirement := range s {
+		if !requirement.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// String renders the selector back into its canonical expression.
+func (s Selector) String() string {
+	terms := make([]string, len(s))
+	for i, requirement := range s {
+		terms[i] = requirement.Key + string(requirement.Operator) + requirement.Value
+	}
+	sort.Strings(terms)
+	return strings.Join(terms, ",")
+}
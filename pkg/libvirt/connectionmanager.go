@@ -1,82 +1,346 @@
 package libvirt
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"sync"
+	"time"
 
 	"github.com/terabiome/homonculus/pkg/executor"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 	"libvirt.org/go/libvirt"
 )
 
+// DefaultMaxConnections is the pool size used when none is configured.
+const DefaultMaxConnections = 4
+
+// Keepalive configures libvirt keepalive messages on pooled connections, so libvirt closes a
+// connection whose peer stopped answering instead of the next call hanging on it.
+type Keepalive struct {
+	Interval time.Duration // between messages; 0 disables keepalives
+	Count    uint          // unanswered messages after which the connection is closed
+}
+
+// DefaultKeepalive notices a dead libvirtd within about 20 seconds.
+var DefaultKeepalive = Keepalive{Interval: 5 * time.Second, Count: 3}
+
+// What noticed a dead connection, recorded on the reconnect metric.
+const (
+	triggerHandout     = "handout"      // the health check when handing out a connection
+	triggerHealthCheck = "health_check" // CheckConnections
+	triggerOperation   = "operation"    // Reconnect, called by an operation that lost its connection
+)
+
+// ConnectionCheck is the result of ConnectionManager.CheckConnections.
+type ConnectionCheck struct {
+	Checked int  // idle connections checked
+	Dropped int  // dead connections closed
+	Opened  bool // a new connection was opened, as no live idle connection was left
+}
+
+// ConnectionManager pools libvirt connections to one hypervisor, so long operations
+// do not block others. Connections are health-checked when handed out and replaced when dead.
 type ConnectionManager struct {
-	conn     *libvirt.Connect
-	executor executor.Executor
-	mu       sync.Mutex
-	uri      string
-	logger   *slog.Logger
+	executor  executor.Executor
+	uri       string
+	keepalive Keepalive
+	logger    *slog.Logger
+
+	reconnects metric.Int64Counter
+
+	// slots holds one token per connection that may be in use at once.
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []*libvirt.Connect
+	closed bool
+
+	// offline managers hand out no libvirt connection, for backends that do not talk to libvirt.
+	offline bool
 }
 
 func NewConnectionManager(uri string, logger *slog.Logger) (*ConnectionManager, error) {
-	conn, err := libvirt.NewConnect(uri)
+	return NewConnectionManagerWithExecutor(uri, executor.NewLocal(logger), logger)
+}
+
+// NewConnectionManagerWithExecutor creates a connection manager whose host-side commands
+// (qemu-img, mkisofs, rm, ...) run through the given executor, e.g. SSH for remote hypervisors.
+func NewConnectionManagerWithExecutor(uri string, exec executor.Executor, logger *slog.Logger) (*ConnectionManager, error) {
+	return NewConnectionPool(uri, exec, DefaultMaxConnections, DefaultKeepalive, logger)
+}
+
+// NewConnectionPool creates a connection manager holding up to maxConnections connections.
+// One connection is opened eagerly so configuration errors surface at startup.
+func NewConnectionPool(uri string, exec executor.Executor, maxConnections int, keepalive Keepalive, logger *slog.Logger) (*ConnectionManager, error) {
+	cm := NewDisconnectedConnectionPool(uri, exec, maxConnections, keepalive, logger)
+	conn, err := cm.connect()
+	if err != nil {
+		return nil, err
+	}
+	cm.idle = []*libvirt.Connect{conn}
+
+	logger.Info("libvirt connection established",
+		slog.String("uri", uri),
+		slog.Int("max_connections", cap(cm.slots)),
+		slog.Duration("keepalive_interval", cm.keepalive.Interval),
+	)
+	return cm, nil
+}
+
+// NewDisconnectedConnectionPool creates a connection manager like NewConnectionPool without
+// connecting first, for hypervisors that are unreachable at startup. Connections are opened
+// when first needed, so the manager recovers by itself once libvirtd is up.
+func NewDisconnectedConnectionPool(uri string, exec executor.Executor, maxConnections int, keepalive Keepalive, logger *slog.Logger) *ConnectionManager {
+	if maxConnections < 1 {
+		maxConnections = 1
+	}
+
+	// Keepalive messages are only exchanged while an event loop runs.
+	if keepalive.Interval > 0 {
+		if err := startEventLoop(); err != nil {
+			logger.Warn("failed to start libvirt event loop, keepalives disabled", slog.String("error", err.Error()))
+			keepalive = Keepalive{}
+		}
+	}
+
+	return &ConnectionManager{
+		executor:   exec,
+		uri:        uri,
+		keepalive:  keepalive,
+		logger:     logger,
+		reconnects: newReconnectCounter(logger),
+		slots:      make(chan struct{}, maxConnections),
+	}
+}
+
+// newReconnectCounter creates the metric counting connections replaced after they died.
+func newReconnectCounter(logger *slog.Logger) metric.Int64Counter {
+	reconnects, err := otel.Meter("homonculus/libvirt").Int64Counter(
+		"homonculus.libvirt.reconnects",
+		metric.WithDescription("Number of dead libvirt connections replaced, by URI, trigger and outcome"),
+		metric.WithUnit("{reconnect}"),
+	)
+	if err != nil {
+		logger.Warn("failed to create libvirt reconnect metric", slog.String("error", err.Error()))
+	}
+	return reconnects
+}
+
+// connect opens a new connection with keepalives enabled.
+func (cm *ConnectionManager) connect() (*libvirt.Connect, error) {
+	conn, err := libvirt.NewConnect(cm.uri)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to libvirt: %w", err)
 	}
+	if cm.keepalive.Interval > 0 {
+		interval := max(int(cm.keepalive.Interval/time.Second), 1)
+		if err := conn.SetKeepAlive(interval, cm.keepalive.Count); err != nil {
+			cm.logger.Debug("failed to enable libvirt keepalive", slog.String("uri", cm.uri), slog.String("error", err.Error()))
+		}
+	}
+	return conn, nil
+}
+
+// recordReconnect counts a replacement of a dead connection.
+func (cm *ConnectionManager) recordReconnect(trigger string, err error) {
+	if cm.reconnects == nil {
+		return
+	}
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	cm.reconnects.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("uri", cm.uri),
+		attribute.String("trigger", trigger),
+		attribute.String("outcome", outcome),
+	))
+}
 
-	logger.Info("libvirt connection established", slog.String("uri", uri))
+// NewOfflineConnectionManager creates a connection manager that never connects to libvirt.
+// It hands out nil connections and exec, so in-memory backends can run without a hypervisor.
+func NewOfflineConnectionManager(uri string, exec executor.Executor, logger *slog.Logger) *ConnectionManager {
+	logger.Info("using offline connection manager", slog.String("uri", uri))
 
 	return &ConnectionManager{
-		conn:     conn,
-		executor: executor.NewLocal(logger),
+		executor: exec,
 		uri:      uri,
 		logger:   logger,
-	}, nil
+		slots:    make(chan struct{}, DefaultMaxConnections),
+		offline:  true,
+	}
 }
 
-func (cm *ConnectionManager) GetHypervisor() (*libvirt.Connect, executor.Executor, func(), error) {
-	cm.mu.Lock()
+// GetHypervisor hands out a healthy connection, waiting for a free one while the pool is exhausted.
+// The returned func gives the connection back and must be called exactly once.
+func (cm *ConnectionManager) GetHypervisor(ctx context.Context) (*libvirt.Connect, executor.Executor, func(), error) {
+	select {
+	case cm.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, nil, nil, fmt.Errorf("waiting for libvirt connection: %w", ctx.Err())
+	}
+
+	if cm.offline {
+		var once sync.Once
+		return nil, cm.executor, func() { once.Do(func() { <-cm.slots }) }, nil
+	}
+
+	conn, err := cm.take()
+	if err != nil {
+		<-cm.slots
+		return nil, nil, nil, err
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			cm.put(conn)
+			<-cm.slots
+		})
+	}
+	return conn, cm.executor, release, nil
+}
 
-	alive, err := cm.conn.IsAlive()
-	if err != nil || !alive {
-		cm.logger.Warn("connection unhealthy, attempting reconnect")
-		if err := cm.reconnect(); err != nil {
+// take returns a live idle connection, opening a new one if none is available.
+func (cm *ConnectionManager) take() (*libvirt.Connect, error) {
+	dropped := false
+	for {
+		cm.mu.Lock()
+		if cm.closed {
 			cm.mu.Unlock()
-			return nil, nil, nil, err
+			return nil, fmt.Errorf("connection manager for %s is closed", cm.uri)
 		}
+		if len(cm.idle) == 0 {
+			cm.mu.Unlock()
+			break
+		}
+		conn := cm.idle[len(cm.idle)-1]
+		cm.idle = cm.idle[:len(cm.idle)-1]
+		cm.mu.Unlock()
+
+		if alive, err := conn.IsAlive(); err == nil && alive {
+			return conn, nil
+		}
+		cm.logger.Warn("dropping unhealthy libvirt connection", slog.String("uri", cm.uri))
+		conn.Close()
+		dropped = true
 	}
 
-	unlock := func() { cm.mu.Unlock() }
-	return cm.conn, cm.executor, unlock, nil
+	conn, err := cm.connect()
+	if dropped {
+		cm.recordReconnect(triggerHandout, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cm.logger.Debug("opened libvirt connection", slog.String("uri", cm.uri))
+	return conn, nil
 }
 
-func (cm *ConnectionManager) reconnect() error {
-	if cm.conn != nil {
-		cm.conn.Close()
+// CheckConnections checks the idle connections, closing dead ones, so a libvirtd that went away
+// is noticed between operations rather than by the next one. When no live idle connection is
+// left, a new one is opened; the error tells the hypervisor is unreachable. Connections in use
+// are left to their holders.
+func (cm *ConnectionManager) CheckConnections() (ConnectionCheck, error) {
+	var check ConnectionCheck
+	if cm.offline {
+		return check, nil
 	}
 
-	conn, err := libvirt.NewConnect(cm.uri)
-	if err != nil {
-		return fmt.Errorf("reconnection failed: %w", err)
+	cm.mu.Lock()
+	if cm.closed {
+		cm.mu.Unlock()
+		return check, fmt.Errorf("connection manager for %s is closed", cm.uri)
 	}
+	idle := cm.idle
+	cm.idle = nil
+	cm.mu.Unlock()
 
-	cm.conn = conn
-	cm.logger.Info("libvirt reconnected", slog.String("uri", cm.uri))
+	var live []*libvirt.Connect
+	for _, conn := range idle {
+		check.Checked++
+		if alive, err := conn.IsAlive(); err == nil && alive {
+			live = append(live, conn)
+			continue
+		}
+		cm.logger.Warn("dropping unhealthy libvirt connection", slog.String("uri", cm.uri))
+		conn.Close()
+		check.Dropped++
+	}
+
+	var err error
+	if len(live) == 0 {
+		var conn *libvirt.Connect
+		conn, err = cm.connect()
+		if check.Dropped > 0 {
+			cm.recordReconnect(triggerHealthCheck, err)
+		}
+		if err == nil {
+			live = append(live, conn)
+			check.Opened = true
+		}
+	}
+	for _, conn := range live {
+		cm.put(conn)
+	}
+	return check, err
+}
+
+// Reconnect replaces a handed-out connection that stopped working, e.g. because libvirtd
+// restarted, with a new connection in place, so its holder keeps using the same handle and
+// gives the new connection back on release. The broken connection is only closed once the
+// new one is open.
+func (cm *ConnectionManager) Reconnect(conn *libvirt.Connect) error {
+	if cm.offline || conn == nil {
+		return fmt.Errorf("connection manager for %s has no libvirt connection", cm.uri)
+	}
+
+	fresh, err := cm.connect()
+	cm.recordReconnect(triggerOperation, err)
+	if err != nil {
+		return fmt.Errorf("failed to reconnect: %w", err)
+	}
+	conn.Close()
+	*conn = *fresh
+	cm.logger.Info("reconnected to libvirt", slog.String("uri", cm.uri))
 	return nil
 }
 
+// put returns a connection to the idle list, closing it if the manager was closed meanwhile
+// or the idle list is full, as connections were opened while CheckConnections held the idle ones.
+func (cm *ConnectionManager) put(conn *libvirt.Connect) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if cm.closed || len(cm.idle) >= cap(cm.slots) {
+		conn.Close()
+		return
+	}
+	cm.idle = append(cm.idle, conn)
+}
+
 // GetURI returns the libvirt URI being used
 func (cm *ConnectionManager) GetURI() string {
 	return cm.uri
 }
 
+// Close closes idle connections; connections still in use are closed when released.
 func (cm *ConnectionManager) Close() error {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
-	if cm.conn != nil {
-		cm.logger.Info("closing libvirt connection")
-		_, err := cm.conn.Close()
-		return err
+	cm.closed = true
+	cm.logger.Info("closing libvirt connections", slog.String("uri", cm.uri))
+
+	var firstErr error
+	for _, conn := range cm.idle {
+		if _, err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
-	return nil
+	cm.idle = nil
+	return firstErr
 }
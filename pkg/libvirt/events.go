@@ -0,0 +1,126 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"libvirt.org/go/libvirt"
+)
+
+// LifecycleEvent is a domain lifecycle change reported by libvirt.
+type LifecycleEvent struct {
+	Domain      string
+	Event       string // defined, undefined, started, suspended, resumed, stopped, shutdown, pmsuspended or crashed
+	Description string
+}
+
+var (
+	eventLoopOnce sync.Once
+	eventLoopErr  error
+)
+
+// startEventLoop registers libvirt's default event loop implementation and runs it in the background.
+// Only connections opened afterwards deliver events.
+func startEventLoop() error {
+	eventLoopOnce.Do(func() {
+		if eventLoopErr = libvirt.EventRegisterDefaultImpl(); eventLoopErr != nil {
+			return
+		}
+		go func() {
+			for {
+				if err := libvirt.EventRunDefaultImpl(); err != nil {
+					time.Sleep(time.Second)
+				}
+			}
+		}()
+	})
+	return eventLoopErr
+}
+
+// WatchLifecycleEvents calls fn for every domain lifecycle event of the hypervisor until ctx is done
+// or the connection is lost. It uses a dedicated connection outside of the pool.
+// Offline managers report no events.
+func (cm *ConnectionManager) WatchLifecycleEvents(ctx context.Context, fn func(LifecycleEvent)) error {
+	if cm.offline {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	if err := startEventLoop(); err != nil {
+		return fmt.Errorf("failed to start libvirt event loop: %w", err)
+	}
+
+	conn, err := libvirt.NewConnect(cm.uri)
+	if err != nil {
+		return fmt.Errorf("failed to connect to libvirt: %w", err)
+	}
+	defer conn.Close()
+
+	// Keepalives let libvirt notice a dead connection and fire the close callback.
+	if err := conn.SetKeepAlive(5, 3); err != nil {
+		cm.logger.Debug("failed to enable libvirt keepalive", slog.String("uri", cm.uri), slog.String("error", err.Error()))
+	}
+
+	closed := make(chan struct{})
+	var closeOnce sync.Once
+	if err := conn.RegisterCloseCallback(func(*libvirt.Connect, libvirt.ConnectCloseReason) {
+		closeOnce.Do(func() { close(closed) })
+	}); err != nil {
+		return fmt.Errorf("failed to register close callback: %w", err)
+	}
+	defer conn.UnregisterCloseCallback()
+
+	callbackID, err := conn.DomainEventLifecycleRegister(nil, func(_ *libvirt.Connect, domain *libvirt.Domain, event *libvirt.DomainEventLifecycle) {
+		name, err := domain.GetName()
+		if err != nil {
+			return
+		}
+		fn(LifecycleEvent{
+			Domain:      name,
+			Event:       lifecycleEventName(event.Event),
+			Description: event.String(),
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register lifecycle events: %w", err)
+	}
+	defer conn.DomainEventDeregister(callbackID)
+
+	cm.logger.Info("watching libvirt lifecycle events", slog.String("uri", cm.uri))
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-closed:
+		return fmt.Errorf("libvirt connection to %s closed", cm.uri)
+	}
+}
+
+// lifecycleEventName returns the short name of a lifecycle event type.
+func lifecycleEventName(eventType libvirt.DomainEventType) string {
+	switch eventType {
+	case libvirt.DOMAIN_EVENT_DEFINED:
+		return "defined"
+	case libvirt.DOMAIN_EVENT_UNDEFINED:
+		return "undefined"
+	case libvirt.DOMAIN_EVENT_STARTED:
+		return "started"
+	case libvirt.DOMAIN_EVENT_SUSPENDED:
+		return "suspended"
+	case libvirt.DOMAIN_EVENT_RESUMED:
+		return "resumed"
+	case libvirt.DOMAIN_EVENT_STOPPED:
+		return "stopped"
+	case libvirt.DOMAIN_EVENT_SHUTDOWN:
+		return "shutdown"
+	case libvirt.DOMAIN_EVENT_PMSUSPENDED:
+		return "pmsuspended"
+	case libvirt.DOMAIN_EVENT_CRASHED:
+		return "crashed"
+	default:
+		return "unknown"
+	}
+}
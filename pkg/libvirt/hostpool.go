@@ -0,0 +1,65 @@
+package libvirt
+
+import (
+	"errors"
+	"fmt"
+)
+
+// HostPool holds one ConnectionManager per configured hypervisor host.
+// The first host added is the default for VMs without a recorded placement.
+type HostPool struct {
+	managers map[string]*ConnectionManager
+	names    []string
+}
+
+// NewHostPool creates an empty host pool.
+func NewHostPool() *HostPool {
+	return &HostPool{
+		managers: make(map[string]*ConnectionManager),
+	}
+}
+
+// Add registers a connection manager under a unique host name.
+func (p *HostPool) Add(name string, cm *ConnectionManager) error {
+	if _, exists := p.managers[name]; exists {
+		return fmt.Errorf("duplicate hypervisor host name: %s", name)
+	}
+	p.managers[name] = cm
+	p.names = append(p.names, name)
+	return nil
+}
+
+// Get returns the connection manager of the named host.
+func (p *HostPool) Get(name string) (*ConnectionManager, bool) {
+	cm, ok := p.managers[name]
+	return cm, ok
+}
+
+// Names returns the host names in registration order.
+func (p *HostPool) Names() []string {
+	return append([]string(nil), p.names...)
+}
+
+// Default returns the name of the default host.
+func (p *HostPool) Default() string {
+	if len(p.names) == 0 {
+		return ""
+	}
+	return p.names[0]
+}
+
+// Len returns the number of configured hosts.
+func (p *HostPool) Len() int {
+	return len(p.names)
+}
+
+// Close closes every connection in the pool.
+func (p *HostPool) Close() error {
+	var errs []error
+	for _, name := range p.names {
+		if err := p.managers[name].Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
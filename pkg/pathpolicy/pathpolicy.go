@@ -0,0 +1,56 @@
+package pathpolicy
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotAllowed is returned for paths outside the allowed directories.
+var ErrNotAllowed = errors.New("path not allowed")
+
+// AllowList restricts host file paths to a set of directories.
+// The check is lexical: symlinks inside the allowed directories are not resolved.
+type AllowList struct {
+	dirs []string
+}
+
+// NewAllowList creates an allow list of absolute directories. An empty list allows every path.
+func NewAllowList(dirs []string) (*AllowList, error) {
+	list := &AllowList{}
+	for _, dir := range dirs {
+		if !filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("allowed directory %s is not absolute", dir)
+		}
+		list.dirs = append(list.dirs, filepath.Clean(dir))
+	}
+	return list, nil
+}
+
+// Enabled reports whether the list restricts paths at all.
+func (l *AllowList) Enabled() bool {
+	return l != nil && len(l.dirs) > 0
+}
+
+// Check returns an error wrapping ErrNotAllowed unless path lies inside an allowed directory.
+// Empty paths are accepted, since they mean the field is unset.
+func (l *AllowList) Check(path string) error {
+	if !l.Enabled() || path == "" {
+		return nil
+	}
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("%w: %s is not absolute", ErrNotAllowed, path)
+	}
+
+	cleaned := filepath.Clean(path)
+	for _, dir := range l.dirs {
+		if cleaned == dir {
+			continue
+		}
+		if dir == "/" || strings.HasPrefix(cleaned, dir+"/") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is outside %s", ErrNotAllowed, path, strings.Join(l.dirs, ", "))
+}
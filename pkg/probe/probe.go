@@ -0,0 +1,79 @@
+// Package probe implements network readiness checks against guests.
+package probe
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TCP succeeds once a connection to address is accepted.
+func TCP(ctx context.Context, address string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// SSHBanner succeeds once the server at address sends an SSH identification string,
+// which sshd only does after it finished starting. It returns the banner.
+func SSHBanner(ctx context.Context, address string) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return "", err
+		}
+	}
+
+	// RFC 4253 allows other lines before the identification string.
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if strings.HasPrefix(line, "SSH-") {
+			return strings.TrimRight(line, "\r\n"), nil
+		}
+		if err != nil {
+			if err == io.EOF {
+				return "", fmt.Errorf("connection closed before SSH banner")
+			}
+			return "", err
+		}
+	}
+}
+
+// HTTP succeeds once a GET of url returns a 2xx or 3xx status. Redirects are not followed.
+func HTTP(ctx context.Context, url string) (int, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+
+	if response.StatusCode < 200 || response.StatusCode >= 400 {
+		return response.StatusCode, fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return response.StatusCode, nil
+}
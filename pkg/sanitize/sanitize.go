@@ -0,0 +1,86 @@
+// Package sanitize validates names and host paths before they are interpolated into
+// domain XML, cloud-init documents, shell commands and file paths.
+package sanitize
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// MaxNameLength is the maximum length of a DNS label, and so of a VM name or hostname.
+	MaxNameLength = 63
+	// MaxHostnameLength is the maximum length of a fully qualified domain name.
+	MaxHostnameLength = 253
+	// MaxPathLength is the maximum length of a host path, PATH_MAX on Linux.
+	MaxPathLength = 4096
+)
+
+var (
+	// ErrInvalidName is returned for names that are not DNS labels.
+	ErrInvalidName = errors.New("invalid name")
+	// ErrInvalidPath is returned for paths that cannot be passed safely to the hypervisor.
+	ErrInvalidPath = errors.New("invalid path")
+)
+
+// namePattern matches an RFC 1123 DNS label in lowercase.
+var namePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// pathPattern restricts paths to characters that need no quoting in XML or a shell.
+var pathPattern = regexp.MustCompile(`^[A-Za-z0-9._/+@=,-]+$`)
+
+// ValidateName checks that name is a lowercase DNS label: letters, digits and '-',
+// starting and ending with a letter or digit, at most MaxNameLength characters.
+func ValidateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: name is empty", ErrInvalidName)
+	}
+	if len(name) > MaxNameLength {
+		return fmt.Errorf("%w: %q is longer than %d characters", ErrInvalidName, name, MaxNameLength)
+	}
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("%w: %q must consist of lowercase letters, digits and '-', and start and end with a letter or digit", ErrInvalidName, name)
+	}
+	return nil
+}
+
+// CleanPath checks that path is an absolute path made of safe characters and returns it
+// normalized, with "." and ".." elements and duplicate or trailing slashes removed.
+// An empty path is returned as is, since it means the field is unset.
+func CleanPath(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	if len(path) > MaxPathLength {
+		return "", fmt.Errorf("%w: path is longer than %d characters", ErrInvalidPath, MaxPathLength)
+	}
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("%w: %q is not absolute", ErrInvalidPath, path)
+	}
+	if !pathPattern.MatchString(path) {
+		return "", fmt.Errorf("%w: %q may only contain letters, digits and . _ / + @ = , -", ErrInvalidPath, path)
+	}
+
+	cleaned := filepath.Clean(path)
+	if cleaned == "/" {
+		return "", fmt.Errorf("%w: %q names the root directory", ErrInvalidPath, path)
+	}
+	return cleaned, nil
+}
+
+// ValidateHostname checks that hostname is a DNS name: one or more names accepted by ValidateName,
+// separated by dots, at most MaxHostnameLength characters.
+func ValidateHostname(hostname string) error {
+	if len(hostname) > MaxHostnameLength {
+		return fmt.Errorf("%w: hostname is longer than %d characters", ErrInvalidName, MaxHostnameLength)
+	}
+	for _, label := range strings.Split(hostname, ".") {
+		if err := ValidateName(label); err != nil {
+			return fmt.Errorf("hostname %q: %w", hostname, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package secrets
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Mask replaces redacted secret values.
+const Mask = "[REDACTED]"
+
+// RedactingHandler is a slog.Handler that masks tracked secret values in messages and string attributes.
+type RedactingHandler struct {
+	next     slog.Handler
+	resolver *Resolver
+}
+
+// NewRedactingHandler wraps next so records are redacted with resolver before being handled.
+func NewRedactingHandler(next slog.Handler, resolver *Resolver) *RedactingHandler {
+	return &RedactingHandler{next: next, resolver: resolver}
+}
+
+func (h *RedactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *RedactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, h.resolver.Redact(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(h.redactAttr(attr))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *RedactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = h.redactAttr(attr)
+	}
+	return &RedactingHandler{next: h.next.WithAttrs(redacted), resolver: h.resolver}
+}
+
+func (h *RedactingHandler) WithGroup(name string) slog.Handler {
+	return &RedactingHandler{next: h.next.WithGroup(name), resolver: h.resolver}
+}
+
+// redactAttr masks secret values in string, error and group attributes.
+func (h *RedactingHandler) redactAttr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, h.resolver.Redact(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		redacted := make([]any, len(group))
+		for i, member := range group {
+			redacted[i] = h.redactAttr(member)
+		}
+		return slog.Group(attr.Key, redacted...)
+	case slog.KindAny:
+		if err, ok := value.Any().(error); ok {
+			return slog.String(attr.Key, h.resolver.Redact(err.Error()))
+		}
+	}
+	return attr
+}
@@ -0,0 +1,175 @@
+package secrets
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// RefPrefix marks a field value as a reference to a secret instead of the secret itself.
+const RefPrefix = "secret://"
+
+// Provider looks up secret values by name.
+type Provider interface {
+	Lookup(ctx context.Context, name string) (string, error)
+	Name() string
+}
+
+// IsRef reports whether value is a secret reference.
+func IsRef(value string) bool {
+	return strings.HasPrefix(value, RefPrefix)
+}
+
+const (
+	// minMaskedLength is the length below which values are not masked: masking every
+	// occurrence of a short value would garble unrelated text rather than hide a secret.
+	minMaskedLength = 6
+	// maxTracked bounds the values registered with Track; the least recently tracked go first.
+	maxTracked = 1024
+)
+
+// Resolver resolves secret references through a provider and remembers resolved values for redaction.
+// It remembers the last value of every resolved reference, and a bounded number of tracked values,
+// among them the earlier values of rotated secrets.
+type Resolver struct {
+	provider Provider
+
+	mu       sync.RWMutex
+	resolved map[string]string // by secret name
+	tracked  map[string]*list.Element
+	recency  *list.List // tracked values, least recently tracked first
+}
+
+// NewResolver creates a resolver backed by provider.
+func NewResolver(provider Provider) *Resolver {
+	return &Resolver{
+		provider: provider,
+		resolved: make(map[string]string),
+		tracked:  make(map[string]*list.Element),
+		recency:  list.New(),
+	}
+}
+
+// Resolve returns the secret referenced by value, or value unchanged when it is not a reference.
+func (r *Resolver) Resolve(ctx context.Context, value string) (string, error) {
+	if !IsRef(value) {
+		return value, nil
+	}
+
+	name := strings.TrimPrefix(value, RefPrefix)
+	if name == "" {
+		return "", fmt.Errorf("empty secret reference")
+	}
+
+	secret, err := r.provider.Lookup(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve secret %s from %s: %w", name, r.provider.Name(), err)
+	}
+	if secret == "" {
+		return "", fmt.Errorf("secret %s from %s is empty", name, r.provider.Name())
+	}
+
+	if len(secret) >= minMaskedLength {
+		r.mu.Lock()
+		// The earlier value of a rotated secret may still show up in output, such as that of
+		// a command started before the rotation.
+		if previous, ok := r.resolved[name]; ok && previous != secret {
+			r.track(previous)
+		}
+		r.resolved[name] = secret
+		r.mu.Unlock()
+	}
+	return secret, nil
+}
+
+// Track registers a sensitive value so Redact masks it, even if it was not resolved from a reference.
+// Values shorter than minMaskedLength are not masked.
+func (r *Resolver) Track(value string) {
+	if len(value) < minMaskedLength {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.track(value)
+}
+
+// track registers value with Track. The caller must hold mu.
+func (r *Resolver) track(value string) {
+	if element, ok := r.tracked[value]; ok {
+		r.recency.MoveToBack(element)
+		return
+	}
+	r.tracked[value] = r.recency.PushBack(value)
+	if r.recency.Len() > maxTracked {
+		oldest := r.recency.Front()
+		r.recency.Remove(oldest)
+		delete(r.tracked, oldest.Value.(string))
+	}
+}
+
+// Redact replaces every remembered secret value in s with a mask. Longer values are replaced
+// first, so a value containing another is masked whole rather than leaving its remainder.
+func (r *Resolver) Redact(s string) string {
+	r.mu.RLock()
+	values := make([]string, 0, len(r.resolved)+len(r.tracked))
+	for _, value := range r.resolved {
+		values = append(values, value)
+	}
+	for value := range r.tracked {
+		values = append(values, value)
+	}
+	r.mu.RUnlock()
+
+	slices.SortFunc(values, func(a, b string) int {
+		return len(b) - len(a)
+	})
+	for _, value := range values {
+		s = strings.ReplaceAll(s, value, Mask)
+	}
+	return s
+}
+
+// EnvProvider reads secrets from environment variables named Prefix + NAME,
+// with the name upper-cased and '-', '.' and '/' replaced by '_'.
+type EnvProvider struct {
+	Prefix string
+}
+
+func (p EnvProvider) Name() string {
+	return "env"
+}
+
+func (p EnvProvider) Lookup(_ context.Context, name string) (string, error) {
+	variable := p.Prefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_", "/", "_").Replace(name))
+	value, ok := os.LookupEnv(variable)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", variable)
+	}
+	return value, nil
+}
+
+// FileProvider reads secrets from files in Dir, one secret per file. A trailing newline is stripped.
+type FileProvider struct {
+	Dir string
+}
+
+func (p FileProvider) Name() string {
+	return "file"
+}
+
+func (p FileProvider) Lookup(_ context.Context, name string) (string, error) {
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+	data, err := os.ReadFile(filepath.Join(p.Dir, name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r"), nil
+}
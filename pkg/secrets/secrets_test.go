@@ -0,0 +1,90 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+type mapProvider map[string]string
+
+func (p mapProvider) Name() string {
+	return "map"
+}
+
+func (p mapProvider) Lookup(_ context.Context, name string) (string, error) {
+	value, ok := p[name]
+	if !ok {
+		return "", fmt.Errorf("secret %s not found", name)
+	}
+	return value, nil
+}
+
+func TestRedact(t *testing.T) {
+	resolver := NewResolver(mapProvider{"db": "s3cr3t-db", "pin": "1234"})
+	for _, ref := range []string{"secret://db", "secret://pin"} {
+		if _, err := resolver.Resolve(context.Background(), ref); err != nil {
+			t.Fatalf("Resolve(%q): %v", ref, err)
+		}
+	}
+	resolver.Track("tracked-password")
+	resolver.Track("abc")
+	// Contains the resolved s3cr3t-db, and must not be masked as Mask + "-suffix".
+	resolver.Track("s3cr3t-db-suffix")
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"password s3cr3t-db", "password " + Mask},
+		{"tracked-password and s3cr3t-db", Mask + " and " + Mask},
+		{"token s3cr3t-db-suffix", "token " + Mask},
+		{"pin 1234 stays", "pin 1234 stays"},
+		{"abcdef stays", "abcdef stays"},
+		{"nothing secret", "nothing secret"},
+	}
+	for _, tt := range tests {
+		if got := resolver.Redact(tt.input); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestResolveKeepsRotatedSecret(t *testing.T) {
+	provider := mapProvider{"token": "first-token"}
+	resolver := NewResolver(provider)
+	if _, err := resolver.Resolve(context.Background(), "secret://token"); err != nil {
+		t.Fatal(err)
+	}
+	provider["token"] = "second-token"
+	if _, err := resolver.Resolve(context.Background(), "secret://token"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := resolver.Redact("first-token second-token"), Mask+" "+Mask; got != want {
+		t.Errorf("Redact = %q, want %q", got, want)
+	}
+}
+
+func TestTrackIsBounded(t *testing.T) {
+	resolver := NewResolver(mapProvider{})
+	resolver.Track("value-0000")
+	for i := 1; i <= maxTracked; i++ {
+		resolver.Track(fmt.Sprintf("value-%04d", i))
+		if i == maxTracked/2 {
+			// Tracking a value again keeps it from being dropped first.
+			resolver.Track("value-0000")
+		}
+	}
+	resolver.Track("value-extra")
+
+	if len(resolver.tracked) != maxTracked || resolver.recency.Len() != maxTracked {
+		t.Fatalf("tracking %d values, want %d", len(resolver.tracked), maxTracked)
+	}
+	if got := resolver.Redact("value-0000"); got != Mask {
+		t.Errorf("recently tracked value-0000 was dropped: %q", got)
+	}
+	if got := resolver.Redact("value-0001"); got != "value-0001" {
+		t.Errorf("least recently tracked value-0001 is still masked: %q", got)
+	}
+}
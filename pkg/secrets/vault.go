@@ -0,0 +1,76 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultVaultField is the key read from a Vault secret when the reference names none.
+const defaultVaultField = "value"
+
+// VaultProvider reads secrets from a HashiCorp Vault KV version 2 engine.
+// Names have the form "path/to/secret#field"; the field defaults to "value".
+type VaultProvider struct {
+	Address string
+	Token   string
+	Mount   string
+	client  *http.Client
+}
+
+// NewVaultProvider creates a Vault provider for the KV v2 engine mounted at mount.
+func NewVaultProvider(address, token, mount string) *VaultProvider {
+	return &VaultProvider{
+		Address: strings.TrimSuffix(address, "/"),
+		Token:   token,
+		Mount:   strings.Trim(mount, "/"),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *VaultProvider) Name() string {
+	return "vault"
+}
+
+func (p *VaultProvider) Lookup(ctx context.Context, name string) (string, error) {
+	path, field, found := strings.Cut(name, "#")
+	if !found || field == "" {
+		field = defaultVaultField
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/%s/data/%s", p.Address, p.Mount, (&url.URL{Path: strings.Trim(path, "/")}).EscapedPath())
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build vault request: %w", err)
+	}
+	request.Header.Set("X-Vault-Token", p.Token)
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("vault request failed: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned %s for %s", response.Status, path)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode vault response: %w", err)
+	}
+
+	value, ok := body.Data.Data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no string field %s", path, field)
+	}
+	return value, nil
+}
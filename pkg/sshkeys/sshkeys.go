@@ -0,0 +1,47 @@
+package sshkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// KeyPair is a generated SSH keypair.
+type KeyPair struct {
+	PublicKey   string // authorized_keys format, including the comment
+	Fingerprint string // SHA256 fingerprint of the public key
+	PrivateKey  []byte // OpenSSH PEM
+}
+
+// Generate creates an ed25519 keypair. The comment is appended to the public key.
+func Generate(comment string) (KeyPair, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("failed to generate ed25519 key: %w", err)
+	}
+
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("failed to encode public key: %w", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(privateKey, comment)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("failed to encode private key: %w", err)
+	}
+
+	authorizedKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublicKey)))
+	if comment != "" {
+		authorizedKey += " " + comment
+	}
+
+	return KeyPair{
+		PublicKey:   authorizedKey,
+		Fingerprint: ssh.FingerprintSHA256(sshPublicKey),
+		PrivateKey:  pem.EncodeToMemory(block),
+	}, nil
+}
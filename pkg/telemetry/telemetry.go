@@ -3,8 +3,12 @@ package telemetry
 import (
 	"context"
 	"fmt"
+	"net/http"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/otel"
+	otelprometheus "go.opentelemetry.io/otel/exporters/prometheus"
 	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
 	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
 	"go.opentelemetry.io/otel/sdk/metric"
@@ -14,6 +18,7 @@ import (
 type Telemetry struct {
 	tracerProvider *trace.TracerProvider
 	meterProvider  *metric.MeterProvider
+	metricsHandler http.Handler
 }
 
 func Initialize(serviceName string) (*Telemetry, error) {
@@ -37,17 +42,36 @@ func Initialize(serviceName string) (*Telemetry, error) {
 		return nil, fmt.Errorf("failed to create metric exporter: %w", err)
 	}
 
+	// The Prometheus exporter backs the scrape endpoint alongside the stdout exporter. Its own
+	// registry keeps the endpoint to the metrics of the meter provider.
+	registry := prometheus.NewRegistry()
+	prometheusExporter, err := otelprometheus.New(otelprometheus.WithRegisterer(registry))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create prometheus exporter: %w", err)
+	}
+
 	meterProvider := metric.NewMeterProvider(
 		metric.WithReader(metric.NewPeriodicReader(metricExporter)),
+		metric.WithReader(prometheusExporter),
 	)
 	otel.SetMeterProvider(meterProvider)
 
 	return &Telemetry{
 		tracerProvider: tracerProvider,
 		meterProvider:  meterProvider,
+		metricsHandler: promhttp.HandlerFor(registry, promhttp.HandlerOpts{}),
 	}, nil
 }
 
+// MetricsHandler serves the collected metrics in the Prometheus text format.
+// It returns nil if telemetry is disabled.
+func (t *Telemetry) MetricsHandler() http.Handler {
+	if t == nil {
+		return nil
+	}
+	return t.metricsHandler
+}
+
 func (t *Telemetry) Shutdown(ctx context.Context) error {
 	if err := t.tracerProvider.Shutdown(ctx); err != nil {
 		return fmt.Errorf("failed to shutdown tracer provider: %w", err)
@@ -0,0 +1,116 @@
+package templator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around every change.
+const diffContext = 3
+
+// diffLine is one line of a line diff: ' ' unchanged, '-' removed or '+' added.
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// unifiedDiff returns a unified diff of two renderings of a template.
+func unifiedDiff(name, before, after string) string {
+	lines := diffLines(strings.Split(before, "\n"), strings.Split(after, "\n"))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- active/%s\n+++ candidate/%s\n", name, name)
+	for start := 0; start < len(lines); {
+		// Find the next change and the hunk of changes close enough to share context.
+		first := start
+		for first < len(lines) && lines[first].op == ' ' {
+			first++
+		}
+		if first == len(lines) {
+			break
+		}
+		last := first
+		for i := first; i < len(lines) && i-last <= 2*diffContext; i++ {
+			if lines[i].op != ' ' {
+				last = i
+			}
+		}
+
+		from := max(first-diffContext, start)
+		to := min(last+diffContext+1, len(lines))
+		oldStart, newStart := lineNumbers(lines, from)
+		var oldCount, newCount int
+		for _, line := range lines[from:to] {
+			if line.op != '+' {
+				oldCount++
+			}
+			if line.op != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		for _, line := range lines[from:to] {
+			out.WriteByte(line.op)
+			out.WriteString(line.text)
+			out.WriteByte('\n')
+		}
+		start = to
+	}
+	return out.String()
+}
+
+// lineNumbers returns the 1-based line numbers in both inputs at which lines[index] starts.
+func lineNumbers(lines []diffLine, index int) (int, int) {
+	oldLine, newLine := 1, 1
+	for _, line := range lines[:index] {
+		if line.op != '+' {
+			oldLine++
+		}
+		if line.op != '-' {
+			newLine++
+		}
+	}
+	return oldLine, newLine
+}
+
+// diffLines computes a line diff from the longest common subsequence of a and b.
+func diffLines(a, b []string) []diffLine {
+	// common[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case common[i+1][j] >= common[i][j+1]:
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, diffLine{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, diffLine{'+', b[j]})
+	}
+	return lines
+}
@@ -2,57 +2,222 @@ package templator
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
+	"sync"
 	"text/template"
+	"time"
 )
 
-type Engine struct {
+// Template versions. The engine renders with the active version; the other one, when staged,
+// is the candidate VM creations are canary-rendered with.
+const (
+	VersionBlue  = "blue"
+	VersionGreen = "green"
+)
+
+// ErrNoCandidate is returned when switching versions while no candidate version is staged.
+var ErrNoCandidate = errors.New("no candidate template version is staged")
+
+// maxDiffs caps how many canary renderings the engine keeps.
+const maxDiffs = 200
+
+// templateVersion is one set of parsed templates with the sources they were parsed from.
+type templateVersion struct {
 	templates map[string]*template.Template
+	digests   map[string]string
+	loadedAt  time.Time
+}
+
+type Engine struct {
+	mu       sync.RWMutex
+	paths    map[string]string
+	versions map[string]*templateVersion
+	active   string
+	diffs    []Diff
+}
+
+// VersionStatus describes a loaded template version.
+type VersionStatus struct {
+	Name     string
+	Active   bool
+	LoadedAt time.Time
+	Digests  map[string]string // sha256 of every template source, by template name
+}
+
+// Diff is the outcome of canary-rendering a template with the candidate version.
+type Diff struct {
+	Subject        string
+	Template       string
+	Time           time.Time
+	Identical      bool
+	Diff           string // unified diff from the active to the candidate rendering
+	CandidateError string
 }
 
 func NewEngine() *Engine {
 	return &Engine{
+		paths:    make(map[string]string),
+		versions: map[string]*templateVersion{VersionBlue: newTemplateVersion()},
+		active:   VersionBlue,
+	}
+}
+
+func newTemplateVersion() *templateVersion {
+	return &templateVersion{
 		templates: make(map[string]*template.Template),
+		digests:   make(map[string]string),
+		loadedAt:  time.Now().UTC(),
 	}
 }
 
+// LoadTemplate loads a template into the active version and remembers its path for staging.
 func (e *Engine) LoadTemplate(name, path string) error {
-	tmpl, err := template.ParseFiles(path)
+	tmpl, digest, err := parseTemplate(path)
 	if err != nil {
 		return fmt.Errorf("failed to load template %s from %s: %w", name, path, err)
 	}
-	e.templates[name] = tmpl
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.paths[name] = path
+	e.versions[e.active].templates[name] = tmpl
+	e.versions[e.active].digests[name] = digest
 	return nil
 }
 
+func parseTemplate(path string) (*template.Template, string, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	tmpl, err := template.New(filepath.Base(path)).Parse(string(source))
+	if err != nil {
+		return nil, "", err
+	}
+	digest := sha256.Sum256(source)
+	return tmpl, hex.EncodeToString(digest[:]), nil
+}
+
+// Stage reads every loaded template from its path again into the inactive version, which
+// becomes the candidate. Nothing is staged if any template fails to parse.
+func (e *Engine) Stage() (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	candidate := newTemplateVersion()
+	for name, path := range e.paths {
+		tmpl, digest, err := parseTemplate(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to stage template %s from %s: %w", name, path, err)
+		}
+		candidate.templates[name] = tmpl
+		candidate.digests[name] = digest
+	}
+
+	name := e.inactive()
+	e.versions[name] = candidate
+	e.diffs = nil
+	return name, nil
+}
+
+// Switch makes the candidate version active. The previously active version stays loaded as
+// the candidate, so switching again rolls back.
+func (e *Engine) Switch() (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	name := e.inactive()
+	if _, ok := e.versions[name]; !ok {
+		return "", ErrNoCandidate
+	}
+	e.active = name
+	e.diffs = nil
+	return name, nil
+}
+
+// Discard unloads the candidate version, which ends canary rendering.
+func (e *Engine) Discard() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.versions, e.inactive())
+	e.diffs = nil
+}
+
+// Versions describes the loaded template versions, the active one first.
+func (e *Engine) Versions() []VersionStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var statuses []VersionStatus
+	for _, name := range []string{e.active, e.inactive()} {
+		version, ok := e.versions[name]
+		if !ok {
+			continue
+		}
+		statuses = append(statuses, VersionStatus{
+			Name:     name,
+			Active:   name == e.active,
+			LoadedAt: version.loadedAt,
+			Digests:  maps.Clone(version.digests),
+		})
+	}
+	return statuses
+}
+
+// Diffs returns the canary renderings since the candidate version was staged, oldest first.
+func (e *Engine) Diffs() []Diff {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return slices.Clone(e.diffs)
+}
+
+func (e *Engine) inactive() string {
+	if e.active == VersionBlue {
+		return VersionGreen
+	}
+	return VersionBlue
+}
+
 func (e *Engine) HasTemplate(name string) bool {
-	_, exists := e.templates[name]
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	_, exists := e.versions[e.active].templates[name]
 	return exists
 }
 
-func (e *Engine) RenderToFile(name, outputPath string, data any) error {
-	tmpl, exists := e.templates[name]
-	if !exists {
-		return fmt.Errorf("template %s not found", name)
+// lookup returns the template called name of the active version, and of the candidate if one is staged.
+func (e *Engine) lookup(name string) (*template.Template, *template.Template) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	var candidate *template.Template
+	if version, ok := e.versions[e.inactive()]; ok {
+		candidate = version.templates[name]
 	}
+	return e.versions[e.active].templates[name], candidate
+}
 
-	file, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+func (e *Engine) RenderToFile(name, outputPath string, data any) error {
+	rendered, err := e.RenderToBytes(name, data)
 	if err != nil {
-		return fmt.Errorf("failed to create output file %s: %w", outputPath, err)
+		return err
 	}
-	defer file.Close()
 
-	if err := tmpl.Execute(file, data); err != nil {
-		return fmt.Errorf("failed to render template %s: %w", name, err)
+	if err := os.WriteFile(outputPath, rendered, 0o644); err != nil {
+		return fmt.Errorf("failed to write output file %s: %w", outputPath, err)
 	}
-
 	return nil
 }
 
 func (e *Engine) RenderToBytes(name string, data any) ([]byte, error) {
-	tmpl, exists := e.templates[name]
-	if !exists {
+	tmpl, _ := e.lookup(name)
+	if tmpl == nil {
 		return nil, fmt.Errorf("template %s not found", name)
 	}
 
@@ -63,3 +228,36 @@ func (e *Engine) RenderToBytes(name string, data any) ([]byte, error) {
 
 	return buf.Bytes(), nil
 }
+
+// Canary renders a template for subject with both the active and the candidate version and
+// records how the candidate rendering differs. It does nothing unless a candidate is staged.
+// A later rendering of the same template for the same subject replaces the earlier one.
+func (e *Engine) Canary(subject, name string, data any) {
+	active, candidate := e.lookup(name)
+	if active == nil || candidate == nil {
+		return
+	}
+
+	var activeOutput, candidateOutput bytes.Buffer
+	if err := active.Execute(&activeOutput, data); err != nil {
+		return
+	}
+	diff := Diff{Subject: subject, Template: name, Time: time.Now().UTC()}
+	if err := candidate.Execute(&candidateOutput, data); err != nil {
+		diff.CandidateError = err.Error()
+	} else if bytes.Equal(activeOutput.Bytes(), candidateOutput.Bytes()) {
+		diff.Identical = true
+	} else {
+		diff.Diff = unifiedDiff(name, activeOutput.String(), candidateOutput.String())
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.diffs = slices.DeleteFunc(e.diffs, func(d Diff) bool {
+		return d.Subject == subject && d.Template == name
+	})
+	e.diffs = append(e.diffs, diff)
+	if len(e.diffs) > maxDiffs {
+		e.diffs = e.diffs[len(e.diffs)-maxDiffs:]
+	}
+}
@@ -0,0 +1,141 @@
+// Package units parses human-friendly sizes such as "16Gi" or "100G" and converts them to the
+// fixed units sizes are stored in.
+package units
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+)
+
+// Binary units. Memory is kept in MiB and disks in GiB.
+const (
+	KiB int64 = 1 << 10
+	MiB int64 = 1 << 20
+	GiB int64 = 1 << 30
+	TiB int64 = 1 << 40
+)
+
+// sizePattern splits a size into its number and unit, e.g. "1.5" and "Gi".
+var sizePattern = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?) ?([A-Za-z]+)$`)
+
+// multipliers are the accepted units: decimal ones (k, M, G, T) are powers of 1000 and binary
+// ones (Ki, Mi, Gi, Ti) powers of 1024, as in Kubernetes quantities. A trailing B is allowed.
+var multipliers = map[string]int64{
+	"B":   1,
+	"k":   1e3,
+	"K":   1e3,
+	"kB":  1e3,
+	"KB":  1e3,
+	"M":   1e6,
+	"MB":  1e6,
+	"G":   1e9,
+	"GB":  1e9,
+	"T":   1e12,
+	"TB":  1e12,
+	"Ki":  KiB,
+	"KiB": KiB,
+	"Mi":  MiB,
+	"MiB": MiB,
+	"Gi":  GiB,
+	"GiB": GiB,
+	"Ti":  TiB,
+	"TiB": TiB,
+}
+
+// ParseSize parses a size with a mandatory unit into bytes, e.g. "16Gi", "100G" or "1.5 GiB".
+// A bare number is rejected, since whether it meant bytes, MB or MiB is exactly what goes wrong.
+// Fractional bytes are rounded up.
+func ParseSize(value string) (int64, error) {
+	match := sizePattern.FindStringSubmatch(value)
+	if match == nil {
+		return 0, fmt.Errorf("invalid size %q: expected a number and a unit, e.g. 16Gi or 100G", value)
+	}
+	multiplier, ok := multipliers[match[2]]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %s (valid: B, k, M, G, T, Ki, Mi, Gi, Ti, optionally followed by B)", value, match[2])
+	}
+
+	number, ok := new(big.Rat).SetString(match[1])
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	bytes := number.Mul(number, new(big.Rat).SetInt64(multiplier))
+	rounded := new(big.Int).Quo(bytes.Num(), bytes.Denom())
+	if !bytes.IsInt() {
+		rounded.Add(rounded, big.NewInt(1))
+	}
+	if !rounded.IsInt64() {
+		return 0, fmt.Errorf("invalid size %q: too large", value)
+	}
+	if rounded.Sign() == 0 {
+		return 0, fmt.Errorf("invalid size %q: must be positive", value)
+	}
+	return rounded.Int64(), nil
+}
+
+// ParseSizeIn parses a size like ParseSize and converts it to whole multiples of unit,
+// rounding up, e.g. ParseSizeIn("100G", GiB) is 94.
+func ParseSizeIn(value string, unit int64) (int64, error) {
+	bytes, err := ParseSize(value)
+	if err != nil {
+		return 0, err
+	}
+	whole := bytes / unit
+	if bytes%unit != 0 {
+		whole++
+	}
+	return whole, nil
+}
+
+// integer is the integer types memory sizes are kept in.
+type integer interface {
+	~int | ~int64 | ~uint | ~uint64
+}
+
+// MiBToKiB converts a memory size in MiB, as VM sizes are stored, into KiB, as libvirt takes it.
+func MiBToKiB[T integer](mib T) T {
+	return mib << 10
+}
+
+// KiBToMiB converts a memory size in KiB into whole MiB, rounding down.
+func KiBToMiB[T integer](kib T) T {
+	return kib >> 10
+}
+
+// KiBToBytes converts a memory size in KiB into bytes.
+func KiBToBytes[T integer](kib T) T {
+	return kib << 10
+}
+
+// BytesToKiB converts a memory size in bytes, as libvirt reports host memory, into whole KiB,
+// rounding down.
+func BytesToKiB[T integer](bytes T) T {
+	return bytes >> 10
+}
+
+// LibvirtToKiB converts a libvirt memory value with its unit attribute into KiB, rounding down.
+// Libvirt takes k, M, G and T and their KiB, MiB, GiB and TiB spellings as powers of 1024, KB,
+// MB, GB and TB as powers of 1000, and b or bytes; an empty unit is KiB.
+func LibvirtToKiB(value uint64, unit string) uint64 {
+	switch unit {
+	case "b", "bytes":
+		return value >> 10
+	case "KB":
+		return value * 1000 >> 10
+	case "MB":
+		return value * 1000 * 1000 >> 10
+	case "GB":
+		return value * 1000 * 1000 * 1000 >> 10
+	case "TB":
+		return value * 1000 * 1000 * 1000 * 1000 >> 10
+	case "M", "MiB":
+		return value << 10
+	case "G", "GiB":
+		return value << 20
+	case "T", "TiB":
+		return value << 30
+	default: // k, KiB or empty
+		return value
+	}
+}
@@ -0,0 +1,183 @@
+package units
+
+import "testing"
+
+func TestMemoryConversions(t *testing.T) {
+	tests := []struct {
+		name string
+		got  uint64
+		want uint64
+	}{
+		{"MiBToKiB zero", MiBToKiB[uint64](0), 0},
+		{"MiBToKiB one", MiBToKiB[uint64](1), 1024},
+		{"MiBToKiB 4 GiB", MiBToKiB[uint64](4096), 4 * 1024 * 1024},
+		{"KiBToMiB exact", KiBToMiB[uint64](2048), 2},
+		{"KiBToMiB below one MiB", KiBToMiB[uint64](1023), 0},
+		{"KiBToMiB at one MiB", KiBToMiB[uint64](1024), 1},
+		{"KiBToMiB just below two MiB", KiBToMiB[uint64](2047), 1},
+		{"KiBToBytes", KiBToBytes[uint64](3), 3072},
+		{"BytesToKiB exact", BytesToKiB[uint64](4096), 4},
+		{"BytesToKiB below one KiB", BytesToKiB[uint64](1023), 0},
+		{"BytesToKiB rounds down", BytesToKiB[uint64](2047), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %d, want %d", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryConversionsRoundTrip(t *testing.T) {
+	for _, mib := range []int64{0, 1, 512, 1536, 65536} {
+		if got := KiBToMiB(MiBToKiB(mib)); got != mib {
+			t.Errorf("KiBToMiB(MiBToKiB(%d)) = %d", mib, got)
+		}
+		if got := BytesToKiB(KiBToBytes(MiBToKiB(mib))); got != MiBToKiB(mib) {
+			t.Errorf("BytesToKiB(KiBToBytes(%d KiB)) = %d", MiBToKiB(mib), got)
+		}
+	}
+}
+
+func TestLibvirtToKiB(t *testing.T) {
+	tests := []struct {
+		value uint64
+		unit  string
+		want  uint64
+	}{
+		{2048, "", 2048},
+		{2048, "k", 2048},
+		{2048, "KiB", 2048},
+		{1024, "b", 1},
+		{1024, "bytes", 1},
+		{1023, "b", 0},
+		{1048575, "b", 1023},
+		{1048576, "b", 1024},
+		{1048577, "b", 1024},
+		{1, "KB", 0},
+		{1024, "KB", 1000},
+		{1, "MB", 976},
+		{1, "GB", 976562},
+		{1, "TB", 976562500},
+		{1, "M", 1024},
+		{512, "MiB", 512 * 1024},
+		{1, "G", 1024 * 1024},
+		{2, "GiB", 2 * 1024 * 1024},
+		{1, "T", 1024 * 1024 * 1024},
+		{1, "TiB", 1024 * 1024 * 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.unit, func(t *testing.T) {
+			if got := LibvirtToKiB(tt.value, tt.unit); got != tt.want {
+				t.Errorf("LibvirtToKiB(%d, %q) = %d, want %d", tt.value, tt.unit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLibvirtToMiBBoundary(t *testing.T) {
+	// Memory just short of a whole MiB must not be reported as that MiB.
+	tests := []struct {
+		value uint64
+		unit  string
+		want  uint64
+	}{
+		{1023, "KiB", 0},
+		{1024, "KiB", 1},
+		{2047, "KiB", 1},
+		{2048, "KiB", 2},
+		{1048575, "b", 0},
+		{1048576, "b", 1},
+		{2097151, "bytes", 1},
+		{1, "MB", 0},
+		{2, "MB", 1},
+		{1, "MiB", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.unit, func(t *testing.T) {
+			if got := KiBToMiB(LibvirtToKiB(tt.value, tt.unit)); got != tt.want {
+				t.Errorf("%d %s is %d MiB, want %d", tt.value, tt.unit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "1B", want: 1},
+		{value: "1k", want: 1000},
+		{value: "1K", want: 1000},
+		{value: "1kB", want: 1000},
+		{value: "100G", want: 100e9},
+		{value: "100GB", want: 100e9},
+		{value: "2T", want: 2e12},
+		{value: "1Ki", want: 1024},
+		{value: "16Gi", want: 16 * GiB},
+		{value: "16GiB", want: 16 * GiB},
+		{value: "1.5 GiB", want: 3 * GiB / 2},
+		{value: "512Mi", want: 512 * MiB},
+		{value: "1Ti", want: TiB},
+		{value: "0.5B", want: 1},
+		{value: "1.0001k", want: 1001},
+		{value: "", wantErr: true},
+		{value: "16", wantErr: true},
+		{value: "Gi", wantErr: true},
+		{value: "-1Gi", wantErr: true},
+		{value: "1 Gi ", wantErr: true},
+		{value: "1Gb", wantErr: true},
+		{value: "1Pi", wantErr: true},
+		{value: "0Gi", wantErr: true},
+		{value: "8388608Ti", wantErr: true},
+		{value: "9999999999T", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseSize(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseSize(%q) = %d, want an error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSize(%q): %v", tt.value, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize(%q) = %d, want %d", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSizeIn(t *testing.T) {
+	tests := []struct {
+		value string
+		unit  int64
+		want  int64
+	}{
+		{"16Gi", GiB, 16},
+		{"100G", GiB, 94},
+		{"1Gi", MiB, 1024},
+		{"1G", MiB, 954},
+		{"1Mi", MiB, 1},
+		{"1M", MiB, 1},
+		{"1025Ki", MiB, 2},
+		{"1B", GiB, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseSizeIn(tt.value, tt.unit)
+			if err != nil {
+				t.Fatalf("ParseSizeIn(%q): %v", tt.value, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSizeIn(%q, %d) = %d, want %d", tt.value, tt.unit, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+// Package variables substitutes ${name} references in request payloads.
+package variables
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+var namePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Validate checks that every variable name is a valid identifier.
+func Validate(vars map[string]string) error {
+	for name := range vars {
+		if !namePattern.MatchString(name) {
+			return fmt.Errorf("invalid variable name %q: must start with a letter or '_' and contain only letters, digits and '_'", name)
+		}
+	}
+	return nil
+}
+
+// Expand replaces every ${name} in s with the value of the variable. "$${" yields a literal "${".
+// Values are not expanded again, and referencing an undefined variable is an error.
+func Expand(s string, vars map[string]string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var result strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			result.WriteString(s)
+			return result.String(), nil
+		}
+		if start > 0 && s[start-1] == '$' {
+			result.WriteString(s[:start-1])
+			result.WriteString("${")
+			s = s[start+2:]
+			continue
+		}
+
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable reference in %q", s)
+		}
+		name := s[start+2 : start+end]
+		value, ok := vars[name]
+		if !ok {
+			return "", fmt.Errorf("undefined variable %q", name)
+		}
+		result.WriteString(s[:start])
+		result.WriteString(value)
+		s = s[start+end+1:]
+	}
+}
+
+// ExpandAll expands the variables in every string reachable from target, which must be a pointer:
+// struct fields, slice and array elements, map values and pointed-to values.
+// Errors name the offending field by its JSON name.
+func ExpandAll(target any, vars map[string]string) error {
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Pointer || value.IsNil() {
+		return fmt.Errorf("expand target must be a non-nil pointer, got %T", target)
+	}
+	return expandValue(value.Elem(), "", vars)
+}
+
+func expandValue(value reflect.Value, path string, vars map[string]string) error {
+	switch value.Kind() {
+	case reflect.String:
+		if !value.CanSet() {
+			return nil
+		}
+		expanded, err := Expand(value.String(), vars)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		value.SetString(expanded)
+	case reflect.Pointer, reflect.Interface:
+		if !value.IsNil() {
+			return expandValue(value.Elem(), path, vars)
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			if err := expandValue(value.Field(i), name, vars); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if err := expandValue(value.Index(i), fmt.Sprintf("%s[%d]", path, i), vars); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if value.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		iter := value.MapRange()
+		for iter.Next() {
+			expanded, err := Expand(iter.Value().String(), vars)
+			if err != nil {
+				return fmt.Errorf("%s[%v]: %w", path, iter.Key(), err)
+			}
+			value.SetMapIndex(iter.Key(), reflect.ValueOf(expanded).Convert(value.Type().Elem()))
+		}
+	}
+	return nil
+}
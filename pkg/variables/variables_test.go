@@ -0,0 +1,156 @@
+package variables
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"env", false},
+		{"_private", false},
+		{"Node_2", false},
+		{"2nodes", true},
+		{"with-dash", true},
+		{"with space", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(map[string]string{tt.name: "value"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate(%q) = %v, want error %t", tt.name, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExpand(t *testing.T) {
+	vars := map[string]string{"env": "prod", "count": "3", "ref": "${env}"}
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: "", want: ""},
+		{input: "plain", want: "plain"},
+		{input: "${env}", want: "prod"},
+		{input: "web-${env}-${count}", want: "web-prod-3"},
+		{input: "${env}${env}", want: "prodprod"},
+		{input: "$env", want: "$env"},
+		{input: "cost: $5 {x}", want: "cost: $5 {x}"},
+		{input: "$${env}", want: "${env}"},
+		{input: "$${env} is ${env}", want: "${env} is prod"},
+		{input: "${ref}", want: "${env}"},
+		{input: "${missing}", wantErr: true},
+		{input: "${env", wantErr: true},
+		{input: "${}", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := Expand(tt.input, vars)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expand(%q) = %q, want an error", tt.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expand(%q): %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("Expand(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+type label string
+
+type payload struct {
+	Name     string            `json:"name"`
+	Count    int               `json:"count"`
+	Tags     []string          `json:"tags"`
+	Labels   map[string]string `json:"labels"`
+	Typed    map[string]label  `json:"typed"`
+	Numbers  map[string]int    `json:"numbers"`
+	Nested   *nested           `json:"nested,omitempty"`
+	Any      any               `json:"any"`
+	Skipped  string            `json:"-"`
+	Untagged string
+	hidden   string
+}
+
+type nested struct {
+	Hosts [2]string `json:"hosts"`
+}
+
+func TestExpandAll(t *testing.T) {
+	vars := map[string]string{"env": "prod"}
+	target := payload{
+		Name:     "web-${env}",
+		Count:    1,
+		Tags:     []string{"${env}", "static"},
+		Labels:   map[string]string{"tier": "${env}"},
+		Typed:    map[string]label{"tier": "${env}"},
+		Numbers:  map[string]int{"a": 1},
+		Nested:   &nested{Hosts: [2]string{"${env}-1", "${env}-2"}},
+		Skipped:  "${env}",
+		Untagged: "${env}",
+		hidden:   "${env}",
+	}
+	anyValue := "${env}"
+	target.Any = &anyValue
+
+	if err := ExpandAll(&target, vars); err != nil {
+		t.Fatalf("ExpandAll: %v", err)
+	}
+
+	prod := "prod"
+	want := payload{
+		Name:     "web-prod",
+		Count:    1,
+		Tags:     []string{"prod", "static"},
+		Labels:   map[string]string{"tier": "prod"},
+		Typed:    map[string]label{"tier": "prod"},
+		Numbers:  map[string]int{"a": 1},
+		Nested:   &nested{Hosts: [2]string{"prod-1", "prod-2"}},
+		Any:      &prod,
+		Skipped:  "${env}",
+		Untagged: "prod",
+		hidden:   "${env}",
+	}
+	if !reflect.DeepEqual(target, want) {
+		t.Errorf("ExpandAll = %+v, want %+v", target, want)
+	}
+}
+
+func TestExpandAllErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		target any
+		want   string
+	}{
+		{"field", &payload{Name: "${missing}"}, `name: undefined variable "missing"`},
+		{"slice element", &payload{Tags: []string{"ok", "${missing}"}}, `tags[1]: undefined variable "missing"`},
+		{"map value", &payload{Labels: map[string]string{"tier": "${missing}"}}, `labels[tier]: undefined variable "missing"`},
+		{"nested field", &payload{Nested: &nested{Hosts: [2]string{"", "${missing}"}}}, `nested.hosts[1]: undefined variable "missing"`},
+		{"untagged field", &payload{Untagged: "${missing}"}, `Untagged: undefined variable "missing"`},
+		{"not a pointer", payload{}, "expand target must be a non-nil pointer, got variables.payload"},
+		{"nil pointer", (*payload)(nil), "expand target must be a non-nil pointer, got *variables.payload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ExpandAll(tt.target, map[string]string{})
+			if err == nil {
+				t.Fatal("ExpandAll succeeded, want an error")
+			}
+			if err.Error() != tt.want {
+				t.Errorf("ExpandAll error = %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package workspace
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// prefix starts the name of every workspace directory, so stale ones can be told apart
+// from other files in the root.
+const prefix = "homonculus-"
+
+// Root is the directory holding the workspaces of provisioning jobs.
+type Root struct {
+	dir string
+}
+
+// NewRoot creates the root directory if needed. An empty dir uses the system temp directory.
+func NewRoot(dir string) (*Root, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create workspace directory %s: %w", dir, err)
+	}
+	return &Root{dir: dir}, nil
+}
+
+// Dir returns the root directory.
+func (r *Root) Dir() string {
+	return r.dir
+}
+
+// New creates a workspace of its own for a job. Jobs running in parallel, even with the
+// same name, never share a workspace. The caller removes it with Close.
+func (r *Root) New(job string) (*Workspace, error) {
+	dir, err := os.MkdirTemp(r.dir, prefix+strings.ReplaceAll(job, string(filepath.Separator), "_")+"-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workspace for %s: %w", job, err)
+	}
+	return &Workspace{dir: dir}, nil
+}
+
+// RemoveStale removes the workspaces older than maxAge, such as those left behind by a
+// process that crashed, and returns how many were removed.
+func (r *Root) RemoveStale(maxAge time.Duration) (int, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list workspace directory %s: %w", r.dir, err)
+	}
+
+	removed := 0
+	cutoff := time.Now().Add(-maxAge)
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(r.dir, entry.Name())); err != nil {
+			return removed, fmt.Errorf("failed to remove stale workspace %s: %w", entry.Name(), err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Workspace is a directory for the temporary artifacts of one provisioning job.
+type Workspace struct {
+	dir string
+}
+
+// Dir returns the workspace directory.
+func (w *Workspace) Dir() string {
+	return w.dir
+}
+
+// Path returns the path of a file in the workspace.
+func (w *Workspace) Path(name string) string {
+	return filepath.Join(w.dir, name)
+}
+
+// Close removes the workspace and everything in it.
+func (w *Workspace) Close() error {
+	return os.RemoveAll(w.dir)
+}
@@ -13,4 +13,8 @@ ethernets:
     {{- end }}
     dhcp6: false
     nameservers:
+      {{- if .Nameservers }}
+      addresses: [{{ range $i, $nameserver := .Nameservers }}{{ if $i }}, {{ end }}{{ $nameserver }}{{ end }}]
+      {{- else }}
       addresses: [1.1.1.1, 8.8.8.8]
+      {{- end }}
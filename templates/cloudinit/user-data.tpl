@@ -20,6 +20,42 @@ users:
     {{- end}}
   {{- end }}
 
+{{- with .Proxy }}
+
+apt:
+  {{- if .HTTPProxy }}
+  http_proxy: "{{ .HTTPProxy }}"
+  {{- end }}
+  {{- if .HTTPSProxy }}
+  https_proxy: "{{ .HTTPSProxy }}"
+  {{- end }}
+
+write_files:
+  - path: /etc/environment
+    append: true
+    content: |
+      {{- if .HTTPProxy }}
+      HTTP_PROXY={{ .HTTPProxy }}
+      http_proxy={{ .HTTPProxy }}
+      {{- end }}
+      {{- if .HTTPSProxy }}
+      HTTPS_PROXY={{ .HTTPSProxy }}
+      https_proxy={{ .HTTPSProxy }}
+      {{- end }}
+      {{- if .NoProxy }}
+      NO_PROXY={{ .NoProxy }}
+      no_proxy={{ .NoProxy }}
+      {{- end }}
+  # Default environment of every systemd service, including containerd and k3s.
+  - path: /etc/systemd/system.conf.d/90-proxy.conf
+    content: |
+      [Manager]
+      DefaultEnvironment=
+      {{- if .HTTPProxy }} "HTTP_PROXY={{ .HTTPProxy }}" "http_proxy={{ .HTTPProxy }}"{{ end }}
+      {{- if .HTTPSProxy }} "HTTPS_PROXY={{ .HTTPSProxy }}" "https_proxy={{ .HTTPSProxy }}"{{ end }}
+      {{- if .NoProxy }} "NO_PROXY={{ .NoProxy }}" "no_proxy={{ .NoProxy }}"{{ end }}
+{{- end }}
+
 {{- if .DoPackageUpdate }}
 package_update: true
 {{- end }}
@@ -27,7 +63,18 @@ package_update: true
 package_upgrade: true
 {{- end }}
 
+{{- if .Mounts }}
+
+mounts:
+  {{- range .Mounts }}
+  - [ {{ .Tag }}, "{{ .Path }}", {{ .FSType }}, "{{ .Options }}", "0", "0" ]
+  {{- end }}
+{{- end }}
+
 runcmd:
+  {{- if .Proxy }}
+  - systemctl daemon-reexec
+  {{- end }}
   {{- range .Runcmds }}
   - {{ . }}
   {{- end }}
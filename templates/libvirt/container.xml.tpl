@@ -0,0 +1,67 @@
+<domain type='lxc'>
+    <!-- Container Identity -->
+    <name>{{ .Name }}</name>
+    {{- if .UUID }}
+    <uuid>{{ .UUID }}</uuid>
+    {{- end }}
+    {{- if .Metadata }}
+    <metadata>
+        {{ .Metadata }}
+    </metadata>
+    {{- end }}
+
+    <!-- Resources (enforced through cgroups) -->
+    <memory unit='KiB'>{{ .MemoryKiB }}</memory>
+    <currentMemory unit='KiB'>{{ .MemoryKiB }}</currentMemory>
+    <vcpu placement='static'>{{ .VCPUCount }}</vcpu>
+
+    <!-- Boot the init system of the root filesystem, so cloud-init runs as in a VM -->
+    <os>
+        <type arch='x86_64'>exe</type>
+        <init>/sbin/init</init>
+    </os>
+
+    <clock offset='utc' />
+    {{- with .Lifecycle }}
+    {{- if .OnPoweroff }}
+    <on_poweroff>{{ .OnPoweroff }}</on_poweroff>
+    {{- end }}
+    {{- if .OnReboot }}
+    <on_reboot>{{ .OnReboot }}</on_reboot>
+    {{- end }}
+    {{- if .OnCrash }}
+    <on_crash>{{ .OnCrash }}</on_crash>
+    {{- end }}
+    {{- end }}
+
+    <!-- Devices -->
+    <devices>
+        <!-- Root filesystem unpacked from the base image -->
+        <filesystem type='mount' accessmode='passthrough'>
+            <source dir='{{ .RootfsPath }}' />
+            <target dir='/' />
+        </filesystem>
+
+        {{- range .HostBindMounts }}
+        <filesystem type='mount' accessmode='passthrough'>
+            <source dir='{{ .SourceDir }}' />
+            <target dir='{{ .TargetDir }}' />
+            {{- if .ReadOnly }}
+            <readonly />
+            {{- end }}
+        </filesystem>
+        {{- end }}
+
+        <!-- Network Interface -->
+        {{- if .BridgeNetworkInterface }}
+        <interface type='bridge'>
+            {{- if .MACAddress }}
+            <mac address='{{ .MACAddress }}' />
+            {{- end }}
+            <source bridge='{{ .BridgeNetworkInterface }}' />
+        </interface>
+        {{- end }}
+
+        <console type='pty' />
+    </devices>
+</domain>
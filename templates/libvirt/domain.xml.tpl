@@ -4,12 +4,20 @@
     {{- if .UUID }}
     <uuid>{{ .UUID }}</uuid>
     {{- end }}
+    {{- if .Metadata }}
+    <metadata>
+        {{ .Metadata }}
+    </metadata>
+    {{- end }}
 
     <!-- Resources -->
     <memory unit='KiB'>{{ .MemoryKiB }}</memory>
     <currentMemory unit='KiB'>{{ .MemoryKiB }}</currentMemory>
     <vcpu placement='static'>{{ .VCPUCount }}</vcpu>
-    {{- if or .VCPUPins .EmulatorCPUSet }}
+    {{- if .IOThreads }}
+    <iothreads>{{ .IOThreads }}</iothreads>
+    {{- end }}
+    {{- if or .VCPUPins .EmulatorCPUSet .IOThreadPins }}
     <cputune>
         {{- range .VCPUPins }}
         <vcpupin vcpu='{{ .VCPU }}' cpuset='{{ .CPUSet }}'/>
@@ -17,6 +25,9 @@
         {{- if .EmulatorCPUSet }}
         <emulatorpin cpuset='{{ .EmulatorCPUSet }}'/>
         {{- end }}
+        {{- range .IOThreadPins }}
+        <iothreadpin iothread='{{ .IOThread }}' cpuset='{{ .CPUSet }}'/>
+        {{- end }}
     </cputune>
     {{- end }}
     {{- if .NUMAMemory }}
@@ -24,10 +35,37 @@
         <memory mode='{{ .NUMAMemory.Mode }}' nodeset='{{ .NUMAMemory.Nodeset }}'/>
     </numatune>
     {{- end }}
+    {{- if or .Hugepages .SharedMemory }}
+    <memoryBacking>
+        {{- if .Hugepages }}
+        <hugepages>
+            <page size='{{ .Hugepages.PageSizeKiB }}' unit='KiB'{{ if .Hugepages.Nodeset }} nodeset='{{ .Hugepages.Nodeset }}'{{ end }}/>
+        </hugepages>
+        {{- else }}
+        <source type='memfd' />
+        {{- end }}
+        {{- if .SharedMemory }}
+        <!-- virtiofs requires guest memory shared with virtiofsd -->
+        <access mode='shared' />
+        {{- end }}
+    </memoryBacking>
+    {{- end }}
 
     <!-- OS and Boot Configuration -->
-    <os>
-        <type arch='x86_64'>hvm</type>
+    <os{{ if eq .Firmware "efi" }} firmware='efi'{{ end }}>
+        <type arch='x86_64'{{ if .MachineType }} machine='{{ .MachineType }}'{{ end }}>hvm</type>
+        {{- if eq .Firmware "efi" }}
+        <firmware>
+            <feature enabled='{{ if .SecureBoot }}yes{{ else }}no{{ end }}' name='secure-boot' />
+            <feature enabled='{{ if .SecureBoot }}yes{{ else }}no{{ end }}' name='enrolled-keys' />
+        </firmware>
+        {{- if .NVRAMPath }}
+        <nvram>{{ .NVRAMPath }}</nvram>
+        {{- end }}
+        {{- end }}
+        {{- if .Netboot }}
+        <boot dev='network' />
+        {{- end }}
         <boot dev='hd' />
         <boot dev='cdrom' />
     </os>
@@ -37,6 +75,9 @@
         <acpi />
         <apic />
         <vmport state='off' />
+        {{- if .SecureBoot }}
+        <smm state='on' />
+        {{- end }}
     </features>
     <cpu mode='host-passthrough' check='none' migratable='on' />
     <clock offset='utc'>
@@ -44,13 +85,24 @@
         <timer name='pit' tickpolicy='delay' />
         <timer name='hpet' present='no' />
     </clock>
+    {{- with .Lifecycle }}
+    {{- if .OnPoweroff }}
+    <on_poweroff>{{ .OnPoweroff }}</on_poweroff>
+    {{- end }}
+    {{- if .OnReboot }}
+    <on_reboot>{{ .OnReboot }}</on_reboot>
+    {{- end }}
+    {{- if .OnCrash }}
+    <on_crash>{{ .OnCrash }}</on_crash>
+    {{- end }}
+    {{- end }}
 
     <!-- Devices -->
     <devices>
         <!-- Main OS Disk (VirtIO for high performance) -->
         {{- if .DiskPath }}
         <disk type='file' device='disk'>
-            <driver name='qemu' type='qcow2' cache='none' io='native' />
+            <driver name='qemu' type='qcow2' cache='none' io='native'{{ if .IOThreads }} iothread='1'{{ end }} />
             <source file='{{ .DiskPath }}' />
             <target dev='vdb' bus='virtio' />
             {{/* <boot order='1'/> */}}
@@ -68,28 +120,51 @@
         </disk>
         {{- end }}
 
-        {{- if .HostBindMounts }}
-            {{- range .HostBindMounts }}
+        {{- range .HostBindMounts }}
+        {{- if eq .Driver "9p" }}
+        <filesystem type='mount' accessmode='mapped'>
+            <source dir='{{ .SourceDir }}'/>
+            <target dir='{{ .Tag }}'/>
+            {{- if .ReadOnly }}
+            <readonly/>
+            {{- end }}
+        </filesystem>
+        {{- else }}
         <filesystem type='mount' accessmode='passthrough'>
             <driver type='virtiofs'/>
+            {{- if $.VirtiofsdPath }}
+            <binary path='{{ $.VirtiofsdPath }}'/>
+            {{- end }}
             <source dir='{{ .SourceDir }}'/>
-            <target dir='{{ .TargetDir }}'/>
+            <target dir='{{ .Tag }}'/>
         </filesystem>
-            {{- end }}
+        {{- end }}
         {{- end }}
 
         <!-- Network Interface (VirtIO bridge for high performance) -->
         {{- if .BridgeNetworkInterface }}
         <interface type='bridge'>
+            {{- if .MACAddress }}
+            <mac address='{{ .MACAddress }}' />
+            {{- end }}
             <source bridge='{{ .BridgeNetworkInterface }}' />
             <model type='virtio' />
         </interface>
         {{- end }}
 
 
-        <graphics type='vnc' port='5901' autoport='yes' listen='0.0.0.0'>
-            <listen type='address' address='0.0.0.0'/>
+        {{- with .Graphics }}
+        {{- if ne .Type "none" }}
+        <graphics type='{{ .Type }}' {{ if .Port }}port='{{ .Port }}' autoport='no'{{ else }}autoport='yes'{{ end }} listen='{{ .Listen }}'{{ if .Password }} passwd='{{ .Password | html }}'{{ end }}>
+            <listen type='address' address='{{ .Listen }}'/>
         </graphics>
+        {{- if eq .Type "spice" }}
+        <channel type='spicevmc'>
+            <target type='virtio' name='com.redhat.spice.0'/>
+        </channel>
+        {{- end }}
+        {{- end }}
+        {{- end }}
 
         <!-- Essential for headless management -->
         <serial type='pty'>
@@ -106,6 +181,25 @@
         <channel type='unix'>
             <target type='virtio' name='org.qemu.guest_agent.0'/>
         </channel>
+        {{- range .HostDevices }}
+
+        <!-- PCI passthrough -->
+        <hostdev mode='subsystem' type='pci' managed='yes'>
+            <source>
+                <address domain='{{ .Domain }}' bus='{{ .Bus }}' slot='{{ .Slot }}' function='{{ .Function }}' />
+            </source>
+        </hostdev>
+        {{- end }}
+        {{- range .ExtraDevices }}
+        {{ . }}
+        {{- end }}
+        {{- if .TPM }}
+
+        <!-- Emulated TPM 2.0 (swtpm) -->
+        <tpm model='tpm-crb'>
+            <backend type='emulator' version='2.0' />
+        </tpm>
+        {{- end }}
 
     </devices>
 </domain>